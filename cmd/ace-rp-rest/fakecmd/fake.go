@@ -0,0 +1,143 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package fakecmd
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-core/pkg/log"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+
+	"github.com/trustbloc/sandbox/cmd/common"
+	"github.com/trustbloc/sandbox/pkg/acefake"
+	"github.com/trustbloc/sandbox/pkg/restapi/healthcheck"
+)
+
+const (
+	hostURLFlagName      = "host-url"
+	hostURLFlagShorthand = "u"
+	hostURLFlagUsage     = "URL to run the services on. Format: HostName:Port."
+	hostURLEnvKey        = "ACE_FAKE_HOST_URL"
+
+	tlsCertFileFlagName  = "tls-cert-file"
+	tlsCertFileFlagUsage = "tls certificate file." +
+		" Alternatively, this can be set with the following environment variable: " + tlsCertFileEnvKey
+	tlsCertFileEnvKey = "ACE_FAKE_TLS_CERT_FILE"
+
+	tlsKeyFileFlagName  = "tls-key-file"
+	tlsKeyFileFlagUsage = "tls key file." +
+		" Alternatively, this can be set with the following environment variable: " + tlsKeyFileEnvKey
+	tlsKeyFileEnvKey = "ACE_FAKE_TLS_KEY_FILE"
+)
+
+var logger = log.New("ace-fake-services")
+
+type server interface {
+	ListenAndServe(host, certFile, keyFile string, router http.Handler) error
+}
+
+type fakeParameters struct {
+	srv         server
+	hostURL     string
+	tlsCertFile string
+	tlsKeyFile  string
+	logLevel    string
+	dbParams    *common.DBParameters
+}
+
+// GetFakeServicesCmd returns the Cobra command which serves the local stand-ins of the vault server and the
+// comparator. The ACE RPs use them with the vault-server-url and the comparator-url of the command.
+func GetFakeServicesCmd(srv server) *cobra.Command {
+	cmd := createFakeServicesCmd(srv)
+
+	createFlags(cmd)
+
+	return cmd
+}
+
+func createFakeServicesCmd(srv server) *cobra.Command {
+	return &cobra.Command{
+		Use:   "fake-services",
+		Short: "Start the local vault server and comparator",
+		Long:  "Start the local stand-ins of the vault server and the comparator, for running the ACE demo on one machine",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			hostURL, err := cmdutils.GetUserSetVarFromString(cmd, hostURLFlagName, hostURLEnvKey, false)
+			if err != nil {
+				return err
+			}
+
+			tlsCertFile, err := cmdutils.GetUserSetVarFromString(cmd, tlsCertFileFlagName, tlsCertFileEnvKey, true)
+			if err != nil {
+				return err
+			}
+
+			tlsKeyFile, err := cmdutils.GetUserSetVarFromString(cmd, tlsKeyFileFlagName, tlsKeyFileEnvKey, true)
+			if err != nil {
+				return err
+			}
+
+			loggingLevel, err := cmdutils.GetUserSetVarFromString(cmd, common.LogLevelFlagName, common.LogLevelEnvKey, true)
+			if err != nil {
+				return err
+			}
+
+			dbParams, err := common.DBParams(cmd)
+			if err != nil {
+				return err
+			}
+
+			return startFakeServices(&fakeParameters{
+				srv:         srv,
+				hostURL:     strings.TrimSpace(hostURL),
+				tlsCertFile: tlsCertFile,
+				tlsKeyFile:  tlsKeyFile,
+				logLevel:    loggingLevel,
+				dbParams:    dbParams,
+			})
+		},
+	}
+}
+
+func createFlags(cmd *cobra.Command) {
+	common.Flags(cmd)
+	cmd.Flags().StringP(hostURLFlagName, hostURLFlagShorthand, "", hostURLFlagUsage)
+	cmd.Flags().StringP(tlsCertFileFlagName, "", "", tlsCertFileFlagUsage)
+	cmd.Flags().StringP(tlsKeyFileFlagName, "", "", tlsKeyFileFlagUsage)
+	cmd.Flags().StringP(common.LogLevelFlagName, common.LogLevelFlagShorthand, "", common.LogLevelPrefixFlagUsage)
+}
+
+func startFakeServices(parameters *fakeParameters) error {
+	if parameters.logLevel != "" {
+		common.SetDefaultLogLevel(logger, parameters.logLevel)
+	}
+
+	storeProvider, err := common.InitEdgeStore(parameters.dbParams, logger)
+	if err != nil {
+		return err
+	}
+
+	services, err := acefake.New(storeProvider)
+	if err != nil {
+		return fmt.Errorf("create local services : %w", err)
+	}
+
+	router := mux.NewRouter()
+
+	for _, handler := range services.GetRESTHandlers() {
+		router.HandleFunc(handler.Path(), handler.Handle()).Methods(handler.Method())
+	}
+
+	for _, handler := range healthcheck.New().GetOperations() {
+		router.HandleFunc(handler.Path(), handler.Handle()).Methods(handler.Method())
+	}
+
+	return parameters.srv.ListenAndServe(parameters.hostURL, parameters.tlsCertFile, parameters.tlsKeyFile, router)
+}
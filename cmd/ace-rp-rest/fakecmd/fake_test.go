@@ -0,0 +1,81 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package fakecmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/sandbox/cmd/common"
+)
+
+const flag = "--"
+
+type mockServer struct {
+	router http.Handler
+}
+
+func (s *mockServer) ListenAndServe(host, certFile, keyFile string, router http.Handler) error {
+	s.router = router
+
+	return nil
+}
+
+func TestFakeServicesCmd(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		srv := &mockServer{}
+
+		cmd := GetFakeServicesCmd(srv)
+		cmd.SetArgs([]string{
+			flag + hostURLFlagName, "localhost:8080",
+			flag + common.DatabaseURLFlagName, "mem://test",
+			flag + common.DatabasePrefixFlagName, "test",
+		})
+
+		err := cmd.Execute()
+		require.NoError(t, err)
+		require.NotNil(t, srv.router)
+
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/config", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("missing host url", func(t *testing.T) {
+		cmd := GetFakeServicesCmd(&mockServer{})
+		cmd.SetArgs([]string{})
+
+		err := cmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(),
+			"Neither host-url (command line flag) nor ACE_FAKE_HOST_URL (environment variable) have been set.")
+	})
+
+	t.Run("missing database url", func(t *testing.T) {
+		cmd := GetFakeServicesCmd(&mockServer{})
+		cmd.SetArgs([]string{flag + hostURLFlagName, "localhost:8080"})
+
+		err := cmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "database-url")
+	})
+
+	t.Run("invalid database driver", func(t *testing.T) {
+		cmd := GetFakeServicesCmd(&mockServer{})
+		cmd.SetArgs([]string{
+			flag + hostURLFlagName, "localhost:8080",
+			flag + common.DatabaseURLFlagName, "invalid-driver://test",
+			flag + common.DatabasePrefixFlagName, "test",
+		})
+
+		err := cmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unsupported storage driver: invalid-driver")
+	})
+}
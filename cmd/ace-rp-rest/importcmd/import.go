@@ -0,0 +1,342 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package importcmd
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+	tlsutils "github.com/trustbloc/edge-core/pkg/utils/tls"
+)
+
+const (
+	urlFlagName  = "url"
+	urlFlagUsage = "URL of the ACE RP." +
+		" Alternatively, this can be set with the following environment variable: " + urlEnvKey
+	urlEnvKey = "ACE_IMPORT_URL"
+
+	fileFlagName      = "file"
+	fileFlagShorthand = "f"
+	fileFlagUsage     = "CSV or JSON file of the users to import. The CSV file has a header of the username, the" +
+		" password and the document type columns. Alternatively, this can be set with the following environment" +
+		" variable: " + fileEnvKey
+	fileEnvKey = "ACE_IMPORT_FILE"
+
+	apiKeyFlagName  = "api-key"
+	apiKeyFlagUsage = "Admin API key of an operator of the ACE RP." +
+		" Alternatively, this can be set with the following environment variable: " + apiKeyEnvKey
+	apiKeyEnvKey = "ACE_IMPORT_API_KEY"
+
+	tlsSystemCertPoolFlagName  = "tls-systemcertpool"
+	tlsSystemCertPoolFlagUsage = "Use system certificate pool." +
+		" Possible values [true] [false]. Defaults to false if not set." +
+		" Alternatively, this can be set with the following environment variable: " + tlsSystemCertPoolEnvKey
+	tlsSystemCertPoolEnvKey = "ACE_IMPORT_TLS_SYSTEMCERTPOOL"
+
+	tlsCACertsFlagName  = "tls-cacerts"
+	tlsCACertsFlagUsage = "Comma-Separated list of ca certs path." +
+		" Alternatively, this can be set with the following environment variable: " + tlsCACertsEnvKey
+	tlsCACertsEnvKey = "ACE_IMPORT_TLS_CACERTS"
+
+	timeoutFlagName  = "timeout"
+	timeoutFlagUsage = "Time to wait for the import to finish, for example 30m. Defaults to 30m if not set." +
+		" Alternatively, this can be set with the following environment variable: " + timeoutEnvKey
+	timeoutEnvKey = "ACE_IMPORT_TIMEOUT"
+
+	userImportPath = "/users/import"
+
+	requestTimeout = time.Minute
+	defaultTimeout = 30 * time.Minute
+
+	// import job status
+	importJobCompleted = "completed"
+	importJobFailed    = "failed"
+)
+
+// pollInterval is the time between the polls of the import job. The import of a large file takes a while, as the
+// users are imported at the rate configured in the ACE RP.
+var pollInterval = 2 * time.Second // nolint: gochecknoglobals
+
+type importParameters struct {
+	url       string
+	file      string
+	apiKey    string
+	timeout   time.Duration
+	tlsConfig *tls.Config
+	out       io.Writer
+}
+
+type importResult struct {
+	Row      int    `json:"row"`
+	UserName string `json:"username"`
+	Status   string `json:"status"`
+	UserID   string `json:"userID,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type importJob struct {
+	ID        string         `json:"id"`
+	Status    string         `json:"status"`
+	Error     string         `json:"error,omitempty"`
+	Total     int            `json:"total"`
+	Processed int            `json:"processed"`
+	Created   int            `json:"created"`
+	Existing  int            `json:"existing"`
+	Failed    int            `json:"failed"`
+	Results   []importResult `json:"results"`
+}
+
+// GetImportUsersCmd returns the Cobra command which imports the users of a CSV or JSON file into an ACE RP. The
+// users which already exist are skipped, so that the demo environments can be seeded again with the same file. The
+// existing users who have no password yet get the password of the file.
+func GetImportUsersCmd() *cobra.Command {
+	cmd := createImportUsersCmd()
+
+	createFlags(cmd)
+
+	return cmd
+}
+
+func createImportUsersCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "import-users",
+		Short: "Import users into an ACE RP",
+		Long:  "Register the users of a CSV or JSON file at an ACE RP, and print the status of each user",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			url, err := cmdutils.GetUserSetVarFromString(cmd, urlFlagName, urlEnvKey, false)
+			if err != nil {
+				return err
+			}
+
+			file, err := cmdutils.GetUserSetVarFromString(cmd, fileFlagName, fileEnvKey, false)
+			if err != nil {
+				return err
+			}
+
+			apiKey, err := cmdutils.GetUserSetVarFromString(cmd, apiKeyFlagName, apiKeyEnvKey, false)
+			if err != nil {
+				return err
+			}
+
+			timeout, err := getTimeout(cmd)
+			if err != nil {
+				return err
+			}
+
+			tlsConfig, err := getTLSConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			return importUsers(&importParameters{
+				url:       strings.TrimSuffix(strings.TrimSpace(url), "/"),
+				file:      file,
+				apiKey:    apiKey,
+				timeout:   timeout,
+				tlsConfig: tlsConfig,
+				out:       cmd.OutOrStdout(),
+			})
+		},
+	}
+}
+
+func createFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(urlFlagName, "", "", urlFlagUsage)
+	cmd.Flags().StringP(fileFlagName, fileFlagShorthand, "", fileFlagUsage)
+	cmd.Flags().StringP(apiKeyFlagName, "", "", apiKeyFlagUsage)
+	cmd.Flags().StringP(timeoutFlagName, "", "", timeoutFlagUsage)
+	cmd.Flags().StringP(tlsSystemCertPoolFlagName, "", "", tlsSystemCertPoolFlagUsage)
+	cmd.Flags().StringArrayP(tlsCACertsFlagName, "", []string{}, tlsCACertsFlagUsage)
+}
+
+func getTimeout(cmd *cobra.Command) (time.Duration, error) {
+	timeoutString, err := cmdutils.GetUserSetVarFromString(cmd, timeoutFlagName, timeoutEnvKey, true)
+	if err != nil {
+		return 0, err
+	}
+
+	if timeoutString == "" {
+		return defaultTimeout, nil
+	}
+
+	timeout, err := time.ParseDuration(timeoutString)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid value for %s : %s", timeoutFlagName, timeoutString)
+	}
+
+	return timeout, nil
+}
+
+func getTLSConfig(cmd *cobra.Command) (*tls.Config, error) {
+	tlsSystemCertPoolString, err := cmdutils.GetUserSetVarFromString(cmd, tlsSystemCertPoolFlagName,
+		tlsSystemCertPoolEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsSystemCertPool := false
+	if tlsSystemCertPoolString != "" {
+		tlsSystemCertPool, err = strconv.ParseBool(tlsSystemCertPoolString)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tlsCACerts, err := cmdutils.GetUserSetVarFromArrayString(cmd, tlsCACertsFlagName, tlsCACertsEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	rootCAs, err := tlsutils.GetCertPool(tlsSystemCertPool, tlsCACerts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{RootCAs: rootCAs, MinVersion: tls.VersionTLS12}, nil
+}
+
+func importUsers(parameters *importParameters) error {
+	body, err := ioutil.ReadFile(filepath.Clean(parameters.file))
+	if err != nil {
+		return fmt.Errorf("read users file : %w", err)
+	}
+
+	contentType := "application/json"
+	if strings.EqualFold(filepath.Ext(parameters.file), ".csv") {
+		contentType = "text/csv"
+	}
+
+	httpClient := &http.Client{
+		Timeout:   requestTimeout,
+		Transport: &http.Transport{TLSClientConfig: parameters.tlsConfig},
+	}
+
+	job := &importJob{}
+
+	err = sendRequest(httpClient, parameters, http.MethodPost, userImportPath, contentType, bytes.NewReader(body),
+		http.StatusAccepted, job)
+	if err != nil {
+		return fmt.Errorf("import users : %w", err)
+	}
+
+	job, err = waitForImportJob(httpClient, parameters, job)
+	if err != nil {
+		return err
+	}
+
+	if job.Error != "" {
+		return fmt.Errorf("import job %s failed : %s", job.ID, job.Error)
+	}
+
+	err = printResults(parameters.out, job)
+	if err != nil {
+		return err
+	}
+
+	if job.Failed > 0 {
+		return fmt.Errorf("%d of %d users failed to import", job.Failed, job.Total)
+	}
+
+	return nil
+}
+
+// waitForImportJob polls the import job until all the users are imported or the timeout expires, and prints the
+// progress.
+func waitForImportJob(httpClient *http.Client, parameters *importParameters, job *importJob) (*importJob, error) {
+	processed := -1
+	deadline := time.Now().Add(parameters.timeout)
+
+	for job.Status != importJobCompleted && job.Status != importJobFailed {
+		if job.Processed != processed {
+			processed = job.Processed
+
+			fmt.Fprintf(parameters.out, "imported %d of %d users\n", job.Processed, job.Total) // nolint: errcheck
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("import job %s not finished after %s, %d of %d users imported",
+				job.ID, parameters.timeout, job.Processed, job.Total)
+		}
+
+		time.Sleep(pollInterval)
+
+		id := job.ID
+		job = &importJob{}
+
+		err := sendRequest(httpClient, parameters, http.MethodGet, userImportPath+"/"+id, "", nil,
+			http.StatusOK, job)
+		if err != nil {
+			return nil, fmt.Errorf("get import job %s : %w", id, err)
+		}
+	}
+
+	return job, nil
+}
+
+func sendRequest(httpClient *http.Client, parameters *importParameters, method, path, contentType string,
+	body io.Reader, status int, result interface{}) error {
+	req, err := http.NewRequest(method, parameters.url+path, body)
+	if err != nil {
+		return fmt.Errorf("create request : %w", err)
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+parameters.apiKey)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close() // nolint: errcheck
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read response : %w", err)
+	}
+
+	if resp.StatusCode != status {
+		return fmt.Errorf("status=%d body=%s", resp.StatusCode, string(respBody))
+	}
+
+	err = json.Unmarshal(respBody, result)
+	if err != nil {
+		return fmt.Errorf("unmarshal response : %w", err)
+	}
+
+	return nil
+}
+
+func printResults(out io.Writer, result *importJob) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "ROW\tUSERNAME\tSTATUS\tUSER ID\tERROR") // nolint: errcheck
+
+	for _, r := range result.Results {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", r.Row, r.UserName, r.Status, r.UserID, r.Error) // nolint: errcheck
+	}
+
+	fmt.Fprintf(w, "\ntotal=%d created=%d existing=%d failed=%d\n", // nolint: errcheck
+		result.Total, result.Created, result.Existing, result.Failed)
+
+	return w.Flush()
+}
@@ -0,0 +1,308 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package importcmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const flag = "--"
+
+func TestMain(m *testing.M) {
+	pollInterval = time.Millisecond
+
+	os.Exit(m.Run())
+}
+
+func TestImportUsersCmd(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		var contentType, auth string
+
+		srv := newImportServer(t, func(w http.ResponseWriter, r *http.Request) {
+			contentType = r.Header.Get("Content-Type")
+			auth = r.Header.Get("Authorization")
+
+			body, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+			require.Equal(t, "username,password,nationalID\n", string(body))
+
+			writeImportJob(t, w, http.StatusAccepted, &importJob{ID: "job1", Status: "pending", Total: 2})
+		},
+			&importJob{ID: "job1", Status: "running", Total: 2, Processed: 1, Created: 1},
+			&importJob{ID: "job1", Status: "completed", Total: 2, Processed: 2, Created: 1, Existing: 1,
+				Results: []importResult{
+					{Row: 1, UserName: "user1@example.com", Status: "created", UserID: "U1"},
+					{Row: 2, UserName: "user2@example.com", Status: "exists", UserID: "U2"},
+				}},
+		)
+
+		out := &bytes.Buffer{}
+
+		cmd := GetImportUsersCmd()
+		cmd.SetOut(out)
+		cmd.SetArgs([]string{
+			flag + urlFlagName, srv.URL + "/",
+			flag + fileFlagName, writeFile(t, "users.csv", "username,password,nationalID\n"),
+			flag + apiKeyFlagName, "operator-key",
+		})
+
+		require.NoError(t, cmd.Execute())
+		require.Equal(t, "text/csv", contentType)
+		require.Equal(t, "Bearer operator-key", auth)
+		require.Contains(t, out.String(), "imported 0 of 2 users")
+		require.Contains(t, out.String(), "imported 1 of 2 users")
+		require.Contains(t, out.String(), "user1@example.com")
+		require.Contains(t, out.String(), "total=2 created=1 existing=1 failed=0")
+	})
+
+	t.Run("failed rows", func(t *testing.T) {
+		var contentType string
+
+		srv := newImportServer(t, func(w http.ResponseWriter, r *http.Request) {
+			contentType = r.Header.Get("Content-Type")
+
+			writeImportJob(t, w, http.StatusAccepted, &importJob{ID: "job1", Status: "pending", Total: 1})
+		},
+			&importJob{ID: "job1", Status: "failed", Total: 1, Processed: 1, Failed: 1, Results: []importResult{
+				{Row: 1, UserName: "user1@example.com", Status: "failed", Error: "missing document"},
+			}},
+		)
+
+		out := &bytes.Buffer{}
+
+		cmd := GetImportUsersCmd()
+		cmd.SetOut(out)
+		cmd.SetArgs([]string{
+			flag + urlFlagName, srv.URL,
+			flag + fileFlagName, writeFile(t, "users.json", `{"users":[]}`),
+			flag + apiKeyFlagName, "operator-key",
+		})
+
+		err := cmd.Execute()
+		require.EqualError(t, err, "1 of 1 users failed to import")
+		require.Equal(t, "application/json", contentType)
+		require.Contains(t, out.String(), "missing document")
+	})
+
+	t.Run("error response", func(t *testing.T) {
+		srv := newImportServer(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		})
+
+		cmd := GetImportUsersCmd()
+		cmd.SetArgs([]string{
+			flag + urlFlagName, srv.URL,
+			flag + fileFlagName, writeFile(t, "users.csv", ""),
+			flag + apiKeyFlagName, "auditor-key",
+		})
+
+		err := cmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "status=403")
+	})
+
+	t.Run("interrupted job", func(t *testing.T) {
+		srv := newImportServer(t, func(w http.ResponseWriter, r *http.Request) {
+			writeImportJob(t, w, http.StatusAccepted, &importJob{ID: "job1", Status: "running", Total: 1})
+		},
+			&importJob{ID: "job1", Status: "failed", Total: 1, Error: "import interrupted by a restart"},
+		)
+
+		cmd := GetImportUsersCmd()
+		cmd.SetOut(&bytes.Buffer{})
+		cmd.SetArgs([]string{
+			flag + urlFlagName, srv.URL,
+			flag + fileFlagName, writeFile(t, "users.csv", ""),
+			flag + apiKeyFlagName, "operator-key",
+		})
+
+		err := cmd.Execute()
+		require.EqualError(t, err, "import job job1 failed : import interrupted by a restart")
+	})
+
+	t.Run("job not found", func(t *testing.T) {
+		srv := newImportServer(t, func(w http.ResponseWriter, r *http.Request) {
+			writeImportJob(t, w, http.StatusAccepted, &importJob{ID: "job1", Status: "running", Total: 1})
+		})
+
+		cmd := GetImportUsersCmd()
+		cmd.SetOut(&bytes.Buffer{})
+		cmd.SetArgs([]string{
+			flag + urlFlagName, srv.URL,
+			flag + fileFlagName, writeFile(t, "users.csv", ""),
+			flag + apiKeyFlagName, "operator-key",
+		})
+
+		err := cmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "get import job job1 : status=404")
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		running := &importJob{ID: "job1", Status: "running", Total: 2, Processed: 1}
+
+		srv := newImportServer(t, func(w http.ResponseWriter, r *http.Request) {
+			writeImportJob(t, w, http.StatusAccepted, &importJob{ID: "job1", Status: "pending", Total: 2})
+		},
+			running, running, running, running, running, running, running, running, running, running,
+		)
+
+		cmd := GetImportUsersCmd()
+		cmd.SetOut(&bytes.Buffer{})
+		cmd.SetArgs([]string{
+			flag + urlFlagName, srv.URL,
+			flag + fileFlagName, writeFile(t, "users.csv", ""),
+			flag + apiKeyFlagName, "operator-key",
+			flag + timeoutFlagName, "5ms",
+		})
+
+		err := cmd.Execute()
+		require.EqualError(t, err, "import job job1 not finished after 5ms, 1 of 2 users imported")
+	})
+
+	t.Run("invalid timeout", func(t *testing.T) {
+		for _, timeout := range []string{"invalid", "-1m"} {
+			cmd := GetImportUsersCmd()
+			cmd.SetArgs([]string{
+				flag + urlFlagName, "https://localhost",
+				flag + fileFlagName, "users.csv",
+				flag + apiKeyFlagName, "operator-key",
+				flag + timeoutFlagName, timeout,
+			})
+
+			err := cmd.Execute()
+			require.EqualError(t, err, "invalid value for timeout : "+timeout)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		cmd := GetImportUsersCmd()
+		cmd.SetArgs([]string{
+			flag + urlFlagName, "https://localhost",
+			flag + fileFlagName, filepath.Join(t.TempDir(), "missing.csv"),
+			flag + apiKeyFlagName, "operator-key",
+		})
+
+		err := cmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "read users file")
+	})
+
+	t.Run("missing args", func(t *testing.T) {
+		for _, args := range [][]string{
+			{},
+			{flag + urlFlagName, "https://localhost"},
+			{flag + urlFlagName, "https://localhost", flag + fileFlagName, "users.csv"},
+		} {
+			cmd := GetImportUsersCmd()
+			cmd.SetArgs(args)
+
+			err := cmd.Execute()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "Neither")
+		}
+	})
+
+	t.Run("invalid tls args", func(t *testing.T) {
+		args := []string{
+			flag + urlFlagName, "https://localhost",
+			flag + fileFlagName, "users.csv",
+			flag + apiKeyFlagName, "operator-key",
+		}
+
+		cmd := GetImportUsersCmd()
+		cmd.SetArgs(append(args, flag+tlsSystemCertPoolFlagName, "invalid"))
+		require.Error(t, cmd.Execute())
+
+		cmd = GetImportUsersCmd()
+		cmd.SetArgs(append(args, flag+tlsCACertsFlagName, "invalid.pem"))
+		require.Error(t, cmd.Execute())
+	})
+
+	t.Run("env vars", func(t *testing.T) {
+		srv := newImportServer(t, func(w http.ResponseWriter, r *http.Request) {
+			writeImportJob(t, w, http.StatusAccepted, &importJob{ID: "job1", Status: "completed"})
+		})
+
+		for k, v := range map[string]string{
+			urlEnvKey:               srv.URL,
+			fileEnvKey:              writeFile(t, "users.csv", ""),
+			apiKeyEnvKey:            "operator-key",
+			tlsSystemCertPoolEnvKey: "true",
+		} {
+			require.NoError(t, os.Setenv(k, v))
+
+			defer func(k string) { require.NoError(t, os.Unsetenv(k)) }(k)
+		}
+
+		cmd := GetImportUsersCmd()
+		cmd.SetOut(&bytes.Buffer{})
+		cmd.SetArgs([]string{})
+
+		require.NoError(t, cmd.Execute())
+	})
+}
+
+// newImportServer starts the import with the handler, and returns the jobs in order to the polls of the import job.
+func newImportServer(t *testing.T, handle http.HandlerFunc, jobs ...*importJob) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			require.Equal(t, userImportPath, r.URL.Path)
+
+			handle(w, r)
+
+			return
+		}
+
+		require.Equal(t, http.MethodGet, r.Method)
+		require.Equal(t, "Bearer operator-key", r.Header.Get("Authorization"))
+
+		if len(jobs) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		require.Equal(t, userImportPath+"/"+jobs[0].ID, r.URL.Path)
+
+		writeImportJob(t, w, http.StatusOK, jobs[0])
+
+		jobs = jobs[1:]
+	}))
+
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func writeImportJob(t *testing.T, w http.ResponseWriter, status int, job *importJob) {
+	t.Helper()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	require.NoError(t, json.NewEncoder(w).Encode(job))
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), name)
+	require.NoError(t, ioutil.WriteFile(file, []byte(content), 0600))
+
+	return file
+}
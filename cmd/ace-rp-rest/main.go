@@ -10,6 +10,8 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/trustbloc/edge-core/pkg/log"
 
+	"github.com/trustbloc/sandbox/cmd/ace-rp-rest/fakecmd"
+	"github.com/trustbloc/sandbox/cmd/ace-rp-rest/importcmd"
 	"github.com/trustbloc/sandbox/cmd/ace-rp-rest/startcmd"
 )
 
@@ -24,6 +26,8 @@ func main() {
 	}
 
 	rootCmd.AddCommand(startcmd.GetStartCmd(&startcmd.HTTPServer{}))
+	rootCmd.AddCommand(fakecmd.GetFakeServicesCmd(&startcmd.HTTPServer{}))
+	rootCmd.AddCommand(importcmd.GetImportUsersCmd())
 
 	if err := rootCmd.Execute(); err != nil {
 		logger.Fatalf("Failed to run rp: %s", err.Error())
@@ -25,9 +25,12 @@ import (
 	tlsutils "github.com/trustbloc/edge-core/pkg/utils/tls"
 
 	"github.com/trustbloc/sandbox/cmd/common"
+	"github.com/trustbloc/sandbox/pkg/acefake"
 	"github.com/trustbloc/sandbox/pkg/restapi/acerp"
 	"github.com/trustbloc/sandbox/pkg/restapi/acerp/operation"
 	"github.com/trustbloc/sandbox/pkg/restapi/healthcheck"
+	tokenResolver "github.com/trustbloc/sandbox/pkg/token/resolver"
+	"github.com/trustbloc/sandbox/pkg/vdr"
 )
 
 const (
@@ -64,12 +67,12 @@ const (
 
 	// vault server url
 	vaultServerURLFlagName  = "vault-server-url"
-	vaultServerURLFlagUsage = "URL of the vault server. This field is mandatory."
+	vaultServerURLFlagUsage = "URL of the vault server. This field is mandatory unless the local services are enabled."
 	vaultServerURLEnvKey    = "ACE_VAULT_SERVER_URL"
 
 	// comparator url
 	comparatorURLFlagName  = "comparator-url"
-	comparatorURLFlagUsage = "URL of the comparator. This field is mandatory."
+	comparatorURLFlagUsage = "URL of the comparator. This field is mandatory unless the local services are enabled."
 	comparatorURLEnvKey    = "ACE_COMPARATOR_URL"
 
 	// vc issuer server url
@@ -102,14 +105,75 @@ const (
 	didResolverURLFlagUsage = "DID Resolver URL."
 	didResolverURLEnvKey    = "ACE_DID_RESOLVER_URL"
 
+	didLocalResolutionFlagName  = "did-local-resolution"
+	didLocalResolutionFlagUsage = "Resolve did:key and did:web DIDs locally, without the DID resolver." +
+		" Possible values [true] [false]. Defaults to false if not set." +
+		" Alternatively, this can be set with the following environment variable: " + didLocalResolutionEnvKey
+	didLocalResolutionEnvKey = "ACE_DID_LOCAL_RESOLUTION"
+
+	localServicesFlagName  = "local-services"
+	localServicesFlagUsage = "Use the local stand-ins of the vault server and the comparator, which save their data in" +
+		" the database of the rp. The DIDs are resolved locally too." +
+		" Possible values [true] [false]. Defaults to false if not set." +
+		" Alternatively, this can be set with the following environment variable: " + localServicesEnvKey
+	localServicesEnvKey = "ACE_LOCAL_SERVICES"
+
+	adminAPIKeysFlagName  = "admin-api-keys"
+	adminAPIKeysEnvKey    = "ACE_ADMIN_API_KEYS" //nolint:gosec
+	adminAPIKeysFlagUsage = "API keys of the management endpoints with the role of their holders," +
+		" in the format role=key. The roles are operator and auditor." +
+		" Alternatively, this can be set with the following environment variable: " + adminAPIKeysEnvKey
+
+	adminIntrospectionURLFlagName  = "admin-introspect-url"
+	adminIntrospectionURLFlagUsage = "Token introspection URL of the OAuth bearer tokens of the management endpoints." +
+		" The token issuer, audience and scope roles are mandatory if it is set. The client of a partner-service" +
+		" token must be a client tied to the partner profile." +
+		" Alternatively, this can be set with the following environment variable: " + adminIntrospectionURLEnvKey
+	adminIntrospectionURLEnvKey = "ACE_ADMIN_TOKEN_INTROSPECTION_URL"
+
+	adminTokenIssuerFlagName  = "admin-token-issuer"
+	adminTokenIssuerFlagUsage = "Issuer of the OAuth bearer tokens of the management endpoints." +
+		" Alternatively, this can be set with the following environment variable: " + adminTokenIssuerEnvKey
+	adminTokenIssuerEnvKey = "ACE_ADMIN_TOKEN_ISSUER"
+
+	adminTokenAudienceFlagName  = "admin-token-audience"
+	adminTokenAudienceFlagUsage = "Audience of the OAuth bearer tokens of the management endpoints." +
+		" Alternatively, this can be set with the following environment variable: " + adminTokenAudienceEnvKey
+	adminTokenAudienceEnvKey = "ACE_ADMIN_TOKEN_AUDIENCE"
+
+	adminTokenScopeRolesFlagName  = "admin-token-scope-roles"
+	adminTokenScopeRolesFlagUsage = "Roles granted by the scopes of the OAuth bearer tokens of the management" +
+		" endpoints, in the format scope=role. The roles are operator, auditor and partner-service." +
+		" Alternatively, this can be set with the following environment variable: " + adminTokenScopeRolesEnvKey
+	adminTokenScopeRolesEnvKey = "ACE_ADMIN_TOKEN_SCOPE_ROLES"
+
 	tokenLength2 = 2
 )
 
+// nolint:gochecknoglobals
+var (
+	nationalIDDocType = operation.DocumentType{
+		Name: "nationalID", Label: "Social Security Number", Attribute: "nationalID",
+	}
+	passportDocType    = operation.DocumentType{Name: "passport", Label: "Passport Number", Attribute: "passportNumber"}
+	dateOfBirthDocType = operation.DocumentType{Name: "dateOfBirth", Label: "Date of Birth", Attribute: "dateOfBirth"}
+	addressDocType     = operation.DocumentType{Name: "address", Label: "Address", Attribute: "address"}
+)
+
 // nolint:gochecknoglobals
 var supportedModes = map[string]demoModeConf{
-	"ucis":     {uiPath: "ucis_dept", svcName: "UCIS"},
-	"cbp":      {uiPath: "cbp_dept", svcName: "CBP"},
-	"benefits": {uiPath: "benefits_dept", svcName: "Benefits Settlement"},
+	"ucis": {
+		uiPath: "ucis_dept", svcName: "UCIS",
+		docTypes: []operation.DocumentType{nationalIDDocType, passportDocType, dateOfBirthDocType, addressDocType},
+	},
+	"cbp": {
+		uiPath: "cbp_dept", svcName: "CBP",
+		docTypes: []operation.DocumentType{nationalIDDocType, passportDocType},
+	},
+	"benefits": {
+		uiPath: "benefits_dept", svcName: "Benefits Settlement",
+		docTypes: []operation.DocumentType{nationalIDDocType, dateOfBirthDocType, addressDocType},
+	},
 }
 
 var logger = log.New("ace-rp-rest")
@@ -117,6 +181,8 @@ var logger = log.New("ace-rp-rest")
 type demoModeConf struct {
 	uiPath  string
 	svcName string
+	// docTypes are the types of the documents which can be compared or extracted in the mode
+	docTypes []operation.DocumentType
 }
 
 type server interface {
@@ -153,6 +219,18 @@ type rpParameters struct {
 	extractorProfile   string
 	requestTokens      map[string]string
 	didResolverURL     string
+	didLocalResolution bool
+	localServices      bool
+	adminAuth          *adminAuthParameters
+}
+
+// adminAuthParameters authenticate the callers of the management endpoints.
+type adminAuthParameters struct {
+	apiKeys          []operation.APIKey
+	introspectionURL string
+	tokenIssuer      string
+	tokenAudience    string
+	tokenScopeRoles  map[string]operation.Role
 }
 
 type tlsConfig struct {
@@ -207,8 +285,13 @@ func createStartCmd(srv server) *cobra.Command { //nolint: funlen, gocyclo
 				return fmt.Errorf("invalid demo mode : %s", demoModeFlag)
 			}
 
+			localServices, err := getBool(cmd, localServicesFlagName, localServicesEnvKey)
+			if err != nil {
+				return err
+			}
+
 			vaultServerURL, err := cmdutils.GetUserSetVarFromString(cmd, vaultServerURLFlagName,
-				vaultServerURLEnvKey, false)
+				vaultServerURLEnvKey, localServices)
 			if err != nil {
 				return err
 			}
@@ -234,8 +317,13 @@ func createStartCmd(srv server) *cobra.Command { //nolint: funlen, gocyclo
 				return err
 			}
 
+			adminAuth, err := getAdminAuth(cmd)
+			if err != nil {
+				return err
+			}
+
 			comparatorURL, err := cmdutils.GetUserSetVarFromString(cmd, comparatorURLFlagName,
-				comparatorURLEnvKey, false)
+				comparatorURLEnvKey, localServices)
 			if err != nil {
 				return err
 			}
@@ -252,6 +340,11 @@ func createStartCmd(srv server) *cobra.Command { //nolint: funlen, gocyclo
 				return err
 			}
 
+			didLocalResolution, err := getBool(cmd, didLocalResolutionFlagName, didLocalResolutionEnvKey)
+			if err != nil {
+				return err
+			}
+
 			parameters := &rpParameters{
 				srv:                srv,
 				hostURL:            strings.TrimSpace(hostURL),
@@ -270,6 +363,9 @@ func createStartCmd(srv server) *cobra.Command { //nolint: funlen, gocyclo
 				extractorProfile:   extractorProfile,
 				requestTokens:      requestTokens,
 				didResolverURL:     didResolverURL,
+				didLocalResolution: didLocalResolution,
+				localServices:      localServices,
+				adminAuth:          adminAuth,
 			}
 
 			return startRP(parameters)
@@ -330,7 +426,14 @@ func createFlags(startCmd *cobra.Command) {
 	startCmd.Flags().StringP(accountLinkProfileFlagName, "", "", accountLinkProfileFlagUsage)
 	startCmd.Flags().StringP(extractorProfileFlagName, "", "", extractorProfileFlagUsage)
 	startCmd.Flags().StringP(didResolverURLFlagName, "", "", didResolverURLFlagUsage)
+	startCmd.Flags().StringP(didLocalResolutionFlagName, "", "", didLocalResolutionFlagUsage)
+	startCmd.Flags().StringP(localServicesFlagName, "", "", localServicesFlagUsage)
 	startCmd.Flags().StringArrayP(requestTokensFlagName, "", []string{}, requestTokensFlagUsage)
+	startCmd.Flags().StringArrayP(adminAPIKeysFlagName, "", []string{}, adminAPIKeysFlagUsage)
+	startCmd.Flags().StringP(adminIntrospectionURLFlagName, "", "", adminIntrospectionURLFlagUsage)
+	startCmd.Flags().StringP(adminTokenIssuerFlagName, "", "", adminTokenIssuerFlagUsage)
+	startCmd.Flags().StringP(adminTokenAudienceFlagName, "", "", adminTokenAudienceFlagUsage)
+	startCmd.Flags().StringArrayP(adminTokenScopeRolesFlagName, "", []string{}, adminTokenScopeRolesFlagUsage)
 	startCmd.Flags().StringP(common.LogLevelFlagName, common.LogLevelFlagShorthand, "", common.LogLevelPrefixFlagUsage)
 }
 
@@ -354,7 +457,9 @@ func startRP(parameters *rpParameters) error { // nolint: funlen
 		return err
 	}
 
-	vdri, err := createVDRI(parameters.didResolverURL, tlsConfig)
+	// the vaults of the local vault server are did:key DIDs
+	vdri, err := createVDRI(parameters.didResolverURL, parameters.didLocalResolution || parameters.localServices,
+		tlsConfig)
 	if err != nil {
 		return err
 	}
@@ -383,6 +488,26 @@ func startRP(parameters *rpParameters) error { // nolint: funlen
 		SvcName:              parameters.modeConf.svcName,
 		VDRI:                 vdri,
 		DocumentLoader:       documentLoader,
+		DocumentTypes:        parameters.modeConf.docTypes,
+		AdminAPIKeys:         parameters.adminAuth.apiKeys,
+		TokenIssuer:          parameters.adminAuth.tokenIssuer,
+		TokenAudience:        parameters.adminAuth.tokenAudience,
+		TokenScopeRoles:      parameters.adminAuth.tokenScopeRoles,
+	}
+
+	if parameters.localServices {
+		localServices, localErr := acefake.New(storeProvider)
+		if localErr != nil {
+			return fmt.Errorf("create local services : %w", localErr)
+		}
+
+		cfg.VaultClient = localServices.Vault()
+		cfg.ComparatorClient = localServices.Comparator()
+	}
+
+	if parameters.adminAuth.introspectionURL != "" {
+		cfg.TokenResolver = tokenResolver.New(parameters.adminAuth.introspectionURL,
+			tokenResolver.WithTLSConfig(tlsConfig))
 	}
 
 	aceRpService, err := acerp.New(cfg)
@@ -390,6 +515,8 @@ func startRP(parameters *rpParameters) error { // nolint: funlen
 		return err
 	}
 
+	defer aceRpService.Close()
+
 	handlers := aceRpService.GetOperations()
 
 	for _, handler := range handlers {
@@ -450,7 +577,114 @@ func getRequestTokens(cmd *cobra.Command) (map[string]string, error) {
 	return tokens, nil
 }
 
-func createVDRI(didResolverURL string, tlsConfig *tls.Config) (vdrapi.Registry, error) {
+// getAdminAuth returns the API keys and the token validation of the management endpoints. The partner services
+// authenticate with their client credentials or tokens, which are tied to their profile.
+func getAdminAuth(cmd *cobra.Command) (*adminAuthParameters, error) {
+	keys, err := cmdutils.GetUserSetVarFromArrayString(cmd, adminAPIKeysFlagName, adminAPIKeysEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	params := &adminAuthParameters{}
+
+	for _, k := range keys {
+		split := strings.SplitN(k, "=", tokenLength2)
+		if len(split) != tokenLength2 || split[1] == "" {
+			return nil, fmt.Errorf("invalid value for %s : expected role=key", adminAPIKeysFlagName)
+		}
+
+		role, roleErr := operation.ParseRole(split[0])
+		if roleErr != nil || role == operation.RolePartnerService {
+			return nil, fmt.Errorf("invalid value for %s : unsupported role %s", adminAPIKeysFlagName, split[0])
+		}
+
+		params.apiKeys = append(params.apiKeys, operation.APIKey{Key: split[1], Role: role})
+	}
+
+	params.introspectionURL, err = cmdutils.GetUserSetVarFromString(cmd, adminIntrospectionURLFlagName,
+		adminIntrospectionURLEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	params.tokenIssuer, err = cmdutils.GetUserSetVarFromString(cmd, adminTokenIssuerFlagName,
+		adminTokenIssuerEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	params.tokenAudience, err = cmdutils.GetUserSetVarFromString(cmd, adminTokenAudienceFlagName,
+		adminTokenAudienceEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	params.tokenScopeRoles, err = getTokenScopeRoles(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.introspectionURL != "" &&
+		(params.tokenIssuer == "" || params.tokenAudience == "" || len(params.tokenScopeRoles) == 0) {
+		return nil, fmt.Errorf("%s needs %s, %s and %s", adminIntrospectionURLFlagName, adminTokenIssuerFlagName,
+			adminTokenAudienceFlagName, adminTokenScopeRolesFlagName)
+	}
+
+	if len(params.apiKeys) == 0 && params.introspectionURL == "" {
+		logger.Warnf("no admin api keys nor token introspection url, the management endpoints are not accessible")
+	}
+
+	return params, nil
+}
+
+func getTokenScopeRoles(cmd *cobra.Command) (map[string]operation.Role, error) {
+	scopeRoles, err := cmdutils.GetUserSetVarFromArrayString(cmd, adminTokenScopeRolesFlagName,
+		adminTokenScopeRolesEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make(map[string]operation.Role)
+
+	for _, sr := range scopeRoles {
+		split := strings.SplitN(sr, "=", tokenLength2)
+		if len(split) != tokenLength2 || split[0] == "" {
+			return nil, fmt.Errorf("invalid value for %s : expected scope=role", adminTokenScopeRolesFlagName)
+		}
+
+		role, roleErr := operation.ParseRole(split[1])
+		if roleErr != nil {
+			return nil, fmt.Errorf("invalid value for %s : unsupported role %s", adminTokenScopeRolesFlagName, split[1])
+		}
+
+		roles[split[0]] = role
+	}
+
+	return roles, nil
+}
+
+func getBool(cmd *cobra.Command, flagName, envKey string) (bool, error) {
+	value, err := cmdutils.GetUserSetVarFromString(cmd, flagName, envKey, true)
+	if err != nil || value == "" {
+		return false, err
+	}
+
+	return strconv.ParseBool(value)
+}
+
+// createVDRI creates the registry which resolves the DIDs with the DID resolver. The did:key and did:web DIDs are
+// resolved locally if enabled. The resolved DID documents are cached.
+func createVDRI(didResolverURL string, localResolution bool, tlsConfig *tls.Config) (vdrapi.Registry, error) {
+	var opts []vdrpkg.Option
+
+	if localResolution {
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+
+		for _, v := range vdr.LocalVDRs(httpClient) {
+			opts = append(opts, vdrpkg.WithVDR(v))
+		}
+	}
+
 	didResolverVDRI, err := httpbinding.New(didResolverURL, httpbinding.WithTLSConfig(tlsConfig),
 		httpbinding.WithAccept(func(method string) bool {
 			return method == "orb" || method == "v1" || method == "elem" || method == "sov" ||
@@ -460,5 +694,8 @@ func createVDRI(didResolverURL string, tlsConfig *tls.Config) (vdrapi.Registry,
 		return nil, fmt.Errorf("failed to create new universal resolver vdr: %w", err)
 	}
 
-	return vdrpkg.New(vdrpkg.WithVDR(didResolverVDRI)), nil
+	// the local VDRs accept their methods first
+	opts = append(opts, vdrpkg.WithVDR(vdr.WithTypedNotFound(didResolverVDRI)))
+
+	return vdr.NewCachingRegistry(vdrpkg.New(opts...)), nil
 }
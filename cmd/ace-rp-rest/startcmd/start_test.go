@@ -16,6 +16,8 @@ import (
 	"github.com/trustbloc/edge-core/pkg/log"
 
 	"github.com/trustbloc/sandbox/cmd/common"
+	"github.com/trustbloc/sandbox/pkg/restapi/acerp/operation"
+	"github.com/trustbloc/sandbox/pkg/vdr"
 )
 
 const flag = "--"
@@ -276,11 +278,157 @@ func TestTLSSystemCertPoolInvalidArgsEnvVar(t *testing.T) {
 
 	require.NoError(t, os.Setenv(tlsSystemCertPoolEnvKey, "wrongvalue"))
 
+	defer func() { require.NoError(t, os.Unsetenv(tlsSystemCertPoolEnvKey)) }()
+
 	err := startCmd.Execute()
 	require.Error(t, err)
 	require.Contains(t, err.Error(), "invalid syntax")
 }
 
+func TestDIDLocalResolutionInvalidArgsEnvVar(t *testing.T) {
+	startCmd := GetStartCmd(&mockServer{})
+
+	setEnvVars(t)
+	defer unsetEnvVars(t)
+
+	require.NoError(t, os.Setenv(didLocalResolutionEnvKey, "wrongvalue"))
+
+	defer func() { require.NoError(t, os.Unsetenv(didLocalResolutionEnvKey)) }()
+
+	err := startCmd.Execute()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid syntax")
+}
+
+func TestLocalServicesArg(t *testing.T) {
+	t.Run("without vault server and comparator urls", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		var args []string
+		args = append(args, hostURLArg()...)
+		args = append(args, databaseURLArg()...)
+		args = append(args, databasePrefixArg()...)
+		args = append(args, demoModeArg("ucis")...)
+		args = append(args, vcIssuerURLArg()...)
+		args = append(args, hostExternalURLArg()...)
+		args = append(args, didResolverURLArg()...)
+		args = append(args, flag+localServicesFlagName, "true")
+		startCmd.SetArgs(args)
+
+		err := startCmd.Execute()
+		require.NoError(t, err)
+	})
+
+	t.Run("invalid value", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		setEnvVars(t)
+		defer unsetEnvVars(t)
+
+		require.NoError(t, os.Setenv(localServicesEnvKey, "wrongvalue"))
+
+		defer func() { require.NoError(t, os.Unsetenv(localServicesEnvKey)) }()
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid syntax")
+	})
+}
+
+func TestAdminAuthArgs(t *testing.T) {
+	newCmd := func(adminArgs ...string) *cobra.Command {
+		startCmd := GetStartCmd(&mockServer{})
+
+		args := getValidArgs("INFO")
+		args = append(args, adminArgs...)
+		startCmd.SetArgs(args)
+
+		return startCmd
+	}
+
+	t.Run("api keys and token validation", func(t *testing.T) {
+		startCmd := newCmd(flag+adminAPIKeysFlagName, "operator=key1", flag+adminAPIKeysFlagName, "auditor=key2",
+			flag+adminIntrospectionURLFlagName, "https://hydra.example.com/oauth2/introspect",
+			flag+adminTokenIssuerFlagName, "https://hydra.example.com/",
+			flag+adminTokenAudienceFlagName, "ace-rp",
+			flag+adminTokenScopeRolesFlagName, "ace:audit=auditor",
+			flag+adminTokenScopeRolesFlagName, "ace:partner=partner-service")
+
+		require.NoError(t, startCmd.Execute())
+
+		params, err := getAdminAuth(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, []operation.APIKey{
+			{Key: "key1", Role: operation.RoleOperator},
+			{Key: "key2", Role: operation.RoleAuditor},
+		}, params.apiKeys)
+		require.Equal(t, "https://hydra.example.com/oauth2/introspect", params.introspectionURL)
+		require.Equal(t, "https://hydra.example.com/", params.tokenIssuer)
+		require.Equal(t, "ace-rp", params.tokenAudience)
+		require.Equal(t, map[string]operation.Role{
+			"ace:audit":   operation.RoleAuditor,
+			"ace:partner": operation.RolePartnerService,
+		}, params.tokenScopeRoles)
+	})
+
+	t.Run("token introspection without the token validation", func(t *testing.T) {
+		err := newCmd(flag+adminIntrospectionURLFlagName, "https://hydra.example.com/oauth2/introspect",
+			flag+adminTokenIssuerFlagName, "https://hydra.example.com/",
+			flag+adminTokenAudienceFlagName, "ace-rp").Execute()
+		require.EqualError(t, err,
+			"admin-introspect-url needs admin-token-issuer, admin-token-audience and admin-token-scope-roles")
+	})
+
+	t.Run("invalid token scope role", func(t *testing.T) {
+		err := newCmd(flag+adminTokenScopeRolesFlagName, "auditor").Execute()
+		require.EqualError(t, err, "invalid value for admin-token-scope-roles : expected scope=role")
+
+		err = newCmd(flag+adminTokenScopeRolesFlagName, "ace:admin=admin").Execute()
+		require.EqualError(t, err, "invalid value for admin-token-scope-roles : unsupported role admin")
+	})
+
+	t.Run("invalid api key", func(t *testing.T) {
+		err := newCmd(flag+adminAPIKeysFlagName, "key1").Execute()
+		require.EqualError(t, err, "invalid value for admin-api-keys : expected role=key")
+
+		err = newCmd(flag+adminAPIKeysFlagName, "operator=").Execute()
+		require.EqualError(t, err, "invalid value for admin-api-keys : expected role=key")
+	})
+
+	t.Run("unsupported role", func(t *testing.T) {
+		err := newCmd(flag+adminAPIKeysFlagName, "admin=key1").Execute()
+		require.EqualError(t, err, "invalid value for admin-api-keys : unsupported role admin")
+
+		// the partner services authenticate with the credentials tied to their profile
+		err = newCmd(flag+adminAPIKeysFlagName, "partner-service=key1").Execute()
+		require.EqualError(t, err, "invalid value for admin-api-keys : unsupported role partner-service")
+	})
+}
+
+func TestCreateVDRI(t *testing.T) {
+	const didKey = "did:key:z6MkpTHR8VNsBxYAAWHut2Geadd9jSwuBV8xRoAnwWsdvktH"
+
+	resolver := httptest.NewServer(http.NotFoundHandler())
+	defer resolver.Close()
+
+	t.Run("local resolution", func(t *testing.T) {
+		vdri, err := createVDRI(resolver.URL, true, nil)
+		require.NoError(t, err)
+
+		doc, err := vdri.Resolve(didKey)
+		require.NoError(t, err)
+		require.Equal(t, didKey, doc.DIDDocument.ID)
+	})
+
+	t.Run("did resolver", func(t *testing.T) {
+		vdri, err := createVDRI(resolver.URL, false, nil)
+		require.NoError(t, err)
+
+		_, err = vdri.Resolve(didKey)
+		require.True(t, vdr.IsNotFound(err))
+	})
+}
+
 func TestTStaticPaths(t *testing.T) {
 	router := pathPrefix("static")
 
@@ -8,13 +8,19 @@ package startcmd
 
 import (
 	"crypto/tls"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/hyperledger/aries-framework-go/pkg/doc/jsonld"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
 	"github.com/spf13/cobra"
+	"github.com/square/go-jose/v3"
 	"github.com/trustbloc/edge-core/pkg/log"
 	"github.com/trustbloc/edge-core/pkg/restapi/logspec"
 	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
@@ -25,7 +31,9 @@ import (
 	"github.com/trustbloc/sandbox/pkg/restapi/healthcheck"
 	"github.com/trustbloc/sandbox/pkg/restapi/issuer"
 	"github.com/trustbloc/sandbox/pkg/restapi/issuer/operation"
+	"github.com/trustbloc/sandbox/pkg/token"
 	tokenIssuer "github.com/trustbloc/sandbox/pkg/token/issuer"
+	"github.com/trustbloc/sandbox/pkg/token/jwks"
 	tokenResolver "github.com/trustbloc/sandbox/pkg/token/resolver"
 )
 
@@ -68,6 +76,57 @@ const (
 	introspectionURLFlagUsage     = "Token introspection URL for auth2 server. Format: HostName:Port."
 	introspectionURLEnvKey        = "OAUTH2_ENDPOINT_TOKEN_INTROSPECTION_URL"
 
+	tokenCacheSizeFlagName  = "token-cache-size"
+	tokenCacheSizeFlagUsage = "Maximum number of introspected access tokens to cache, 0 disables the cache." +
+		" Default 1000." +
+		" Alternatively, this can be set with the following environment variable: " + tokenCacheSizeEnvKey
+	tokenCacheSizeEnvKey = "ISSUER_TOKEN_CACHE_SIZE"
+
+	tokenCacheMaxTTLFlagName  = "token-cache-max-ttl"
+	tokenCacheMaxTTLFlagUsage = "Maximum time an introspected access token is cached, tokens are cached until" +
+		" they expire otherwise. Default 5m." +
+		" Alternatively, this can be set with the following environment variable: " + tokenCacheMaxTTLEnvKey
+	tokenCacheMaxTTLEnvKey = "ISSUER_TOKEN_CACHE_MAX_TTL"
+
+	tokenIssuerFlagName  = "token-issuer"
+	tokenIssuerFlagUsage = "If set, access tokens issued by another issuer are rejected." +
+		" Alternatively, this can be set with the following environment variable: " + tokenIssuerEnvKey
+	tokenIssuerEnvKey = "ISSUER_TOKEN_ISSUER"
+
+	tokenAudienceFlagName  = "token-audience"
+	tokenAudienceFlagUsage = "If set, access tokens not issued for this audience are rejected." +
+		" Required with the jwt token validation." +
+		" Alternatively, this can be set with the following environment variable: " + tokenAudienceEnvKey
+	tokenAudienceEnvKey = "ISSUER_TOKEN_AUDIENCE"
+
+	tokenValidationFlagName  = "token-validation"
+	tokenValidationFlagUsage = "How access tokens are validated: introspection (the default) with the introspect-url" +
+		" or jwt for JWT access tokens verified with the keys of the token-jwks-url." +
+		" Alternatively, this can be set with the following environment variable: " + tokenValidationEnvKey
+	tokenValidationEnvKey = "ISSUER_TOKEN_VALIDATION"
+
+	tokenJWKSURLFlagName  = "token-jwks-url"
+	tokenJWKSURLFlagUsage = "JWKS URL of the keys of the JWT access tokens, required for the jwt token validation." +
+		" Alternatively, this can be set with the following environment variable: " + tokenJWKSURLEnvKey
+	tokenJWKSURLEnvKey = "ISSUER_TOKEN_JWKS_URL"
+
+	revocationURLFlagName  = "revoke-url"
+	revocationURLFlagUsage = "Token revocation URL of the auth2 server, the tokens are revoked on logout if set." +
+		" Alternatively, this can be set with the following environment variable: " + revocationURLEnvKey
+	revocationURLEnvKey = "OAUTH2_ENDPOINT_TOKEN_REVOCATION_URL"
+
+	clientAuthMethodFlagName  = "client-auth-method"
+	clientAuthMethodFlagUsage = "Client authentication of the introspection and revocation requests:" +
+		" client_secret_basic, client_secret_post or private_key_jwt. Introspection requests aren't authenticated" +
+		" and revocation requests use client_secret_basic if not set." +
+		" Alternatively, this can be set with the following environment variable: " + clientAuthMethodEnvKey
+	clientAuthMethodEnvKey = "OAUTH2_ISSUER_CLIENT_AUTH_METHOD"
+
+	clientPrivateKeyFileFlagName  = "client-private-key-file"
+	clientPrivateKeyFileFlagUsage = "Path to the JWK of the client private key, required for private_key_jwt." +
+		" Alternatively, this can be set with the following environment variable: " + clientPrivateKeyFileEnvKey
+	clientPrivateKeyFileEnvKey = "OAUTH2_ISSUER_CLIENT_PRIVATE_KEY_FILE"
+
 	tlsCertFileFlagName      = "tls-cert-file"
 	tlsCertFileFlagShorthand = ""
 	tlsCertFileFlagUsage     = "tls certificate file." +
@@ -134,7 +193,27 @@ const (
 		" Alternatively, this can be set with the following environment variable: " + oidcCallbackURLEnvKey
 	oidcCallbackURLEnvKey = "ISSUER_OIDC_CALLBACK"
 
+	oidcUserInfoFlagName  = "oidc-userinfo"
+	oidcUserInfoFlagUsage = "Merge the claims from the OIDC provider's UserInfo endpoint into the ID token claims" +
+		" (true/false). Default false." +
+		" Alternatively, this can be set with the following environment variable: " + oidcUserInfoEnvKey
+	oidcUserInfoEnvKey = "ISSUER_OIDC_USERINFO"
+
+	oidcProvidersFileFlagName  = "oidc-providers-file"
+	oidcProvidersFileFlagUsage = "Path to a JSON file with the named OIDC providers selected with the provider" +
+		` parameter of the OIDC request, for example {"bank": {"url": "https://bank.example.com",` +
+		` "clientID": "id", "clientSecret": "secret", "userInfo": true}}.` +
+		" The provider of the oidc-opurl flag is the default provider." +
+		" Alternatively, this can be set with the following environment variable: " + oidcProvidersFileEnvKey
+	oidcProvidersFileEnvKey = "ISSUER_OIDC_PROVIDERS_FILE"
+
 	tokenLength2 = 2
+
+	defaultTokenCacheSize   = 1000
+	defaultTokenCacheMaxTTL = 5 * time.Minute
+
+	introspectionTokenValidation = "introspection"
+	jwtTokenValidation           = "jwt"
 )
 
 var logger = log.New("issuer-rest")
@@ -173,6 +252,18 @@ type issuerParameters struct {
 	logLevel              string
 	dbParameters          *common.DBParameters
 	oidcParameters        *oidcParameters
+	tokenParameters       *tokenParameters
+}
+
+type tokenParameters struct {
+	validation    string
+	jwksURL       string
+	cacheSize     int
+	cacheMaxTTL   time.Duration
+	issuer        string
+	audience      string
+	revocationURL string
+	clientAuth    *token.ClientAuth
 }
 
 type tlsConfig struct {
@@ -187,6 +278,8 @@ type oidcParameters struct {
 	oidcClientID     string
 	oidcClientSecret string
 	oidcCallbackURL  string
+	oidcUserInfo     bool
+	oidcProviders    map[string]*operation.OIDCProviderConfig
 }
 
 // GetStartCmd returns the Cobra start command.
@@ -215,8 +308,19 @@ func createStartCmd(srv server) *cobra.Command { // nolint: gocyclo
 				return err
 			}
 
+			tokenParams, err := getTokenParameters(cmd)
+			if err != nil {
+				return err
+			}
+
+			tokenParams.clientAuth, err = getClientAuth(cmd, oauth2Config)
+			if err != nil {
+				return err
+			}
+
+			// the introspection url isn't used to validate jwt access tokens
 			tokenIntrospectionURL, err := cmdutils.GetUserSetVarFromString(cmd, introspectionURLFlagName,
-				introspectionURLEnvKey, false)
+				introspectionURLEnvKey, tokenParams.validation == jwtTokenValidation)
 			if err != nil {
 				return err
 			}
@@ -278,6 +382,7 @@ func createStartCmd(srv server) *cobra.Command { // nolint: gocyclo
 				logLevel:              loggingLevel,
 				dbParameters:          dbParams,
 				oidcParameters:        oidcParams,
+				tokenParameters:       tokenParams,
 			}
 
 			return startIssuer(parameters)
@@ -307,14 +412,211 @@ func getOIDCParameters(cmd *cobra.Command) (*oidcParameters, error) {
 		return nil, err
 	}
 
+	oidcUserInfoString, err := cmdutils.GetUserSetVarFromString(cmd, oidcUserInfoFlagName, oidcUserInfoEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	oidcUserInfo := false
+
+	if oidcUserInfoString != "" {
+		oidcUserInfo, err = strconv.ParseBool(oidcUserInfoString)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s : %w", oidcUserInfoFlagName, err)
+		}
+	}
+
+	oidcProviders, err := getOIDCProviders(cmd)
+	if err != nil {
+		return nil, err
+	}
+
 	return &oidcParameters{
 		oidcProviderURL:  oidcProviderURL,
 		oidcClientID:     oidcClientID,
 		oidcClientSecret: oidcClientSecret,
 		oidcCallbackURL:  oidcCallbackURL,
+		oidcUserInfo:     oidcUserInfo,
+		oidcProviders:    oidcProviders,
 	}, nil
 }
 
+func newTokenResolver(parameters *issuerParameters,
+	tlsConfig *tls.Config) (*tokenResolver.CachingResolver, error) {
+	opts := []tokenResolver.CacheOption{
+		tokenResolver.WithCacheSize(parameters.tokenParameters.cacheSize),
+		tokenResolver.WithCacheMaxTTL(parameters.tokenParameters.cacheMaxTTL),
+		tokenResolver.WithIssuer(parameters.tokenParameters.issuer),
+		tokenResolver.WithAudience(parameters.tokenParameters.audience),
+	}
+
+	if parameters.tokenParameters.validation == jwtTokenValidation {
+		jwtResolver, err := jwks.New(parameters.tokenParameters.jwksURL, parameters.tokenParameters.audience,
+			jwks.WithTLSConfig(tlsConfig))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create jwt token resolver : %w", err)
+		}
+
+		return tokenResolver.NewCachingResolver(jwtResolver, opts...), nil
+	}
+
+	return tokenResolver.NewCachingResolver(
+		tokenResolver.New(parameters.tokenIntrospectionURL, tokenResolver.WithTLSConfig(tlsConfig),
+			tokenResolver.WithClientAuth(parameters.tokenParameters.clientAuth)), opts...), nil
+}
+
+func newTokenIssuer(parameters *issuerParameters, tlsConfig *tls.Config,
+	storeProvider storage.Provider) (*tokenIssuer.Issuer, error) {
+	return tokenIssuer.New(parameters.oauth2Config, tokenIssuer.WithTLSConfig(tlsConfig),
+		tokenIssuer.WithStoreProvider(storeProvider),
+		tokenIssuer.WithRevocationURL(parameters.tokenParameters.revocationURL),
+		tokenIssuer.WithClientAuth(parameters.tokenParameters.clientAuth))
+}
+
+// getClientAuth returns the client authentication of the auth2 server requests, or nil if the method isn't set.
+func getClientAuth(cmd *cobra.Command, oauth2Config *oauth2.Config) (*token.ClientAuth, error) {
+	method, err := cmdutils.GetUserSetVarFromString(cmd, clientAuthMethodFlagName, clientAuthMethodEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if method == "" {
+		return nil, nil
+	}
+
+	auth := &token.ClientAuth{
+		Method:       strings.TrimSpace(method),
+		ClientID:     oauth2Config.ClientID,
+		ClientSecret: oauth2Config.ClientSecret,
+	}
+
+	if auth.Method == token.PrivateKeyJWT {
+		keyFile, err := cmdutils.GetUserSetVarFromString(cmd, clientPrivateKeyFileFlagName,
+			clientPrivateKeyFileEnvKey, false)
+		if err != nil {
+			return nil, err
+		}
+
+		keyBytes, err := os.ReadFile(keyFile) // nolint: gosec
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client private key file : %w", err)
+		}
+
+		auth.PrivateKey = &jose.JSONWebKey{}
+
+		err = auth.PrivateKey.UnmarshalJSON(keyBytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s : %w", clientPrivateKeyFileFlagName, err)
+		}
+	}
+
+	err = auth.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for %s : %w", clientAuthMethodFlagName, err)
+	}
+
+	return auth, nil
+}
+
+func getTokenParameters(cmd *cobra.Command) (*tokenParameters, error) {
+	cacheSize, err := cmdutils.GetUserSetVarFromString(cmd, tokenCacheSizeFlagName, tokenCacheSizeEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheMaxTTL, err := cmdutils.GetUserSetVarFromString(cmd, tokenCacheMaxTTLFlagName, tokenCacheMaxTTLEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	issuer, err := cmdutils.GetUserSetVarFromString(cmd, tokenIssuerFlagName, tokenIssuerEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	validation, err := cmdutils.GetUserSetVarFromString(cmd, tokenValidationFlagName, tokenValidationEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	switch validation {
+	case "":
+		validation = introspectionTokenValidation
+	case introspectionTokenValidation, jwtTokenValidation:
+	default:
+		return nil, fmt.Errorf("invalid value for %s : %s", tokenValidationFlagName, validation)
+	}
+
+	jwksURL, err := cmdutils.GetUserSetVarFromString(cmd, tokenJWKSURLFlagName, tokenJWKSURLEnvKey,
+		validation != jwtTokenValidation)
+	if err != nil {
+		return nil, err
+	}
+
+	// the JWT access tokens can only be told apart from the provider's other tokens by their audience
+	audience, err := cmdutils.GetUserSetVarFromString(cmd, tokenAudienceFlagName, tokenAudienceEnvKey,
+		validation != jwtTokenValidation)
+	if err != nil {
+		return nil, err
+	}
+
+	revocationURL, err := cmdutils.GetUserSetVarFromString(cmd, revocationURLFlagName, revocationURLEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	params := &tokenParameters{
+		validation:    validation,
+		jwksURL:       jwksURL,
+		cacheSize:     defaultTokenCacheSize,
+		cacheMaxTTL:   defaultTokenCacheMaxTTL,
+		issuer:        issuer,
+		audience:      audience,
+		revocationURL: strings.TrimSpace(revocationURL),
+	}
+
+	if cacheSize != "" {
+		params.cacheSize, err = strconv.Atoi(cacheSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s : %w", tokenCacheSizeFlagName, err)
+		}
+	}
+
+	if cacheMaxTTL != "" {
+		params.cacheMaxTTL, err = time.ParseDuration(cacheMaxTTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s : %w", tokenCacheMaxTTLFlagName, err)
+		}
+	}
+
+	return params, nil
+}
+
+func getOIDCProviders(cmd *cobra.Command) (map[string]*operation.OIDCProviderConfig, error) {
+	providersFile, err := cmdutils.GetUserSetVarFromString(cmd, oidcProvidersFileFlagName, oidcProvidersFileEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if providersFile == "" {
+		return nil, nil
+	}
+
+	providersBytes, err := os.ReadFile(providersFile) // nolint: gosec
+	if err != nil {
+		return nil, fmt.Errorf("failed to read oidc providers file : %w", err)
+	}
+
+	var providers map[string]*operation.OIDCProviderConfig
+
+	err = json.Unmarshal(providersBytes, &providers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse oidc providers file : %w", err)
+	}
+
+	return providers, nil
+}
+
 func getRequestTokens(cmd *cobra.Command) (map[string]string, error) {
 	requestTokens, err := cmdutils.GetUserSetVarFromArrayString(cmd, requestTokensFlagName,
 		requestTokensEnvKey, true)
@@ -404,6 +706,17 @@ func createFlags(startCmd *cobra.Command) {
 	startCmd.Flags().StringP(oidcClientIDFlagName, "", "", oidcClientIDFlagUsage)
 	startCmd.Flags().StringP(oidcClientSecretFlagName, "", "", oidcClientSecretFlagUsage)
 	startCmd.Flags().StringP(oidcCallbackURLFlagName, "", "", oidcCallbackURLFlagUsage)
+	startCmd.Flags().StringP(oidcUserInfoFlagName, "", "", oidcUserInfoFlagUsage)
+	startCmd.Flags().StringP(oidcProvidersFileFlagName, "", "", oidcProvidersFileFlagUsage)
+	startCmd.Flags().StringP(tokenCacheSizeFlagName, "", "", tokenCacheSizeFlagUsage)
+	startCmd.Flags().StringP(tokenCacheMaxTTLFlagName, "", "", tokenCacheMaxTTLFlagUsage)
+	startCmd.Flags().StringP(tokenIssuerFlagName, "", "", tokenIssuerFlagUsage)
+	startCmd.Flags().StringP(tokenAudienceFlagName, "", "", tokenAudienceFlagUsage)
+	startCmd.Flags().StringP(tokenValidationFlagName, "", "", tokenValidationFlagUsage)
+	startCmd.Flags().StringP(tokenJWKSURLFlagName, "", "", tokenJWKSURLFlagUsage)
+	startCmd.Flags().StringP(revocationURLFlagName, "", "", revocationURLFlagUsage)
+	startCmd.Flags().StringP(clientAuthMethodFlagName, "", "", clientAuthMethodFlagUsage)
+	startCmd.Flags().StringP(clientPrivateKeyFileFlagName, "", "", clientPrivateKeyFileFlagUsage)
 }
 
 func startIssuer(parameters *issuerParameters) error { //nolint:funlen
@@ -428,9 +741,19 @@ func startIssuer(parameters *issuerParameters) error { //nolint:funlen
 		return err
 	}
 
+	oauth2Issuer, err := newTokenIssuer(parameters, tlsConfig, storeProvider)
+	if err != nil {
+		return err
+	}
+
+	resolver, err := newTokenResolver(parameters, tlsConfig)
+	if err != nil {
+		return err
+	}
+
 	cfg := &operation.Config{
-		TokenIssuer:      tokenIssuer.New(parameters.oauth2Config, tokenIssuer.WithTLSConfig(tlsConfig)),
-		TokenResolver:    tokenResolver.New(parameters.tokenIntrospectionURL, tokenResolver.WithTLSConfig(tlsConfig)),
+		TokenIssuer:      oauth2Issuer,
+		TokenResolver:    resolver,
 		DocumentLoader:   documentLoader,
 		CMSURL:           parameters.cmsURL,
 		VCSURL:           parameters.vcsURL,
@@ -447,6 +770,8 @@ func startIssuer(parameters *issuerParameters) error { //nolint:funlen
 		OIDCClientID:     parameters.oidcParameters.oidcClientID,
 		OIDCClientSecret: parameters.oidcParameters.oidcClientSecret,
 		OIDCCallbackURL:  parameters.oidcParameters.oidcCallbackURL,
+		OIDCUserInfo:     parameters.oidcParameters.oidcUserInfo,
+		OIDCProviders:    parameters.oidcParameters.oidcProviders,
 	}
 
 	issuerService, err := issuer.New(cfg)
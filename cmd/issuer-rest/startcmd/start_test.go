@@ -6,12 +6,16 @@ SPDX-License-Identifier: Apache-2.0
 package startcmd
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
 	"fmt"
 	"net/http"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/spf13/cobra"
+	"github.com/square/go-jose/v3"
 	"github.com/stretchr/testify/require"
 	"github.com/trustbloc/edge-core/pkg/log"
 
@@ -307,6 +311,176 @@ func TestOIDCParam(t *testing.T) {
 		err := startCmd.Execute()
 		require.Contains(t, err.Error(), "oidc param error")
 	})
+
+	t.Run("test oidc param - invalid userinfo", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		args := getValidArgs("")
+		args = append(args, "--"+oidcUserInfoFlagName, "invalid")
+		startCmd.SetArgs(args)
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid value for "+oidcUserInfoFlagName)
+	})
+
+	t.Run("test token params", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		args := getValidArgs("")
+		args = append(args, "--"+tokenCacheSizeFlagName, "10", "--"+tokenCacheMaxTTLFlagName, "1m",
+			"--"+tokenIssuerFlagName, "https://hydra.example.com/", "--"+tokenAudienceFlagName, "issuer")
+		startCmd.SetArgs(args)
+		require.NoError(t, startCmd.Execute())
+
+		startCmd = GetStartCmd(&mockServer{})
+
+		args = getValidArgs("")
+		args = append(args, "--"+tokenCacheSizeFlagName, "invalid")
+		startCmd.SetArgs(args)
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid value for "+tokenCacheSizeFlagName)
+
+		startCmd = GetStartCmd(&mockServer{})
+
+		args = getValidArgs("")
+		args = append(args, "--"+tokenCacheMaxTTLFlagName, "invalid")
+		startCmd.SetArgs(args)
+
+		err = startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid value for "+tokenCacheMaxTTLFlagName)
+	})
+
+	t.Run("test token validation params", func(t *testing.T) {
+		var args []string
+
+		// jwt access tokens are validated without the introspection url
+		for _, arg := range getValidArgs("") {
+			if arg == flag+introspectionURLFlagName || arg == "endpoint/introspect" {
+				continue
+			}
+
+			args = append(args, arg)
+		}
+
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(args, "--"+tokenValidationFlagName, "jwt",
+			"--"+tokenJWKSURLFlagName, "https://hydra.example.com/.well-known/jwks.json",
+			"--"+tokenAudienceFlagName, "issuer"))
+		require.NoError(t, startCmd.Execute())
+
+		startCmd = GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(getValidArgs(""), "--"+tokenValidationFlagName, "jwt"))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), tokenJWKSURLFlagName)
+
+		// the audience is required with the jwt validation
+		startCmd = GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(args, "--"+tokenValidationFlagName, "jwt",
+			"--"+tokenJWKSURLFlagName, "https://hydra.example.com/.well-known/jwks.json"))
+
+		err = startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), tokenAudienceFlagName)
+
+		startCmd = GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(getValidArgs(""), "--"+tokenValidationFlagName, "invalid"))
+
+		err = startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid value for "+tokenValidationFlagName)
+	})
+
+	t.Run("test client auth params", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(getValidArgs(""), "--"+clientAuthMethodFlagName, "client_secret_post",
+			"--"+revocationURLFlagName, "endpoint/revoke"))
+		require.NoError(t, startCmd.Execute())
+
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+
+		keyBytes, err := (&jose.JSONWebKey{Key: privateKey, KeyID: "key-1"}).MarshalJSON()
+		require.NoError(t, err)
+
+		keyFile := filepath.Join(t.TempDir(), "key.json")
+		require.NoError(t, os.WriteFile(keyFile, keyBytes, 0o600))
+
+		startCmd = GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(getValidArgs(""), "--"+clientAuthMethodFlagName, "private_key_jwt",
+			"--"+clientPrivateKeyFileFlagName, keyFile))
+		require.NoError(t, startCmd.Execute())
+
+		startCmd = GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(getValidArgs(""), "--"+clientAuthMethodFlagName, "private_key_jwt"))
+
+		err = startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), clientPrivateKeyFileFlagName)
+
+		startCmd = GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(getValidArgs(""), "--"+clientAuthMethodFlagName, "private_key_jwt",
+			"--"+clientPrivateKeyFileFlagName, filepath.Join(t.TempDir(), "missing.json")))
+
+		err = startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to read client private key file")
+
+		require.NoError(t, os.WriteFile(keyFile, []byte("{"), 0o600))
+
+		startCmd = GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(getValidArgs(""), "--"+clientAuthMethodFlagName, "private_key_jwt",
+			"--"+clientPrivateKeyFileFlagName, keyFile))
+
+		err = startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid value for "+clientPrivateKeyFileFlagName)
+
+		startCmd = GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(getValidArgs(""), "--"+clientAuthMethodFlagName, "invalid"))
+
+		err = startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid value for "+clientAuthMethodFlagName)
+	})
+
+	t.Run("test oidc param - providers file", func(t *testing.T) {
+		providersFile := filepath.Join(t.TempDir(), "providers.json")
+
+		require.NoError(t, os.WriteFile(providersFile,
+			[]byte(`{"bank": {"url": "https://bank.example.com", "clientID": "id"}}`), 0o600))
+
+		startCmd := GetStartCmd(&mockServer{})
+
+		args := getValidArgs("")
+		args = append(args, "--"+oidcProvidersFileFlagName, providersFile)
+		startCmd.SetArgs(args)
+		require.NoError(t, startCmd.Execute())
+
+		require.NoError(t, os.WriteFile(providersFile, []byte("{"), 0o600))
+
+		startCmd = GetStartCmd(&mockServer{})
+		startCmd.SetArgs(args)
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to parse oidc providers file")
+
+		startCmd = GetStartCmd(&mockServer{})
+
+		args = getValidArgs("")
+		args = append(args, "--"+oidcProvidersFileFlagName, filepath.Join(t.TempDir(), "missing.json"))
+		startCmd.SetArgs(args)
+
+		err = startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to read oidc providers file")
+	})
 }
 
 func TestStartCmdValidArgs(t *testing.T) {
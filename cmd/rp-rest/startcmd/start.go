@@ -8,12 +8,17 @@ package startcmd
 
 import (
 	"crypto/tls"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/spf13/cobra"
+	"github.com/square/go-jose/v3"
 	"github.com/trustbloc/edge-core/pkg/log"
 	"github.com/trustbloc/edge-core/pkg/restapi/logspec"
 	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
@@ -83,6 +88,54 @@ const (
 		" Alternatively, this can be set with the following environment variable: " + oidcCallbackURLEnvKey
 	oidcCallbackURLEnvKey = "RP_OIDC_CALLBACK"
 
+	oidcUserInfoFlagName  = "oidc-userinfo"
+	oidcUserInfoFlagUsage = "Merge the claims from the OIDC provider's UserInfo endpoint into the ID token claims" +
+		" (true/false). Default false." +
+		" Alternatively, this can be set with the following environment variable: " + oidcUserInfoEnvKey
+	oidcUserInfoEnvKey = "RP_OIDC_USERINFO"
+
+	oidcProvidersFileFlagName  = "oidc-providers-file"
+	oidcProvidersFileFlagUsage = "Path to a JSON file with the named OIDC providers selected with the provider" +
+		` parameter of the OIDC request, for example {"bank": {"url": "https://bank.example.com",` +
+		` "clientID": "id", "clientSecret": "secret", "userInfo": true}}.` +
+		" The provider of the oidc-opurl flag is the default provider." +
+		" Alternatively, this can be set with the following environment variable: " + oidcProvidersFileEnvKey
+	oidcProvidersFileEnvKey = "RP_OIDC_PROVIDERS_FILE"
+
+	claimsMappingFileFlagName  = "claims-mapping-file"
+	claimsMappingFileFlagUsage = "Path to a JSON file mapping the verified claims to application fields per flow," +
+		` for example {"flow1": {"name.given": "firstName"}}.` +
+		" Alternatively, this can be set with the following environment variable: " + claimsMappingFileEnvKey
+	claimsMappingFileEnvKey = "RP_CLAIMS_MAPPING_FILE"
+
+	claimsSessionTTLFlagName  = "claims-session-ttl"
+	claimsSessionTTLFlagUsage = "How long the verified claims are kept for the application (ex: 15m). Default 15m." +
+		" Alternatively, this can be set with the following environment variable: " + claimsSessionTTLEnvKey
+	claimsSessionTTLEnvKey = "RP_CLAIMS_SESSION_TTL"
+
+	trustRegistryFileFlagName  = "trust-registry-file"
+	trustRegistryFileFlagUsage = "Path to the trust registry document (JSON, YAML or JWS signed) listing the accepted" +
+		" issuers. Alternatively, this can be set with the following environment variable: " + trustRegistryFileEnvKey
+	trustRegistryFileEnvKey = "RP_TRUST_REGISTRY_FILE"
+
+	trustRegistryKeyFlagName  = "trust-registry-governance-key"
+	trustRegistryKeyFlagUsage = "Path to the public JWK used to verify the signed trust registry documents." +
+		" If set, unsigned documents are rejected." +
+		" Alternatively, this can be set with the following environment variable: " + trustRegistryKeyEnvKey
+	trustRegistryKeyEnvKey = "RP_TRUST_REGISTRY_GOVERNANCE_KEY"
+
+	trustRegistryAdminTokenFlagName  = "trust-registry-admin-token"
+	trustRegistryAdminTokenFlagUsage = "Bearer token required to update the trust registry over REST when the documents" +
+		" aren't signed with the governance key." +
+		" Alternatively, this can be set with the following environment variable: " + trustRegistryAdminTokenEnvKey
+	trustRegistryAdminTokenEnvKey = "RP_TRUST_REGISTRY_ADMIN_TOKEN"
+
+	trustRegistryAllowEmptyFlagName  = "trust-registry-allow-empty"
+	trustRegistryAllowEmptyFlagUsage = "Accept trust registry documents without issuer policies, which trusts all" +
+		" the issuers. Default false." +
+		" Alternatively, this can be set with the following environment variable: " + trustRegistryAllowEmptyEnvKey
+	trustRegistryAllowEmptyEnvKey = "RP_TRUST_REGISTRY_ALLOW_EMPTY"
+
 	tokenLength2 = 2
 )
 
@@ -118,6 +171,20 @@ type rpParameters struct {
 	logLevel          string
 	oidcParameters    *oidcParameters
 	dbParams          *common.DBParameters
+	claimsParameters  *claimsParameters
+	trustRegistry     *trustRegistryParameters
+}
+
+type trustRegistryParameters struct {
+	document      []byte
+	governanceKey *jose.JSONWebKey
+	adminToken    string
+	allowEmpty    bool
+}
+
+type claimsParameters struct {
+	flowClaims map[string]operation.ClaimsMapping
+	sessionTTL time.Duration
 }
 
 type oidcParameters struct {
@@ -125,6 +192,8 @@ type oidcParameters struct {
 	oidcClientID     string
 	oidcClientSecret string
 	oidcCallbackURL  string
+	oidcUserInfo     bool
+	oidcProviders    map[string]*operation.OIDCProviderConfig
 }
 
 type tlsConfig struct {
@@ -184,6 +253,16 @@ func createStartCmd(srv server) *cobra.Command {
 				return err
 			}
 
+			claimsParams, err := getClaimsParameters(cmd)
+			if err != nil {
+				return err
+			}
+
+			trustRegistryParams, err := getTrustRegistryParameters(cmd)
+			if err != nil {
+				return err
+			}
+
 			parameters := &rpParameters{
 				srv:               srv,
 				hostURL:           strings.TrimSpace(hostURL),
@@ -196,6 +275,8 @@ func createStartCmd(srv server) *cobra.Command {
 				logLevel:          loggingLevel,
 				oidcParameters:    oidcParams,
 				dbParams:          dbParams,
+				claimsParameters:  claimsParams,
+				trustRegistry:     trustRegistryParams,
 			}
 
 			return startRP(parameters)
@@ -225,14 +306,151 @@ func getOIDCParameters(cmd *cobra.Command) (*oidcParameters, error) {
 		return nil, err
 	}
 
+	oidcUserInfoString, err := cmdutils.GetUserSetVarFromString(cmd, oidcUserInfoFlagName, oidcUserInfoEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	oidcUserInfo := false
+
+	if oidcUserInfoString != "" {
+		oidcUserInfo, err = strconv.ParseBool(oidcUserInfoString)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s : %w", oidcUserInfoFlagName, err)
+		}
+	}
+
+	oidcProviders, err := getOIDCProviders(cmd)
+	if err != nil {
+		return nil, err
+	}
+
 	return &oidcParameters{
 		oidcProviderURL:  oidcProviderURL,
 		oidcClientID:     oidcClientID,
 		oidcClientSecret: oidcClientSecret,
 		oidcCallbackURL:  oidcCallbackURL,
+		oidcUserInfo:     oidcUserInfo,
+		oidcProviders:    oidcProviders,
 	}, nil
 }
 
+func getOIDCProviders(cmd *cobra.Command) (map[string]*operation.OIDCProviderConfig, error) {
+	providersFile, err := cmdutils.GetUserSetVarFromString(cmd, oidcProvidersFileFlagName, oidcProvidersFileEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if providersFile == "" {
+		return nil, nil
+	}
+
+	providersBytes, err := os.ReadFile(providersFile) // nolint: gosec
+	if err != nil {
+		return nil, fmt.Errorf("failed to read oidc providers file : %w", err)
+	}
+
+	var providers map[string]*operation.OIDCProviderConfig
+
+	err = json.Unmarshal(providersBytes, &providers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse oidc providers file : %w", err)
+	}
+
+	return providers, nil
+}
+
+func getClaimsParameters(cmd *cobra.Command) (*claimsParameters, error) {
+	mappingFile, err := cmdutils.GetUserSetVarFromString(cmd, claimsMappingFileFlagName, claimsMappingFileEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	params := &claimsParameters{}
+
+	if mappingFile != "" {
+		mappingBytes, err := os.ReadFile(mappingFile) // nolint: gosec
+		if err != nil {
+			return nil, fmt.Errorf("failed to read claims mapping file : %w", err)
+		}
+
+		err = json.Unmarshal(mappingBytes, &params.flowClaims)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse claims mapping file : %w", err)
+		}
+	}
+
+	sessionTTL, err := cmdutils.GetUserSetVarFromString(cmd, claimsSessionTTLFlagName, claimsSessionTTLEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if sessionTTL != "" {
+		params.sessionTTL, err = time.ParseDuration(sessionTTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s : %w", claimsSessionTTLFlagName, err)
+		}
+	}
+
+	return params, nil
+}
+
+func getTrustRegistryParameters(cmd *cobra.Command) (*trustRegistryParameters, error) {
+	documentFile, err := cmdutils.GetUserSetVarFromString(cmd, trustRegistryFileFlagName, trustRegistryFileEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	keyFile, err := cmdutils.GetUserSetVarFromString(cmd, trustRegistryKeyFlagName, trustRegistryKeyEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	adminToken, err := cmdutils.GetUserSetVarFromString(cmd, trustRegistryAdminTokenFlagName,
+		trustRegistryAdminTokenEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	allowEmptyString, err := cmdutils.GetUserSetVarFromString(cmd, trustRegistryAllowEmptyFlagName,
+		trustRegistryAllowEmptyEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	params := &trustRegistryParameters{adminToken: adminToken}
+
+	if allowEmptyString != "" {
+		params.allowEmpty, err = strconv.ParseBool(allowEmptyString)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s : %w", trustRegistryAllowEmptyFlagName, err)
+		}
+	}
+
+	if documentFile != "" {
+		params.document, err = os.ReadFile(documentFile) // nolint: gosec
+		if err != nil {
+			return nil, fmt.Errorf("failed to read trust registry file : %w", err)
+		}
+	}
+
+	if keyFile != "" {
+		keyBytes, err := os.ReadFile(keyFile) // nolint: gosec
+		if err != nil {
+			return nil, fmt.Errorf("failed to read trust registry governance key : %w", err)
+		}
+
+		params.governanceKey = &jose.JSONWebKey{}
+
+		err = params.governanceKey.UnmarshalJSON(keyBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse trust registry governance key : %w", err)
+		}
+	}
+
+	return params, nil
+}
+
 func getRequestTokens(cmd *cobra.Command) (map[string]string, error) {
 	requestTokens, err := cmdutils.GetUserSetVarFromArrayString(cmd, requestTokensFlagName,
 		requestTokensEnvKey, true)
@@ -307,6 +525,14 @@ func createFlags(startCmd *cobra.Command) {
 	startCmd.Flags().StringP(oidcClientIDFlagName, "", "", oidcClientIDFlagUsage)
 	startCmd.Flags().StringP(oidcClientSecretFlagName, "", "", oidcClientSecretFlagUsage)
 	startCmd.Flags().StringP(oidcCallbackURLFlagName, "", "", oidcCallbackURLFlagUsage)
+	startCmd.Flags().StringP(oidcUserInfoFlagName, "", "", oidcUserInfoFlagUsage)
+	startCmd.Flags().StringP(oidcProvidersFileFlagName, "", "", oidcProvidersFileFlagUsage)
+	startCmd.Flags().StringP(claimsMappingFileFlagName, "", "", claimsMappingFileFlagUsage)
+	startCmd.Flags().StringP(claimsSessionTTLFlagName, "", "", claimsSessionTTLFlagUsage)
+	startCmd.Flags().StringP(trustRegistryFileFlagName, "", "", trustRegistryFileFlagUsage)
+	startCmd.Flags().StringP(trustRegistryKeyFlagName, "", "", trustRegistryKeyFlagUsage)
+	startCmd.Flags().StringP(trustRegistryAdminTokenFlagName, "", "", trustRegistryAdminTokenFlagUsage)
+	startCmd.Flags().StringP(trustRegistryAllowEmptyFlagName, "", "", trustRegistryAllowEmptyFlagUsage)
 }
 
 func startRP(parameters *rpParameters) error {
@@ -325,16 +551,24 @@ func startRP(parameters *rpParameters) error {
 	}
 
 	cfg := &operation.Config{
-		VPHTML:                 "static/vp.html",
-		DIDCOMMVPHTML:          "static/didcommvp.html",
-		VCSURL:                 parameters.vcServiceURL,
-		TLSConfig:              &tls.Config{RootCAs: rootCAs, MinVersion: tls.VersionTLS12},
-		RequestTokens:          parameters.requestTokens,
-		TransientStoreProvider: transientStore,
-		OIDCProviderURL:        parameters.oidcParameters.oidcProviderURL,
-		OIDCClientID:           parameters.oidcParameters.oidcClientID,
-		OIDCClientSecret:       parameters.oidcParameters.oidcClientSecret,
-		OIDCCallbackURL:        parameters.oidcParameters.oidcCallbackURL,
+		VPHTML:                  "static/vp.html",
+		DIDCOMMVPHTML:           "static/didcommvp.html",
+		VCSURL:                  parameters.vcServiceURL,
+		TLSConfig:               &tls.Config{RootCAs: rootCAs, MinVersion: tls.VersionTLS12},
+		RequestTokens:           parameters.requestTokens,
+		TransientStoreProvider:  transientStore,
+		OIDCProviderURL:         parameters.oidcParameters.oidcProviderURL,
+		OIDCClientID:            parameters.oidcParameters.oidcClientID,
+		OIDCClientSecret:        parameters.oidcParameters.oidcClientSecret,
+		OIDCCallbackURL:         parameters.oidcParameters.oidcCallbackURL,
+		OIDCUserInfo:            parameters.oidcParameters.oidcUserInfo,
+		OIDCProviders:           parameters.oidcParameters.oidcProviders,
+		FlowClaims:              parameters.claimsParameters.flowClaims,
+		ClaimsSessionTTL:        parameters.claimsParameters.sessionTTL,
+		TrustRegistryDocument:   parameters.trustRegistry.document,
+		TrustRegistryKey:        parameters.trustRegistry.governanceKey,
+		TrustRegistryAdminToken: parameters.trustRegistry.adminToken,
+		TrustRegistryAllowEmpty: parameters.trustRegistry.allowEmpty,
 	}
 
 	rpService, err := rp.New(cfg)
@@ -8,6 +8,7 @@ package startcmd
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -103,6 +104,67 @@ func TestOIDCParam(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestOIDCUserInfoParam(t *testing.T) {
+	oidcProviderURL, cleanup := newTestOIDCProvider()
+	defer cleanup()
+
+	startCmd := GetStartCmd(&mockServer{})
+
+	args := getValidArgs("", oidcProviderURL)
+	args = append(args, flag+oidcUserInfoFlagName, "true")
+	startCmd.SetArgs(args)
+	require.NoError(t, startCmd.Execute())
+
+	startCmd = GetStartCmd(&mockServer{})
+
+	args = getValidArgs("", oidcProviderURL)
+	args = append(args, flag+oidcUserInfoFlagName, "invalid")
+	startCmd.SetArgs(args)
+
+	err := startCmd.Execute()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid value for "+oidcUserInfoFlagName)
+}
+
+func TestOIDCProvidersParam(t *testing.T) {
+	oidcProviderURL, cleanup := newTestOIDCProvider()
+	defer cleanup()
+
+	providersFile, fileCleanup := writeTempFile(t,
+		`{"bank": {"url": "https://bank.example.com", "clientID": "id"}, "gov": {"url": "https://gov.example.com"}}`)
+	defer fileCleanup()
+
+	startCmd := GetStartCmd(&mockServer{})
+
+	args := getValidArgs("", oidcProviderURL)
+	args = append(args, flag+oidcProvidersFileFlagName, providersFile)
+	startCmd.SetArgs(args)
+	require.NoError(t, startCmd.Execute())
+
+	invalidFile, invalidCleanup := writeTempFile(t, "{")
+	defer invalidCleanup()
+
+	startCmd = GetStartCmd(&mockServer{})
+
+	args = getValidArgs("", oidcProviderURL)
+	args = append(args, flag+oidcProvidersFileFlagName, invalidFile)
+	startCmd.SetArgs(args)
+
+	err := startCmd.Execute()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed to parse oidc providers file")
+
+	startCmd = GetStartCmd(&mockServer{})
+
+	args = getValidArgs("", oidcProviderURL)
+	args = append(args, flag+oidcProvidersFileFlagName, "invalid-file")
+	startCmd.SetArgs(args)
+
+	err = startCmd.Execute()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed to read oidc providers file")
+}
+
 func TestStartCmdValidArgsEnvVar(t *testing.T) {
 	startCmd := GetStartCmd(&mockServer{})
 
@@ -207,6 +269,199 @@ func TestStartCmd(t *testing.T) {
 	})
 }
 
+func TestClaimsParams(t *testing.T) {
+	t.Run("valid claims mapping and ttl", func(t *testing.T) {
+		oidcProviderURL, cleanup := newTestOIDCProvider()
+		defer cleanup()
+
+		file, err := ioutil.TempFile("", "claims-*.json")
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, os.Remove(file.Name())) }()
+
+		_, err = file.WriteString(`{"flow1": {"name.given": "firstName"}}`)
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+
+		cmd := GetStartCmd(&mockServer{})
+		args := getValidArgs("", oidcProviderURL)
+		args = append(args, flag+claimsMappingFileFlagName, file.Name())
+		args = append(args, flag+claimsSessionTTLFlagName, "5m")
+
+		cmd.SetArgs(args)
+		require.NoError(t, cmd.Execute())
+	})
+
+	t.Run("claims mapping file not found", func(t *testing.T) {
+		cmd := GetStartCmd(&mockServer{})
+		args := getValidArgs("", "")
+		args = append(args, flag+claimsMappingFileFlagName, "/invalid/claims.json")
+
+		cmd.SetArgs(args)
+		err := cmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to read claims mapping file")
+	})
+
+	t.Run("invalid claims mapping file", func(t *testing.T) {
+		file, err := ioutil.TempFile("", "claims-*.json")
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, os.Remove(file.Name())) }()
+
+		cmd := GetStartCmd(&mockServer{})
+		args := getValidArgs("", "")
+		args = append(args, flag+claimsMappingFileFlagName, file.Name())
+
+		cmd.SetArgs(args)
+		err = cmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to parse claims mapping file")
+	})
+
+	t.Run("invalid claims session ttl", func(t *testing.T) {
+		cmd := GetStartCmd(&mockServer{})
+		args := getValidArgs("", "")
+		args = append(args, flag+claimsSessionTTLFlagName, "invalid")
+
+		cmd.SetArgs(args)
+		err := cmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid value for "+claimsSessionTTLFlagName)
+	})
+}
+
+func TestTrustRegistryParams(t *testing.T) {
+	t.Run("valid trust registry file", func(t *testing.T) {
+		oidcProviderURL, cleanup := newTestOIDCProvider()
+		defer cleanup()
+
+		file, fileCleanup := writeTempFile(t, `{"issuers": [{"dids": ["did:example:issuer1"]}]}`)
+		defer fileCleanup()
+
+		cmd := GetStartCmd(&mockServer{})
+		args := getValidArgs("", oidcProviderURL)
+		args = append(args, flag+trustRegistryFileFlagName, file)
+
+		cmd.SetArgs(args)
+		require.NoError(t, cmd.Execute())
+	})
+
+	t.Run("admin token and empty trust registry", func(t *testing.T) {
+		oidcProviderURL, cleanup := newTestOIDCProvider()
+		defer cleanup()
+
+		file, fileCleanup := writeTempFile(t, `{"issuers": []}`)
+		defer fileCleanup()
+
+		cmd := GetStartCmd(&mockServer{})
+		args := getValidArgs("", oidcProviderURL)
+		args = append(args, flag+trustRegistryFileFlagName, file, flag+trustRegistryAdminTokenFlagName, "token",
+			flag+trustRegistryAllowEmptyFlagName, "true")
+
+		cmd.SetArgs(args)
+		require.NoError(t, cmd.Execute())
+	})
+
+	t.Run("empty trust registry not allowed", func(t *testing.T) {
+		oidcProviderURL, cleanup := newTestOIDCProvider()
+		defer cleanup()
+
+		file, fileCleanup := writeTempFile(t, `{"issuers": []}`)
+		defer fileCleanup()
+
+		cmd := GetStartCmd(&mockServer{})
+		args := getValidArgs("", oidcProviderURL)
+		args = append(args, flag+trustRegistryFileFlagName, file)
+
+		cmd.SetArgs(args)
+		err := cmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "no issuer policies")
+	})
+
+	t.Run("invalid allow empty value", func(t *testing.T) {
+		cmd := GetStartCmd(&mockServer{})
+		args := getValidArgs("", "")
+		args = append(args, flag+trustRegistryAllowEmptyFlagName, "maybe")
+
+		cmd.SetArgs(args)
+		err := cmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid value for "+trustRegistryAllowEmptyFlagName)
+	})
+
+	t.Run("trust registry file not found", func(t *testing.T) {
+		cmd := GetStartCmd(&mockServer{})
+		args := getValidArgs("", "")
+		args = append(args, flag+trustRegistryFileFlagName, "/invalid/registry.json")
+
+		cmd.SetArgs(args)
+		err := cmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to read trust registry file")
+	})
+
+	t.Run("unsigned trust registry with governance key", func(t *testing.T) {
+		oidcProviderURL, cleanup := newTestOIDCProvider()
+		defer cleanup()
+
+		file, fileCleanup := writeTempFile(t, `{"issuers": [{"dids": ["did:example:issuer1"]}]}`)
+		defer fileCleanup()
+
+		keyFile, keyCleanup := writeTempFile(t,
+			`{"kty":"OKP","crv":"Ed25519","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}`)
+		defer keyCleanup()
+
+		cmd := GetStartCmd(&mockServer{})
+		args := getValidArgs("", oidcProviderURL)
+		args = append(args, flag+trustRegistryFileFlagName, file, flag+trustRegistryKeyFlagName, keyFile)
+
+		cmd.SetArgs(args)
+		err := cmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "trust registry document is not signed")
+	})
+
+	t.Run("governance key not found", func(t *testing.T) {
+		cmd := GetStartCmd(&mockServer{})
+		args := getValidArgs("", "")
+		args = append(args, flag+trustRegistryKeyFlagName, "/invalid/key.json")
+
+		cmd.SetArgs(args)
+		err := cmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to read trust registry governance key")
+	})
+
+	t.Run("invalid governance key", func(t *testing.T) {
+		keyFile, keyCleanup := writeTempFile(t, "invalid")
+		defer keyCleanup()
+
+		cmd := GetStartCmd(&mockServer{})
+		args := getValidArgs("", "")
+		args = append(args, flag+trustRegistryKeyFlagName, keyFile)
+
+		cmd.SetArgs(args)
+		err := cmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to parse trust registry governance key")
+	})
+}
+
+func writeTempFile(t *testing.T, content string) (string, func()) {
+	t.Helper()
+
+	file, err := ioutil.TempFile("", "rp-*")
+	require.NoError(t, err)
+
+	_, err = file.WriteString(content)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	return file.Name(), func() { require.NoError(t, os.Remove(file.Name())) }
+}
+
 func checkFlagPropertiesCorrect(t *testing.T, cmd *cobra.Command, flagName, flagShorthand, flagUsage string) {
 	t.Helper()
 
@@ -28,5 +28,7 @@ require (
 	github.com/trustbloc/edge-core v0.1.7-0.20210527163745-994ae929f957
 	github.com/trustbloc/edge-service v0.1.7-0.20210512082458-f8636e7a6288
 	github.com/trustbloc/edv v0.1.7-0.20210527173439-3b17690a0345
+	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
 	golang.org/x/oauth2 v0.0.0-20200902213428-5d25da1a8d43
+	gopkg.in/yaml.v2 v2.4.0
 )
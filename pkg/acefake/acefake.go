@@ -0,0 +1,123 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package acefake has local stand-ins for the vault server and the comparator, so that the ACE flow can run on
+// one machine. The stand-ins implement the clients used by the ACE RP, and can be served with the HTTP API of the
+// services.
+package acefake
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hyperledger/aries-framework-go/pkg/vdr/fingerprint"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+)
+
+const rootPath = "$"
+
+func newDIDKey() (string, string, error) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("generate key : %w", err)
+	}
+
+	didKey, keyID := fingerprint.CreateDIDKey(pub)
+
+	return didKey, keyID, nil
+}
+
+// newToken returns an opaque token, which stands for a zcap.
+func newToken() string {
+	return strings.ReplaceAll(uuid.NewString(), "-", "")
+}
+
+func expiry(now time.Time, duration int64) *time.Time {
+	if duration <= 0 {
+		return nil
+	}
+
+	t := now.Add(time.Duration(duration) * time.Second)
+
+	return &t
+}
+
+func expired(expiry *time.Time, now time.Time) bool {
+	return expiry != nil && !now.Before(*expiry)
+}
+
+// attrValue returns the value of the attribute of the document. Only the dot notation of the JSONPath is
+// supported, for example $.credentialSubject.nationalID.
+func attrValue(doc interface{}, path string) (interface{}, error) {
+	if path == "" || path == rootPath {
+		return doc, nil
+	}
+
+	if !strings.HasPrefix(path, rootPath+".") {
+		return nil, fmt.Errorf("unsupported doc attribute path %s", path)
+	}
+
+	value := doc
+
+	for _, name := range strings.Split(strings.TrimPrefix(path, rootPath+"."), ".") {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("doc attribute %s not found", path)
+		}
+
+		value, ok = obj[name]
+		if !ok {
+			return nil, fmt.Errorf("doc attribute %s not found", path)
+		}
+	}
+
+	return value, nil
+}
+
+func putJSON(store storage.Store, key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal : %w", err)
+	}
+
+	return store.Put(key, b)
+}
+
+func getJSON(store storage.Store, key string, v interface{}) error {
+	b, err := store.Get(key)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+func remove(values []string, v string) []string {
+	var result []string
+
+	for _, s := range values {
+		if s != v {
+			result = append(result, s)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,61 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package acefake
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttrValue(t *testing.T) {
+	doc := map[string]interface{}{
+		"credentialSubject": map[string]interface{}{"nationalID": "555341212"},
+	}
+
+	t.Run("root", func(t *testing.T) {
+		value, err := attrValue(doc, "")
+		require.NoError(t, err)
+		require.Equal(t, doc, value)
+
+		value, err = attrValue(doc, "$")
+		require.NoError(t, err)
+		require.Equal(t, doc, value)
+	})
+
+	t.Run("nested attribute", func(t *testing.T) {
+		value, err := attrValue(doc, "$.credentialSubject.nationalID")
+		require.NoError(t, err)
+		require.Equal(t, "555341212", value)
+	})
+
+	t.Run("attribute not found", func(t *testing.T) {
+		_, err := attrValue(doc, "$.credentialSubject.passportNumber")
+		require.EqualError(t, err, "doc attribute $.credentialSubject.passportNumber not found")
+
+		_, err = attrValue(doc, "$.credentialSubject.nationalID.value")
+		require.EqualError(t, err, "doc attribute $.credentialSubject.nationalID.value not found")
+	})
+
+	t.Run("unsupported path", func(t *testing.T) {
+		_, err := attrValue(doc, "$['credentialSubject']")
+		require.EqualError(t, err, "unsupported doc attribute path $['credentialSubject']")
+	})
+}
+
+func TestExpiry(t *testing.T) {
+	now := time.Now()
+
+	require.Nil(t, expiry(now, 0))
+	require.False(t, expired(nil, now))
+
+	e := expiry(now, 60)
+	require.NotNil(t, e)
+	require.False(t, expired(e, now))
+	require.True(t, expired(e, now.Add(time.Minute)))
+}
@@ -0,0 +1,263 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package acefake
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	compclientops "github.com/trustbloc/edge-service/pkg/client/comparator/client/operations"
+	compmodel "github.com/trustbloc/edge-service/pkg/client/comparator/models"
+)
+
+const (
+	comparatorStoreName = "acefake_comparator"
+	configKey           = "config"
+
+	minCompareArgs = 2
+)
+
+// ErrInvalidRequest is returned when a request is missing a field or has an unsupported query or operator.
+var ErrInvalidRequest = errors.New("invalid request")
+
+// Comparator is a stand-in for the comparator. It evaluates the EqOp comparisons and the extractions on the
+// documents of the vault stand-in.
+type Comparator struct {
+	store  storage.Store
+	vault  *Vault
+	config *compmodel.Config
+	now    func() time.Time
+}
+
+type comparatorAuthorization struct {
+	ID              string     `json:"id"`
+	RequestingParty string     `json:"requestingParty"`
+	VaultID         string     `json:"vaultID"`
+	DocID           string     `json:"docID"`
+	DocAttrPath     string     `json:"docAttrPath"`
+	EDVToken        string     `json:"edvToken"`
+	Expiry          *time.Time `json:"expiry,omitempty"`
+}
+
+// NewComparator creates a comparator stand-in backed by the store provider. The DID of the comparator is saved,
+// so that the authorizations given to the comparator are kept after a restart.
+func NewComparator(provider storage.Provider, v *Vault) (*Comparator, error) {
+	store, err := provider.OpenStore(comparatorStoreName)
+	if err != nil {
+		return nil, fmt.Errorf("open comparator store : %w", err)
+	}
+
+	config := &compmodel.Config{}
+
+	err = getJSON(store, configKey, config)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		did, keyID, createErr := newDIDKey()
+		if createErr != nil {
+			return nil, createErr
+		}
+
+		config = &compmodel.Config{Did: &did, AuthKeyURL: keyID}
+
+		err = putJSON(store, configKey, config)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("comparator config : %w", err)
+	}
+
+	return &Comparator{store: store, vault: v, config: config, now: time.Now}, nil
+}
+
+// GetConfig returns the DID of the comparator. The vault authorizations are given to the auth key of the DID.
+func (c *Comparator) GetConfig(_ *compclientops.GetConfigParams) (*compclientops.GetConfigOK, error) {
+	return &compclientops.GetConfigOK{Payload: c.config}, nil
+}
+
+// PostAuthorizations authorizes the requesting party to compare or extract the document of the scope. The vault
+// authorization of the scope must be given to the comparator.
+func (c *Comparator) PostAuthorizations(
+	params *compclientops.PostAuthorizationsParams) (*compclientops.PostAuthorizationsOK, error) {
+	req := params.Authorization
+	if req == nil || req.RequestingParty == nil || req.Scope == nil || req.Scope.DocID == nil ||
+		req.Scope.AuthTokens == nil {
+		return nil, fmt.Errorf("authorization needs a requesting party and a scope : %w", ErrInvalidRequest)
+	}
+
+	scope := req.Scope
+
+	_, err := c.vault.readDoc(scope.VaultID, *scope.DocID, scope.AuthTokens.Edv, c.config.AuthKeyURL)
+	if err != nil {
+		return nil, fmt.Errorf("check vault authorization : %w", err)
+	}
+
+	authz := &comparatorAuthorization{
+		ID:              uuid.NewString(),
+		RequestingParty: *req.RequestingParty,
+		VaultID:         scope.VaultID,
+		DocID:           *scope.DocID,
+		DocAttrPath:     scope.DocAttrPath,
+		EDVToken:        scope.AuthTokens.Edv,
+	}
+
+	for _, caveat := range scope.Caveats() {
+		if e, ok := caveat.(*compmodel.ExpiryCaveat); ok {
+			authz.Expiry = expiry(c.now(), e.Duration)
+		}
+	}
+
+	token := newToken()
+
+	err = putJSON(c.store, token, authz)
+	if err != nil {
+		return nil, fmt.Errorf("save authorization : %w", err)
+	}
+
+	return &compclientops.PostAuthorizationsOK{
+		Location: "/authorizations/" + authz.ID,
+		Payload: &compmodel.Authorization{
+			ID:              authz.ID,
+			AuthToken:       token,
+			RequestingParty: req.RequestingParty,
+			Scope:           scope,
+		},
+	}, nil
+}
+
+// PostCompare compares the values of the queries. Only the EqOp operator is supported.
+func (c *Comparator) PostCompare(params *compclientops.PostCompareParams) (*compclientops.PostCompareOK, error) {
+	comparison := &compmodel.Comparison{}
+
+	// the queries can be of the client or the server models
+	err := normalize(params.Comparison, comparison)
+	if err != nil {
+		return nil, err
+	}
+
+	eq, ok := comparison.Op().(*compmodel.EqOp)
+	if !ok {
+		return nil, fmt.Errorf("unsupported operator : %w", ErrInvalidRequest)
+	}
+
+	if len(eq.Args()) < minCompareArgs {
+		return nil, fmt.Errorf("EqOp needs at least %d args : %w", minCompareArgs, ErrInvalidRequest)
+	}
+
+	var first interface{}
+
+	result := true
+
+	for i, q := range eq.Args() {
+		value, err := c.queryValue(q)
+		if err != nil {
+			return nil, err
+		}
+
+		if i == 0 {
+			first = value
+		} else if !reflect.DeepEqual(first, value) {
+			result = false
+		}
+	}
+
+	return &compclientops.PostCompareOK{Payload: &compmodel.ComparisonResult{Result: result}}, nil
+}
+
+// PostExtract returns the values of the queries, with the ids of the queries.
+func (c *Comparator) PostExtract(params *compclientops.PostExtractParams) (*compclientops.PostExtractOK, error) {
+	extract := &compmodel.Extract{}
+
+	err := normalize(params.Extract, extract)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &compmodel.ExtractResp{Documents: make([]*compmodel.ExtractRespDocumentsItems0, 0)}
+
+	for _, q := range extract.Queries() {
+		value, err := c.queryValue(q)
+		if err != nil {
+			return nil, err
+		}
+
+		resp.Documents = append(resp.Documents, &compmodel.ExtractRespDocumentsItems0{ID: q.ID(), Contents: value})
+	}
+
+	return &compclientops.PostExtractOK{Payload: resp}, nil
+}
+
+func (c *Comparator) queryValue(q compmodel.Query) (interface{}, error) {
+	switch query := q.(type) {
+	case *compmodel.DocQuery:
+		if query.VaultID == nil || query.DocID == nil || query.AuthTokens == nil {
+			return nil, fmt.Errorf("DocQuery needs a vault, a doc and auth tokens : %w", ErrInvalidRequest)
+		}
+
+		doc, err := c.vault.readDoc(*query.VaultID, *query.DocID, query.AuthTokens.Edv, c.config.AuthKeyURL)
+		if err != nil {
+			return nil, fmt.Errorf("read doc : %w", err)
+		}
+
+		return attrValue(doc, query.DocAttrPath)
+	case *compmodel.AuthorizedQuery:
+		if query.AuthToken == nil {
+			return nil, fmt.Errorf("AuthorizedQuery needs an auth token : %w", ErrInvalidRequest)
+		}
+
+		authz, err := c.getAuthorization(*query.AuthToken)
+		if err != nil {
+			return nil, err
+		}
+
+		doc, err := c.vault.readDoc(authz.VaultID, authz.DocID, authz.EDVToken, c.config.AuthKeyURL)
+		if err != nil {
+			return nil, fmt.Errorf("read doc : %w", err)
+		}
+
+		return attrValue(doc, authz.DocAttrPath)
+	default:
+		return nil, fmt.Errorf("unsupported query %s : %w", q.Type(), ErrInvalidRequest)
+	}
+}
+
+func (c *Comparator) getAuthorization(token string) (*comparatorAuthorization, error) {
+	authz := &comparatorAuthorization{}
+
+	err := getJSON(c.store, token, authz)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		return nil, fmt.Errorf("invalid auth token : %w", ErrUnauthorized)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("get authorization : %w", err)
+	}
+
+	if expired(authz.Expiry, c.now()) {
+		return nil, fmt.Errorf("auth token expired : %w", ErrUnauthorized)
+	}
+
+	return authz, nil
+}
+
+// normalize converts the request to the client models, as the ACE RP builds some queries with the server models.
+func normalize(req, v interface{}) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("marshal request : %w", err)
+	}
+
+	err = json.Unmarshal(b, v)
+	if err != nil {
+		return fmt.Errorf("%s : %w", err.Error(), ErrInvalidRequest)
+	}
+
+	return nil
+}
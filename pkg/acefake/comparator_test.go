@@ -0,0 +1,275 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package acefake
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	mockstorage "github.com/hyperledger/aries-framework-go/component/storageutil/mock"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+	compclientops "github.com/trustbloc/edge-service/pkg/client/comparator/client/operations"
+	compmodel "github.com/trustbloc/edge-service/pkg/client/comparator/models"
+	"github.com/trustbloc/edge-service/pkg/restapi/comparator/operation/models"
+)
+
+const (
+	sampleAttrPath        = "$.nationalID"
+	sampleAuthorizedParty = "did:example:other-rp"
+)
+
+func TestNewComparator(t *testing.T) {
+	t.Run("config is kept", func(t *testing.T) {
+		provider := mem.NewProvider()
+
+		c := newTestComparator(t, provider)
+		require.NotNil(t, c.config.Did)
+		require.Contains(t, c.config.AuthKeyURL, *c.config.Did)
+
+		other := newTestComparator(t, provider)
+		require.Equal(t, c.config, other.config)
+	})
+
+	t.Run("open store error", func(t *testing.T) {
+		c, err := NewComparator(&mockstorage.Provider{ErrOpenStore: errors.New("open error")}, nil)
+		require.EqualError(t, err, "open comparator store : open error")
+		require.Nil(t, c)
+	})
+
+	t.Run("get config error", func(t *testing.T) {
+		c, err := NewComparator(&mockstorage.Provider{
+			OpenStoreReturn: &mockstorage.Store{ErrGet: errors.New("get error")},
+		}, nil)
+		require.EqualError(t, err, "comparator config : get error")
+		require.Nil(t, c)
+	})
+}
+
+func TestComparator_GetConfig(t *testing.T) {
+	c := newTestComparator(t, mem.NewProvider())
+
+	resp, err := c.GetConfig(compclientops.NewGetConfigParams())
+	require.NoError(t, err)
+	require.Equal(t, c.config, resp.Payload)
+}
+
+func TestComparator_PostAuthorizations(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		c := newTestComparator(t, mem.NewProvider())
+		vaultID := createVaultWithDoc(t, c.vault)
+
+		resp, err := c.PostAuthorizations(authorizationParams(t, c, vaultID, 60))
+		require.NoError(t, err)
+		require.NotEmpty(t, resp.Payload.AuthToken)
+		require.Equal(t, "/authorizations/"+resp.Payload.ID, resp.Location)
+		require.Equal(t, sampleAuthorizedParty, *resp.Payload.RequestingParty)
+
+		authz, err := c.getAuthorization(resp.Payload.AuthToken)
+		require.NoError(t, err)
+		require.Equal(t, vaultID, authz.VaultID)
+		require.NotNil(t, authz.Expiry)
+	})
+
+	t.Run("missing scope", func(t *testing.T) {
+		c := newTestComparator(t, mem.NewProvider())
+
+		_, err := c.PostAuthorizations(compclientops.NewPostAuthorizationsParams().
+			WithAuthorization(&compmodel.Authorization{}))
+		require.True(t, errors.Is(err, ErrInvalidRequest))
+	})
+
+	t.Run("vault authorization for another party", func(t *testing.T) {
+		c := newTestComparator(t, mem.NewProvider())
+		vaultID := createVaultWithDoc(t, c.vault)
+
+		vaultAuthz, err := c.vault.CreateAuthorization(vaultID, sampleRequestingParty, readScope(0))
+		require.NoError(t, err)
+
+		params := authorizationParams(t, c, vaultID, 0)
+		params.Authorization.Scope.AuthTokens.Edv = vaultAuthz.Tokens.EDV
+
+		_, err = c.PostAuthorizations(params)
+		require.True(t, errors.Is(err, ErrUnauthorized))
+		require.Contains(t, err.Error(), "check vault authorization")
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		c := newTestComparator(t, mem.NewProvider())
+		vaultID := createVaultWithDoc(t, c.vault)
+		params := authorizationParams(t, c, vaultID, 0)
+
+		c.store = &mockstorage.Store{ErrPut: errors.New("put error")}
+
+		_, err := c.PostAuthorizations(params)
+		require.EqualError(t, err, "save authorization : put error")
+	})
+}
+
+func TestComparator_PostCompare(t *testing.T) {
+	c := newTestComparator(t, mem.NewProvider())
+	vaultID := createVaultWithDoc(t, c.vault)
+
+	authResp, err := c.PostAuthorizations(authorizationParams(t, c, vaultID, 60))
+	require.NoError(t, err)
+
+	authToken := authResp.Payload.AuthToken
+
+	t.Run("equal", func(t *testing.T) {
+		otherVaultID := createVault(t, c.vault)
+
+		resp, err := c.PostCompare(compareParams(docQuery(t, c, otherVaultID, "555341212"), authToken))
+		require.NoError(t, err)
+		require.True(t, resp.Payload.Result)
+	})
+
+	t.Run("not equal", func(t *testing.T) {
+		otherVaultID := createVault(t, c.vault)
+
+		resp, err := c.PostCompare(compareParams(docQuery(t, c, otherVaultID, "555341213"), authToken))
+		require.NoError(t, err)
+		require.False(t, resp.Payload.Result)
+	})
+
+	t.Run("expired authorization", func(t *testing.T) {
+		otherVaultID := createVault(t, c.vault)
+		query := docQuery(t, c, otherVaultID, "555341212")
+
+		c.now = func() time.Time { return time.Now().Add(time.Hour) }
+		defer func() { c.now = time.Now }()
+
+		_, err := c.PostCompare(compareParams(query, authToken))
+		require.True(t, errors.Is(err, ErrUnauthorized))
+		require.Contains(t, err.Error(), "auth token expired")
+	})
+
+	t.Run("invalid auth token", func(t *testing.T) {
+		otherVaultID := createVault(t, c.vault)
+
+		_, err := c.PostCompare(compareParams(docQuery(t, c, otherVaultID, "555341212"), "invalid"))
+		require.True(t, errors.Is(err, ErrUnauthorized))
+		require.Contains(t, err.Error(), "invalid auth token")
+	})
+
+	t.Run("unsupported operator", func(t *testing.T) {
+		_, err := c.PostCompare(compclientops.NewPostCompareParams().WithComparison(&compmodel.Comparison{}))
+		require.True(t, errors.Is(err, ErrInvalidRequest))
+	})
+
+	t.Run("one arg", func(t *testing.T) {
+		eq := &models.EqOp{}
+		eq.SetArgs([]models.Query{&models.AuthorizedQuery{AuthToken: &authToken}})
+
+		cr := &compmodel.Comparison{}
+		cr.SetOp(eq)
+
+		_, err := c.PostCompare(compclientops.NewPostCompareParams().WithComparison(cr))
+		require.True(t, errors.Is(err, ErrInvalidRequest))
+		require.Contains(t, err.Error(), "EqOp needs at least 2 args")
+	})
+}
+
+func TestComparator_PostExtract(t *testing.T) {
+	c := newTestComparator(t, mem.NewProvider())
+	vaultID := createVaultWithDoc(t, c.vault)
+
+	authResp, err := c.PostAuthorizations(authorizationParams(t, c, vaultID, 0))
+	require.NoError(t, err)
+
+	t.Run("success", func(t *testing.T) {
+		query := &compmodel.AuthorizedQuery{AuthToken: &authResp.Payload.AuthToken}
+		query.SetID("query1")
+
+		extract := &compmodel.Extract{}
+		extract.SetQueries([]compmodel.Query{query})
+
+		resp, err := c.PostExtract(compclientops.NewPostExtractParams().WithExtract(extract))
+		require.NoError(t, err)
+		require.Len(t, resp.Payload.Documents, 1)
+		require.Equal(t, "query1", resp.Payload.Documents[0].ID)
+		require.Equal(t, "555341212", resp.Payload.Documents[0].Contents)
+	})
+
+	t.Run("missing auth token", func(t *testing.T) {
+		extract := &compmodel.Extract{}
+		extract.SetQueries([]compmodel.Query{&compmodel.AuthorizedQuery{}})
+
+		_, err := c.PostExtract(compclientops.NewPostExtractParams().WithExtract(extract))
+		require.True(t, errors.Is(err, ErrInvalidRequest))
+	})
+}
+
+func newTestComparator(t *testing.T, provider storage.Provider) *Comparator {
+	t.Helper()
+
+	v, err := NewVault(provider)
+	require.NoError(t, err)
+
+	c, err := NewComparator(provider, v)
+	require.NoError(t, err)
+
+	return c
+}
+
+// authorizationParams authorizes the comparator to read the doc, as the ACE RP does before it authorizes another RP.
+func authorizationParams(t *testing.T, c *Comparator, vaultID string,
+	expirySecs int64) *compclientops.PostAuthorizationsParams {
+	t.Helper()
+
+	vaultAuthz, err := c.vault.CreateAuthorization(vaultID, c.config.AuthKeyURL, readScope(0))
+	require.NoError(t, err)
+
+	docID := sampleDocID
+	requestingParty := sampleAuthorizedParty
+
+	scope := &compmodel.Scope{
+		Actions:     []string{"compare"},
+		VaultID:     vaultID,
+		DocID:       &docID,
+		AuthTokens:  &compmodel.ScopeAuthTokens{Edv: vaultAuthz.Tokens.EDV, Kms: vaultAuthz.Tokens.KMS},
+		DocAttrPath: sampleAttrPath,
+	}
+	scope.SetCaveats([]compmodel.Caveat{&compmodel.ExpiryCaveat{Duration: expirySecs}})
+
+	return compclientops.NewPostAuthorizationsParams().WithAuthorization(&compmodel.Authorization{
+		RequestingParty: &requestingParty,
+		Scope:           scope,
+	})
+}
+
+// docQuery saves the national id in the vault and returns the query of the doc, with the server models as the
+// ACE RP does.
+func docQuery(t *testing.T, c *Comparator, vaultID, nationalID string) *models.DocQuery {
+	t.Helper()
+
+	_, err := c.vault.SaveDoc(vaultID, sampleDocID, sampleDoc(nationalID))
+	require.NoError(t, err)
+
+	vaultAuthz, err := c.vault.CreateAuthorization(vaultID, c.config.AuthKeyURL, readScope(0))
+	require.NoError(t, err)
+
+	docID := sampleDocID
+
+	return &models.DocQuery{
+		DocID:       &docID,
+		VaultID:     &vaultID,
+		AuthTokens:  &models.DocQueryAO1AuthTokens{Kms: vaultAuthz.Tokens.KMS, Edv: vaultAuthz.Tokens.EDV},
+		DocAttrPath: sampleAttrPath,
+	}
+}
+
+func compareParams(query *models.DocQuery, authToken string) *compclientops.PostCompareParams {
+	eq := &models.EqOp{}
+	eq.SetArgs([]models.Query{query, &models.AuthorizedQuery{AuthToken: &authToken}})
+
+	cr := &compmodel.Comparison{}
+	cr.SetOp(eq)
+
+	return compclientops.NewPostCompareParams().WithComparison(cr)
+}
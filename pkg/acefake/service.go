@@ -0,0 +1,275 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package acefake
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/trustbloc/edge-core/pkg/log"
+	compclientops "github.com/trustbloc/edge-service/pkg/client/comparator/client/operations"
+	compmodel "github.com/trustbloc/edge-service/pkg/client/comparator/models"
+	vaultops "github.com/trustbloc/edge-service/pkg/restapi/vault/operation"
+
+	"github.com/trustbloc/sandbox/pkg/internal/common/support"
+)
+
+const (
+	// vault server api paths
+	vaults                 = "/vaults"
+	vaultPath              = vaults + "/{vaultID}"
+	docs                   = vaultPath + "/docs"
+	vaultAuthorizations    = vaultPath + "/authorizations"
+	vaultAuthorizationPath = vaultAuthorizations + "/{authorizationID}"
+
+	// comparator api paths
+	config         = "/config"
+	authorizations = "/authorizations"
+	compare        = "/compare"
+	extract        = "/extract"
+)
+
+var logger = log.New("ace-fake")
+
+// Handler http handler for each controller API endpoint.
+type Handler interface {
+	Path() string
+	Method() string
+	Handle() http.HandlerFunc
+}
+
+// Service serves the vault and the comparator stand-ins with the HTTP API of the vault server and the comparator,
+// so that the ACE RPs of a demo can share them.
+type Service struct {
+	vault      *Vault
+	comparator *Comparator
+	handlers   []Handler
+}
+
+// New creates the vault and the comparator stand-ins backed by the store provider.
+func New(provider storage.Provider) (*Service, error) {
+	v, err := NewVault(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := NewComparator(provider, v)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Service{vault: v, comparator: c}
+	s.registerHandler()
+
+	return s, nil
+}
+
+// Vault returns the vault stand-in.
+func (s *Service) Vault() *Vault {
+	return s.vault
+}
+
+// Comparator returns the comparator stand-in.
+func (s *Service) Comparator() *Comparator {
+	return s.comparator
+}
+
+// GetRESTHandlers get all controller API handler available for this service
+func (s *Service) GetRESTHandlers() []Handler {
+	return s.handlers
+}
+
+func (s *Service) registerHandler() {
+	s.handlers = []Handler{
+		support.NewHTTPHandler(vaults, http.MethodPost, s.createVault),
+		support.NewHTTPHandler(vaultPath, http.MethodDelete, s.deleteVault),
+		support.NewHTTPHandler(docs, http.MethodPost, s.saveDoc),
+		support.NewHTTPHandler(vaultAuthorizations, http.MethodPost, s.createVaultAuthorization),
+		support.NewHTTPHandler(vaultAuthorizationPath, http.MethodDelete, s.deleteVaultAuthorization),
+		support.NewHTTPHandler(config, http.MethodGet, s.getConfig),
+		support.NewHTTPHandler(authorizations, http.MethodPost, s.createAuthorization),
+		support.NewHTTPHandler(compare, http.MethodPost, s.compare),
+		support.NewHTTPHandler(extract, http.MethodPost, s.extract),
+	}
+}
+
+func (s *Service) createVault(w http.ResponseWriter, _ *http.Request) {
+	resp, err := s.vault.CreateVault()
+	if err != nil {
+		writeErrorResponse(w, err)
+
+		return
+	}
+
+	writeResponse(w, http.StatusCreated, resp)
+}
+
+func (s *Service) deleteVault(w http.ResponseWriter, r *http.Request) {
+	err := s.vault.DeleteVault(mux.Vars(r)["vaultID"])
+	if err != nil {
+		writeErrorResponse(w, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Service) saveDoc(w http.ResponseWriter, r *http.Request) {
+	req := &vaultops.SaveDocRequestBody{}
+
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		writeErrorResponse(w, fmt.Errorf("%s : %w", err.Error(), ErrInvalidRequest))
+
+		return
+	}
+
+	resp, err := s.vault.SaveDoc(mux.Vars(r)["vaultID"], req.ID, req.Content)
+	if err != nil {
+		writeErrorResponse(w, err)
+
+		return
+	}
+
+	writeResponse(w, http.StatusCreated, resp)
+}
+
+func (s *Service) createVaultAuthorization(w http.ResponseWriter, r *http.Request) {
+	req := &vaultops.CreateAuthorizationsBody{}
+
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		writeErrorResponse(w, fmt.Errorf("%s : %w", err.Error(), ErrInvalidRequest))
+
+		return
+	}
+
+	resp, err := s.vault.CreateAuthorization(mux.Vars(r)["vaultID"], req.RequestingParty, &req.Scope)
+	if err != nil {
+		writeErrorResponse(w, err)
+
+		return
+	}
+
+	writeResponse(w, http.StatusCreated, resp)
+}
+
+func (s *Service) deleteVaultAuthorization(w http.ResponseWriter, r *http.Request) {
+	err := s.vault.DeleteAuthorization(mux.Vars(r)["vaultID"], mux.Vars(r)["authorizationID"])
+	if err != nil {
+		writeErrorResponse(w, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Service) getConfig(w http.ResponseWriter, _ *http.Request) {
+	resp, err := s.comparator.GetConfig(nil)
+	if err != nil {
+		writeErrorResponse(w, err)
+
+		return
+	}
+
+	writeResponse(w, http.StatusOK, resp.Payload)
+}
+
+func (s *Service) createAuthorization(w http.ResponseWriter, r *http.Request) {
+	req := &compmodel.Authorization{}
+
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		writeErrorResponse(w, fmt.Errorf("%s : %w", err.Error(), ErrInvalidRequest))
+
+		return
+	}
+
+	resp, err := s.comparator.PostAuthorizations(&compclientops.PostAuthorizationsParams{Authorization: req})
+	if err != nil {
+		writeErrorResponse(w, err)
+
+		return
+	}
+
+	w.Header().Set("Location", resp.Location)
+	writeResponse(w, http.StatusOK, resp.Payload)
+}
+
+func (s *Service) compare(w http.ResponseWriter, r *http.Request) {
+	req := &compmodel.Comparison{}
+
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		writeErrorResponse(w, fmt.Errorf("%s : %w", err.Error(), ErrInvalidRequest))
+
+		return
+	}
+
+	resp, err := s.comparator.PostCompare(&compclientops.PostCompareParams{Comparison: req})
+	if err != nil {
+		writeErrorResponse(w, err)
+
+		return
+	}
+
+	writeResponse(w, http.StatusOK, resp.Payload)
+}
+
+func (s *Service) extract(w http.ResponseWriter, r *http.Request) {
+	req := &compmodel.Extract{}
+
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		writeErrorResponse(w, fmt.Errorf("%s : %w", err.Error(), ErrInvalidRequest))
+
+		return
+	}
+
+	resp, err := s.comparator.PostExtract(&compclientops.PostExtractParams{Extract: req})
+	if err != nil {
+		writeErrorResponse(w, err)
+
+		return
+	}
+
+	writeResponse(w, http.StatusOK, resp.Payload)
+}
+
+// writeErrorResponse writes the error in the error model of the comparator, which the vault client reads as text.
+func writeErrorResponse(w http.ResponseWriter, err error) {
+	logger.Errorf(err.Error())
+
+	status := http.StatusInternalServerError
+
+	switch {
+	case errors.Is(err, ErrInvalidRequest):
+		status = http.StatusBadRequest
+	case errors.Is(err, ErrUnauthorized):
+		status = http.StatusForbidden
+	case errors.Is(err, ErrNotFound):
+		status = http.StatusNotFound
+	}
+
+	writeResponse(w, status, &compmodel.Error{ErrMessage: err.Error()})
+}
+
+func writeResponse(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		logger.Errorf("Unable to send response, %s", err)
+	}
+}
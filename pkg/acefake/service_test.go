@@ -0,0 +1,188 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package acefake
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	httptransport "github.com/go-openapi/runtime/client"
+	"github.com/go-openapi/strfmt"
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	mockstorage "github.com/hyperledger/aries-framework-go/component/storageutil/mock"
+	"github.com/stretchr/testify/require"
+	compclient "github.com/trustbloc/edge-service/pkg/client/comparator/client"
+	compclientops "github.com/trustbloc/edge-service/pkg/client/comparator/client/operations"
+	compmodel "github.com/trustbloc/edge-service/pkg/client/comparator/models"
+	vaultclient "github.com/trustbloc/edge-service/pkg/client/vault"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		s, err := New(mem.NewProvider())
+		require.NoError(t, err)
+		require.NotNil(t, s.Vault())
+		require.NotNil(t, s.Comparator())
+		require.Equal(t, 9, len(s.GetRESTHandlers()))
+	})
+
+	t.Run("open store error", func(t *testing.T) {
+		s, err := New(&mockstorage.Provider{ErrOpenStore: errors.New("open error")})
+		require.EqualError(t, err, "open vault store : open error")
+		require.Nil(t, s)
+	})
+}
+
+func TestService(t *testing.T) {
+	s, err := New(mem.NewProvider())
+	require.NoError(t, err)
+
+	router := mux.NewRouter()
+
+	for _, handler := range s.GetRESTHandlers() {
+		router.HandleFunc(handler.Path(), handler.Handle()).Methods(handler.Method())
+	}
+
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	vClient := vaultclient.New(srv.URL)
+
+	serverURL, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	compClient := compclient.New(httptransport.New(serverURL.Host, compclient.DefaultBasePath,
+		[]string{serverURL.Scheme}), strfmt.Default).Operations
+
+	configResp, err := compClient.GetConfig(compclientops.NewGetConfigParams())
+	require.NoError(t, err)
+	require.Equal(t, s.Comparator().config.AuthKeyURL, configResp.Payload.AuthKeyURL)
+
+	created, err := vClient.CreateVault()
+	require.NoError(t, err)
+
+	t.Run("compare and extract", func(t *testing.T) {
+		_, err = vClient.SaveDoc(created.ID, sampleDocID, sampleDoc("555341212"))
+		require.NoError(t, err)
+
+		vaultAuthz, err := vClient.CreateAuthorization(created.ID, configResp.Payload.AuthKeyURL, readScope(60))
+		require.NoError(t, err)
+
+		docID := sampleDocID
+		requestingParty := sampleAuthorizedParty
+		scope := &compmodel.Scope{
+			Actions:     []string{"compare"},
+			VaultID:     created.ID,
+			DocID:       &docID,
+			AuthTokens:  &compmodel.ScopeAuthTokens{Edv: vaultAuthz.Tokens.EDV, Kms: vaultAuthz.Tokens.KMS},
+			DocAttrPath: sampleAttrPath,
+		}
+
+		authResp, err := compClient.PostAuthorizations(compclientops.NewPostAuthorizationsParams().
+			WithAuthorization(&compmodel.Authorization{RequestingParty: &requestingParty, Scope: scope}))
+		require.NoError(t, err)
+		require.NotEmpty(t, authResp.Payload.AuthToken)
+
+		otherVault, err := vClient.CreateVault()
+		require.NoError(t, err)
+
+		query := docQuery(t, s.Comparator(), otherVault.ID, "555341212")
+
+		compareResp, err := compClient.PostCompare(compareParams(query, authResp.Payload.AuthToken))
+		require.NoError(t, err)
+		require.True(t, compareResp.Payload.Result)
+
+		extractQuery := &compmodel.AuthorizedQuery{AuthToken: &authResp.Payload.AuthToken}
+		extractQuery.SetID("query1")
+
+		extract := &compmodel.Extract{}
+		extract.SetQueries([]compmodel.Query{extractQuery})
+
+		extractResp, err := compClient.PostExtract(compclientops.NewPostExtractParams().WithExtract(extract))
+		require.NoError(t, err)
+		require.Len(t, extractResp.Payload.Documents, 1)
+		require.Equal(t, "555341212", extractResp.Payload.Documents[0].Contents)
+
+		resp, err := doRequest(http.MethodDelete, srv.URL+"/vaults/"+created.ID+"/authorizations/"+vaultAuthz.ID, "")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+
+		_, err = compClient.PostCompare(compareParams(query, authResp.Payload.AuthToken))
+		require.Error(t, err)
+	})
+
+	t.Run("vault not found", func(t *testing.T) {
+		_, err := vClient.SaveDoc("did:key:invalid", sampleDocID, sampleDoc("555341212"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "vault did:key:invalid : not found")
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		token := "invalid"
+		query := &compmodel.AuthorizedQuery{AuthToken: &token}
+
+		extract := &compmodel.Extract{}
+		extract.SetQueries([]compmodel.Query{query})
+
+		_, err := compClient.PostExtract(compclientops.NewPostExtractParams().WithExtract(extract))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "403")
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		for _, path := range []string{"/vaults/" + created.ID + "/docs", "/vaults/" + created.ID + "/authorizations",
+			"/authorizations", "/compare", "/extract"} {
+			resp, err := doRequest(http.MethodPost, srv.URL+path, "{")
+			require.NoError(t, err)
+			require.Equal(t, http.StatusBadRequest, resp.StatusCode, path)
+
+			errResp := &compmodel.Error{}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(errResp))
+			require.NoError(t, resp.Body.Close())
+			require.NotEmpty(t, errResp.ErrMessage)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		resp, err := doRequest(http.MethodDelete, srv.URL+"/vaults/"+created.ID+"/authorizations/invalid", "")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+
+		resp, err = doRequest(http.MethodDelete, srv.URL+"/vaults/"+created.ID, "")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+
+		resp, err = doRequest(http.MethodDelete, srv.URL+"/vaults/"+created.ID, "")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+	})
+}
+
+func TestWriteErrorResponse(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeErrorResponse(w, errors.New("store error"))
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func doRequest(method, target, body string) (*http.Response, error) {
+	req, err := http.NewRequest(method, target, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	return http.DefaultClient.Do(req)
+}
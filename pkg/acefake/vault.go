@@ -0,0 +1,310 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package acefake
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/trustbloc/edge-core/pkg/zcapld"
+	"github.com/trustbloc/edge-service/pkg/restapi/vault"
+)
+
+const (
+	vaultStoreName = "acefake_vault"
+
+	docKeyPrefix   = "doc_"
+	authzKeyPrefix = "authz_"
+	tokenKeyPrefix = "token_"
+
+	actionRead = "read"
+)
+
+var (
+	// ErrNotFound is returned when the vault, the document or the authorization doesn't exist.
+	ErrNotFound = errors.New("not found")
+	// ErrUnauthorized is returned when a token doesn't authorize the access to a document.
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// Vault is a stand-in for the vault server. The documents are saved unencrypted in the store, and the
+// authorizations are random tokens instead of zcaps.
+type Vault struct {
+	store storage.Store
+	mu    sync.Mutex
+	now   func() time.Time
+}
+
+type vaultData struct {
+	ID             string   `json:"id"`
+	Docs           []string `json:"docs"`
+	Authorizations []string `json:"authorizations"`
+}
+
+type vaultAuthorization struct {
+	ID              string     `json:"id"`
+	VaultID         string     `json:"vaultID"`
+	DocID           string     `json:"docID"`
+	RequestingParty string     `json:"requestingParty"`
+	Actions         []string   `json:"actions"`
+	EDVToken        string     `json:"edvToken"`
+	KMSToken        string     `json:"kmsToken"`
+	Expiry          *time.Time `json:"expiry,omitempty"`
+}
+
+// NewVault creates a vault stand-in backed by the store provider.
+func NewVault(provider storage.Provider) (*Vault, error) {
+	store, err := provider.OpenStore(vaultStoreName)
+	if err != nil {
+		return nil, fmt.Errorf("open vault store : %w", err)
+	}
+
+	return &Vault{store: store, now: time.Now}, nil
+}
+
+// CreateVault creates a vault. The id of the vault is a did:key DID, which resolves without a DID resolver.
+func (v *Vault) CreateVault() (*vault.CreatedVault, error) {
+	id, _, err := newDIDKey()
+	if err != nil {
+		return nil, err
+	}
+
+	err = putJSON(v.store, id, &vaultData{ID: id})
+	if err != nil {
+		return nil, fmt.Errorf("save vault : %w", err)
+	}
+
+	return &vault.CreatedVault{ID: id}, nil
+}
+
+// SaveDoc saves the document in the vault.
+func (v *Vault) SaveDoc(vaultID, id string, content interface{}) (*vault.DocumentMetadata, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	data, err := v.getVault(vaultID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = putJSON(v.store, docKey(vaultID, id), content)
+	if err != nil {
+		return nil, fmt.Errorf("save doc : %w", err)
+	}
+
+	if !contains(data.Docs, id) {
+		data.Docs = append(data.Docs, id)
+
+		err = putJSON(v.store, vaultID, data)
+		if err != nil {
+			return nil, fmt.Errorf("save vault : %w", err)
+		}
+	}
+
+	return &vault.DocumentMetadata{ID: id, URI: fmt.Sprintf("/vaults/%s/docs/%s", vaultID, id)}, nil
+}
+
+// CreateAuthorization authorizes the requesting party to access the target document of the scope. The expiry
+// caveat of the scope is enforced.
+func (v *Vault) CreateAuthorization(vaultID, requestingParty string,
+	scope *vault.AuthorizationsScope) (*vault.CreatedAuthorization, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	data, err := v.getVault(vaultID)
+	if err != nil {
+		return nil, err
+	}
+
+	if scope == nil || !contains(data.Docs, scope.Target) {
+		return nil, fmt.Errorf("doc : %w", ErrNotFound)
+	}
+
+	authz := &vaultAuthorization{
+		ID:              uuid.NewString(),
+		VaultID:         vaultID,
+		DocID:           scope.Target,
+		RequestingParty: requestingParty,
+		Actions:         scope.Actions,
+		EDVToken:        newToken(),
+		KMSToken:        newToken(),
+	}
+
+	for _, c := range scope.Caveats {
+		if c.Type == zcapld.CaveatTypeExpiry {
+			authz.Expiry = expiry(v.now(), int64(c.Duration))
+		}
+	}
+
+	err = putJSON(v.store, authzKeyPrefix+authz.ID, authz)
+	if err != nil {
+		return nil, fmt.Errorf("save authorization : %w", err)
+	}
+
+	err = v.store.Put(tokenKeyPrefix+authz.EDVToken, []byte(authz.ID))
+	if err != nil {
+		return nil, fmt.Errorf("save authorization token : %w", err)
+	}
+
+	data.Authorizations = append(data.Authorizations, authz.ID)
+
+	err = putJSON(v.store, vaultID, data)
+	if err != nil {
+		return nil, fmt.Errorf("save vault : %w", err)
+	}
+
+	return &vault.CreatedAuthorization{
+		ID:              authz.ID,
+		Scope:           scope,
+		RequestingParty: requestingParty,
+		Tokens:          &vault.Tokens{EDV: authz.EDVToken, KMS: authz.KMSToken},
+	}, nil
+}
+
+// DeleteAuthorization revokes the authorization.
+func (v *Vault) DeleteAuthorization(vaultID, authorizationID string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	data, err := v.getVault(vaultID)
+	if err != nil {
+		return err
+	}
+
+	if !contains(data.Authorizations, authorizationID) {
+		return fmt.Errorf("authorization : %w", ErrNotFound)
+	}
+
+	err = v.deleteAuthorization(authorizationID)
+	if err != nil {
+		return err
+	}
+
+	data.Authorizations = remove(data.Authorizations, authorizationID)
+
+	err = putJSON(v.store, vaultID, data)
+	if err != nil {
+		return fmt.Errorf("save vault : %w", err)
+	}
+
+	return nil
+}
+
+// DeleteVault deletes the vault with its documents and authorizations.
+func (v *Vault) DeleteVault(vaultID string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	data, err := v.getVault(vaultID)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range data.Authorizations {
+		err = v.deleteAuthorization(id)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, id := range data.Docs {
+		err = v.store.Delete(docKey(vaultID, id))
+		if err != nil {
+			return fmt.Errorf("delete doc : %w", err)
+		}
+	}
+
+	err = v.store.Delete(vaultID)
+	if err != nil {
+		return fmt.Errorf("delete vault : %w", err)
+	}
+
+	return nil
+}
+
+// readDoc returns the document if the edv token authorizes the requesting party to read it.
+func (v *Vault) readDoc(vaultID, docID, edvToken, requestingParty string) (interface{}, error) {
+	idBytes, err := v.store.Get(tokenKeyPrefix + edvToken)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		return nil, fmt.Errorf("invalid edv token : %w", ErrUnauthorized)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("get authorization token : %w", err)
+	}
+
+	authz := &vaultAuthorization{}
+
+	err = getJSON(v.store, authzKeyPrefix+string(idBytes), authz)
+	if err != nil {
+		return nil, fmt.Errorf("get authorization : %w", err)
+	}
+
+	switch {
+	case authz.VaultID != vaultID || authz.DocID != docID:
+		return nil, fmt.Errorf("edv token is for another doc : %w", ErrUnauthorized)
+	case authz.RequestingParty != requestingParty:
+		return nil, fmt.Errorf("edv token is for another requesting party : %w", ErrUnauthorized)
+	case !contains(authz.Actions, actionRead):
+		return nil, fmt.Errorf("edv token doesn't authorize the read action : %w", ErrUnauthorized)
+	case expired(authz.Expiry, v.now()):
+		return nil, fmt.Errorf("edv token expired : %w", ErrUnauthorized)
+	}
+
+	var doc interface{}
+
+	err = getJSON(v.store, docKey(vaultID, docID), &doc)
+	if err != nil {
+		return nil, fmt.Errorf("get doc : %w", err)
+	}
+
+	return doc, nil
+}
+
+func (v *Vault) getVault(vaultID string) (*vaultData, error) {
+	data := &vaultData{}
+
+	err := getJSON(v.store, vaultID, data)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		return nil, fmt.Errorf("vault %s : %w", vaultID, ErrNotFound)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("get vault : %w", err)
+	}
+
+	return data, nil
+}
+
+func (v *Vault) deleteAuthorization(id string) error {
+	authz := &vaultAuthorization{}
+
+	err := getJSON(v.store, authzKeyPrefix+id, authz)
+	if err != nil {
+		return fmt.Errorf("get authorization : %w", err)
+	}
+
+	err = v.store.Delete(tokenKeyPrefix + authz.EDVToken)
+	if err != nil {
+		return fmt.Errorf("delete authorization token : %w", err)
+	}
+
+	err = v.store.Delete(authzKeyPrefix + id)
+	if err != nil {
+		return fmt.Errorf("delete authorization : %w", err)
+	}
+
+	return nil
+}
+
+func docKey(vaultID, docID string) string {
+	return docKeyPrefix + vaultID + "_" + docID
+}
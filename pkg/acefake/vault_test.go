@@ -0,0 +1,276 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package acefake
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	mockstorage "github.com/hyperledger/aries-framework-go/component/storageutil/mock"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-core/pkg/zcapld"
+	"github.com/trustbloc/edge-service/pkg/restapi/vault"
+)
+
+const (
+	sampleDocID           = "nationalID"
+	sampleRequestingParty = "did:example:rp#key1"
+)
+
+func TestNewVault(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		v, err := NewVault(mem.NewProvider())
+		require.NoError(t, err)
+		require.NotNil(t, v)
+	})
+
+	t.Run("open store error", func(t *testing.T) {
+		v, err := NewVault(&mockstorage.Provider{ErrOpenStore: errors.New("open error")})
+		require.EqualError(t, err, "open vault store : open error")
+		require.Nil(t, v)
+	})
+}
+
+func TestVault_CreateVault(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		v := newTestVault(t)
+
+		created, err := v.CreateVault()
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(created.ID, "did:key:"))
+
+		other, err := v.CreateVault()
+		require.NoError(t, err)
+		require.NotEqual(t, created.ID, other.ID)
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		v, err := NewVault(&mockstorage.Provider{OpenStoreReturn: &mockstorage.Store{ErrPut: errors.New("put error")}})
+		require.NoError(t, err)
+
+		_, err = v.CreateVault()
+		require.EqualError(t, err, "save vault : put error")
+	})
+}
+
+func TestVault_SaveDoc(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		v := newTestVault(t)
+		vaultID := createVault(t, v)
+
+		meta, err := v.SaveDoc(vaultID, sampleDocID, sampleDoc("555341212"))
+		require.NoError(t, err)
+		require.Equal(t, sampleDocID, meta.ID)
+		require.Equal(t, "/vaults/"+vaultID+"/docs/"+sampleDocID, meta.URI)
+
+		// the doc is replaced
+		_, err = v.SaveDoc(vaultID, sampleDocID, sampleDoc("555341213"))
+		require.NoError(t, err)
+
+		data, err := v.getVault(vaultID)
+		require.NoError(t, err)
+		require.Equal(t, []string{sampleDocID}, data.Docs)
+	})
+
+	t.Run("vault not found", func(t *testing.T) {
+		v := newTestVault(t)
+
+		_, err := v.SaveDoc("did:key:invalid", sampleDocID, sampleDoc("555341212"))
+		require.True(t, errors.Is(err, ErrNotFound))
+	})
+}
+
+func TestVault_CreateAuthorization(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		v := newTestVault(t)
+		vaultID := createVaultWithDoc(t, v)
+
+		authz, err := v.CreateAuthorization(vaultID, sampleRequestingParty, readScope(0))
+		require.NoError(t, err)
+		require.NotEmpty(t, authz.ID)
+		require.Equal(t, sampleRequestingParty, authz.RequestingParty)
+		require.NotEmpty(t, authz.Tokens.EDV)
+		require.NotEmpty(t, authz.Tokens.KMS)
+
+		doc, err := v.readDoc(vaultID, sampleDocID, authz.Tokens.EDV, sampleRequestingParty)
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{"nationalID": "555341212"}, doc)
+	})
+
+	t.Run("doc not found", func(t *testing.T) {
+		v := newTestVault(t)
+		vaultID := createVault(t, v)
+
+		_, err := v.CreateAuthorization(vaultID, sampleRequestingParty, readScope(0))
+		require.True(t, errors.Is(err, ErrNotFound))
+
+		_, err = v.CreateAuthorization(vaultID, sampleRequestingParty, nil)
+		require.True(t, errors.Is(err, ErrNotFound))
+	})
+
+	t.Run("vault not found", func(t *testing.T) {
+		v := newTestVault(t)
+
+		_, err := v.CreateAuthorization("did:key:invalid", sampleRequestingParty, readScope(0))
+		require.True(t, errors.Is(err, ErrNotFound))
+	})
+}
+
+func TestVault_ReadDoc(t *testing.T) {
+	v := newTestVault(t)
+	vaultID := createVaultWithDoc(t, v)
+
+	authz, err := v.CreateAuthorization(vaultID, sampleRequestingParty, readScope(60))
+	require.NoError(t, err)
+
+	t.Run("invalid token", func(t *testing.T) {
+		_, err := v.readDoc(vaultID, sampleDocID, "invalid", sampleRequestingParty)
+		require.True(t, errors.Is(err, ErrUnauthorized))
+		require.Contains(t, err.Error(), "invalid edv token")
+	})
+
+	t.Run("another doc", func(t *testing.T) {
+		_, err := v.readDoc(vaultID, "passport", authz.Tokens.EDV, sampleRequestingParty)
+		require.True(t, errors.Is(err, ErrUnauthorized))
+		require.Contains(t, err.Error(), "edv token is for another doc")
+	})
+
+	t.Run("another requesting party", func(t *testing.T) {
+		_, err := v.readDoc(vaultID, sampleDocID, authz.Tokens.EDV, "did:example:other#key1")
+		require.True(t, errors.Is(err, ErrUnauthorized))
+		require.Contains(t, err.Error(), "edv token is for another requesting party")
+	})
+
+	t.Run("no read action", func(t *testing.T) {
+		scope := readScope(0)
+		scope.Actions = []string{"write"}
+
+		writeAuthz, err := v.CreateAuthorization(vaultID, sampleRequestingParty, scope)
+		require.NoError(t, err)
+
+		_, err = v.readDoc(vaultID, sampleDocID, writeAuthz.Tokens.EDV, sampleRequestingParty)
+		require.True(t, errors.Is(err, ErrUnauthorized))
+		require.Contains(t, err.Error(), "doesn't authorize the read action")
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		v.now = func() time.Time { return time.Now().Add(time.Hour) }
+		defer func() { v.now = time.Now }()
+
+		_, err := v.readDoc(vaultID, sampleDocID, authz.Tokens.EDV, sampleRequestingParty)
+		require.True(t, errors.Is(err, ErrUnauthorized))
+		require.Contains(t, err.Error(), "edv token expired")
+	})
+}
+
+func TestVault_DeleteAuthorization(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		v := newTestVault(t)
+		vaultID := createVaultWithDoc(t, v)
+
+		authz, err := v.CreateAuthorization(vaultID, sampleRequestingParty, readScope(0))
+		require.NoError(t, err)
+
+		err = v.DeleteAuthorization(vaultID, authz.ID)
+		require.NoError(t, err)
+
+		_, err = v.readDoc(vaultID, sampleDocID, authz.Tokens.EDV, sampleRequestingParty)
+		require.True(t, errors.Is(err, ErrUnauthorized))
+
+		err = v.DeleteAuthorization(vaultID, authz.ID)
+		require.True(t, errors.Is(err, ErrNotFound))
+	})
+
+	t.Run("vault not found", func(t *testing.T) {
+		v := newTestVault(t)
+
+		err := v.DeleteAuthorization("did:key:invalid", "authz")
+		require.True(t, errors.Is(err, ErrNotFound))
+	})
+}
+
+func TestVault_DeleteVault(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		v := newTestVault(t)
+		vaultID := createVaultWithDoc(t, v)
+
+		authz, err := v.CreateAuthorization(vaultID, sampleRequestingParty, readScope(0))
+		require.NoError(t, err)
+
+		err = v.DeleteVault(vaultID)
+		require.NoError(t, err)
+
+		_, err = v.readDoc(vaultID, sampleDocID, authz.Tokens.EDV, sampleRequestingParty)
+		require.True(t, errors.Is(err, ErrUnauthorized))
+
+		err = v.DeleteVault(vaultID)
+		require.True(t, errors.Is(err, ErrNotFound))
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		v := newTestVault(t)
+		vaultID := createVaultWithDoc(t, v)
+
+		v.store = &failingDeleteStore{Store: v.store}
+
+		err := v.DeleteVault(vaultID)
+		require.EqualError(t, err, "delete doc : delete error")
+	})
+}
+
+type failingDeleteStore struct {
+	storage.Store
+}
+
+func (s *failingDeleteStore) Delete(string) error {
+	return errors.New("delete error")
+}
+
+func newTestVault(t *testing.T) *Vault {
+	t.Helper()
+
+	v, err := NewVault(mem.NewProvider())
+	require.NoError(t, err)
+
+	return v
+}
+
+func createVault(t *testing.T, v *Vault) string {
+	t.Helper()
+
+	created, err := v.CreateVault()
+	require.NoError(t, err)
+
+	return created.ID
+}
+
+func createVaultWithDoc(t *testing.T, v *Vault) string {
+	t.Helper()
+
+	vaultID := createVault(t, v)
+
+	_, err := v.SaveDoc(vaultID, sampleDocID, sampleDoc("555341212"))
+	require.NoError(t, err)
+
+	return vaultID
+}
+
+func sampleDoc(nationalID string) map[string]interface{} {
+	return map[string]interface{}{"nationalID": nationalID}
+}
+
+func readScope(expirySecs int) *vault.AuthorizationsScope {
+	return &vault.AuthorizationsScope{
+		Target:  sampleDocID,
+		Actions: []string{"read"},
+		Caveats: []vault.Caveat{{Type: zcapld.CaveatTypeExpiry, Duration: uint64(expirySecs)}},
+	}
+}
@@ -23,12 +23,18 @@ func New(config *operation.Config) (*Controller, error) {
 
 	allHandlers = append(allHandlers, aceRpService.GetRESTHandlers()...)
 
-	return &Controller{handlers: allHandlers}, nil
+	return &Controller{handlers: allHandlers, service: aceRpService}, nil
 }
 
 // Controller contains handlers for controller
 type Controller struct {
 	handlers []operation.Handler
+	service  *operation.Operation
+}
+
+// Close stops the background jobs of the controller.
+func (c *Controller) Close() {
+	c.service.Close()
 }
 
 // GetOperations returns all controller endpoints
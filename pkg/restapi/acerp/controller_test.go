@@ -19,7 +19,7 @@ import (
 func TestController_New(t *testing.T) {
 	t.Run("success", func(t *testing.T) {
 		controller, err := New(&operation.Config{
-			StoreProvider: &mockstorage.Provider{},
+			StoreProvider: &mockstorage.Provider{OpenStoreReturn: &mockstorage.Store{QueryReturn: &mockstorage.Iterator{}}},
 			ComparatorURL: "http://comp.example.com",
 		})
 		require.NoError(t, err)
@@ -38,12 +38,22 @@ func TestController_New(t *testing.T) {
 
 func TestController_GetOperations(t *testing.T) {
 	controller, err := New(&operation.Config{
-		StoreProvider: &mockstorage.Provider{},
+		StoreProvider: &mockstorage.Provider{OpenStoreReturn: &mockstorage.Store{QueryReturn: &mockstorage.Iterator{}}},
 		ComparatorURL: "http://comp.example.com",
 	})
 	require.NoError(t, err)
 	require.NotNil(t, controller)
 
 	ops := controller.GetOperations()
-	require.Equal(t, 19, len(ops))
+	require.Equal(t, 43, len(ops))
+}
+
+func TestController_Close(t *testing.T) {
+	controller, err := New(&operation.Config{
+		StoreProvider: &mockstorage.Provider{OpenStoreReturn: &mockstorage.Store{QueryReturn: &mockstorage.Iterator{}}},
+		ComparatorURL: "http://comp.example.com",
+	})
+	require.NoError(t, err)
+
+	controller.Close()
 }
@@ -0,0 +1,257 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/sandbox/pkg/token"
+)
+
+// Role is the role of the callers of the management endpoints.
+type Role string
+
+const (
+	// RoleOperator manages the clients, the profiles and the users, and extracts the documents.
+	RoleOperator Role = "operator"
+	// RoleAuditor reads the users, the account links and the extract requests.
+	RoleAuditor Role = "auditor"
+	// RolePartnerService is the role of the services of the other departments, which submit the user
+	// authorizations.
+	RolePartnerService Role = "partner-service"
+)
+
+const bearerPrefix = "Bearer "
+
+var (
+	errUnauthenticated = errors.New("missing or invalid credentials")
+	errForbidden       = errors.New("insufficient role")
+)
+
+// APIKey is an admin API key with the role of its holder.
+type APIKey struct {
+	Key  string
+	Role Role
+}
+
+// TokenResolver resolves the OAuth bearer tokens of the callers of the management endpoints.
+type TokenResolver interface {
+	Resolve(token string) (*token.Introspection, error)
+}
+
+// ParseRole returns the role of the name.
+func ParseRole(name string) (Role, error) {
+	switch r := Role(name); r {
+	case RoleOperator, RoleAuditor, RolePartnerService:
+		return r, nil
+	default:
+		return "", fmt.Errorf("unsupported role %s", name)
+	}
+}
+
+// principal is the authenticated caller of a management endpoint. The profile is set for the partner services.
+type principal struct {
+	roles     []Role
+	profileID string
+}
+
+func (p *principal) hasRole(roles ...Role) bool {
+	for _, r := range roles {
+		for _, pr := range p.roles {
+			if pr == r {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+type principalKey struct{}
+
+// requireRole only lets the callers with one of the roles through to the handler, which gets the caller with
+// principalOf.
+func (o *Operation) requireRole(handle http.HandlerFunc, roles ...Role) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if p, ok := o.requirePrincipal(w, r, roles...); ok {
+			handle(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+		}
+	}
+}
+
+// principalOf returns the caller authenticated by requireRole, or an anonymous principal.
+func principalOf(r *http.Request) *principal {
+	if p, ok := r.Context().Value(principalKey{}).(*principal); ok {
+		return p
+	}
+
+	return &principal{}
+}
+
+// requirePartner returns the profile of the partner service calling the endpoint. The partner credentials must be
+// tied to a profile registered at this service.
+func (o *Operation) requirePartner(w http.ResponseWriter, r *http.Request) (*profileData, bool) {
+	p, ok := o.requirePrincipal(w, r, RolePartnerService)
+	if !ok {
+		return nil, false
+	}
+
+	pData, err := o.getProfileData(p.profileID)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusForbidden,
+			fmt.Sprintf("partner credentials aren't tied to a registered profile : %s", err.Error()))
+
+		return nil, false
+	}
+
+	return pData, true
+}
+
+// requirePrincipal authenticates the caller, and checks that it has one of the roles.
+func (o *Operation) requirePrincipal(w http.ResponseWriter, r *http.Request, roles ...Role) (*principal, bool) {
+	p, err := o.authenticatePrincipal(r)
+
+	switch {
+	case errors.Is(err, errUnauthenticated), errors.Is(err, errInvalidClient), errors.Is(err, token.ErrInvalidToken):
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		o.writeErrorResponse(w, http.StatusUnauthorized, err.Error())
+
+		return nil, false
+	case err != nil:
+		o.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to authenticate : %s", err.Error()))
+
+		return nil, false
+	}
+
+	if !p.hasRole(roles...) {
+		o.writeErrorResponse(w, http.StatusForbidden, errForbidden.Error())
+
+		return nil, false
+	}
+
+	return p, true
+}
+
+// requesterDID returns the DID of the profile of the principal. It is empty for the callers which aren't tied to a
+// registered profile, like the holders of the admin API keys.
+func (o *Operation) requesterDID(p *principal) (string, error) {
+	if p.profileID == "" {
+		return "", nil
+	}
+
+	pData, err := o.getProfileData(p.profileID)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return pData.DID, nil
+}
+
+// authenticatePrincipal authenticates the caller with an admin API key or an OAuth bearer token, or with the client
+// credentials of a partner service. The roles of a token are mapped from its scopes, and the profile of a partner
+// service token is the one its client is tied to.
+func (o *Operation) authenticatePrincipal(r *http.Request) (*principal, error) {
+	if _, _, ok := r.BasicAuth(); ok {
+		return o.authenticatePartnerClient(r)
+	}
+
+	authHeader := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authHeader, bearerPrefix) {
+		return nil, errUnauthenticated
+	}
+
+	credential := strings.TrimPrefix(authHeader, bearerPrefix)
+
+	for _, k := range o.adminAPIKeys {
+		if subtle.ConstantTimeCompare([]byte(k.Key), []byte(credential)) == 1 {
+			return &principal{roles: []Role{k.Role}}, nil
+		}
+	}
+
+	if o.tokenResolver == nil {
+		return nil, errUnauthenticated
+	}
+
+	info, err := o.tokenResolver.Resolve(credential)
+	if err != nil {
+		return nil, fmt.Errorf("resolve token : %w", err)
+	}
+
+	err = info.Validate(time.Now(), o.tokenIssuer, o.tokenAudience)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &principal{}
+
+	for _, scope := range strings.Fields(info.Scope) {
+		if role, ok := o.tokenScopeRoles[scope]; ok && role != RolePartnerService {
+			p.roles = append(p.roles, role)
+		}
+	}
+
+	if !o.grantsPartnerRole(info) {
+		return p, nil
+	}
+
+	cData, err := o.getClientData(info.ClientID)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		return p, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// like the client credentials, the token is a partner's only if its client is tied to a profile
+	if cData.ProfileID != "" {
+		p.roles = append(p.roles, RolePartnerService)
+		p.profileID = cData.ProfileID
+	}
+
+	return p, nil
+}
+
+func (o *Operation) grantsPartnerRole(info *token.Introspection) bool {
+	if info.ClientID == "" {
+		return false
+	}
+
+	for _, scope := range strings.Fields(info.Scope) {
+		if o.tokenScopeRoles[scope] == RolePartnerService {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authenticatePartnerClient authenticates a partner service with the credentials of a client tied to a profile.
+func (o *Operation) authenticatePartnerClient(r *http.Request) (*principal, error) {
+	cData, err := o.authenticateClient(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if cData.ProfileID == "" {
+		return &principal{}, nil
+	}
+
+	return &principal{roles: []Role{RolePartnerService}, profileID: cData.ProfileID}, nil
+}
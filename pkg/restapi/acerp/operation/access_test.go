@@ -0,0 +1,367 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	mockstorage "github.com/hyperledger/aries-framework-go/component/storageutil/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/sandbox/pkg/token"
+)
+
+const (
+	samplePartnerProfile = "partner-profile"
+	sampleTokenIssuer    = "https://hydra.example.com/"
+	sampleOperatorKey    = "operator-key"
+	sampleAuditorKey     = "auditor-key"
+)
+
+func TestParseRole(t *testing.T) {
+	for _, name := range []string{"operator", "auditor", "partner-service"} {
+		role, err := ParseRole(name)
+		require.NoError(t, err)
+		require.Equal(t, Role(name), role)
+	}
+
+	_, err := ParseRole("admin")
+	require.EqualError(t, err, "unsupported role admin")
+}
+
+func TestRequireRole(t *testing.T) {
+	svc, err := New(&Config{
+		StoreProvider: mem.NewProvider(),
+		ComparatorURL: "http://comp.example.com",
+		AdminAPIKeys: []APIKey{
+			{Key: sampleOperatorKey, Role: RoleOperator},
+			{Key: sampleAuditorKey, Role: RoleAuditor},
+		},
+	})
+	require.NoError(t, err)
+
+	handle := func(method, path, apiKey string) int {
+		t.Helper()
+
+		for _, h := range svc.GetRESTHandlers() {
+			if h.Method() != method || h.Path() != path {
+				continue
+			}
+
+			req := httptest.NewRequest(method, path, nil)
+			if apiKey != "" {
+				req.Header.Set("Authorization", "Bearer "+apiKey)
+			}
+
+			rr := httptest.NewRecorder()
+			h.Handle()(rr, req)
+
+			return rr.Code
+		}
+
+		require.Failf(t, "handler not found", "%s %s", method, path)
+
+		return 0
+	}
+
+	t.Run("operator", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, handle(http.MethodGet, users, sampleOperatorKey))
+		require.Equal(t, http.StatusBadRequest, handle(http.MethodPost, client, sampleOperatorKey))
+		require.Equal(t, http.StatusBadRequest, handle(http.MethodPost, extractJobs, sampleOperatorKey))
+	})
+
+	t.Run("auditor", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, handle(http.MethodGet, users, sampleAuditorKey))
+		require.Equal(t, http.StatusOK, handle(http.MethodGet, userExtract, sampleAuditorKey))
+		require.Equal(t, http.StatusOK, handle(http.MethodGet, links, sampleAuditorKey))
+		require.Equal(t, http.StatusOK, handle(http.MethodGet, audit, sampleAuditorKey))
+
+		for _, h := range []struct{ method, path string }{
+			{http.MethodPost, client},
+			{http.MethodGet, profile},
+			{http.MethodPost, generateUserAuth},
+			{http.MethodPost, extractJobs},
+			{http.MethodGet, getExtractJob},
+			{http.MethodGet, userDataExport},
+			{http.MethodDelete, getUser},
+			{http.MethodPost, userImport},
+		} {
+			require.Equal(t, http.StatusForbidden, handle(h.method, h.path, sampleAuditorKey), h.path)
+		}
+	})
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		require.Equal(t, http.StatusUnauthorized, handle(http.MethodGet, users, ""))
+		require.Equal(t, http.StatusUnauthorized, handle(http.MethodGet, users, "invalid-key"))
+		require.Equal(t, http.StatusUnauthorized, handle(http.MethodGet, profile, ""))
+	})
+
+	t.Run("public endpoints", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, handle(http.MethodGet, docTypes, ""))
+	})
+}
+
+func TestAuthenticatePrincipal(t *testing.T) {
+	newOperation := func(t *testing.T, resolver TokenResolver) *Operation {
+		t.Helper()
+
+		svc, err := New(&Config{
+			StoreProvider: mem.NewProvider(),
+			ComparatorURL: "http://comp.example.com",
+			AdminAPIKeys:  []APIKey{{Key: sampleOperatorKey, Role: RoleOperator}},
+			TokenResolver: resolver,
+			TokenIssuer:   sampleTokenIssuer,
+			TokenAudience: "ace-rp",
+			TokenScopeRoles: map[string]Role{
+				"ace:audit":   RoleAuditor,
+				"ace:partner": RolePartnerService,
+			},
+		})
+		require.NoError(t, err)
+
+		return svc
+	}
+
+	newToken := func(scope, clientID string) *token.Introspection {
+		return &token.Introspection{
+			Active:    true,
+			Scope:     scope,
+			ClientID:  clientID,
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			Issuer:    sampleTokenIssuer,
+			Audience:  []string{"ace-rp"},
+		}
+	}
+
+	bearerRequest := func(tk string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, users, nil)
+		req.Header.Set("Authorization", "Bearer "+tk)
+
+		return req
+	}
+
+	t.Run("api key", func(t *testing.T) {
+		p, err := newOperation(t, nil).authenticatePrincipal(bearerRequest(sampleOperatorKey))
+		require.NoError(t, err)
+		require.True(t, p.hasRole(RoleOperator))
+		require.False(t, p.hasRole(RoleAuditor, RolePartnerService))
+	})
+
+	t.Run("token", func(t *testing.T) {
+		resolver := &mockTokenResolver{info: newToken("openid operator ace:audit ace:partner", "partner-client")}
+
+		svc := newOperation(t, resolver)
+
+		// the partner client is tied to the partner profile
+		newPartnerRequest(t, svc, userAuth, nil)
+
+		p, err := svc.authenticatePrincipal(bearerRequest("token"))
+		require.NoError(t, err)
+		require.Equal(t, []Role{RoleAuditor, RolePartnerService}, p.roles)
+		require.Equal(t, samplePartnerProfile, p.profileID)
+		require.Equal(t, "token", resolver.token)
+	})
+
+	t.Run("partner token of a client without profile", func(t *testing.T) {
+		for _, clientID := range []string{"", "unknown", "client1"} {
+			svc := newOperation(t, &mockTokenResolver{info: newToken("ace:audit ace:partner", clientID)})
+			require.NoError(t, svc.saveClientData(&clientData{ClientID: "client1"}))
+
+			p, err := svc.authenticatePrincipal(bearerRequest("token"))
+			require.NoError(t, err)
+			require.Equal(t, []Role{RoleAuditor}, p.roles, clientID)
+			require.Empty(t, p.profileID)
+		}
+	})
+
+	t.Run("partner token client error", func(t *testing.T) {
+		svc := newOperation(t, &mockTokenResolver{info: newToken("ace:partner", "partner-client")})
+		svc.clientStore = &mockstorage.Store{ErrGet: errors.New("get error")}
+
+		_, err := svc.authenticatePrincipal(bearerRequest("token"))
+		require.EqualError(t, err, "get client data: get error")
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		info := newToken("ace:audit", "")
+		info.Audience = []string{"other"}
+
+		resolver := &mockTokenResolver{info: info}
+
+		_, err := newOperation(t, resolver).authenticatePrincipal(bearerRequest("token"))
+		require.ErrorIs(t, err, token.ErrInvalidAudience)
+
+		info = newToken("ace:audit", "")
+		info.Issuer = "https://other.example.com/"
+		resolver.info = info
+
+		_, err = newOperation(t, resolver).authenticatePrincipal(bearerRequest("token"))
+		require.ErrorIs(t, err, token.ErrInvalidIssuer)
+
+		resolver.info = &token.Introspection{Active: false, Scope: "operator"}
+
+		_, err = newOperation(t, resolver).authenticatePrincipal(bearerRequest("token"))
+		require.ErrorIs(t, err, token.ErrInactiveToken)
+	})
+
+	t.Run("resolve error", func(t *testing.T) {
+		svc := newOperation(t, &mockTokenResolver{err: errors.New("introspection error")})
+
+		_, err := svc.authenticatePrincipal(bearerRequest("token"))
+		require.EqualError(t, err, "resolve token : introspection error")
+
+		rr := httptest.NewRecorder()
+		svc.requireRole(svc.getUsers, RoleOperator)(rr, bearerRequest("token"))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to authenticate : resolve token : introspection error")
+	})
+
+	t.Run("no token resolver", func(t *testing.T) {
+		_, err := newOperation(t, nil).authenticatePrincipal(bearerRequest("token"))
+		require.ErrorIs(t, err, errUnauthenticated)
+	})
+
+	t.Run("no credentials", func(t *testing.T) {
+		_, err := newOperation(t, nil).authenticatePrincipal(httptest.NewRequest(http.MethodGet, users, nil))
+		require.ErrorIs(t, err, errUnauthenticated)
+	})
+
+	t.Run("partner client", func(t *testing.T) {
+		svc := newOperation(t, nil)
+
+		p, err := svc.authenticatePrincipal(newPartnerRequest(t, svc, userAuth, nil))
+		require.NoError(t, err)
+		require.True(t, p.hasRole(RolePartnerService))
+		require.Equal(t, samplePartnerProfile, p.profileID)
+
+		req := httptest.NewRequest(http.MethodPost, userAuth, nil)
+		req.SetBasicAuth("unknown", "secret")
+
+		_, err = svc.authenticatePrincipal(req)
+		require.ErrorIs(t, err, errInvalidClient)
+	})
+
+	t.Run("client without profile", func(t *testing.T) {
+		svc := newOperation(t, nil)
+
+		secret, err := newClientSecret()
+		require.NoError(t, err)
+
+		require.NoError(t, svc.saveClientData(&clientData{ClientID: "client1", SecretHash: hashClientSecret(secret)}))
+
+		req := httptest.NewRequest(http.MethodPost, userAuth, nil)
+		req.SetBasicAuth("client1", secret)
+
+		p, err := svc.authenticatePrincipal(req)
+		require.NoError(t, err)
+		require.False(t, p.hasRole(RolePartnerService))
+	})
+}
+
+func TestRequirePartner(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		pData, ok := svc.requirePartner(httptest.NewRecorder(), newPartnerRequest(t, svc, userAuth, nil))
+		require.True(t, ok)
+		require.Equal(t, samplePartnerProfile, pData.ID)
+	})
+
+	t.Run("operator", func(t *testing.T) {
+		svc, err := New(&Config{
+			StoreProvider: mem.NewProvider(),
+			ComparatorURL: "http://comp.example.com",
+			AdminAPIKeys:  []APIKey{{Key: sampleOperatorKey, Role: RoleOperator}},
+		})
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, userAuth, nil)
+		req.Header.Set("Authorization", "Bearer "+sampleOperatorKey)
+
+		rr := httptest.NewRecorder()
+
+		_, ok := svc.requirePartner(rr, req)
+		require.False(t, ok)
+		require.Equal(t, http.StatusForbidden, rr.Code)
+	})
+
+	t.Run("profile deleted", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		req := newPartnerRequest(t, svc, userAuth, nil)
+
+		require.NoError(t, svc.profileStore.Delete(samplePartnerProfile))
+
+		rr := httptest.NewRecorder()
+
+		_, ok := svc.requirePartner(rr, req)
+		require.False(t, ok)
+		require.Equal(t, http.StatusForbidden, rr.Code)
+		require.Contains(t, rr.Body.String(), "partner credentials aren't tied to a registered profile")
+	})
+}
+
+func TestRequesterDID(t *testing.T) {
+	svc := newTestOperation(t, withProfile(testProfileData()))
+
+	requester, err := svc.requesterDID(&principal{profileID: "profile1"})
+	require.NoError(t, err)
+	require.Equal(t, "did:example:123", requester)
+
+	// not tied to a profile
+	requester, err = svc.requesterDID(&principal{roles: []Role{RoleOperator}})
+	require.NoError(t, err)
+	require.Empty(t, requester)
+
+	requester, err = svc.requesterDID(&principal{profileID: "unknown"})
+	require.NoError(t, err)
+	require.Empty(t, requester)
+
+	svc.profileStore = &mockstorage.Store{ErrGet: errors.New("get error")}
+
+	_, err = svc.requesterDID(&principal{profileID: "profile1"})
+	require.EqualError(t, err, "get profile data: get error")
+}
+
+// newPartnerRequest returns a request with the credentials of a client tied to the registered partner profile.
+func newPartnerRequest(t *testing.T, svc *Operation, target string, body []byte) *http.Request {
+	t.Helper()
+
+	require.NoError(t, svc.saveProfileData(&profileData{ID: samplePartnerProfile, URL: "https://partner.example.com"}))
+
+	secret, err := newClientSecret()
+	require.NoError(t, err)
+
+	require.NoError(t, svc.saveClientData(&clientData{
+		ClientID:   "partner-client",
+		ProfileID:  samplePartnerProfile,
+		SecretHash: hashClientSecret(secret),
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	req.SetBasicAuth("partner-client", secret)
+
+	return req
+}
+
+type mockTokenResolver struct {
+	info  *token.Introspection
+	err   error
+	token string
+}
+
+func (m *mockTokenResolver) Resolve(tk string) (*token.Introspection, error) {
+	m.token = tk
+
+	return m.info, m.err
+}
@@ -0,0 +1,241 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/trustbloc/edge-core/pkg/zcapld"
+	compmodel "github.com/trustbloc/edge-service/pkg/client/comparator/models"
+	"github.com/trustbloc/edge-service/pkg/restapi/vault"
+)
+
+const (
+	// caveats enforced by the rp, as the vault and the comparator only support the expiry caveat
+	caveatTypeUsage           = "usage"
+	caveatTypeRequestingParty = "requestingParty"
+
+	// maxAuthExpiry is the longest lifetime of the authorizations in seconds.
+	maxAuthExpiry = 24 * 60 * 60
+
+	vaultActionRead  = "read"
+	vaultActionWrite = "write"
+
+	// the comparator authorizations only support the compare action
+	comparatorActionCompare = "compare"
+
+	// authUsageStoreName is the store of the authorization counts of the usage caveats.
+	authUsageStoreName = "auth_usage"
+)
+
+var errAuthPolicy = errors.New("authorization policy")
+
+// expiry returns the lifetime of the authorizations in seconds.
+func (p *authPolicy) expiry() uint64 {
+	if p == nil || p.Expiry == 0 {
+		return authExpiryTime
+	}
+
+	return p.Expiry
+}
+
+// actions returns the actions allowed on the vault document.
+func (p *authPolicy) actions() []string {
+	if p == nil || len(p.Actions) == 0 {
+		return []string{vaultActionRead}
+	}
+
+	return p.Actions
+}
+
+func (p *authPolicy) validate() error {
+	if p == nil {
+		return nil
+	}
+
+	if p.Expiry > maxAuthExpiry {
+		return fmt.Errorf("expiry must be at most %d seconds", maxAuthExpiry)
+	}
+
+	for _, a := range p.Actions {
+		if a != vaultActionRead && a != vaultActionWrite {
+			return fmt.Errorf("unsupported action %s", a)
+		}
+	}
+
+	for _, c := range p.Caveats {
+		switch c.Type {
+		case caveatTypeUsage:
+			if c.MaxUses < 1 {
+				return errors.New("usage caveat needs a positive maxUses")
+			}
+		case caveatTypeRequestingParty:
+			if len(c.RequestingParties) == 0 {
+				return errors.New("requestingParty caveat needs requestingParties")
+			}
+		default:
+			return fmt.Errorf("unsupported caveat type %s", c.Type)
+		}
+	}
+
+	return nil
+}
+
+func (p *authPolicy) vaultScope(docID string) *vault.AuthorizationsScope {
+	return &vault.AuthorizationsScope{
+		Target:  docID,
+		Actions: p.actions(),
+		Caveats: []vault.Caveat{{Type: zcapld.CaveatTypeExpiry, Duration: p.expiry()}},
+	}
+}
+
+func (p *authPolicy) comparatorScope(vaultID, docID, attrPath string, tokens *vault.Tokens) *compmodel.Scope {
+	scope := &compmodel.Scope{
+		Actions:     []string{comparatorActionCompare},
+		VaultID:     vaultID,
+		DocID:       &docID,
+		AuthTokens:  &compmodel.ScopeAuthTokens{Edv: tokens.EDV, Kms: tokens.KMS},
+		DocAttrPath: attrPath,
+	}
+
+	scope.SetCaveats([]compmodel.Caveat{&compmodel.ExpiryCaveat{Duration: int64(p.expiry())}})
+
+	return scope
+}
+
+// enforceAuthPolicy checks the caveats of the profile before an authorization to the document is given to the
+// requesting party. The usage caveat counts the authorizations given for each document: a use is reserved here, and
+// must be given back with the returned function if the authorization can't be given.
+func (o *Operation) enforceAuthPolicy(profile *profileData, docID, requestingParty string) (func(), error) {
+	cancel := func() {}
+
+	if profile.AuthPolicy == nil {
+		return cancel, nil
+	}
+
+	for _, c := range profile.AuthPolicy.Caveats {
+		if c.Type != caveatTypeRequestingParty {
+			continue
+		}
+
+		if requestingParty == "" {
+			return cancel, fmt.Errorf("%w: requesting party isn't identified", errAuthPolicy)
+		}
+
+		if !contains(c.RequestingParties, requestingParty) {
+			return cancel, fmt.Errorf("%w: requesting party %s isn't allowed", errAuthPolicy, requestingParty)
+		}
+	}
+
+	for _, c := range profile.AuthPolicy.Caveats {
+		if c.Type != caveatTypeUsage {
+			continue
+		}
+
+		key := profile.ID + "_" + docID
+
+		err := o.useAuthorization(key, docID, c.MaxUses)
+		if err != nil {
+			return cancel, err
+		}
+
+		return func() { o.cancelAuthorizationUse(key) }, nil
+	}
+
+	return cancel, nil
+}
+
+// writeAuthPolicyError writes the error of enforceAuthPolicy: the requests denied by the policy are forbidden.
+func (o *Operation) writeAuthPolicyError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errAuthPolicy) {
+		o.writeErrorResponse(w, http.StatusForbidden, err.Error())
+
+		return
+	}
+
+	o.writeErrorResponse(w, http.StatusInternalServerError,
+		fmt.Sprintf("failed to enforce authorization policy : %s", err.Error()))
+}
+
+// useAuthorization counts a use of the document. The stores have no conditional writes, so the check and the
+// increment of the count are only atomic under the authorization usage lock of this instance: like the username
+// reservation, the usage caveat requires the ACE RP to run as a single instance, or concurrent requests to two
+// instances could exceed the maximum uses.
+func (o *Operation) useAuthorization(key, docID string, maxUses int) error {
+	o.authUsageMu.Lock()
+	defer o.authUsageMu.Unlock()
+
+	uses, err := o.getAuthorizationUses(key)
+	if err != nil {
+		return err
+	}
+
+	if uses >= maxUses {
+		return fmt.Errorf("%w: document %s has been authorized %d times", errAuthPolicy, docID, uses)
+	}
+
+	err = o.authUsageStore.Put(key, []byte(strconv.Itoa(uses+1)))
+	if err != nil {
+		return fmt.Errorf("save authorization usage : %w", err)
+	}
+
+	return nil
+}
+
+// cancelAuthorizationUse gives back the use of a document whose authorization failed.
+func (o *Operation) cancelAuthorizationUse(key string) {
+	o.authUsageMu.Lock()
+	defer o.authUsageMu.Unlock()
+
+	uses, err := o.getAuthorizationUses(key)
+	if err != nil {
+		logger.Warnf("failed to cancel authorization use : %s", err.Error())
+
+		return
+	}
+
+	if uses == 0 {
+		return
+	}
+
+	err = o.authUsageStore.Put(key, []byte(strconv.Itoa(uses-1)))
+	if err != nil {
+		logger.Warnf("failed to cancel authorization use : save authorization usage : %s", err.Error())
+	}
+}
+
+func (o *Operation) getAuthorizationUses(key string) (int, error) {
+	usesBytes, err := o.authUsageStore.Get(key)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("get authorization usage : %w", err)
+	}
+
+	uses, err := strconv.Atoi(string(usesBytes))
+	if err != nil {
+		return 0, fmt.Errorf("invalid authorization usage : %w", err)
+	}
+
+	return uses, nil
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+
+	return false
+}
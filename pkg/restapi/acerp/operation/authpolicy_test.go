@@ -0,0 +1,544 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	mockstorage "github.com/hyperledger/aries-framework-go/component/storageutil/mock"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+	compmodel "github.com/trustbloc/edge-service/pkg/client/comparator/models"
+	"github.com/trustbloc/edge-service/pkg/restapi/vault"
+)
+
+func TestAuthPolicy(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		var p *authPolicy
+
+		require.Equal(t, uint64(authExpiryTime), p.expiry())
+		require.Equal(t, []string{"read"}, p.actions())
+		require.NoError(t, p.validate())
+
+		scope := p.vaultScope("doc1")
+		require.Equal(t, "doc1", scope.Target)
+		require.Equal(t, []string{"read"}, scope.Actions)
+		require.Equal(t, uint64(authExpiryTime), scope.Caveats[0].Duration)
+	})
+
+	t.Run("scopes", func(t *testing.T) {
+		p := &authPolicy{Expiry: 3600, Actions: []string{"read", "write"}}
+
+		scope := p.vaultScope("doc1")
+		require.Equal(t, []string{"read", "write"}, scope.Actions)
+		require.Equal(t, uint64(3600), scope.Caveats[0].Duration)
+
+		compScope := p.comparatorScope("vault1", "doc1", "$.credentialSubject.nationalID",
+			&vault.Tokens{EDV: "edv", KMS: "kms"})
+		require.Equal(t, []string{"compare"}, compScope.Actions)
+		require.Equal(t, "vault1", compScope.VaultID)
+		require.Equal(t, "edv", compScope.AuthTokens.Edv)
+		require.Equal(t, "$.credentialSubject.nationalID", compScope.DocAttrPath)
+		require.Len(t, compScope.Caveats(), 1)
+		require.Equal(t, int64(3600), compScope.Caveats()[0].(*compmodel.ExpiryCaveat).Duration)
+	})
+
+	t.Run("validate", func(t *testing.T) {
+		tests := []struct {
+			policy *authPolicy
+			err    string
+		}{
+			{policy: &authPolicy{Expiry: maxAuthExpiry + 1}, err: "expiry must be at most 86400 seconds"},
+			{policy: &authPolicy{Actions: []string{"delete"}}, err: "unsupported action delete"},
+			{policy: &authPolicy{Caveats: []authCaveat{{Type: "usage"}}}, err: "usage caveat needs a positive maxUses"},
+			{
+				policy: &authPolicy{Caveats: []authCaveat{{Type: "requestingParty"}}},
+				err:    "requestingParty caveat needs requestingParties",
+			},
+			{policy: &authPolicy{Caveats: []authCaveat{{Type: "location"}}}, err: "unsupported caveat type location"},
+		}
+
+		for _, tc := range tests {
+			require.EqualError(t, tc.policy.validate(), tc.err)
+		}
+
+		require.NoError(t, (&authPolicy{
+			Expiry:  maxAuthExpiry,
+			Actions: []string{"read"},
+			Caveats: []authCaveat{
+				{Type: "usage", MaxUses: 2},
+				{Type: "requestingParty", RequestingParties: []string{"did:example:123"}},
+			},
+		}).validate())
+	})
+}
+
+func TestEnforceAuthPolicy(t *testing.T) {
+	enforce := func(svc *Operation, profile *profileData, docID, requestingParty string) error {
+		_, err := svc.enforceAuthPolicy(profile, docID, requestingParty)
+
+		return err
+	}
+
+	t.Run("requesting party", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		profile := &profileData{ID: "profile1", AuthPolicy: &authPolicy{
+			Caveats: []authCaveat{{Type: "requestingParty", RequestingParties: []string{"did:example:123"}}},
+		}}
+
+		require.NoError(t, enforce(svc, profile, "doc1", "did:example:123"))
+
+		err := enforce(svc, profile, "doc1", "did:example:456")
+		require.True(t, errors.Is(err, errAuthPolicy))
+		require.Contains(t, err.Error(), "requesting party did:example:456 isn't allowed")
+
+		err = enforce(svc, profile, "doc1", "")
+		require.True(t, errors.Is(err, errAuthPolicy))
+		require.Contains(t, err.Error(), "requesting party isn't identified")
+	})
+
+	t.Run("usage", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		profile := &profileData{ID: "profile1", AuthPolicy: &authPolicy{
+			Caveats: []authCaveat{{Type: "usage", MaxUses: 2}},
+		}}
+
+		require.NoError(t, enforce(svc, profile, "doc1", "did:example:123"))
+		require.NoError(t, enforce(svc, profile, "doc1", "did:example:123"))
+
+		err := enforce(svc, profile, "doc1", "did:example:123")
+		require.True(t, errors.Is(err, errAuthPolicy))
+		require.Contains(t, err.Error(), "document doc1 has been authorized 2 times")
+
+		// counted for each document
+		require.NoError(t, enforce(svc, profile, "doc2", "did:example:123"))
+
+		// no policy
+		require.NoError(t, enforce(svc, &profileData{ID: "profile2"}, "doc1", "did:example:123"))
+	})
+
+	t.Run("use of a failed authorization is given back", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		profile := &profileData{ID: "profile1", AuthPolicy: &authPolicy{
+			Caveats: []authCaveat{{Type: "usage", MaxUses: 1}},
+		}}
+
+		cancelUse, err := svc.enforceAuthPolicy(profile, "doc1", "did:example:123")
+		require.NoError(t, err)
+
+		cancelUse()
+
+		require.NoError(t, enforce(svc, profile, "doc1", "did:example:123"))
+		require.Error(t, enforce(svc, profile, "doc1", "did:example:123"))
+
+		// a denied request has nothing to give back
+		cancelUse, err = svc.enforceAuthPolicy(profile, "doc1", "did:example:123")
+		require.Error(t, err)
+
+		cancelUse()
+
+		require.Error(t, enforce(svc, profile, "doc1", "did:example:123"))
+	})
+
+	t.Run("concurrent uses", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		profile := &profileData{ID: "profile1", AuthPolicy: &authPolicy{
+			Caveats: []authCaveat{{Type: "usage", MaxUses: 3}},
+		}}
+
+		var (
+			wg      sync.WaitGroup
+			allowed int32
+		)
+
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				if enforce(svc, profile, "doc1", "did:example:123") == nil {
+					atomic.AddInt32(&allowed, 1)
+				}
+			}()
+		}
+
+		wg.Wait()
+
+		require.Equal(t, int32(3), allowed)
+	})
+
+	t.Run("store errors", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		profile := &profileData{ID: "profile1", AuthPolicy: &authPolicy{
+			Caveats: []authCaveat{{Type: "usage", MaxUses: 2}},
+		}}
+
+		svc.authUsageStore = &mockstorage.Store{ErrGet: errors.New("get error")}
+
+		err := enforce(svc, profile, "doc1", "did:example:123")
+		require.EqualError(t, err, "get authorization usage : get error")
+
+		svc.authUsageStore = &mockstorage.Store{GetReturn: []byte("invalid")}
+
+		err = enforce(svc, profile, "doc1", "did:example:123")
+		require.Contains(t, err.Error(), "invalid authorization usage")
+
+		svc.authUsageStore = &mockstorage.Store{ErrGet: storage.ErrDataNotFound, ErrPut: errors.New("put error")}
+
+		err = enforce(svc, profile, "doc1", "did:example:123")
+		require.EqualError(t, err, "save authorization usage : put error")
+
+		// the use can't be given back
+		svc.authUsageStore = &mockstorage.Store{GetReturn: []byte("1"), ErrPut: errors.New("put error")}
+
+		require.NotPanics(t, func() { svc.cancelAuthorizationUse("key") })
+
+		svc.authUsageStore = &mockstorage.Store{ErrGet: errors.New("get error")}
+
+		require.NotPanics(t, func() { svc.cancelAuthorizationUse("key") })
+	})
+}
+
+func TestGenerateUserAuthsPolicy(t *testing.T) {
+	t.Run("expiry", func(t *testing.T) {
+		vClient := &mockVaultClient{}
+		compClient := &mockComparatorClient{}
+
+		svc := newTestOperation(t, withUserAuthPolicy(t, &authPolicy{Expiry: 3600}, vClient, compClient))
+
+		rr := httptest.NewRecorder()
+
+		svc.generateUserAuths(rr, newGenerateUserAuthRequest(t, "U1"))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		require.Len(t, vClient.authorizationScopes, 1)
+		require.Equal(t, uint64(3600), vClient.authorizationScopes[0].Caveats[0].Duration)
+
+		require.Len(t, compClient.authorizations, 1)
+		require.Equal(t, "did:example:profile", *compClient.authorizations[0].RequestingParty)
+		require.Equal(t, int64(3600),
+			compClient.authorizations[0].Scope.Caveats()[0].(*compmodel.ExpiryCaveat).Duration)
+	})
+
+	t.Run("usage exceeded", func(t *testing.T) {
+		vClient := &mockVaultClient{}
+		policy := &authPolicy{
+			Caveats: []authCaveat{{Type: "usage", MaxUses: 1}},
+		}
+
+		svc := newTestOperation(t, withUserAuthPolicy(t, policy, vClient, &mockComparatorClient{}))
+
+		rr := httptest.NewRecorder()
+
+		svc.generateUserAuths(rr, newGenerateUserAuthRequest(t, "U1"))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		rr = httptest.NewRecorder()
+
+		svc.generateUserAuths(rr, newGenerateUserAuthRequest(t, "U1"))
+		require.Equal(t, http.StatusForbidden, rr.Code)
+		require.Contains(t, rr.Body.String(), "authorization policy: document doc1 has been authorized 1 times")
+
+		require.Len(t, vClient.authorizationScopes, 1)
+	})
+
+	t.Run("usage of a failed authorization", func(t *testing.T) {
+		vClient := &mockVaultClient{CreateAuthorizationErr: errors.New("vault error")}
+		policy := &authPolicy{
+			Caveats: []authCaveat{{Type: "usage", MaxUses: 1}},
+		}
+
+		svc := newTestOperation(t, withUserAuthPolicy(t, policy, vClient, &mockComparatorClient{}))
+
+		rr := httptest.NewRecorder()
+
+		svc.generateUserAuths(rr, newGenerateUserAuthRequest(t, "U1"))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+
+		vClient.CreateAuthorizationErr = nil
+		rr = httptest.NewRecorder()
+
+		svc.generateUserAuths(rr, newGenerateUserAuthRequest(t, "U1"))
+		require.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("requesting party", func(t *testing.T) {
+		vClient := &mockVaultClient{}
+		policy := &authPolicy{
+			Caveats: []authCaveat{{Type: "requestingParty", RequestingParties: []string{"did:example:requester"}}},
+		}
+
+		svc := newTestOperation(t,
+			withUserAuthPolicy(t, policy, vClient, &mockComparatorClient{}),
+			withProfileData(&profileData{ID: "profile2", DID: "did:example:requester"}),
+			withProfileData(&profileData{ID: "profile3", DID: "did:example:other"}),
+		)
+
+		rr := httptest.NewRecorder()
+
+		svc.generateUserAuths(rr, withTestPrincipal(newGenerateUserAuthRequest(t, "U1"), "profile2"))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		rr = httptest.NewRecorder()
+
+		svc.generateUserAuths(rr, withTestPrincipal(newGenerateUserAuthRequest(t, "U1"), "profile3"))
+		require.Equal(t, http.StatusForbidden, rr.Code)
+		require.Contains(t, rr.Body.String(), "requesting party did:example:other isn't allowed")
+
+		// the holders of the admin API keys aren't identified
+		rr = httptest.NewRecorder()
+
+		svc.generateUserAuths(rr, newGenerateUserAuthRequest(t, "U1"))
+		require.Equal(t, http.StatusForbidden, rr.Code)
+		require.Contains(t, rr.Body.String(), "requesting party isn't identified")
+
+		require.Len(t, vClient.authorizationScopes, 1)
+	})
+
+	t.Run("usage store error", func(t *testing.T) {
+		policy := &authPolicy{
+			Caveats: []authCaveat{{Type: "usage", MaxUses: 1}},
+		}
+
+		svc := newTestOperation(t, withUserAuthPolicy(t, policy, &mockVaultClient{}, &mockComparatorClient{}))
+
+		svc.authUsageStore = &mockstorage.Store{ErrGet: errors.New("get error")}
+
+		rr := httptest.NewRecorder()
+
+		svc.generateUserAuths(rr, newGenerateUserAuthRequest(t, "U1"))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to enforce authorization policy")
+	})
+}
+
+func TestConsentPolicy(t *testing.T) {
+	newOperation := func(t *testing.T, policy *authPolicy, vClient *mockVaultClient) *Operation {
+		t.Helper()
+
+		return newTestOperation(t,
+			withVaultClient(vClient),
+			withComparatorClient(&mockComparatorClient{}),
+			withUserData(&userData{ID: "U1", UserName: sampleUserName, VaultID: "vault1", NationalIDDocID: "doc1"}),
+			withClientData(&clientData{ClientID: "client1", DID: "did:example:client", ProfileID: "profile1"}),
+			withProfileData(&profileData{ID: "profile1", DID: "did:example:profile", AuthPolicy: policy}),
+		)
+	}
+
+	consent := func(t *testing.T, svc *Operation) *httptest.ResponseRecorder {
+		t.Helper()
+
+		dataBytes, err := json.Marshal(&sessionData{
+			DID:         "did:example:client",
+			State:       uuid.NewString(),
+			CallbackURL: "https://client.example.com/callback",
+			ClientID:    "client1",
+		})
+		require.NoError(t, err)
+		require.NoError(t, svc.store.Put("session1", dataBytes))
+
+		rr := httptest.NewRecorder()
+
+		svc.consent(rr, newCSRFRequest(t, svc, consent+"?id=session1", sampleUserName))
+
+		return rr
+	}
+
+	t.Run("policy of the client profile", func(t *testing.T) {
+		vClient := &mockVaultClient{}
+
+		svc := newOperation(t, &authPolicy{Expiry: 3600, Caveats: []authCaveat{
+			{Type: "requestingParty", RequestingParties: []string{"did:example:client"}},
+			{Type: "usage", MaxUses: 1},
+		}}, vClient)
+
+		rr := consent(t, svc)
+		require.Equal(t, http.StatusFound, rr.Code)
+
+		require.Len(t, vClient.authorizationScopes, 1)
+		require.Equal(t, uint64(3600), vClient.authorizationScopes[0].Caveats[0].Duration)
+
+		// the account link expires with the authorization
+		links, err := svc.getAccountLinks(userTagName, "U1")
+		require.NoError(t, err)
+		require.Len(t, links, 1)
+		require.WithinDuration(t, links[0].CreatedTime.Add(3600*time.Second), links[0].AuthExpiry.Time, time.Second)
+
+		rr = consent(t, svc)
+		require.Equal(t, http.StatusForbidden, rr.Code)
+		require.Contains(t, rr.Body.String(), "document doc1 has been authorized 1 times")
+	})
+
+	t.Run("requesting party not allowed", func(t *testing.T) {
+		vClient := &mockVaultClient{}
+
+		svc := newOperation(t, &authPolicy{Caveats: []authCaveat{
+			{Type: "requestingParty", RequestingParties: []string{"did:example:other"}},
+		}}, vClient)
+
+		rr := consent(t, svc)
+		require.Equal(t, http.StatusForbidden, rr.Code)
+		require.Contains(t, rr.Body.String(), "requesting party did:example:client isn't allowed")
+		require.Empty(t, vClient.authorizationScopes)
+	})
+
+	t.Run("usage of a failed authorization", func(t *testing.T) {
+		vClient := &mockVaultClient{CreateAuthorizationErr: errors.New("vault error")}
+
+		svc := newOperation(t, &authPolicy{Caveats: []authCaveat{{Type: "usage", MaxUses: 1}}}, vClient)
+
+		rr := consent(t, svc)
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+
+		vClient.CreateAuthorizationErr = nil
+
+		rr = consent(t, svc)
+		require.Equal(t, http.StatusFound, rr.Code)
+	})
+
+	t.Run("client profile error", func(t *testing.T) {
+		svc := newOperation(t, nil, &mockVaultClient{})
+
+		require.NoError(t, svc.profileStore.Delete("profile1"))
+
+		rr := consent(t, svc)
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to get client profile")
+	})
+}
+
+func TestAccountLinkCallbackPolicy(t *testing.T) {
+	newOperation := func(t *testing.T, policy *authPolicy, vClient *mockVaultClient) *Operation {
+		t.Helper()
+
+		return newTestOperation(t,
+			withConfig(func(config *Config) {
+				config.AccountLinkProfile = "profile1"
+				config.AccountLinkedHTML = newTestHTMLFile(t)
+			}),
+			withVaultClient(vClient),
+			withComparatorClient(&mockComparatorClient{}),
+			withUserData(&userData{ID: "U1", UserName: sampleUserName, VaultID: "vault1", NationalIDDocID: "doc1"}),
+			withProfileData(&profileData{ID: "profile1", DID: "did:example:profile", AuthPolicy: policy}),
+		)
+	}
+
+	callback := func(t *testing.T, svc *Operation) *httptest.ResponseRecorder {
+		t.Helper()
+
+		state := uuid.NewString()
+
+		require.NoError(t, svc.saveConnectState(state, &connectState{UserName: sampleUserName}))
+
+		rr := httptest.NewRecorder()
+
+		svc.accountLinkCallback(rr, httptest.NewRequest(http.MethodGet,
+			accountLinkCallback+"?auth=token&state="+state, nil))
+
+		return rr
+	}
+
+	t.Run("usage", func(t *testing.T) {
+		svc := newOperation(t, &authPolicy{Caveats: []authCaveat{
+			{Type: "requestingParty", RequestingParties: []string{"did:example:profile"}},
+			{Type: "usage", MaxUses: 1},
+		}}, &mockVaultClient{})
+
+		rr := callback(t, svc)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		rr = callback(t, svc)
+		require.Equal(t, http.StatusForbidden, rr.Code)
+		require.Contains(t, rr.Body.String(), "document doc1 has been authorized 1 times")
+	})
+
+	t.Run("usage of a failed authorization", func(t *testing.T) {
+		vClient := &mockVaultClient{CreateAuthorizationResp: &vault.CreatedAuthorization{}}
+
+		svc := newOperation(t, &authPolicy{Caveats: []authCaveat{{Type: "usage", MaxUses: 1}}}, vClient)
+
+		rr := callback(t, svc)
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "missing auth token from vault-server")
+
+		vClient.CreateAuthorizationResp = nil
+		vClient.CreateAuthorizationErr = errors.New("vault error")
+
+		rr = callback(t, svc)
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+
+		vClient.CreateAuthorizationErr = nil
+
+		rr = callback(t, svc)
+		require.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("policy store error", func(t *testing.T) {
+		svc := newOperation(t, &authPolicy{Caveats: []authCaveat{{Type: "usage", MaxUses: 1}}}, &mockVaultClient{})
+
+		require.NoError(t, svc.authUsageStore.Put("profile1_doc1", []byte("invalid")))
+
+		rr := callback(t, svc)
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to enforce authorization policy")
+	})
+}
+
+// withUserAuthPolicy seeds the extractor profile with the policy and a user with a national ID document.
+func withUserAuthPolicy(t *testing.T, policy *authPolicy, vClient *mockVaultClient,
+	compClient *mockComparatorClient) testOperationOption {
+	t.Helper()
+
+	return func(opts *testOperationOptions) {
+		withConfig(func(config *Config) {
+			config.ExtractorProfile = "profile1"
+		})(opts)
+		withVaultClient(vClient)(opts)
+		withComparatorClient(compClient)(opts)
+		withHTTPClient(&mockHTTPClient{
+			doFunc: mockHTTPResponse(t, nil, &mockHTTPResponseData{status: http.StatusOK}),
+		})(opts)
+		withProfileData(&profileData{
+			ID:         "profile1",
+			DID:        "did:example:profile",
+			Callback:   "https://profile.example.com",
+			AuthPolicy: policy,
+		})(opts)
+		withUserData(&userData{ID: "U1", UserName: sampleUserName, VaultID: "vault1", NationalIDDocID: "doc1"})(opts)
+	}
+}
+
+// withTestPrincipal authenticates the request as an operator tied to the profile.
+func withTestPrincipal(req *http.Request, profileID string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), principalKey{},
+		&principal{roles: []Role{RoleOperator}, profileID: profileID}))
+}
+
+func newGenerateUserAuthRequest(t *testing.T, userIDs ...string) *http.Request {
+	t.Helper()
+
+	reqBytes, err := json.Marshal(generateUserAuthReq{Users: userIDs})
+	require.NoError(t, err)
+
+	return httptest.NewRequest(http.MethodPost, generateUserAuth, bytes.NewReader(reqBytes))
+}
@@ -0,0 +1,502 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+)
+
+const (
+	clientSecretLen = 32
+
+	defaultClientSecretGracePeriod = 24 * time.Hour
+)
+
+var errInvalidClient = errors.New("invalid client credentials")
+
+func (o *Operation) createClient(w http.ResponseWriter, r *http.Request) {
+	req := &clientReq{}
+
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusBadRequest,
+			fmt.Sprintf("failed to decode request: %s", err.Error()))
+
+		return
+	}
+
+	err = o.validateClient(req)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	secret, err := newClientSecret()
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to create client secret: %s", err.Error()))
+
+		return
+	}
+
+	data := &clientData{
+		ClientID:   uuid.New().String(),
+		DID:        req.DID,
+		Callback:   req.Callback,
+		ProfileID:  req.ProfileID,
+		SecretHash: hashClientSecret(secret),
+	}
+
+	err = o.saveClientData(data)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to save client data: %s", err.Error()))
+
+		return
+	}
+
+	o.writeResponse(w, http.StatusCreated, clientResp{
+		ClientID:     data.ClientID,
+		ClientSecret: secret,
+		DID:          req.DID,
+		Callback:     req.Callback,
+		ProfileID:    req.ProfileID,
+	})
+}
+
+func (o *Operation) getClients(w http.ResponseWriter, r *http.Request) {
+	page, err := getPageRequest(r)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	values, next, err := queryPage(o.clientStore, clientTagName, page)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to get clients: %s", err.Error()))
+
+		return
+	}
+
+	clients := make([]clientResp, len(values))
+
+	for i, v := range values {
+		data := &clientData{}
+
+		err = json.Unmarshal(v, data)
+		if err != nil {
+			o.writeErrorResponse(w, http.StatusInternalServerError,
+				fmt.Sprintf("failed to unmarshal client data: %s", err.Error()))
+
+			return
+		}
+
+		clients[i] = clientResp{
+			ClientID: data.ClientID, DID: data.DID, Callback: data.Callback, ProfileID: data.ProfileID,
+		}
+	}
+
+	o.writeResponse(w, http.StatusOK, &clientsResp{Clients: clients, NextCursor: next})
+}
+
+func (o *Operation) getClient(w http.ResponseWriter, r *http.Request) {
+	data, ok := o.getClientOrWriteError(w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	o.writeResponse(w, http.StatusOK, clientResp{
+		ClientID:  data.ClientID,
+		DID:       data.DID,
+		Callback:  data.Callback,
+		ProfileID: data.ProfileID,
+	})
+}
+
+func (o *Operation) updateClient(w http.ResponseWriter, r *http.Request) {
+	req := &clientReq{}
+
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusBadRequest,
+			fmt.Sprintf("failed to decode request: %s", err.Error()))
+
+		return
+	}
+
+	err = o.validateClient(req)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	data, ok := o.getClientOrWriteError(w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	data.DID = req.DID
+	data.Callback = req.Callback
+	data.ProfileID = req.ProfileID
+
+	err = o.saveClientData(data)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to save client data: %s", err.Error()))
+
+		return
+	}
+
+	o.writeResponse(w, http.StatusOK, clientResp{
+		ClientID:  data.ClientID,
+		DID:       data.DID,
+		Callback:  data.Callback,
+		ProfileID: data.ProfileID,
+	})
+}
+
+// deleteClient revokes the account links of the client before deleting it.
+func (o *Operation) deleteClient(w http.ResponseWriter, r *http.Request) {
+	data, ok := o.getClientOrWriteError(w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	clientLinks, err := o.getAccountLinks(clientTagName, data.ClientID)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to get account links: %s", err.Error()))
+
+		return
+	}
+
+	for _, l := range clientLinks {
+		err = o.removeAccountLink(l)
+		if err != nil {
+			o.writeErrorResponse(w, http.StatusInternalServerError,
+				fmt.Sprintf("failed to remove account link %s: %s", l.ID, err.Error()))
+
+			return
+		}
+
+		o.notifyAccountUnlink(l)
+	}
+
+	err = o.clientStore.Delete(data.ClientID)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to delete client : id=%s - %s", data.ClientID, err.Error()))
+
+		return
+	}
+
+	logger.Infof("deleteClient : clientID=[%s] links=[%d]", data.ClientID, len(clientLinks))
+
+	o.writeResponse(w, http.StatusOK, nil)
+}
+
+// rotateClientSecret issues a new secret to the client. The previous secret stays valid for a grace period so that
+// the client can be reconfigured without breaking the account links in progress, but only the current secret can
+// rotate it, so that a replaced secret can't be used to take over the client.
+func (o *Operation) rotateClientSecret(w http.ResponseWriter, r *http.Request) {
+	data, err := o.authenticateClientWith(r, (*clientData).verifyCurrentSecret)
+	if err != nil {
+		o.writeClientAuthError(w, err)
+
+		return
+	}
+
+	if data.ClientID != mux.Vars(r)["id"] {
+		o.writeClientAuthError(w, errInvalidClient)
+
+		return
+	}
+
+	secret, err := newClientSecret()
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to create client secret: %s", err.Error()))
+
+		return
+	}
+
+	data.PreviousSecretHash = data.SecretHash
+	data.PreviousSecretExpiry = time.Now().Add(o.clientSecretGracePeriod)
+	data.SecretHash = hashClientSecret(secret)
+
+	err = o.saveClientData(data)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to save client data: %s", err.Error()))
+
+		return
+	}
+
+	logger.Infof("rotateClientSecret : clientID=[%s]", data.ClientID)
+
+	o.writeResponse(w, http.StatusOK, clientResp{
+		ClientID:     data.ClientID,
+		ClientSecret: secret,
+		DID:          data.DID,
+		Callback:     data.Callback,
+	})
+}
+
+// resetClientSecret issues a new secret to the client for the operator, and revokes the previous secrets at once. It
+// is used for the clients migrated without a secret and for the leaked secrets.
+func (o *Operation) resetClientSecret(w http.ResponseWriter, r *http.Request) {
+	data, ok := o.getClientOrWriteError(w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	secret, err := newClientSecret()
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to create client secret: %s", err.Error()))
+
+		return
+	}
+
+	data.SecretHash = hashClientSecret(secret)
+	data.PreviousSecretHash = nil
+	data.PreviousSecretExpiry = time.Time{}
+
+	err = o.saveClientData(data)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to save client data: %s", err.Error()))
+
+		return
+	}
+
+	logger.Infof("resetClientSecret : clientID=[%s]", data.ClientID)
+
+	o.writeResponse(w, http.StatusOK, clientResp{
+		ClientID:     data.ClientID,
+		ClientSecret: secret,
+		DID:          data.DID,
+		Callback:     data.Callback,
+		ProfileID:    data.ProfileID,
+	})
+}
+
+// authenticateClient checks the client credentials sent with HTTP basic authentication.
+func (o *Operation) authenticateClient(r *http.Request) (*clientData, error) {
+	return o.authenticateClientWith(r, (*clientData).verifySecret)
+}
+
+// authenticateClientWith checks the client credentials with the given check of the secret.
+func (o *Operation) authenticateClientWith(r *http.Request,
+	verify func(c *clientData, secret string) bool) (*clientData, error) {
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		return nil, errInvalidClient
+	}
+
+	data, err := o.getClientData(clientID)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		return nil, errInvalidClient
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !verify(data, secret) {
+		return nil, errInvalidClient
+	}
+
+	return data, nil
+}
+
+func (o *Operation) writeClientAuthError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errInvalidClient) {
+		w.Header().Set("WWW-Authenticate", "Basic")
+		o.writeErrorResponse(w, http.StatusUnauthorized, err.Error())
+
+		return
+	}
+
+	o.writeErrorResponse(w, http.StatusInternalServerError,
+		fmt.Sprintf("failed to authenticate client: %s", err.Error()))
+}
+
+func (o *Operation) getClientOrWriteError(w http.ResponseWriter, id string) (*clientData, bool) {
+	data, err := o.getClientData(id)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		o.writeErrorResponse(w, http.StatusNotFound, fmt.Sprintf("client %s not found", id))
+
+		return nil, false
+	}
+
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to get client : id=%s - %s", id, err.Error()))
+
+		return nil, false
+	}
+
+	return data, true
+}
+
+func (o *Operation) validateClient(req *clientReq) error {
+	err := validateURL(req.Callback)
+	if err != nil {
+		return fmt.Errorf("invalid callback url: %w", err)
+	}
+
+	err = o.validateDID(req.DID)
+	if err != nil {
+		return fmt.Errorf("invalid did: %w", err)
+	}
+
+	if req.ProfileID != "" {
+		_, err = o.getProfileData(req.ProfileID)
+		if err != nil {
+			return fmt.Errorf("invalid profile: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (o *Operation) getClientData(clientID string) (*clientData, error) {
+	cDataBytes, err := o.clientStore.Get(clientID)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		cDataBytes, err = o.migrateLegacyClient(clientID)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("get client data: %w", err)
+	}
+
+	var cData *clientData
+
+	err = json.Unmarshal(cDataBytes, &cData)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal client data: %w", err)
+	}
+
+	return cData, nil
+}
+
+// getClientProfile returns the profile the client is tied to. The clients which aren't tied to a profile get an
+// empty profile, without an authorization policy.
+func (o *Operation) getClientProfile(clientID string) (*profileData, error) {
+	cData, err := o.getClientData(clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	if cData.ProfileID == "" {
+		return &profileData{}, nil
+	}
+
+	return o.getProfileData(cData.ProfileID)
+}
+
+func (o *Operation) saveClientData(data *clientData) error {
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("marshal client data: %w", err)
+	}
+
+	return o.clientStore.Put(data.ClientID, dataBytes, storage.Tag{Name: clientTagName})
+}
+
+// migrateLegacyClient moves the client from the transaction store to the client store when it is first read, as the
+// transaction store has no tags to list the clients. The previous versions didn't keep the client secrets, so the
+// operator has to reset the secret of a migrated client. It returns the client data, or storage.ErrDataNotFound if
+// the transaction store has no client with the id.
+func (o *Operation) migrateLegacyClient(id string) ([]byte, error) {
+	legacyBytes, err := o.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &clientData{}
+
+	// the transaction store also has the users and the link states
+	if json.Unmarshal(legacyBytes, data) != nil || data.ClientID != id {
+		return nil, storage.ErrDataNotFound
+	}
+
+	err = o.saveClientData(data)
+	if err != nil {
+		return nil, fmt.Errorf("migrate client: %w", err)
+	}
+
+	err = o.store.Delete(id)
+	if err != nil {
+		logger.Warnf("failed to delete legacy client %s : %s", id, err.Error())
+	}
+
+	logger.Warnf("migrated legacy client %s, its secret must be reset", id)
+
+	return json.Marshal(data)
+}
+
+// verifySecret compares the hash of the secret in constant time. The previous secret is accepted until the end of
+// the rotation grace period.
+func (c *clientData) verifySecret(secret string) bool {
+	if c.verifyCurrentSecret(secret) {
+		return true
+	}
+
+	return time.Now().Before(c.PreviousSecretExpiry) &&
+		subtle.ConstantTimeCompare(hashClientSecret(secret), c.PreviousSecretHash) == 1
+}
+
+// verifyCurrentSecret compares the hash of the secret with the hash of the current secret in constant time.
+func (c *clientData) verifyCurrentSecret(secret string) bool {
+	return subtle.ConstantTimeCompare(hashClientSecret(secret), c.SecretHash) == 1
+}
+
+func newClientSecret() (string, error) {
+	secret := make([]byte, clientSecretLen)
+
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// hashClientSecret hashes the secret with SHA-256. Unlike the passwords of the users, the client secrets are random
+// 256 bit values, so a slow hash isn't needed to protect them from brute force.
+func hashClientSecret(secret string) []byte {
+	hash := sha256.Sum256([]byte(secret))
+
+	return hash[:]
+}
+
+func validateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return errors.New("must be an absolute http(s) url")
+	}
+
+	return nil
+}
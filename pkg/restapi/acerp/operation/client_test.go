@@ -0,0 +1,634 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	mockstorage "github.com/hyperledger/aries-framework-go/component/storageutil/mock"
+	vdrmock "github.com/hyperledger/aries-framework-go/pkg/mock/vdr"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateClient(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		svc := newTestOperation(t, withDIDResolution())
+
+		cReq := clientReq{
+			DID:      "did:example:123",
+			Callback: "http://test/callback",
+		}
+
+		reqBytes, err := json.Marshal(cReq)
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+
+		svc.createClient(rr, httptest.NewRequest(http.MethodPost, client, bytes.NewBuffer(reqBytes)))
+		require.Equal(t, http.StatusCreated, rr.Code)
+
+		var resp *clientResp
+
+		err = json.Unmarshal(rr.Body.Bytes(), &resp)
+		require.NoError(t, err)
+
+		require.NotEmpty(t, resp.ClientID)
+		require.NotEmpty(t, resp.ClientSecret)
+		require.Equal(t, cReq.DID, resp.DID)
+		require.Equal(t, cReq.Callback, resp.Callback)
+
+		// only the hash of the secret is stored
+		cData, err := svc.getClientData(resp.ClientID)
+		require.NoError(t, err)
+		require.NotContains(t, string(cData.SecretHash), resp.ClientSecret)
+		require.True(t, cData.verifySecret(resp.ClientSecret))
+	})
+
+	t.Run("partner profile", func(t *testing.T) {
+		svc := newTestOperation(t, withDIDResolution(), withProfileData(&profileData{ID: "profile1"}))
+
+		for profileID, status := range map[string]int{"profile1": http.StatusCreated, "invalid": http.StatusBadRequest} {
+			reqBytes, err := json.Marshal(clientReq{
+				DID:       "did:example:123",
+				Callback:  "http://test/callback",
+				ProfileID: profileID,
+			})
+			require.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+
+			svc.createClient(rr, httptest.NewRequest(http.MethodPost, client, bytes.NewBuffer(reqBytes)))
+			require.Equal(t, status, rr.Code, profileID)
+
+			if status == http.StatusCreated {
+				var resp *clientResp
+
+				require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+				require.Equal(t, profileID, resp.ProfileID)
+			} else {
+				require.Contains(t, rr.Body.String(), "invalid profile")
+			}
+		}
+	})
+
+	t.Run("invalid request", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		rr := httptest.NewRecorder()
+
+		svc.createClient(rr, httptest.NewRequest(http.MethodPost, client, strings.NewReader("invalid-json")))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to decode request")
+	})
+
+	t.Run("invalid callback", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		for _, callback := range []string{"", "/callback", "ftp://test/callback", ":invalid"} {
+			reqBytes, err := json.Marshal(clientReq{DID: "did:example:123", Callback: callback})
+			require.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+
+			svc.createClient(rr, httptest.NewRequest(http.MethodPost, client, bytes.NewBuffer(reqBytes)))
+			require.Equal(t, http.StatusBadRequest, rr.Code)
+			require.Contains(t, rr.Body.String(), "invalid callback url")
+		}
+	})
+
+	t.Run("invalid did", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		reqBytes, err := json.Marshal(clientReq{Callback: "http://test/callback"})
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+
+		svc.createClient(rr, httptest.NewRequest(http.MethodPost, client, bytes.NewBuffer(reqBytes)))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "invalid did: did is mandatory")
+
+		svc.vdri = &vdrmock.MockVDRegistry{ResolveErr: errors.New("DID does not exist")}
+
+		reqBytes, err = json.Marshal(clientReq{DID: "did:example:123", Callback: "http://test/callback"})
+		require.NoError(t, err)
+
+		rr = httptest.NewRecorder()
+
+		svc.createClient(rr, httptest.NewRequest(http.MethodPost, client, bytes.NewBuffer(reqBytes)))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "resolve did did:example:123 : DID does not exist")
+	})
+
+	t.Run("db error", func(t *testing.T) {
+		svc := newTestOperation(t, withDIDResolution())
+
+		svc.clientStore = &mockstorage.Store{ErrPut: errors.New("save error")}
+
+		reqBytes, err := json.Marshal(clientReq{DID: "did:example:123", Callback: "http://test/callback"})
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+
+		svc.createClient(rr, httptest.NewRequest(http.MethodPost, client, bytes.NewBuffer(reqBytes)))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to save client data")
+	})
+}
+
+func TestGetClients(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		svc := newTestOperation(t, withClientData(testClientData()))
+
+		for _, id := range []string{"client3", "client2"} {
+			require.NoError(t, svc.saveClientData(&clientData{ClientID: id, SecretHash: hashClientSecret("secret")}))
+		}
+
+		getPage := func(query string) *clientsResp {
+			rr := httptest.NewRecorder()
+
+			svc.getClients(rr, httptest.NewRequest(http.MethodGet, client+query, nil))
+			require.Equal(t, http.StatusOK, rr.Code)
+			require.NotContains(t, rr.Body.String(), "secret")
+
+			resp := &clientsResp{}
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+
+			return resp
+		}
+
+		resp := getPage("?limit=2")
+		require.Len(t, resp.Clients, 2)
+		require.Equal(t, "client1", resp.Clients[0].ClientID)
+		require.Equal(t, "https://client.example.com/callback", resp.Clients[0].Callback)
+		require.Equal(t, "client2", resp.Clients[1].ClientID)
+		require.NotEmpty(t, resp.NextCursor)
+
+		resp = getPage("?limit=2&cursor=" + resp.NextCursor)
+		require.Len(t, resp.Clients, 1)
+		require.Equal(t, "client3", resp.Clients[0].ClientID)
+		require.Empty(t, resp.NextCursor)
+
+		resp = getPage("")
+		require.Len(t, resp.Clients, 3)
+	})
+
+	t.Run("invalid page", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		rr := httptest.NewRecorder()
+
+		svc.getClients(rr, httptest.NewRequest(http.MethodGet, client+"?limit=0", nil))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "limit must be between 1 and 100")
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		svc.clientStore = &mockstorage.Store{ErrQuery: errors.New("query error")}
+
+		rr := httptest.NewRecorder()
+
+		svc.getClients(rr, httptest.NewRequest(http.MethodGet, client, nil))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to get clients")
+	})
+
+	t.Run("invalid data", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		require.NoError(t, svc.clientStore.Put("client2", []byte("invalid-json"), storage.Tag{Name: clientTagName}))
+
+		rr := httptest.NewRecorder()
+
+		svc.getClients(rr, httptest.NewRequest(http.MethodGet, client, nil))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to unmarshal client data")
+	})
+}
+
+func TestGetClient(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		svc := newTestOperation(t, withClientData(testClientData()))
+
+		rr := httptest.NewRecorder()
+
+		svc.getClient(rr, newClientRequest(http.MethodGet, "client1", nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.NotContains(t, rr.Body.String(), "secret")
+
+		var resp *clientResp
+
+		err := json.Unmarshal(rr.Body.Bytes(), &resp)
+		require.NoError(t, err)
+
+		require.Equal(t, "client1", resp.ClientID)
+		require.Equal(t, "https://client.example.com/callback", resp.Callback)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		rr := httptest.NewRecorder()
+
+		svc.getClient(rr, newClientRequest(http.MethodGet, "invalid", nil))
+		require.Equal(t, http.StatusNotFound, rr.Code)
+		require.Contains(t, rr.Body.String(), "client invalid not found")
+	})
+
+	t.Run("invalid data", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		require.NoError(t, svc.clientStore.Put("client2", []byte("invalid-json")))
+
+		rr := httptest.NewRecorder()
+
+		svc.getClient(rr, newClientRequest(http.MethodGet, "client2", nil))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "unmarshal client data")
+	})
+
+	t.Run("legacy client", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		legacyBytes, err := json.Marshal(&clientData{ClientID: "client2", Callback: "https://client.example.com/callback"})
+		require.NoError(t, err)
+
+		require.NoError(t, svc.store.Put("client2", legacyBytes))
+		require.NoError(t, svc.store.Put("user1", []byte(`{"sub":"user1"}`)))
+
+		rr := httptest.NewRecorder()
+
+		svc.getClient(rr, newClientRequest(http.MethodGet, "client2", nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Contains(t, rr.Body.String(), "client2")
+
+		// the legacy client moves to the client store without a secret
+		cData, err := svc.getClientData("client2")
+		require.NoError(t, err)
+		require.Empty(t, cData.SecretHash)
+		require.False(t, cData.verifySecret(""))
+
+		_, err = svc.store.Get("client2")
+		require.True(t, errors.Is(err, storage.ErrDataNotFound))
+
+		// the other items of the transaction store aren't clients
+		rr = httptest.NewRecorder()
+
+		svc.getClient(rr, newClientRequest(http.MethodGet, "user1", nil))
+		require.Equal(t, http.StatusNotFound, rr.Code)
+	})
+}
+
+func TestUpdateClient(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		svc := newTestOperation(t, withDIDResolution(), withClientData(testClientData()))
+
+		reqBytes, err := json.Marshal(clientReq{DID: "did:example:456", Callback: "https://new.example.com/callback"})
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+
+		svc.updateClient(rr, newClientRequest(http.MethodPut, "client1", reqBytes))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		cData, err := svc.getClientData("client1")
+		require.NoError(t, err)
+		require.Equal(t, "did:example:456", cData.DID)
+		require.Equal(t, "https://new.example.com/callback", cData.Callback)
+
+		// the secret isn't changed
+		require.True(t, cData.verifySecret("secret1"))
+	})
+
+	t.Run("invalid request", func(t *testing.T) {
+		svc := newTestOperation(t, withClientData(testClientData()))
+
+		rr := httptest.NewRecorder()
+
+		svc.updateClient(rr, newClientRequest(http.MethodPut, "client1", []byte("invalid-json")))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to decode request")
+
+		reqBytes, err := json.Marshal(clientReq{DID: "did:example:456"})
+		require.NoError(t, err)
+
+		rr = httptest.NewRecorder()
+
+		svc.updateClient(rr, newClientRequest(http.MethodPut, "client1", reqBytes))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "invalid callback url")
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		svc := newTestOperation(t, withDIDResolution())
+
+		reqBytes, err := json.Marshal(clientReq{DID: "did:example:456", Callback: "https://new.example.com/callback"})
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+
+		svc.updateClient(rr, newClientRequest(http.MethodPut, "invalid", reqBytes))
+		require.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		svc := newTestOperation(t, withDIDResolution(), withClientData(testClientData()))
+
+		cDataBytes, err := svc.clientStore.Get("client1")
+		require.NoError(t, err)
+
+		svc.clientStore = &mockstorage.Store{GetReturn: cDataBytes, ErrPut: errors.New("save error")}
+
+		reqBytes, err := json.Marshal(clientReq{DID: "did:example:456", Callback: "https://new.example.com/callback"})
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+
+		svc.updateClient(rr, newClientRequest(http.MethodPut, "client1", reqBytes))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to save client data: save error")
+	})
+}
+
+func TestDeleteClient(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		vClient := &mockVaultClient{}
+
+		svc := newTestOperation(t, withClientData(testClientData()), withVaultClient(vClient))
+
+		require.NoError(t, svc.saveAccountLink(&accountLink{UserID: "U1", State: "1", ClientID: "client1",
+			VaultAuthorizationID: "a1"}))
+		require.NoError(t, svc.saveAccountLink(&accountLink{UserID: "U2", State: "2", ClientID: "client1",
+			VaultAuthorizationID: "a2"}))
+		require.NoError(t, svc.saveAccountLink(&accountLink{UserID: "U1", State: "3", ClientID: "client2",
+			VaultAuthorizationID: "a3"}))
+
+		notified := make([]string, 0)
+
+		svc.httpClient = &mockHTTPClient{
+			doFunc: func(req *http.Request) (*http.Response, error) {
+				notified = append(notified, req.URL.String())
+
+				return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewReader(nil))}, nil
+			},
+		}
+
+		rr := httptest.NewRecorder()
+
+		svc.deleteClient(rr, newClientRequest(http.MethodDelete, "client1", nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		require.ElementsMatch(t, []string{"a1", "a2"}, vClient.deletedAuthorizations)
+		require.Equal(t, []string{"https://client.example.com/callback", "https://client.example.com/callback"},
+			notified)
+
+		_, err := svc.getClientData("client1")
+		require.True(t, errors.Is(err, storage.ErrDataNotFound))
+
+		clientLinks, err := svc.getAccountLinks(clientTagName, "client1")
+		require.NoError(t, err)
+		require.Empty(t, clientLinks)
+
+		clientLinks, err = svc.getAccountLinks(clientTagName, "client2")
+		require.NoError(t, err)
+		require.Len(t, clientLinks, 1)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		rr := httptest.NewRecorder()
+
+		svc.deleteClient(rr, newClientRequest(http.MethodDelete, "invalid", nil))
+		require.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("revoke error", func(t *testing.T) {
+		svc := newTestOperation(t, withClientData(testClientData()),
+			withVaultClient(&mockVaultClient{DeleteAuthorizationErr: errors.New("vault error")}))
+
+		require.NoError(t, svc.saveAccountLink(&accountLink{UserID: "U1", State: "1", ClientID: "client1",
+			VaultAuthorizationID: "a1"}))
+
+		rr := httptest.NewRecorder()
+
+		svc.deleteClient(rr, newClientRequest(http.MethodDelete, "client1", nil))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to remove account link")
+
+		_, err := svc.getClientData("client1")
+		require.NoError(t, err)
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		svc := newTestOperation(t, withClientData(testClientData()))
+
+		svc.linkStore = &mockstorage.Store{ErrQuery: errors.New("query error")}
+
+		rr := httptest.NewRecorder()
+
+		svc.deleteClient(rr, newClientRequest(http.MethodDelete, "client1", nil))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to get account links")
+
+		cDataBytes, err := svc.clientStore.Get("client1")
+		require.NoError(t, err)
+
+		svc.linkStore = &mockstorage.Store{QueryReturn: &mockstorage.Iterator{}}
+		svc.clientStore = &mockstorage.Store{GetReturn: cDataBytes, ErrDelete: errors.New("delete error")}
+
+		rr = httptest.NewRecorder()
+
+		svc.deleteClient(rr, newClientRequest(http.MethodDelete, "client1", nil))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to delete client : id=client1 - delete error")
+	})
+}
+
+func TestRotateClientSecret(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		svc := newTestOperation(t, withClientData(testClientData()), withConfig(func(config *Config) {
+			config.ClientSecretGracePeriod = time.Hour
+		}))
+
+		rotate := func(secret string) *httptest.ResponseRecorder {
+			req := mux.SetURLVars(httptest.NewRequest(http.MethodPost, client+"/client1/secret", nil),
+				map[string]string{"id": "client1"})
+			req.SetBasicAuth("client1", secret)
+
+			rr := httptest.NewRecorder()
+
+			svc.rotateClientSecret(rr, req)
+
+			return rr
+		}
+
+		rr := rotate("secret1")
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		resp := &clientResp{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+		require.Equal(t, "client1", resp.ClientID)
+		require.Equal(t, "https://client.example.com/callback", resp.Callback)
+		require.NotEmpty(t, resp.ClientSecret)
+
+		cData, err := svc.getClientData("client1")
+		require.NoError(t, err)
+
+		// the previous secret is valid during the grace period
+		require.True(t, cData.verifySecret(resp.ClientSecret))
+		require.True(t, cData.verifySecret("secret1"))
+		require.False(t, cData.verifySecret("secret2"))
+
+		require.WithinDuration(t, time.Now().Add(time.Hour), cData.PreviousSecretExpiry, time.Minute)
+
+		cData.PreviousSecretExpiry = time.Now().Add(-time.Minute)
+		require.False(t, cData.verifySecret("secret1"))
+
+		// the previous secret can't rotate the secret during the grace period
+		rr = rotate("secret1")
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+
+		rr = rotate(resp.ClientSecret)
+		require.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("invalid credentials", func(t *testing.T) {
+		svc := newTestOperation(t, withClientData(testClientData()))
+
+		rr := httptest.NewRecorder()
+
+		svc.rotateClientSecret(rr, httptest.NewRequest(http.MethodPost, client+"/client1/secret", nil))
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+		require.Equal(t, "Basic", rr.Header().Get("WWW-Authenticate"))
+		require.Contains(t, rr.Body.String(), "invalid client credentials")
+
+		for _, creds := range [][2]string{{"client1", "invalid"}, {"invalid", "secret1"}} {
+			req := httptest.NewRequest(http.MethodPost, client+"/client1/secret", nil)
+			req.SetBasicAuth(creds[0], creds[1])
+
+			rr = httptest.NewRecorder()
+
+			svc.rotateClientSecret(rr, req)
+			require.Equal(t, http.StatusUnauthorized, rr.Code)
+		}
+	})
+
+	t.Run("other client", func(t *testing.T) {
+		svc := newTestOperation(t, withClientData(testClientData()))
+
+		req := mux.SetURLVars(httptest.NewRequest(http.MethodPost, client+"/client2/secret", nil),
+			map[string]string{"id": "client2"})
+		req.SetBasicAuth("client1", "secret1")
+
+		rr := httptest.NewRecorder()
+
+		svc.rotateClientSecret(rr, req)
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		svc := newTestOperation(t, withClientData(testClientData()))
+
+		cDataBytes, err := svc.clientStore.Get("client1")
+		require.NoError(t, err)
+
+		svc.clientStore = &mockstorage.Store{GetReturn: cDataBytes, ErrPut: errors.New("save error")}
+
+		req := mux.SetURLVars(httptest.NewRequest(http.MethodPost, client+"/client1/secret", nil),
+			map[string]string{"id": "client1"})
+		req.SetBasicAuth("client1", "secret1")
+
+		rr := httptest.NewRecorder()
+
+		svc.rotateClientSecret(rr, req)
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to save client data: save error")
+
+		svc.clientStore = &mockstorage.Store{ErrGet: errors.New("get error")}
+
+		rr = httptest.NewRecorder()
+
+		svc.rotateClientSecret(rr, req)
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to authenticate client: get client data: get error")
+	})
+}
+
+func TestResetClientSecret(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		svc := newTestOperation(t, withClientData(testClientData()), withConfig(func(config *Config) {
+			config.ClientSecretGracePeriod = time.Hour
+		}))
+
+		cData, err := svc.getClientData("client1")
+		require.NoError(t, err)
+
+		cData.PreviousSecretHash = hashClientSecret("secret0")
+		cData.PreviousSecretExpiry = time.Now().Add(time.Hour)
+		require.NoError(t, svc.saveClientData(cData))
+
+		rr := httptest.NewRecorder()
+
+		svc.resetClientSecret(rr, newClientRequest(http.MethodPut, "client1", nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		resp := &clientResp{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+		require.Equal(t, "client1", resp.ClientID)
+		require.NotEmpty(t, resp.ClientSecret)
+
+		cData, err = svc.getClientData("client1")
+		require.NoError(t, err)
+
+		// the previous secrets are revoked at once
+		require.True(t, cData.verifySecret(resp.ClientSecret))
+		require.False(t, cData.verifySecret("secret1"))
+		require.False(t, cData.verifySecret("secret0"))
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		rr := httptest.NewRecorder()
+
+		svc.resetClientSecret(rr, newClientRequest(http.MethodPut, "invalid", nil))
+		require.Equal(t, http.StatusNotFound, rr.Code)
+		require.Contains(t, rr.Body.String(), "client invalid not found")
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		svc := newTestOperation(t, withClientData(testClientData()))
+
+		cDataBytes, err := svc.clientStore.Get("client1")
+		require.NoError(t, err)
+
+		svc.clientStore = &mockstorage.Store{GetReturn: cDataBytes, ErrPut: errors.New("save error")}
+
+		rr := httptest.NewRecorder()
+
+		svc.resetClientSecret(rr, newClientRequest(http.MethodPut, "client1", nil))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to save client data: save error")
+	})
+}
+
+func newClientRequest(method, id string, body []byte) *http.Request {
+	return mux.SetURLVars(httptest.NewRequest(method, client+"/"+id, bytes.NewReader(body)), map[string]string{"id": id})
+}
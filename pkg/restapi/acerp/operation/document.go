@@ -0,0 +1,92 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	docTypes = "/document/types"
+
+	// docTypeParam is the form, query and json param of the document type chosen by the caller.
+	docTypeParam = "docType"
+
+	credentialSubjectPath = "$.credentialSubject."
+)
+
+// DocumentType is a type of the documents saved in the vaults of the users. The value of the document is saved
+// in the Attribute of the credential subject of a vc.
+type DocumentType struct {
+	Name      string `json:"name"`
+	Label     string `json:"label,omitempty"`
+	Attribute string `json:"attribute"`
+}
+
+// attrPath returns the path of the value in the vc, which the comparator compares or extracts.
+func (d *DocumentType) attrPath() string {
+	return credentialSubjectPath + d.Attribute
+}
+
+// defaultDocumentTypes is used when the document types aren't configured, for the services which only
+// store the national id.
+func defaultDocumentTypes() []DocumentType {
+	return []DocumentType{{Name: nationalID, Label: "Social Security Number", Attribute: nationalID}}
+}
+
+func validateDocumentTypes(types []DocumentType) error {
+	names := make(map[string]bool)
+
+	for _, t := range types {
+		if t.Name == "" || t.Attribute == "" {
+			return errors.New("document type needs a name and an attribute")
+		}
+
+		if names[t.Name] {
+			return fmt.Errorf("duplicate document type %s", t.Name)
+		}
+
+		names[t.Name] = true
+	}
+
+	return nil
+}
+
+// documentType returns the document type with the name. The first configured type is used if the name is empty.
+func (o *Operation) documentType(name string) (*DocumentType, error) {
+	if name == "" {
+		return &o.documentTypes[0], nil
+	}
+
+	for i := range o.documentTypes {
+		if o.documentTypes[i].Name == name {
+			return &o.documentTypes[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported document type %s", name)
+}
+
+func (o *Operation) getDocumentTypes(w http.ResponseWriter, _ *http.Request) {
+	o.writeResponse(w, http.StatusOK, &documentTypesResp{DocumentTypes: o.documentTypes})
+}
+
+// docID returns the id of the document of the type in the vault of the user. Users registered before the document
+// types only have the national id document.
+func (u *userData) docID(docType string) (string, error) {
+	if id, ok := u.Documents[docType]; ok {
+		return id, nil
+	}
+
+	if docType == nationalID && u.NationalIDDocID != "" {
+		return u.NationalIDDocID, nil
+	}
+
+	return "", fmt.Errorf("user %s has no %s document", u.ID, docType)
+}
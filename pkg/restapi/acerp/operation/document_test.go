@@ -0,0 +1,254 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+)
+
+const passportNumber = "passportNumber"
+
+func testDocumentTypes() []DocumentType {
+	return []DocumentType{
+		{Name: nationalID, Attribute: nationalID},
+		{Name: "passport", Label: "Passport Number", Attribute: passportNumber},
+	}
+}
+
+func TestDocumentTypes(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		docType, err := svc.documentType("")
+		require.NoError(t, err)
+		require.Equal(t, nationalID, docType.Name)
+		require.Equal(t, "$.credentialSubject.nationalID", docType.attrPath())
+
+		_, err = svc.documentType("passport")
+		require.EqualError(t, err, "unsupported document type passport")
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		svc, err := New(&Config{
+			StoreProvider: mem.NewProvider(),
+			ComparatorURL: "http://comp.example.com",
+			DocumentTypes: testDocumentTypes(),
+		})
+		require.NoError(t, err)
+
+		docType, err := svc.documentType("passport")
+		require.NoError(t, err)
+		require.Equal(t, "$.credentialSubject.passportNumber", docType.attrPath())
+
+		rr := httptest.NewRecorder()
+
+		svc.getDocumentTypes(rr, httptest.NewRequest(http.MethodGet, docTypes, nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var resp *documentTypesResp
+
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		require.Equal(t, testDocumentTypes(), resp.DocumentTypes)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := New(&Config{
+			StoreProvider: mem.NewProvider(),
+			ComparatorURL: "http://comp.example.com",
+			DocumentTypes: []DocumentType{{Name: "passport"}},
+		})
+		require.EqualError(t, err, "invalid document types : document type needs a name and an attribute")
+
+		_, err = New(&Config{
+			StoreProvider: mem.NewProvider(),
+			ComparatorURL: "http://comp.example.com",
+			DocumentTypes: []DocumentType{
+				{Name: "passport", Attribute: passportNumber},
+				{Name: "passport", Attribute: "passportID"},
+			},
+		})
+		require.EqualError(t, err, "invalid document types : duplicate document type passport")
+	})
+}
+
+func TestUserDataDocID(t *testing.T) {
+	u := &userData{ID: "U1", Documents: map[string]string{"passport": "doc2"}}
+
+	docID, err := u.docID("passport")
+	require.NoError(t, err)
+	require.Equal(t, "doc2", docID)
+
+	_, err = u.docID(nationalID)
+	require.EqualError(t, err, "user U1 has no nationalID document")
+
+	// registered before the document types
+	u = &userData{ID: "U1", NationalIDDocID: "doc1"}
+
+	docID, err = u.docID(nationalID)
+	require.NoError(t, err)
+	require.Equal(t, "doc1", docID)
+}
+
+func TestParseConnectState(t *testing.T) {
+	state := parseConnectState([]byte(`{"userName":"user1","docType":"passport"}`))
+	require.Equal(t, &connectState{UserName: "user1", DocType: "passport"}, state)
+
+	// saved before the document types
+	state = parseConnectState([]byte(sampleUserName))
+	require.Equal(t, &connectState{UserName: sampleUserName}, state)
+}
+
+func TestRegisterDocuments(t *testing.T) {
+	svc := newTestOperation(t,
+		withUserCreation(t, &mockVaultClient{}),
+		withDashboardHTML(newTestHTMLFile(t)),
+		withConfig(func(config *Config) {
+			config.DocumentTypes = testDocumentTypes()
+		}),
+	)
+
+	t.Run("passport only", func(t *testing.T) {
+		req := &http.Request{Form: make(map[string][]string)}
+		req.Form.Add(username, sampleUserName)
+		req.Form.Add(password, samplePassword)
+		req.Form.Add("passport", "P1234567")
+
+		rr := httptest.NewRecorder()
+
+		svc.register(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		u, err := svc.getUserData(sampleUserName)
+		require.NoError(t, err)
+		require.Empty(t, u.NationalIDDocID)
+		require.Len(t, u.Documents, 1)
+		require.NotEmpty(t, u.Documents["passport"])
+	})
+
+	t.Run("all documents", func(t *testing.T) {
+		req := &http.Request{Form: make(map[string][]string)}
+		req.Form.Add(username, "jane.doe@example.com")
+		req.Form.Add(password, samplePassword)
+		req.Form.Add(nationalID, sampleNationalID)
+		req.Form.Add("passport", "P1234567")
+
+		rr := httptest.NewRecorder()
+
+		svc.register(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		u, err := svc.getUserData("jane.doe@example.com")
+		require.NoError(t, err)
+		require.Len(t, u.Documents, 2)
+		require.Equal(t, u.Documents[nationalID], u.NationalIDDocID)
+	})
+}
+
+func TestGenerateUserAuthsDocType(t *testing.T) {
+	newOperation := func(t *testing.T) (*Operation, *mockVaultClient, *mockComparatorClient) {
+		t.Helper()
+
+		vClient := &mockVaultClient{}
+		compClient := &mockComparatorClient{}
+
+		svc := newTestOperation(t, withUserAuthPolicy(t, nil, vClient, compClient))
+		svc.documentTypes = testDocumentTypes()
+
+		return svc, vClient, compClient
+	}
+
+	t.Run("success", func(t *testing.T) {
+		svc, vClient, compClient := newOperation(t)
+
+		uBytes, err := json.Marshal(&userData{
+			ID: "U1", UserName: sampleUserName, VaultID: "vault1",
+			Documents: map[string]string{"passport": "doc2"},
+		})
+		require.NoError(t, err)
+		require.NoError(t, svc.store.Put(sampleUserName, uBytes))
+
+		rr := httptest.NewRecorder()
+
+		svc.generateUserAuths(rr, newGenerateUserAuthDocTypeRequest(t, "passport", "U1"))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		require.Equal(t, "doc2", vClient.authorizationScopes[0].Target)
+		require.Equal(t, "$.credentialSubject.passportNumber", compClient.authorizations[0].Scope.DocAttrPath)
+
+		var resp *userAuthData
+
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		require.Equal(t, "passport", resp.DocType)
+	})
+
+	t.Run("user without the document", func(t *testing.T) {
+		svc, vClient, _ := newOperation(t)
+
+		rr := httptest.NewRecorder()
+
+		svc.generateUserAuths(rr, newGenerateUserAuthDocTypeRequest(t, "passport", "U1"))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "user U1 has no passport document")
+		require.Empty(t, vClient.authorizationScopes)
+	})
+
+	t.Run("unsupported document type", func(t *testing.T) {
+		svc, _, _ := newOperation(t)
+
+		rr := httptest.NewRecorder()
+
+		svc.generateUserAuths(rr, newGenerateUserAuthDocTypeRequest(t, "address", "U1"))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "unsupported document type address")
+	})
+}
+
+func TestConnectDocType(t *testing.T) {
+	svc := newTestOperation(t)
+
+	rr := httptest.NewRecorder()
+
+	svc.connect(rr, newSessionRequest(t, svc, http.MethodGet, connect+"?docType=passport", "user1"))
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	require.Contains(t, rr.Body.String(), "unsupported document type passport")
+}
+
+func TestAccountLinkCallbackDocType(t *testing.T) {
+	svc := newTestOperation(t)
+
+	stateBytes, err := json.Marshal(&connectState{UserName: sampleUserName, DocType: nationalID})
+	require.NoError(t, err)
+	require.NoError(t, svc.store.Put(connectStateKeyPrefix+"state1", stateBytes))
+
+	uBytes, err := json.Marshal(&userData{ID: "U1", UserName: sampleUserName, VaultID: "vault1"})
+	require.NoError(t, err)
+	require.NoError(t, svc.store.Put(sampleUserName, uBytes))
+	require.NoError(t, svc.userStore.Put("U1", uBytes, storage.Tag{Name: userTagName}))
+
+	rr := httptest.NewRecorder()
+
+	svc.accountLinkCallback(rr, httptest.NewRequest(http.MethodGet, accountLinkCallback+"?state=state1&auth=token", nil))
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	require.Contains(t, rr.Body.String(), "user U1 has no nationalID document")
+}
+
+func newGenerateUserAuthDocTypeRequest(t *testing.T, docType string, userIDs ...string) *http.Request {
+	t.Helper()
+
+	reqBytes, err := json.Marshal(generateUserAuthReq{Users: userIDs, DocType: docType})
+	require.NoError(t, err)
+
+	return httptest.NewRequest(http.MethodPost, generateUserAuth, bytes.NewReader(reqBytes))
+}
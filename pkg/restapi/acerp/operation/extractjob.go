@@ -0,0 +1,456 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	compclientops "github.com/trustbloc/edge-service/pkg/client/comparator/client/operations"
+	compmodel "github.com/trustbloc/edge-service/pkg/client/comparator/models"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/util"
+)
+
+const (
+	extractJobStoreName = "extract_job"
+	extractJobTagName   = "extractjob"
+
+	// job status
+	extractJobPending   = "pending"
+	extractJobRunning   = "running"
+	extractJobCompleted = "completed"
+	extractJobFailed    = "failed"
+
+	// user result status
+	extractSucceeded = "succeeded"
+	extractFailed    = "failed"
+
+	defaultExtractWorkers    = 2
+	defaultExtractChunkSize  = 10
+	defaultExtractRetries    = 3
+	defaultExtractRetryDelay = 2 * time.Second
+	defaultExtractRetention  = 24 * time.Hour
+
+	extractQueueSize = 100
+)
+
+var errExtractQueueFull = errors.New("too many extract jobs in progress, try again later")
+
+// startExtractWorkers starts the pool of the workers which run the extraction jobs, and requeues the jobs which
+// weren't finished before a restart. The workers stop when the operation is closed.
+func (o *Operation) startExtractWorkers(workers int) {
+	for i := 0; i < workers; i++ {
+		o.workers.Add(1)
+
+		go func() {
+			defer o.workers.Done()
+
+			for {
+				select {
+				case <-o.ctx.Done():
+					return
+				case id := <-o.extractQueue:
+					o.runExtractJob(id)
+				}
+			}
+		}()
+	}
+
+	jobs, err := o.getExtractJobs()
+	if err != nil {
+		logger.Warnf("failed to get the unfinished extract jobs: %s", err.Error())
+
+		return
+	}
+
+	// the unfinished jobs may be more than the queue holds, so they are queued as the workers take them
+	go func() {
+		for _, j := range jobs {
+			if j.Status != extractJobPending && j.Status != extractJobRunning {
+				continue
+			}
+
+			select {
+			case <-o.ctx.Done():
+				return
+			case o.extractQueue <- j.ID:
+			}
+		}
+	}()
+}
+
+func (o *Operation) createExtractJob(w http.ResponseWriter, r *http.Request) {
+	req := &extractJobReq{}
+
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("failed to decode request: %s", err.Error()))
+
+		return
+	}
+
+	auths, err := o.getUserAuthData(req.ExtractID)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		o.writeErrorResponse(w, http.StatusNotFound, fmt.Sprintf("extract %s not found", req.ExtractID))
+
+		return
+	}
+
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to get user auth data %s : %s", req.ExtractID, err.Error()))
+
+		return
+	}
+
+	o.deleteExpiredExtractJobs()
+
+	now := time.Now()
+
+	job := &extractJob{
+		ID:          uuid.NewString(),
+		ExtractID:   req.ExtractID,
+		DocType:     auths.DocType,
+		Status:      extractJobPending,
+		Results:     make([]userExtractResult, 0),
+		CreatedTime: util.NewTime(now),
+		UpdatedTime: util.NewTime(now),
+		ExpiryTime:  util.NewTime(now.Add(o.extractRetention)),
+	}
+
+	err = o.saveExtractJob(job)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to save extract job: %s", err.Error()))
+
+		return
+	}
+
+	err = o.queueExtractJob(job.ID)
+	if err != nil {
+		// the job isn't kept, so that the caller creates it again
+		if delErr := o.extractJobStore.Delete(job.ID); delErr != nil {
+			logger.Warnf("failed to delete extract job %s: %s", job.ID, delErr.Error())
+		}
+
+		o.writeErrorResponse(w, http.StatusServiceUnavailable, err.Error())
+
+		return
+	}
+
+	logger.Infof("createExtractJob : jobID=[%s] extractID=[%s]", job.ID, job.ExtractID)
+
+	o.writeResponse(w, http.StatusAccepted, job)
+}
+
+// getExtractJob returns the progress of the job, and the results of the users processed so far.
+func (o *Operation) getExtractJob(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	job, err := o.getExtractJobData(id)
+	if errors.Is(err, storage.ErrDataNotFound) || (err == nil && job.expired()) {
+		o.writeErrorResponse(w, http.StatusNotFound, fmt.Sprintf("extract job %s not found", id))
+
+		return
+	}
+
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to get extract job %s : %s", id, err.Error()))
+
+		return
+	}
+
+	o.writeResponse(w, http.StatusOK, job)
+}
+
+// queueExtractJob queues the job for the workers, or fails if the queue is full.
+func (o *Operation) queueExtractJob(id string) error {
+	select {
+	case o.extractQueue <- id:
+		return nil
+	default:
+		return errExtractQueueFull
+	}
+}
+
+// runExtractJob extracts the documents in chunks, saving the progress after each chunk. A chunk which fails after
+// the retries fails its users only.
+func (o *Operation) runExtractJob(id string) {
+	job, err := o.getExtractJobData(id)
+	if err != nil {
+		logger.Errorf("failed to get extract job %s : %s", id, err.Error())
+
+		return
+	}
+
+	auths, err := o.getUserAuthData(job.ExtractID)
+	if err != nil {
+		job.fail(fmt.Sprintf("failed to get user auth data: %s", err.Error()))
+		o.updateExtractJob(job)
+
+		return
+	}
+
+	job.Status = extractJobRunning
+	job.Total = len(auths.UserAuths)
+	o.updateExtractJob(job)
+
+	// results of a job restarted after a crash are kept
+	for start := len(job.Results); start < len(auths.UserAuths); start += o.extractChunkSize {
+		end := start + o.extractChunkSize
+		if end > len(auths.UserAuths) {
+			end = len(auths.UserAuths)
+		}
+
+		results := o.extractChunk(auths.UserAuths[start:end])
+
+		// the job is left running when the operation is closed, so that the chunk is extracted on the next start
+		if o.ctx.Err() != nil {
+			return
+		}
+
+		job.Results = append(job.Results, results...)
+		job.Processed = len(job.Results)
+		o.updateExtractJob(job)
+	}
+
+	job.Status = extractJobCompleted
+
+	if job.Total > 0 && job.failedCount() == job.Total {
+		job.Status = extractJobFailed
+	}
+
+	o.updateExtractJob(job)
+
+	logger.Infof("runExtractJob : jobID=[%s] status=[%s] processed=[%d] failed=[%d]",
+		job.ID, job.Status, job.Processed, job.failedCount())
+}
+
+func (o *Operation) extractChunk(auths []userAuthorization) []userExtractResult {
+	queries := make([]compmodel.Query, 0, len(auths))
+	queryIDs := make([]string, 0, len(auths))
+
+	for _, a := range auths {
+		queryID := uuid.NewString()
+		token := a.AuthToken
+
+		query := &compmodel.AuthorizedQuery{AuthToken: &token}
+		query.SetID(queryID)
+
+		queries = append(queries, query)
+		queryIDs = append(queryIDs, queryID)
+	}
+
+	request := &compmodel.Extract{}
+	request.SetQueries(queries)
+
+	var (
+		resp *compclientops.PostExtractOK
+		err  error
+	)
+
+	for attempt := 0; attempt <= o.extractRetries; attempt++ {
+		if attempt > 0 {
+			if err = o.sleep(o.extractRetryDelay); err != nil {
+				break
+			}
+		}
+
+		resp, err = o.compClient.PostExtract(
+			compclientops.NewPostExtractParams().WithTimeout(requestTimeout).WithExtract(request),
+		)
+		if err == nil {
+			break
+		}
+
+		logger.Warnf("extract attempt %d failed: %s", attempt+1, err.Error())
+	}
+
+	docs := make(map[string]interface{})
+
+	if err == nil && resp.Payload != nil {
+		for _, d := range resp.Payload.Documents {
+			docs[d.ID] = d.Contents
+		}
+	}
+
+	results := make([]userExtractResult, len(auths))
+
+	for i, a := range auths {
+		results[i] = userExtractResult{ID: a.ID, Name: a.Name, DID: a.DID, Status: extractFailed}
+
+		contents, ok := docs[queryIDs[i]]
+
+		switch {
+		case err != nil:
+			results[i].Error = fmt.Sprintf("failed to extract data: %s", err.Error())
+		case !ok:
+			results[i].Error = "no document returned by the comparator"
+		default:
+			value, isString := contents.(string)
+			if !isString {
+				results[i].Error = "invalid content; expected string type"
+
+				continue
+			}
+
+			results[i].Value = value
+			results[i].Status = extractSucceeded
+		}
+	}
+
+	return results
+}
+
+func (o *Operation) updateExtractJob(job *extractJob) {
+	job.UpdatedTime = util.NewTime(time.Now())
+
+	err := o.saveExtractJob(job)
+	if err != nil {
+		logger.Errorf("failed to save extract job %s : %s", job.ID, err.Error())
+	}
+}
+
+func (o *Operation) deleteExpiredExtractJobs() {
+	jobs, err := o.getExtractJobs()
+	if err != nil {
+		logger.Warnf("failed to get extract jobs: %s", err.Error())
+
+		return
+	}
+
+	for _, j := range jobs {
+		if !j.expired() {
+			continue
+		}
+
+		err = o.extractJobStore.Delete(j.ID)
+		if err != nil {
+			logger.Warnf("failed to delete extract job %s: %s", j.ID, err.Error())
+		}
+	}
+}
+
+func (o *Operation) getUserAuthData(id string) (*userAuthData, error) {
+	dataBytes, err := o.userAuthStore.Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("get user auth data: %w", err)
+	}
+
+	var data *userAuthData
+
+	err = json.Unmarshal(dataBytes, &data)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal user auth data: %w", err)
+	}
+
+	return data, nil
+}
+
+func (o *Operation) getExtractJobData(id string) (*extractJob, error) {
+	jobBytes, err := o.extractJobStore.Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("get extract job: %w", err)
+	}
+
+	var job *extractJob
+
+	err = json.Unmarshal(jobBytes, &job)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal extract job: %w", err)
+	}
+
+	return job, nil
+}
+
+func (o *Operation) getExtractJobs() ([]*extractJob, error) {
+	jobs := make([]*extractJob, 0)
+
+	err := forEachValue(o.extractJobStore, extractJobTagName, func(value []byte) error {
+		var job *extractJob
+
+		err := json.Unmarshal(value, &job)
+		if err != nil {
+			return fmt.Errorf("unmarshal extract job: %w", err)
+		}
+
+		jobs = append(jobs, job)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+func (o *Operation) saveExtractJob(job *extractJob) error {
+	jobBytes, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("marshal extract job: %w", err)
+	}
+
+	return o.extractJobStore.Put(job.ID, jobBytes, storage.Tag{Name: extractJobTagName})
+}
+
+func (j *extractJob) expired() bool {
+	return j.ExpiryTime != nil && time.Now().After(j.ExpiryTime.Time)
+}
+
+func (j *extractJob) fail(reason string) {
+	j.Status = extractJobFailed
+	j.Error = reason
+}
+
+func (j *extractJob) failedCount() int {
+	count := 0
+
+	for _, r := range j.Results {
+		if r.Status == extractFailed {
+			count++
+		}
+	}
+
+	return count
+}
+
+func intOrDefault(v, defaultValue int) int {
+	if v <= 0 {
+		return defaultValue
+	}
+
+	return v
+}
+
+func durationOrDefault(v, defaultValue time.Duration) time.Duration {
+	if v <= 0 {
+		return defaultValue
+	}
+
+	return v
+}
+
+// sleep waits for the duration, or fails when the operation is closed.
+func (o *Operation) sleep(d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-o.ctx.Done():
+		return o.ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
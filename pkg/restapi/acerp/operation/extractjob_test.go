@@ -0,0 +1,474 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	mockstorage "github.com/hyperledger/aries-framework-go/component/storageutil/mock"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/util"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+	compclientops "github.com/trustbloc/edge-service/pkg/client/comparator/client/operations"
+	compmodel "github.com/trustbloc/edge-service/pkg/client/comparator/models"
+)
+
+func TestCreateExtractJob(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		svc := newTestOperation(t, withComparatorClient(&mockComparatorClient{}))
+		extractID := saveTestUserAuths(t, svc, 5)
+
+		rr := httptest.NewRecorder()
+
+		svc.createExtractJob(rr, newExtractJobRequest(t, extractID))
+		require.Equal(t, http.StatusAccepted, rr.Code)
+
+		var job *extractJob
+
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &job))
+		require.NotEmpty(t, job.ID)
+		require.Equal(t, extractID, job.ExtractID)
+		require.Equal(t, extractJobPending, job.Status)
+
+		job = waitForExtractJob(t, svc, job.ID)
+		require.Equal(t, extractJobCompleted, job.Status)
+		require.Equal(t, 5, job.Total)
+		require.Equal(t, 5, job.Processed)
+		require.Len(t, job.Results, 5)
+
+		for _, r := range job.Results {
+			require.Equal(t, extractSucceeded, r.Status)
+			require.NotEmpty(t, r.Value)
+		}
+	})
+
+	t.Run("invalid request", func(t *testing.T) {
+		svc := newTestOperation(t, withComparatorClient(&mockComparatorClient{}))
+
+		rr := httptest.NewRecorder()
+
+		svc.createExtractJob(rr, httptest.NewRequest(http.MethodPost, extractJobs, bytes.NewReader([]byte("invalid"))))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to decode request")
+	})
+
+	t.Run("extract not found", func(t *testing.T) {
+		svc := newTestOperation(t, withComparatorClient(&mockComparatorClient{}))
+
+		rr := httptest.NewRecorder()
+
+		svc.createExtractJob(rr, newExtractJobRequest(t, "invalid"))
+		require.Equal(t, http.StatusNotFound, rr.Code)
+		require.Contains(t, rr.Body.String(), "extract invalid not found")
+	})
+
+	t.Run("user auth data errors", func(t *testing.T) {
+		svc := newTestOperation(t, withComparatorClient(&mockComparatorClient{}))
+
+		svc.userAuthStore = &mockstorage.Store{ErrGet: errors.New("get error")}
+
+		rr := httptest.NewRecorder()
+
+		svc.createExtractJob(rr, newExtractJobRequest(t, "extract1"))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to get user auth data extract1 : get user auth data: get error")
+
+		svc.userAuthStore = &mockstorage.Store{GetReturn: []byte("invalid")}
+
+		rr = httptest.NewRecorder()
+
+		svc.createExtractJob(rr, newExtractJobRequest(t, "extract1"))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "unmarshal user auth data")
+	})
+
+	t.Run("save error", func(t *testing.T) {
+		svc := newTestOperation(t, withComparatorClient(&mockComparatorClient{}))
+		extractID := saveTestUserAuths(t, svc, 1)
+
+		svc.extractJobStore = &mockstorage.Store{
+			QueryReturn: &mockstorage.Iterator{},
+			ErrPut:      errors.New("put error"),
+		}
+
+		rr := httptest.NewRecorder()
+
+		svc.createExtractJob(rr, newExtractJobRequest(t, extractID))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to save extract job: put error")
+	})
+
+	t.Run("queue full", func(t *testing.T) {
+		svc := newTestOperation(t, withComparatorClient(&mockComparatorClient{}))
+		extractID := saveTestUserAuths(t, svc, 1)
+
+		// the workers are stopped, so that the queue isn't drained
+		svc.Close()
+
+		for i := 0; i < extractQueueSize; i++ {
+			svc.extractQueue <- uuid.NewString()
+		}
+
+		rr := httptest.NewRecorder()
+
+		svc.createExtractJob(rr, newExtractJobRequest(t, extractID))
+		require.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		require.Contains(t, rr.Body.String(), errExtractQueueFull.Error())
+
+		jobs, err := svc.getExtractJobs()
+		require.NoError(t, err)
+		require.Empty(t, jobs)
+	})
+
+	t.Run("expired jobs are deleted", func(t *testing.T) {
+		svc := newTestOperation(t, withComparatorClient(&mockComparatorClient{}))
+		extractID := saveTestUserAuths(t, svc, 1)
+
+		expired := &extractJob{
+			ID:         uuid.NewString(),
+			ExtractID:  extractID,
+			Status:     extractJobCompleted,
+			ExpiryTime: util.NewTime(time.Now().Add(-time.Minute)),
+		}
+		require.NoError(t, svc.saveExtractJob(expired))
+
+		rr := httptest.NewRecorder()
+
+		svc.createExtractJob(rr, newExtractJobRequest(t, extractID))
+		require.Equal(t, http.StatusAccepted, rr.Code)
+
+		_, err := svc.extractJobStore.Get(expired.ID)
+		require.True(t, errors.Is(err, storage.ErrDataNotFound))
+	})
+}
+
+func TestGetExtractJob(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		job := &extractJob{
+			ID:         uuid.NewString(),
+			Status:     extractJobRunning,
+			Total:      2,
+			Processed:  1,
+			Results:    []userExtractResult{{ID: "U1", Status: extractSucceeded, Value: "123"}},
+			ExpiryTime: util.NewTime(time.Now().Add(time.Hour)),
+		}
+		require.NoError(t, svc.saveExtractJob(job))
+
+		rr := httptest.NewRecorder()
+
+		svc.getExtractJob(rr, newGetExtractJobRequest(job.ID))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var resp *extractJob
+
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		require.Equal(t, extractJobRunning, resp.Status)
+		require.Equal(t, 1, resp.Processed)
+		require.Equal(t, "123", resp.Results[0].Value)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		rr := httptest.NewRecorder()
+
+		svc.getExtractJob(rr, newGetExtractJobRequest("invalid"))
+		require.Equal(t, http.StatusNotFound, rr.Code)
+		require.Contains(t, rr.Body.String(), "extract job invalid not found")
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		job := &extractJob{
+			ID:         uuid.NewString(),
+			Status:     extractJobCompleted,
+			ExpiryTime: util.NewTime(time.Now().Add(-time.Minute)),
+		}
+		require.NoError(t, svc.saveExtractJob(job))
+
+		rr := httptest.NewRecorder()
+
+		svc.getExtractJob(rr, newGetExtractJobRequest(job.ID))
+		require.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		svc.extractJobStore = &mockstorage.Store{ErrGet: errors.New("get error")}
+
+		rr := httptest.NewRecorder()
+
+		svc.getExtractJob(rr, newGetExtractJobRequest("job1"))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to get extract job job1 : get extract job: get error")
+	})
+}
+
+func TestRunExtractJob(t *testing.T) {
+	t.Run("chunks with partial failures", func(t *testing.T) {
+		var (
+			mutex sync.Mutex
+			calls int
+		)
+
+		compClient := &mockComparatorClient{}
+		compClient.postExtractFunc = func(
+			params *compclientops.PostExtractParams) (*compclientops.PostExtractOK, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			calls++
+
+			queries := params.Extract.Queries()
+
+			switch calls {
+			case 2, 3:
+				// second chunk fails with its retry
+				return nil, errors.New("comparator error")
+			case 1:
+				// first chunk misses a document and has an invalid one
+				return &compclientops.PostExtractOK{Payload: &compmodel.ExtractResp{
+					Documents: []*compmodel.ExtractRespDocumentsItems0{
+						{ID: queries[0].ID(), Contents: "123"},
+						{ID: queries[1].ID(), Contents: 123},
+					},
+				}}, nil
+			}
+
+			docs := make([]*compmodel.ExtractRespDocumentsItems0, len(queries))
+			for i, q := range queries {
+				docs[i] = &compmodel.ExtractRespDocumentsItems0{ID: q.ID(), Contents: "456"}
+			}
+
+			return &compclientops.PostExtractOK{Payload: &compmodel.ExtractResp{Documents: docs}}, nil
+		}
+
+		s := newTestOperation(t, withExtractSettings(), withComparatorClient(compClient))
+		extractID := saveTestUserAuths(t, s, 7)
+
+		job := createTestExtractJob(t, s, extractID)
+
+		job = waitForExtractJob(t, s, job.ID)
+		require.Equal(t, extractJobCompleted, job.Status)
+		require.Equal(t, 7, job.Total)
+		require.Equal(t, 7, job.Processed)
+
+		statuses := make([]string, len(job.Results))
+		for i, r := range job.Results {
+			statuses[i] = r.Status
+		}
+
+		require.Equal(t, []string{
+			extractSucceeded, extractFailed, extractFailed,
+			extractFailed, extractFailed, extractFailed,
+			extractSucceeded,
+		}, statuses)
+
+		require.Equal(t, "123", job.Results[0].Value)
+		require.Equal(t, "invalid content; expected string type", job.Results[1].Error)
+		require.Equal(t, "no document returned by the comparator", job.Results[2].Error)
+		require.Equal(t, "failed to extract data: comparator error", job.Results[3].Error)
+		require.Equal(t, "456", job.Results[6].Value)
+		require.Equal(t, 5, job.failedCount())
+	})
+
+	t.Run("retry succeeds", func(t *testing.T) {
+		var (
+			mutex sync.Mutex
+			calls int
+		)
+
+		compClient := &mockComparatorClient{}
+		compClient.postExtractFunc = func(
+			params *compclientops.PostExtractParams) (*compclientops.PostExtractOK, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			calls++
+			if calls == 1 {
+				return nil, errors.New("comparator error")
+			}
+
+			return (&mockComparatorClient{}).PostExtract(params)
+		}
+
+		s := newTestOperation(t, withExtractSettings(), withComparatorClient(compClient))
+		extractID := saveTestUserAuths(t, s, 2)
+
+		job := waitForExtractJob(t, s, createTestExtractJob(t, s, extractID).ID)
+		require.Equal(t, extractJobCompleted, job.Status)
+		require.Zero(t, job.failedCount())
+	})
+
+	t.Run("all users failed", func(t *testing.T) {
+		compClient := &mockComparatorClient{PostExtractErr: errors.New("comparator error")}
+
+		s := newTestOperation(t, withExtractSettings(), withComparatorClient(compClient))
+		extractID := saveTestUserAuths(t, s, 2)
+
+		job := waitForExtractJob(t, s, createTestExtractJob(t, s, extractID).ID)
+		require.Equal(t, extractJobFailed, job.Status)
+		require.Equal(t, 2, job.failedCount())
+	})
+
+	t.Run("user auth data removed", func(t *testing.T) {
+		s := newTestOperation(t, withExtractSettings(), withComparatorClient(&mockComparatorClient{}))
+
+		job := &extractJob{ID: uuid.NewString(), ExtractID: "invalid", Status: extractJobPending}
+		require.NoError(t, s.saveExtractJob(job))
+
+		s.runExtractJob(job.ID)
+
+		job, err := s.getExtractJobData(job.ID)
+		require.NoError(t, err)
+		require.Equal(t, extractJobFailed, job.Status)
+		require.Contains(t, job.Error, "failed to get user auth data")
+	})
+
+	t.Run("unfinished jobs are resumed", func(t *testing.T) {
+		s := newTestOperation(t, withExtractSettings(), withComparatorClient(&mockComparatorClient{}))
+		extractID := saveTestUserAuths(t, s, 3)
+
+		// the first user was processed before the restart
+		job := &extractJob{
+			ID:         uuid.NewString(),
+			ExtractID:  extractID,
+			Status:     extractJobRunning,
+			Processed:  1,
+			Results:    []userExtractResult{{ID: "done", Status: extractSucceeded, Value: "789"}},
+			ExpiryTime: util.NewTime(time.Now().Add(time.Hour)),
+		}
+		require.NoError(t, s.saveExtractJob(job))
+
+		s.startExtractWorkers(1)
+
+		job = waitForExtractJob(t, s, job.ID)
+		require.Equal(t, extractJobCompleted, job.Status)
+		require.Equal(t, 3, job.Processed)
+		require.Equal(t, "789", job.Results[0].Value)
+		require.Equal(t, "U1", job.Results[1].ID)
+	})
+
+	t.Run("closed operation", func(t *testing.T) {
+		s := newTestOperation(t, withExtractSettings(), withComparatorClient(&mockComparatorClient{}))
+		extractID := saveTestUserAuths(t, s, 3)
+
+		job := &extractJob{ID: uuid.NewString(), ExtractID: extractID, Status: extractJobPending}
+		require.NoError(t, s.saveExtractJob(job))
+
+		s.Close()
+		s.runExtractJob(job.ID)
+
+		// the job is resumed on the next start
+		job, err := s.getExtractJobData(job.ID)
+		require.NoError(t, err)
+		require.Equal(t, extractJobRunning, job.Status)
+		require.Empty(t, job.Results)
+	})
+
+	t.Run("query error on start", func(t *testing.T) {
+		s := newTestOperation(t, withExtractSettings(), withComparatorClient(&mockComparatorClient{}))
+
+		s.extractJobStore = &mockstorage.Store{ErrQuery: errors.New("query error")}
+
+		require.NotPanics(t, func() { s.startExtractWorkers(1) })
+	})
+}
+
+// withExtractSettings makes the jobs extract chunks of 3 users with a single immediate retry.
+func withExtractSettings() testOperationOption {
+	return withConfig(func(config *Config) {
+		config.ExtractChunkSize = 3
+		config.ExtractRetries = 1
+		config.ExtractRetryDelay = time.Millisecond
+	})
+}
+
+func saveTestUserAuths(t *testing.T, svc *Operation, count int) string {
+	t.Helper()
+
+	data := &userAuthData{
+		ID:            uuid.NewString(),
+		Source:        "test",
+		SubmittedTime: util.NewTime(time.Now()),
+	}
+
+	for i := 0; i < count; i++ {
+		data.UserAuths = append(data.UserAuths, userAuthorization{
+			ID:        fmt.Sprintf("U%d", i),
+			Name:      sampleUserName,
+			DID:       "did:example:123",
+			AuthToken: uuid.NewString(),
+		})
+	}
+
+	dataBytes, err := json.Marshal(data)
+	require.NoError(t, err)
+
+	require.NoError(t, svc.userAuthStore.Put(data.ID, dataBytes, storage.Tag{Name: userTagName}))
+
+	return data.ID
+}
+
+func createTestExtractJob(t *testing.T, svc *Operation, extractID string) *extractJob {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+
+	svc.createExtractJob(rr, newExtractJobRequest(t, extractID))
+	require.Equal(t, http.StatusAccepted, rr.Code)
+
+	var job *extractJob
+
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &job))
+
+	return job
+}
+
+func waitForExtractJob(t *testing.T, svc *Operation, id string) *extractJob {
+	t.Helper()
+
+	var job *extractJob
+
+	require.Eventually(t, func() bool {
+		var err error
+
+		job, err = svc.getExtractJobData(id)
+		require.NoError(t, err)
+
+		return job.Status == extractJobCompleted || job.Status == extractJobFailed
+	}, 5*time.Second, 10*time.Millisecond)
+
+	return job
+}
+
+func newExtractJobRequest(t *testing.T, extractID string) *http.Request {
+	t.Helper()
+
+	reqBytes, err := json.Marshal(&extractJobReq{ExtractID: extractID})
+	require.NoError(t, err)
+
+	return httptest.NewRequest(http.MethodPost, extractJobs, bytes.NewReader(reqBytes))
+}
+
+func newGetExtractJobRequest(id string) *http.Request {
+	return mux.SetURLVars(httptest.NewRequest(http.MethodGet, extractJobs+"/"+id, nil), map[string]string{"id": id})
+}
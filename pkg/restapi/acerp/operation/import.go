@@ -0,0 +1,528 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/util"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+)
+
+const (
+	userImport   = users + "/import"
+	getImportJob = userImport + "/{id}"
+
+	importJobStoreName = "import_job"
+	importJobTagName   = "importjob"
+
+	csvContentType = "text/csv"
+
+	// job status
+	importJobPending   = "pending"
+	importJobRunning   = "running"
+	importJobCompleted = "completed"
+	importJobFailed    = "failed"
+
+	// import row status
+	importCreated     = "created"
+	importExists      = "exists"
+	importPasswordSet = "passwordSet"
+	importFailed      = "failed"
+
+	maxImportRows          = 1000
+	defaultImportWorkers   = 4
+	defaultImportRate      = 10
+	defaultImportRetention = 24 * time.Hour
+)
+
+// importNamespace is the namespace of the ids of the registrations of the imported users. The registration of a
+// username always has the same id, so that an import can be retried.
+var importNamespace = uuid.MustParse("5d1f4a3e-6c1b-4f0e-9a57-2f8e0b7d3c41") // nolint: gochecknoglobals
+
+// importUsers starts a job which registers the users of the CSV or JSON body. The caller polls the job for the status
+// of each row. The users which already exist are skipped, so that the same file can be imported again.
+func (o *Operation) importUsers(w http.ResponseWriter, r *http.Request) {
+	rows, err := o.readImportRows(r)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid import : %s", err.Error()))
+
+		return
+	}
+
+	o.deleteExpiredImportJobs()
+
+	now := time.Now()
+
+	job := &importJob{
+		ID:          uuid.NewString(),
+		Status:      importJobPending,
+		Total:       len(rows),
+		Results:     make([]userImportResult, 0, len(rows)),
+		CreatedTime: util.NewTime(now),
+		UpdatedTime: util.NewTime(now),
+		ExpiryTime:  util.NewTime(now.Add(o.importRetention)),
+	}
+
+	err = o.saveImportJob(job)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to save import job: %s", err.Error()))
+
+		return
+	}
+
+	logger.Infof("importUsers : jobID=[%s] total=[%d]", job.ID, job.Total)
+
+	o.writeResponse(w, http.StatusAccepted, job)
+
+	// the job is updated by the import once the response is written
+	o.workers.Add(1)
+
+	go func() {
+		defer o.workers.Done()
+
+		o.runImportJob(job, rows)
+	}()
+}
+
+// getImportJob returns the progress of the import, and the results of the rows imported so far.
+func (o *Operation) getImportJob(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	job, err := o.getImportJobData(id)
+	if errors.Is(err, storage.ErrDataNotFound) || (err == nil && job.expired()) {
+		o.writeErrorResponse(w, http.StatusNotFound, fmt.Sprintf("import job %s not found", id))
+
+		return
+	}
+
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to get import job %s : %s", id, err.Error()))
+
+		return
+	}
+
+	o.writeResponse(w, http.StatusOK, job)
+}
+
+// runImportJob imports the rows, saving the progress after each row. The rows aren't saved, as they have the
+// passwords of the users, so a job interrupted by a restart fails and the file is imported again.
+func (o *Operation) runImportJob(job *importJob, rows []userImportRow) {
+	mu := sync.Mutex{}
+
+	job.Status = importJobRunning
+	o.updateImportJob(job)
+
+	o.runImport(o.ctx, rows, func(res userImportResult) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		job.add(res)
+		o.updateImportJob(job)
+	})
+
+	sort.Slice(job.Results, func(i, j int) bool { return job.Results[i].Row < job.Results[j].Row })
+
+	job.Status = importJobCompleted
+
+	switch {
+	case o.ctx.Err() != nil:
+		job.Status = importJobFailed
+		job.Error = "import interrupted by a shutdown, the users can be imported again"
+	case job.Failed == job.Total:
+		job.Status = importJobFailed
+	}
+
+	o.updateImportJob(job)
+
+	logger.Infof("runImportJob : jobID=[%s] total=[%d] created=[%d] existing=[%d] failed=[%d]",
+		job.ID, job.Total, job.Created, job.Existing, job.Failed)
+}
+
+// recoverImportJobs fails the import jobs which were interrupted by a restart.
+func (o *Operation) recoverImportJobs() {
+	jobs, err := o.getImportJobs()
+	if err != nil {
+		logger.Warnf("failed to get the unfinished import jobs: %s", err.Error())
+
+		return
+	}
+
+	for _, j := range jobs {
+		if j.Status == importJobPending || j.Status == importJobRunning {
+			j.Status = importJobFailed
+			j.Error = "import interrupted by a restart, the users can be imported again"
+			o.updateImportJob(j)
+		}
+	}
+}
+
+// readImportRows reads the users of a CSV body, with a header of the username, the password and the document type
+// columns, or of a JSON body.
+func (o *Operation) readImportRows(r *http.Request) ([]userImportRow, error) {
+	var (
+		rows []userImportRow
+		err  error
+	)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")) // nolint: errcheck
+	if mediaType == csvContentType {
+		rows, err = o.readImportCSV(r.Body)
+	} else {
+		req := &userImportReq{}
+
+		err = json.NewDecoder(r.Body).Decode(req)
+		rows = req.Users
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, errors.New("no users to import")
+	}
+
+	if len(rows) > maxImportRows {
+		return nil, fmt.Errorf("at most %d users can be imported at once", maxImportRows)
+	}
+
+	return rows, nil
+}
+
+func (o *Operation) readImportCSV(body io.Reader) ([]userImportRow, error) {
+	records, err := csv.NewReader(body).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("read csv : %w", err)
+	}
+
+	if len(records) == 0 {
+		return nil, errors.New("missing csv header")
+	}
+
+	header := records[0]
+
+	for i, name := range header {
+		header[i] = strings.TrimSpace(name)
+
+		if header[i] == username || header[i] == password {
+			continue
+		}
+
+		if _, err = o.documentType(header[i]); err != nil || header[i] == "" {
+			return nil, fmt.Errorf("unsupported column '%s'", header[i])
+		}
+	}
+
+	rows := make([]userImportRow, 0, len(records)-1)
+
+	for _, record := range records[1:] {
+		row := userImportRow{Documents: make(map[string]string)}
+
+		for i, v := range record {
+			switch header[i] {
+			case username:
+				row.UserName = strings.TrimSpace(v)
+			case password:
+				row.Password = v
+			default:
+				if v = strings.TrimSpace(v); v != "" {
+					row.Documents[header[i]] = v
+				}
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// runImport imports the rows with the pool of the import workers, and reports the result of each row. The
+// registrations are started at the import rate, as each registration saves the documents in the vault and has the
+// vcs signed by the issuer.
+func (o *Operation) runImport(ctx context.Context, rows []userImportRow, report func(res userImportResult)) {
+	queue := make(chan int)
+	seen := make(map[string]bool)
+
+	limiter := time.NewTicker(time.Second / time.Duration(o.importRate))
+	defer limiter.Stop()
+
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < o.importWorkers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range queue {
+				select {
+				case <-ctx.Done():
+				case <-limiter.C:
+				}
+
+				// the rows left are failed if the import is canceled
+				if err := ctx.Err(); err != nil {
+					report(importResult(i, &rows[i], err))
+
+					continue
+				}
+
+				report(o.importUser(ctx, i, &rows[i]))
+			}
+		}()
+	}
+
+	for i := range rows {
+		// the registrations of the same username would conflict
+		if seen[rows[i].UserName] {
+			report(importResult(i, &rows[i], errors.New("duplicate username")))
+
+			continue
+		}
+
+		seen[rows[i].UserName] = true
+		queue <- i
+	}
+
+	close(queue)
+	wg.Wait()
+}
+
+// importUser runs the registration saga of the user of the row, unless the user already exists.
+func (o *Operation) importUser(ctx context.Context, i int, row *userImportRow) userImportResult {
+	if row.UserName == "" {
+		return importResult(i, row, errors.New("missing username"))
+	}
+
+	uData, err := o.getUserData(row.UserName)
+	if err == nil {
+		return o.importExistingUser(i, row, uData)
+	}
+
+	if !errors.Is(err, storage.ErrDataNotFound) {
+		return importResult(i, row, err)
+	}
+
+	values, err := o.importDocumentValues(row.Documents)
+	if err != nil {
+		return importResult(i, row, err)
+	}
+
+	err = validatePassword(row.Password)
+	if err != nil {
+		return importResult(i, row, err)
+	}
+
+	cred, err := newUserCredential(row.Password)
+	if err != nil {
+		return importResult(i, row, fmt.Errorf("failed to hash password : %w", err))
+	}
+
+	regID := uuid.NewSHA1(importNamespace, []byte(row.UserName)).String()
+
+	reg, err := o.startRegistration(regID, row.UserName, cred)
+	if err != nil {
+		return importResult(i, row, err)
+	}
+
+	res := userImportResult{Row: i + 1, UserName: row.UserName, RegistrationID: reg.ID}
+
+	// the registration was completed by a previous import
+	if reg.Status == registrationCompleted {
+		res.Status = importExists
+		res.UserID = reg.UserID
+
+		return res
+	}
+
+	err = o.runRegistration(ctx, reg, values)
+	if err != nil {
+		o.rollbackRegistration(reg, err)
+
+		res.Status = importFailed
+		res.Error = err.Error()
+
+		return res
+	}
+
+	res.Status = importCreated
+	res.UserID = reg.UserID
+
+	return res
+}
+
+// importDocumentValues checks the documents of an imported user like documentValues checks the registration form.
+func (o *Operation) importDocumentValues(docs map[string]string) (map[string]string, error) {
+	values := make(map[string]string)
+
+	for name, v := range docs {
+		if _, err := o.documentType(name); err != nil || name == "" {
+			return nil, fmt.Errorf("unsupported document type '%s'", name)
+		}
+
+		if v != "" {
+			values[name] = v
+		}
+	}
+
+	if len(values) == 0 {
+		return nil, errors.New("at least one document is mandatory")
+	}
+
+	return values, nil
+}
+
+// importExistingUser sets the password of the row for an existing user who has none, as the users registered before
+// the passwords were added can't log in otherwise. The passwords of the other users aren't changed.
+func (o *Operation) importExistingUser(i int, row *userImportRow, uData *userData) userImportResult {
+	res := userImportResult{Row: i + 1, UserName: row.UserName, Status: importExists, UserID: uData.ID}
+
+	_, err := o.getUserCredential(row.UserName)
+	if err == nil {
+		return res
+	}
+
+	if !errors.Is(err, storage.ErrDataNotFound) {
+		return importResult(i, row, err)
+	}
+
+	err = validatePassword(row.Password)
+	if err != nil {
+		return importResult(i, row, err)
+	}
+
+	cred, err := newUserCredential(row.Password)
+	if err != nil {
+		return importResult(i, row, fmt.Errorf("failed to hash password : %w", err))
+	}
+
+	set, err := o.setMissingPassword(row.UserName, cred)
+	if err != nil {
+		return importResult(i, row, err)
+	}
+
+	if set {
+		res.Status = importPasswordSet
+	}
+
+	return res
+}
+
+func importResult(i int, row *userImportRow, err error) userImportResult {
+	return userImportResult{Row: i + 1, UserName: row.UserName, Status: importFailed, Error: err.Error()}
+}
+
+func (o *Operation) updateImportJob(job *importJob) {
+	job.UpdatedTime = util.NewTime(time.Now())
+
+	err := o.saveImportJob(job)
+	if err != nil {
+		logger.Errorf("failed to save import job %s : %s", job.ID, err.Error())
+	}
+}
+
+func (o *Operation) deleteExpiredImportJobs() {
+	jobs, err := o.getImportJobs()
+	if err != nil {
+		logger.Warnf("failed to get import jobs: %s", err.Error())
+
+		return
+	}
+
+	for _, j := range jobs {
+		if !j.expired() {
+			continue
+		}
+
+		err = o.importJobStore.Delete(j.ID)
+		if err != nil {
+			logger.Warnf("failed to delete import job %s: %s", j.ID, err.Error())
+		}
+	}
+}
+
+func (o *Operation) getImportJobData(id string) (*importJob, error) {
+	jobBytes, err := o.importJobStore.Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("get import job: %w", err)
+	}
+
+	var job *importJob
+
+	err = json.Unmarshal(jobBytes, &job)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal import job: %w", err)
+	}
+
+	return job, nil
+}
+
+func (o *Operation) getImportJobs() ([]*importJob, error) {
+	jobs := make([]*importJob, 0)
+
+	err := forEachValue(o.importJobStore, importJobTagName, func(value []byte) error {
+		var job *importJob
+
+		err := json.Unmarshal(value, &job)
+		if err != nil {
+			return fmt.Errorf("unmarshal import job: %w", err)
+		}
+
+		jobs = append(jobs, job)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+func (o *Operation) saveImportJob(job *importJob) error {
+	jobBytes, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("marshal import job: %w", err)
+	}
+
+	return o.importJobStore.Put(job.ID, jobBytes, storage.Tag{Name: importJobTagName})
+}
+
+// add counts the result of an imported row.
+func (j *importJob) add(res userImportResult) {
+	j.Results = append(j.Results, res)
+	j.Processed = len(j.Results)
+
+	switch res.Status {
+	case importCreated:
+		j.Created++
+	case importExists, importPasswordSet:
+		j.Existing++
+	default:
+		j.Failed++
+	}
+}
+
+func (j *importJob) expired() bool {
+	return j.ExpiryTime != nil && time.Now().After(j.ExpiryTime.Time)
+}
@@ -0,0 +1,351 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportUsers(t *testing.T) {
+	t.Run("csv", func(t *testing.T) {
+		svc := newTestOperation(t, withUserCreation(t, &mockVaultClient{}), withFastImport())
+
+		resp := importUsers(t, svc, csvContentType,
+			"username,password,nationalID\n"+
+				"user1@example.com,"+samplePassword+",111111111\n"+
+				"user2@example.com,"+samplePassword+",222222222\n")
+		require.Equal(t, 2, resp.Total)
+		require.Equal(t, 2, resp.Created)
+
+		for i, res := range resp.Results {
+			require.Equal(t, i+1, res.Row)
+			require.Equal(t, importCreated, res.Status)
+			require.NotEmpty(t, res.UserID)
+			require.Equal(t, uuid.NewSHA1(importNamespace, []byte(res.UserName)).String(), res.RegistrationID)
+
+			uData, err := svc.getUserData(res.UserName)
+			require.NoError(t, err)
+			require.Equal(t, res.UserID, uData.ID)
+
+			require.NoError(t, svc.checkPassword(res.UserName, samplePassword))
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		svc := newTestOperation(t, withUserCreation(t, &mockVaultClient{}), withFastImport())
+
+		reqBytes, err := json.Marshal(&userImportReq{Users: []userImportRow{
+			{UserName: sampleUserName, Password: samplePassword, Documents: map[string]string{nationalID: "111111111"}},
+		}})
+		require.NoError(t, err)
+
+		resp := importUsers(t, svc, "application/json", string(reqBytes))
+		require.Equal(t, 1, resp.Created)
+		require.Equal(t, sampleUserName, resp.Results[0].UserName)
+	})
+
+	t.Run("import is idempotent", func(t *testing.T) {
+		vClient := &mockVaultClient{}
+
+		svc := newTestOperation(t, withUserCreation(t, vClient), withFastImport())
+
+		body := "username,password,nationalID\n" + sampleUserName + "," + samplePassword + ",111111111\n"
+
+		resp := importUsers(t, svc, csvContentType, body)
+		require.Equal(t, 1, resp.Created)
+
+		userID := resp.Results[0].UserID
+
+		vClient.CreateVaultErr = errors.New("vault must not be created again")
+
+		resp = importUsers(t, svc, csvContentType, body)
+		require.Equal(t, 0, resp.Created)
+		require.Equal(t, 1, resp.Existing)
+		require.Equal(t, importExists, resp.Results[0].Status)
+		require.Equal(t, userID, resp.Results[0].UserID)
+	})
+
+	t.Run("existing user without password", func(t *testing.T) {
+		svc := newTestOperation(t, withUserCreation(t, &mockVaultClient{}), withFastImport(),
+			withUserData(&userData{ID: "U1", UserName: sampleUserName, VaultID: "vault1"}))
+
+		require.ErrorIs(t, svc.checkPassword(sampleUserName, samplePassword), errInvalidCredentials)
+
+		resp := importUsers(t, svc, csvContentType,
+			"username,password,nationalID\n"+sampleUserName+",short,111111111\n")
+		require.Equal(t, 1, resp.Failed)
+		require.Contains(t, resp.Results[0].Error, "password must have at least")
+
+		resp = importUsers(t, svc, csvContentType,
+			"username,password,nationalID\n"+sampleUserName+","+samplePassword+",111111111\n")
+		require.Equal(t, 1, resp.Existing)
+		require.Equal(t, importPasswordSet, resp.Results[0].Status)
+		require.Equal(t, "U1", resp.Results[0].UserID)
+		require.NoError(t, svc.checkPassword(sampleUserName, samplePassword))
+
+		// the password isn't changed once it is set
+		resp = importUsers(t, svc, csvContentType,
+			"username,password,nationalID\n"+sampleUserName+",other-password,111111111\n")
+		require.Equal(t, importExists, resp.Results[0].Status)
+		require.NoError(t, svc.checkPassword(sampleUserName, samplePassword))
+	})
+
+	t.Run("completed registration is reported as existing", func(t *testing.T) {
+		svc := newTestOperation(t, withUserCreation(t, &mockVaultClient{}), withFastImport())
+
+		regID := uuid.NewSHA1(importNamespace, []byte(sampleUserName)).String()
+
+		require.NoError(t, svc.updateRegistration(&userRegistration{
+			ID: regID, UserName: sampleUserName, UserID: "U1",
+		}, registrationCompleted))
+
+		resp := importUsers(t, svc, csvContentType,
+			"username,password,nationalID\n"+sampleUserName+","+samplePassword+",111111111\n")
+		require.Equal(t, 1, resp.Existing)
+		require.Equal(t, "U1", resp.Results[0].UserID)
+	})
+
+	t.Run("failed rows", func(t *testing.T) {
+		svc := newTestOperation(t, withUserCreation(t, &mockVaultClient{}), withFastImport())
+
+		resp := importUsers(t, svc, csvContentType,
+			"username,password,nationalID\n"+
+				",pa$$word,111111111\n"+
+				"user1@example.com,short,111111111\n"+
+				"user2@example.com,pa$$word,\n"+
+				"user3@example.com,pa$$word,333333333\n"+
+				"user3@example.com,pa$$word,333333333\n")
+		require.Equal(t, 5, resp.Total)
+		require.Equal(t, 1, resp.Created)
+		require.Equal(t, 4, resp.Failed)
+
+		for i, msg := range []string{
+			"missing username",
+			"password must have at least",
+			"at least one document is mandatory",
+			"",
+			"duplicate username",
+		} {
+			require.Contains(t, resp.Results[i].Error, msg)
+		}
+	})
+
+	t.Run("unsupported document type", func(t *testing.T) {
+		svc := newTestOperation(t, withUserCreation(t, &mockVaultClient{}), withFastImport())
+
+		reqBytes, err := json.Marshal(&userImportReq{Users: []userImportRow{
+			{UserName: sampleUserName, Password: samplePassword, Documents: map[string]string{"passport": "123"}},
+		}})
+		require.NoError(t, err)
+
+		resp := importUsers(t, svc, "application/json", string(reqBytes))
+		require.Equal(t, 1, resp.Failed)
+		require.Equal(t, "unsupported document type 'passport'", resp.Results[0].Error)
+	})
+
+	t.Run("vault error", func(t *testing.T) {
+		vClient := &mockVaultClient{}
+
+		svc := newTestOperation(t, withUserCreation(t, vClient), withFastImport())
+		vClient.CreateVaultErr = errors.New("vault error")
+
+		body := "username,password,nationalID\n" + sampleUserName + "," + samplePassword + ",111111111\n"
+
+		resp := importUsers(t, svc, csvContentType, body)
+		require.Equal(t, 1, resp.Failed)
+		require.Contains(t, resp.Results[0].Error, "vault error")
+
+		// the registration is rolled back, so that the import can be retried
+		vClient.CreateVaultErr = nil
+
+		resp = importUsers(t, svc, csvContentType, body)
+		require.Equal(t, 1, resp.Created)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		svc := newTestOperation(t, withUserCreation(t, &mockVaultClient{}), withFastImport())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		var results []userImportResult
+
+		svc.runImport(ctx, []userImportRow{
+			{UserName: sampleUserName, Password: samplePassword, Documents: map[string]string{nationalID: "1"}},
+		}, func(res userImportResult) {
+			results = append(results, res)
+		})
+		require.Len(t, results, 1)
+		require.Equal(t, importFailed, results[0].Status)
+		require.Contains(t, results[0].Error, "context canceled")
+	})
+
+	t.Run("closed operation", func(t *testing.T) {
+		svc := newTestOperation(t, withUserCreation(t, &mockVaultClient{}), withFastImport())
+		svc.Close()
+
+		job := importUsers(t, svc, csvContentType, "username,password,nationalID\n"+sampleUserName+","+samplePassword+",1\n")
+		require.Equal(t, importJobFailed, job.Status)
+		require.Contains(t, job.Error, "interrupted by a shutdown")
+		require.Equal(t, 1, job.Failed)
+	})
+
+	t.Run("all rows failed", func(t *testing.T) {
+		svc := newTestOperation(t, withUserCreation(t, &mockVaultClient{}), withFastImport())
+
+		job := importUsers(t, svc, csvContentType, "username,password,nationalID\nuser1@example.com,short,1\n")
+		require.Equal(t, importJobFailed, job.Status)
+		require.Equal(t, 1, job.Processed)
+		require.Equal(t, 1, job.Failed)
+	})
+
+	t.Run("invalid import", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		for body, msg := range map[string]string{
+			"":                               "missing csv header",
+			"username,password,passport\n":   "unsupported column 'passport'",
+			"username,password\n\"a,b\n":     "read csv",
+			"username,password,nationalID\n": "no users to import",
+		} {
+			rr := httptest.NewRecorder()
+			svc.importUsers(rr, newImportRequest(csvContentType+"; charset=utf-8", body))
+			require.Equal(t, http.StatusBadRequest, rr.Code, body)
+			require.Contains(t, rr.Body.String(), msg, body)
+		}
+
+		rr := httptest.NewRecorder()
+		svc.importUsers(rr, newImportRequest("application/json", "invalid"))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "invalid import")
+
+		var csvBody strings.Builder
+
+		csvBody.WriteString("username,password,nationalID\n")
+
+		for i := 0; i <= maxImportRows; i++ {
+			csvBody.WriteString(fmt.Sprintf("user%d,%s,1\n", i, samplePassword))
+		}
+
+		rr = httptest.NewRecorder()
+		svc.importUsers(rr, newImportRequest(csvContentType, csvBody.String()))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), fmt.Sprintf("at most %d users", maxImportRows))
+	})
+}
+
+func TestGetImportJob(t *testing.T) {
+	t.Run("not found", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		rr := httptest.NewRecorder()
+		svc.getImportJob(rr, newGetImportJobRequest("invalid"))
+		require.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		svc := newTestOperation(t, withConfig(func(config *Config) {
+			config.ImportJobRetention = time.Nanosecond
+		}))
+
+		job := &importJob{
+			ID: uuid.NewString(), Status: importJobCompleted, ExpiryTime: util.NewTime(time.Now().Add(-time.Second)),
+		}
+		require.NoError(t, svc.saveImportJob(job))
+
+		rr := httptest.NewRecorder()
+		svc.getImportJob(rr, newGetImportJobRequest(job.ID))
+		require.Equal(t, http.StatusNotFound, rr.Code)
+
+		// the expired jobs are deleted when an import starts
+		rr = httptest.NewRecorder()
+		svc.importUsers(rr, newImportRequest(csvContentType, "username,password,nationalID\nuser1,short,1\n"))
+		require.Equal(t, http.StatusAccepted, rr.Code)
+
+		_, err := svc.getImportJobData(job.ID)
+		require.Error(t, err)
+	})
+
+	t.Run("interrupted by a restart", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		job := &importJob{ID: uuid.NewString(), Status: importJobRunning, Total: 2, Processed: 1}
+		require.NoError(t, svc.saveImportJob(job))
+
+		svc.recoverImportJobs()
+
+		job, err := svc.getImportJobData(job.ID)
+		require.NoError(t, err)
+		require.Equal(t, importJobFailed, job.Status)
+		require.Contains(t, job.Error, "interrupted by a restart")
+	})
+}
+
+// withFastImport lifts the rate limit of the imports.
+func withFastImport() testOperationOption {
+	return withConfig(func(config *Config) {
+		config.ImportRate = 1000
+	})
+}
+
+// importUsers starts the import, and returns the job once it is finished.
+func importUsers(t *testing.T, svc *Operation, contentType, body string) *importJob {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	svc.importUsers(rr, newImportRequest(contentType, body))
+	require.Equal(t, http.StatusAccepted, rr.Code, rr.Body.String())
+
+	var job *importJob
+
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &job))
+	require.NotEmpty(t, job.ID)
+
+	return waitForImportJob(t, svc, job.ID)
+}
+
+func waitForImportJob(t *testing.T, svc *Operation, id string) *importJob {
+	t.Helper()
+
+	var job *importJob
+
+	require.Eventually(t, func() bool {
+		rr := httptest.NewRecorder()
+		svc.getImportJob(rr, newGetImportJobRequest(id))
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &job))
+
+		return job.Status == importJobCompleted || job.Status == importJobFailed
+	}, 5*time.Second, 10*time.Millisecond)
+
+	return job
+}
+
+func newGetImportJobRequest(id string) *http.Request {
+	return mux.SetURLVars(httptest.NewRequest(http.MethodGet, userImport+"/"+id, nil), map[string]string{"id": id})
+}
+
+func newImportRequest(contentType, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, userImport, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", contentType)
+
+	return req
+}
@@ -0,0 +1,626 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/util"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+)
+
+const (
+	defaultLinkRequestExpiry = 60 * time.Second
+
+	// the link requests and the connect states are saved in the store of the users, so their keys are prefixed
+	// to keep the ids sent to the browser from addressing the records of the users.
+	linkRequestKeyPrefix  = "linkrequest_"
+	connectStateKeyPrefix = "connectstate_"
+
+	csrfTokenParam = "csrf_token"
+)
+
+// pushLinkRequest saves the account link request of an authenticated client. The client redirects the user to /link
+// with the returned request uri, so the callback url and the state can't be changed in the browser.
+func (o *Operation) pushLinkRequest(w http.ResponseWriter, r *http.Request) {
+	cData, err := o.authenticateClient(r)
+	if err != nil {
+		o.writeClientAuthError(w, err)
+
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("unable to parse form data: %s", err.Error()))
+
+		return
+	}
+
+	state := r.FormValue("state")
+	if state == "" {
+		o.writeErrorResponse(w, http.StatusBadRequest, "missing state")
+
+		return
+	}
+
+	if r.FormValue("callback") != cData.Callback {
+		o.writeErrorResponse(w, http.StatusBadRequest, "callback url doesn't match the registered callback")
+
+		return
+	}
+
+	docType, err := o.documentType(r.FormValue(docTypeParam))
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	req := &linkRequest{
+		ClientID:    cData.ClientID,
+		State:       state,
+		CallbackURL: cData.Callback,
+		DocType:     docType.Name,
+		ExpiresAt:   time.Now().Add(o.linkRequestExpiry),
+	}
+
+	reqBytes, err := json.Marshal(req)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to marshal link request: %s", err.Error()))
+
+		return
+	}
+
+	requestURI := uuid.NewString()
+
+	err = o.store.Put(linkRequestKeyPrefix+requestURI, reqBytes)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to save link request: %s", err.Error()))
+
+		return
+	}
+
+	o.writeResponse(w, http.StatusCreated, &linkRequestResp{
+		RequestURI: requestURI,
+		ExpiresIn:  int(o.linkRequestExpiry.Seconds()),
+	})
+}
+
+// parseConnectState returns the state saved by connect. States saved before the document types only have the
+// username.
+func parseConnectState(stateBytes []byte) *connectState {
+	state := &connectState{}
+
+	err := json.Unmarshal(stateBytes, state)
+	if err != nil || state.UserName == "" {
+		return &connectState{UserName: string(stateBytes)}
+	}
+
+	return state
+}
+
+// consumeLinkRequest gets the pushed link request. The request can be used only once.
+func (o *Operation) consumeLinkRequest(requestURI string) (*linkRequest, error) {
+	reqBytes, err := o.store.Get(linkRequestKeyPrefix + requestURI)
+	if err != nil {
+		return nil, fmt.Errorf("get link request: %w", err)
+	}
+
+	err = o.store.Delete(linkRequestKeyPrefix + requestURI)
+	if err != nil {
+		return nil, fmt.Errorf("delete link request: %w", err)
+	}
+
+	req := &linkRequest{}
+
+	err = json.Unmarshal(reqBytes, req)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal link request: %w", err)
+	}
+
+	if time.Now().After(req.ExpiresAt) {
+		return nil, errors.New("expired link request")
+	}
+
+	return req, nil
+}
+
+// requestAccountLink pushes the account link request to the service of the profile with the client credentials
+// registered at the service. The document of the type is compared when the account is linked.
+func (o *Operation) requestAccountLink(data *profileData, state, docType string) (string, error) {
+	form := url.Values{}
+	form.Set("callback", o.hostExternalURL+accountLinkCallback)
+	form.Set("state", state)
+	form.Set(docTypeParam, docType)
+
+	req, err := http.NewRequest(http.MethodPost, data.URL+link, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	err = o.setProfileCredentials(req, data)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		err = resp.Body.Close()
+		if err != nil {
+			logger.Warnf("failed to close response body")
+		}
+	}()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("read response : %w", err)
+	}
+
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("%s: %s", resp.Status, string(respBody))
+	}
+
+	linkResp := &linkRequestResp{}
+
+	err = json.Unmarshal(respBody, linkResp)
+	if err != nil {
+		return "", fmt.Errorf("unmarshal link request response : %w", err)
+	}
+
+	return linkResp.RequestURI, nil
+}
+
+// saveConnectState saves the state of the account link requested by the user.
+func (o *Operation) saveConnectState(state string, cState *connectState) error {
+	stateBytes, err := json.Marshal(cState)
+	if err != nil {
+		return fmt.Errorf("marshal state data : %w", err)
+	}
+
+	err = o.store.Put(connectStateKeyPrefix+state, stateBytes)
+	if err != nil {
+		return fmt.Errorf("save state data : %w", err)
+	}
+
+	return nil
+}
+
+// consumeConnectState gets the state saved by connect. The state can be used only once, so that the callback of
+// the linked service can't be replayed.
+func (o *Operation) consumeConnectState(state string) (*connectState, error) {
+	stateBytes, err := o.store.Get(connectStateKeyPrefix + state)
+	if err != nil {
+		return nil, fmt.Errorf("get state %s : %w", state, err)
+	}
+
+	err = o.store.Delete(connectStateKeyPrefix + state)
+	if err != nil {
+		return nil, fmt.Errorf("delete state %s : %w", state, err)
+	}
+
+	return parseConnectState(stateBytes), nil
+}
+
+// disconnect removes the account links of the user of the session. The form must have the CSRF token of the
+// session.
+func (o *Operation) disconnect(w http.ResponseWriter, r *http.Request) {
+	session, ok := o.requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	if !o.requireCSRFToken(w, r, session) {
+		return
+	}
+
+	uData, err := o.getUserData(session.UserName)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("failed to get user data: %s", err.Error()))
+
+		return
+	}
+
+	links, err := o.getAccountLinks(userTagName, uData.ID)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to get account links: %s", err.Error()))
+
+		return
+	}
+
+	for _, l := range links {
+		err = o.removeAccountLink(l)
+		if err != nil {
+			o.writeErrorResponse(w, http.StatusInternalServerError,
+				fmt.Sprintf("failed to remove account link %s: %s", l.ID, err.Error()))
+
+			return
+		}
+
+		o.notifyAccountUnlink(l)
+	}
+
+	logger.Infof("disconnect : userName=[%s] links=[%d]", session.UserName, len(links))
+
+	o.showDashboard(w, session, uData.VaultID, false)
+}
+
+// accountUnlink handles the notification of the linked service that the user has withdrawn the consent. The
+// notifications are sent to the registered callback of the client. The linked service authenticates as a client,
+// and only the links of the client, or of the profile of the client, are removed.
+func (o *Operation) accountUnlink(w http.ResponseWriter, r *http.Request) {
+	cData, err := o.authenticateClient(r)
+	if err != nil {
+		o.writeClientAuthError(w, err)
+
+		return
+	}
+
+	req := &accountUnlinkReq{}
+
+	err = json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusBadRequest,
+			fmt.Sprintf("failed to decode request: %s", err.Error()))
+
+		return
+	}
+
+	if req.State == "" {
+		o.writeErrorResponse(w, http.StatusBadRequest, "missing state")
+
+		return
+	}
+
+	links, err := o.getAccountLinks(stateTagName, req.State)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to get account links: %s", err.Error()))
+
+		return
+	}
+
+	removed := 0
+
+	for _, l := range links {
+		if !l.linkedTo(cData) {
+			continue
+		}
+
+		err = o.removeAccountLink(l)
+		if err != nil {
+			o.writeErrorResponse(w, http.StatusInternalServerError,
+				fmt.Sprintf("failed to remove account link %s: %s", l.ID, err.Error()))
+
+			return
+		}
+
+		removed++
+	}
+
+	logger.Infof("accountUnlink : clientID=[%s] state=[%s] links=[%d]", cData.ClientID, req.State, removed)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// getLinks lists a page of the account links, of all the users or of the user of the userID query param. The newest
+// links are listed first by default.
+func (o *Operation) getLinks(w http.ResponseWriter, r *http.Request) {
+	req, err := getListRequest(r)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	expression := userTagName
+
+	if userID := r.URL.Query().Get("userID"); userID != "" {
+		expression = fmt.Sprintf("%s:%s", userTagName, userID)
+	}
+
+	page, err := queryIndex(o.linkStore, expression, req, accountLinkIndex)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to get account links: %s", err.Error()))
+
+		return
+	}
+
+	l := make([]*accountLink, 0, len(page.keys))
+
+	if len(page.keys) > 0 {
+		l, err = o.fetchAccountLinks(page.keys...)
+		if err != nil {
+			o.writeErrorResponse(w, http.StatusInternalServerError,
+				fmt.Sprintf("failed to get account links: %s", err.Error()))
+
+			return
+		}
+	}
+
+	o.writeResponse(w, http.StatusOK, &accountLinksResp{Links: l, Total: page.total, NextCursor: page.next})
+}
+
+// fetchAccountLinks reads the account links of the page, skipping the links deleted since the page was queried.
+func (o *Operation) fetchAccountLinks(ids ...string) ([]*accountLink, error) {
+	values, err := o.linkStore.GetBulk(ids...)
+	if err != nil {
+		return nil, fmt.Errorf("get account links: %w", err)
+	}
+
+	links := make([]*accountLink, 0, len(values))
+
+	for _, v := range values {
+		if v == nil {
+			continue
+		}
+
+		l := &accountLink{}
+
+		err = json.Unmarshal(v, l)
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal account link: %w", err)
+		}
+
+		links = append(links, l)
+	}
+
+	return links, nil
+}
+
+func (o *Operation) getLink(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	linkBytes, err := o.linkStore.Get(id)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		o.writeErrorResponse(w, http.StatusNotFound, fmt.Sprintf("account link %s not found", id))
+
+		return
+	}
+
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to get account link %s: %s", id, err.Error()))
+
+		return
+	}
+
+	l := &accountLink{}
+
+	err = json.Unmarshal(linkBytes, l)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to unmarshal account link %s: %s", id, err.Error()))
+
+		return
+	}
+
+	o.writeResponse(w, http.StatusOK, l)
+}
+
+// isLinked checks whether the user has an account link with a successful comparison.
+func (o *Operation) isLinked(userID string) (bool, error) {
+	l, err := o.getAccountLinks(userTagName, userID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, v := range l {
+		if v.linked() {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (o *Operation) saveAccountLink(l *accountLink) error {
+	now := time.Now()
+
+	l.ID = uuid.NewString()
+	l.CreatedTime = util.NewTime(now)
+
+	if l.AuthExpiry == nil {
+		l.AuthExpiry = util.NewTime(now.Add(authExpiryTime * time.Second))
+	}
+
+	linkBytes, err := json.Marshal(l)
+	if err != nil {
+		return fmt.Errorf("marshal account link: %w", err)
+	}
+
+	tags := []storage.Tag{
+		{Name: userTagName, Value: l.UserID},
+		{Name: stateTagName, Value: l.State},
+		createdTag(now),
+	}
+
+	if l.ClientID != "" {
+		tags = append(tags, storage.Tag{Name: clientTagName, Value: l.ClientID})
+	}
+
+	err = o.linkStore.Put(l.ID, linkBytes, tags...)
+	if err != nil {
+		return fmt.Errorf("save account link: %w", err)
+	}
+
+	return nil
+}
+
+func accountLinkIndex(value []byte) (time.Time, string, error) {
+	l := &accountLink{}
+
+	err := json.Unmarshal(value, l)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("unmarshal account link: %w", err)
+	}
+
+	if l.CreatedTime == nil {
+		return time.Time{}, "", nil
+	}
+
+	return l.CreatedTime.Time, "", nil
+}
+
+func (o *Operation) getAccountLinks(tagName, tagValue string) ([]*accountLink, error) {
+	return o.queryAccountLinks(fmt.Sprintf("%s:%s", tagName, tagValue))
+}
+
+func (o *Operation) queryAccountLinks(expression string) ([]*accountLink, error) {
+	iter, err := o.linkStore.Query(expression)
+	if err != nil {
+		return nil, fmt.Errorf("query account links: %w", err)
+	}
+
+	defer func() {
+		err = iter.Close()
+		if err != nil {
+			logger.Warnf("failed to close account link iterator: %s", err.Error())
+		}
+	}()
+
+	links := make([]*accountLink, 0)
+
+	more, err := iter.Next()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get next account link: %w", err)
+	}
+
+	for more {
+		value, err := iter.Value()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get account link value: %w", err)
+		}
+
+		l := &accountLink{}
+
+		err = json.Unmarshal(value, l)
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal account link: %w", err)
+		}
+
+		links = append(links, l)
+
+		more, err = iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get next account link: %w", err)
+		}
+	}
+
+	return links, nil
+}
+
+// removeAccountLink revokes the vault authorization of the link and deletes the link record. The comparator
+// doesn't support revocation; its authorizations expire with the expiry caveat and can't be used to read the
+// document once the vault authorization backing them is revoked.
+func (o *Operation) removeAccountLink(l *accountLink) error {
+	if l.VaultAuthorizationID != "" {
+		err := o.vClient.DeleteAuthorization(l.VaultID, l.VaultAuthorizationID)
+		if err != nil {
+			return fmt.Errorf("revoke vault authorization : %w", err)
+		}
+	}
+
+	err := o.linkStore.Delete(l.ID)
+	if err != nil && !errors.Is(err, storage.ErrDataNotFound) {
+		return fmt.Errorf("delete account link : %w", err)
+	}
+
+	return nil
+}
+
+// notifyAccountUnlink notifies the linked service through its registered callback, with the client credentials of
+// this service at the linked service, if any. The link is already revoked on this side, so a failure is only logged.
+func (o *Operation) notifyAccountUnlink(l *accountLink) {
+	callback, pData, err := o.getLinkCallback(l)
+	if err != nil {
+		logger.Warnf("failed to get callback of account link %s : %s", l.ID, err.Error())
+
+		return
+	}
+
+	reqBytes, err := json.Marshal(&accountUnlinkReq{State: l.State})
+	if err != nil {
+		logger.Warnf("failed to marshal account unlink request : %s", err.Error())
+
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, callback, bytes.NewBuffer(reqBytes))
+	if err != nil {
+		logger.Warnf("failed to create account unlink request : %s", err.Error())
+
+		return
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	// a client without a profile isn't a service with credentials of this service
+	if pData != nil {
+		err = o.setProfileCredentials(req, pData)
+		if err != nil {
+			logger.Warnf("failed to set account unlink credentials : %s", err.Error())
+
+			return
+		}
+	}
+
+	_, err = o.doHTTPRequest(req, http.StatusOK)
+	if err != nil {
+		logger.Warnf("failed to notify account unlink to %s : %s", callback, err.Error())
+	}
+}
+
+// getLinkCallback returns the callback of the linked service, and the profile with the client credentials of this
+// service at the linked service. A client is linked to the profile of its service, if any.
+func (o *Operation) getLinkCallback(l *accountLink) (string, *profileData, error) {
+	if l.ClientID != "" {
+		cData, err := o.getClientData(l.ClientID)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if cData.ProfileID == "" {
+			return cData.Callback, nil, nil
+		}
+
+		pData, err := o.getProfileData(cData.ProfileID)
+		if err != nil {
+			return "", nil, err
+		}
+
+		return cData.Callback, pData, nil
+	}
+
+	pData, err := o.getProfileData(l.ProfileID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// the profile service has registered the callback of this service
+	return pData.URL + accountLinkCallback, pData, nil
+}
@@ -0,0 +1,663 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	mockstorage "github.com/hyperledger/aries-framework-go/component/storageutil/mock"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/util"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPushLinkRequest(t *testing.T) {
+	newRequest := func(clientID, secret, callback, state string) *http.Request {
+		form := url.Values{}
+		form.Set("callback", callback)
+		form.Set("state", state)
+
+		req := httptest.NewRequest(http.MethodPost, link, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(clientID, secret)
+
+		return req
+	}
+
+	t.Run("success", func(t *testing.T) {
+		svc := newTestOperation(t, withClientData(testClientData()))
+
+		rr := httptest.NewRecorder()
+
+		svc.pushLinkRequest(rr, newRequest("client1", "secret1", "https://client.example.com/callback", "state1"))
+		require.Equal(t, http.StatusCreated, rr.Code)
+
+		resp := &linkRequestResp{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+		require.NotEmpty(t, resp.RequestURI)
+		require.Equal(t, 60, resp.ExpiresIn)
+
+		req, err := svc.consumeLinkRequest(resp.RequestURI)
+		require.NoError(t, err)
+		require.Equal(t, "client1", req.ClientID)
+		require.Equal(t, "state1", req.State)
+		require.Equal(t, "https://client.example.com/callback", req.CallbackURL)
+		require.Equal(t, nationalID, req.DocType)
+	})
+
+	t.Run("unsupported document type", func(t *testing.T) {
+		svc := newTestOperation(t, withClientData(testClientData()))
+
+		req := newRequest("client1", "secret1", "https://client.example.com/callback", "state1")
+		req.URL.RawQuery = "docType=passport"
+
+		rr := httptest.NewRecorder()
+
+		svc.pushLinkRequest(rr, req)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "unsupported document type passport")
+	})
+
+	t.Run("invalid credentials", func(t *testing.T) {
+		svc := newTestOperation(t, withClientData(testClientData()))
+
+		rr := httptest.NewRecorder()
+
+		svc.pushLinkRequest(rr, newRequest("client1", "invalid", "https://client.example.com/callback", "state1"))
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+		require.Contains(t, rr.Body.String(), "invalid client credentials")
+	})
+
+	t.Run("callback mismatch", func(t *testing.T) {
+		svc := newTestOperation(t, withClientData(testClientData()))
+
+		for _, callback := range []string{
+			"", "https://client.example.com", "https://client.example.com/callback/", "https://evil.example.com/callback",
+		} {
+			rr := httptest.NewRecorder()
+
+			svc.pushLinkRequest(rr, newRequest("client1", "secret1", callback, "state1"))
+			require.Equal(t, http.StatusBadRequest, rr.Code)
+			require.Contains(t, rr.Body.String(), "callback url doesn't match the registered callback")
+		}
+	})
+
+	t.Run("missing state", func(t *testing.T) {
+		svc := newTestOperation(t, withClientData(testClientData()))
+
+		rr := httptest.NewRecorder()
+
+		svc.pushLinkRequest(rr, newRequest("client1", "secret1", "https://client.example.com/callback", ""))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "missing state")
+	})
+
+	t.Run("parse form error", func(t *testing.T) {
+		svc := newTestOperation(t, withClientData(testClientData()))
+
+		req := httptest.NewRequest(http.MethodPost, link+"?state=%zz", nil)
+		req.SetBasicAuth("client1", "secret1")
+
+		rr := httptest.NewRecorder()
+
+		svc.pushLinkRequest(rr, req)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "unable to parse form data")
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		svc := newTestOperation(t, withClientData(testClientData()))
+
+		svc.store = &mockstorage.Store{ErrPut: errors.New("save error")}
+
+		rr := httptest.NewRecorder()
+
+		svc.pushLinkRequest(rr, newRequest("client1", "secret1", "https://client.example.com/callback", "state1"))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to save link request: save error")
+	})
+}
+
+func TestDisconnect(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		vClient := &mockVaultClient{}
+
+		svc := newTestOperation(
+			t,
+			withUserData(testUserData()),
+			withClientData(testPartnerClientData()),
+			withProfile(testProfileData()),
+			withDashboardHTML(newTestHTMLFile(t)),
+			withVaultClient(vClient),
+		)
+
+		clientLink := &accountLink{UserID: "U1", State: uuid.NewString(), ClientID: "client1", VaultAuthorizationID: "a1"}
+		require.NoError(t, svc.saveAccountLink(clientLink))
+
+		profileLink := &accountLink{UserID: "U1", State: uuid.NewString(), ProfileID: "profile1", VaultAuthorizationID: "a2"}
+		require.NoError(t, svc.saveAccountLink(profileLink))
+
+		otherLink := &accountLink{UserID: "U2", State: uuid.NewString(), ClientID: "client1", VaultAuthorizationID: "a3"}
+		require.NoError(t, svc.saveAccountLink(otherLink))
+
+		require.NoError(t, svc.saveClientData(&clientData{
+			ClientID: "client2",
+			Callback: "https://client2.example.com/callback",
+		}))
+
+		client2Link := &accountLink{UserID: "U1", State: uuid.NewString(), ClientID: "client2", VaultAuthorizationID: "a4"}
+		require.NoError(t, svc.saveAccountLink(client2Link))
+
+		notified := make(map[string]string)
+		authenticated := make(map[string]bool)
+
+		svc.httpClient = &mockHTTPClient{
+			doFunc: func(req *http.Request) (*http.Response, error) {
+				unlinkReq := &accountUnlinkReq{}
+				require.NoError(t, json.NewDecoder(req.Body).Decode(unlinkReq))
+
+				notified[req.URL.String()] = unlinkReq.State
+
+				clientID, secret, ok := req.BasicAuth()
+				authenticated[req.URL.String()] = ok && clientID == "rp1" && secret == "rpsecret"
+
+				return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewReader(nil))}, nil
+			},
+		}
+
+		rr := httptest.NewRecorder()
+
+		svc.disconnect(rr, newDisconnectRequest(t, svc, sampleUserName))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		require.ElementsMatch(t, []string{"a1", "a2", "a4"}, vClient.deletedAuthorizations)
+		require.Equal(t, map[string]string{
+			"https://client.example.com/callback":  clientLink.State,
+			"https://profile.example.com/callback": profileLink.State,
+			"https://client2.example.com/callback": client2Link.State,
+		}, notified)
+
+		// the client without a profile has no credentials of this service
+		require.Equal(t, map[string]bool{
+			"https://client.example.com/callback":  true,
+			"https://profile.example.com/callback": true,
+			"https://client2.example.com/callback": false,
+		}, authenticated)
+
+		links, err := svc.getAccountLinks(userTagName, "U1")
+		require.NoError(t, err)
+		require.Empty(t, links)
+
+		links, err = svc.getAccountLinks(userTagName, "U2")
+		require.NoError(t, err)
+		require.Len(t, links, 1)
+	})
+
+	t.Run("notification failure", func(t *testing.T) {
+		svc := newTestOperation(
+			t,
+			withUserData(testUserData()),
+			withClientData(testClientData()),
+			withDashboardHTML(newTestHTMLFile(t)),
+		)
+
+		require.NoError(t, svc.saveAccountLink(&accountLink{UserID: "U1", State: uuid.NewString(), ClientID: "client1"}))
+		require.NoError(t, svc.saveAccountLink(&accountLink{UserID: "U1", State: uuid.NewString(), ClientID: "invalid"}))
+
+		svc.httpClient = &mockHTTPClient{respErr: errors.New("http error")}
+
+		rr := httptest.NewRecorder()
+
+		svc.disconnect(rr, newDisconnectRequest(t, svc, sampleUserName))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		links, err := svc.getAccountLinks(userTagName, "U1")
+		require.NoError(t, err)
+		require.Empty(t, links)
+	})
+
+	t.Run("no session", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		rr := httptest.NewRecorder()
+
+		svc.disconnect(rr, httptest.NewRequest(http.MethodPost, "/disconnect?userName="+sampleUserName, nil))
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+		require.Contains(t, rr.Body.String(), "no session")
+	})
+
+	t.Run("invalid csrf token", func(t *testing.T) {
+		vClient := &mockVaultClient{}
+
+		svc := newTestOperation(t, withVaultClient(vClient))
+
+		require.NoError(t, svc.saveAccountLink(&accountLink{UserID: "U1", State: uuid.NewString(),
+			ClientID: "client1", VaultAuthorizationID: "a1"}))
+
+		for _, token := range []string{"", "invalid"} {
+			req := newDisconnectRequest(t, svc, sampleUserName)
+			req.Form = url.Values{csrfTokenParam: {token}}
+
+			rr := httptest.NewRecorder()
+
+			svc.disconnect(rr, req)
+			require.Equal(t, http.StatusForbidden, rr.Code)
+			require.Contains(t, rr.Body.String(), "invalid csrf token")
+		}
+
+		require.Empty(t, vClient.deletedAuthorizations)
+	})
+
+	t.Run("invalid user", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		rr := httptest.NewRecorder()
+
+		svc.disconnect(rr, newDisconnectRequest(t, svc, "invalid"))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to get user data")
+	})
+
+	t.Run("link store error", func(t *testing.T) {
+		svc := newTestOperation(t, withUserData(testUserData()))
+
+		svc.linkStore = &mockstorage.Store{ErrQuery: errors.New("query error")}
+
+		rr := httptest.NewRecorder()
+
+		svc.disconnect(rr, newDisconnectRequest(t, svc, sampleUserName))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to get account links")
+	})
+
+	t.Run("revoke error", func(t *testing.T) {
+		vClient := &mockVaultClient{}
+
+		svc := newTestOperation(t, withUserData(testUserData()), withVaultClient(vClient))
+
+		vClient.DeleteAuthorizationErr = errors.New("vault error")
+
+		require.NoError(t, svc.saveAccountLink(&accountLink{UserID: "U1", State: uuid.NewString(),
+			ClientID: "client1", VaultAuthorizationID: "a1"}))
+
+		rr := httptest.NewRecorder()
+
+		svc.disconnect(rr, newDisconnectRequest(t, svc, sampleUserName))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "revoke vault authorization : vault error")
+
+		links, err := svc.getAccountLinks(userTagName, "U1")
+		require.NoError(t, err)
+		require.Len(t, links, 1)
+	})
+}
+
+func TestAccountUnlink(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		vClient := &mockVaultClient{}
+
+		svc := newTestOperation(t, withClientData(testPartnerClientData()), withVaultClient(vClient))
+
+		state := uuid.NewString()
+
+		require.NoError(t, svc.saveAccountLink(&accountLink{UserID: "U1", State: state,
+			ProfileID: "profile1", VaultAuthorizationID: "a1"}))
+		require.NoError(t, svc.saveAccountLink(&accountLink{UserID: "U1", State: uuid.NewString(),
+			ProfileID: "profile1", VaultAuthorizationID: "a2"}))
+
+		svc.httpClient = &mockHTTPClient{respErr: errors.New("unexpected notification")}
+
+		rr := httptest.NewRecorder()
+
+		svc.accountUnlink(rr, newUnlinkRequest("client1", "secret1", state))
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Equal(t, []string{"a1"}, vClient.deletedAuthorizations)
+
+		links, err := svc.getAccountLinks(userTagName, "U1")
+		require.NoError(t, err)
+		require.Len(t, links, 1)
+		require.Equal(t, "a2", links[0].VaultAuthorizationID)
+
+		// unknown state
+		rr = httptest.NewRecorder()
+
+		svc.accountUnlink(rr, newUnlinkRequest("client1", "secret1", state))
+		require.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("links of the client", func(t *testing.T) {
+		vClient := &mockVaultClient{}
+
+		svc := newTestOperation(t, withClientData(testPartnerClientData()), withVaultClient(vClient))
+
+		state := uuid.NewString()
+
+		require.NoError(t, svc.saveAccountLink(&accountLink{UserID: "U1", State: state,
+			ClientID: "client1", VaultAuthorizationID: "a1"}))
+		require.NoError(t, svc.saveAccountLink(&accountLink{UserID: "U1", State: state,
+			ClientID: "client2", VaultAuthorizationID: "a2"}))
+		require.NoError(t, svc.saveAccountLink(&accountLink{UserID: "U1", State: state,
+			ProfileID: "profile2", VaultAuthorizationID: "a3"}))
+
+		rr := httptest.NewRecorder()
+
+		svc.accountUnlink(rr, newUnlinkRequest("client1", "secret1", state))
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Equal(t, []string{"a1"}, vClient.deletedAuthorizations)
+
+		links, err := svc.getAccountLinks(stateTagName, state)
+		require.NoError(t, err)
+		require.Len(t, links, 2)
+	})
+
+	t.Run("invalid credentials", func(t *testing.T) {
+		vClient := &mockVaultClient{}
+
+		svc := newTestOperation(t, withClientData(testClientData()), withVaultClient(vClient))
+
+		state := uuid.NewString()
+
+		require.NoError(t, svc.saveAccountLink(&accountLink{UserID: "U1", State: state,
+			ClientID: "client1", VaultAuthorizationID: "a1"}))
+
+		for _, req := range []*http.Request{
+			newUnlinkRequest("client1", "invalid", state),
+			newUnlinkRequest("invalid", "secret1", state),
+			httptest.NewRequest(http.MethodPost, accountLinkCallback, strings.NewReader(`{"state":"`+state+`"}`)),
+		} {
+			rr := httptest.NewRecorder()
+
+			svc.accountUnlink(rr, req)
+			require.Equal(t, http.StatusUnauthorized, rr.Code)
+			require.Contains(t, rr.Body.String(), "invalid client credentials")
+		}
+
+		require.Empty(t, vClient.deletedAuthorizations)
+	})
+
+	t.Run("invalid request", func(t *testing.T) {
+		svc := newTestOperation(t, withClientData(testClientData()))
+
+		req := newUnlinkRequest("client1", "secret1", "")
+		req.Body = ioutil.NopCloser(strings.NewReader("invalid"))
+
+		rr := httptest.NewRecorder()
+
+		svc.accountUnlink(rr, req)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to decode request")
+	})
+
+	t.Run("missing state", func(t *testing.T) {
+		svc := newTestOperation(t, withClientData(testClientData()))
+
+		rr := httptest.NewRecorder()
+
+		svc.accountUnlink(rr, newUnlinkRequest("client1", "secret1", ""))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "missing state")
+	})
+
+	t.Run("link store error", func(t *testing.T) {
+		svc := newTestOperation(t, withClientData(testClientData()))
+
+		svc.linkStore = &mockstorage.Store{ErrQuery: errors.New("query error")}
+
+		rr := httptest.NewRecorder()
+
+		svc.accountUnlink(rr, newUnlinkRequest("client1", "secret1", "123"))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to get account links")
+	})
+
+	t.Run("revoke error", func(t *testing.T) {
+		vClient := &mockVaultClient{}
+
+		svc := newTestOperation(t, withClientData(testPartnerClientData()), withVaultClient(vClient))
+
+		vClient.DeleteAuthorizationErr = errors.New("vault error")
+
+		require.NoError(t, svc.saveAccountLink(&accountLink{UserID: "U1", State: "123",
+			ProfileID: "profile1", VaultAuthorizationID: "a1"}))
+
+		rr := httptest.NewRecorder()
+
+		svc.accountUnlink(rr, newUnlinkRequest("client1", "secret1", "123"))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to remove account link")
+	})
+}
+
+func TestGetLinks(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		require.NoError(t, svc.saveAccountLink(&accountLink{UserID: "U1", State: "1", ClientID: "client1"}))
+		require.NoError(t, svc.saveAccountLink(&accountLink{UserID: "U1", State: "2", ProfileID: "profile1"}))
+		require.NoError(t, svc.saveAccountLink(&accountLink{UserID: "U2", State: "3", ClientID: "client1"}))
+
+		rr := httptest.NewRecorder()
+
+		svc.getLinks(rr, httptest.NewRequest(http.MethodGet, links, nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		resp := &accountLinksResp{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+		require.Len(t, resp.Links, 3)
+
+		for i := 1; i < len(resp.Links); i++ {
+			require.False(t, resp.Links[i].CreatedTime.After(resp.Links[i-1].CreatedTime.Time))
+		}
+
+		rr = httptest.NewRecorder()
+
+		svc.getLinks(rr, httptest.NewRequest(http.MethodGet, links+"?userID=U1", nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		resp = &accountLinksResp{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+		require.Len(t, resp.Links, 2)
+
+		for _, l := range resp.Links {
+			require.Equal(t, "U1", l.UserID)
+		}
+	})
+
+	t.Run("pages", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		for i := 0; i < 5; i++ {
+			require.NoError(t, svc.saveAccountLink(&accountLink{UserID: "U1", State: strconv.Itoa(i)}))
+		}
+
+		seen := map[string]bool{}
+		cursor := ""
+
+		for _, n := range []int{2, 2, 1} {
+			rr := httptest.NewRecorder()
+
+			svc.getLinks(rr, httptest.NewRequest(http.MethodGet, links+"?userID=U1&limit=2&cursor="+cursor, nil))
+			require.Equal(t, http.StatusOK, rr.Code)
+
+			resp := &accountLinksResp{}
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+			require.Len(t, resp.Links, n)
+			require.Equal(t, 5, resp.Total)
+
+			for _, l := range resp.Links {
+				require.False(t, seen[l.ID])
+				seen[l.ID] = true
+			}
+
+			cursor = resp.NextCursor
+		}
+
+		require.Empty(t, cursor)
+	})
+
+	t.Run("legacy links", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		require.NoError(t, svc.saveAccountLink(&accountLink{UserID: "U1", State: "1"}))
+
+		// the links saved before they were tagged with their index
+		legacyBytes, err := json.Marshal(&accountLink{ID: "legacy1", UserID: "U1", State: "2",
+			CreatedTime: util.NewTime(time.Now().Add(-time.Hour))})
+		require.NoError(t, err)
+
+		require.NoError(t, svc.linkStore.Put("legacy1", legacyBytes,
+			storage.Tag{Name: userTagName, Value: "U1"}, storage.Tag{Name: stateTagName, Value: "2"}))
+
+		rr := httptest.NewRecorder()
+
+		svc.getLinks(rr, httptest.NewRequest(http.MethodGet, links+"?order=asc", nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		resp := &accountLinksResp{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+		require.Len(t, resp.Links, 2)
+		require.Equal(t, "legacy1", resp.Links[0].ID)
+
+		require.NoError(t, indexLegacyItems(svc.linkStore, userTagName, accountLinkIndex))
+
+		iter, err := svc.linkStore.Query(createdTagName)
+		require.NoError(t, err)
+
+		count := 0
+
+		require.NoError(t, iterate(iter, createdTagName, func(storage.Iterator) error {
+			count++
+
+			return nil
+		}))
+		require.Equal(t, 2, count)
+	})
+
+	t.Run("invalid request", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		rr := httptest.NewRecorder()
+
+		svc.getLinks(rr, httptest.NewRequest(http.MethodGet, links+"?limit=0", nil))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "limit must be between 1 and 100")
+	})
+
+	t.Run("link store error", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		svc.linkStore = &mockstorage.Store{ErrQuery: errors.New("query error")}
+
+		rr := httptest.NewRecorder()
+
+		svc.getLinks(rr, httptest.NewRequest(http.MethodGet, links, nil))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to get account links")
+	})
+}
+
+func TestGetLink(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		result := true
+		l := &accountLink{UserID: "U1", UserName: sampleUserName, State: "1", ProfileID: "profile1",
+			ComparisonResult: &result}
+		require.NoError(t, svc.saveAccountLink(l))
+
+		rr := httptest.NewRecorder()
+
+		svc.getLink(rr, mux.SetURLVars(httptest.NewRequest(http.MethodGet, links+"/"+l.ID, nil),
+			map[string]string{"id": l.ID}))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		resp := &accountLink{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+		require.Equal(t, l.ID, resp.ID)
+		require.Equal(t, sampleUserName, resp.UserName)
+		require.Equal(t, "profile1", resp.ProfileID)
+		require.True(t, *resp.ComparisonResult)
+		require.NotNil(t, resp.AuthExpiry)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		rr := httptest.NewRecorder()
+
+		svc.getLink(rr, mux.SetURLVars(httptest.NewRequest(http.MethodGet, links+"/invalid", nil),
+			map[string]string{"id": "invalid"}))
+		require.Equal(t, http.StatusNotFound, rr.Code)
+		require.Contains(t, rr.Body.String(), "account link invalid not found")
+	})
+
+	t.Run("link store error", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		svc.linkStore = &mockstorage.Store{ErrGet: errors.New("get error")}
+
+		rr := httptest.NewRecorder()
+
+		svc.getLink(rr, mux.SetURLVars(httptest.NewRequest(http.MethodGet, links+"/123", nil),
+			map[string]string{"id": "123"}))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to get account link 123: get error")
+	})
+
+	t.Run("invalid data", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		svc.linkStore = &mockstorage.Store{GetReturn: []byte("invalid")}
+
+		rr := httptest.NewRecorder()
+
+		svc.getLink(rr, mux.SetURLVars(httptest.NewRequest(http.MethodGet, links+"/123", nil),
+			map[string]string{"id": "123"}))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to unmarshal account link 123")
+	})
+}
+
+func TestSaveAccountLink(t *testing.T) {
+	svc := newTestOperation(t)
+
+	svc.linkStore = &mockstorage.Store{ErrPut: errors.New("put error")}
+
+	err := svc.saveAccountLink(&accountLink{UserID: "U1", State: "123"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "save account link: put error")
+}
+
+func newDisconnectRequest(t *testing.T, svc *Operation, userName string) *http.Request {
+	t.Helper()
+
+	return newCSRFRequest(t, svc, disconnect, userName)
+}
+
+func newUnlinkRequest(clientID, secret, state string) *http.Request {
+	reqBytes, err := json.Marshal(&accountUnlinkReq{State: state})
+	if err != nil {
+		panic(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, accountLinkCallback, bytes.NewReader(reqBytes))
+	req.SetBasicAuth(clientID, secret)
+
+	return req
+}
@@ -6,13 +6,25 @@ SPDX-License-Identifier: Apache-2.0
 
 package operation
 
-import "github.com/hyperledger/aries-framework-go/pkg/doc/util"
+import (
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/util"
+)
 
 type userData struct {
 	ID              string `json:"id"`
 	UserName        string `json:"userName"`
 	VaultID         string `json:"vaultID"`
-	NationalIDDocID string `json:"nationalIDDocID"`
+	NationalIDDocID string `json:"nationalIDDocID,omitempty"`
+	// Documents maps the document types to the ids of the documents in the vault.
+	Documents map[string]string `json:"documents,omitempty"`
+}
+
+// connectState is saved with the state of the account link requested by the user.
+type connectState struct {
+	UserName string `json:"userName"`
+	DocType  string `json:"docType"`
 }
 
 type userIDNameMap struct {
@@ -25,43 +37,110 @@ type sessionData struct {
 	DID         string `json:"did"`
 	State       string `json:"state"`
 	CallbackURL string `json:"callbackURL"`
+	ClientID    string `json:"clientID"`
+	DocType     string `json:"docType"`
 }
 
 type clientReq struct {
 	DID      string `json:"did"`
 	Callback string `json:"callback"`
+	// ProfileID ties the client to the registered profile of a partner service.
+	ProfileID string `json:"profileID,omitempty"`
 }
 
 type clientResp struct {
 	ClientID     string `json:"clientID"`
-	ClientSecret string `json:"clientSecret"`
+	ClientSecret string `json:"clientSecret,omitempty"`
 	DID          string `json:"did"`
 	Callback     string `json:"callback"`
+	ProfileID    string `json:"profileID,omitempty"`
 }
 
 type clientData struct {
-	ClientID string `json:"clientID"`
-	DID      string `json:"did"`
-	Callback string `json:"callback"`
+	ClientID             string    `json:"clientID"`
+	DID                  string    `json:"did"`
+	Callback             string    `json:"callback"`
+	ProfileID            string    `json:"profileID,omitempty"`
+	SecretHash           []byte    `json:"secretHash"`
+	PreviousSecretHash   []byte    `json:"previousSecretHash,omitempty"`
+	PreviousSecretExpiry time.Time `json:"previousSecretExpiry,omitempty"`
+}
+
+type linkRequest struct {
+	ClientID    string    `json:"clientID"`
+	State       string    `json:"state"`
+	CallbackURL string    `json:"callbackURL"`
+	DocType     string    `json:"docType"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+type linkRequestResp struct {
+	RequestURI string `json:"requestURI"`
+	ExpiresIn  int    `json:"expiresIn"`
 }
 
+type clientsResp struct {
+	Clients    []clientResp `json:"clients"`
+	NextCursor string       `json:"nextCursor,omitempty"`
+}
+
+type profilesResp struct {
+	Profiles   []*profileData `json:"profiles"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
+// profileData is a partner service. The ClientID is the client registered for this service at the partner service,
+// the secret of the client is kept apart and never returned.
 type profileData struct {
-	ID           string `json:"id"`
-	Name         string `json:"name"`
-	ClientID     string `json:"clientID"`
+	ID         string      `json:"id"`
+	Name       string      `json:"name"`
+	ClientID   string      `json:"clientID"`
+	URL        string      `json:"url"`
+	DID        string      `json:"did"`
+	Callback   string      `json:"callback"`
+	AuthPolicy *authPolicy `json:"authPolicy,omitempty"`
+}
+
+// profileReq creates or updates a profile. The secret of an updated profile is kept if the request has none.
+type profileReq struct {
+	profileData
 	ClientSecret string `json:"clientSecret"`
-	URL          string `json:"url"`
-	DID          string `json:"did"`
-	Callback     string `json:"callback"`
+}
+
+type profileSecret struct {
+	ClientSecret string `json:"clientSecret"`
+}
+
+// authPolicy sets the authorizations given to the documents of the users for the profile.
+type authPolicy struct {
+	// Expiry is the lifetime of the authorizations in seconds.
+	Expiry  uint64       `json:"expiry,omitempty"`
+	Actions []string     `json:"actions,omitempty"`
+	Caveats []authCaveat `json:"caveats,omitempty"`
+}
+
+type authCaveat struct {
+	Type              string   `json:"type"`
+	MaxUses           int      `json:"maxUses,omitempty"`
+	RequestingParties []string `json:"requestingParties,omitempty"`
 }
 
 type userAuthData struct {
 	ID            string                         `json:"id"`
 	Source        string                         `json:"source"`
+	ProfileID     string                         `json:"profileID,omitempty"`
+	DocType       string                         `json:"docType,omitempty"`
 	SubmittedTime *util.TimeWithTrailingZeroMsec `json:"submittedTime"`
 	UserAuths     []userAuthorization            `json:"userAuths"`
 }
 
+// saveUserAuthsReq is the request of a partner service. The source and the submitted time of the saved user auths
+// are set by this service.
+type saveUserAuthsReq struct {
+	DocType   string              `json:"docType,omitempty"`
+	UserAuths []userAuthorization `json:"userAuths"`
+}
+
 type userAuthorization struct {
 	ID        string `json:"id"`
 	Name      string `json:"name"`
@@ -70,15 +149,24 @@ type userAuthorization struct {
 }
 
 type getUserDataResp struct {
-	Users []userData `json:"users"`
+	Users      []userData `json:"users"`
+	Total      int        `json:"total"`
+	NextCursor string     `json:"nextCursor,omitempty"`
 }
 
 type generateUserAuthReq struct {
-	Users []string `json:"users"`
+	Users   []string `json:"users"`
+	DocType string   `json:"docType,omitempty"`
+}
+
+type documentTypesResp struct {
+	DocumentTypes []DocumentType `json:"documentTypes"`
 }
 
 type extractResp struct {
 	ExtractData []extractData `json:"extractData"`
+	Total       int           `json:"total"`
+	NextCursor  string        `json:"nextCursor,omitempty"`
 }
 
 type extractData struct {
@@ -87,13 +175,176 @@ type extractData struct {
 	SubmittedTime *util.TimeWithTrailingZeroMsec `json:"submittedTime"`
 }
 
-type getExtractData struct {
-	Data []userExtractedData `json:"userDetails"`
+type extractJobReq struct {
+	ExtractID string `json:"extractID"`
+}
+
+// extractJob extracts the documents of the users of a submission. The results of the users are added as the chunks
+// of the queries are processed.
+type extractJob struct {
+	ID          string                         `json:"id"`
+	ExtractID   string                         `json:"extractID"`
+	DocType     string                         `json:"docType"`
+	Status      string                         `json:"status"`
+	Error       string                         `json:"error,omitempty"`
+	Total       int                            `json:"total"`
+	Processed   int                            `json:"processed"`
+	Results     []userExtractResult            `json:"results"`
+	CreatedTime *util.TimeWithTrailingZeroMsec `json:"createdTime"`
+	UpdatedTime *util.TimeWithTrailingZeroMsec `json:"updatedTime"`
+	ExpiryTime  *util.TimeWithTrailingZeroMsec `json:"expiryTime"`
+}
+
+type userExtractResult struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	DID    string `json:"did"`
+	Value  string `json:"value,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type accountLink struct {
+	ID                   string                         `json:"id"`
+	UserID               string                         `json:"userID"`
+	UserName             string                         `json:"userName"`
+	State                string                         `json:"state"`
+	ClientID             string                         `json:"clientID,omitempty"`
+	ProfileID            string                         `json:"profileID,omitempty"`
+	VaultID              string                         `json:"vaultID"`
+	DocType              string                         `json:"docType,omitempty"`
+	VaultAuthorizationID string                         `json:"vaultAuthorizationID,omitempty"`
+	ComparisonResult     *bool                          `json:"comparisonResult,omitempty"`
+	CreatedTime          *util.TimeWithTrailingZeroMsec `json:"createdTime"`
+	AuthExpiry           *util.TimeWithTrailingZeroMsec `json:"authExpiry"`
+}
+
+// linkedTo checks whether the link was created by the client, or with the profile of the client.
+func (l *accountLink) linkedTo(c *clientData) bool {
+	return (l.ClientID != "" && l.ClientID == c.ClientID) || (l.ProfileID != "" && l.ProfileID == c.ProfileID)
 }
 
-type userExtractedData struct {
-	ID         string `json:"id"`
-	Name       string `json:"name"`
-	DID        string `json:"did"`
-	NationalID string `json:"nationalID"`
+// linked returns false if the comparison of the documents of the user failed. Links created on consent don't have
+// a comparison result.
+func (l *accountLink) linked() bool {
+	return l.ComparisonResult == nil || *l.ComparisonResult
+}
+
+type accountLinksResp struct {
+	Links      []*accountLink `json:"links"`
+	Total      int            `json:"total"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
+type accountUnlinkReq struct {
+	State string `json:"state"`
+}
+
+type docAuthorization struct {
+	VaultAuthorizationID string
+	ComparatorAuthToken  string
+}
+
+// userRegistration is the state of the registration saga of a user.
+type userRegistration struct {
+	ID          string                         `json:"id"`
+	UserName    string                         `json:"userName"`
+	Status      string                         `json:"status"`
+	UserID      string                         `json:"userID,omitempty"`
+	VaultID     string                         `json:"vaultID,omitempty"`
+	Documents   map[string]string              `json:"documents,omitempty"`
+	Error       string                         `json:"error,omitempty"`
+	CreatedTime *util.TimeWithTrailingZeroMsec `json:"createdTime"`
+	UpdatedTime *util.TimeWithTrailingZeroMsec `json:"updatedTime"`
+}
+
+type registrationResp struct {
+	ID          string                         `json:"id"`
+	UserName    string                         `json:"userName"`
+	Status      string                         `json:"status"`
+	Error       string                         `json:"error,omitempty"`
+	CreatedTime *util.TimeWithTrailingZeroMsec `json:"createdTime"`
+	UpdatedTime *util.TimeWithTrailingZeroMsec `json:"updatedTime"`
+}
+
+// userExport is everything held about a user. The password hash and the session ids aren't exported.
+type userExport struct {
+	User           *userData                      `json:"user"`
+	CreatedTime    *util.TimeWithTrailingZeroMsec `json:"createdTime,omitempty"`
+	Login          *loginExport                   `json:"login,omitempty"`
+	Sessions       []sessionExport                `json:"sessions"`
+	Registrations  []*userRegistration            `json:"registrations"`
+	Links          []*accountLink                 `json:"links"`
+	UserAuths      []*userAuthData                `json:"userAuths"`
+	ExtractResults []extractResultExport          `json:"extractResults"`
+	ExportedTime   *util.TimeWithTrailingZeroMsec `json:"exportedTime"`
+}
+
+type loginExport struct {
+	FailedAttempts int       `json:"failedAttempts"`
+	LockedUntil    time.Time `json:"lockedUntil,omitempty"`
+}
+
+type sessionExport struct {
+	CreatedTime  time.Time `json:"createdTime"`
+	LastSeenTime time.Time `json:"lastSeenTime"`
+}
+
+type extractResultExport struct {
+	JobID       string                         `json:"jobID"`
+	ExtractID   string                         `json:"extractID"`
+	DocType     string                         `json:"docType"`
+	CreatedTime *util.TimeWithTrailingZeroMsec `json:"createdTime"`
+	Result      userExtractResult              `json:"result"`
+}
+
+// auditRecord is an entry of the audit log. The tombstones of the erased users only keep the id of the user.
+type auditRecord struct {
+	ID     string                         `json:"id"`
+	Event  string                         `json:"event"`
+	UserID string                         `json:"userID"`
+	Actor  string                         `json:"actor"`
+	Erased map[string]int                 `json:"erased,omitempty"`
+	Time   *util.TimeWithTrailingZeroMsec `json:"time"`
+}
+
+type auditRecordsResp struct {
+	Records    []*auditRecord `json:"records"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
+type userImportReq struct {
+	Users []userImportRow `json:"users"`
+}
+
+// userImportRow is a user to import. The documents are keyed by the names of the document types.
+type userImportRow struct {
+	UserName  string            `json:"username"`
+	Password  string            `json:"password"`
+	Documents map[string]string `json:"documents"`
+}
+
+type userImportResult struct {
+	Row            int    `json:"row"`
+	UserName       string `json:"username"`
+	Status         string `json:"status"`
+	UserID         string `json:"userID,omitempty"`
+	RegistrationID string `json:"registrationID,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// importJob imports the users of a file. The results of the rows are added as the users are imported.
+type importJob struct {
+	ID          string                         `json:"id"`
+	Status      string                         `json:"status"`
+	Error       string                         `json:"error,omitempty"`
+	Total       int                            `json:"total"`
+	Processed   int                            `json:"processed"`
+	Created     int                            `json:"created"`
+	Existing    int                            `json:"existing"`
+	Failed      int                            `json:"failed"`
+	Results     []userImportResult             `json:"results"`
+	CreatedTime *util.TimeWithTrailingZeroMsec `json:"createdTime"`
+	UpdatedTime *util.TimeWithTrailingZeroMsec `json:"updatedTime"`
+	ExpiryTime  *util.TimeWithTrailingZeroMsec `json:"expiryTime"`
 }
@@ -8,6 +8,7 @@ package operation
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
@@ -15,11 +16,11 @@ import (
 	"html/template"
 	"io/ioutil"
 	"net/http"
-	"sort"
+	"net/url"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/dchest/uniuri"
 	httptransport "github.com/go-openapi/runtime/client"
 	"github.com/go-openapi/strfmt"
 	"github.com/google/uuid"
@@ -30,17 +31,16 @@ import (
 	"github.com/hyperledger/aries-framework-go/spi/storage"
 	"github.com/piprate/json-gold/ld"
 	"github.com/trustbloc/edge-core/pkg/log"
-	"github.com/trustbloc/edge-core/pkg/zcapld"
 	compclient "github.com/trustbloc/edge-service/pkg/client/comparator/client"
 	compclientops "github.com/trustbloc/edge-service/pkg/client/comparator/client/operations"
 	compmodel "github.com/trustbloc/edge-service/pkg/client/comparator/models"
-	vaultclient "github.com/trustbloc/edge-service/pkg/client/vault"
 	"github.com/trustbloc/edge-service/pkg/restapi/comparator/operation/models"
 	edgesvcops "github.com/trustbloc/edge-service/pkg/restapi/issuer/operation"
 	"github.com/trustbloc/edge-service/pkg/restapi/vault"
 	"github.com/trustbloc/edv/pkg/edvutils"
 
 	"github.com/trustbloc/sandbox/pkg/internal/common/support"
+	"github.com/trustbloc/sandbox/pkg/vdr"
 )
 
 const (
@@ -54,23 +54,36 @@ const (
 	consent             = "/consent"
 	client              = "/client"
 	getClient           = client + "/{id}"
+	clientSecret        = getClient + "/secret"
 	profile             = "/profile"
 	getProfile          = profile + "/{id}"
 	users               = "/users"
 	userAuth            = users + "/auth"
 	generateUserAuth    = userAuth + "/generate"
 	userExtract         = users + "/extract"
-	getUserExtract      = userExtract + "/{id}"
+	extractJobs         = "/extract/jobs"
+	getExtractJob       = extractJobs + "/{id}"
+	changePassword      = "/password"
+	links               = "/links"
+	getLink             = links + "/{id}"
+	disconnect          = "/disconnect"
+	dashboard           = "/dashboard"
 
 	// store
-	txnStoreName      = "issuer_txn"
-	userStoreName     = "user_txn"
-	userAuthStoreName = "userauth_txn"
+	txnStoreName           = "issuer_txn"
+	userStoreName          = "user_txn"
+	userAuthStoreName      = "userauth_txn"
+	credentialStoreName    = "user_credential"
+	linkStoreName          = "account_link"
+	clientStoreName        = "client"
+	profileStoreName       = "profile"
+	profileSecretStoreName = "profile_secret"
 
 	// form param
-	username   = "username"
-	password   = "password"
-	nationalID = "nationalID"
+	username    = "username"
+	password    = "password"
+	newPassword = "newPassword"
+	nationalID  = "nationalID"
 
 	// cookies
 	actionCookie     = "action"
@@ -79,8 +92,6 @@ const (
 	sessionidCookie  = "sessionid"
 	cookieExpiryTime = 5
 	authExpiryTime   = 5
-	// TODO- this should be configurable
-	resultLimit = 5
 
 	vcsIssuerRequestTokenName = "vcs_issuer"
 	requestTimeout            = 30 * time.Second
@@ -88,14 +99,15 @@ const (
 
 	// external paths
 	issueCredentialURLFormat = "%s" + "/credentials/issue"
-	accountLinkURLFormat     = "%s/link?client_id=%s&callback=%s/callback&state=%s"
+	accountLinkURLFormat     = "%s/link?client_id=%s&request_uri=%s"
 
 	// json-ld
 	credentialContext = "https://www.w3.org/2018/credentials/v1"
 
-	nationalIDVCPath = "$.credentialSubject." + nationalID
-
-	userTagName = "user"
+	userTagName    = "user"
+	stateTagName   = "state"
+	clientTagName  = "client"
+	profileTagName = "profile"
 )
 
 var logger = log.New("ace-rp-restapi")
@@ -104,14 +116,18 @@ type httpClient interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
-type vaultClient interface {
+// VaultClient is the client of the vault server.
+type VaultClient interface {
 	CreateVault() (*vault.CreatedVault, error)
 	SaveDoc(vaultID, id string, content interface{}) (*vault.DocumentMetadata, error)
 	CreateAuthorization(vaultID, requestingParty string,
 		scope *vault.AuthorizationsScope) (*vault.CreatedAuthorization, error)
+	DeleteAuthorization(vaultID, authorizationID string) error
+	DeleteVault(vaultID string) error
 }
 
-type comparatorClient interface {
+// ComparatorClient is the client of the comparator.
+type ComparatorClient interface {
 	GetConfig(params *compclientops.GetConfigParams) (*compclientops.GetConfigOK, error)
 	PostAuthorizations(params *compclientops.PostAuthorizationsParams) (*compclientops.PostAuthorizationsOK, error)
 	PostCompare(params *compclientops.PostCompareParams) (*compclientops.PostCompareOK, error)
@@ -130,6 +146,42 @@ type Operation struct {
 	store                   storage.Store
 	userStore               storage.Store
 	userAuthStore           storage.Store
+	credentialStore         storage.Store
+	credentialMu            sync.Mutex
+	authUsageStore          storage.Store
+	authUsageMu             sync.Mutex
+	linkStore               storage.Store
+	clientStore             storage.Store
+	profileStore            storage.Store
+	profileSecretStore      storage.Store
+	extractJobStore         storage.Store
+	importJobStore          storage.Store
+	registrationStore       storage.Store
+	sessionStore            storage.Store
+	auditStore              storage.Store
+	sessionIdleTimeout      time.Duration
+	sessionAbsoluteTimeout  time.Duration
+	linkRequestExpiry       time.Duration
+	maxLoginAttempts        int
+	lockoutDuration         time.Duration
+	clientSecretGracePeriod time.Duration
+	registrationTimeout     time.Duration
+	adminAPIKeys            []APIKey
+	tokenResolver           TokenResolver
+	tokenIssuer             string
+	tokenAudience           string
+	tokenScopeRoles         map[string]Role
+	extractQueue            chan string
+	ctx                     context.Context
+	cancel                  context.CancelFunc
+	workers                 sync.WaitGroup
+	extractChunkSize        int
+	extractRetries          int
+	extractRetryDelay       time.Duration
+	extractRetention        time.Duration
+	importWorkers           int
+	importRate              int
+	importRetention         time.Duration
 	handlers                []Handler
 	homePageHTML            string
 	loginHTML               string
@@ -143,11 +195,13 @@ type Operation struct {
 	accountLinkProfile      string
 	extractorProfile        string
 	hostExternalURL         string
-	vClient                 vaultClient
-	compClient              comparatorClient
+	vClient                 VaultClient
+	compClient              ComparatorClient
 	svcName                 string
 	vdri                    vdrapi.Registry
+	didBackoff              vdr.Backoff
 	documentLoader          ld.DocumentLoader
+	documentTypes           []DocumentType
 	addJSONLDContextHandler http.HandlerFunc
 }
 
@@ -171,41 +225,152 @@ type Config struct {
 	SvcName              string
 	VDRI                 vdrapi.Registry
 	DocumentLoader       ld.DocumentLoader
+	ExtractWorkers       int
+	ExtractChunkSize     int
+	ExtractRetries       int
+	ExtractRetryDelay    time.Duration
+	ExtractJobRetention  time.Duration
+	// ImportWorkers is the number of the users imported concurrently, ImportRate the number of the imports started
+	// per second, and ImportJobRetention the time the results of an import are kept.
+	ImportWorkers      int
+	ImportRate         int
+	ImportJobRetention time.Duration
+	// DIDResolveRetries and DIDResolveDelay configure the backoff of the resolution of the vault DIDs, which are
+	// resolved before they are published.
+	DIDResolveRetries int
+	DIDResolveDelay   time.Duration
+	// DocumentTypes are the types of the documents saved in the vaults. Only the national id is saved if empty.
+	DocumentTypes []DocumentType
+	// SessionIdleTimeout and SessionAbsoluteTimeout are the timeouts of the login sessions, after the last request
+	// and after the login.
+	SessionIdleTimeout     time.Duration
+	SessionAbsoluteTimeout time.Duration
+	// LinkRequestExpiry is the lifetime of the account link requests pushed by the clients.
+	LinkRequestExpiry time.Duration
+	// MaxLoginAttempts is the number of the failed logins after which the account is locked for LockoutDuration.
+	MaxLoginAttempts int
+	LockoutDuration  time.Duration
+	// ClientSecretGracePeriod is the time the previous secret of a client stays valid after a rotation.
+	ClientSecretGracePeriod time.Duration
+	// RegistrationTimeout is the time after which an unfinished registration is rolled back.
+	RegistrationTimeout time.Duration
+	// AdminAPIKeys and the bearer tokens of the TokenResolver authenticate the callers of the management endpoints.
+	// The tokens must be issued by the TokenIssuer for the TokenAudience, and TokenScopeRoles maps their scopes to
+	// the roles they grant. The client of a partner service token must be a client tied to the partner profile.
+	AdminAPIKeys    []APIKey
+	TokenResolver   TokenResolver
+	TokenIssuer     string
+	TokenAudience   string
+	TokenScopeRoles map[string]Role
+	// VaultClient and ComparatorClient replace the clients of VaultServerURL and ComparatorURL if set, for example
+	// with the local stand-ins of the services.
+	VaultClient      VaultClient
+	ComparatorClient ComparatorClient
 }
 
 // New returns ace-rp operation instance.
 func New(config *Config) (*Operation, error) { //nolint:funlen
+	if config.TokenResolver != nil &&
+		(config.TokenIssuer == "" || config.TokenAudience == "" || len(config.TokenScopeRoles) == 0) {
+		return nil, errors.New("token resolver needs the token issuer, audience and scope roles")
+	}
+
 	store, err := getStore(config.StoreProvider, txnStoreName, nil)
 	if err != nil {
 		return nil, fmt.Errorf("ace-rp store provider : %w", err)
 	}
 
 	userStore, err := getStore(config.StoreProvider, userStoreName,
-		&storage.StoreConfiguration{TagNames: []string{userTagName}})
+		&storage.StoreConfiguration{TagNames: []string{userTagName, createdTagName}})
 	if err != nil {
 		return nil, fmt.Errorf("ace-rp userStore store provider : %w", err)
 	}
 
 	userAuthStore, err := getStore(config.StoreProvider, userAuthStoreName,
-		&storage.StoreConfiguration{TagNames: []string{userTagName}})
+		&storage.StoreConfiguration{TagNames: []string{userTagName, createdTagName, sourceTagName}})
 	if err != nil {
 		return nil, fmt.Errorf("ace-rp userAuthStore store provider : %w", err)
 	}
 
-	httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: config.TLSConfig}}
+	credentialStore, err := getStore(config.StoreProvider, credentialStoreName, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ace-rp credentialStore store provider : %w", err)
+	}
 
-	if config.ComparatorURL == "" {
-		return nil, errors.New("comparator url mandatory")
+	linkStore, err := getStore(config.StoreProvider, linkStoreName,
+		&storage.StoreConfiguration{TagNames: []string{userTagName, stateTagName, clientTagName, createdTagName}})
+	if err != nil {
+		return nil, fmt.Errorf("ace-rp linkStore store provider : %w", err)
 	}
 
-	comparatorURL := strings.Split(config.ComparatorURL, "://")
+	clientStore, err := getStore(config.StoreProvider, clientStoreName,
+		&storage.StoreConfiguration{TagNames: []string{clientTagName}})
+	if err != nil {
+		return nil, fmt.Errorf("ace-rp clientStore store provider : %w", err)
+	}
 
-	transport := httptransport.NewWithClient(
-		comparatorURL[1],
-		compclient.DefaultBasePath,
-		[]string{comparatorURL[0]},
-		httpClient,
-	)
+	profileStore, err := getStore(config.StoreProvider, profileStoreName,
+		&storage.StoreConfiguration{TagNames: []string{profileTagName}})
+	if err != nil {
+		return nil, fmt.Errorf("ace-rp profileStore store provider : %w", err)
+	}
+
+	profileSecretStore, err := getStore(config.StoreProvider, profileSecretStoreName, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ace-rp profileSecretStore store provider : %w", err)
+	}
+
+	authUsageStore, err := getStore(config.StoreProvider, authUsageStoreName, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ace-rp authUsageStore store provider : %w", err)
+	}
+
+	extractJobStore, err := getStore(config.StoreProvider, extractJobStoreName,
+		&storage.StoreConfiguration{TagNames: []string{extractJobTagName}})
+	if err != nil {
+		return nil, fmt.Errorf("ace-rp extractJobStore store provider : %w", err)
+	}
+
+	importJobStore, err := getStore(config.StoreProvider, importJobStoreName,
+		&storage.StoreConfiguration{TagNames: []string{importJobTagName}})
+	if err != nil {
+		return nil, fmt.Errorf("ace-rp importJobStore store provider : %w", err)
+	}
+
+	registrationStore, err := getStore(config.StoreProvider, registrationStoreName,
+		&storage.StoreConfiguration{TagNames: []string{registrationTagName}})
+	if err != nil {
+		return nil, fmt.Errorf("ace-rp registrationStore store provider : %w", err)
+	}
+
+	sessionStore, err := getStore(config.StoreProvider, sessionStoreName,
+		&storage.StoreConfiguration{TagNames: []string{sessionUserTagName}})
+	if err != nil {
+		return nil, fmt.Errorf("ace-rp sessionStore store provider : %w", err)
+	}
+
+	auditStore, err := getStore(config.StoreProvider, auditStoreName,
+		&storage.StoreConfiguration{TagNames: []string{auditTagName}})
+	if err != nil {
+		return nil, fmt.Errorf("ace-rp auditStore store provider : %w", err)
+	}
+
+	documentTypes := config.DocumentTypes
+	if len(documentTypes) == 0 {
+		documentTypes = defaultDocumentTypes()
+	}
+
+	err = validateDocumentTypes(documentTypes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid document types : %w", err)
+	}
+
+	httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: config.TLSConfig}}
+
+	compClient, err := getComparatorClient(config, httpClient)
+	if err != nil {
+		return nil, err
+	}
 
 	contextOp, err := jsonldcontextrest.New(&storeProvider{config.StoreProvider})
 	if err != nil {
@@ -216,6 +381,37 @@ func New(config *Config) (*Operation, error) { //nolint:funlen
 		store:                   store,
 		userStore:               userStore,
 		userAuthStore:           userAuthStore,
+		credentialStore:         credentialStore,
+		authUsageStore:          authUsageStore,
+		linkStore:               linkStore,
+		clientStore:             clientStore,
+		profileStore:            profileStore,
+		profileSecretStore:      profileSecretStore,
+		extractJobStore:         extractJobStore,
+		importJobStore:          importJobStore,
+		registrationStore:       registrationStore,
+		sessionStore:            sessionStore,
+		auditStore:              auditStore,
+		sessionIdleTimeout:      durationOrDefault(config.SessionIdleTimeout, defaultSessionIdleTimeout),
+		sessionAbsoluteTimeout:  durationOrDefault(config.SessionAbsoluteTimeout, defaultSessionAbsoluteTimeout),
+		linkRequestExpiry:       durationOrDefault(config.LinkRequestExpiry, defaultLinkRequestExpiry),
+		maxLoginAttempts:        intOrDefault(config.MaxLoginAttempts, defaultMaxLoginAttempts),
+		lockoutDuration:         durationOrDefault(config.LockoutDuration, defaultLockoutDuration),
+		clientSecretGracePeriod: durationOrDefault(config.ClientSecretGracePeriod, defaultClientSecretGracePeriod),
+		registrationTimeout:     durationOrDefault(config.RegistrationTimeout, defaultRegistrationTimeout),
+		adminAPIKeys:            config.AdminAPIKeys,
+		tokenResolver:           config.TokenResolver,
+		tokenIssuer:             config.TokenIssuer,
+		tokenAudience:           config.TokenAudience,
+		tokenScopeRoles:         config.TokenScopeRoles,
+		extractQueue:            make(chan string, extractQueueSize),
+		extractChunkSize:        intOrDefault(config.ExtractChunkSize, defaultExtractChunkSize),
+		extractRetries:          intOrDefault(config.ExtractRetries, defaultExtractRetries),
+		extractRetryDelay:       durationOrDefault(config.ExtractRetryDelay, defaultExtractRetryDelay),
+		extractRetention:        durationOrDefault(config.ExtractJobRetention, defaultExtractRetention),
+		importWorkers:           intOrDefault(config.ImportWorkers, defaultImportWorkers),
+		importRate:              intOrDefault(config.ImportRate, defaultImportRate),
+		importRetention:         durationOrDefault(config.ImportJobRetention, defaultImportRetention),
 		homePageHTML:            config.HomePageHTML,
 		loginHTML:               config.LoginHTML,
 		dashboardHTML:           config.DashboardHTML,
@@ -228,39 +424,103 @@ func New(config *Config) (*Operation, error) { //nolint:funlen
 		extractorProfile:        config.ExtractorProfile,
 		hostExternalURL:         config.HostExternalURL,
 		requestTokens:           config.RequestTokens,
-		vClient:                 vaultclient.New(config.VaultServerURL, vaultclient.WithHTTPClient(httpClient)),
-		compClient:              compclient.New(transport, strfmt.Default).Operations,
+		vClient:                 getVaultClient(config, httpClient),
+		compClient:              compClient,
 		svcName:                 config.SvcName,
 		vdri:                    config.VDRI,
+		didBackoff:              didBackoff(config),
 		documentLoader:          config.DocumentLoader,
+		documentTypes:           documentTypes,
 		addJSONLDContextHandler: contextOp.Add,
 	}
 
+	op.ctx, op.cancel = context.WithCancel(context.Background())
+
 	op.registerHandler()
+	op.indexLegacyItems()
+	op.migrateLegacyProfiles()
+	op.startExtractWorkers(intOrDefault(config.ExtractWorkers, defaultExtractWorkers))
+	op.recoverRegistrations()
+	op.recoverImportJobs()
 
 	return op, nil
 }
 
+func getVaultClient(config *Config, httpClient *http.Client) VaultClient {
+	if config.VaultClient != nil {
+		return config.VaultClient
+	}
+
+	return newVaultClient(config.VaultServerURL, httpClient)
+}
+
+func getComparatorClient(config *Config, httpClient *http.Client) (ComparatorClient, error) {
+	if config.ComparatorClient != nil {
+		return config.ComparatorClient, nil
+	}
+
+	if config.ComparatorURL == "" {
+		return nil, errors.New("comparator url mandatory")
+	}
+
+	comparatorURL := strings.Split(config.ComparatorURL, "://")
+
+	transport := httptransport.NewWithClient(
+		comparatorURL[1],
+		compclient.DefaultBasePath,
+		[]string{comparatorURL[0]},
+		httpClient,
+	)
+
+	return compclient.New(transport, strfmt.Default).Operations, nil
+}
+
 // registerHandler register handlers to be exposed from this service as REST API endpoints
 func (o *Operation) registerHandler() {
 	o.handlers = []Handler{
 		support.NewHTTPHandler(register, http.MethodPost, o.register),
+		support.NewHTTPHandler(registration, http.MethodGet, o.getRegistrationStatus),
 		support.NewHTTPHandler(login, http.MethodPost, o.login),
 		support.NewHTTPHandler(logout, http.MethodGet, o.logout),
+		support.NewHTTPHandler(dashboard, http.MethodGet, o.showUserDashboard),
+		support.NewHTTPHandler(changePassword, http.MethodPost, o.changePassword),
 		support.NewHTTPHandler(connect, http.MethodGet, o.connect),
+		support.NewHTTPHandler(disconnect, http.MethodPost, o.disconnect),
+		support.NewHTTPHandler(accountExport, http.MethodGet, o.exportAccount),
+		support.NewHTTPHandler(accountErase, http.MethodPost, o.eraseAccount),
+		support.NewHTTPHandler(links, http.MethodGet, o.requireRole(o.getLinks, RoleOperator, RoleAuditor)),
+		support.NewHTTPHandler(getLink, http.MethodGet, o.requireRole(o.getLink, RoleOperator, RoleAuditor)),
+		support.NewHTTPHandler(link, http.MethodPost, o.pushLinkRequest),
 		support.NewHTTPHandler(link, http.MethodGet, o.link),
 		support.NewHTTPHandler(accountLinkCallback, http.MethodGet, o.accountLinkCallback),
-		support.NewHTTPHandler(consent, http.MethodGet, o.consent),
-		support.NewHTTPHandler(client, http.MethodPost, o.createClient),
-		support.NewHTTPHandler(getClient, http.MethodGet, o.getClient),
-		support.NewHTTPHandler(profile, http.MethodPost, o.createProfile),
-		support.NewHTTPHandler(getProfile, http.MethodGet, o.getProfile),
-		support.NewHTTPHandler(getProfile, http.MethodDelete, o.deleteProfile),
-		support.NewHTTPHandler(users, http.MethodGet, o.getUsers),
-		support.NewHTTPHandler(generateUserAuth, http.MethodPost, o.generateUserAuths),
+		support.NewHTTPHandler(accountLinkCallback, http.MethodPost, o.accountUnlink),
+		support.NewHTTPHandler(consent, http.MethodPost, o.consent),
+		support.NewHTTPHandler(client, http.MethodPost, o.requireRole(o.createClient, RoleOperator)),
+		support.NewHTTPHandler(client, http.MethodGet, o.requireRole(o.getClients, RoleOperator, RoleAuditor)),
+		support.NewHTTPHandler(getClient, http.MethodGet, o.requireRole(o.getClient, RoleOperator, RoleAuditor)),
+		support.NewHTTPHandler(getClient, http.MethodPut, o.requireRole(o.updateClient, RoleOperator)),
+		support.NewHTTPHandler(getClient, http.MethodDelete, o.requireRole(o.deleteClient, RoleOperator)),
+		support.NewHTTPHandler(clientSecret, http.MethodPost, o.rotateClientSecret),
+		support.NewHTTPHandler(clientSecret, http.MethodPut, o.requireRole(o.resetClientSecret, RoleOperator)),
+		support.NewHTTPHandler(profile, http.MethodPost, o.requireRole(o.createProfile, RoleOperator)),
+		support.NewHTTPHandler(profile, http.MethodGet, o.requireRole(o.getProfiles, RoleOperator)),
+		support.NewHTTPHandler(getProfile, http.MethodGet, o.requireRole(o.getProfile, RoleOperator)),
+		support.NewHTTPHandler(getProfile, http.MethodPut, o.requireRole(o.updateProfile, RoleOperator)),
+		support.NewHTTPHandler(getProfile, http.MethodDelete, o.requireRole(o.deleteProfile, RoleOperator)),
+		support.NewHTTPHandler(users, http.MethodGet, o.requireRole(o.getUsers, RoleOperator, RoleAuditor)),
+		support.NewHTTPHandler(userImport, http.MethodPost, o.requireRole(o.importUsers, RoleOperator)),
+		support.NewHTTPHandler(getImportJob, http.MethodGet, o.requireRole(o.getImportJob, RoleOperator)),
+		support.NewHTTPHandler(userDataExport, http.MethodGet, o.requireRole(o.exportUserData, RoleOperator)),
+		support.NewHTTPHandler(getUser, http.MethodDelete, o.requireRole(o.deleteUser, RoleOperator)),
+		support.NewHTTPHandler(audit, http.MethodGet, o.requireRole(o.getAuditRecords, RoleOperator, RoleAuditor)),
+		support.NewHTTPHandler(generateUserAuth, http.MethodPost, o.requireRole(o.generateUserAuths, RoleOperator)),
 		support.NewHTTPHandler(userAuth, http.MethodPost, o.saveUserAuths),
-		support.NewHTTPHandler(userExtract, http.MethodGet, o.extractRequests),
-		support.NewHTTPHandler(getUserExtract, http.MethodGet, o.getUserExtract),
+		support.NewHTTPHandler(userExtract, http.MethodGet,
+			o.requireRole(o.extractRequests, RoleOperator, RoleAuditor)),
+		// the extract jobs have the extracted documents
+		support.NewHTTPHandler(extractJobs, http.MethodPost, o.requireRole(o.createExtractJob, RoleOperator)),
+		support.NewHTTPHandler(getExtractJob, http.MethodGet, o.requireRole(o.getExtractJob, RoleOperator)),
+		support.NewHTTPHandler(docTypes, http.MethodGet, o.getDocumentTypes),
 
 		// TODO find a way to handle this in start.go
 		support.NewHTTPHandler("/showlogin", http.MethodGet, o.showlogin),
@@ -275,7 +535,16 @@ func (o *Operation) GetRESTHandlers() []Handler {
 	return o.handlers
 }
 
-func (o *Operation) register(w http.ResponseWriter, r *http.Request) { // nolint: funlen
+// Close stops the extract workers, the imports and the rollbacks of the stale registrations, and waits for the
+// jobs in progress to save their state. The unfinished jobs are resumed or failed on the next start.
+func (o *Operation) Close() {
+	o.cancel()
+	o.workers.Wait()
+}
+
+// register runs the registration saga of the user. The caller can pass the id of a failed registration to retry it,
+// and get the status of the registration with the id returned in the registration header.
+func (o *Operation) register(w http.ResponseWriter, r *http.Request) { // nolint: funlen,gocyclo
 	err := r.ParseForm()
 	if err != nil {
 		o.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("unable to parse form data: %s", err.Error()))
@@ -283,80 +552,85 @@ func (o *Operation) register(w http.ResponseWriter, r *http.Request) { // nolint
 		return
 	}
 
-	pwd, err := o.store.Get(r.FormValue(username))
-	if err != nil && !errors.Is(err, storage.ErrDataNotFound) {
-		o.writeErrorResponse(w, http.StatusInternalServerError,
-			fmt.Sprintf("unable to get user data %s : %s", r.FormValue(username), err.Error()))
+	err = validatePassword(r.FormValue(password))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		o.showRegistrationError(w, r.FormValue(username), err.Error())
 
 		return
 	}
 
-	if pwd != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		o.showDashboard(w, r.FormValue(username),
-			fmt.Sprintf("Username '%s' already exists", r.FormValue(username)), "", false)
+	cred, err := newUserCredential(r.FormValue(password))
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to hash password - err:%s", err.Error()))
 
 		return
 	}
 
-	// create vault for the user
-	vaultID, docID, err := o.storeNationalID(r.FormValue(nationalID))
+	values, err := o.documentValues(r)
 	if err != nil {
-		o.writeErrorResponse(w, http.StatusInternalServerError,
-			fmt.Sprintf("failed to store national id in vault %s - err:%s", r.FormValue(nationalID), err.Error()))
+		o.writeErrorResponse(w, http.StatusBadRequest, err.Error())
 
 		return
 	}
 
-	uData := userData{
-		ID:              strings.ToUpper(uniuri.NewLen(userIDLen)),
-		UserName:        r.FormValue(username),
-		VaultID:         vaultID,
-		NationalIDDocID: docID,
-	}
+	reg, err := o.startRegistration(r.FormValue(registrationIDParam), r.FormValue(username), cred)
 
-	uDataBytes, err := json.Marshal(uData)
-	if err != nil {
-		o.writeErrorResponse(w, http.StatusInternalServerError,
-			fmt.Sprintf("failed to unmarshal user data - err:%s", err.Error()))
+	switch {
+	case errors.Is(err, errUsernameExists):
+		w.WriteHeader(http.StatusBadRequest)
+		o.showRegistrationError(w, r.FormValue(username),
+			fmt.Sprintf("Username '%s' already exists", r.FormValue(username)))
 
 		return
-	}
+	case errors.Is(err, errRegistrationInProgress):
+		o.writeErrorResponse(w, http.StatusConflict, err.Error())
 
-	err = o.store.Put(r.FormValue(username), uDataBytes)
-	if err != nil {
+		return
+	case errors.Is(err, errInvalidRegistration):
+		o.writeErrorResponse(w, http.StatusBadRequest, err.Error())
+
+		return
+	case err != nil:
 		o.writeErrorResponse(w, http.StatusInternalServerError,
-			fmt.Sprintf("unable to save user data %s: %s", r.FormValue(username), err.Error()))
+			fmt.Sprintf("unable to reserve username %s : %s", r.FormValue(username), err.Error()))
 
 		return
 	}
 
-	uMap := userIDNameMap{
-		ID:          uData.ID,
-		UserName:    uData.UserName,
-		CreatedTime: util.NewTime(time.Now()),
-	}
+	w.Header().Set(registrationIDHeader, reg.ID)
 
-	uMapBytes, err := json.Marshal(uMap)
-	if err != nil {
-		o.writeErrorResponse(w, http.StatusInternalServerError,
-			fmt.Sprintf("failed to unmarshal user data - err:%s", err.Error()))
+	// retry of a completed registration
+	if reg.Status == registrationCompleted {
+		if !o.authenticate(w, reg.UserName, r.FormValue(password)) {
+			return
+		}
+
+		if session, ok := o.startSessionOrWriteError(w, r, reg.UserName); ok {
+			o.showDashboard(w, session, reg.VaultID, false)
+		}
 
 		return
 	}
 
-	err = o.userStore.Put(uData.ID, uMapBytes, storage.Tag{Name: userTagName})
+	err = o.runRegistration(r.Context(), reg, values)
 	if err != nil {
-		o.writeErrorResponse(w, http.StatusInternalServerError,
-			fmt.Sprintf("unable to save id-username mapping data: %s", err.Error()))
+		o.rollbackRegistration(reg, err)
+		o.writeErrorResponse(w, http.StatusInternalServerError, err.Error())
 
 		return
 	}
 
-	o.showDashboard(w, r.FormValue(username), "", vaultID, false)
+	session, ok := o.startSessionOrWriteError(w, r, reg.UserName)
+	if !ok {
+		return
+	}
+
+	o.showDashboard(w, session, reg.VaultID, false)
 }
 
-func (o *Operation) login(w http.ResponseWriter, r *http.Request) {
+func (o *Operation) login(w http.ResponseWriter, r *http.Request) { // nolint: funlen
 	err := r.ParseForm()
 	if err != nil {
 		o.writeErrorResponse(w, http.StatusInternalServerError,
@@ -365,6 +639,10 @@ func (o *Operation) login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !o.authenticate(w, r.FormValue(username), r.FormValue(password)) {
+		return
+	}
+
 	uData, err := o.getUserData(r.FormValue(username))
 	if err != nil {
 		o.writeErrorResponse(w, http.StatusInternalServerError,
@@ -392,24 +670,79 @@ func (o *Operation) login(w http.ResponseWriter, r *http.Request) {
 		logger.Infof("loginQueryParam: action=%s id=%s", action, id)
 	}
 
-	sessionID := uuid.New().String()
-
-	err = o.store.Put(sessionID, []byte(r.FormValue(username)))
-	if err != nil {
-		o.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to save session : %s", err.Error()))
-
+	session, ok := o.startSessionOrWriteError(w, r, r.FormValue(username))
+	if !ok {
 		return
 	}
 
 	if action == linkAction {
 		o.loadHTML(w, o.consentHTML, map[string]interface{}{
-			"QueryParam": fmt.Sprintf("?action=%s&id=%s&sessionid=%s", action, id, sessionID),
+			"QueryParam": fmt.Sprintf("?action=%s&id=%s", action, url.QueryEscape(id)),
+			"CSRFToken":  session.CSRFToken,
 		})
 
 		return
 	}
 
-	o.showDashboard(w, r.FormValue(username), "", uData.VaultID, true)
+	serviceLinked, err := o.isLinked(uData.ID)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to get account links of user %s: %s", r.FormValue(username), err.Error()))
+
+		return
+	}
+
+	o.showDashboard(w, session, uData.VaultID, serviceLinked)
+}
+
+func (o *Operation) changePassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("unable to parse form data: %s", err.Error()))
+
+		return
+	}
+
+	err = validatePassword(r.FormValue(newPassword))
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	if !o.authenticate(w, r.FormValue(username), r.FormValue(password)) {
+		return
+	}
+
+	cred, err := newUserCredential(r.FormValue(newPassword))
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to hash password - err:%s", err.Error()))
+
+		return
+	}
+
+	o.credentialMu.Lock()
+	err = o.saveUserCredential(r.FormValue(username), cred)
+	o.credentialMu.Unlock()
+
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to save password - err:%s", err.Error()))
+
+		return
+	}
+
+	err = o.renewSessions(w, r, r.FormValue(username))
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to renew sessions - err:%s", err.Error()))
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
 }
 
 func (o *Operation) showlogin(w http.ResponseWriter, r *http.Request) {
@@ -419,15 +752,47 @@ func (o *Operation) showlogin(w http.ResponseWriter, r *http.Request) {
 }
 
 func (o *Operation) logout(w http.ResponseWriter, r *http.Request) {
+	o.endSession(w, r)
 	clearCookies(w)
 
 	o.loadHTML(w, o.homePageHTML, map[string]interface{}{})
 }
 
+// showUserDashboard shows the dashboard of the user of the session.
+func (o *Operation) showUserDashboard(w http.ResponseWriter, r *http.Request) {
+	session, ok := o.requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	uData, err := o.getUserData(session.UserName)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("unable to get user data %s: %s", session.UserName, err.Error()))
+
+		return
+	}
+
+	serviceLinked, err := o.isLinked(uData.ID)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to get account links of user %s: %s", session.UserName, err.Error()))
+
+		return
+	}
+
+	o.showDashboard(w, session, uData.VaultID, serviceLinked)
+}
+
 func (o *Operation) connect(w http.ResponseWriter, r *http.Request) {
-	userName := r.URL.Query()["userName"]
-	if len(userName) == 0 {
-		o.writeErrorResponse(w, http.StatusBadRequest, "missing username")
+	session, ok := o.requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	docType, err := o.documentType(r.URL.Query().Get(docTypeParam))
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusBadRequest, err.Error())
 
 		return
 	}
@@ -442,14 +807,22 @@ func (o *Operation) connect(w http.ResponseWriter, r *http.Request) {
 
 	state := uuid.New().String()
 
-	err = o.store.Put(state, []byte(userName[0]))
+	err = o.saveConnectState(state, &connectState{UserName: session.UserName, DocType: docType.Name})
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError, err.Error())
+
+		return
+	}
+
+	requestURI, err := o.requestAccountLink(data, state, docType.Name)
 	if err != nil {
 		o.writeErrorResponse(w, http.StatusInternalServerError,
-			fmt.Sprintf("failed to save state data : %s", err.Error()))
+			fmt.Sprintf("failed to request account link : %s", err.Error()))
+
 		return
 	}
 
-	endpoint := fmt.Sprintf(accountLinkURLFormat, data.URL, data.ClientID, o.hostExternalURL, state)
+	endpoint := fmt.Sprintf(accountLinkURLFormat, data.URL, url.QueryEscape(data.ClientID), url.QueryEscape(requestURI))
 
 	logger.Infof("connect: redirectURL=[%s]", endpoint)
 
@@ -474,20 +847,42 @@ func (o *Operation) accountLinkCallback(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	uNameBytes, err := o.store.Get(state[0])
+	cState, err := o.consumeConnectState(state[0])
 	if err != nil {
-		o.writeErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("failed to get state %s : %s", state, err.Error()))
+		o.writeErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("failed to consume state : %s", err.Error()))
 
 		return
 	}
 
-	userData, err := o.getUserData(string(uNameBytes))
+	userData, err := o.getUserData(cState.UserName)
 	if err != nil {
 		o.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("unable to get user data: %s", err.Error()))
 
 		return
 	}
 
+	docType, err := o.documentType(cState.DocType)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	docID, err := userData.docID(docType.Name)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	pData, err := o.getProfileData(o.accountLinkProfile)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to get profile data : %s", err.Error()))
+
+		return
+	}
+
 	confResp, err := o.compClient.GetConfig(compclientops.NewGetConfigParams().
 		WithTimeout(requestTimeout))
 	if err != nil {
@@ -503,16 +898,22 @@ func (o *Operation) accountLinkCallback(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// the linked service requests the comparison
+	cancelUse, err := o.enforceAuthPolicy(pData, docID, pData.DID)
+	if err != nil {
+		o.writeAuthPolicyError(w, err)
+
+		return
+	}
+
 	docAuth, err := o.vClient.CreateAuthorization(
 		userData.VaultID,
 		confResp.Payload.AuthKeyURL,
-		&vault.AuthorizationsScope{
-			Target:  userData.NationalIDDocID,
-			Actions: []string{"read"},
-			Caveats: []vault.Caveat{{Type: zcapld.CaveatTypeExpiry, Duration: uint64(authExpiryTime * time.Second)}},
-		},
+		pData.AuthPolicy.vaultScope(docID),
 	)
 	if err != nil {
+		cancelUse()
+
 		o.writeErrorResponse(w, http.StatusInternalServerError,
 			fmt.Sprintf("failed to create vault authorization: %s", err.Error()))
 
@@ -520,21 +921,23 @@ func (o *Operation) accountLinkCallback(w http.ResponseWriter, r *http.Request)
 	}
 
 	if docAuth == nil || docAuth.Tokens == nil {
+		cancelUse()
+
 		o.writeErrorResponse(w, http.StatusInternalServerError, "missing auth token from vault-server")
 
 		return
 	}
 
 	logger.Infof("compare : vaultID=[%s] docID=[%s] kmsToken=[%s] edvToken=[%s] auth=[%s]",
-		userData.VaultID, userData.NationalIDDocID, docAuth.Tokens.KMS, docAuth.Tokens.EDV, auth[0])
+		userData.VaultID, docID, docAuth.Tokens.KMS, docAuth.Tokens.EDV, auth[0])
 
 	query := make([]models.Query, 0)
 	query = append(query,
 		&models.DocQuery{
-			DocID:       &userData.NationalIDDocID,
+			DocID:       &docID,
 			VaultID:     &userData.VaultID,
 			AuthTokens:  &models.DocQueryAO1AuthTokens{Kms: docAuth.Tokens.KMS, Edv: docAuth.Tokens.EDV},
-			DocAttrPath: nationalIDVCPath,
+			DocAttrPath: docType.attrPath(),
 		},
 		&models.AuthorizedQuery{
 			AuthToken: &auth[0],
@@ -567,7 +970,27 @@ func (o *Operation) accountLinkCallback(w http.ResponseWriter, r *http.Request)
 
 	logger.Infof("compare: result=[%s]", compareResp.Payload.Result)
 
-	if !compareResp.Payload.Result {
+	result := compareResp.Payload.Result
+
+	err = o.saveAccountLink(&accountLink{
+		UserID:               userData.ID,
+		UserName:             userData.UserName,
+		State:                state[0],
+		ProfileID:            o.accountLinkProfile,
+		VaultID:              userData.VaultID,
+		DocType:              docType.Name,
+		VaultAuthorizationID: docAuth.ID,
+		ComparisonResult:     &result,
+		AuthExpiry:           util.NewTime(time.Now().Add(time.Duration(pData.AuthPolicy.expiry()) * time.Second)),
+	})
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to save account link: %s", err.Error()))
+
+		return
+	}
+
+	if !result {
 		o.loadHTML(w, o.accountNotLinkedHTML, nil)
 
 		return
@@ -576,31 +999,39 @@ func (o *Operation) accountLinkCallback(w http.ResponseWriter, r *http.Request)
 	o.loadHTML(w, o.accountLinkedHTML, nil)
 }
 
-func (o *Operation) link(w http.ResponseWriter, r *http.Request) {
-	clientID := r.URL.Query()["client_id"]
-	if len(clientID) == 0 {
+// link starts the account link with the request pushed by the client. The callback url and the state come from the
+// authenticated request, not from the browser.
+func (o *Operation) link(w http.ResponseWriter, r *http.Request) { // nolint: funlen
+	clientID := r.URL.Query().Get("client_id")
+	if clientID == "" {
 		o.writeErrorResponse(w, http.StatusBadRequest, "missing client_id")
 
 		return
 	}
 
-	callback := r.URL.Query()["callback"]
-	if len(callback) == 0 {
-		o.writeErrorResponse(w, http.StatusBadRequest, "missing callback url")
+	requestURI := r.URL.Query().Get("request_uri")
+	if requestURI == "" {
+		o.writeErrorResponse(w, http.StatusBadRequest, "missing request_uri")
 
 		return
 	}
 
-	state := r.URL.Query()["state"]
-	if len(state) == 0 {
-		o.writeErrorResponse(w, http.StatusBadRequest, "missing state")
+	req, err := o.consumeLinkRequest(requestURI)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid link request: %s", err.Error()))
+
+		return
+	}
+
+	if req.ClientID != clientID {
+		o.writeErrorResponse(w, http.StatusBadRequest, "invalid link request: client_id mismatch")
 
 		return
 	}
 
-	logger.Infof("link : clientID=[%s] callbackURL=[%s] state=[%s]", clientID, callback, state)
+	logger.Infof("link : clientID=[%s] callbackURL=[%s] state=[%s]", req.ClientID, req.CallbackURL, req.State)
 
-	cData, err := o.getClientData(clientID[0])
+	cData, err := o.getClientData(clientID)
 	if err != nil {
 		o.writeErrorResponse(w, http.StatusBadRequest,
 			fmt.Sprintf("failed to get client data: %s", err.Error()))
@@ -609,9 +1040,11 @@ func (o *Operation) link(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := sessionData{
-		State:       state[0],
-		CallbackURL: callback[0],
+		State:       req.State,
+		CallbackURL: req.CallbackURL,
 		DID:         cData.DID,
+		ClientID:    cData.ClientID,
+		DocType:     req.DocType,
 	}
 
 	dataBytes, err := json.Marshal(data)
@@ -637,39 +1070,30 @@ func (o *Operation) link(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// consent authorizes the client of the account link request to the document of the user of the session. The consent
+// form must have the CSRF token of the session, and the account link request can only be consented to once.
 // nolint: funlen
 func (o *Operation) consent(w http.ResponseWriter, r *http.Request) { // nolint: gocyclo
-	id := ""
-	sessionID := ""
-
-	if r.URL.Query() != nil {
-		idParam := r.URL.Query()["id"]
-		if len(idParam) != 0 {
-			id = idParam[0]
-		}
-
-		sessionIDParam := r.URL.Query()["sessionid"]
-		if len(sessionIDParam) != 0 {
-			sessionID = sessionIDParam[0]
-		}
-
-		logger.Infof("consentQueryParam: id=%s sessionID=%s", id, sessionID)
+	session, ok := o.requireSession(w, r)
+	if !ok {
+		return
 	}
 
-	if id == "" || sessionID == "" {
-		o.writeErrorResponse(w, http.StatusBadRequest, "sessionid or id can't be empty")
-
+	if !o.requireCSRFToken(w, r, session) {
 		return
 	}
 
-	username, err := o.store.Get(sessionID)
-	if err != nil {
-		o.writeErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("failed to get session data: %s", err.Error()))
+	id := r.FormValue("id")
+
+	logger.Infof("consentQueryParam: id=%s", id)
+
+	if id == "" {
+		o.writeErrorResponse(w, http.StatusBadRequest, "id can't be empty")
 
 		return
 	}
 
-	userData, err := o.getUserData(string(username))
+	userData, err := o.getUserData(session.UserName)
 	if err != nil {
 		o.writeErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("failed to get user data: %s", err.Error()))
 
@@ -684,6 +1108,15 @@ func (o *Operation) consent(w http.ResponseWriter, r *http.Request) { // nolint:
 		return
 	}
 
+	// the session data is used once, so that the consent can't be replayed
+	err = o.store.Delete(id)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to delete state data: %s", err.Error()))
+
+		return
+	}
+
 	var data *sessionData
 
 	err = json.Unmarshal(dataBytes, &data)
@@ -702,154 +1135,110 @@ func (o *Operation) consent(w http.ResponseWriter, r *http.Request) { // nolint:
 		return
 	}
 
-	// pass the zcap to the caller
-	auth, err := o.getAuthorization(
-		userData.VaultID,
-		compConfig.AuthKeyURL,
-		userData.NationalIDDocID,
-		data.DID,
-	)
+	docType, err := o.documentType(data.DocType)
 	if err != nil {
-		o.writeErrorResponse(w, http.StatusInternalServerError,
-			fmt.Sprintf("failed create authorization : %s", err.Error()))
+		o.writeErrorResponse(w, http.StatusBadRequest, err.Error())
 
 		return
 	}
 
-	// invalid the cookies
-	clearCookies(w)
-
-	redirectURL := fmt.Sprintf("%s?state=%s&auth=%s", data.CallbackURL, data.State, auth)
+	docID, err := userData.docID(docType.Name)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusBadRequest, err.Error())
 
-	logger.Infof("consent : redirectURL=%s", redirectURL)
+		return
+	}
 
-	http.Redirect(w, r, redirectURL, http.StatusFound)
-}
-
-func (o *Operation) createClient(w http.ResponseWriter, r *http.Request) {
-	req := &clientReq{}
-
-	err := json.NewDecoder(r.Body).Decode(req)
+	pData, err := o.getClientProfile(data.ClientID)
 	if err != nil {
-		o.writeErrorResponse(w, http.StatusBadRequest,
-			fmt.Sprintf("failed to decode request: %s", err.Error()))
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to get client profile : %s", err.Error()))
 
 		return
 	}
 
-	data := clientData{
-		ClientID: uuid.New().String(),
-		DID:      req.DID,
-		Callback: req.Callback,
-	}
-
-	dataBytes, err := json.Marshal(data)
+	cancelUse, err := o.enforceAuthPolicy(pData, docID, data.DID)
 	if err != nil {
-		o.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to marshal client data: %s", err.Error()))
+		o.writeAuthPolicyError(w, err)
 
 		return
 	}
 
-	err = o.store.Put(data.ClientID, dataBytes)
+	// pass the zcap to the caller
+	auth, err := o.getAuthorization(
+		userData.VaultID,
+		compConfig.AuthKeyURL,
+		docID,
+		docType.attrPath(),
+		data.DID,
+		pData.AuthPolicy,
+	)
 	if err != nil {
-		o.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to save client data: %s", err.Error()))
+		cancelUse()
 
-		return
-	}
-
-	o.writeResponse(w, http.StatusCreated, clientResp{
-		ClientID:     data.ClientID,
-		ClientSecret: uuid.New().String(),
-		DID:          req.DID,
-		Callback:     req.Callback,
-	})
-}
-
-func (o *Operation) getClient(w http.ResponseWriter, r *http.Request) {
-	var data *clientData
-
-	o.getData(w, strings.Split(r.URL.Path, "/")[2], data)
-}
-
-func (o *Operation) createProfile(w http.ResponseWriter, r *http.Request) {
-	data := &profileData{}
-
-	err := json.NewDecoder(r.Body).Decode(data)
-	if err != nil {
-		o.writeErrorResponse(w, http.StatusBadRequest,
-			fmt.Sprintf("failed to decode request: %s", err.Error()))
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed create authorization : %s", err.Error()))
 
 		return
 	}
 
-	dataBytes, err := json.Marshal(data)
+	err = o.saveAccountLink(&accountLink{
+		UserID:               userData.ID,
+		UserName:             userData.UserName,
+		State:                data.State,
+		ClientID:             data.ClientID,
+		VaultID:              userData.VaultID,
+		DocType:              docType.Name,
+		VaultAuthorizationID: auth.VaultAuthorizationID,
+		AuthExpiry:           util.NewTime(time.Now().Add(time.Duration(pData.AuthPolicy.expiry()) * time.Second)),
+	})
 	if err != nil {
-		o.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to marshal client data: %s", err.Error()))
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to save account link: %s", err.Error()))
 
 		return
 	}
 
-	err = o.store.Put(data.ID, dataBytes)
-	if err != nil {
-		o.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to save client data: %s", err.Error()))
+	// invalid the cookies
+	clearCookies(w)
 
-		return
-	}
+	redirectURL := fmt.Sprintf("%s?state=%s&auth=%s", data.CallbackURL, data.State, auth.ComparatorAuthToken)
 
-	o.writeResponse(w, http.StatusCreated, data)
-}
-
-func (o *Operation) getProfile(w http.ResponseWriter, r *http.Request) {
-	var data *profileData
+	logger.Infof("consent : redirectURL=%s", redirectURL)
 
-	o.getData(w, strings.Split(r.URL.Path, "/")[2], data)
+	http.Redirect(w, r, redirectURL, http.StatusFound)
 }
 
-func (o *Operation) getData(w http.ResponseWriter, id string, data interface{}) {
-	dataBytes, err := o.store.Get(id)
-	if err != nil {
-		o.writeErrorResponse(w, http.StatusInternalServerError,
-			fmt.Sprintf("failed to get data : id=%s - %s", id, err.Error()))
-
-		return
-	}
-
-	err = json.Unmarshal(dataBytes, &data)
+func (o *Operation) getUsers(w http.ResponseWriter, r *http.Request) {
+	req, err := getListRequest(r)
 	if err != nil {
-		o.writeErrorResponse(w, http.StatusInternalServerError,
-			fmt.Sprintf("failed to unmarshal data : id=%s - %s", id, err.Error()))
+		o.writeErrorResponse(w, http.StatusBadRequest, err.Error())
 
 		return
 	}
 
-	o.writeResponse(w, http.StatusOK, data)
-}
-
-func (o *Operation) deleteProfile(w http.ResponseWriter, r *http.Request) {
-	profileID := strings.Split(r.URL.Path, "/")[2]
-
-	err := o.store.Delete(profileID)
+	page, err := queryIndex(o.userStore, userTagName, req, userIndex)
 	if err != nil {
 		o.writeErrorResponse(w, http.StatusInternalServerError,
-			fmt.Sprintf("failed to delete data : id=%s - %s", profileID, err.Error()))
+			fmt.Sprintf("failed get user data: %s", err.Error()))
 
 		return
 	}
 
-	o.writeResponse(w, http.StatusOK, nil)
-}
+	u := make([]userData, 0)
 
-func (o *Operation) getUsers(w http.ResponseWriter, r *http.Request) {
-	u, err := o.fetchUsers()
-	if err != nil {
-		o.writeErrorResponse(w, http.StatusInternalServerError,
-			fmt.Sprintf("failed get user data: %s", err.Error()))
+	if len(page.keys) > 0 {
+		u, err = o.fetchUsers(page.keys...)
+		if err != nil {
+			o.writeErrorResponse(w, http.StatusInternalServerError,
+				fmt.Sprintf("failed get user data: %s", err.Error()))
 
-		return
+			return
+		}
 	}
 
 	// send the user data in the response
-	o.writeResponse(w, http.StatusOK, &getUserDataResp{Users: u})
+	o.writeResponse(w, http.StatusOK, &getUserDataResp{Users: u, Total: page.total, NextCursor: page.next})
 }
 
 func (o *Operation) generateUserAuths(w http.ResponseWriter, r *http.Request) { // nolint: funlen
@@ -863,6 +1252,13 @@ func (o *Operation) generateUserAuths(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	docType, err := o.documentType(data.DocType)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
 	// get all the users
 	u, err := o.fetchUsers(data.Users...)
 	if err != nil {
@@ -881,6 +1277,14 @@ func (o *Operation) generateUserAuths(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	requester, err := o.requesterDID(principalOf(r))
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to get requester profile : %s", err.Error()))
+
+		return
+	}
+
 	// get the comparator config
 	compConfig, err := o.getComparatorConfig()
 	if err != nil {
@@ -894,26 +1298,49 @@ func (o *Operation) generateUserAuths(w http.ResponseWriter, r *http.Request) {
 
 	// get the authorization for all
 	for _, v := range u {
-		logger.Infof("generateUserAuths: id=[%s] vaultID=[%s] nationalDocID=[%s]", v.ID, v.VaultID, v.NationalIDDocID)
+		docID, docErr := v.docID(docType.Name)
+		if docErr != nil {
+			o.writeErrorResponse(w, http.StatusBadRequest, docErr.Error())
+
+			return
+		}
+
+		logger.Infof("generateUserAuths: id=[%s] vaultID=[%s] docType=[%s] docID=[%s]",
+			v.ID, v.VaultID, docType.Name, docID)
+
+		cancelUse, err := o.enforceAuthPolicy(pData, docID, requester)
+		if err != nil {
+			o.writeAuthPolicyError(w, err)
+
+			return
+		}
+
 		// pass the zcap to the caller
 		auth, authErr := o.getAuthorization(
 			v.VaultID,
 			compConfig.AuthKeyURL,
-			v.NationalIDDocID,
+			docID,
+			docType.attrPath(),
 			pData.DID,
+			pData.AuthPolicy,
 		)
 		if authErr != nil {
+			cancelUse()
+
 			o.writeErrorResponse(w, http.StatusInternalServerError,
 				fmt.Sprintf("failed create authorization : %s", authErr.Error()))
 
 			return
 		}
 
-		userAuths = append(userAuths, userAuthorization{ID: v.ID, Name: v.UserName, DID: v.VaultID, AuthToken: auth})
+		userAuths = append(userAuths, userAuthorization{
+			ID: v.ID, Name: v.UserName, DID: v.VaultID, AuthToken: auth.ComparatorAuthToken,
+		})
 	}
 
 	uData := &userAuthData{
 		Source:        o.svcName,
+		DocType:       docType.Name,
 		SubmittedTime: util.NewTime(time.Now()),
 		UserAuths:     userAuths,
 	}
@@ -926,10 +1353,24 @@ func (o *Operation) generateUserAuths(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// post the user authorizations to the extractor service
-	endpoint := pData.Callback + userAuth
+	// post the user authorizations to the extractor service, with the client credentials registered at the service
+	req, err := http.NewRequest(http.MethodPost, pData.Callback+userAuth, bytes.NewBuffer(reqBytes))
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to create request to extractor service : %s", err.Error()))
+
+		return
+	}
+
+	err = o.setProfileCredentials(req, pData)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to create request to extractor service : %s", err.Error()))
+
+		return
+	}
 
-	_, err = o.sendHTTPRequest(http.MethodPost, endpoint, reqBytes, http.StatusOK, "")
+	_, err = o.doHTTPRequest(req, http.StatusOK)
 	if err != nil {
 		o.writeErrorResponse(w, http.StatusInternalServerError,
 			fmt.Sprintf("failed to get user auth data : %s", err.Error()))
@@ -942,10 +1383,15 @@ func (o *Operation) generateUserAuths(w http.ResponseWriter, r *http.Request) {
 }
 
 func (o *Operation) saveUserAuths(w http.ResponseWriter, r *http.Request) {
+	pData, ok := o.requirePartner(w, r)
+	if !ok {
+		return
+	}
+
 	// decode request
-	data := &userAuthData{}
+	req := &saveUserAuthsReq{}
 
-	err := json.NewDecoder(r.Body).Decode(data)
+	err := json.NewDecoder(r.Body).Decode(req)
 	if err != nil {
 		o.writeErrorResponse(w, http.StatusBadRequest,
 			fmt.Sprintf("failed to decode request: %s", err.Error()))
@@ -953,23 +1399,28 @@ func (o *Operation) saveUserAuths(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if len(data.UserAuths) == 0 {
+	if len(req.UserAuths) == 0 {
 		o.writeErrorResponse(w, http.StatusBadRequest, "no user auths in the request")
 
 		return
 	}
 
-	// create a new id and save the data
-	data.ID = uuid.NewString()
-
-	authBytes, err := json.Marshal(data)
-	if err != nil {
-		o.writeErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("failed to marshal user auth data: %s", err.Error()))
+	// the source is the authenticated partner, so that a partner can't submit on behalf of another
+	source := pData.Name
+	if source == "" {
+		source = pData.ID
+	}
 
-		return
+	data := &userAuthData{
+		ID:            uuid.NewString(),
+		Source:        source,
+		ProfileID:     pData.ID,
+		DocType:       req.DocType,
+		SubmittedTime: util.NewTime(time.Now()),
+		UserAuths:     req.UserAuths,
 	}
 
-	err = o.userAuthStore.Put(data.ID, authBytes, storage.Tag{Name: userTagName})
+	err = o.saveUserAuthData(data)
 	if err != nil {
 		o.writeErrorResponse(w, http.StatusInternalServerError,
 			fmt.Sprintf("failed to save user auth data: %s", err.Error()))
@@ -977,149 +1428,77 @@ func (o *Operation) saveUserAuths(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	logger.Infof("saveUserAuths: id=[%s] data=[%s]", data.ID, string(authBytes))
+	logger.Infof("saveUserAuths: id=[%s] source=[%s] users=[%d]", data.ID, data.Source, len(data.UserAuths))
 
 	// send response
 	o.writeResponse(w, http.StatusOK, map[string]string{"id": data.ID})
 }
 
 func (o *Operation) extractRequests(w http.ResponseWriter, r *http.Request) {
-	userAuthData, err := o.fetchUserAuths()
-	if err != nil {
-		o.writeErrorResponse(w, http.StatusInternalServerError,
-			fmt.Sprintf("failed to get user auth data: %s", err.Error()))
-
-		return
-	}
-
-	eData := make([]extractData, 0)
-
-	for _, authData := range userAuthData {
-		eData = append(eData, extractData{
-			ID:            authData.ID,
-			Source:        authData.Source,
-			SubmittedTime: authData.SubmittedTime,
-		})
-	}
-
-	// send response
-	o.writeResponse(w, http.StatusOK, extractResp{ExtractData: eData})
-}
-
-func (o *Operation) getUserExtract(w http.ResponseWriter, r *http.Request) { // nolint: funlen
-	id := strings.Split(r.URL.Path, "/")[3]
-
-	userAuthDataByte, err := o.userAuthStore.Get(id)
-	if err != nil {
-		o.writeErrorResponse(w, http.StatusBadRequest,
-			fmt.Sprintf("failed to get user auth data %s : %s", id, err.Error()))
-
-		return
-	}
-
-	var u *userAuthData
-
-	err = json.Unmarshal(userAuthDataByte, &u)
+	req, err := getListRequest(r)
 	if err != nil {
-		o.writeErrorResponse(w, http.StatusBadRequest,
-			fmt.Sprintf("failed to unmarshal user auth data %s : %s", string(userAuthDataByte), err.Error()))
+		o.writeErrorResponse(w, http.StatusBadRequest, err.Error())
 
 		return
 	}
 
-	queries := make([]compmodel.Query, 0)
-	queryMap := make(map[string]string)
-
-	for _, auths := range u.UserAuths {
-		queryID := uuid.NewString()
-
-		t := auths.AuthToken
-
-		query := &compmodel.AuthorizedQuery{AuthToken: &t}
-		query.SetID(queryID)
-
-		queries = append(queries, query)
-		queryMap[queryID] = auths.ID
-
-		logger.Infof("extractUserData: id=[%s] name=[%s] queryID=[%s] token=[%s]",
-			auths.ID, auths.Name, query.ID(), *query.AuthToken)
-	}
-
-	request := &compmodel.Extract{}
-	request.SetQueries(queries)
+	req.source = r.URL.Query().Get(sourceQueryParam)
 
-	extractRes, err := o.compClient.PostExtract(
-		compclientops.NewPostExtractParams().WithTimeout(requestTimeout).WithExtract(request),
-	)
+	page, err := queryIndex(o.userAuthStore, userTagName, req, userAuthIndex)
 	if err != nil {
 		o.writeErrorResponse(w, http.StatusInternalServerError,
-			fmt.Sprintf("failed to extract data: %s", err))
+			fmt.Sprintf("failed to get user auth data: %s", err.Error()))
 
 		return
 	}
 
-	if len(extractRes.Payload.Documents) != len(queries) {
+	userAuthData, err := o.fetchUserAuths(page.keys)
+	if err != nil {
 		o.writeErrorResponse(w, http.StatusInternalServerError,
-			fmt.Sprintf("count of documents %d doesnt match the expected %d",
-				len(extractRes.Payload.Documents), len(queries)))
+			fmt.Sprintf("failed to get user auth data: %s", err.Error()))
 
 		return
 	}
 
-	userNationalIDMap := make(map[string]string)
-
-	for _, k := range extractRes.Payload.Documents {
-		logger.Infof("extract: id=%s content=%v type=%T", k.ID, k.Contents, k.Contents)
-
-		nationalID, ok := k.Contents.(string)
-		if !ok {
-			o.writeErrorResponse(w, http.StatusInternalServerError, "invalid content; expected string type")
-
-			return
-		}
-
-		userNationalIDMap[queryMap[k.ID]] = nationalID
-	}
-
-	uData := make([]userExtractedData, 0)
+	eData := make([]extractData, 0)
 
-	for _, auths := range u.UserAuths {
-		uData = append(uData, userExtractedData{
-			ID:         auths.ID,
-			Name:       auths.Name,
-			DID:        auths.DID,
-			NationalID: userNationalIDMap[auths.ID],
+	for _, authData := range userAuthData {
+		eData = append(eData, extractData{
+			ID:            authData.ID,
+			Source:        authData.Source,
+			SubmittedTime: authData.SubmittedTime,
 		})
 	}
 
 	// send response
-	o.writeResponse(w, http.StatusOK, getExtractData{
-		Data: uData,
-	})
+	o.writeResponse(w, http.StatusOK, extractResp{ExtractData: eData, Total: page.total, NextCursor: page.next})
 }
 
-func (o *Operation) showDashboard(w http.ResponseWriter, userName, errMsg, vaultID string, serviceLinked bool) {
-	endpoint := fmt.Sprintf("/connect?userName=%s", userName)
+// showDashboard shows the dashboard of the user of the session. The disconnect form posts the CSRF token of the
+// session.
+func (o *Operation) showDashboard(w http.ResponseWriter, s *userSession, vaultID string, serviceLinked bool) {
+	endpoint := connect
 	if serviceLinked {
-		endpoint = fmt.Sprintf("/disconnect?userName=%s", userName)
+		endpoint = disconnect
 	}
 
-	if errMsg == "" {
-		o.loadHTML(w, o.dashboardHTML, map[string]interface{}{
-			"UserName":      userName,
-			"ServiceLinked": serviceLinked,
-			"URL":           endpoint,
-			"VaultID":       vaultID,
-			"ErrMsg":        "",
-		})
-	} else {
-		o.loadHTML(w, o.homePageHTML, map[string]interface{}{
-			"UserName":      userName,
-			"ServiceLinked": serviceLinked,
-			"URL":           endpoint,
-			"ErrMsg":        errMsg,
-		})
-	}
+	o.loadHTML(w, o.dashboardHTML, map[string]interface{}{
+		"UserName":      s.UserName,
+		"ServiceLinked": serviceLinked,
+		"URL":           endpoint,
+		"VaultID":       vaultID,
+		"CSRFToken":     s.CSRFToken,
+		"ErrMsg":        "",
+	})
+}
+
+func (o *Operation) showRegistrationError(w http.ResponseWriter, userName, errMsg string) {
+	o.loadHTML(w, o.homePageHTML, map[string]interface{}{
+		"UserName":      userName,
+		"ServiceLinked": false,
+		"URL":           connect,
+		"ErrMsg":        errMsg,
+	})
 }
 
 func (o *Operation) loadHTML(w http.ResponseWriter, htmlFileName string, data map[string]interface{}) {
@@ -1172,232 +1551,221 @@ func (o *Operation) getUserData(username string) (*userData, error) {
 	return uData, nil
 }
 
-func (o *Operation) getClientData(clientID string) (*clientData, error) {
-	cDataBytes, err := o.store.Get(clientID)
-	if err != nil {
-		return nil, fmt.Errorf("get client data: %w", err)
-	}
-
-	var cData *clientData
-
-	err = json.Unmarshal(cDataBytes, &cData)
-	if err != nil {
-		return nil, fmt.Errorf("unmarshal client data: %w", err)
-	}
+// fetchUsers returns the users with the ids, or the newest users if no ids are given.
+func (o *Operation) fetchUsers(ids ...string) ([]userData, error) {
+	if len(ids) == 0 {
+		page, err := queryIndex(o.userStore, userTagName,
+			&listRequest{pageRequest: &pageRequest{limit: defaultPageLimit}}, userIndex)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the newest users: %w", err)
+		}
 
-	return cData, nil
-}
+		if len(page.keys) == 0 {
+			return make([]userData, 0), nil
+		}
 
-func (o *Operation) getProfileData(profileID string) (*profileData, error) {
-	dataBytes, err := o.store.Get(profileID)
-	if err != nil {
-		return nil, fmt.Errorf("get profile data: %w", err)
+		ids = page.keys
 	}
 
-	var data *profileData
-
-	err = json.Unmarshal(dataBytes, &data)
+	userIDNames, err := o.userStore.GetBulk(ids...)
 	if err != nil {
-		return nil, fmt.Errorf("unamrshal profile data: %w", err)
+		return nil, fmt.Errorf("get all user data: %w", err)
 	}
 
-	return data, nil
-}
-
-func (o *Operation) fetchUsers(ids ...string) ([]userData, error) {
-	var usernamesToFetch []string
-
-	if len(ids) == 0 {
-		allUsernames, err := o.getAllUsernames()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get all usernames: %w", err)
-		}
+	usernamesToFetch := make([]string, 0)
 
-		usernamesToFetch = allUsernames
-	} else {
-		usernamesToFetch = make([]string, 0)
+	for _, v := range userIDNames {
+		var u *userIDNameMap
 
-		userData, err := o.userStore.GetBulk(ids...)
+		err = json.Unmarshal(v, &u)
 		if err != nil {
-			return nil, fmt.Errorf("get all user data: %w", err)
+			return nil, fmt.Errorf("unamrshal user data %s : %w", string(v), err)
 		}
 
-		for _, v := range userData {
-			var u *userIDNameMap
+		usernamesToFetch = append(usernamesToFetch, u.UserName)
+	}
 
-			err = json.Unmarshal(v, &u)
-			if err != nil {
-				return nil, fmt.Errorf("unamrshal user data %s : %w", string(v), err)
-			}
+	logger.Infof("usernamesToFetch=%s", usernamesToFetch)
 
-			usernamesToFetch = append(usernamesToFetch, u.UserName)
-		}
+	bulkData, err := o.store.GetBulk(usernamesToFetch...)
+	if err != nil {
+		return nil, fmt.Errorf("get user data keys=%s : %w", usernamesToFetch, err)
 	}
 
-	logger.Infof("usernamesToFetch=%s", usernamesToFetch)
+	users := make([]userData, len(bulkData))
+
+	for i, v := range bulkData {
+		var u userData
 
-	if len(usernamesToFetch) > 0 {
-		bulkData, err := o.store.GetBulk(usernamesToFetch...)
+		err = json.Unmarshal(v, &u)
 		if err != nil {
-			return nil, fmt.Errorf("get user data keys=%s : %w", usernamesToFetch, err)
+			return nil, fmt.Errorf("unamrshal user data %s: %w", string(v), err)
 		}
 
-		users := make([]userData, len(bulkData))
+		users[i] = u
+	}
 
-		for i, v := range bulkData {
-			var u userData
+	return users, nil
+}
 
-			err = json.Unmarshal(v, &u)
-			if err != nil {
-				return nil, fmt.Errorf("unamrshal user data %s: %w", string(v), err)
-			}
+func (o *Operation) saveUserAuthData(data *userAuthData) error {
+	authBytes, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("marshal user auth data: %w", err)
+	}
 
-			users[i] = u
-		}
+	tags := []storage.Tag{{Name: userTagName}, sourceTag(data.Source)}
 
-		return users, nil
+	if data.SubmittedTime != nil {
+		tags = append(tags, createdTag(data.SubmittedTime.Time))
 	}
 
-	return make([]userData, 0), nil
+	err = o.userAuthStore.Put(data.ID, authBytes, tags...)
+	if err != nil {
+		return fmt.Errorf("save user auth data: %w", err)
+	}
+
+	return nil
 }
 
-func (o *Operation) getAllUsernames() ([]string, error) {
-	userIDNames := make([]userIDNameMap, 0)
+func (o *Operation) fetchUserAuths(ids []string) ([]userAuthData, error) {
+	users := make([]userAuthData, 0, len(ids))
 
-	userDataIterator, err := o.userStore.Query("user")
-	if err != nil {
-		return nil, fmt.Errorf("get all user data: %w", err)
+	if len(ids) == 0 {
+		return users, nil
 	}
 
-	moreUserData, err := userDataIterator.Next()
+	bulkData, err := o.userAuthStore.GetBulk(ids...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get next user's data: %w", err)
+		return nil, fmt.Errorf("get user auth data: %w", err)
 	}
 
-	for moreUserData {
-		userDataValue, err := userDataIterator.Value()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get user data value: %w", err)
-		}
-
-		var u *userIDNameMap
+	for _, v := range bulkData {
+		var u *userAuthData
 
-		err = json.Unmarshal(userDataValue, &u)
+		err = json.Unmarshal(v, &u)
 		if err != nil {
-			return nil, fmt.Errorf("unamrshal user data %s : %w", string(userDataValue), err)
+			return nil, fmt.Errorf("unamrshal user auth data %s : %w", string(v), err)
 		}
 
-		userIDNames = append(userIDNames, *u)
+		users = append(users, *u)
+	}
 
-		moreUserData, err = userDataIterator.Next()
+	return users, nil
+}
+
+// indexLegacyItems tags the users, the user auths and the account links saved before they were tagged with their
+// index.
+func (o *Operation) indexLegacyItems() {
+	for _, s := range []struct {
+		store storage.Store
+		index indexFunc
+	}{
+		{o.userStore, userIndex},
+		{o.userAuthStore, userAuthIndex},
+		{o.linkStore, accountLinkIndex},
+	} {
+		err := indexLegacyItems(s.store, userTagName, s.index)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get next user's data: %w", err)
+			logger.Warnf("failed to index the legacy items: %s", err.Error())
 		}
 	}
+}
 
-	sort.Slice(userIDNames, func(i, j int) bool {
-		return userIDNames[i].CreatedTime.After(userIDNames[j].CreatedTime.Time)
-	})
+func userIndex(value []byte) (time.Time, string, error) {
+	var u *userIDNameMap
 
-	// TODO configure this limit value
-	if len(userIDNames) > resultLimit {
-		userIDNames = userIDNames[:resultLimit]
+	err := json.Unmarshal(value, &u)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("unamrshal user data %s : %w", string(value), err)
 	}
 
-	userNames := make([]string, 0)
-
-	for _, v := range userIDNames {
-		userNames = append(userNames, v.UserName)
+	if u.CreatedTime == nil {
+		return time.Time{}, "", nil
 	}
 
-	return userNames, nil
+	return u.CreatedTime.Time, "", nil
 }
 
-func (o *Operation) fetchUserAuths() ([]userAuthData, error) {
-	users := make([]userAuthData, 0)
+func userAuthIndex(value []byte) (time.Time, string, error) {
+	var u *userAuthData
 
-	userDataIterator, err := o.userAuthStore.Query("user")
+	err := json.Unmarshal(value, &u)
 	if err != nil {
-		return nil, fmt.Errorf("get all user auth data: %w", err)
+		return time.Time{}, "", fmt.Errorf("unamrshal user auth data %s : %w", string(value), err)
 	}
 
-	moreUserData, err := userDataIterator.Next()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get next user auth data: %w", err)
+	if u.SubmittedTime == nil {
+		return time.Time{}, u.Source, nil
 	}
 
-	for moreUserData {
-		userDataValue, err := userDataIterator.Value()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get user auth data value: %w", err)
-		}
-
-		var u *userAuthData
+	return u.SubmittedTime.Time, u.Source, nil
+}
 
-		err = json.Unmarshal(userDataValue, &u)
-		if err != nil {
-			return nil, fmt.Errorf("unamrshal user auth data %s : %w", string(userDataValue), err)
-		}
+// documentValues returns the values of the document types in the form by document type.
+func (o *Operation) documentValues(r *http.Request) (map[string]string, error) {
+	values := make(map[string]string)
 
-		users = append(users, *u)
-
-		moreUserData, err = userDataIterator.Next()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get next user's data: %w", err)
+	for _, t := range o.documentTypes {
+		if v := r.FormValue(t.Name); v != "" {
+			values[t.Name] = v
 		}
 	}
 
-	sort.Slice(users, func(i, j int) bool {
-		return users[i].SubmittedTime.After(users[j].SubmittedTime.Time)
-	})
-
-	// TODO configure this limit value
-	if len(users) > resultLimit {
-		users = users[:resultLimit]
+	if len(values) == 0 {
+		return nil, errors.New("at least one document is mandatory")
 	}
 
-	return users, nil
+	return values, nil
 }
 
-func (o *Operation) storeNationalID(id string) (string, string, error) {
-	vaultData, err := o.vClient.CreateVault()
-	if err != nil {
-		return "", "", fmt.Errorf("create vault : %w", err)
-	}
+// storeDocuments saves a vc in the vault of the user for each document value. It returns the ids of the documents
+// by document type.
+func (o *Operation) storeDocuments(ctx context.Context, vaultID string,
+	values map[string]string) (map[string]string, error) {
+	var err error
 
-	vaultID := vaultData.ID
+	// wrap the values in vcs
+	vcs := make(map[string]*verifiable.Credential)
 
-	// wrap nationalID in a vc
-	vc, err := o.createNationalIDCred(vaultID, id)
-	if err != nil {
-		return "", "", fmt.Errorf("create vc for nationalID : %w", err)
-	}
+	for i := range o.documentTypes {
+		t := &o.documentTypes[i]
 
-	// resolve DID
-	err = resolveDID(o.vdri, vaultID, 10)
-	if err != nil {
-		return "", "", fmt.Errorf("resolve did %s : %w", vaultID, err)
+		v, ok := values[t.Name]
+		if !ok {
+			continue
+		}
+
+		vcs[t.Name], err = o.createDocumentCred(vaultID, t, v)
+		if err != nil {
+			return nil, fmt.Errorf("create vc for %s : %w", t.Name, err)
+		}
 	}
 
-	// save nationalID vc
-	docID, err := o.saveNationalIDDoc(
-		vaultID,
-		vc,
-	)
+	// the vault DID can be resolved only after it's published
+	_, err = vdr.ResolveWithBackoff(ctx, o.vdri, vaultID, o.didBackoff)
 	if err != nil {
-		return "", "", fmt.Errorf("save nationalID doc : %w", err)
+		return nil, fmt.Errorf("resolve did %s : %w", vaultID, err)
 	}
 
-	logger.Infof("storeNationalID : vaultID=[%s] docID=[%s] content=[%s]", vaultID, docID, vc)
+	docs := make(map[string]string)
 
-	return vaultID, docID, nil
-}
+	for name, vc := range vcs {
+		docID, err := o.saveDoc(vaultID, vc)
+		if err != nil {
+			return nil, fmt.Errorf("save %s doc : %w", name, err)
+		}
 
-func (o *Operation) createNationalIDCred(sub, id string) (*verifiable.Credential, error) {
-	if id == "" {
-		return nil, errors.New("nationalID is mandatory")
+		logger.Infof("storeDocuments : vaultID=[%s] type=[%s] docID=[%s]", vaultID, name, docID)
+
+		docs[name] = docID
 	}
 
+	return docs, nil
+}
+
+func (o *Operation) createDocumentCred(sub string, docType *DocumentType,
+	value string) (*verifiable.Credential, error) {
 	cred := verifiable.Credential{}
 	cred.ID = uuid.New().URN()
 	cred.Context = []string{credentialContext}
@@ -1408,7 +1776,7 @@ func (o *Operation) createNationalIDCred(sub, id string) (*verifiable.Credential
 
 	credentialSubject := make(map[string]interface{})
 	credentialSubject["id"] = sub
-	credentialSubject[nationalID] = id
+	credentialSubject[docType.Attribute] = value
 
 	cred.Subject = credentialSubject
 
@@ -1441,7 +1809,7 @@ func (o *Operation) createNationalIDCred(sub, id string) (*verifiable.Credential
 	return vc, nil
 }
 
-func (o *Operation) saveNationalIDDoc(vaultID string, vc interface{}) (string, error) {
+func (o *Operation) saveDoc(vaultID string, vc interface{}) (string, error) {
 	docID, err := edvutils.GenerateEDVCompatibleID()
 	if err != nil {
 		return "", fmt.Errorf("create edv doc id : %w", err)
@@ -1469,40 +1837,25 @@ func (o *Operation) getComparatorConfig() (*compmodel.Config, error) {
 	return confResp.Payload, nil
 }
 
-func (o *Operation) getAuthorization(vaultID, rp, docID, authDID string) (string, error) {
-	logger.Infof("getAuthorization : vaultID=[%s] rp=[%s] docID=[%s] authDID=[%s]", vaultID, rp, docID, authDID)
+// getAuthorization gives the requesting party the authorization to compare the document, with the caveats of the
+// policy.
+func (o *Operation) getAuthorization(vaultID, rp, docID, attrPath, authDID string,
+	policy *authPolicy) (*docAuthorization, error) {
+	logger.Infof("getAuthorization : vaultID=[%s] rp=[%s] docID=[%s] attrPath=[%s] authDID=[%s]",
+		vaultID, rp, docID, attrPath, authDID)
 
-	docAuth, err := o.vClient.CreateAuthorization(
-		vaultID,
-		rp,
-		&vault.AuthorizationsScope{
-			Target:  docID,
-			Actions: []string{"read"},
-			Caveats: []vault.Caveat{{Type: zcapld.CaveatTypeExpiry, Duration: uint64(authExpiryTime * time.Second)}},
-		},
-	)
+	docAuth, err := o.vClient.CreateAuthorization(vaultID, rp, policy.vaultScope(docID))
 	if err != nil {
-		return "", fmt.Errorf("create vault authorization : %w", err)
+		return nil, fmt.Errorf("create vault authorization : %w", err)
 	}
 
 	if docAuth == nil || docAuth.Tokens == nil {
-		return "", errors.New("missing auth token from vault-server")
+		return nil, errors.New("missing auth token from vault-server")
 	}
 
 	logger.Infof("getAuthorization : edv=[%s] kms=[%s]", docAuth.Tokens.EDV, docAuth.Tokens.KMS)
 
-	scope := &compmodel.Scope{
-		Actions:     []string{"compare"},
-		VaultID:     vaultID,
-		DocID:       &docID,
-		AuthTokens:  &compmodel.ScopeAuthTokens{Edv: docAuth.Tokens.EDV, Kms: docAuth.Tokens.KMS},
-		DocAttrPath: nationalIDVCPath,
-	}
-
-	caveat := make([]compmodel.Caveat, 0)
-	caveat = append(caveat, &compmodel.ExpiryCaveat{Duration: int64(authExpiryTime * time.Second)})
-
-	scope.SetCaveats(caveat)
+	scope := policy.comparatorScope(vaultID, docID, attrPath, docAuth.Tokens)
 
 	authResp, err := o.compClient.PostAuthorizations(
 		compclientops.NewPostAuthorizationsParams().
@@ -1515,16 +1868,19 @@ func (o *Operation) getAuthorization(vaultID, rp, docID, authDID string) (string
 			),
 	)
 	if err != nil {
-		return "", fmt.Errorf("create comparator authorization : %w", err)
+		return nil, fmt.Errorf("create comparator authorization : %w", err)
 	}
 
 	if authResp == nil || authResp.Payload == nil {
-		return "", errors.New("missing auth token from comparator")
+		return nil, errors.New("missing auth token from comparator")
 	}
 
 	logger.Infof("getAuthorization : token=[%s]", authResp.Payload.AuthToken)
 
-	return authResp.Payload.AuthToken, nil
+	return &docAuthorization{
+		VaultAuthorizationID: docAuth.ID,
+		ComparatorAuthToken:  authResp.Payload.AuthToken,
+	}, nil
 }
 
 func (o *Operation) sendHTTPRequest(method, endpoint string, reqBody []byte, status int,
@@ -1540,6 +1896,10 @@ func (o *Operation) sendHTTPRequest(method, endpoint string, reqBody []byte, sta
 		req.Header.Add("Authorization", "Bearer "+httpToken)
 	}
 
+	return o.doHTTPRequest(req, status)
+}
+
+func (o *Operation) doHTTPRequest(req *http.Request, status int) ([]byte, error) {
 	resp, err := o.httpClient.Do(req)
 	if err != nil {
 		return nil, err
@@ -1593,25 +1953,12 @@ func clearCookies(w http.ResponseWriter) {
 	http.SetCookie(w, &cookie)
 }
 
-// nolint: interfacer
-func resolveDID(vdrRegistry vdrapi.Registry, did string, maxRetry int) error {
-	for i := 1; i <= maxRetry; i++ {
-		var err error
-		_, err = vdrRegistry.Resolve(did)
-
-		if err != nil {
-			if !strings.Contains(err.Error(), "DID does not exist") {
-				return err
-			}
+func didBackoff(config *Config) vdr.Backoff {
+	backoff := vdr.DefaultBackoff()
+	backoff.Retries = intOrDefault(config.DIDResolveRetries, backoff.Retries)
+	backoff.InitialDelay = durationOrDefault(config.DIDResolveDelay, backoff.InitialDelay)
 
-			fmt.Printf("did %s not found will retry %d of %d\n", did, i, maxRetry)
-			time.Sleep(1 * time.Second)
-
-			continue
-		}
-	}
-
-	return nil
+	return backoff
 }
 
 type storeProvider struct {
@@ -8,6 +8,7 @@ package operation
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -17,6 +18,7 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -43,10 +45,25 @@ const (
 
 func TestNew(t *testing.T) {
 	t.Run("success", func(t *testing.T) {
-		svc, err := New(&Config{StoreProvider: &mockstorage.Provider{}, ComparatorURL: "http://comp.example.com"})
+		svc, err := New(&Config{StoreProvider: newMockStoreProvider(), ComparatorURL: "http://comp.example.com"})
 		require.NoError(t, err)
 		require.NotNil(t, svc)
-		require.Equal(t, 19, len(svc.GetRESTHandlers()))
+		require.Equal(t, 43, len(svc.GetRESTHandlers()))
+	})
+
+	t.Run("token resolver without the token validation", func(t *testing.T) {
+		for _, config := range []*Config{
+			{TokenAudience: "ace-rp", TokenScopeRoles: map[string]Role{"ace:audit": RoleAuditor}},
+			{TokenIssuer: sampleTokenIssuer, TokenScopeRoles: map[string]Role{"ace:audit": RoleAuditor}},
+			{TokenIssuer: sampleTokenIssuer, TokenAudience: "ace-rp"},
+		} {
+			config.StoreProvider = mem.NewProvider()
+			config.ComparatorURL = "http://comp.example.com"
+			config.TokenResolver = &mockTokenResolver{}
+
+			_, err := New(config)
+			require.EqualError(t, err, "token resolver needs the token issuer, audience and scope roles")
+		}
 	})
 
 	t.Run("error", func(t *testing.T) {
@@ -60,12 +77,26 @@ func TestNew(t *testing.T) {
 
 	t.Run("empty comparator url", func(t *testing.T) {
 		svc, err := New(&Config{
-			StoreProvider: &mockstorage.Provider{},
+			StoreProvider: newMockStoreProvider(),
 		})
 		require.Error(t, err)
 		require.Contains(t, err.Error(), "comparator url mandatory")
 		require.Nil(t, svc)
 	})
+
+	t.Run("clients of the config", func(t *testing.T) {
+		vClient := &mockVaultClient{}
+		compClient := &mockComparatorClient{}
+
+		svc, err := New(&Config{
+			StoreProvider:    newMockStoreProvider(),
+			VaultClient:      vClient,
+			ComparatorClient: compClient,
+		})
+		require.NoError(t, err)
+		require.Equal(t, vClient, svc.vClient)
+		require.Equal(t, compClient, svc.compClient)
+	})
 }
 
 // nolint: bodyclose
@@ -96,6 +127,7 @@ func TestRegister(t *testing.T) {
 
 		req := &http.Request{Form: make(map[string][]string)}
 		req.Form.Add(username, sampleUserName)
+		req.Form.Add(password, samplePassword)
 		req.Form.Add(nationalID, sampleNationalID)
 
 		svc.register(rr, req)
@@ -128,6 +160,7 @@ func TestRegister(t *testing.T) {
 
 		req := &http.Request{Form: make(map[string][]string)}
 		req.Form.Add(username, sampleUserName)
+		req.Form.Add(password, samplePassword)
 
 		svc.register(rr, req)
 		require.Equal(t, http.StatusBadRequest, rr.Code)
@@ -135,9 +168,7 @@ func TestRegister(t *testing.T) {
 
 	t.Run("save user data error", func(t *testing.T) {
 		svc, err := New(&Config{
-			StoreProvider: &mockstorage.Provider{
-				OpenStoreReturn: &mockstorage.Store{ErrPut: errors.New("save error")},
-			},
+			StoreProvider:  mem.NewProvider(),
 			ComparatorURL:  "http://comp.example.com",
 			VDRI:           &vdrmock.MockVDRegistry{ResolveValue: &did.Doc{}},
 			DocumentLoader: createTestDocumentLoader(t),
@@ -145,6 +176,7 @@ func TestRegister(t *testing.T) {
 		require.NoError(t, err)
 		require.NotNil(t, svc)
 
+		svc.store = &mockstorage.Store{ErrGet: storage.ErrDataNotFound, ErrPut: errors.New("save error")}
 		svc.httpClient = &mockHTTPClient{
 			doFunc: mockHTTPResponse(t, nil, nil),
 		}
@@ -154,11 +186,133 @@ func TestRegister(t *testing.T) {
 
 		req := &http.Request{Form: make(map[string][]string)}
 		req.Form.Add(username, sampleUserName)
+		req.Form.Add(password, samplePassword)
 		req.Form.Add(nationalID, sampleNationalID)
 
 		svc.register(rr, req)
 		require.Equal(t, http.StatusInternalServerError, rr.Code)
 		require.Contains(t, rr.Body.String(), "unable to save user data")
+
+		// the username is released for a new registration
+		_, err = svc.credentialStore.Get(sampleUserName)
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+	})
+
+	t.Run("reserve username error", func(t *testing.T) {
+		svc, err := New(&Config{
+			StoreProvider: &mockstorage.Provider{
+				OpenStoreReturn: &mockstorage.Store{
+					ErrGet:      storage.ErrDataNotFound,
+					ErrPut:      errors.New("save error"),
+					QueryReturn: &mockstorage.Iterator{},
+				},
+			},
+			ComparatorURL: "http://comp.example.com",
+		})
+		require.NoError(t, err)
+		require.NotNil(t, svc)
+
+		rr := httptest.NewRecorder()
+
+		req := &http.Request{Form: make(map[string][]string)}
+		req.Form.Add(username, sampleUserName)
+		req.Form.Add(password, samplePassword)
+		req.Form.Add(nationalID, sampleNationalID)
+
+		svc.register(rr, req)
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "unable to reserve username")
+	})
+
+	t.Run("weak password", func(t *testing.T) {
+		file, err := ioutil.TempFile("", "*.html")
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, os.Remove(file.Name())) }()
+
+		svc, err := New(&Config{
+			StoreProvider: mem.NewProvider(),
+			HomePageHTML:  file.Name(),
+			ComparatorURL: "http://comp.example.com",
+		})
+		require.NoError(t, err)
+		require.NotNil(t, svc)
+
+		rr := httptest.NewRecorder()
+
+		req := &http.Request{Form: make(map[string][]string)}
+		req.Form.Add(username, sampleUserName)
+		req.Form.Add(password, "short")
+
+		svc.register(rr, req)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+
+		_, err = svc.credentialStore.Get(sampleUserName)
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+	})
+
+	t.Run("concurrent registrations of a username", func(t *testing.T) {
+		file, err := ioutil.TempFile("", "*.html")
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, os.Remove(file.Name())) }()
+
+		svc, err := New(&Config{
+			StoreProvider:  mem.NewProvider(),
+			DashboardHTML:  file.Name(),
+			HomePageHTML:   file.Name(),
+			RequestTokens:  map[string]string{vcsIssuerRequestTokenName: "test"},
+			ComparatorURL:  "http://comp.example.com",
+			VDRI:           &vdrmock.MockVDRegistry{ResolveValue: &did.Doc{}},
+			DocumentLoader: createTestDocumentLoader(t),
+		})
+		require.NoError(t, err)
+		require.NotNil(t, svc)
+
+		svc.httpClient = &mockHTTPClient{
+			doFunc: mockHTTPResponse(t, nil, nil),
+		}
+		svc.vClient = &mockVaultClient{}
+
+		const registrations = 5
+
+		codes := make(chan int, registrations)
+
+		var wg sync.WaitGroup
+
+		for i := 0; i < registrations; i++ {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				rr := httptest.NewRecorder()
+
+				req := &http.Request{Form: make(map[string][]string)}
+				req.Form.Add(username, sampleUserName)
+				req.Form.Add(password, samplePassword)
+				req.Form.Add(nationalID, sampleNationalID)
+
+				svc.register(rr, req)
+
+				codes <- rr.Code
+			}()
+		}
+
+		wg.Wait()
+		close(codes)
+
+		registered := 0
+
+		for code := range codes {
+			if code == http.StatusOK {
+				registered++
+			} else {
+				require.Equal(t, http.StatusBadRequest, code)
+			}
+		}
+
+		require.Equal(t, 1, registered)
 	})
 
 	t.Run("parse form error", func(t *testing.T) {
@@ -168,7 +322,7 @@ func TestRegister(t *testing.T) {
 		defer func() { require.NoError(t, os.Remove(file.Name())) }()
 
 		svc, err := New(&Config{
-			StoreProvider: &mockstorage.Provider{},
+			StoreProvider: newMockStoreProvider(),
 			DashboardHTML: file.Name(),
 			ComparatorURL: "http://comp.example.com",
 		})
@@ -201,6 +355,7 @@ func TestRegister(t *testing.T) {
 
 		req := &http.Request{Form: make(map[string][]string)}
 		req.Form.Add(username, sampleUserName)
+		req.Form.Add(password, samplePassword)
 		req.Form.Add(nationalID, sampleNationalID)
 
 		svc.register(rr, req)
@@ -227,13 +382,15 @@ func TestRegister(t *testing.T) {
 
 		req := &http.Request{Form: make(map[string][]string)}
 		req.Form.Add(username, sampleUserName)
+		req.Form.Add(password, samplePassword)
+		req.Form.Add(nationalID, sampleNationalID)
 
 		svc.register(rr, req)
 		require.Equal(t, http.StatusInternalServerError, rr.Code)
-		require.Contains(t, rr.Body.String(), "failed to store national id in vault  - err:create vault")
+		require.Contains(t, rr.Body.String(), "failed to store documents in vault - err:create vault")
 	})
 
-	t.Run("missing national id", func(t *testing.T) {
+	t.Run("missing documents", func(t *testing.T) {
 		file, err := ioutil.TempFile("", "*.html")
 		require.NoError(t, err)
 
@@ -256,10 +413,11 @@ func TestRegister(t *testing.T) {
 
 		req := &http.Request{Form: make(map[string][]string)}
 		req.Form.Add(username, sampleUserName)
+		req.Form.Add(password, samplePassword)
 
 		svc.register(rr, req)
-		require.Equal(t, http.StatusInternalServerError, rr.Code)
-		require.Contains(t, rr.Body.String(), "nationalID is mandatory")
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "at least one document is mandatory")
 	})
 
 	t.Run("failed to create vc", func(t *testing.T) {
@@ -285,6 +443,7 @@ func TestRegister(t *testing.T) {
 
 		req := &http.Request{Form: make(map[string][]string)}
 		req.Form.Add(username, sampleUserName)
+		req.Form.Add(password, samplePassword)
 		req.Form.Add(nationalID, sampleNationalID)
 
 		svc.register(rr, req)
@@ -312,6 +471,7 @@ func TestRegister(t *testing.T) {
 
 		req := &http.Request{Form: make(map[string][]string)}
 		req.Form.Add(username, sampleUserName)
+		req.Form.Add(password, samplePassword)
 		req.Form.Add(nationalID, sampleNationalID)
 
 		svc.register(rr, req)
@@ -327,23 +487,16 @@ func TestLogin(t *testing.T) {
 
 		defer func() { require.NoError(t, os.Remove(file.Name())) }()
 
-		uDataBytes, err := json.Marshal(&userData{})
-		require.NoError(t, err)
-
-		storeToReturnFromMockProvider, err := mem.NewProvider().OpenStore("mockstoretoreturn")
-		require.NoError(t, err)
-
-		err = storeToReturnFromMockProvider.Put(sampleUserName, uDataBytes)
-		require.NoError(t, err)
-
 		svc, err := New(&Config{
-			StoreProvider: &mockstorage.Provider{OpenStoreReturn: storeToReturnFromMockProvider},
+			StoreProvider: mem.NewProvider(),
 			DashboardHTML: file.Name(),
 			ComparatorURL: "http://comp.example.com",
 		})
 		require.NoError(t, err)
 		require.NotNil(t, svc)
 
+		saveTestUser(t, svc, &userData{})
+
 		rr := httptest.NewRecorder()
 
 		req := &http.Request{Form: make(map[string][]string), URL: &url.URL{}}
@@ -352,31 +505,102 @@ func TestLogin(t *testing.T) {
 
 		svc.login(rr, req)
 		require.Equal(t, http.StatusOK, rr.Code)
+
+		cookie := sessionCookieOf(t, rr)
+		require.True(t, cookie.HttpOnly)
+		require.True(t, cookie.Secure)
+		require.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+
+		sessionReq := httptest.NewRequest(http.MethodGet, dashboard, nil)
+		sessionReq.AddCookie(cookie)
+
+		s, err := svc.getSession(sessionReq)
+		require.NoError(t, err)
+		require.Equal(t, sampleUserName, s.UserName)
 	})
 
-	t.Run("success for linking mode", func(t *testing.T) {
+	t.Run("link state", func(t *testing.T) {
 		file, err := ioutil.TempFile("", "*.html")
 		require.NoError(t, err)
 
 		defer func() { require.NoError(t, os.Remove(file.Name())) }()
 
-		uDataBytes, err := json.Marshal(&userData{})
+		_, err = file.WriteString("linked={{.ServiceLinked}}")
 		require.NoError(t, err)
 
-		storeToReturnFromMockProvider, err := mem.NewProvider().OpenStore("mockstoretoreturn")
+		svc, err := New(&Config{
+			StoreProvider: mem.NewProvider(),
+			DashboardHTML: file.Name(),
+			ComparatorURL: "http://comp.example.com",
+		})
+		require.NoError(t, err)
+		require.NotNil(t, svc)
+
+		saveTestUser(t, svc, &userData{ID: "U1"})
+
+		loginUser := func() string {
+			rr := httptest.NewRecorder()
+
+			req := &http.Request{Form: make(map[string][]string), URL: &url.URL{}}
+			req.Form.Add(username, sampleUserName)
+			req.Form.Add(password, samplePassword)
+
+			svc.login(rr, req)
+			require.Equal(t, http.StatusOK, rr.Code)
+
+			return rr.Body.String()
+		}
+
+		require.Equal(t, "linked=false", loginUser())
+
+		result := false
+		require.NoError(t, svc.saveAccountLink(&accountLink{UserID: "U1", State: "1", ComparisonResult: &result}))
+		require.Equal(t, "linked=false", loginUser())
+
+		result = true
+		require.NoError(t, svc.saveAccountLink(&accountLink{UserID: "U1", State: "2", ComparisonResult: &result}))
+		require.Equal(t, "linked=true", loginUser())
+	})
+
+	t.Run("link store error", func(t *testing.T) {
+		svc, err := New(&Config{
+			StoreProvider: mem.NewProvider(),
+			ComparatorURL: "http://comp.example.com",
+		})
 		require.NoError(t, err)
+		require.NotNil(t, svc)
 
-		err = storeToReturnFromMockProvider.Put(sampleUserName, uDataBytes)
+		saveTestUser(t, svc, &userData{})
+
+		svc.linkStore = &mockstorage.Store{ErrQuery: errors.New("query error")}
+
+		rr := httptest.NewRecorder()
+
+		req := &http.Request{Form: make(map[string][]string), URL: &url.URL{}}
+		req.Form.Add(username, sampleUserName)
+		req.Form.Add(password, samplePassword)
+
+		svc.login(rr, req)
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to get account links of user")
+	})
+
+	t.Run("success for linking mode", func(t *testing.T) {
+		file, err := ioutil.TempFile("", "*.html")
 		require.NoError(t, err)
 
+		defer func() { require.NoError(t, os.Remove(file.Name())) }()
+
 		svc, err := New(&Config{
-			StoreProvider: &mockstorage.Provider{OpenStoreReturn: storeToReturnFromMockProvider},
+			StoreProvider: mem.NewProvider(),
 			ConsentHTML:   file.Name(),
 			ComparatorURL: "http://comp.example.com",
 		})
 		require.NoError(t, err)
 		require.NotNil(t, svc)
 
+		saveTestUser(t, svc, &userData{})
+
 		rr := httptest.NewRecorder()
 
 		req := &http.Request{
@@ -392,7 +616,7 @@ func TestLogin(t *testing.T) {
 
 	t.Run("parse form error", func(t *testing.T) {
 		svc, err := New(&Config{
-			StoreProvider: &mockstorage.Provider{},
+			StoreProvider: newMockStoreProvider(),
 			ComparatorURL: "http://comp.example.com",
 		})
 		require.NoError(t, err)
@@ -426,113 +650,413 @@ func TestLogin(t *testing.T) {
 		req.Form.Add(password, samplePassword)
 
 		svc.login(rr, req)
-		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+		require.Contains(t, rr.Body.String(), "invalid username or password")
 	})
 
-	t.Run("invalid data in db", func(t *testing.T) {
-		s := make(map[string][]byte)
-		s[sampleUserName] = []byte("invalid-json-data")
+	t.Run("invalid password", func(t *testing.T) {
+		svc, err := New(&Config{
+			StoreProvider: mem.NewProvider(),
+			ComparatorURL: "http://comp.example.com",
+		})
+		require.NoError(t, err)
+		require.NotNil(t, svc)
 
-		storeToReturnFromMockProvider, err := mem.NewProvider().OpenStore("mockstoretoreturn")
+		saveTestUser(t, svc, &userData{})
+
+		rr := httptest.NewRecorder()
+
+		req := &http.Request{Form: make(map[string][]string)}
+		req.Form.Add(username, sampleUserName)
+		req.Form.Add(password, "wrong-password")
+
+		svc.login(rr, req)
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+		require.Contains(t, rr.Body.String(), "invalid username or password")
+	})
+
+	t.Run("account is locked after failed logins", func(t *testing.T) {
+		file, err := ioutil.TempFile("", "*.html")
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, os.Remove(file.Name())) }()
+
+		svc, err := New(&Config{
+			StoreProvider:    mem.NewProvider(),
+			DashboardHTML:    file.Name(),
+			ComparatorURL:    "http://comp.example.com",
+			MaxLoginAttempts: 3,
+			LockoutDuration:  time.Hour,
+		})
 		require.NoError(t, err)
+		require.NotNil(t, svc)
+
+		saveTestUser(t, svc, &userData{})
+
+		login := func(pwd string) *httptest.ResponseRecorder {
+			rr := httptest.NewRecorder()
+
+			req := &http.Request{Form: make(map[string][]string), URL: &url.URL{}}
+			req.Form.Add(username, sampleUserName)
+			req.Form.Add(password, pwd)
 
-		err = storeToReturnFromMockProvider.Put(sampleUserName, []byte("invalid-json-data"))
+			svc.login(rr, req)
+
+			return rr
+		}
+
+		// a successful login resets the failed logins
+		require.Equal(t, http.StatusUnauthorized, login("wrong-password").Code)
+		require.Equal(t, http.StatusOK, login(samplePassword).Code)
+
+		for i := 0; i < 3; i++ {
+			require.Equal(t, http.StatusUnauthorized, login("wrong-password").Code)
+		}
+
+		rr := login(samplePassword)
+		require.Equal(t, http.StatusForbidden, rr.Code)
+		require.Contains(t, rr.Body.String(), "account is locked")
+
+		cred, err := svc.getUserCredential(sampleUserName)
 		require.NoError(t, err)
+		require.WithinDuration(t, time.Now().Add(time.Hour), cred.LockedUntil, time.Minute)
+
+		cred.LockedUntil = time.Now().Add(-time.Second)
+		require.NoError(t, svc.saveUserCredential(sampleUserName, cred))
 
+		require.Equal(t, http.StatusOK, login(samplePassword).Code)
+	})
+
+	t.Run("invalid data in db", func(t *testing.T) {
 		svc, err := New(&Config{
-			StoreProvider: &mockstorage.Provider{OpenStoreReturn: storeToReturnFromMockProvider},
+			StoreProvider: mem.NewProvider(),
 			ComparatorURL: "http://comp.example.com",
 		})
 		require.NoError(t, err)
 		require.NotNil(t, svc)
 
+		saveTestUser(t, svc, &userData{})
+
+		err = svc.store.Put(sampleUserName, []byte("invalid-json-data"))
+		require.NoError(t, err)
+
 		rr := httptest.NewRecorder()
 
 		req := &http.Request{Form: make(map[string][]string)}
 		req.Form.Add(username, sampleUserName)
-		req.Form.Add(password, sampleUserName)
+		req.Form.Add(password, samplePassword)
 
 		svc.login(rr, req)
 		require.Equal(t, http.StatusInternalServerError, rr.Code)
 		require.Contains(t, rr.Body.String(), "unmarshal user data")
 	})
 
-	t.Run("db error", func(t *testing.T) {
-		uDataBytes, err := json.Marshal(&userData{})
-		require.NoError(t, err)
-
+	t.Run("credential db error", func(t *testing.T) {
 		svc, err := New(&Config{
-			StoreProvider: &mockstorage.Provider{OpenStoreReturn: &mockstorage.Store{
-				GetReturn: uDataBytes,
-				ErrPut:    errors.New("db error"),
-			}},
+			StoreProvider: mem.NewProvider(),
 			ComparatorURL: "http://comp.example.com",
 		})
 		require.NoError(t, err)
 		require.NotNil(t, svc)
 
+		svc.credentialStore = &mockstorage.Store{ErrGet: errors.New("db error")}
+
 		rr := httptest.NewRecorder()
 
-		req := &http.Request{Form: make(map[string][]string), URL: &url.URL{}}
+		req := &http.Request{Form: make(map[string][]string)}
 		req.Form.Add(username, sampleUserName)
 		req.Form.Add(password, samplePassword)
 
 		svc.login(rr, req)
 		require.Equal(t, http.StatusInternalServerError, rr.Code)
-		require.Contains(t, rr.Body.String(), "failed to save session")
+		require.Contains(t, rr.Body.String(), "unable to check password of user")
 	})
-}
 
-func TestLogout(t *testing.T) {
-	t.Run("success", func(t *testing.T) {
-		file, err := ioutil.TempFile("", "*.html")
+	t.Run("db error", func(t *testing.T) {
+		uDataBytes, err := json.Marshal(&userData{NationalIDDocID: "doc1"})
 		require.NoError(t, err)
 
-		defer func() { require.NoError(t, os.Remove(file.Name())) }()
-
 		svc, err := New(&Config{
-			StoreProvider: &mockstorage.Provider{},
-			HomePageHTML:  file.Name(),
+			StoreProvider: mem.NewProvider(),
 			ComparatorURL: "http://comp.example.com",
 		})
 		require.NoError(t, err)
 		require.NotNil(t, svc)
 
+		saveTestUser(t, svc, &userData{})
+
+		svc.store = &mockstorage.Store{GetReturn: uDataBytes}
+		svc.sessionStore = &mockstorage.Store{QueryReturn: &mockstorage.Iterator{}, ErrPut: errors.New("db error")}
+
 		rr := httptest.NewRecorder()
 
-		req := &http.Request{}
+		req := &http.Request{Form: make(map[string][]string), URL: &url.URL{}}
+		req.Form.Add(username, sampleUserName)
+		req.Form.Add(password, samplePassword)
 
-		svc.logout(rr, req)
-		require.Equal(t, http.StatusOK, rr.Code)
+		svc.login(rr, req)
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to start session : save session : db error")
 	})
 }
 
-func TestConnect(t *testing.T) {
-	t.Run("success", func(t *testing.T) {
-		profileID := uuid.New().String()
+func TestChangePassword(t *testing.T) {
+	newRequest := func(pwd, newPwd string) *http.Request {
+		req := &http.Request{Form: make(map[string][]string)}
+		req.Form.Add(username, sampleUserName)
+		req.Form.Add(password, pwd)
+		req.Form.Add(newPassword, newPwd)
 
-		memProvider := mem.NewProvider()
+		return req
+	}
 
+	t.Run("success", func(t *testing.T) {
 		svc, err := New(&Config{
-			StoreProvider:      memProvider,
-			HostExternalURL:    "http://my-external",
-			AccountLinkProfile: profileID,
-			ComparatorURL:      "http://comp.example.com",
+			StoreProvider: mem.NewProvider(),
+			ComparatorURL: "http://comp.example.com",
 		})
 		require.NoError(t, err)
-		require.NotNil(t, svc)
-
-		dBytes, err := json.Marshal(&profileData{})
-		require.NoError(t, err)
 
-		userStore, err := memProvider.OpenStore(txnStoreName)
-		require.NoError(t, err)
+		saveTestUser(t, svc, &userData{})
 
-		err = userStore.Put(profileID, dBytes)
-		require.NoError(t, err)
+		otherReq := newSessionRequest(t, svc, http.MethodGet, dashboard, sampleUserName)
+		currentReq := newSessionRequest(t, svc, http.MethodPost, changePassword, sampleUserName)
 
-		req, err := http.NewRequest("GET", "/connect?userName="+sampleUserName, nil)
-		require.NoError(t, err)
+		req := newRequest(samplePassword, "n3w-pa$$word")
+		req.Header = currentReq.Header
+
+		rr := httptest.NewRecorder()
+		svc.changePassword(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		require.ErrorIs(t, svc.checkPassword(sampleUserName, samplePassword), errInvalidCredentials)
+		require.NoError(t, svc.checkPassword(sampleUserName, "n3w-pa$$word"))
+
+		// the other sessions are invalidated, and the current one is rotated
+		_, err = svc.getSession(otherReq)
+		require.ErrorIs(t, err, errNoSession)
+
+		_, err = svc.getSession(currentReq)
+		require.ErrorIs(t, err, errNoSession)
+
+		rotatedReq := httptest.NewRequest(http.MethodGet, dashboard, nil)
+		rotatedReq.AddCookie(sessionCookieOf(t, rr))
+
+		s, err := svc.getSession(rotatedReq)
+		require.NoError(t, err)
+		require.Equal(t, sampleUserName, s.UserName)
+	})
+
+	t.Run("invalid current password", func(t *testing.T) {
+		svc, err := New(&Config{
+			StoreProvider: mem.NewProvider(),
+			ComparatorURL: "http://comp.example.com",
+		})
+		require.NoError(t, err)
+
+		saveTestUser(t, svc, &userData{})
+
+		rr := httptest.NewRecorder()
+		svc.changePassword(rr, newRequest("wrong-password", "n3w-pa$$word"))
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("weak new password", func(t *testing.T) {
+		svc, err := New(&Config{
+			StoreProvider: mem.NewProvider(),
+			ComparatorURL: "http://comp.example.com",
+		})
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		svc.changePassword(rr, newRequest(samplePassword, "short"))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "password must have at least")
+	})
+
+	t.Run("parse form error", func(t *testing.T) {
+		svc, err := New(&Config{
+			StoreProvider: mem.NewProvider(),
+			ComparatorURL: "http://comp.example.com",
+		})
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		svc.changePassword(rr, &http.Request{Method: http.MethodPost})
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "unable to parse form data")
+	})
+}
+
+func saveTestUser(t *testing.T, svc *Operation, uData *userData) {
+	t.Helper()
+
+	uDataBytes, err := json.Marshal(uData)
+	require.NoError(t, err)
+
+	require.NoError(t, svc.store.Put(sampleUserName, uDataBytes))
+
+	cred, err := newUserCredential(samplePassword)
+	require.NoError(t, err)
+
+	require.NoError(t, svc.saveUserCredential(sampleUserName, cred))
+}
+
+func TestLogout(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		file, err := ioutil.TempFile("", "*.html")
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, os.Remove(file.Name())) }()
+
+		svc, err := New(&Config{
+			StoreProvider: newMockStoreProvider(),
+			HomePageHTML:  file.Name(),
+			ComparatorURL: "http://comp.example.com",
+		})
+		require.NoError(t, err)
+		require.NotNil(t, svc)
+
+		rr := httptest.NewRecorder()
+
+		req := &http.Request{}
+
+		svc.logout(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("session is invalidated", func(t *testing.T) {
+		file, err := ioutil.TempFile("", "*.html")
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, os.Remove(file.Name())) }()
+
+		svc, err := New(&Config{
+			StoreProvider: mem.NewProvider(),
+			HomePageHTML:  file.Name(),
+			ComparatorURL: "http://comp.example.com",
+		})
+		require.NoError(t, err)
+
+		req := newSessionRequest(t, svc, http.MethodGet, logout, sampleUserName)
+
+		rr := httptest.NewRecorder()
+		svc.logout(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Equal(t, -1, sessionCookieOf(t, rr).MaxAge)
+
+		_, err = svc.getSession(req)
+		require.ErrorIs(t, err, errNoSession)
+	})
+}
+
+func TestShowUserDashboard(t *testing.T) {
+	file, err := ioutil.TempFile("", "*.html")
+	require.NoError(t, err)
+
+	defer func() { require.NoError(t, os.Remove(file.Name())) }()
+
+	_, err = file.WriteString("{{.UserName}} linked={{.ServiceLinked}} url={{.URL}}")
+	require.NoError(t, err)
+
+	t.Run("success", func(t *testing.T) {
+		svc := newTestOperation(t, withUserData(testUserData()), withDashboardHTML(file.Name()))
+
+		rr := httptest.NewRecorder()
+		svc.showUserDashboard(rr, newSessionRequest(t, svc, http.MethodGet, dashboard, sampleUserName))
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Equal(t, sampleUserName+" linked=false url="+connect, rr.Body.String())
+
+		result := true
+		require.NoError(t, svc.saveAccountLink(&accountLink{UserID: "U1", State: "1", ComparisonResult: &result}))
+
+		rr = httptest.NewRecorder()
+		svc.showUserDashboard(rr, newSessionRequest(t, svc, http.MethodGet, dashboard, sampleUserName))
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Equal(t, sampleUserName+" linked=true url="+disconnect, rr.Body.String())
+	})
+
+	t.Run("no session", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		rr := httptest.NewRecorder()
+		svc.showUserDashboard(rr, httptest.NewRequest(http.MethodGet, dashboard+"?userName="+sampleUserName, nil))
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+		require.Contains(t, rr.Body.String(), "no session")
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		rr := httptest.NewRecorder()
+		svc.showUserDashboard(rr, newSessionRequest(t, svc, http.MethodGet, dashboard, "invalid"))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "unable to get user data invalid")
+	})
+
+	t.Run("link store error", func(t *testing.T) {
+		svc := newTestOperation(t, withUserData(testUserData()))
+
+		svc.linkStore = &mockstorage.Store{ErrQuery: errors.New("query error")}
+
+		rr := httptest.NewRecorder()
+		svc.showUserDashboard(rr, newSessionRequest(t, svc, http.MethodGet, dashboard, sampleUserName))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to get account links")
+	})
+}
+
+func TestConnect(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		profileID := uuid.New().String()
+
+		memProvider := mem.NewProvider()
+
+		svc, err := New(&Config{
+			StoreProvider:      memProvider,
+			HostExternalURL:    "http://my-external",
+			AccountLinkProfile: profileID,
+			ComparatorURL:      "http://comp.example.com",
+		})
+		require.NoError(t, err)
+		require.NotNil(t, svc)
+
+		require.NoError(t, svc.saveProfile(&profileReq{
+			profileData:  profileData{ID: profileID, URL: "http://third-party-svc", ClientID: "client1"},
+			ClientSecret: "secret",
+		}))
+
+		requestURI := uuid.New().String()
+		state := ""
+
+		svc.httpClient = &mockHTTPClient{
+			doFunc: func(req *http.Request) (*http.Response, error) {
+				require.Equal(t, http.MethodPost, req.Method)
+				require.Equal(t, "http://third-party-svc/link", req.URL.String())
+
+				clientID, secret, ok := req.BasicAuth()
+				require.True(t, ok)
+				require.Equal(t, "client1", clientID)
+				require.Equal(t, "secret", secret)
+
+				require.NoError(t, req.ParseForm())
+				require.Equal(t, svc.hostExternalURL+"/callback", req.PostForm.Get("callback"))
+				require.Equal(t, nationalID, req.PostForm.Get(docTypeParam))
+
+				state = req.PostForm.Get("state")
+
+				respBytes, err := json.Marshal(&linkRequestResp{RequestURI: requestURI})
+				require.NoError(t, err)
+
+				return &http.Response{
+					StatusCode: http.StatusCreated,
+					Body:       ioutil.NopCloser(bytes.NewReader(respBytes)),
+				}, nil
+			},
+		}
+
+		// the user of the session is linked, not the user of the query
+		req := newSessionRequest(t, svc, http.MethodGet, connect+"?userName=other", sampleUserName)
 
 		rr := httptest.NewRecorder()
 
@@ -543,13 +1067,65 @@ func TestConnect(t *testing.T) {
 		require.NoError(t, err)
 
 		require.Equal(t, ep.Path, "/link")
-		require.Equal(t, ep.Query().Get("callback"), svc.hostExternalURL+"/callback")
-		require.NotEmpty(t, ep.Query().Get("state"))
+		require.Equal(t, "client1", ep.Query().Get("client_id"))
+		require.Equal(t, requestURI, ep.Query().Get("request_uri"))
+
+		stateBytes, err := svc.store.Get(connectStateKeyPrefix + state)
+		require.NoError(t, err)
+		require.Equal(t, sampleUserName, parseConnectState(stateBytes).UserName)
 	})
 
-	t.Run("no username", func(t *testing.T) {
+	t.Run("link request error", func(t *testing.T) {
+		profileID := uuid.New().String()
+
 		svc, err := New(&Config{
-			StoreProvider: &mockstorage.Provider{},
+			StoreProvider:      mem.NewProvider(),
+			HostExternalURL:    "http://my-external",
+			AccountLinkProfile: profileID,
+			ComparatorURL:      "http://comp.example.com",
+		})
+		require.NoError(t, err)
+		require.NotNil(t, svc)
+
+		require.NoError(t, svc.saveProfileData(&profileData{ID: profileID, URL: "http://third-party-svc"}))
+
+		req := newSessionRequest(t, svc, http.MethodGet, connect, sampleUserName)
+
+		svc.httpClient = &mockHTTPClient{respErr: errors.New("http error")}
+
+		rr := httptest.NewRecorder()
+
+		svc.connect(rr, req)
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to request account link : http error")
+
+		svc.httpClient = &mockHTTPClient{respValue: &http.Response{
+			StatusCode: http.StatusUnauthorized,
+			Status:     "401 Unauthorized",
+			Body:       ioutil.NopCloser(bytes.NewReader([]byte("invalid client credentials"))),
+		}}
+
+		rr = httptest.NewRecorder()
+
+		svc.connect(rr, req)
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "401 Unauthorized: invalid client credentials")
+
+		svc.httpClient = &mockHTTPClient{respValue: &http.Response{
+			StatusCode: http.StatusCreated,
+			Body:       ioutil.NopCloser(bytes.NewReader([]byte("invalid-json"))),
+		}}
+
+		rr = httptest.NewRecorder()
+
+		svc.connect(rr, req)
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "unmarshal link request response")
+	})
+
+	t.Run("no session", func(t *testing.T) {
+		svc, err := New(&Config{
+			StoreProvider: newMockStoreProvider(),
 			ComparatorURL: "http://comp.example.com",
 		})
 		require.NoError(t, err)
@@ -557,12 +1133,12 @@ func TestConnect(t *testing.T) {
 
 		rr := httptest.NewRecorder()
 
-		req, err := http.NewRequest("GET", "/connect", nil)
+		req, err := http.NewRequest("GET", "/connect?userName="+sampleUserName, nil)
 		require.NoError(t, err)
 
 		svc.connect(rr, req)
-		require.Equal(t, http.StatusBadRequest, rr.Code)
-		require.Contains(t, rr.Body.String(), "missing username")
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+		require.Contains(t, rr.Body.String(), "no session")
 	})
 
 	t.Run("data error", func(t *testing.T) {
@@ -579,8 +1155,7 @@ func TestConnect(t *testing.T) {
 		require.NoError(t, err)
 		require.NotNil(t, svc)
 
-		req, err := http.NewRequest("GET", "/connect?userName="+sampleUserName, nil)
-		require.NoError(t, err)
+		req := newSessionRequest(t, svc, http.MethodGet, connect, sampleUserName)
 
 		rr := httptest.NewRecorder()
 
@@ -588,10 +1163,10 @@ func TestConnect(t *testing.T) {
 		require.Equal(t, http.StatusInternalServerError, rr.Code)
 		require.Contains(t, rr.Body.String(), "failed to get profile data")
 
-		userStore, err := memProvider.OpenStore(txnStoreName)
+		profileStore, err := memProvider.OpenStore(profileStoreName)
 		require.NoError(t, err)
 
-		err = userStore.Put(profileID, []byte("invalid-json"))
+		err = profileStore.Put(profileID, []byte("invalid-json"))
 		require.NoError(t, err)
 
 		rr = httptest.NewRecorder()
@@ -603,16 +1178,26 @@ func TestConnect(t *testing.T) {
 }
 
 func TestAccountLink(t *testing.T) {
+	newLinkRequest := func(t *testing.T, svc *Operation, req *linkRequest) string {
+		t.Helper()
+
+		reqBytes, err := json.Marshal(req)
+		require.NoError(t, err)
+
+		requestURI := uuid.New().String()
+		require.NoError(t, svc.store.Put(linkRequestKeyPrefix+requestURI, reqBytes))
+
+		return requestURI
+	}
+
 	t.Run("success", func(t *testing.T) {
 		file, err := ioutil.TempFile("", "*.html")
 		require.NoError(t, err)
 
 		defer func() { require.NoError(t, os.Remove(file.Name())) }()
 
-		memProvider := mem.NewProvider()
-
 		svc, err := New(&Config{
-			StoreProvider:      memProvider,
+			StoreProvider:      mem.NewProvider(),
 			HostExternalURL:    "http://my-external",
 			AccountLinkProfile: "http://third-party-svc",
 			ComparatorURL:      "http://comp.example.com",
@@ -622,17 +1207,16 @@ func TestAccountLink(t *testing.T) {
 		require.NotNil(t, svc)
 
 		cID := uuid.New().String()
-		cIDBytes, err := json.Marshal(&clientData{})
-		require.NoError(t, err)
-
-		txnStore, err := memProvider.OpenStore(txnStoreName)
-		require.NoError(t, err)
+		require.NoError(t, svc.saveClientData(&clientData{ClientID: cID, DID: "did:example:123"}))
 
-		err = txnStore.Put(cID, cIDBytes)
-		require.NoError(t, err)
+		requestURI := newLinkRequest(t, svc, &linkRequest{
+			ClientID:    cID,
+			State:       uuid.New().String(),
+			CallbackURL: "http://client/callback",
+			ExpiresAt:   time.Now().Add(time.Minute),
+		})
 
-		state := uuid.New().String()
-		endpoint := fmt.Sprintf(accountLinkURLFormat, svc.accountLinkProfile, cID, svc.hostExternalURL, state)
+		endpoint := fmt.Sprintf(accountLinkURLFormat, svc.accountLinkProfile, cID, requestURI)
 
 		req, err := http.NewRequest("GET", endpoint, nil)
 		require.NoError(t, err)
@@ -641,6 +1225,13 @@ func TestAccountLink(t *testing.T) {
 
 		svc.link(rr, req)
 		require.Equal(t, http.StatusOK, rr.Code)
+
+		// the request can be used only once
+		rr = httptest.NewRecorder()
+
+		svc.link(rr, req)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "invalid link request: get link request")
 	})
 
 	t.Run("no clientID", func(t *testing.T) {
@@ -662,7 +1253,7 @@ func TestAccountLink(t *testing.T) {
 		require.Contains(t, rr.Body.String(), "missing client_id")
 	})
 
-	t.Run("no callback url", func(t *testing.T) {
+	t.Run("no request uri", func(t *testing.T) {
 		svc, err := New(&Config{
 			StoreProvider:      mem.NewProvider(),
 			AccountLinkProfile: "http://third-party-svc",
@@ -678,42 +1269,76 @@ func TestAccountLink(t *testing.T) {
 
 		svc.link(rr, req)
 		require.Equal(t, http.StatusBadRequest, rr.Code)
-		require.Contains(t, rr.Body.String(), "missing callback url")
+		require.Contains(t, rr.Body.String(), "missing request_uri")
 	})
 
-	t.Run("no state", func(t *testing.T) {
+	t.Run("client mismatch", func(t *testing.T) {
 		svc, err := New(&Config{
 			StoreProvider:      mem.NewProvider(),
 			AccountLinkProfile: "http://third-party-svc",
-			HostExternalURL:    "http://my-external",
 			ComparatorURL:      "http://comp.example.com",
 		})
 		require.NoError(t, err)
 		require.NotNil(t, svc)
 
+		requestURI := newLinkRequest(t, svc, &linkRequest{
+			ClientID:  uuid.New().String(),
+			ExpiresAt: time.Now().Add(time.Minute),
+		})
+
+		endpoint := fmt.Sprintf(accountLinkURLFormat, svc.accountLinkProfile, uuid.New().String(), requestURI)
+
+		req, err := http.NewRequest("GET", endpoint, nil)
+		require.NoError(t, err)
+
 		rr := httptest.NewRecorder()
 
-		req, err := http.NewRequest("GET",
-			svc.accountLinkProfile+"/link?callback="+svc.hostExternalURL+"&client_id="+uuid.New().String(), nil)
+		svc.link(rr, req)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "client_id mismatch")
+	})
+
+	t.Run("expired request", func(t *testing.T) {
+		svc, err := New(&Config{
+			StoreProvider:      mem.NewProvider(),
+			AccountLinkProfile: "http://third-party-svc",
+			ComparatorURL:      "http://comp.example.com",
+		})
+		require.NoError(t, err)
+		require.NotNil(t, svc)
+
+		cID := uuid.New().String()
+
+		requestURI := newLinkRequest(t, svc, &linkRequest{
+			ClientID:  cID,
+			ExpiresAt: time.Now().Add(-time.Minute),
+		})
+
+		endpoint := fmt.Sprintf(accountLinkURLFormat, svc.accountLinkProfile, cID, requestURI)
+
+		req, err := http.NewRequest("GET", endpoint, nil)
 		require.NoError(t, err)
 
+		rr := httptest.NewRecorder()
+
 		svc.link(rr, req)
 		require.Equal(t, http.StatusBadRequest, rr.Code)
-		require.Contains(t, rr.Body.String(), "missing state")
+		require.Contains(t, rr.Body.String(), "expired link request")
 	})
 
-	t.Run("client not found", func(t *testing.T) {
+	t.Run("invalid request data", func(t *testing.T) {
 		svc, err := New(&Config{
 			StoreProvider:      mem.NewProvider(),
-			HostExternalURL:    "http://my-external",
 			AccountLinkProfile: "http://third-party-svc",
 			ComparatorURL:      "http://comp.example.com",
 		})
 		require.NoError(t, err)
 		require.NotNil(t, svc)
 
-		state := uuid.New().String()
-		endpoint := fmt.Sprintf(accountLinkURLFormat, svc.accountLinkProfile, uuid.New().String(), svc.hostExternalURL, state)
+		requestURI := uuid.New().String()
+		require.NoError(t, svc.store.Put(linkRequestKeyPrefix+requestURI, []byte("invalid-json")))
+
+		endpoint := fmt.Sprintf(accountLinkURLFormat, svc.accountLinkProfile, uuid.New().String(), requestURI)
 
 		req, err := http.NewRequest("GET", endpoint, nil)
 		require.NoError(t, err)
@@ -722,14 +1347,12 @@ func TestAccountLink(t *testing.T) {
 
 		svc.link(rr, req)
 		require.Equal(t, http.StatusBadRequest, rr.Code)
-		require.Contains(t, rr.Body.String(), "get client data")
+		require.Contains(t, rr.Body.String(), "unmarshal link request")
 	})
 
-	t.Run("invalid client data", func(t *testing.T) {
+	t.Run("client not found", func(t *testing.T) {
 		svc, err := New(&Config{
-			StoreProvider: &mockstorage.Provider{
-				OpenStoreReturn: &mockstorage.Store{ErrPut: errors.New("store error")},
-			},
+			StoreProvider:      mem.NewProvider(),
 			HostExternalURL:    "http://my-external",
 			AccountLinkProfile: "http://third-party-svc",
 			ComparatorURL:      "http://comp.example.com",
@@ -737,8 +1360,14 @@ func TestAccountLink(t *testing.T) {
 		require.NoError(t, err)
 		require.NotNil(t, svc)
 
-		state := uuid.New().String()
-		endpoint := fmt.Sprintf(accountLinkURLFormat, svc.accountLinkProfile, uuid.New().String(), svc.hostExternalURL, state)
+		cID := uuid.New().String()
+
+		requestURI := newLinkRequest(t, svc, &linkRequest{
+			ClientID:  cID,
+			ExpiresAt: time.Now().Add(time.Minute),
+		})
+
+		endpoint := fmt.Sprintf(accountLinkURLFormat, svc.accountLinkProfile, cID, requestURI)
 
 		req, err := http.NewRequest("GET", endpoint, nil)
 		require.NoError(t, err)
@@ -747,16 +1376,22 @@ func TestAccountLink(t *testing.T) {
 
 		svc.link(rr, req)
 		require.Equal(t, http.StatusBadRequest, rr.Code)
-		require.Contains(t, rr.Body.String(), "unmarshal client data")
+		require.Contains(t, rr.Body.String(), "get client data")
 	})
 
 	t.Run("store error", func(t *testing.T) {
-		cIDBytes, err := json.Marshal(&clientData{})
+		cID := uuid.New().String()
+
+		dataBytes, err := json.Marshal(&linkRequest{ClientID: cID, ExpiresAt: time.Now().Add(time.Minute)})
 		require.NoError(t, err)
 
 		svc, err := New(&Config{
 			StoreProvider: &mockstorage.Provider{
-				OpenStoreReturn: &mockstorage.Store{GetReturn: cIDBytes, ErrPut: errors.New("store error")},
+				OpenStoreReturn: &mockstorage.Store{
+					GetReturn:   dataBytes,
+					ErrPut:      errors.New("store error"),
+					QueryReturn: &mockstorage.Iterator{},
+				},
 			},
 			HostExternalURL:    "http://my-external",
 			AccountLinkProfile: "http://third-party-svc",
@@ -765,8 +1400,7 @@ func TestAccountLink(t *testing.T) {
 		require.NoError(t, err)
 		require.NotNil(t, svc)
 
-		state := uuid.New().String()
-		endpoint := fmt.Sprintf(accountLinkURLFormat, svc.accountLinkProfile, uuid.New().String(), svc.hostExternalURL, state)
+		endpoint := fmt.Sprintf(accountLinkURLFormat, svc.accountLinkProfile, cID, uuid.New().String())
 
 		req, err := http.NewRequest("GET", endpoint, nil)
 		require.NoError(t, err)
@@ -780,7 +1414,7 @@ func TestAccountLink(t *testing.T) {
 }
 
 func TestConsent(t *testing.T) {
-	queryFmt := "?id=%s&sessionid=%s"
+	queryFmt := consent + "?id=%s"
 
 	t.Run("success", func(t *testing.T) {
 		memProvider := mem.NewProvider()
@@ -796,16 +1430,12 @@ func TestConsent(t *testing.T) {
 
 		svc.vClient = &mockVaultClient{}
 		svc.compClient = &mockComparatorClient{}
+		require.NoError(t, svc.saveClientData(testClientData()))
 
 		txnStore, err := memProvider.OpenStore(txnStoreName)
 		require.NoError(t, err)
 
-		sessionid := uuid.New().String()
-
-		err = txnStore.Put(sessionid, []byte(sampleUserName))
-		require.NoError(t, err)
-
-		b, err := json.Marshal(&userData{})
+		b, err := json.Marshal(&userData{NationalIDDocID: "doc1"})
 		require.NoError(t, err)
 
 		err = txnStore.Put(sampleUserName, b)
@@ -814,6 +1444,7 @@ func TestConsent(t *testing.T) {
 		data := &sessionData{
 			State:       uuid.New().String(),
 			CallbackURL: "https://url/callback",
+			ClientID:    "client1",
 		}
 		b, err = json.Marshal(data)
 		require.NoError(t, err)
@@ -822,8 +1453,7 @@ func TestConsent(t *testing.T) {
 		err = txnStore.Put(stateID, b)
 		require.NoError(t, err)
 
-		req, err := http.NewRequest("GET", fmt.Sprintf(queryFmt, stateID, sessionid), nil)
-		require.NoError(t, err)
+		req := newCSRFRequest(t, svc, fmt.Sprintf(queryFmt, stateID), sampleUserName)
 
 		rr := httptest.NewRecorder()
 
@@ -836,9 +1466,37 @@ func TestConsent(t *testing.T) {
 		require.Equal(t, "/callback", ep.Path)
 		require.Equal(t, data.State, ep.Query().Get("state"))
 		require.NotEmpty(t, ep.Query().Get("auth"))
+
+		links, err := svc.getAccountLinks(stateTagName, data.State)
+		require.NoError(t, err)
+		require.Len(t, links, 1)
+		require.NotEmpty(t, links[0].VaultAuthorizationID)
+
+		// the consent can't be replayed
+		rr = httptest.NewRecorder()
+
+		svc.consent(rr, newCSRFRequest(t, svc, fmt.Sprintf(queryFmt, stateID), sampleUserName))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to get state data")
+	})
+
+	t.Run("invalid csrf token", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		for _, req := range []*http.Request{
+			newSessionRequest(t, svc, http.MethodPost, fmt.Sprintf(queryFmt, uuid.NewString()), sampleUserName),
+			newSessionRequest(t, svc, http.MethodPost, fmt.Sprintf(queryFmt, uuid.NewString())+"&csrf_token=invalid",
+				sampleUserName),
+		} {
+			rr := httptest.NewRecorder()
+
+			svc.consent(rr, req)
+			require.Equal(t, http.StatusForbidden, rr.Code)
+			require.Contains(t, rr.Body.String(), "invalid csrf token")
+		}
 	})
 
-	t.Run("missing session query param", func(t *testing.T) {
+	t.Run("missing id query param", func(t *testing.T) {
 		svc, err := New(&Config{
 			StoreProvider:      mem.NewProvider(),
 			AccountLinkProfile: "http://third-party-svc",
@@ -849,15 +1507,12 @@ func TestConsent(t *testing.T) {
 
 		rr := httptest.NewRecorder()
 
-		req, err := http.NewRequest("GET", fmt.Sprintf(queryFmt, "", ""), nil)
-		require.NoError(t, err)
-
-		svc.consent(rr, req)
+		svc.consent(rr, newCSRFRequest(t, svc, fmt.Sprintf(queryFmt, ""), sampleUserName))
 		require.Equal(t, http.StatusBadRequest, rr.Code)
-		require.Contains(t, rr.Body.String(), "sessionid or id can't be empty")
+		require.Contains(t, rr.Body.String(), "id can't be empty")
 	})
 
-	t.Run("sessionid not found", func(t *testing.T) {
+	t.Run("no session", func(t *testing.T) {
 		svc, err := New(&Config{
 			StoreProvider:      mem.NewProvider(),
 			AccountLinkProfile: "http://third-party-svc",
@@ -869,12 +1524,13 @@ func TestConsent(t *testing.T) {
 
 		rr := httptest.NewRecorder()
 
-		req, err := http.NewRequest("GET", fmt.Sprintf(queryFmt, uuid.NewString(), uuid.NewString()), nil)
+		// the session id isn't accepted in the query
+		req, err := http.NewRequest("GET", fmt.Sprintf(queryFmt, uuid.NewString())+"&sessionid="+uuid.NewString(), nil)
 		require.NoError(t, err)
 
 		svc.consent(rr, req)
-		require.Equal(t, http.StatusBadRequest, rr.Code)
-		require.Contains(t, rr.Body.String(), "failed to get session data")
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+		require.Contains(t, rr.Body.String(), "no session")
 	})
 
 	t.Run("stateID not found", func(t *testing.T) {
@@ -892,12 +1548,7 @@ func TestConsent(t *testing.T) {
 		txnStore, err := memProvider.OpenStore(txnStoreName)
 		require.NoError(t, err)
 
-		sessionid := uuid.New().String()
-
-		err = txnStore.Put(sessionid, []byte(sampleUserName))
-		require.NoError(t, err)
-
-		b, err := json.Marshal(&userData{})
+		b, err := json.Marshal(&userData{NationalIDDocID: "doc1"})
 		require.NoError(t, err)
 
 		err = txnStore.Put(sampleUserName, b)
@@ -905,8 +1556,7 @@ func TestConsent(t *testing.T) {
 
 		rr := httptest.NewRecorder()
 
-		req, err := http.NewRequest("GET", fmt.Sprintf(queryFmt, uuid.NewString(), sessionid), nil)
-		require.NoError(t, err)
+		req := newCSRFRequest(t, svc, fmt.Sprintf(queryFmt, uuid.NewString()), sampleUserName)
 
 		svc.consent(rr, req)
 		require.Equal(t, http.StatusBadRequest, rr.Code)
@@ -914,9 +1564,8 @@ func TestConsent(t *testing.T) {
 	})
 
 	t.Run("no data for the user", func(t *testing.T) {
-		memProvider := mem.NewProvider()
 		svc, err := New(&Config{
-			StoreProvider:      memProvider,
+			StoreProvider:      mem.NewProvider(),
 			HostExternalURL:    "http://my-external",
 			AccountLinkProfile: "http://third-party-svc",
 			ComparatorURL:      "http://comp.example.com",
@@ -924,16 +1573,7 @@ func TestConsent(t *testing.T) {
 		require.NoError(t, err)
 		require.NotNil(t, svc)
 
-		txnStore, err := memProvider.OpenStore(txnStoreName)
-		require.NoError(t, err)
-
-		sessionid := uuid.New().String()
-
-		err = txnStore.Put(sessionid, []byte(sampleUserName))
-		require.NoError(t, err)
-
-		req, err := http.NewRequest("GET", fmt.Sprintf(queryFmt, uuid.NewString(), sessionid), nil)
-		require.NoError(t, err)
+		req := newCSRFRequest(t, svc, fmt.Sprintf(queryFmt, uuid.NewString()), sampleUserName)
 
 		rr := httptest.NewRecorder()
 
@@ -957,11 +1597,7 @@ func TestConsent(t *testing.T) {
 		txnStore, err := memProvider.OpenStore(txnStoreName)
 		require.NoError(t, err)
 
-		sessionid := uuid.New().String()
-		err = txnStore.Put(sessionid, []byte(sampleUserName))
-		require.NoError(t, err)
-
-		b, err := json.Marshal(&userData{})
+		b, err := json.Marshal(&userData{NationalIDDocID: "doc1"})
 		require.NoError(t, err)
 
 		err = txnStore.Put(sampleUserName, b)
@@ -972,8 +1608,7 @@ func TestConsent(t *testing.T) {
 		err = txnStore.Put(stateID, []byte("invalid data"))
 		require.NoError(t, err)
 
-		req, err := http.NewRequest("GET", fmt.Sprintf(queryFmt, stateID, sessionid), nil)
-		require.NoError(t, err)
+		req := newCSRFRequest(t, svc, fmt.Sprintf(queryFmt, stateID), sampleUserName)
 
 		rr := httptest.NewRecorder()
 
@@ -996,14 +1631,10 @@ func TestConsent(t *testing.T) {
 
 		svc.compClient = &mockComparatorClient{GetConfigErr: errors.New("config error")}
 
-		sessionid := uuid.New().String()
 		txnStore, err := memProvider.OpenStore(txnStoreName)
 		require.NoError(t, err)
 
-		err = txnStore.Put(sessionid, []byte(sampleUserName))
-		require.NoError(t, err)
-
-		b, err := json.Marshal(&userData{})
+		b, err := json.Marshal(&userData{NationalIDDocID: "doc1"})
 		require.NoError(t, err)
 
 		err = txnStore.Put(sampleUserName, b)
@@ -1022,8 +1653,7 @@ func TestConsent(t *testing.T) {
 		err = txnStore.Put(stateID, b)
 		require.NoError(t, err)
 
-		req, err := http.NewRequest("GET", fmt.Sprintf(queryFmt, stateID, sessionid), nil)
-		require.NoError(t, err)
+		req := newCSRFRequest(t, svc, fmt.Sprintf(queryFmt, stateID), sampleUserName)
 
 		rr := httptest.NewRecorder()
 
@@ -1033,6 +1663,8 @@ func TestConsent(t *testing.T) {
 
 		svc.compClient = &mockComparatorClient{GetConfigResp: &compclientops.GetConfigOK{}}
 
+		require.NoError(t, txnStore.Put(stateID, b))
+
 		rr = httptest.NewRecorder()
 
 		svc.consent(rr, req)
@@ -1054,15 +1686,12 @@ func TestConsent(t *testing.T) {
 
 		svc.vClient = &mockVaultClient{CreateAuthorizationErr: errors.New("vault auth error")}
 		svc.compClient = &mockComparatorClient{}
+		require.NoError(t, svc.saveClientData(testClientData()))
 
-		sessionid := uuid.New().String()
 		txnStore, err := memProvider.OpenStore(txnStoreName)
 		require.NoError(t, err)
 
-		err = txnStore.Put(sessionid, []byte(sampleUserName))
-		require.NoError(t, err)
-
-		b, err := json.Marshal(&userData{})
+		b, err := json.Marshal(&userData{NationalIDDocID: "doc1"})
 		require.NoError(t, err)
 
 		err = txnStore.Put(sampleUserName, b)
@@ -1071,6 +1700,7 @@ func TestConsent(t *testing.T) {
 		data := &sessionData{
 			State:       uuid.New().String(),
 			CallbackURL: "https://url/callback",
+			ClientID:    "client1",
 		}
 
 		b, err = json.Marshal(data)
@@ -1081,8 +1711,7 @@ func TestConsent(t *testing.T) {
 		err = txnStore.Put(stateID, b)
 		require.NoError(t, err)
 
-		req, err := http.NewRequest("GET", fmt.Sprintf(queryFmt, stateID, sessionid), nil)
-		require.NoError(t, err)
+		req := newCSRFRequest(t, svc, fmt.Sprintf(queryFmt, stateID), sampleUserName)
 
 		rr := httptest.NewRecorder()
 
@@ -1105,15 +1734,12 @@ func TestConsent(t *testing.T) {
 
 		svc.vClient = &mockVaultClient{CreateAuthorizationResp: &vault.CreatedAuthorization{}}
 		svc.compClient = &mockComparatorClient{}
+		require.NoError(t, svc.saveClientData(testClientData()))
 
-		sessionid := uuid.New().String()
 		txnStore, err := memProvider.OpenStore(txnStoreName)
 		require.NoError(t, err)
 
-		err = txnStore.Put(sessionid, []byte(sampleUserName))
-		require.NoError(t, err)
-
-		b, err := json.Marshal(&userData{})
+		b, err := json.Marshal(&userData{NationalIDDocID: "doc1"})
 		require.NoError(t, err)
 
 		err = txnStore.Put(sampleUserName, b)
@@ -1122,6 +1748,7 @@ func TestConsent(t *testing.T) {
 		data := &sessionData{
 			State:       uuid.New().String(),
 			CallbackURL: "https://url/callback",
+			ClientID:    "client1",
 		}
 
 		b, err = json.Marshal(data)
@@ -1132,8 +1759,7 @@ func TestConsent(t *testing.T) {
 		err = txnStore.Put(stateID, b)
 		require.NoError(t, err)
 
-		req, err := http.NewRequest("GET", fmt.Sprintf(queryFmt, stateID, sessionid), nil)
-		require.NoError(t, err)
+		req := newCSRFRequest(t, svc, fmt.Sprintf(queryFmt, stateID), sampleUserName)
 
 		rr := httptest.NewRecorder()
 
@@ -1156,15 +1782,12 @@ func TestConsent(t *testing.T) {
 
 		svc.vClient = &mockVaultClient{}
 		svc.compClient = &mockComparatorClient{PostAuthorizationsErr: errors.New("http error")}
+		require.NoError(t, svc.saveClientData(testClientData()))
 
-		sessionid := uuid.New().String()
 		txnStore, err := memProvider.OpenStore(txnStoreName)
 		require.NoError(t, err)
 
-		err = txnStore.Put(sessionid, []byte(sampleUserName))
-		require.NoError(t, err)
-
-		b, err := json.Marshal(&userData{})
+		b, err := json.Marshal(&userData{NationalIDDocID: "doc1"})
 		require.NoError(t, err)
 
 		err = txnStore.Put(sampleUserName, b)
@@ -1173,6 +1796,7 @@ func TestConsent(t *testing.T) {
 		data := &sessionData{
 			State:       uuid.New().String(),
 			CallbackURL: "https://url/callback",
+			ClientID:    "client1",
 		}
 
 		b, err = json.Marshal(data)
@@ -1180,250 +1804,63 @@ func TestConsent(t *testing.T) {
 
 		stateID := uuid.New().String()
 
-		err = txnStore.Put(stateID, b)
-		require.NoError(t, err)
-
-		req, err := http.NewRequest("GET", fmt.Sprintf(queryFmt, stateID, sessionid), nil)
-		require.NoError(t, err)
-
-		rr := httptest.NewRecorder()
-
-		svc.consent(rr, req)
-		require.Equal(t, http.StatusInternalServerError, rr.Code)
-		require.Contains(t, rr.Body.String(), "create comparator authorization")
-
-		svc.compClient = &mockComparatorClient{PostAuthorizationsResp: &compclientops.PostAuthorizationsOK{}}
-
-		svc.consent(rr, req)
-		require.Equal(t, http.StatusInternalServerError, rr.Code)
-		require.Contains(t, rr.Body.String(), "missing auth token from comparator")
-	})
-}
-
-func TestAccountLinkCallback(t *testing.T) {
-	t.Run("success", func(t *testing.T) {
-		file, err := ioutil.TempFile("", "*.html")
-		require.NoError(t, err)
-
-		defer func() { require.NoError(t, os.Remove(file.Name())) }()
-
-		memProvider := mem.NewProvider()
-
-		svc, err := New(&Config{
-			StoreProvider:     memProvider,
-			AccountLinkedHTML: file.Name(),
-			ComparatorURL:     "http://comp.example.com",
-		})
-		require.NoError(t, err)
-		require.NotNil(t, svc)
-
-		svc.vClient = &mockVaultClient{}
-		svc.compClient = &mockComparatorClient{}
-
-		txnStore, err := memProvider.OpenStore(txnStoreName)
-		require.NoError(t, err)
-
-		state := uuid.New().String()
-
-		err = txnStore.Put(state, []byte(sampleUserName))
-		require.NoError(t, err)
-
-		uDataBytes, err := json.Marshal(&userData{})
-		require.NoError(t, err)
-
-		err = txnStore.Put(sampleUserName, uDataBytes)
-		require.NoError(t, err)
-
-		req, err := http.NewRequest("GET", "/callback?auth="+uuid.New().String()+"&state="+state, nil)
-		require.NoError(t, err)
-
-		rr := httptest.NewRecorder()
-
-		svc.accountLinkCallback(rr, req)
-		require.Equal(t, http.StatusOK, rr.Code)
-	})
-
-	t.Run("missing auth", func(t *testing.T) {
-		svc, err := New(&Config{
-			StoreProvider: mem.NewProvider(),
-			ComparatorURL: "http://comp.example.com",
-		})
-		require.NoError(t, err)
-		require.NotNil(t, svc)
-
-		rr := httptest.NewRecorder()
-
-		req, err := http.NewRequest("GET", "/callback", nil)
-		require.NoError(t, err)
-
-		svc.accountLinkCallback(rr, req)
-		require.Equal(t, http.StatusBadRequest, rr.Code)
-		require.Contains(t, rr.Body.String(), "missing authorization")
-	})
-
-	t.Run("missing state", func(t *testing.T) {
-		svc, err := New(&Config{
-			StoreProvider: mem.NewProvider(),
-			ComparatorURL: "http://comp.example.com",
-		})
-		require.NoError(t, err)
-		require.NotNil(t, svc)
-
-		rr := httptest.NewRecorder()
-
-		req, err := http.NewRequest("GET", "/callback?auth="+uuid.New().String(), nil)
-		require.NoError(t, err)
-
-		svc.accountLinkCallback(rr, req)
-		require.Equal(t, http.StatusBadRequest, rr.Code)
-		require.Contains(t, rr.Body.String(), "missing state")
-	})
-
-	t.Run("db error", func(t *testing.T) {
-		memProvider := mem.NewProvider()
-
-		svc, err := New(&Config{
-			StoreProvider: memProvider,
-			ComparatorURL: "http://comp.example.com",
-		})
-		require.NoError(t, err)
-		require.NotNil(t, svc)
-
-		state := uuid.New().String()
-
-		req, err := http.NewRequest("GET", "/callback?auth="+uuid.New().String()+"&state="+state, nil)
-		require.NoError(t, err)
-
-		rr := httptest.NewRecorder()
-
-		svc.accountLinkCallback(rr, req)
-		require.Equal(t, http.StatusBadRequest, rr.Code)
-		require.Contains(t, rr.Body.String(), "failed to get state")
-
-		txnStore, err := memProvider.OpenStore(txnStoreName)
-		require.NoError(t, err)
-
-		err = txnStore.Put(state, []byte(sampleUserName))
-		require.NoError(t, err)
-
-		rr = httptest.NewRecorder()
-
-		svc.accountLinkCallback(rr, req)
-		require.Equal(t, http.StatusInternalServerError, rr.Code)
-		require.Contains(t, rr.Body.String(), "unable to get user data")
-	})
-
-	t.Run("vault server error", func(t *testing.T) {
-		memProvider := mem.NewProvider()
-
-		svc, err := New(&Config{
-			StoreProvider: memProvider,
-			ComparatorURL: "http://comp.example.com",
-		})
-		require.NoError(t, err)
-		require.NotNil(t, svc)
-
-		svc.vClient = &mockVaultClient{CreateAuthorizationErr: errors.New("create auth error")}
-		svc.compClient = &mockComparatorClient{}
-
-		txnStore, err := memProvider.OpenStore(txnStoreName)
-		require.NoError(t, err)
-
-		state := uuid.New().String()
-
-		err = txnStore.Put(state, []byte(sampleUserName))
-		require.NoError(t, err)
-
-		uDataBytes, err := json.Marshal(&userData{})
-		require.NoError(t, err)
-
-		err = txnStore.Put(sampleUserName, uDataBytes)
-		require.NoError(t, err)
-
-		req, err := http.NewRequest("GET", "/callback?auth="+uuid.New().String()+"&state="+state, nil)
-		require.NoError(t, err)
-
-		rr := httptest.NewRecorder()
-
-		svc.accountLinkCallback(rr, req)
-		require.Equal(t, http.StatusInternalServerError, rr.Code)
-		require.Contains(t, rr.Body.String(), "failed to create vault authorization")
-
-		svc.vClient = &mockVaultClient{CreateAuthorizationResp: &vault.CreatedAuthorization{}}
-
-		rr = httptest.NewRecorder()
-
-		svc.accountLinkCallback(rr, req)
-		require.Equal(t, http.StatusInternalServerError, rr.Code)
-		require.Contains(t, rr.Body.String(), "missing auth token from vault-server")
-	})
-
-	t.Run("comparator - get error", func(t *testing.T) {
-		memProvider := mem.NewProvider()
-
-		svc, err := New(&Config{
-			StoreProvider: memProvider,
-			ComparatorURL: "http://comp.example.com",
-		})
-		require.NoError(t, err)
-		require.NotNil(t, svc)
-
-		svc.compClient = &mockComparatorClient{GetConfigErr: errors.New("config error")}
-
-		txnStore, err := memProvider.OpenStore(txnStoreName)
-		require.NoError(t, err)
-
-		state := uuid.New().String()
-
-		err = txnStore.Put(state, []byte(sampleUserName))
-		require.NoError(t, err)
-
-		uDataBytes, err := json.Marshal(&userData{})
-		require.NoError(t, err)
-
-		err = txnStore.Put(sampleUserName, uDataBytes)
-		require.NoError(t, err)
-
-		req, err := http.NewRequest("GET", "/callback?auth="+uuid.New().String()+"&state="+state, nil)
+		err = txnStore.Put(stateID, b)
 		require.NoError(t, err)
 
+		req := newCSRFRequest(t, svc, fmt.Sprintf(queryFmt, stateID), sampleUserName)
+
 		rr := httptest.NewRecorder()
 
-		svc.accountLinkCallback(rr, req)
+		svc.consent(rr, req)
 		require.Equal(t, http.StatusInternalServerError, rr.Code)
-		require.Contains(t, rr.Body.String(), "failed get config from comparator")
+		require.Contains(t, rr.Body.String(), "create comparator authorization")
 
-		svc.compClient = &mockComparatorClient{GetConfigResp: &compclientops.GetConfigOK{}}
+		svc.compClient = &mockComparatorClient{PostAuthorizationsResp: &compclientops.PostAuthorizationsOK{}}
 
-		rr = httptest.NewRecorder()
+		require.NoError(t, txnStore.Put(stateID, b))
 
-		svc.accountLinkCallback(rr, req)
+		svc.consent(rr, req)
 		require.Equal(t, http.StatusInternalServerError, rr.Code)
-		require.Contains(t, rr.Body.String(), "empty config from comparator")
+		require.Contains(t, rr.Body.String(), "missing auth token from comparator")
 	})
+}
+
+func TestAccountLinkCallback(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		file, err := ioutil.TempFile("", "*.html")
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, os.Remove(file.Name())) }()
 
-	t.Run("comparator - compare error", func(t *testing.T) {
 		memProvider := mem.NewProvider()
 
 		svc, err := New(&Config{
-			StoreProvider: memProvider,
-			ComparatorURL: "http://comp.example.com",
+			StoreProvider:     memProvider,
+			AccountLinkedHTML: file.Name(),
+			ComparatorURL:     "http://comp.example.com",
 		})
 		require.NoError(t, err)
 		require.NotNil(t, svc)
 
-		svc.vClient = &mockVaultClient{}
-		svc.compClient = &mockComparatorClient{PostCompareErr: errors.New("compare error")}
+		svc.accountLinkProfile = "profile1"
+		require.NoError(t, svc.saveProfileData(&profileData{
+			ID:         "profile1",
+			AuthPolicy: &authPolicy{Expiry: 600},
+		}))
+
+		vClient := &mockVaultClient{}
+		svc.vClient = vClient
+		svc.compClient = &mockComparatorClient{}
 
 		txnStore, err := memProvider.OpenStore(txnStoreName)
 		require.NoError(t, err)
 
 		state := uuid.New().String()
 
-		err = txnStore.Put(state, []byte(sampleUserName))
+		err = txnStore.Put(connectStateKeyPrefix+state, []byte(sampleUserName))
 		require.NoError(t, err)
 
-		uDataBytes, err := json.Marshal(&userData{})
+		uDataBytes, err := json.Marshal(&userData{NationalIDDocID: "doc1"})
 		require.NoError(t, err)
 
 		err = txnStore.Put(sampleUserName, uDataBytes)
@@ -1435,144 +1872,129 @@ func TestAccountLinkCallback(t *testing.T) {
 		rr := httptest.NewRecorder()
 
 		svc.accountLinkCallback(rr, req)
-		require.Equal(t, http.StatusInternalServerError, rr.Code)
-		require.Contains(t, rr.Body.String(), "failed to compare docs")
+		require.Equal(t, http.StatusOK, rr.Code)
 
-		svc.compClient = &mockComparatorClient{PostCompareResp: &compclientops.PostCompareOK{}}
+		links, err := svc.getAccountLinks(stateTagName, state)
+		require.NoError(t, err)
+		require.Len(t, links, 1)
+		require.NotEmpty(t, links[0].VaultAuthorizationID)
+		require.True(t, links[0].linked())
+		require.WithinDuration(t, links[0].CreatedTime.Add(600*time.Second), links[0].AuthExpiry.Time, time.Second)
+
+		require.Len(t, vClient.authorizationScopes, 1)
+		require.Equal(t, []string{"read"}, vClient.authorizationScopes[0].Actions)
+		require.Equal(t, uint64(600), vClient.authorizationScopes[0].Caveats[0].Duration)
 
+		// the callback can't be replayed
 		rr = httptest.NewRecorder()
 
 		svc.accountLinkCallback(rr, req)
-		require.Equal(t, http.StatusInternalServerError, rr.Code)
-		require.Contains(t, rr.Body.String(), "missing compare result from comparator")
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to consume state")
 	})
-}
 
-func TestCreateClient(t *testing.T) {
-	t.Run("success", func(t *testing.T) {
+	t.Run("user data key as state", func(t *testing.T) {
 		svc, err := New(&Config{
 			StoreProvider: mem.NewProvider(),
 			ComparatorURL: "http://comp.example.com",
 		})
 		require.NoError(t, err)
-		require.NotNil(t, svc)
 
-		cReq := clientReq{
-			DID:      "did:example:123",
-			Callback: "http://test/callback",
-		}
-
-		reqBytes, err := json.Marshal(cReq)
+		uDataBytes, err := json.Marshal(&userData{UserName: sampleUserName, NationalIDDocID: "doc1"})
 		require.NoError(t, err)
 
-		req, err := http.NewRequest("POST", client, bytes.NewBuffer(reqBytes))
-		require.NoError(t, err)
+		require.NoError(t, svc.store.Put(sampleUserName, uDataBytes))
 
 		rr := httptest.NewRecorder()
 
-		svc.createClient(rr, req)
-		require.Equal(t, http.StatusCreated, rr.Code)
-
-		var resp *clientResp
+		svc.accountLinkCallback(rr, httptest.NewRequest(http.MethodGet,
+			"/callback?auth="+uuid.New().String()+"&state="+sampleUserName, nil))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to consume state")
 
-		err = json.Unmarshal(rr.Body.Bytes(), &resp)
+		_, err = svc.store.Get(sampleUserName)
 		require.NoError(t, err)
-
-		require.NotEmpty(t, resp.ClientID)
-		require.NotEmpty(t, resp.ClientSecret)
-		require.Equal(t, cReq.DID, resp.DID)
-		require.Equal(t, cReq.Callback, resp.Callback)
 	})
 
-	t.Run("invalid request", func(t *testing.T) {
+	t.Run("profile not found", func(t *testing.T) {
 		svc, err := New(&Config{
-			StoreProvider: mem.NewProvider(),
-			ComparatorURL: "http://comp.example.com",
+			StoreProvider:      mem.NewProvider(),
+			ComparatorURL:      "http://comp.example.com",
+			AccountLinkProfile: "profile1",
 		})
 		require.NoError(t, err)
-		require.NotNil(t, svc)
-
-		req, err := http.NewRequest("POST", client, strings.NewReader("invalid-json"))
-		require.NoError(t, err)
 
-		rr := httptest.NewRecorder()
-
-		svc.createClient(rr, req)
-		require.Equal(t, http.StatusBadRequest, rr.Code)
-		require.Contains(t, rr.Body.String(), "failed to decode request")
-	})
+		state := uuid.New().String()
 
-	t.Run("db error", func(t *testing.T) {
-		svc, err := New(&Config{
-			StoreProvider: &mockstorage.Provider{
-				OpenStoreReturn: &mockstorage.Store{ErrPut: errors.New("save error")},
-			},
-			ComparatorURL: "http://comp.example.com",
-		})
-		require.NoError(t, err)
-		require.NotNil(t, svc)
+		require.NoError(t, svc.store.Put(connectStateKeyPrefix+state, []byte(sampleUserName)))
 
-		reqBytes, err := json.Marshal(clientReq{})
+		uDataBytes, err := json.Marshal(&userData{NationalIDDocID: "doc1"})
 		require.NoError(t, err)
 
-		req, err := http.NewRequest("POST", client, bytes.NewBuffer(reqBytes))
-		require.NoError(t, err)
+		require.NoError(t, svc.store.Put(sampleUserName, uDataBytes))
 
 		rr := httptest.NewRecorder()
 
-		svc.createClient(rr, req)
+		svc.accountLinkCallback(rr, httptest.NewRequest(http.MethodGet,
+			"/callback?auth="+uuid.New().String()+"&state="+state, nil))
 		require.Equal(t, http.StatusInternalServerError, rr.Code)
-		require.Contains(t, rr.Body.String(), "failed to save client data")
+		require.Contains(t, rr.Body.String(), "failed to get profile data")
 	})
-}
 
-func TestGetCreate(t *testing.T) {
-	t.Run("success", func(t *testing.T) {
-		memProvider := mem.NewProvider()
+	t.Run("comparison failed", func(t *testing.T) {
+		file, err := ioutil.TempFile("", "*.html")
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, os.Remove(file.Name())) }()
+
+		_, err = file.WriteString("not linked")
+		require.NoError(t, err)
 
 		svc, err := New(&Config{
-			StoreProvider: memProvider,
-			ComparatorURL: "http://comp.example.com",
+			StoreProvider:        mem.NewProvider(),
+			AccountNotLinkedHTML: file.Name(),
+			ComparatorURL:        "http://comp.example.com",
 		})
 		require.NoError(t, err)
 		require.NotNil(t, svc)
 
-		id := uuid.New().String()
+		svc.accountLinkProfile = "profile1"
+		require.NoError(t, svc.saveProfileData(&profileData{ID: "profile1"}))
 
-		cReq := clientData{
-			ClientID: id,
-			DID:      "did:example:123",
-			Callback: "http://test/callback",
+		svc.vClient = &mockVaultClient{}
+		svc.compClient = &mockComparatorClient{
+			PostCompareResp: &compclientops.PostCompareOK{Payload: &compmodel.ComparisonResult{Result: false}},
 		}
 
-		reqBytes, err := json.Marshal(cReq)
-		require.NoError(t, err)
+		state := uuid.New().String()
 
-		txnStore, err := memProvider.OpenStore(txnStoreName)
-		require.NoError(t, err)
+		require.NoError(t, svc.store.Put(connectStateKeyPrefix+state, []byte(sampleUserName)))
 
-		err = txnStore.Put(id, reqBytes)
+		uDataBytes, err := json.Marshal(&userData{ID: "U1", NationalIDDocID: "doc1"})
 		require.NoError(t, err)
 
-		req, err := http.NewRequest("GET", client+"/"+id, nil)
+		require.NoError(t, svc.store.Put(sampleUserName, uDataBytes))
+
+		req, err := http.NewRequest("GET", "/callback?auth="+uuid.New().String()+"&state="+state, nil)
 		require.NoError(t, err)
 
 		rr := httptest.NewRecorder()
 
-		svc.getClient(rr, req)
+		svc.accountLinkCallback(rr, req)
 		require.Equal(t, http.StatusOK, rr.Code)
+		require.Equal(t, "not linked", rr.Body.String())
 
-		var resp *clientData
-
-		err = json.Unmarshal(rr.Body.Bytes(), &resp)
+		links, err := svc.getAccountLinks(stateTagName, state)
 		require.NoError(t, err)
+		require.Len(t, links, 1)
+		require.False(t, links[0].linked())
 
-		require.NotEmpty(t, resp.ClientID)
-		require.Equal(t, cReq.DID, resp.DID)
-		require.Equal(t, cReq.Callback, resp.Callback)
+		linked, err := svc.isLinked("U1")
+		require.NoError(t, err)
+		require.False(t, linked)
 	})
 
-	t.Run("no data for the id", func(t *testing.T) {
+	t.Run("missing auth", func(t *testing.T) {
 		svc, err := New(&Config{
 			StoreProvider: mem.NewProvider(),
 			ComparatorURL: "http://comp.example.com",
@@ -1580,47 +2002,17 @@ func TestGetCreate(t *testing.T) {
 		require.NoError(t, err)
 		require.NotNil(t, svc)
 
-		req, err := http.NewRequest("GET", client+"/"+uuid.New().String(), nil)
-		require.NoError(t, err)
-
 		rr := httptest.NewRecorder()
 
-		svc.getClient(rr, req)
-		require.Equal(t, http.StatusInternalServerError, rr.Code)
-		require.Contains(t, rr.Body.String(), "failed to get data")
-	})
-
-	t.Run("invalid data for the id", func(t *testing.T) {
-		memProvider := mem.NewProvider()
-
-		svc, err := New(&Config{
-			StoreProvider: memProvider,
-			ComparatorURL: "http://comp.example.com",
-		})
-		require.NoError(t, err)
-		require.NotNil(t, svc)
-
-		txnStore, err := memProvider.OpenStore(txnStoreName)
-		require.NoError(t, err)
-
-		id := uuid.New().String()
-
-		err = txnStore.Put(id, []byte("invalid-json"))
-		require.NoError(t, err)
-
-		req, err := http.NewRequest("GET", client+"/"+id, nil)
+		req, err := http.NewRequest("GET", "/callback", nil)
 		require.NoError(t, err)
 
-		rr := httptest.NewRecorder()
-
-		svc.getClient(rr, req)
-		require.Equal(t, http.StatusInternalServerError, rr.Code)
-		require.Contains(t, rr.Body.String(), "failed to unmarshal data")
+		svc.accountLinkCallback(rr, req)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "missing authorization")
 	})
-}
 
-func TestCreateProfile(t *testing.T) {
-	t.Run("success", func(t *testing.T) {
+	t.Run("missing state", func(t *testing.T) {
 		svc, err := New(&Config{
 			StoreProvider: mem.NewProvider(),
 			ComparatorURL: "http://comp.example.com",
@@ -1628,79 +2020,51 @@ func TestCreateProfile(t *testing.T) {
 		require.NoError(t, err)
 		require.NotNil(t, svc)
 
-		cReq := profileData{
-			ID:       uuid.New().String(),
-			DID:      "did:example:123",
-			Callback: "http://test/callback",
-		}
-
-		reqBytes, err := json.Marshal(cReq)
-		require.NoError(t, err)
-
-		req, err := http.NewRequest("POST", client, bytes.NewBuffer(reqBytes))
-		require.NoError(t, err)
-
 		rr := httptest.NewRecorder()
 
-		svc.createProfile(rr, req)
-		require.Equal(t, http.StatusCreated, rr.Code)
-
-		var resp *profileData
-
-		err = json.Unmarshal(rr.Body.Bytes(), &resp)
+		req, err := http.NewRequest("GET", "/callback?auth="+uuid.New().String(), nil)
 		require.NoError(t, err)
 
-		require.Equal(t, cReq.ID, resp.ID)
-		require.Equal(t, cReq.ClientID, resp.ClientID)
-		require.Equal(t, cReq.ClientSecret, resp.ClientSecret)
-		require.Equal(t, cReq.DID, resp.DID)
-		require.Equal(t, cReq.Callback, resp.Callback)
+		svc.accountLinkCallback(rr, req)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "missing state")
 	})
 
-	t.Run("invalid request", func(t *testing.T) {
+	t.Run("db error", func(t *testing.T) {
+		memProvider := mem.NewProvider()
+
 		svc, err := New(&Config{
-			StoreProvider: mem.NewProvider(),
+			StoreProvider: memProvider,
 			ComparatorURL: "http://comp.example.com",
 		})
 		require.NoError(t, err)
 		require.NotNil(t, svc)
 
-		req, err := http.NewRequest("POST", client, strings.NewReader("invalid-json"))
+		state := uuid.New().String()
+
+		req, err := http.NewRequest("GET", "/callback?auth="+uuid.New().String()+"&state="+state, nil)
 		require.NoError(t, err)
 
 		rr := httptest.NewRecorder()
 
-		svc.createProfile(rr, req)
+		svc.accountLinkCallback(rr, req)
 		require.Equal(t, http.StatusBadRequest, rr.Code)
-		require.Contains(t, rr.Body.String(), "failed to decode request")
-	})
-
-	t.Run("db error", func(t *testing.T) {
-		svc, err := New(&Config{
-			StoreProvider: &mockstorage.Provider{
-				OpenStoreReturn: &mockstorage.Store{ErrPut: errors.New("save error")},
-			},
-			ComparatorURL: "http://comp.example.com",
-		})
-		require.NoError(t, err)
-		require.NotNil(t, svc)
+		require.Contains(t, rr.Body.String(), "failed to consume state")
 
-		reqBytes, err := json.Marshal(profileData{})
+		txnStore, err := memProvider.OpenStore(txnStoreName)
 		require.NoError(t, err)
 
-		req, err := http.NewRequest("POST", client, bytes.NewBuffer(reqBytes))
+		err = txnStore.Put(connectStateKeyPrefix+state, []byte(sampleUserName))
 		require.NoError(t, err)
 
-		rr := httptest.NewRecorder()
+		rr = httptest.NewRecorder()
 
-		svc.createProfile(rr, req)
+		svc.accountLinkCallback(rr, req)
 		require.Equal(t, http.StatusInternalServerError, rr.Code)
-		require.Contains(t, rr.Body.String(), "failed to save client data")
+		require.Contains(t, rr.Body.String(), "unable to get user data")
 	})
-}
 
-func TestGetProfile(t *testing.T) {
-	t.Run("success", func(t *testing.T) {
+	t.Run("vault server error", func(t *testing.T) {
 		memProvider := mem.NewProvider()
 
 		svc, err := New(&Config{
@@ -1710,60 +2074,48 @@ func TestGetProfile(t *testing.T) {
 		require.NoError(t, err)
 		require.NotNil(t, svc)
 
-		id := uuid.New().String()
-
-		cReq := profileData{
-			ClientID: id,
-			DID:      "did:example:123",
-			Callback: "http://test/callback",
-		}
+		svc.accountLinkProfile = "profile1"
+		require.NoError(t, svc.saveProfileData(&profileData{ID: "profile1"}))
 
-		reqBytes, err := json.Marshal(cReq)
-		require.NoError(t, err)
+		svc.vClient = &mockVaultClient{CreateAuthorizationErr: errors.New("create auth error")}
+		svc.compClient = &mockComparatorClient{}
 
 		txnStore, err := memProvider.OpenStore(txnStoreName)
 		require.NoError(t, err)
 
-		err = txnStore.Put(id, reqBytes)
-		require.NoError(t, err)
+		state := uuid.New().String()
 
-		req, err := http.NewRequest("GET", profile+"/"+id, nil)
+		err = txnStore.Put(connectStateKeyPrefix+state, []byte(sampleUserName))
 		require.NoError(t, err)
 
-		rr := httptest.NewRecorder()
-
-		svc.getProfile(rr, req)
-		require.Equal(t, http.StatusOK, rr.Code)
-
-		var resp *clientData
-
-		err = json.Unmarshal(rr.Body.Bytes(), &resp)
+		uDataBytes, err := json.Marshal(&userData{NationalIDDocID: "doc1"})
 		require.NoError(t, err)
 
-		require.NotEmpty(t, resp.ClientID)
-		require.Equal(t, cReq.DID, resp.DID)
-		require.Equal(t, cReq.Callback, resp.Callback)
-	})
-
-	t.Run("no data for the id", func(t *testing.T) {
-		svc, err := New(&Config{
-			StoreProvider: mem.NewProvider(),
-			ComparatorURL: "http://comp.example.com",
-		})
+		err = txnStore.Put(sampleUserName, uDataBytes)
 		require.NoError(t, err)
-		require.NotNil(t, svc)
 
-		req, err := http.NewRequest("GET", client+"/"+uuid.New().String(), nil)
+		req, err := http.NewRequest("GET", "/callback?auth="+uuid.New().String()+"&state="+state, nil)
 		require.NoError(t, err)
 
 		rr := httptest.NewRecorder()
 
-		svc.getProfile(rr, req)
+		svc.accountLinkCallback(rr, req)
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to create vault authorization")
+
+		svc.vClient = &mockVaultClient{CreateAuthorizationResp: &vault.CreatedAuthorization{}}
+
+		// the state is consumed by the callback
+		require.NoError(t, txnStore.Put(connectStateKeyPrefix+state, []byte(sampleUserName)))
+
+		rr = httptest.NewRecorder()
+
+		svc.accountLinkCallback(rr, req)
 		require.Equal(t, http.StatusInternalServerError, rr.Code)
-		require.Contains(t, rr.Body.String(), "failed to get data")
+		require.Contains(t, rr.Body.String(), "missing auth token from vault-server")
 	})
 
-	t.Run("invalid data for the id", func(t *testing.T) {
+	t.Run("comparator - get error", func(t *testing.T) {
 		memProvider := mem.NewProvider()
 
 		svc, err := New(&Config{
@@ -1773,27 +2125,47 @@ func TestGetProfile(t *testing.T) {
 		require.NoError(t, err)
 		require.NotNil(t, svc)
 
+		svc.accountLinkProfile = "profile1"
+		require.NoError(t, svc.saveProfileData(&profileData{ID: "profile1"}))
+
+		svc.compClient = &mockComparatorClient{GetConfigErr: errors.New("config error")}
+
 		txnStore, err := memProvider.OpenStore(txnStoreName)
 		require.NoError(t, err)
 
-		id := uuid.New().String()
+		state := uuid.New().String()
+
+		err = txnStore.Put(connectStateKeyPrefix+state, []byte(sampleUserName))
+		require.NoError(t, err)
+
+		uDataBytes, err := json.Marshal(&userData{NationalIDDocID: "doc1"})
+		require.NoError(t, err)
 
-		err = txnStore.Put(id, []byte("invalid-json"))
+		err = txnStore.Put(sampleUserName, uDataBytes)
 		require.NoError(t, err)
 
-		req, err := http.NewRequest("GET", client+"/"+id, nil)
+		req, err := http.NewRequest("GET", "/callback?auth="+uuid.New().String()+"&state="+state, nil)
 		require.NoError(t, err)
 
 		rr := httptest.NewRecorder()
 
-		svc.getProfile(rr, req)
+		svc.accountLinkCallback(rr, req)
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed get config from comparator")
+
+		svc.compClient = &mockComparatorClient{GetConfigResp: &compclientops.GetConfigOK{}}
+
+		// the state is consumed by the callback
+		require.NoError(t, txnStore.Put(connectStateKeyPrefix+state, []byte(sampleUserName)))
+
+		rr = httptest.NewRecorder()
+
+		svc.accountLinkCallback(rr, req)
 		require.Equal(t, http.StatusInternalServerError, rr.Code)
-		require.Contains(t, rr.Body.String(), "failed to unmarshal data")
+		require.Contains(t, rr.Body.String(), "empty config from comparator")
 	})
-}
 
-func TestDeleteProfile(t *testing.T) {
-	t.Run("success", func(t *testing.T) {
+	t.Run("comparator - compare error", func(t *testing.T) {
 		memProvider := mem.NewProvider()
 
 		svc, err := New(&Config{
@@ -1803,48 +2175,45 @@ func TestDeleteProfile(t *testing.T) {
 		require.NoError(t, err)
 		require.NotNil(t, svc)
 
-		id := uuid.New().String()
+		svc.accountLinkProfile = "profile1"
+		require.NoError(t, svc.saveProfileData(&profileData{ID: "profile1"}))
 
-		cReq := profileData{
-			ClientID: id,
-		}
+		svc.vClient = &mockVaultClient{}
+		svc.compClient = &mockComparatorClient{PostCompareErr: errors.New("compare error")}
 
-		reqBytes, err := json.Marshal(cReq)
+		txnStore, err := memProvider.OpenStore(txnStoreName)
 		require.NoError(t, err)
 
-		txnStore, err := memProvider.OpenStore(txnStoreName)
+		state := uuid.New().String()
+
+		err = txnStore.Put(connectStateKeyPrefix+state, []byte(sampleUserName))
+		require.NoError(t, err)
+
+		uDataBytes, err := json.Marshal(&userData{NationalIDDocID: "doc1"})
 		require.NoError(t, err)
 
-		err = txnStore.Put(id, reqBytes)
+		err = txnStore.Put(sampleUserName, uDataBytes)
 		require.NoError(t, err)
 
-		req, err := http.NewRequest("DELETE", profile+"/"+id, nil)
+		req, err := http.NewRequest("GET", "/callback?auth="+uuid.New().String()+"&state="+state, nil)
 		require.NoError(t, err)
 
 		rr := httptest.NewRecorder()
 
-		svc.deleteProfile(rr, req)
-		require.Equal(t, http.StatusOK, rr.Code)
-	})
+		svc.accountLinkCallback(rr, req)
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to compare docs")
 
-	t.Run("db error", func(t *testing.T) {
-		svc, err := New(&Config{
-			StoreProvider: &mockstorage.Provider{
-				OpenStoreReturn: &mockstorage.Store{ErrDelete: errors.New("delete error")},
-			},
-			ComparatorURL: "http://comp.example.com",
-		})
-		require.NoError(t, err)
-		require.NotNil(t, svc)
+		svc.compClient = &mockComparatorClient{PostCompareResp: &compclientops.PostCompareOK{}}
 
-		req, err := http.NewRequest("DELETE", client+"/"+uuid.New().String(), nil)
-		require.NoError(t, err)
+		// the state is consumed by the callback
+		require.NoError(t, txnStore.Put(connectStateKeyPrefix+state, []byte(sampleUserName)))
 
-		rr := httptest.NewRecorder()
+		rr = httptest.NewRecorder()
 
-		svc.deleteProfile(rr, req)
+		svc.accountLinkCallback(rr, req)
 		require.Equal(t, http.StatusInternalServerError, rr.Code)
-		require.Contains(t, rr.Body.String(), "failed to delete data")
+		require.Contains(t, rr.Body.String(), "missing compare result from comparator")
 	})
 }
 
@@ -1902,7 +2271,7 @@ func TestGetUsers(t *testing.T) {
 		require.Equal(t, 1, len(resp.Users))
 	})
 
-	t.Run("get only 5 records", func(t *testing.T) {
+	t.Run("paginated", func(t *testing.T) {
 		svc, err := New(&Config{
 			StoreProvider: mem.NewProvider(),
 			ComparatorURL: "http://comp.example.com",
@@ -1910,15 +2279,12 @@ func TestGetUsers(t *testing.T) {
 		require.NoError(t, err)
 		require.NotNil(t, svc)
 
-		userStore, err := mem.NewProvider().OpenStore("userstore")
-		require.NoError(t, err)
-
-		svc.userStore = userStore
+		created := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
 
 		for i := 0; i < 10; i++ {
 			reqData := userData{
-				ID:              uuid.NewString(),
-				UserName:        sampleUserName,
+				ID:              fmt.Sprintf("U%d", i),
+				UserName:        fmt.Sprintf("user%d", i),
 				VaultID:         uuid.NewString(),
 				NationalIDDocID: uuid.NewString(),
 			}
@@ -1929,32 +2295,114 @@ func TestGetUsers(t *testing.T) {
 			err = svc.store.Put(reqData.UserName, reqBytes)
 			require.NoError(t, err)
 
-			uMap := userIDNameMap{
+			createdTime := created.Add(time.Duration(i) * time.Hour)
+
+			reqBytes, err = json.Marshal(userIDNameMap{
 				ID:          reqData.ID,
 				UserName:    reqData.UserName,
-				CreatedTime: util.NewTime(time.Now()),
-			}
-
-			reqBytes, err = json.Marshal(uMap)
+				CreatedTime: util.NewTime(createdTime),
+			})
 			require.NoError(t, err)
 
-			err = userStore.Put(reqData.ID, reqBytes, storage.Tag{Name: userTagName})
+			err = svc.userStore.Put(reqData.ID, reqBytes, storage.Tag{Name: userTagName}, createdTag(createdTime))
 			require.NoError(t, err)
 		}
 
-		req, err := http.NewRequest("GET", users, nil)
+		getUsers := func(query string) *getUserDataResp {
+			rr := httptest.NewRecorder()
+
+			svc.getUsers(rr, httptest.NewRequest(http.MethodGet, users+query, nil))
+			require.Equal(t, http.StatusOK, rr.Code)
+
+			var resp *getUserDataResp
+
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+			return resp
+		}
+
+		// newest first
+		resp := getUsers("?limit=4")
+		require.Equal(t, 10, resp.Total)
+		require.Len(t, resp.Users, 4)
+		require.Equal(t, "U9", resp.Users[0].ID)
+		require.Equal(t, "U6", resp.Users[3].ID)
+		require.NotEmpty(t, resp.NextCursor)
+
+		resp = getUsers("?limit=4&cursor=" + resp.NextCursor)
+		require.Len(t, resp.Users, 4)
+		require.Equal(t, "U5", resp.Users[0].ID)
+
+		resp = getUsers("?limit=4&cursor=" + resp.NextCursor)
+		require.Len(t, resp.Users, 2)
+		require.Equal(t, "U0", resp.Users[1].ID)
+		require.Empty(t, resp.NextCursor)
+
+		// all the users fit in the default page
+		resp = getUsers("")
+		require.Len(t, resp.Users, 10)
+		require.Empty(t, resp.NextCursor)
+
+		// date range, oldest first
+		resp = getUsers("?order=asc&from=2021-06-01T02:00:00Z&to=2021-06-01T04:00:00Z")
+		require.Equal(t, 3, resp.Total)
+		require.Len(t, resp.Users, 3)
+		require.Equal(t, "U2", resp.Users[0].ID)
+		require.Equal(t, "U4", resp.Users[2].ID)
+
+		resp = getUsers("?from=2022-01-01T00:00:00Z")
+		require.Equal(t, 0, resp.Total)
+		require.Empty(t, resp.Users)
+	})
+
+	t.Run("invalid request", func(t *testing.T) {
+		svc, err := New(&Config{
+			StoreProvider: mem.NewProvider(),
+			ComparatorURL: "http://comp.example.com",
+		})
 		require.NoError(t, err)
 
-		rr := httptest.NewRecorder()
+		for query, errMsg := range map[string]string{
+			"?limit=1000":     "limit must be between 1 and 100",
+			"?from=yesterday": "invalid from date",
+			"?to=2021-06-01":  "invalid to date",
+			"?order=random":   "order must be asc or desc",
+			"?cursor=" + base64.RawURLEncoding.EncodeToString([]byte("U1")): "invalid cursor",
+		} {
+			rr := httptest.NewRecorder()
 
-		svc.getUsers(rr, req)
-		require.Equal(t, http.StatusOK, rr.Code)
+			svc.getUsers(rr, httptest.NewRequest(http.MethodGet, users+query, nil))
+			require.Equal(t, http.StatusBadRequest, rr.Code)
+			require.Contains(t, rr.Body.String(), errMsg)
+		}
+	})
 
-		var resp *getUserDataResp
+	t.Run("db error", func(t *testing.T) {
+		svc, err := New(&Config{
+			StoreProvider: mem.NewProvider(),
+			ComparatorURL: "http://comp.example.com",
+		})
+		require.NoError(t, err)
 
-		err = json.Unmarshal(rr.Body.Bytes(), &resp)
+		svc.userStore = &mockstorage.Store{ErrQuery: errors.New("query error")}
+
+		rr := httptest.NewRecorder()
+
+		svc.getUsers(rr, httptest.NewRequest(http.MethodGet, users, nil))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed get user data: query user : query error")
+
+		svc.userStore, err = mem.NewProvider().OpenStore("userstore")
 		require.NoError(t, err)
-		require.Equal(t, 5, len(resp.Users))
+
+		require.NoError(t, svc.userStore.Put("U1", []byte(`{"id":"U1","userName":"invalid"}`),
+			storage.Tag{Name: userTagName}))
+
+		rr = httptest.NewRecorder()
+
+		svc.getUsers(rr, httptest.NewRequest(http.MethodGet, users, nil))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed get user data")
 	})
 }
 
@@ -1975,8 +2423,16 @@ func TestCreateAuthorizations(t *testing.T) {
 
 		svc.vClient = &mockVaultClient{}
 		svc.compClient = &mockComparatorClient{}
+
+		var clientID, clientSecret string
+
+		doFunc := mockHTTPResponse(t, nil, &mockHTTPResponseData{status: http.StatusOK})
 		svc.httpClient = &mockHTTPClient{
-			doFunc: mockHTTPResponse(t, nil, &mockHTTPResponseData{status: http.StatusOK}),
+			doFunc: func(req *http.Request) (*http.Response, error) {
+				clientID, clientSecret, _ = req.BasicAuth()
+
+				return doFunc(req)
+			},
 		}
 
 		userStore, err := mem.NewProvider().OpenStore("userstore")
@@ -2015,11 +2471,10 @@ func TestCreateAuthorizations(t *testing.T) {
 		err = userStore.Put(uData.ID, uBytes, storage.Tag{Name: userTagName})
 		require.NoError(t, err)
 
-		cIDBytes, err := json.Marshal(&clientData{})
-		require.NoError(t, err)
-
-		err = txnStore.Put(cID, cIDBytes)
-		require.NoError(t, err)
+		require.NoError(t, svc.saveProfile(&profileReq{
+			profileData:  profileData{ID: cID, ClientID: "client1"},
+			ClientSecret: "secret1",
+		}))
 
 		uBytes, err = json.Marshal(generateUserAuthReq{Users: []string{uData.ID}})
 		require.NoError(t, err)
@@ -2037,6 +2492,10 @@ func TestCreateAuthorizations(t *testing.T) {
 		err = json.Unmarshal(rr.Body.Bytes(), &resp)
 		require.NoError(t, err)
 		require.Equal(t, 1, len(resp.UserAuths))
+
+		// the extractor service is called with the client credentials of the profile
+		require.Equal(t, "client1", clientID)
+		require.Equal(t, "secret1", clientSecret)
 	})
 
 	t.Run("invalid client id", func(t *testing.T) {
@@ -2109,11 +2568,7 @@ func TestCreateAuthorizations(t *testing.T) {
 		err = userStore.Put(uData.ID, uBytes, storage.Tag{Name: userTagName})
 		require.NoError(t, err)
 
-		cIDBytes, err := json.Marshal(&clientData{})
-		require.NoError(t, err)
-
-		err = txnStore.Put(cID, cIDBytes)
-		require.NoError(t, err)
+		require.NoError(t, svc.saveProfileData(&profileData{ID: cID}))
 
 		uBytes, err = json.Marshal(generateUserAuthReq{})
 		require.NoError(t, err)
@@ -2191,11 +2646,7 @@ func TestCreateAuthorizations(t *testing.T) {
 		err = userStore.Put(uData.ID, uBytes, storage.Tag{Name: userTagName})
 		require.NoError(t, err)
 
-		cIDBytes, err := json.Marshal(&clientData{})
-		require.NoError(t, err)
-
-		err = txnStore.Put(cID, cIDBytes)
-		require.NoError(t, err)
+		require.NoError(t, svc.saveProfileData(&profileData{ID: cID}))
 
 		uBytes, err = json.Marshal(generateUserAuthReq{})
 		require.NoError(t, err)
@@ -2230,29 +2681,73 @@ func TestSaveUserAuth(t *testing.T) {
 		require.NoError(t, err)
 		require.NotNil(t, svc)
 
-		req, err := http.NewRequest("POST", client, bytes.NewBuffer(reqBytes))
+		rr := httptest.NewRecorder()
+
+		svc.saveUserAuths(rr, newPartnerRequest(t, svc, userAuth, reqBytes))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		resp := map[string]string{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+		auths, err := svc.getUserAuthData(resp["id"])
+		require.NoError(t, err)
+		require.Equal(t, samplePartnerProfile, auths.ProfileID)
+		require.Equal(t, samplePartnerProfile, auths.Source)
+		require.NotNil(t, auths.SubmittedTime)
+	})
+
+	t.Run("source and submitted time are set by the service", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		rBytes, err := json.Marshal(userAuthData{
+			Source:        "other department",
+			SubmittedTime: util.NewTime(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)),
+			UserAuths:     userAuths,
+		})
 		require.NoError(t, err)
 
+		req := newPartnerRequest(t, svc, userAuth, rBytes)
+		require.NoError(t, svc.saveProfileData(&profileData{ID: samplePartnerProfile, Name: "Partner Dept"}))
+
+		before := time.Now()
 		rr := httptest.NewRecorder()
 
 		svc.saveUserAuths(rr, req)
 		require.Equal(t, http.StatusOK, rr.Code)
+
+		resp := map[string]string{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+		auths, err := svc.getUserAuthData(resp["id"])
+		require.NoError(t, err)
+		require.Equal(t, "Partner Dept", auths.Source)
+		require.False(t, auths.SubmittedTime.Before(before.Truncate(time.Millisecond)))
 	})
 
-	t.Run("invalid request", func(t *testing.T) {
+	t.Run("no partner credentials", func(t *testing.T) {
 		svc, err := New(&Config{
 			StoreProvider: mem.NewProvider(),
 			ComparatorURL: "http://comp.example.com",
 		})
 		require.NoError(t, err)
-		require.NotNil(t, svc)
 
-		req, err := http.NewRequest("POST", client, strings.NewReader("invalid-json"))
+		rr := httptest.NewRecorder()
+
+		svc.saveUserAuths(rr, httptest.NewRequest(http.MethodPost, userAuth, bytes.NewReader(reqBytes)))
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("invalid request", func(t *testing.T) {
+		svc, err := New(&Config{
+			StoreProvider: mem.NewProvider(),
+			ComparatorURL: "http://comp.example.com",
+		})
 		require.NoError(t, err)
+		require.NotNil(t, svc)
 
 		rr := httptest.NewRecorder()
 
-		svc.saveUserAuths(rr, req)
+		svc.saveUserAuths(rr, newPartnerRequest(t, svc, userAuth, []byte("invalid-json")))
 		require.Equal(t, http.StatusBadRequest, rr.Code)
 		require.Contains(t, rr.Body.String(), "failed to decode request")
 	})
@@ -2268,28 +2763,24 @@ func TestSaveUserAuth(t *testing.T) {
 		rBytes, err := json.Marshal(userAuthData{})
 		require.NoError(t, err)
 
-		req, err := http.NewRequest("POST", client, bytes.NewBuffer(rBytes))
-		require.NoError(t, err)
-
 		rr := httptest.NewRecorder()
 
-		svc.saveUserAuths(rr, req)
+		svc.saveUserAuths(rr, newPartnerRequest(t, svc, userAuth, rBytes))
 		require.Equal(t, http.StatusBadRequest, rr.Code)
 		require.Contains(t, rr.Body.String(), "no user auths in the request")
 	})
 
 	t.Run("db error", func(t *testing.T) {
 		svc, err := New(&Config{
-			StoreProvider: &mockstorage.Provider{
-				OpenStoreReturn: &mockstorage.Store{ErrPut: errors.New("save error")},
-			},
+			StoreProvider: mem.NewProvider(),
 			ComparatorURL: "http://comp.example.com",
 		})
 		require.NoError(t, err)
 		require.NotNil(t, svc)
 
-		req, err := http.NewRequest("POST", client, bytes.NewBuffer(reqBytes))
-		require.NoError(t, err)
+		req := newPartnerRequest(t, svc, userAuth, reqBytes)
+
+		svc.userAuthStore = &mockstorage.Store{ErrPut: errors.New("save error")}
 
 		rr := httptest.NewRecorder()
 
@@ -2340,7 +2831,7 @@ func TestExtractRequests(t *testing.T) {
 		require.Equal(t, 1, len(resp.ExtractData))
 	})
 
-	t.Run("get only 5 records", func(t *testing.T) {
+	t.Run("paginated", func(t *testing.T) {
 		svc, err := New(&Config{
 			StoreProvider: mem.NewProvider(),
 			ComparatorURL: "http://comp.example.com",
@@ -2350,34 +2841,64 @@ func TestExtractRequests(t *testing.T) {
 
 		svc.compClient = &mockComparatorClient{}
 
+		submitted := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
 		for i := 0; i < 10; i++ {
-			reqBytes, mErr := json.Marshal(userAuthData{
-				Source:        "test" + fmt.Sprint(i),
-				SubmittedTime: util.NewTime(time.Now()),
+			require.NoError(t, svc.saveUserAuthData(&userAuthData{
+				ID:            uuid.NewString(),
+				Source:        fmt.Sprintf("Dept: %d", i%2),
+				SubmittedTime: util.NewTime(submitted.Add(time.Duration(i) * time.Hour)),
 				UserAuths: []userAuthorization{
 					{ID: uuid.NewString(), Name: sampleUserName, AuthToken: uuid.NewString()},
-					{ID: uuid.NewString(), Name: sampleUserName, AuthToken: uuid.NewString()},
 				},
-			})
-			require.NoError(t, mErr)
+			}))
+		}
 
-			err = svc.userAuthStore.Put(uuid.NewString(), reqBytes, storage.Tag{Name: userTagName})
-			require.NoError(t, err)
+		getExtracts := func(query string) *extractResp {
+			rr := httptest.NewRecorder()
+
+			svc.extractRequests(rr, httptest.NewRequest(http.MethodGet, userExtract+query, nil))
+			require.Equal(t, http.StatusOK, rr.Code)
+
+			var resp *extractResp
+
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+			return resp
 		}
 
-		req, err := http.NewRequest(http.MethodGet, userExtract, nil)
-		require.NoError(t, err)
+		resp := getExtracts("?limit=5")
+		require.Equal(t, 10, resp.Total)
+		require.Len(t, resp.ExtractData, 5)
+		require.True(t, resp.ExtractData[0].SubmittedTime.After(resp.ExtractData[4].SubmittedTime.Time))
+		require.NotEmpty(t, resp.NextCursor)
 
-		rr := httptest.NewRecorder()
+		resp = getExtracts("?limit=5&cursor=" + resp.NextCursor)
+		require.Len(t, resp.ExtractData, 5)
+		require.Equal(t, submitted, resp.ExtractData[4].SubmittedTime.UTC())
+		require.Empty(t, resp.NextCursor)
 
-		svc.extractRequests(rr, req)
-		require.Equal(t, http.StatusOK, rr.Code)
+		resp = getExtracts("?source=" + url.QueryEscape("Dept: 1") + "&from=2021-06-01T05:00:00Z")
+		require.Equal(t, 3, resp.Total)
+		require.Len(t, resp.ExtractData, 3)
 
-		var resp *extractResp
+		for _, e := range resp.ExtractData {
+			require.Equal(t, "Dept: 1", e.Source)
+		}
+	})
 
-		err = json.Unmarshal(rr.Body.Bytes(), &resp)
+	t.Run("invalid request", func(t *testing.T) {
+		svc, err := New(&Config{
+			StoreProvider: mem.NewProvider(),
+			ComparatorURL: "http://comp.example.com",
+		})
 		require.NoError(t, err)
-		require.Equal(t, 5, len(resp.ExtractData))
+
+		rr := httptest.NewRecorder()
+
+		svc.extractRequests(rr, httptest.NewRequest(http.MethodGet, userExtract+"?limit=0", nil))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "limit must be between 1 and 100")
 	})
 
 	t.Run("db error", func(t *testing.T) {
@@ -2397,7 +2918,7 @@ func TestExtractRequests(t *testing.T) {
 
 		svc.extractRequests(rr, req)
 		require.Equal(t, http.StatusInternalServerError, rr.Code)
-		require.Contains(t, rr.Body.String(), "failed to get user auth data: get all user auth data")
+		require.Contains(t, rr.Body.String(), "failed to get user auth data: query user : query error")
 	})
 
 	t.Run("invalid data in the db", func(t *testing.T) {
@@ -2418,144 +2939,197 @@ func TestExtractRequests(t *testing.T) {
 
 		svc.extractRequests(rr, req)
 		require.Equal(t, http.StatusInternalServerError, rr.Code)
-		require.Contains(t, rr.Body.String(), "failed to get user auth data: unamrshal user auth data invalid-data")
+		require.Contains(t, rr.Body.String(),
+			"failed to get user auth data: failed to read user index: unamrshal user auth data invalid-data")
 	})
 }
 
-func TestGetUserExtract(t *testing.T) {
-	uData := userAuthData{
-		Source:        "test",
-		SubmittedTime: util.NewTime(time.Now()),
-		UserAuths: []userAuthorization{
-			{ID: uuid.NewString(), Name: sampleUserName, AuthToken: uuid.NewString()},
-			{ID: uuid.NewString(), Name: sampleUserName, AuthToken: uuid.NewString()},
-		},
+// testOperationOptions are the config of the operation of a test and the records seeded in its stores.
+type testOperationOptions struct {
+	config *Config
+	seeds  []func(t *testing.T, svc *Operation)
+}
+
+type testOperationOption func(opts *testOperationOptions)
+
+// newTestOperation returns an operation on a memory store, with the config and the seeded records of the options.
+func newTestOperation(t *testing.T, opts ...testOperationOption) *Operation {
+	t.Helper()
+
+	options := &testOperationOptions{
+		config: &Config{StoreProvider: mem.NewProvider(), ComparatorURL: "http://comp.example.com"},
 	}
 
-	uBytes, err := json.Marshal(uData)
-	require.NoError(t, err)
+	for _, opt := range opts {
+		opt(options)
+	}
 
-	t.Run("success", func(t *testing.T) {
-		svc, err := New(&Config{
-			StoreProvider: mem.NewProvider(),
-			ComparatorURL: "http://comp.example.com",
-		})
-		require.NoError(t, err)
-		require.NotNil(t, svc)
+	svc, err := New(options.config)
+	require.NoError(t, err)
 
-		svc.compClient = &mockComparatorClient{}
+	t.Cleanup(svc.Close)
 
-		id := uuid.NewString()
+	for _, seed := range options.seeds {
+		seed(t, svc)
+	}
 
-		err = svc.userAuthStore.Put(id, uBytes, storage.Tag{Name: userTagName})
-		require.NoError(t, err)
+	return svc
+}
 
-		req, err := http.NewRequest(http.MethodGet, userExtract+"/"+id, nil)
-		require.NoError(t, err)
-		require.NoError(t, err)
+// withConfig changes the config of the operation.
+func withConfig(f func(config *Config)) testOperationOption {
+	return func(opts *testOperationOptions) {
+		f(opts.config)
+	}
+}
 
-		rr := httptest.NewRecorder()
+// withDIDResolution resolves every DID of the clients and the profiles.
+func withDIDResolution() testOperationOption {
+	return withConfig(func(config *Config) {
+		config.VDRI = &vdrmock.MockVDRegistry{ResolveValue: &did.Doc{}}
+	})
+}
 
-		svc.getUserExtract(rr, req)
-		require.Equal(t, http.StatusOK, rr.Code)
+// withSeed runs the function on the operation once it is created.
+func withSeed(f func(t *testing.T, svc *Operation)) testOperationOption {
+	return func(opts *testOperationOptions) {
+		opts.seeds = append(opts.seeds, f)
+	}
+}
 
-		var resp *getExtractData
+func withVaultClient(vClient VaultClient) testOperationOption {
+	return withSeed(func(t *testing.T, svc *Operation) {
+		svc.vClient = vClient
+	})
+}
 
-		err = json.Unmarshal(rr.Body.Bytes(), &resp)
-		require.NoError(t, err)
-		require.Equal(t, 2, len(resp.Data))
+func withComparatorClient(compClient ComparatorClient) testOperationOption {
+	return withSeed(func(t *testing.T, svc *Operation) {
+		svc.compClient = compClient
 	})
+}
 
-	t.Run("db error", func(t *testing.T) {
-		svc, err := New(&Config{
-			StoreProvider: &mockstorage.Provider{
-				OpenStoreReturn: &mockstorage.Store{ErrGet: errors.New("get error")},
-			},
-			ComparatorURL: "http://comp.example.com",
-		})
-		require.NoError(t, err)
-		require.NotNil(t, svc)
+func withHTTPClient(client httpClient) testOperationOption {
+	return withSeed(func(t *testing.T, svc *Operation) {
+		svc.httpClient = client
+	})
+}
 
-		req, err := http.NewRequest(http.MethodGet, userExtract+"/"+uuid.NewString(), nil)
-		require.NoError(t, err)
+func withClientData(data *clientData) testOperationOption {
+	return withSeed(func(t *testing.T, svc *Operation) {
+		require.NoError(t, svc.saveClientData(data))
+	})
+}
 
-		rr := httptest.NewRecorder()
+func withProfileData(data *profileData) testOperationOption {
+	return withSeed(func(t *testing.T, svc *Operation) {
+		require.NoError(t, svc.saveProfileData(data))
+	})
+}
 
-		svc.getUserExtract(rr, req)
-		require.Equal(t, http.StatusBadRequest, rr.Code)
-		require.Contains(t, rr.Body.String(), "failed to get user auth data")
+// withProfile saves the profile with its client secret.
+func withProfile(req *profileReq) testOperationOption {
+	return withSeed(func(t *testing.T, svc *Operation) {
+		require.NoError(t, svc.saveProfile(req))
 	})
+}
 
-	t.Run("invalid data in the db", func(t *testing.T) {
-		svc, err := New(&Config{
-			StoreProvider: mem.NewProvider(),
-			ComparatorURL: "http://comp.example.com",
-		})
+// withUserData saves the data of the user, and the user in the list of the users if the user has an id.
+func withUserData(data *userData) testOperationOption {
+	return withSeed(func(t *testing.T, svc *Operation) {
+		uDataBytes, err := json.Marshal(data)
 		require.NoError(t, err)
-		require.NotNil(t, svc)
 
-		id := uuid.NewString()
+		require.NoError(t, svc.store.Put(data.UserName, uDataBytes))
 
-		err = svc.userAuthStore.Put(id, []byte("invalid-data"), storage.Tag{Name: userTagName})
-		require.NoError(t, err)
+		if data.ID == "" {
+			return
+		}
 
-		req, err := http.NewRequest(http.MethodGet, userExtract+"/"+id, nil)
+		uBytes, err := json.Marshal(&userIDNameMap{ID: data.ID, UserName: data.UserName})
 		require.NoError(t, err)
 
-		rr := httptest.NewRecorder()
-
-		svc.getUserExtract(rr, req)
-		require.Equal(t, http.StatusBadRequest, rr.Code)
-		require.Contains(t, rr.Body.String(), "failed to unmarshal user auth data")
+		require.NoError(t, svc.userStore.Put(data.ID, uBytes, storage.Tag{Name: userTagName}))
 	})
+}
 
-	t.Run("extract api error", func(t *testing.T) {
-		svc, err := New(&Config{
-			StoreProvider: mem.NewProvider(),
-			ComparatorURL: "http://comp.example.com",
-		})
-		require.NoError(t, err)
-		require.NotNil(t, svc)
+// testClientData returns the client registered with the secret "secret1".
+func testClientData() *clientData {
+	return &clientData{
+		ClientID:   "client1",
+		Callback:   "https://client.example.com/callback",
+		SecretHash: hashClientSecret("secret1"),
+	}
+}
 
-		svc.compClient = &mockComparatorClient{PostExtractErr: errors.New("extract api error")}
+// testPartnerClientData returns the client of the service of the profile returned by testProfileData.
+func testPartnerClientData() *clientData {
+	data := testClientData()
+	data.ProfileID = "profile1"
 
-		id := uuid.NewString()
+	return data
+}
 
-		err = svc.userAuthStore.Put(id, uBytes, storage.Tag{Name: userTagName})
-		require.NoError(t, err)
+// testProfileData returns the profile of a linked service, with the client credentials of this service.
+func testProfileData() *profileReq {
+	return &profileReq{
+		profileData: profileData{
+			ID:       "profile1",
+			URL:      "https://profile.example.com",
+			DID:      "did:example:123",
+			ClientID: "rp1",
+		},
+		ClientSecret: "rpsecret",
+	}
+}
 
-		req, err := http.NewRequest(http.MethodGet, userExtract+"/"+id, nil)
-		require.NoError(t, err)
+// testUserData returns the data of the user sampleUserName.
+func testUserData() *userData {
+	return &userData{ID: "U1", UserName: sampleUserName, VaultID: "did:example:123"}
+}
 
-		rr := httptest.NewRecorder()
+// withUserCreation configures the vault, the DID resolution and the issuer needed to create the users.
+func withUserCreation(t *testing.T, vClient *mockVaultClient) testOperationOption {
+	t.Helper()
 
-		svc.getUserExtract(rr, req)
-		require.Equal(t, http.StatusInternalServerError, rr.Code)
-		require.Contains(t, rr.Body.String(), "failed to extract data")
+	return func(opts *testOperationOptions) {
+		withDIDResolution()(opts)
+		withConfig(func(config *Config) {
+			config.RequestTokens = map[string]string{vcsIssuerRequestTokenName: "test"}
+			config.DocumentLoader = createTestDocumentLoader(t)
+		})(opts)
+		withVaultClient(vClient)(opts)
+		withHTTPClient(&mockHTTPClient{doFunc: mockHTTPResponse(t, nil, nil)})(opts)
+	}
+}
 
-		svc.compClient = &mockComparatorClient{PostExtractResp: &compclientops.PostExtractOK{Payload: &compmodel.ExtractResp{
-			Documents: []*compmodel.ExtractRespDocumentsItems0{},
-		}}}
+func withHomePageHTML(path string) testOperationOption {
+	return withConfig(func(config *Config) {
+		config.HomePageHTML = path
+	})
+}
 
-		rr = httptest.NewRecorder()
+func withDashboardHTML(path string) testOperationOption {
+	return withConfig(func(config *Config) {
+		config.DashboardHTML = path
+	})
+}
 
-		svc.getUserExtract(rr, req)
-		require.Equal(t, http.StatusInternalServerError, rr.Code)
-		require.Contains(t, rr.Body.String(), "count of documents 0 doesnt match the expected 2")
+// newTestHTMLFile creates an empty template, deleted at the end of the test.
+func newTestHTMLFile(t *testing.T) string {
+	t.Helper()
 
-		svc.compClient = &mockComparatorClient{PostExtractResp: &compclientops.PostExtractOK{Payload: &compmodel.ExtractResp{
-			Documents: []*compmodel.ExtractRespDocumentsItems0{
-				{ID: uuid.NewString(), Contents: 1256},
-				{ID: uuid.NewString(), Contents: "1256"},
-			},
-		}}}
+	file, err := ioutil.TempFile("", "*.html")
+	require.NoError(t, err)
 
-		rr = httptest.NewRecorder()
+	t.Cleanup(func() { require.NoError(t, os.Remove(file.Name())) })
 
-		svc.getUserExtract(rr, req)
-		require.Equal(t, http.StatusInternalServerError, rr.Code)
-		require.Contains(t, rr.Body.String(), "invalid content; expected string type")
-	})
+	return file.Name()
+}
+
+// newMockStoreProvider returns a provider of empty stores, which the extract workers can query on start.
+func newMockStoreProvider() *mockstorage.Provider {
+	return &mockstorage.Provider{OpenStoreReturn: &mockstorage.Store{QueryReturn: &mockstorage.Iterator{}}}
 }
 
 func createTestDocumentLoader(t *testing.T) *jsonld.DocumentLoader {
@@ -2654,6 +3228,11 @@ type mockVaultClient struct {
 	SaveDocErr              error
 	CreateAuthorizationErr  error
 	CreateAuthorizationResp *vault.CreatedAuthorization
+	DeleteAuthorizationErr  error
+	DeleteVaultErr          error
+	deletedAuthorizations   []string
+	deletedVaults           []string
+	authorizationScopes     []*vault.AuthorizationsScope
 }
 
 func (m *mockVaultClient) CreateVault() (*vault.CreatedVault, error) {
@@ -2676,6 +3255,8 @@ func (m *mockVaultClient) SaveDoc(vaultID, id string, content interface{}) (*vau
 
 func (m *mockVaultClient) CreateAuthorization(vaultID, requestingParty string,
 	scope *vault.AuthorizationsScope) (*vault.CreatedAuthorization, error) {
+	m.authorizationScopes = append(m.authorizationScopes, scope)
+
 	if m.CreateAuthorizationErr != nil {
 		return nil, m.CreateAuthorizationErr
 	}
@@ -2684,7 +3265,30 @@ func (m *mockVaultClient) CreateAuthorization(vaultID, requestingParty string,
 		return m.CreateAuthorizationResp, nil
 	}
 
-	return &vault.CreatedAuthorization{Tokens: &vault.Tokens{EDV: uuid.New().String(), KMS: uuid.New().String()}}, nil
+	return &vault.CreatedAuthorization{
+		ID:     uuid.New().String(),
+		Tokens: &vault.Tokens{EDV: uuid.New().String(), KMS: uuid.New().String()},
+	}, nil
+}
+
+func (m *mockVaultClient) DeleteVault(vaultID string) error {
+	if m.DeleteVaultErr != nil {
+		return m.DeleteVaultErr
+	}
+
+	m.deletedVaults = append(m.deletedVaults, vaultID)
+
+	return nil
+}
+
+func (m *mockVaultClient) DeleteAuthorization(vaultID, authorizationID string) error {
+	if m.DeleteAuthorizationErr != nil {
+		return m.DeleteAuthorizationErr
+	}
+
+	m.deletedAuthorizations = append(m.deletedAuthorizations, authorizationID)
+
+	return nil
 }
 
 type mockComparatorClient struct {
@@ -2696,6 +3300,8 @@ type mockComparatorClient struct {
 	PostCompareResp        *compclientops.PostCompareOK
 	PostExtractErr         error
 	PostExtractResp        *compclientops.PostExtractOK
+	postExtractFunc        func(*compclientops.PostExtractParams) (*compclientops.PostExtractOK, error)
+	authorizations         []*compmodel.Authorization
 }
 
 func (m *mockComparatorClient) GetConfig(params *compclientops.GetConfigParams) (*compclientops.GetConfigOK, error) {
@@ -2716,6 +3322,8 @@ func (m *mockComparatorClient) GetConfig(params *compclientops.GetConfigParams)
 
 func (m *mockComparatorClient) PostAuthorizations(
 	params *compclientops.PostAuthorizationsParams) (*compclientops.PostAuthorizationsOK, error) {
+	m.authorizations = append(m.authorizations, params.Authorization)
+
 	if m.PostAuthorizationsErr != nil {
 		return nil, m.PostAuthorizationsErr
 	}
@@ -2742,6 +3350,10 @@ func (m *mockComparatorClient) PostCompare(
 
 func (m *mockComparatorClient) PostExtract(
 	params *compclientops.PostExtractParams) (*compclientops.PostExtractOK, error) {
+	if m.postExtractFunc != nil {
+		return m.postExtractFunc(params)
+	}
+
 	if m.PostExtractErr != nil {
 		return nil, m.PostExtractErr
 	}
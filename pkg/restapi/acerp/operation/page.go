@@ -0,0 +1,542 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+)
+
+const (
+	// query params
+	cursorQueryParam = "cursor"
+	limitQueryParam  = "limit"
+	fromQueryParam   = "from"
+	toQueryParam     = "to"
+	sourceQueryParam = "source"
+	orderQueryParam  = "order"
+
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+
+	// index tags
+	createdTagName = "created"
+	sourceTagName  = "source"
+
+	// createdTagFormat sorts lexically and has no ':', which separates the tag name and the value in the queries.
+	createdTagFormat = "20060102150405.000000000"
+
+	sortAscending  = "asc"
+	sortDescending = "desc"
+
+	cursorSeparator = "/"
+)
+
+type pageRequest struct {
+	limit int
+	// last is the last item of the previous page, and offset the number of the items before the page
+	last   *indexEntry
+	offset int
+}
+
+// getPageRequest reads the page query params. The cursor is opaque to the callers, it holds the position and the
+// index of the last item of the previous page.
+func getPageRequest(r *http.Request) (*pageRequest, error) {
+	page := &pageRequest{limit: defaultPageLimit}
+
+	if limit := r.URL.Query().Get(limitQueryParam); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l < 1 || l > maxPageLimit {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+
+		page.limit = l
+	}
+
+	if cursor := r.URL.Query().Get(cursorQueryParam); cursor != "" {
+		c, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return nil, errors.New("invalid cursor")
+		}
+
+		parts := strings.SplitN(string(c), cursorSeparator, 3)
+		if len(parts) != 3 {
+			return nil, errors.New("invalid cursor")
+		}
+
+		page.offset, err = strconv.Atoi(parts[0])
+		if err != nil || page.offset < 0 {
+			return nil, errors.New("invalid cursor")
+		}
+
+		page.last = &indexEntry{created: parts[1], key: parts[2]}
+	}
+
+	return page, nil
+}
+
+// queryPage returns the values of the page of the items with the tag ordered by key, and the cursor of the next
+// page, which is empty for the last page. Only the values of the page are read.
+func queryPage(store storage.Store, tagName string, page *pageRequest) ([][]byte, string, error) {
+	result, err := queryIndex(store, tagName, &listRequest{pageRequest: page, ascending: true, byKey: true}, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(result.keys) == 0 {
+		return [][]byte{}, "", nil
+	}
+
+	values, err := store.GetBulk(result.keys...)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get %s values: %w", tagName, err)
+	}
+
+	// an item deleted since the query has no value
+	found := make([][]byte, 0, len(values))
+
+	for _, v := range values {
+		if v != nil {
+			found = append(found, v)
+		}
+	}
+
+	return found, result.next, nil
+}
+
+// forEachValue reads the values of the items with the tag one at a time, until f fails.
+func forEachValue(store storage.Store, tagName string, f func(value []byte) error) error {
+	iter, err := store.Query(tagName)
+	if err != nil {
+		return fmt.Errorf("query %s : %w", tagName, err)
+	}
+
+	return iterate(iter, tagName, func(iter storage.Iterator) error {
+		value, err := iter.Value()
+		if err != nil {
+			return fmt.Errorf("failed to get %s value: %w", tagName, err)
+		}
+
+		return f(value)
+	})
+}
+
+// iterate calls f for each item of the iterator until f fails, and closes the iterator.
+func iterate(iter storage.Iterator, tagName string, f func(iter storage.Iterator) error) error {
+	defer closeIterator(iter, tagName)
+
+	more, err := iter.Next()
+	if err != nil {
+		return fmt.Errorf("failed to get next %s: %w", tagName, err)
+	}
+
+	for more {
+		err = f(iter)
+		if err != nil {
+			return err
+		}
+
+		more, err = iter.Next()
+		if err != nil {
+			return fmt.Errorf("failed to get next %s: %w", tagName, err)
+		}
+	}
+
+	return nil
+}
+
+type listRequest struct {
+	*pageRequest
+	from      string
+	to        string
+	source    string
+	ascending bool
+	// byKey orders the items which have no index tags by key
+	byKey bool
+}
+
+// getListRequest reads the page, the creation date range (RFC 3339) and the sort order query params. The newest items
+// are listed first by default.
+func getListRequest(r *http.Request) (*listRequest, error) {
+	page, err := getPageRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &listRequest{pageRequest: page}
+
+	for param, value := range map[string]*string{fromQueryParam: &req.from, toQueryParam: &req.to} {
+		v := r.URL.Query().Get(param)
+		if v == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s date: %w", param, err)
+		}
+
+		*value = t.UTC().Format(createdTagFormat)
+	}
+
+	switch r.URL.Query().Get(orderQueryParam) {
+	case "", sortDescending:
+	case sortAscending:
+		req.ascending = true
+	default:
+		return nil, fmt.Errorf("order must be %s or %s", sortAscending, sortDescending)
+	}
+
+	return req, nil
+}
+
+type indexEntry struct {
+	key     string
+	created string
+	source  string
+}
+
+// indexFunc reads the index of the items saved before they were tagged.
+type indexFunc func(value []byte) (created time.Time, source string, err error)
+
+func (e *indexEntry) before(other *indexEntry) bool {
+	if e.created != other.created {
+		return e.created < other.created
+	}
+
+	return e.key < other.key
+}
+
+// cursor returns the cursor of the page after the entry, which is at the offset.
+func (e *indexEntry) cursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString(
+		[]byte(strconv.Itoa(offset) + cursorSeparator + e.created + cursorSeparator + e.key))
+}
+
+type indexPage struct {
+	keys  []string
+	total int
+	next  string
+}
+
+func createdTag(t time.Time) storage.Tag {
+	return storage.Tag{Name: createdTagName, Value: t.UTC().Format(createdTagFormat)}
+}
+
+// sourceTag encodes the source, which is a free text name that may contain ':'.
+func sourceTag(source string) storage.Tag {
+	return storage.Tag{Name: sourceTagName, Value: base64.RawURLEncoding.EncodeToString([]byte(source))}
+}
+
+// queryIndex returns the keys of the page of the items with the tag, or with the source of the request. The items are
+// filtered and sorted on their index tags so that only the values of the page need to be read. The stores which
+// support it sort the items on the created tag and start at the page of the cursor; the mem and MySQL providers
+// don't, so their items are sorted in memory, as are the items ordered by key.
+func queryIndex(store storage.Store, tagName string, req *listRequest, legacyIndex indexFunc) (*indexPage, error) {
+	expression := tagName
+
+	if req.source != "" {
+		tag := sourceTag(req.source)
+		expression = tag.Name + ":" + tag.Value
+	}
+
+	order := storage.SortDescending
+	if req.ascending {
+		order = storage.SortAscending
+	}
+
+	// the positions of the items are known only if they aren't filtered after the query. The page before the one of
+	// the cursor is read again, as the items created or deleted since may have moved the cursor to it.
+	pageNum := 0
+	if req.from == "" && req.to == "" && req.offset >= req.limit {
+		pageNum = req.offset/req.limit - 1
+	}
+
+	if !req.byKey {
+		iter, err := store.Query(expression,
+			storage.WithSortOrder(&storage.SortOptions{Order: order, TagName: createdTagName}),
+			storage.WithPageSize(req.limit), storage.WithInitialPageNum(pageNum))
+		if err == nil {
+			return readSortedIndex(iter, tagName, req, pageNum*req.limit, legacyIndex)
+		}
+	}
+
+	iter, err := store.Query(expression)
+	if err != nil {
+		return nil, fmt.Errorf("query %s : %w", tagName, err)
+	}
+
+	entries := make([]*indexEntry, 0)
+
+	err = forEachEntry(iter, tagName, legacyIndex, func(entry *indexEntry) {
+		if req.matches(entry) {
+			entries = append(entries, entry)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return req.page(entries), nil
+}
+
+// readSortedIndex reads the page after the cursor from the items sorted by the store. The skipped items are before
+// the cursor, and are counted in the total.
+func readSortedIndex(iter storage.Iterator, tagName string, req *listRequest, skipped int,
+	legacyIndex indexFunc) (*indexPage, error) {
+	result := &indexPage{keys: make([]string, 0, req.limit), total: skipped}
+
+	var last *indexEntry
+
+	err := forEachEntry(iter, tagName, legacyIndex, func(entry *indexEntry) {
+		if !req.matches(entry) {
+			return
+		}
+
+		result.total++
+
+		if req.last != nil && !req.after(entry, req.last) {
+			return
+		}
+
+		if len(result.keys) < req.limit {
+			result.keys = append(result.keys, entry.key)
+			last = entry
+
+			return
+		}
+
+		if result.next == "" {
+			result.next = last.cursor(result.total - 1)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// forEachEntry reads the index of the items of the iterator, and closes it.
+func forEachEntry(iter storage.Iterator, tagName string, legacyIndex indexFunc, f func(entry *indexEntry)) error {
+	return iterate(iter, tagName, func(iter storage.Iterator) error {
+		entry, err := readIndexEntry(iter, tagName, legacyIndex)
+		if err != nil {
+			return err
+		}
+
+		f(entry)
+
+		return nil
+	})
+}
+
+func closeIterator(iter storage.Iterator, tagName string) {
+	err := iter.Close()
+	if err != nil {
+		logger.Warnf("failed to close %s iterator: %s", tagName, err.Error())
+	}
+}
+
+// indexLegacyItems adds the index tags to the items with the tag which were saved before the items were tagged, so
+// that the stores can sort them and the queries of a source find them.
+func indexLegacyItems(store storage.Store, tagName string, legacyIndex indexFunc) error {
+	iter, err := store.Query(tagName)
+	if err != nil {
+		return fmt.Errorf("query %s : %w", tagName, err)
+	}
+
+	items := make([]*legacyItem, 0)
+
+	err = iterate(iter, tagName, func(iter storage.Iterator) error {
+		tags, err := iter.Tags()
+		if err != nil {
+			return fmt.Errorf("failed to get %s tags: %w", tagName, err)
+		}
+
+		if hasTag(tags, createdTagName) {
+			return nil
+		}
+
+		item, err := readLegacyItem(iter, tagName, tags, legacyIndex)
+		if err != nil {
+			return err
+		}
+
+		items = append(items, item)
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// the items are saved once the iterator is read, as the stores may lock the items of a query
+	for _, item := range items {
+		err = store.Put(item.key, item.value, item.tags...)
+		if err != nil {
+			return fmt.Errorf("failed to index %s %s: %w", tagName, item.key, err)
+		}
+	}
+
+	return nil
+}
+
+type legacyItem struct {
+	key   string
+	value []byte
+	tags  []storage.Tag
+}
+
+// readLegacyItem reads the item, and adds the index tags to its tags.
+func readLegacyItem(iter storage.Iterator, tagName string, tags []storage.Tag,
+	legacyIndex indexFunc) (*legacyItem, error) {
+	key, err := iter.Key()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s key: %w", tagName, err)
+	}
+
+	value, err := iter.Value()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s value: %w", tagName, err)
+	}
+
+	created, source, err := legacyIndex(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s index: %w", tagName, err)
+	}
+
+	item := &legacyItem{key: key, value: value, tags: append(tags, createdTag(created))}
+
+	if source != "" && !hasTag(tags, sourceTagName) {
+		item.tags = append(item.tags, sourceTag(source))
+	}
+
+	return item, nil
+}
+
+func hasTag(tags []storage.Tag, name string) bool {
+	for _, t := range tags {
+		if t.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func readIndexEntry(iter storage.Iterator, tagName string, legacyIndex indexFunc) (*indexEntry, error) {
+	key, err := iter.Key()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s key: %w", tagName, err)
+	}
+
+	tags, err := iter.Tags()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s tags: %w", tagName, err)
+	}
+
+	entry := &indexEntry{key: key}
+
+	for _, t := range tags {
+		switch t.Name {
+		case createdTagName:
+			entry.created = t.Value
+		case sourceTagName:
+			source, err := base64.RawURLEncoding.DecodeString(t.Value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s source tag: %w", tagName, err)
+			}
+
+			entry.source = string(source)
+		}
+	}
+
+	// the items ordered by key have no index
+	if entry.created != "" || legacyIndex == nil {
+		return entry, nil
+	}
+
+	value, err := iter.Value()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s value: %w", tagName, err)
+	}
+
+	created, source, err := legacyIndex(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s index: %w", tagName, err)
+	}
+
+	entry.created = created.UTC().Format(createdTagFormat)
+	entry.source = source
+
+	return entry, nil
+}
+
+func (r *listRequest) matches(e *indexEntry) bool {
+	if r.from != "" && e.created < r.from {
+		return false
+	}
+
+	if r.to != "" && e.created > r.to {
+		return false
+	}
+
+	return r.source == "" || e.source == r.source
+}
+
+// after tells if the entry is after the other in the order of the request.
+func (r *listRequest) after(e, other *indexEntry) bool {
+	if r.ascending {
+		return other.before(e)
+	}
+
+	return e.before(other)
+}
+
+// page sorts the entries and cuts the page after the cursor. The total is the number of the entries matching the
+// filters, in all the pages.
+func (r *listRequest) page(entries []*indexEntry) *indexPage {
+	sort.Slice(entries, func(i, j int) bool {
+		if r.ascending {
+			return entries[i].before(entries[j])
+		}
+
+		return entries[j].before(entries[i])
+	})
+
+	start := 0
+
+	if r.last != nil {
+		start = sort.Search(len(entries), func(i int) bool { return r.after(entries[i], r.last) })
+	}
+
+	end := start + r.limit
+	if end > len(entries) {
+		end = len(entries)
+	}
+
+	result := &indexPage{keys: make([]string, 0, end-start), total: len(entries)}
+
+	for _, e := range entries[start:end] {
+		result.keys = append(result.keys, e.key)
+	}
+
+	if end < len(entries) {
+		result.next = entries[end-1].cursor(end)
+	}
+
+	return result
+}
@@ -0,0 +1,401 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	mockstorage "github.com/hyperledger/aries-framework-go/component/storageutil/mock"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetPageRequest(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		page, err := getPageRequest(httptest.NewRequest(http.MethodGet, "/items", nil))
+		require.NoError(t, err)
+		require.Equal(t, defaultPageLimit, page.limit)
+		require.Nil(t, page.last)
+	})
+
+	t.Run("cursor and limit", func(t *testing.T) {
+		cursor := (&indexEntry{key: "key1/a"}).cursor(3)
+
+		page, err := getPageRequest(httptest.NewRequest(http.MethodGet, "/items?limit=5&cursor="+cursor, nil))
+		require.NoError(t, err)
+		require.Equal(t, 5, page.limit)
+		require.Equal(t, 3, page.offset)
+		require.Equal(t, &indexEntry{key: "key1/a"}, page.last)
+	})
+
+	t.Run("invalid limit", func(t *testing.T) {
+		for _, limit := range []string{"0", "101", "-1", "abc"} {
+			_, err := getPageRequest(httptest.NewRequest(http.MethodGet, "/items?limit="+limit, nil))
+			require.EqualError(t, err, "limit must be between 1 and 100")
+		}
+	})
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		for _, cursor := range []string{
+			"!!",
+			base64.RawURLEncoding.EncodeToString([]byte("key1")),
+			base64.RawURLEncoding.EncodeToString([]byte("-1//key1")),
+		} {
+			_, err := getPageRequest(httptest.NewRequest(http.MethodGet, "/items?cursor="+cursor, nil))
+			require.EqualError(t, err, "invalid cursor", cursor)
+		}
+	})
+}
+
+func TestQueryPage(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		store, err := mem.NewProvider().OpenStore("test")
+		require.NoError(t, err)
+
+		for _, k := range []string{"c", "a", "b"} {
+			require.NoError(t, store.Put(k, []byte(k), storage.Tag{Name: "item"}))
+		}
+
+		require.NoError(t, store.Put("d", []byte("d")))
+
+		values, next, err := queryPage(store, "item", &pageRequest{limit: 2})
+		require.NoError(t, err)
+		require.Equal(t, [][]byte{[]byte("a"), []byte("b")}, values)
+		require.NotEmpty(t, next)
+
+		values, next, err = queryPage(store, "item", newListRequest(t, 2, next).pageRequest)
+		require.NoError(t, err)
+		require.Equal(t, [][]byte{[]byte("c")}, values)
+		require.Empty(t, next)
+
+		values, next, err = queryPage(store, "item", &pageRequest{limit: 3})
+		require.NoError(t, err)
+		require.Len(t, values, 3)
+		require.Empty(t, next)
+	})
+
+	t.Run("store errors", func(t *testing.T) {
+		_, _, err := queryPage(&mockstorage.Store{ErrQuery: errors.New("query error")}, "item",
+			&pageRequest{limit: 1})
+		require.EqualError(t, err, "query item : query error")
+
+		_, _, err = queryPage(&mockstorage.Store{QueryReturn: &mockstorage.Iterator{ErrNext: errors.New("next error")}},
+			"item", &pageRequest{limit: 1})
+		require.EqualError(t, err, "failed to get next item: next error")
+
+		_, _, err = queryPage(&mockstorage.Store{
+			QueryReturn: &mockstorage.Iterator{NextReturn: true, ErrKey: errors.New("key error")},
+		}, "item", &pageRequest{limit: 1})
+		require.EqualError(t, err, "failed to get item key: key error")
+
+		store, err := mem.NewProvider().OpenStore("test")
+		require.NoError(t, err)
+		require.NoError(t, store.Put("a", []byte("a"), storage.Tag{Name: "item"}))
+
+		_, _, err = queryPage(&getBulkErrStore{Store: store}, "item", &pageRequest{limit: 1})
+		require.EqualError(t, err, "failed to get item values: get bulk error")
+	})
+}
+
+func TestForEachValue(t *testing.T) {
+	store, err := mem.NewProvider().OpenStore("test")
+	require.NoError(t, err)
+
+	for _, k := range []string{"a", "b"} {
+		require.NoError(t, store.Put(k, []byte(k), storage.Tag{Name: "item"}))
+	}
+
+	count := 0
+
+	require.NoError(t, forEachValue(store, "item", func(value []byte) error {
+		count++
+
+		return nil
+	}))
+	require.Equal(t, 2, count)
+
+	err = forEachValue(store, "item", func(value []byte) error {
+		return errors.New("value error")
+	})
+	require.EqualError(t, err, "value error")
+
+	err = forEachValue(&mockstorage.Store{ErrQuery: errors.New("query error")}, "item", nil)
+	require.EqualError(t, err, "query item : query error")
+
+	err = forEachValue(&mockstorage.Store{
+		QueryReturn: &mockstorage.Iterator{NextReturn: true, ErrValue: errors.New("value error")},
+	}, "item", nil)
+	require.EqualError(t, err, "failed to get item value: value error")
+}
+
+func TestQueryIndex(t *testing.T) {
+	legacyIndex := func(value []byte) (time.Time, string, error) {
+		created, err := time.Parse(time.RFC3339, string(value))
+
+		return created, "legacy", err
+	}
+
+	t.Run("success", func(t *testing.T) {
+		store, err := mem.NewProvider().OpenStore("test")
+		require.NoError(t, err)
+
+		created := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
+		require.NoError(t, store.Put("a", []byte("a"), storage.Tag{Name: "item"}, createdTag(created),
+			sourceTag("src:1")))
+		require.NoError(t, store.Put("b", []byte("b"), storage.Tag{Name: "item"}, createdTag(created),
+			sourceTag("src:2")))
+		require.NoError(t, store.Put("c", []byte("2021-05-01T00:00:00Z"), storage.Tag{Name: "item"}))
+
+		page, err := queryIndex(store, "item", &listRequest{pageRequest: &pageRequest{limit: 2}}, legacyIndex)
+		require.NoError(t, err)
+		require.Equal(t, []string{"b", "a"}, page.keys)
+		require.Equal(t, 3, page.total)
+
+		page, err = queryIndex(store, "item", newListRequest(t, 2, page.next), legacyIndex)
+		require.NoError(t, err)
+		require.Equal(t, []string{"c"}, page.keys)
+		require.Empty(t, page.next)
+
+		page, err = queryIndex(store, "item",
+			&listRequest{pageRequest: &pageRequest{limit: 2}, source: "src:2"}, legacyIndex)
+		require.NoError(t, err)
+		require.Equal(t, []string{"b"}, page.keys)
+		require.Equal(t, 1, page.total)
+
+		// the source of the legacy item is queried once the item is indexed
+		req := &listRequest{pageRequest: &pageRequest{limit: 2}, source: "legacy", ascending: true}
+
+		page, err = queryIndex(store, "item", req, legacyIndex)
+		require.NoError(t, err)
+		require.Empty(t, page.keys)
+
+		require.NoError(t, indexLegacyItems(store, "item", legacyIndex))
+
+		page, err = queryIndex(store, "item", req, legacyIndex)
+		require.NoError(t, err)
+		require.Equal(t, []string{"c"}, page.keys)
+	})
+
+	t.Run("sorted by the store", func(t *testing.T) {
+		store := newSortingStore(t)
+		created := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
+		for i, key := range []string{"a", "b", "c", "d", "e"} {
+			require.NoError(t, store.Put(key, []byte(key), storage.Tag{Name: "item"},
+				createdTag(created.Add(time.Duration(i)*time.Hour))))
+		}
+
+		page, err := queryIndex(store, "item", newListRequest(t, 2, ""), legacyIndex)
+		require.NoError(t, err)
+		require.Equal(t, []string{"e", "d"}, page.keys)
+		require.Equal(t, 5, page.total)
+		require.Equal(t, 0, store.pageNum)
+
+		page, err = queryIndex(store, "item", newListRequest(t, 2, page.next), legacyIndex)
+		require.NoError(t, err)
+		require.Equal(t, []string{"c", "b"}, page.keys)
+		require.Equal(t, 5, page.total)
+		require.Equal(t, 0, store.pageNum)
+
+		// the page before the one of the cursor is read again
+		page, err = queryIndex(store, "item", newListRequest(t, 2, page.next), legacyIndex)
+		require.NoError(t, err)
+		require.Equal(t, []string{"a"}, page.keys)
+		require.Equal(t, 5, page.total)
+		require.Empty(t, page.next)
+		require.Equal(t, 1, store.pageNum)
+		require.Equal(t, 2, store.pageSize)
+
+		req := newListRequest(t, 2, "")
+		req.from = created.Add(time.Hour).Format(createdTagFormat)
+		req.to = created.Add(2 * time.Hour).Format(createdTagFormat)
+		req.ascending = true
+
+		page, err = queryIndex(store, "item", req, legacyIndex)
+		require.NoError(t, err)
+		require.Equal(t, []string{"b", "c"}, page.keys)
+		require.Equal(t, 2, page.total)
+		require.Empty(t, page.next)
+	})
+
+	t.Run("legacy index errors", func(t *testing.T) {
+		err := indexLegacyItems(&mockstorage.Store{ErrQuery: errors.New("query error")}, "item", legacyIndex)
+		require.EqualError(t, err, "query item : query error")
+
+		err = indexLegacyItems(&mockstorage.Store{
+			QueryReturn: &mockstorage.Iterator{NextReturn: true, ValueReturn: []byte("invalid")},
+		}, "item", legacyIndex)
+		require.Contains(t, err.Error(), "failed to read item index")
+
+		err = indexLegacyItems(&mockstorage.Store{
+			QueryReturn: &mockstorage.Iterator{NextReturn: true, ErrTags: errors.New("tags error")},
+		}, "item", legacyIndex)
+		require.EqualError(t, err, "failed to get item tags: tags error")
+	})
+
+	t.Run("store errors", func(t *testing.T) {
+		req := &listRequest{pageRequest: &pageRequest{limit: 1}}
+
+		_, err := queryIndex(&mockstorage.Store{ErrQuery: errors.New("query error")}, "item", req, legacyIndex)
+		require.EqualError(t, err, "query item : query error")
+
+		_, err = queryIndex(&mockstorage.Store{
+			QueryReturn: &mockstorage.Iterator{NextReturn: true, ErrTags: errors.New("tags error")},
+		}, "item", req, legacyIndex)
+		require.EqualError(t, err, "failed to get item tags: tags error")
+
+		_, err = queryIndex(&mockstorage.Store{
+			QueryReturn: &mockstorage.Iterator{NextReturn: true, ErrValue: errors.New("value error")},
+		}, "item", req, legacyIndex)
+		require.EqualError(t, err, "failed to get item value: value error")
+
+		_, err = queryIndex(&mockstorage.Store{
+			QueryReturn: &mockstorage.Iterator{NextReturn: true, ValueReturn: []byte("invalid")},
+		}, "item", req, legacyIndex)
+		require.Contains(t, err.Error(), "failed to read item index")
+
+		_, err = queryIndex(&mockstorage.Store{
+			QueryReturn: &mockstorage.Iterator{
+				NextReturn: true,
+				TagsReturn: []storage.Tag{{Name: sourceTagName, Value: "!"}},
+			},
+		}, "item", req, legacyIndex)
+		require.Contains(t, err.Error(), "invalid item source tag")
+	})
+}
+
+func newListRequest(t *testing.T, limit int, cursor string) *listRequest {
+	t.Helper()
+
+	query := url.Values{limitQueryParam: []string{strconv.Itoa(limit)}}
+	if cursor != "" {
+		query.Set(cursorQueryParam, cursor)
+	}
+
+	req, err := getListRequest(httptest.NewRequest(http.MethodGet, "/items?"+query.Encode(), nil))
+	require.NoError(t, err)
+
+	return req
+}
+
+// sortingStore sorts the items on the tag of the sort options, and starts at the page of the query options, like the
+// CouchDB provider.
+type sortingStore struct {
+	storage.Store
+	pageSize int
+	pageNum  int
+}
+
+func newSortingStore(t *testing.T) *sortingStore {
+	t.Helper()
+
+	store, err := mem.NewProvider().OpenStore("test")
+	require.NoError(t, err)
+
+	return &sortingStore{Store: store}
+}
+
+func (s *sortingStore) Query(expression string, options ...storage.QueryOption) (storage.Iterator, error) {
+	opts := &storage.QueryOptions{}
+	for _, o := range options {
+		o(opts)
+	}
+
+	iter, err := s.Store.Query(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	items := &sliceIterator{index: -1}
+
+	for more, err := iter.Next(); more && err == nil; more, err = iter.Next() {
+		key, _ := iter.Key()     // nolint: errcheck
+		tags, _ := iter.Tags()   // nolint: errcheck
+		value, _ := iter.Value() // nolint: errcheck
+
+		items.entries = append(items.entries, sliceEntry{key: key, value: value, tags: tags})
+	}
+
+	sortTag := func(e sliceEntry) string {
+		for _, t := range e.tags {
+			if t.Name == opts.SortOptions.TagName {
+				return t.Value
+			}
+		}
+
+		return ""
+	}
+
+	sort.Slice(items.entries, func(i, j int) bool {
+		if opts.SortOptions.Order == storage.SortAscending {
+			return sortTag(items.entries[i]) < sortTag(items.entries[j])
+		}
+
+		return sortTag(items.entries[i]) > sortTag(items.entries[j])
+	})
+
+	s.pageSize, s.pageNum = opts.PageSize, opts.InitialPageNum
+
+	if start := opts.InitialPageNum * opts.PageSize; start < len(items.entries) {
+		items.entries = items.entries[start:]
+	} else {
+		items.entries = nil
+	}
+
+	return items, nil
+}
+
+type sliceEntry struct {
+	key   string
+	value []byte
+	tags  []storage.Tag
+}
+
+type sliceIterator struct {
+	entries []sliceEntry
+	index   int
+}
+
+func (i *sliceIterator) Next() (bool, error) {
+	i.index++
+
+	return i.index < len(i.entries), nil
+}
+
+func (i *sliceIterator) Key() (string, error) {
+	return i.entries[i.index].key, nil
+}
+
+func (i *sliceIterator) Value() ([]byte, error) {
+	return i.entries[i.index].value, nil
+}
+
+func (i *sliceIterator) Tags() ([]storage.Tag, error) {
+	return i.entries[i.index].tags, nil
+}
+
+func (i *sliceIterator) Close() error {
+	return nil
+}
+
+type getBulkErrStore struct {
+	storage.Store
+}
+
+func (s *getBulkErrStore) GetBulk(...string) ([][]byte, error) {
+	return nil, errors.New("get bulk error")
+}
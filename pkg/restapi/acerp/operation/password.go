@@ -0,0 +1,264 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// argon2id parameters recommended by RFC 9106 for memory constrained environments
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+	saltLen       = 16
+
+	minPasswordLength = 8
+
+	defaultMaxLoginAttempts = 5
+	defaultLockoutDuration  = 15 * time.Minute
+)
+
+var (
+	errInvalidCredentials = errors.New("invalid username or password")
+	errAccountLocked      = errors.New("account is locked, try again later")
+	errUsernameExists     = errors.New("username already exists")
+
+	// dummyCredential is checked for unknown usernames
+	dummyCredential = &userCredential{ // nolint: gochecknoglobals
+		Hash:    make([]byte, argon2KeyLen),
+		Salt:    make([]byte, saltLen),
+		Time:    argon2Time,
+		Memory:  argon2Memory,
+		Threads: argon2Threads,
+	}
+)
+
+// userCredential is the argon2id hash of the password of a user with the state of the failed logins.
+type userCredential struct {
+	Hash           []byte    `json:"hash"`
+	Salt           []byte    `json:"salt"`
+	Time           uint32    `json:"time"`
+	Memory         uint32    `json:"memory"`
+	Threads        uint8     `json:"threads"`
+	FailedAttempts int       `json:"failedAttempts,omitempty"`
+	LockedUntil    time.Time `json:"lockedUntil,omitempty"`
+}
+
+// newUserCredential hashes the password with a random salt.
+func newUserCredential(pwd string) (*userCredential, error) {
+	salt := make([]byte, saltLen)
+
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, fmt.Errorf("create salt : %w", err)
+	}
+
+	return &userCredential{
+		Hash:    argon2.IDKey([]byte(pwd), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen),
+		Salt:    salt,
+		Time:    argon2Time,
+		Memory:  argon2Memory,
+		Threads: argon2Threads,
+	}, nil
+}
+
+// verify checks the password in constant time with the parameters the hash was created with.
+func (c *userCredential) verify(pwd string) bool {
+	hash := argon2.IDKey([]byte(pwd), c.Salt, c.Time, c.Memory, c.Threads, uint32(len(c.Hash)))
+
+	return subtle.ConstantTimeCompare(hash, c.Hash) == 1
+}
+
+func (c *userCredential) locked() bool {
+	return time.Now().Before(c.LockedUntil)
+}
+
+func validatePassword(pwd string) error {
+	if len(pwd) < minPasswordLength {
+		return fmt.Errorf("password must have at least %d characters", minPasswordLength)
+	}
+
+	return nil
+}
+
+// reserveUsername saves the credential of a new user. The stores have no conditional writes, so the check and the
+// save are only atomic under the credential lock of this instance: the ACE RP must run as a single instance, or two
+// instances could register the same username concurrently.
+func (o *Operation) reserveUsername(name string, cred *userCredential) error {
+	o.credentialMu.Lock()
+	defer o.credentialMu.Unlock()
+
+	_, err := o.store.Get(name)
+	if err == nil {
+		return errUsernameExists
+	}
+
+	if !errors.Is(err, storage.ErrDataNotFound) {
+		return err
+	}
+
+	_, err = o.getUserCredential(name)
+	if err == nil {
+		return errUsernameExists
+	}
+
+	if !errors.Is(err, storage.ErrDataNotFound) {
+		return err
+	}
+
+	return o.saveUserCredential(name, cred)
+}
+
+// setMissingPassword saves the credential of a user registered before the passwords were added, who can't log in
+// until then. It returns false if the user already has a password.
+func (o *Operation) setMissingPassword(name string, cred *userCredential) (bool, error) {
+	o.credentialMu.Lock()
+	defer o.credentialMu.Unlock()
+
+	_, err := o.getUserCredential(name)
+	if err == nil {
+		return false, nil
+	}
+
+	if !errors.Is(err, storage.ErrDataNotFound) {
+		return false, err
+	}
+
+	return true, o.saveUserCredential(name, cred)
+}
+
+// releaseUsername deletes the credential of a failed registration.
+func (o *Operation) releaseUsername(name string) {
+	o.credentialMu.Lock()
+	defer o.credentialMu.Unlock()
+
+	err := o.credentialStore.Delete(name)
+	if err != nil {
+		logger.Errorf("failed to delete credential of user %s : %s", name, err.Error())
+	}
+}
+
+// authenticate checks the password of the user and writes the error response if the check fails. The account is
+// locked after repeated failures.
+func (o *Operation) authenticate(w http.ResponseWriter, name, pwd string) bool {
+	err := o.checkPassword(name, pwd)
+	if err == nil {
+		return true
+	}
+
+	switch {
+	case errors.Is(err, errInvalidCredentials):
+		o.writeErrorResponse(w, http.StatusUnauthorized, err.Error())
+	case errors.Is(err, errAccountLocked):
+		o.writeErrorResponse(w, http.StatusForbidden, err.Error())
+	default:
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("unable to check password of user %s: %s", name, err.Error()))
+	}
+
+	return false
+}
+
+func (o *Operation) checkPassword(name, pwd string) error {
+	cred, err := o.getUserCredential(name)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		// hash the password anyway so that unknown usernames take as long as wrong passwords
+		dummyCredential.verify(pwd)
+
+		if _, err = o.store.Get(name); err == nil {
+			logger.Warnf("user %s has no password, it is set by importing the user again", name)
+		}
+
+		return errInvalidCredentials
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if cred.locked() {
+		return errAccountLocked
+	}
+
+	// the hash is computed without the lock, the failures are counted on the latest saved credential
+	valid := cred.verify(pwd)
+
+	o.credentialMu.Lock()
+	defer o.credentialMu.Unlock()
+
+	cred, err = o.getUserCredential(name)
+	if err != nil {
+		return err
+	}
+
+	if valid {
+		if cred.FailedAttempts == 0 {
+			return nil
+		}
+
+		cred.FailedAttempts = 0
+
+		return o.saveUserCredential(name, cred)
+	}
+
+	cred.FailedAttempts++
+
+	if cred.FailedAttempts >= o.maxLoginAttempts {
+		logger.Warnf("locking account of user %s after %d failed logins", name, cred.FailedAttempts)
+
+		cred.FailedAttempts = 0
+		cred.LockedUntil = time.Now().Add(o.lockoutDuration)
+	}
+
+	err = o.saveUserCredential(name, cred)
+	if err != nil {
+		return err
+	}
+
+	return errInvalidCredentials
+}
+
+func (o *Operation) getUserCredential(name string) (*userCredential, error) {
+	credBytes, err := o.credentialStore.Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	cred := &userCredential{}
+
+	err = json.Unmarshal(credBytes, cred)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal user credential: %w", err)
+	}
+
+	return cred, nil
+}
+
+func (o *Operation) saveUserCredential(name string, cred *userCredential) error {
+	credBytes, err := json.Marshal(cred)
+	if err != nil {
+		return fmt.Errorf("marshal user credential: %w", err)
+	}
+
+	err = o.credentialStore.Put(name, credBytes)
+	if err != nil {
+		return fmt.Errorf("save user credential: %w", err)
+	}
+
+	return nil
+}
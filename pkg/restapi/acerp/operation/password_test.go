@@ -0,0 +1,106 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	mockstorage "github.com/hyperledger/aries-framework-go/component/storageutil/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserCredential(t *testing.T) {
+	t.Run("verify", func(t *testing.T) {
+		cred, err := newUserCredential(samplePassword)
+		require.NoError(t, err)
+		require.Len(t, cred.Salt, saltLen)
+		require.Len(t, cred.Hash, argon2KeyLen)
+
+		require.True(t, cred.verify(samplePassword))
+		require.False(t, cred.verify("wrong-password"))
+		require.False(t, dummyCredential.verify(samplePassword))
+	})
+
+	t.Run("per user salt", func(t *testing.T) {
+		cred1, err := newUserCredential(samplePassword)
+		require.NoError(t, err)
+
+		cred2, err := newUserCredential(samplePassword)
+		require.NoError(t, err)
+
+		require.NotEqual(t, cred1.Salt, cred2.Salt)
+		require.NotEqual(t, cred1.Hash, cred2.Hash)
+	})
+
+	t.Run("locked", func(t *testing.T) {
+		cred := &userCredential{}
+		require.False(t, cred.locked())
+
+		cred.LockedUntil = time.Now().Add(time.Minute)
+		require.True(t, cred.locked())
+	})
+
+	t.Run("validate password", func(t *testing.T) {
+		require.NoError(t, validatePassword(samplePassword))
+		require.Error(t, validatePassword("short"))
+	})
+}
+
+func TestReserveUsername(t *testing.T) {
+	t.Run("saves the credential", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		cred, err := newUserCredential(samplePassword)
+		require.NoError(t, err)
+
+		require.NoError(t, svc.reserveUsername(sampleUserName, cred))
+
+		saved, err := svc.getUserCredential(sampleUserName)
+		require.NoError(t, err)
+		require.True(t, saved.verify(samplePassword))
+
+		require.ErrorIs(t, svc.reserveUsername(sampleUserName, cred), errUsernameExists)
+	})
+
+	t.Run("existing user", func(t *testing.T) {
+		svc := newTestOperation(t, withUserData(&userData{UserName: sampleUserName}))
+
+		require.ErrorIs(t, svc.reserveUsername(sampleUserName, &userCredential{}), errUsernameExists)
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		svc.credentialStore = &mockstorage.Store{ErrGet: errors.New("get error")}
+
+		err := svc.reserveUsername(sampleUserName, &userCredential{})
+		require.EqualError(t, err, "get error")
+	})
+}
+
+func TestSetMissingPassword(t *testing.T) {
+	svc := newTestOperation(t)
+
+	cred, err := newUserCredential(samplePassword)
+	require.NoError(t, err)
+
+	set, err := svc.setMissingPassword(sampleUserName, cred)
+	require.NoError(t, err)
+	require.True(t, set)
+
+	set, err = svc.setMissingPassword(sampleUserName, &userCredential{})
+	require.NoError(t, err)
+	require.False(t, set)
+	require.NoError(t, svc.checkPassword(sampleUserName, samplePassword))
+
+	svc.credentialStore = &mockstorage.Store{ErrGet: errors.New("get error")}
+
+	_, err = svc.setMissingPassword(sampleUserName, cred)
+	require.EqualError(t, err, "get error")
+}
@@ -0,0 +1,626 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/util"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+)
+
+const (
+	accountExport  = "/account/export"
+	accountErase   = "/account/erase"
+	getUser        = users + "/{id}"
+	userDataExport = getUser + "/export"
+	audit          = "/audit"
+
+	auditStoreName = "audit"
+	auditTagName   = "audit"
+
+	auditUserErased = "userErased"
+	// actorSelf is the actor of the erasures requested by the users themselves
+	actorSelf = "self"
+
+	exportFileName = "ace-user-data.json"
+)
+
+// exportAccount sends everything held about the user of the session as a JSON file.
+func (o *Operation) exportAccount(w http.ResponseWriter, r *http.Request) {
+	session, ok := o.requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	uData, err := o.getUserData(session.UserName)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("unable to get user data %s: %s", session.UserName, err.Error()))
+
+		return
+	}
+
+	o.writeUserExport(w, uData)
+}
+
+// eraseAccount erases the user of the session and ends the session.
+func (o *Operation) eraseAccount(w http.ResponseWriter, r *http.Request) {
+	session, ok := o.requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	uData, err := o.getUserData(session.UserName)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("unable to get user data %s: %s", session.UserName, err.Error()))
+
+		return
+	}
+
+	_, err = o.eraseUser(uData, actorSelf)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to erase user : %s", err.Error()))
+
+		return
+	}
+
+	o.endSession(w, r)
+	clearCookies(w)
+
+	o.loadHTML(w, o.homePageHTML, map[string]interface{}{})
+}
+
+// exportUserData sends everything held about the user with the id.
+func (o *Operation) exportUserData(w http.ResponseWriter, r *http.Request) {
+	uData, ok := o.getUserByIDOrWriteError(w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	o.writeUserExport(w, uData)
+}
+
+// deleteUser erases the user with the id, and returns the tombstone saved in the audit log.
+func (o *Operation) deleteUser(w http.ResponseWriter, r *http.Request) {
+	uData, ok := o.getUserByIDOrWriteError(w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	record, err := o.eraseUser(uData, string(RoleOperator))
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to erase user : %s", err.Error()))
+
+		return
+	}
+
+	o.writeResponse(w, http.StatusOK, record)
+}
+
+func (o *Operation) getAuditRecords(w http.ResponseWriter, r *http.Request) {
+	page, err := getPageRequest(r)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	values, next, err := queryPage(o.auditStore, auditTagName, page)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to get audit records: %s", err.Error()))
+
+		return
+	}
+
+	records := make([]*auditRecord, len(values))
+
+	for i, v := range values {
+		err = json.Unmarshal(v, &records[i])
+		if err != nil {
+			o.writeErrorResponse(w, http.StatusInternalServerError,
+				fmt.Sprintf("failed to unmarshal audit record: %s", err.Error()))
+
+			return
+		}
+	}
+
+	o.writeResponse(w, http.StatusOK, &auditRecordsResp{Records: records, NextCursor: next})
+}
+
+func (o *Operation) getUserByIDOrWriteError(w http.ResponseWriter, id string) (*userData, bool) {
+	uMap, err := o.getUserIDNameMap(id)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		o.writeErrorResponse(w, http.StatusNotFound, fmt.Sprintf("user %s not found", id))
+
+		return nil, false
+	}
+
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to get user %s: %s", id, err.Error()))
+
+		return nil, false
+	}
+
+	uData, err := o.getUserData(uMap.UserName)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		o.writeErrorResponse(w, http.StatusNotFound, fmt.Sprintf("user %s not found", id))
+
+		return nil, false
+	}
+
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("unable to get user data %s: %s", id, err.Error()))
+
+		return nil, false
+	}
+
+	return uData, true
+}
+
+func (o *Operation) writeUserExport(w http.ResponseWriter, uData *userData) {
+	export, err := o.exportUser(uData)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to export user : %s", err.Error()))
+
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", exportFileName))
+	o.writeResponse(w, http.StatusOK, export)
+}
+
+// exportUser collects the data of the user from all the stores.
+func (o *Operation) exportUser(uData *userData) (*userExport, error) { // nolint: funlen,gocyclo
+	export := &userExport{User: uData, ExportedTime: util.NewTime(time.Now())}
+
+	uMap, err := o.getUserIDNameMap(uData.ID)
+	if err != nil && !errors.Is(err, storage.ErrDataNotFound) {
+		return nil, err
+	}
+
+	if err == nil {
+		export.CreatedTime = uMap.CreatedTime
+	}
+
+	cred, err := o.getUserCredential(uData.UserName)
+	if err != nil && !errors.Is(err, storage.ErrDataNotFound) {
+		return nil, err
+	}
+
+	if err == nil {
+		export.Login = &loginExport{FailedAttempts: cred.FailedAttempts, LockedUntil: cred.LockedUntil}
+	}
+
+	sessions, err := o.getUserSessions(uData.UserName)
+	if err != nil {
+		return nil, err
+	}
+
+	export.Sessions = make([]sessionExport, len(sessions))
+
+	for i, s := range sessions {
+		export.Sessions[i] = sessionExport{CreatedTime: s.CreatedTime, LastSeenTime: s.LastSeenTime}
+	}
+
+	export.Registrations, err = o.getUserRegistrations(uData.UserName)
+	if err != nil {
+		return nil, err
+	}
+
+	export.Links, err = o.getAccountLinks(userTagName, uData.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	authData, err := o.getUserAuthDataOf(uData)
+	if err != nil {
+		return nil, err
+	}
+
+	export.UserAuths = make([]*userAuthData, 0, len(authData))
+
+	for _, data := range authData {
+		data.UserAuths, _ = splitUserAuths(data.UserAuths, uData)
+		export.UserAuths = append(export.UserAuths, data)
+	}
+
+	jobs, err := o.getExtractJobs()
+	if err != nil {
+		return nil, err
+	}
+
+	export.ExtractResults = make([]extractResultExport, 0)
+
+	for _, j := range jobs {
+		for _, res := range j.Results {
+			if uData.owns(res.ID, res.Name) {
+				export.ExtractResults = append(export.ExtractResults, extractResultExport{
+					JobID: j.ID, ExtractID: j.ExtractID, DocType: j.DocType, CreatedTime: j.CreatedTime, Result: res,
+				})
+			}
+		}
+	}
+
+	return export, nil
+}
+
+// eraseUser revokes the account links of the user, deletes the vault with the documents and removes the user from
+// all the stores, and records the tombstone in the audit log. The user data is deleted last, so that the erasure can
+// be retried if a step fails.
+func (o *Operation) eraseUser(uData *userData, actor string) (*auditRecord, error) { // nolint: funlen,gocyclo
+	erased := make(map[string]int)
+
+	links, err := o.getAccountLinks(userTagName, uData.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, l := range links {
+		err = o.removeAccountLink(l)
+		if err != nil {
+			return nil, fmt.Errorf("remove account link %s : %w", l.ID, err)
+		}
+
+		o.notifyAccountUnlink(l)
+	}
+
+	erased["accountLinks"] = len(links)
+
+	erased["userAuths"], err = o.eraseUserAuths(uData)
+	if err != nil {
+		return nil, err
+	}
+
+	erased["extractResults"], err = o.eraseExtractResults(uData)
+	if err != nil {
+		return nil, err
+	}
+
+	erased["importResults"], err = o.eraseImportResults(uData)
+	if err != nil {
+		return nil, err
+	}
+
+	erased["registrations"], err = o.eraseRegistrations(uData.UserName)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := o.getUserSessions(uData.UserName)
+	if err != nil {
+		return nil, err
+	}
+
+	err = o.invalidateUserSessions(uData.UserName, "")
+	if err != nil {
+		return nil, err
+	}
+
+	erased["sessions"] = len(sessions)
+
+	if uData.VaultID != "" {
+		err = o.vClient.DeleteVault(uData.VaultID)
+		if err != nil {
+			return nil, fmt.Errorf("delete vault : %w", err)
+		}
+
+		erased["vaults"] = 1
+		erased["documents"] = len(uData.Documents)
+
+		// the vault is gone, a retry of the erasure mustn't delete it again
+		uData.VaultID = ""
+		uData.NationalIDDocID = ""
+		uData.Documents = nil
+
+		err = o.saveUserData(uData)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	record := &auditRecord{
+		ID:     uuid.NewString(),
+		Event:  auditUserErased,
+		UserID: uData.ID,
+		Actor:  actor,
+		Erased: erased,
+		Time:   util.NewTime(time.Now()),
+	}
+
+	// the tombstone is saved while the erasure can still be retried, so that no erasure goes unrecorded
+	err = o.saveAuditRecord(record)
+	if err != nil {
+		return nil, err
+	}
+
+	err = o.deleteUserCredential(uData.UserName)
+	if err != nil {
+		return nil, err
+	}
+
+	err = deleteIfExists(o.userStore, uData.ID)
+	if err != nil {
+		return nil, fmt.Errorf("delete id-username mapping : %w", err)
+	}
+
+	err = deleteIfExists(o.store, uData.UserName)
+	if err != nil {
+		return nil, fmt.Errorf("delete user data : %w", err)
+	}
+
+	logger.Infof("eraseUser : userID=[%s] actor=[%s] erased=%v", uData.ID, actor, erased)
+
+	return record, nil
+}
+
+// eraseUserAuths removes the authorizations of the user from the saved user auths. The user auths left empty are
+// deleted.
+func (o *Operation) eraseUserAuths(uData *userData) (int, error) {
+	authData, err := o.getUserAuthDataOf(uData)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+
+	for _, data := range authData {
+		removed, kept := splitUserAuths(data.UserAuths, uData)
+		count += len(removed)
+
+		if len(kept) == 0 {
+			err = deleteIfExists(o.userAuthStore, data.ID)
+			if err != nil {
+				return 0, fmt.Errorf("delete user auth data %s : %w", data.ID, err)
+			}
+
+			continue
+		}
+
+		data.UserAuths = kept
+
+		err = o.saveUserAuthData(data)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return count, nil
+}
+
+// eraseExtractResults removes the results of the user from the extract jobs.
+func (o *Operation) eraseExtractResults(uData *userData) (int, error) {
+	jobs, err := o.getExtractJobs()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+
+	for _, j := range jobs {
+		results := make([]userExtractResult, 0, len(j.Results))
+
+		for _, res := range j.Results {
+			if !uData.owns(res.ID, res.Name) {
+				results = append(results, res)
+			}
+		}
+
+		removed := len(j.Results) - len(results)
+		if removed == 0 {
+			continue
+		}
+
+		j.Results = results
+		j.Total -= removed
+		j.Processed -= removed
+
+		err = o.saveExtractJob(j)
+		if err != nil {
+			return 0, fmt.Errorf("save extract job %s : %w", j.ID, err)
+		}
+
+		count += removed
+	}
+
+	return count, nil
+}
+
+// eraseImportResults removes the rows of the user from the import jobs.
+func (o *Operation) eraseImportResults(uData *userData) (int, error) {
+	jobs, err := o.getImportJobs()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+
+	for _, j := range jobs {
+		results := j.Results
+		j.Results = make([]userImportResult, 0, len(results))
+		j.Total -= len(results)
+		j.Created, j.Existing, j.Failed = 0, 0, 0
+
+		for _, res := range results {
+			if res.UserName != uData.UserName {
+				j.Total++
+				j.add(res)
+			}
+		}
+
+		removed := len(results) - len(j.Results)
+		if removed == 0 {
+			continue
+		}
+
+		err = o.saveImportJob(j)
+		if err != nil {
+			return 0, fmt.Errorf("save import job %s : %w", j.ID, err)
+		}
+
+		count += removed
+	}
+
+	return count, nil
+}
+
+func (o *Operation) eraseRegistrations(userName string) (int, error) {
+	regs, err := o.getUserRegistrations(userName)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, reg := range regs {
+		err = deleteIfExists(o.registrationStore, reg.ID)
+		if err != nil {
+			return 0, fmt.Errorf("delete registration %s : %w", reg.ID, err)
+		}
+	}
+
+	return len(regs), nil
+}
+
+// getUserAuthDataOf returns the saved user auths with an authorization of the user.
+func (o *Operation) getUserAuthDataOf(uData *userData) ([]*userAuthData, error) {
+	authData := make([]*userAuthData, 0)
+
+	err := forEachValue(o.userAuthStore, userTagName, func(value []byte) error {
+		var data *userAuthData
+
+		err := json.Unmarshal(value, &data)
+		if err != nil {
+			return fmt.Errorf("unmarshal user auth data: %w", err)
+		}
+
+		if removed, _ := splitUserAuths(data.UserAuths, uData); len(removed) > 0 {
+			authData = append(authData, data)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return authData, nil
+}
+
+func (o *Operation) getUserRegistrations(userName string) ([]*userRegistration, error) {
+	regs := make([]*userRegistration, 0)
+
+	err := forEachValue(o.registrationStore, registrationTagName, func(value []byte) error {
+		reg := &userRegistration{}
+
+		err := json.Unmarshal(value, reg)
+		if err != nil {
+			return fmt.Errorf("unmarshal registration : %w", err)
+		}
+
+		if reg.UserName == userName {
+			regs = append(regs, reg)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return regs, nil
+}
+
+func (o *Operation) getUserIDNameMap(id string) (*userIDNameMap, error) {
+	uMapBytes, err := o.userStore.Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("get id-username mapping : %w", err)
+	}
+
+	uMap := &userIDNameMap{}
+
+	err = json.Unmarshal(uMapBytes, uMap)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal id-username mapping : %w", err)
+	}
+
+	return uMap, nil
+}
+
+func (o *Operation) saveUserData(uData *userData) error {
+	uDataBytes, err := json.Marshal(uData)
+	if err != nil {
+		return fmt.Errorf("marshal user data : %w", err)
+	}
+
+	err = o.store.Put(uData.UserName, uDataBytes)
+	if err != nil {
+		return fmt.Errorf("save user data : %w", err)
+	}
+
+	return nil
+}
+
+func (o *Operation) deleteUserCredential(name string) error {
+	o.credentialMu.Lock()
+	defer o.credentialMu.Unlock()
+
+	err := deleteIfExists(o.credentialStore, name)
+	if err != nil {
+		return fmt.Errorf("delete user credential : %w", err)
+	}
+
+	return nil
+}
+
+func (o *Operation) saveAuditRecord(record *auditRecord) error {
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("marshal audit record : %w", err)
+	}
+
+	err = o.auditStore.Put(record.ID, recordBytes, storage.Tag{Name: auditTagName})
+	if err != nil {
+		return fmt.Errorf("save audit record : %w", err)
+	}
+
+	return nil
+}
+
+// owns returns true if the authorization or the extract result with the id and the name is of the user.
+func (u *userData) owns(id, name string) bool {
+	return id == u.ID && name == u.UserName
+}
+
+// splitUserAuths splits the authorizations of the user from the others.
+func splitUserAuths(auths []userAuthorization, uData *userData) (removed, kept []userAuthorization) {
+	for _, a := range auths {
+		if uData.owns(a.ID, a.Name) {
+			removed = append(removed, a)
+		} else {
+			kept = append(kept, a)
+		}
+	}
+
+	return removed, kept
+}
+
+func deleteIfExists(store storage.Store, key string) error {
+	err := store.Delete(key)
+	if err != nil && !errors.Is(err, storage.ErrDataNotFound) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,409 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	mockstorage "github.com/hyperledger/aries-framework-go/component/storageutil/mock"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/util"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	samplePrivacyUserID  = "U1"
+	samplePrivacyVaultID = "did:example:vault"
+)
+
+func TestExportUser(t *testing.T) {
+	t.Run("self-service", func(t *testing.T) {
+		svc := newTestOperation(t, withPrivacyTestUser(&mockVaultClient{}))
+
+		rr := httptest.NewRecorder()
+		svc.exportAccount(rr, newSessionRequest(t, svc, http.MethodGet, accountExport, sampleUserName))
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Contains(t, rr.Header().Get("Content-Disposition"), exportFileName)
+		require.NotContains(t, rr.Body.String(), "hash")
+
+		export := &userExport{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), export))
+		require.Equal(t, samplePrivacyUserID, export.User.ID)
+		require.NotNil(t, export.CreatedTime)
+		require.Equal(t, 1, export.Login.FailedAttempts)
+		require.Len(t, export.Sessions, 2)
+		require.Len(t, export.Registrations, 1)
+		require.Len(t, export.Links, 1)
+		require.Len(t, export.UserAuths, 2)
+
+		for _, data := range export.UserAuths {
+			require.Len(t, data.UserAuths, 1)
+			require.Equal(t, samplePrivacyUserID, data.UserAuths[0].ID)
+		}
+
+		require.Len(t, export.ExtractResults, 1)
+		require.Equal(t, "job1", export.ExtractResults[0].JobID)
+	})
+
+	t.Run("no session", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		rr := httptest.NewRecorder()
+		svc.exportAccount(rr, httptest.NewRequest(http.MethodGet, accountExport, nil))
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("admin", func(t *testing.T) {
+		svc := newTestOperation(t, withPrivacyTestUser(&mockVaultClient{}))
+
+		rr := httptest.NewRecorder()
+		svc.exportUserData(rr, newUserIDRequest(http.MethodGet, userDataExport, samplePrivacyUserID))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		export := &userExport{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), export))
+		require.Equal(t, sampleUserName, export.User.UserName)
+	})
+
+	t.Run("user not found", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		rr := httptest.NewRecorder()
+		svc.exportUserData(rr, newUserIDRequest(http.MethodGet, userDataExport, "unknown"))
+		require.Equal(t, http.StatusNotFound, rr.Code)
+		require.Contains(t, rr.Body.String(), "user unknown not found")
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		svc := newTestOperation(t, withPrivacyTestUser(&mockVaultClient{}))
+		svc.extractJobStore = &mockstorage.Store{ErrQuery: errors.New("query error")}
+
+		rr := httptest.NewRecorder()
+		svc.exportUserData(rr, newUserIDRequest(http.MethodGet, userDataExport, samplePrivacyUserID))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to export user")
+	})
+}
+
+func TestEraseUser(t *testing.T) {
+	t.Run("self-service", func(t *testing.T) {
+		vClient := &mockVaultClient{}
+
+		svc := newTestOperation(t, withPrivacyTestUser(vClient), withHomePageHTML(newTestHTMLFile(t)))
+
+		req := newSessionRequest(t, svc, http.MethodPost, accountErase, sampleUserName)
+
+		rr := httptest.NewRecorder()
+		svc.eraseAccount(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		requireUserErased(t, svc, vClient)
+
+		_, err := svc.getSession(req)
+		require.ErrorIs(t, err, errNoSession)
+
+		values, _, err := queryPage(svc.auditStore, auditTagName, &pageRequest{limit: maxPageLimit})
+		require.NoError(t, err)
+		require.Len(t, values, 1)
+
+		for _, v := range values {
+			record := &auditRecord{}
+			require.NoError(t, json.Unmarshal(v, record))
+			require.Equal(t, actorSelf, record.Actor)
+			require.NotContains(t, string(v), sampleUserName)
+		}
+	})
+
+	t.Run("admin", func(t *testing.T) {
+		vClient := &mockVaultClient{}
+
+		svc := newTestOperation(t, withPrivacyTestUser(vClient))
+
+		rr := httptest.NewRecorder()
+		svc.deleteUser(rr, newUserIDRequest(http.MethodDelete, getUser, samplePrivacyUserID))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		record := &auditRecord{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), record))
+		require.Equal(t, auditUserErased, record.Event)
+		require.Equal(t, samplePrivacyUserID, record.UserID)
+		require.Equal(t, string(RoleOperator), record.Actor)
+		require.Equal(t, map[string]int{
+			"accountLinks":   1,
+			"userAuths":      2,
+			"extractResults": 1,
+			"importResults":  1,
+			"registrations":  1,
+			"sessions":       1,
+			"vaults":         1,
+			"documents":      1,
+		}, record.Erased)
+
+		requireUserErased(t, svc, vClient)
+
+		rr = httptest.NewRecorder()
+		svc.deleteUser(rr, newUserIDRequest(http.MethodDelete, getUser, samplePrivacyUserID))
+		require.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("vault error", func(t *testing.T) {
+		vClient := &mockVaultClient{}
+
+		svc := newTestOperation(t, withPrivacyTestUser(vClient))
+		vClient.DeleteVaultErr = errors.New("vault error")
+
+		rr := httptest.NewRecorder()
+		svc.deleteUser(rr, newUserIDRequest(http.MethodDelete, getUser, samplePrivacyUserID))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to erase user : delete vault : vault error")
+
+		// the erasure is retried
+		vClient.DeleteVaultErr = nil
+
+		rr = httptest.NewRecorder()
+		svc.deleteUser(rr, newUserIDRequest(http.MethodDelete, getUser, samplePrivacyUserID))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		requireUserErased(t, svc, vClient)
+	})
+
+	t.Run("retry after the vault is deleted", func(t *testing.T) {
+		vClient := &mockVaultClient{}
+
+		svc := newTestOperation(t, withPrivacyTestUser(vClient))
+		userStore := svc.userStore
+		svc.userStore = &mockstorage.Store{ErrDelete: errors.New("delete error")}
+
+		uData, err := svc.getUserData(sampleUserName)
+		require.NoError(t, err)
+
+		_, err = svc.eraseUser(uData, actorSelf)
+		require.EqualError(t, err, "delete id-username mapping : delete error")
+
+		svc.userStore = userStore
+
+		uData, err = svc.getUserData(sampleUserName)
+		require.NoError(t, err)
+		require.Empty(t, uData.VaultID)
+
+		record, err := svc.eraseUser(uData, actorSelf)
+		require.NoError(t, err)
+		require.Zero(t, record.Erased["vaults"])
+		require.Equal(t, []string{samplePrivacyVaultID}, vClient.deletedVaults)
+
+		// both attempts are in the audit log
+		values, _, err := queryPage(svc.auditStore, auditTagName, &pageRequest{limit: maxPageLimit})
+		require.NoError(t, err)
+		require.Len(t, values, 2)
+	})
+
+	t.Run("revoke error", func(t *testing.T) {
+		vClient := &mockVaultClient{}
+
+		svc := newTestOperation(t, withPrivacyTestUser(vClient))
+		vClient.DeleteAuthorizationErr = errors.New("vault error")
+
+		rr := httptest.NewRecorder()
+		svc.eraseAccount(rr, newSessionRequest(t, svc, http.MethodPost, accountErase, sampleUserName))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "revoke vault authorization : vault error")
+
+		_, err := svc.getUserData(sampleUserName)
+		require.NoError(t, err)
+	})
+
+	t.Run("audit error", func(t *testing.T) {
+		svc := newTestOperation(t, withPrivacyTestUser(&mockVaultClient{}))
+		svc.auditStore = &mockstorage.Store{ErrPut: errors.New("put error")}
+
+		rr := httptest.NewRecorder()
+		svc.deleteUser(rr, newUserIDRequest(http.MethodDelete, getUser, samplePrivacyUserID))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "save audit record : put error")
+
+		_, err := svc.getUserData(sampleUserName)
+		require.NoError(t, err)
+	})
+}
+
+func TestGetAuditRecords(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		svc := newTestOperation(t, withPrivacyTestUser(&mockVaultClient{}))
+
+		rr := httptest.NewRecorder()
+		svc.deleteUser(rr, newUserIDRequest(http.MethodDelete, getUser, samplePrivacyUserID))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		rr = httptest.NewRecorder()
+		svc.getAuditRecords(rr, httptest.NewRequest(http.MethodGet, audit, nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		resp := &auditRecordsResp{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+		require.Len(t, resp.Records, 1)
+		require.Equal(t, samplePrivacyUserID, resp.Records[0].UserID)
+	})
+
+	t.Run("invalid page", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		rr := httptest.NewRecorder()
+		svc.getAuditRecords(rr, httptest.NewRequest(http.MethodGet, audit+"?limit=0", nil))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		svc := newTestOperation(t)
+		svc.auditStore = &mockstorage.Store{ErrQuery: errors.New("query error")}
+
+		rr := httptest.NewRecorder()
+		svc.getAuditRecords(rr, httptest.NewRequest(http.MethodGet, audit, nil))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to get audit records")
+	})
+}
+
+// withPrivacyTestUser seeds a user who has data in all the stores, and the clients needed to erase them.
+func withPrivacyTestUser(vClient *mockVaultClient) testOperationOption {
+	return func(opts *testOperationOptions) {
+		withVaultClient(vClient)(opts)
+		withHTTPClient(&mockHTTPClient{doFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewReader(nil))}, nil
+		}})(opts)
+		withSeed(seedPrivacyTestUser)(opts)
+	}
+}
+
+func seedPrivacyTestUser(t *testing.T, svc *Operation) {
+	t.Helper()
+
+	reg := &userRegistration{
+		ID:        "reg1",
+		UserName:  sampleUserName,
+		UserID:    samplePrivacyUserID,
+		VaultID:   samplePrivacyVaultID,
+		Documents: map[string]string{nationalID: "doc1"},
+	}
+	require.NoError(t, svc.commitRegistration(reg))
+	require.NoError(t, svc.updateRegistration(reg, registrationCompleted))
+
+	cred, err := newUserCredential(samplePassword)
+	require.NoError(t, err)
+
+	cred.FailedAttempts = 1
+	require.NoError(t, svc.saveUserCredential(sampleUserName, cred))
+
+	require.NoError(t, svc.saveClientData(&clientData{ClientID: "client1", Callback: "https://client.example.com"}))
+	require.NoError(t, svc.saveAccountLink(&accountLink{
+		UserID:               samplePrivacyUserID,
+		UserName:             sampleUserName,
+		State:                "state1",
+		ClientID:             "client1",
+		VaultID:              samplePrivacyVaultID,
+		VaultAuthorizationID: "auth1",
+	}))
+
+	userAuth := userAuthorization{ID: samplePrivacyUserID, Name: sampleUserName, DID: samplePrivacyVaultID}
+	otherAuth := userAuthorization{ID: "U2", Name: "other@example.com", DID: "did:example:other"}
+
+	for _, data := range []*userAuthData{
+		{ID: "auth1", Source: "ucis", UserAuths: []userAuthorization{userAuth}},
+		{ID: "auth2", Source: "ucis", SubmittedTime: util.NewTime(time.Now()),
+			UserAuths: []userAuthorization{userAuth, otherAuth}},
+		{ID: "auth3", Source: "ucis", UserAuths: []userAuthorization{otherAuth}},
+	} {
+		require.NoError(t, svc.saveUserAuthData(data))
+	}
+
+	require.NoError(t, svc.saveExtractJob(&extractJob{
+		ID:        "job1",
+		ExtractID: "auth2",
+		Status:    extractJobCompleted,
+		Total:     2,
+		Processed: 2,
+		Results: []userExtractResult{
+			{ID: samplePrivacyUserID, Name: sampleUserName, Value: "123", Status: extractSucceeded},
+			{ID: "U2", Name: "other@example.com", Value: "456", Status: extractSucceeded},
+		},
+	}))
+
+	require.NoError(t, svc.saveImportJob(&importJob{
+		ID:        "import1",
+		Status:    importJobCompleted,
+		Total:     2,
+		Processed: 2,
+		Created:   1,
+		Existing:  1,
+		Results: []userImportResult{
+			{Row: 0, UserName: sampleUserName, Status: importCreated, UserID: samplePrivacyUserID},
+			{Row: 1, UserName: "other@example.com", Status: importExists, UserID: "U2"},
+		},
+	}))
+
+	_, err = svc.startSession(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, login, nil), sampleUserName)
+	require.NoError(t, err)
+}
+
+func requireUserErased(t *testing.T, svc *Operation, vClient *mockVaultClient) {
+	t.Helper()
+
+	for _, s := range []struct {
+		store storage.Store
+		key   string
+	}{
+		{svc.store, sampleUserName},
+		{svc.userStore, samplePrivacyUserID},
+		{svc.credentialStore, sampleUserName},
+		{svc.registrationStore, "reg1"},
+		{svc.userAuthStore, "auth1"},
+	} {
+		_, err := s.store.Get(s.key)
+		require.ErrorIs(t, err, storage.ErrDataNotFound, s.key)
+	}
+
+	require.Equal(t, []string{samplePrivacyVaultID}, vClient.deletedVaults)
+	require.Equal(t, []string{"auth1"}, vClient.deletedAuthorizations)
+
+	links, err := svc.getAccountLinks(userTagName, samplePrivacyUserID)
+	require.NoError(t, err)
+	require.Empty(t, links)
+
+	sessions, err := svc.getUserSessions(sampleUserName)
+	require.NoError(t, err)
+	require.Empty(t, sessions)
+
+	data, err := svc.getUserAuthData("auth2")
+	require.NoError(t, err)
+	require.Len(t, data.UserAuths, 1)
+	require.Equal(t, "U2", data.UserAuths[0].ID)
+
+	job, err := svc.getExtractJobData("job1")
+	require.NoError(t, err)
+	require.Len(t, job.Results, 1)
+	require.Equal(t, 1, job.Total)
+	require.Equal(t, "U2", job.Results[0].ID)
+
+	imp, err := svc.getImportJobData("import1")
+	require.NoError(t, err)
+	require.Len(t, imp.Results, 1)
+	require.Equal(t, 1, imp.Total)
+	require.Equal(t, 0, imp.Created)
+	require.Equal(t, 1, imp.Existing)
+	require.Equal(t, "U2", imp.Results[0].UserID)
+}
+
+func newUserIDRequest(method, target, id string) *http.Request {
+	return mux.SetURLVars(httptest.NewRequest(method, target, nil), map[string]string{"id": id})
+}
@@ -0,0 +1,340 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+)
+
+func (o *Operation) createProfile(w http.ResponseWriter, r *http.Request) {
+	req := &profileReq{}
+
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusBadRequest,
+			fmt.Sprintf("failed to decode request: %s", err.Error()))
+
+		return
+	}
+
+	data := &req.profileData
+
+	err = o.validateProfile(data)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid profile: %s", err.Error()))
+
+		return
+	}
+
+	_, err = o.profileStore.Get(data.ID)
+	if err == nil {
+		o.writeErrorResponse(w, http.StatusConflict, fmt.Sprintf("profile %s already exists", data.ID))
+
+		return
+	}
+
+	if !errors.Is(err, storage.ErrDataNotFound) {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to get profile data : id=%s - %s", data.ID, err.Error()))
+
+		return
+	}
+
+	err = o.saveProfile(req)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to save profile data: %s", err.Error()))
+
+		return
+	}
+
+	o.writeResponse(w, http.StatusCreated, data)
+}
+
+func (o *Operation) getProfiles(w http.ResponseWriter, r *http.Request) {
+	page, err := getPageRequest(r)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	values, next, err := queryPage(o.profileStore, profileTagName, page)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to get profiles: %s", err.Error()))
+
+		return
+	}
+
+	profiles := make([]*profileData, len(values))
+
+	for i, v := range values {
+		err = json.Unmarshal(v, &profiles[i])
+		if err != nil {
+			o.writeErrorResponse(w, http.StatusInternalServerError,
+				fmt.Sprintf("failed to unmarshal profile data: %s", err.Error()))
+
+			return
+		}
+	}
+
+	o.writeResponse(w, http.StatusOK, &profilesResp{Profiles: profiles, NextCursor: next})
+}
+
+func (o *Operation) getProfile(w http.ResponseWriter, r *http.Request) {
+	data, ok := o.getProfileOrWriteError(w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	o.writeResponse(w, http.StatusOK, data)
+}
+
+func (o *Operation) updateProfile(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	req := &profileReq{}
+
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusBadRequest,
+			fmt.Sprintf("failed to decode request: %s", err.Error()))
+
+		return
+	}
+
+	data := &req.profileData
+
+	if data.ID == "" {
+		data.ID = id
+	}
+
+	if data.ID != id {
+		o.writeErrorResponse(w, http.StatusBadRequest, "profile id can't be changed")
+
+		return
+	}
+
+	err = o.validateProfile(data)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid profile: %s", err.Error()))
+
+		return
+	}
+
+	if _, ok := o.getProfileOrWriteError(w, id); !ok {
+		return
+	}
+
+	err = o.saveProfile(req)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to save profile data: %s", err.Error()))
+
+		return
+	}
+
+	o.writeResponse(w, http.StatusOK, data)
+}
+
+func (o *Operation) deleteProfile(w http.ResponseWriter, r *http.Request) {
+	profileID := mux.Vars(r)["id"]
+
+	if _, ok := o.getProfileOrWriteError(w, profileID); !ok {
+		return
+	}
+
+	err := o.profileStore.Delete(profileID)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to delete data : id=%s - %s", profileID, err.Error()))
+
+		return
+	}
+
+	err = o.profileSecretStore.Delete(profileID)
+	if err != nil {
+		logger.Errorf("failed to delete secret of profile %s : %s", profileID, err.Error())
+	}
+
+	o.writeResponse(w, http.StatusOK, nil)
+}
+
+func (o *Operation) getProfileOrWriteError(w http.ResponseWriter, id string) (*profileData, bool) {
+	data, err := o.getProfileData(id)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		o.writeErrorResponse(w, http.StatusNotFound, fmt.Sprintf("profile %s not found", id))
+
+		return nil, false
+	}
+
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to get profile data : id=%s - %s", id, err.Error()))
+
+		return nil, false
+	}
+
+	return data, true
+}
+
+func (o *Operation) validateProfile(data *profileData) error {
+	if data.ID == "" {
+		return errors.New("id is mandatory")
+	}
+
+	for _, u := range []string{data.URL, data.Callback} {
+		if u == "" {
+			continue
+		}
+
+		err := validateURL(u)
+		if err != nil {
+			return fmt.Errorf("invalid url %s : %w", u, err)
+		}
+	}
+
+	err := data.AuthPolicy.validate()
+	if err != nil {
+		return fmt.Errorf("invalid auth policy : %w", err)
+	}
+
+	return o.validateDID(data.DID)
+}
+
+func (o *Operation) getProfileData(profileID string) (*profileData, error) {
+	dataBytes, err := o.profileStore.Get(profileID)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		dataBytes, err = o.migrateLegacyProfile(profileID)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("get profile data: %w", err)
+	}
+
+	var data *profileData
+
+	err = json.Unmarshal(dataBytes, &data)
+	if err != nil {
+		return nil, fmt.Errorf("unamrshal profile data: %w", err)
+	}
+
+	return data, nil
+}
+
+func (o *Operation) saveProfileData(data *profileData) error {
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("marshal profile data: %w", err)
+	}
+
+	return o.profileStore.Put(data.ID, dataBytes, storage.Tag{Name: profileTagName})
+}
+
+// saveProfile saves the profile, and the client secret of the request if it has one.
+func (o *Operation) saveProfile(req *profileReq) error {
+	if req.ClientSecret != "" {
+		secretBytes, err := json.Marshal(&profileSecret{ClientSecret: req.ClientSecret})
+		if err != nil {
+			return fmt.Errorf("marshal profile secret: %w", err)
+		}
+
+		err = o.profileSecretStore.Put(req.ID, secretBytes)
+		if err != nil {
+			return fmt.Errorf("save profile secret: %w", err)
+		}
+	}
+
+	return o.saveProfileData(&req.profileData)
+}
+
+// setProfileCredentials sets the credentials of the client registered for this service at the service of the
+// profile. The profiles without a client secret are sent with an empty one.
+func (o *Operation) setProfileCredentials(req *http.Request, data *profileData) error {
+	secretBytes, err := o.profileSecretStore.Get(data.ID)
+	if err != nil && !errors.Is(err, storage.ErrDataNotFound) {
+		return fmt.Errorf("get profile secret: %w", err)
+	}
+
+	secret := &profileSecret{}
+
+	if err == nil {
+		err = json.Unmarshal(secretBytes, secret)
+		if err != nil {
+			return fmt.Errorf("unmarshal profile secret: %w", err)
+		}
+	}
+
+	req.SetBasicAuth(data.ClientID, secret.ClientSecret)
+
+	return nil
+}
+
+// migrateLegacyProfiles moves the configured profiles saved in the transaction store by the previous versions. The
+// transaction store has no tags to list the other profiles, they are moved when they are first read.
+func (o *Operation) migrateLegacyProfiles() {
+	for _, id := range []string{o.accountLinkProfile, o.extractorProfile} {
+		if id == "" {
+			continue
+		}
+
+		_, err := o.getProfileData(id)
+		if err != nil && !errors.Is(err, storage.ErrDataNotFound) {
+			logger.Errorf("failed to migrate profile %s : %s", id, err.Error())
+		}
+	}
+}
+
+// migrateLegacyProfile moves the profile from the transaction store to the profile store, with its client secret to
+// the profile secret store. It returns the profile data, or storage.ErrDataNotFound if the transaction store has no
+// profile with the id.
+func (o *Operation) migrateLegacyProfile(id string) ([]byte, error) {
+	legacyBytes, err := o.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &profileReq{}
+
+	// the transaction store also has the users and the link states
+	if json.Unmarshal(legacyBytes, req) != nil || req.ID != id {
+		return nil, storage.ErrDataNotFound
+	}
+
+	err = o.saveProfile(req)
+	if err != nil {
+		return nil, fmt.Errorf("migrate profile: %w", err)
+	}
+
+	err = o.store.Delete(id)
+	if err != nil {
+		logger.Warnf("failed to delete legacy profile %s : %s", id, err.Error())
+	}
+
+	logger.Infof("migrated legacy profile %s", id)
+
+	return json.Marshal(&req.profileData)
+}
+
+// validateDID checks that the DID resolves. The comparator authorizations are given to the DIDs of the clients and
+// the profiles.
+func (o *Operation) validateDID(did string) error {
+	if did == "" {
+		return errors.New("did is mandatory")
+	}
+
+	_, err := o.vdri.Resolve(did)
+	if err != nil {
+		return fmt.Errorf("resolve did %s : %w", did, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,479 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	mockstorage "github.com/hyperledger/aries-framework-go/component/storageutil/mock"
+	vdrmock "github.com/hyperledger/aries-framework-go/pkg/mock/vdr"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateProfile(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		svc := newTestOperation(t, withDIDResolution())
+
+		data := &profileData{
+			ID:       "profile2",
+			Name:     "Test",
+			URL:      "https://profile2.example.com",
+			DID:      "did:example:123",
+			Callback: "https://profile2.example.com/callback",
+		}
+
+		rr := httptest.NewRecorder()
+
+		svc.createProfile(rr, httptest.NewRequest(http.MethodPost, profile, bytes.NewReader(marshal(t, data))))
+		require.Equal(t, http.StatusCreated, rr.Code)
+
+		pData, err := svc.getProfileData("profile2")
+		require.NoError(t, err)
+		require.Equal(t, data, pData)
+	})
+
+	t.Run("client secret", func(t *testing.T) {
+		svc := newTestOperation(t, withDIDResolution())
+
+		rr := httptest.NewRecorder()
+
+		svc.createProfile(rr, httptest.NewRequest(http.MethodPost, profile, bytes.NewReader(marshal(t, testProfileData()))))
+		require.Equal(t, http.StatusCreated, rr.Code)
+
+		// the secret is neither returned nor saved with the profile
+		require.NotContains(t, rr.Body.String(), "rpsecret")
+
+		pDataBytes, err := svc.profileStore.Get("profile1")
+		require.NoError(t, err)
+		require.NotContains(t, string(pDataBytes), "rpsecret")
+
+		require.Equal(t, "rpsecret", profileSecretOf(t, svc, "profile1"))
+	})
+
+	t.Run("invalid request", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		rr := httptest.NewRecorder()
+
+		svc.createProfile(rr, httptest.NewRequest(http.MethodPost, profile, bytes.NewReader([]byte("invalid-json"))))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to decode request")
+	})
+
+	t.Run("invalid profile", func(t *testing.T) {
+		svc := newTestOperation(t, withDIDResolution())
+
+		tests := []struct {
+			data *profileData
+			err  string
+		}{
+			{data: &profileData{DID: "did:example:123"}, err: "id is mandatory"},
+			{data: &profileData{ID: "profile2", URL: "/link", DID: "did:example:123"}, err: "invalid url /link"},
+			{
+				data: &profileData{ID: "profile2", Callback: "ftp://cb", DID: "did:example:123"},
+				err:  "invalid url ftp://cb",
+			},
+			{data: &profileData{ID: "profile2"}, err: "did is mandatory"},
+			{
+				data: &profileData{ID: "profile2", DID: "did:example:123", AuthPolicy: &authPolicy{Expiry: 100000}},
+				err:  "invalid auth policy : expiry must be at most 86400 seconds",
+			},
+		}
+
+		for _, tc := range tests {
+			rr := httptest.NewRecorder()
+
+			svc.createProfile(rr, httptest.NewRequest(http.MethodPost, profile, bytes.NewReader(marshal(t, tc.data))))
+			require.Equal(t, http.StatusBadRequest, rr.Code)
+			require.Contains(t, rr.Body.String(), tc.err)
+		}
+
+		svc.vdri = &vdrmock.MockVDRegistry{ResolveErr: errors.New("DID does not exist")}
+
+		rr := httptest.NewRecorder()
+
+		svc.createProfile(rr, httptest.NewRequest(http.MethodPost, profile,
+			bytes.NewReader(marshal(t, &profileData{ID: "profile2", DID: "did:example:123"}))))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "DID does not exist")
+	})
+
+	t.Run("already exists", func(t *testing.T) {
+		svc := newTestOperation(t, withDIDResolution(), withProfile(testProfileData()))
+
+		rr := httptest.NewRecorder()
+
+		svc.createProfile(rr, httptest.NewRequest(http.MethodPost, profile,
+			bytes.NewReader(marshal(t, &profileData{ID: "profile1", DID: "did:example:123"}))))
+		require.Equal(t, http.StatusConflict, rr.Code)
+		require.Contains(t, rr.Body.String(), "profile profile1 already exists")
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		svc := newTestOperation(t, withDIDResolution())
+
+		svc.profileStore = &mockstorage.Store{ErrGet: errors.New("get error")}
+
+		body := marshal(t, &profileData{ID: "profile2", DID: "did:example:123"})
+
+		rr := httptest.NewRecorder()
+
+		svc.createProfile(rr, httptest.NewRequest(http.MethodPost, profile, bytes.NewReader(body)))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to get profile data : id=profile2 - get error")
+
+		svc.profileStore = &mockstorage.Store{ErrGet: storage.ErrDataNotFound, ErrPut: errors.New("save error")}
+
+		rr = httptest.NewRecorder()
+
+		svc.createProfile(rr, httptest.NewRequest(http.MethodPost, profile, bytes.NewReader(body)))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to save profile data: save error")
+	})
+}
+
+func TestGetProfiles(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		svc := newTestOperation(t, withProfile(testProfileData()))
+
+		for _, id := range []string{"profile3", "profile2"} {
+			require.NoError(t, svc.saveProfileData(&profileData{ID: id}))
+		}
+
+		getPage := func(query string) *profilesResp {
+			rr := httptest.NewRecorder()
+
+			svc.getProfiles(rr, httptest.NewRequest(http.MethodGet, profile+query, nil))
+			require.Equal(t, http.StatusOK, rr.Code)
+
+			resp := &profilesResp{}
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+
+			return resp
+		}
+
+		resp := getPage("?limit=2")
+		require.Len(t, resp.Profiles, 2)
+		require.Equal(t, "profile1", resp.Profiles[0].ID)
+		require.Equal(t, "profile2", resp.Profiles[1].ID)
+		require.NotEmpty(t, resp.NextCursor)
+
+		resp = getPage("?limit=2&cursor=" + resp.NextCursor)
+		require.Len(t, resp.Profiles, 1)
+		require.Equal(t, "profile3", resp.Profiles[0].ID)
+		require.Empty(t, resp.NextCursor)
+	})
+
+	t.Run("invalid page", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		rr := httptest.NewRecorder()
+
+		svc.getProfiles(rr, httptest.NewRequest(http.MethodGet, profile+"?cursor=!", nil))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "invalid cursor")
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		svc.profileStore = &mockstorage.Store{ErrQuery: errors.New("query error")}
+
+		rr := httptest.NewRecorder()
+
+		svc.getProfiles(rr, httptest.NewRequest(http.MethodGet, profile, nil))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to get profiles")
+	})
+
+	t.Run("invalid data", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		require.NoError(t, svc.profileStore.Put("profile2", []byte("invalid-json"), storage.Tag{Name: profileTagName}))
+
+		rr := httptest.NewRecorder()
+
+		svc.getProfiles(rr, httptest.NewRequest(http.MethodGet, profile, nil))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to unmarshal profile data")
+	})
+}
+
+func TestGetProfile(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		svc := newTestOperation(t, withProfile(testProfileData()))
+
+		rr := httptest.NewRecorder()
+
+		svc.getProfile(rr, newProfileRequest(http.MethodGet, "profile1", nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		resp := &profileData{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+		require.Equal(t, "profile1", resp.ID)
+		require.Equal(t, "https://profile.example.com", resp.URL)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		rr := httptest.NewRecorder()
+
+		svc.getProfile(rr, newProfileRequest(http.MethodGet, "invalid", nil))
+		require.Equal(t, http.StatusNotFound, rr.Code)
+		require.Contains(t, rr.Body.String(), "profile invalid not found")
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		svc.profileStore = &mockstorage.Store{ErrGet: errors.New("get error")}
+
+		rr := httptest.NewRecorder()
+
+		svc.getProfile(rr, newProfileRequest(http.MethodGet, "profile1", nil))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to get profile data : id=profile1")
+	})
+}
+
+func TestUpdateProfile(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		svc := newTestOperation(t, withDIDResolution(), withProfile(testProfileData()))
+
+		data := &profileData{Name: "Updated", URL: "https://new.example.com", DID: "did:example:456"}
+
+		rr := httptest.NewRecorder()
+
+		svc.updateProfile(rr, newProfileRequest(http.MethodPut, "profile1", marshal(t, data)))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		pData, err := svc.getProfileData("profile1")
+		require.NoError(t, err)
+		require.Equal(t, "Updated", pData.Name)
+		require.Equal(t, "https://new.example.com", pData.URL)
+		require.Equal(t, "did:example:456", pData.DID)
+
+		// the secret is kept if the update has none
+		require.Equal(t, "rpsecret", profileSecretOf(t, svc, "profile1"))
+
+		rr = httptest.NewRecorder()
+
+		svc.updateProfile(rr, newProfileRequest(http.MethodPut, "profile1",
+			marshal(t, &profileReq{profileData: *data, ClientSecret: "newsecret"})))
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.NotContains(t, rr.Body.String(), "newsecret")
+		require.Equal(t, "newsecret", profileSecretOf(t, svc, "profile1"))
+	})
+
+	t.Run("invalid request", func(t *testing.T) {
+		svc := newTestOperation(t, withDIDResolution(), withProfile(testProfileData()))
+
+		rr := httptest.NewRecorder()
+
+		svc.updateProfile(rr, newProfileRequest(http.MethodPut, "profile1", []byte("invalid-json")))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to decode request")
+
+		rr = httptest.NewRecorder()
+
+		svc.updateProfile(rr, newProfileRequest(http.MethodPut, "profile1",
+			marshal(t, &profileData{ID: "profile2", DID: "did:example:123"})))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "profile id can't be changed")
+
+		rr = httptest.NewRecorder()
+
+		svc.updateProfile(rr, newProfileRequest(http.MethodPut, "profile1", marshal(t, &profileData{})))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "invalid profile: did is mandatory")
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		svc := newTestOperation(t, withDIDResolution())
+
+		rr := httptest.NewRecorder()
+
+		svc.updateProfile(rr, newProfileRequest(http.MethodPut, "invalid",
+			marshal(t, &profileData{DID: "did:example:123"})))
+		require.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		svc := newTestOperation(t, withDIDResolution(), withProfile(testProfileData()))
+
+		pDataBytes, err := svc.profileStore.Get("profile1")
+		require.NoError(t, err)
+
+		svc.profileStore = &mockstorage.Store{GetReturn: pDataBytes, ErrPut: errors.New("save error")}
+
+		rr := httptest.NewRecorder()
+
+		svc.updateProfile(rr, newProfileRequest(http.MethodPut, "profile1",
+			marshal(t, &profileData{DID: "did:example:123"})))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to save profile data: save error")
+	})
+}
+
+func TestDeleteProfile(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		svc := newTestOperation(t, withProfile(testProfileData()))
+
+		rr := httptest.NewRecorder()
+
+		svc.deleteProfile(rr, newProfileRequest(http.MethodDelete, "profile1", nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		_, err := svc.getProfileData("profile1")
+		require.True(t, errors.Is(err, storage.ErrDataNotFound))
+
+		_, err = svc.profileSecretStore.Get("profile1")
+		require.True(t, errors.Is(err, storage.ErrDataNotFound))
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		rr := httptest.NewRecorder()
+
+		svc.deleteProfile(rr, newProfileRequest(http.MethodDelete, "invalid", nil))
+		require.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		svc := newTestOperation(t, withProfile(testProfileData()))
+
+		pDataBytes, err := svc.profileStore.Get("profile1")
+		require.NoError(t, err)
+
+		svc.profileStore = &mockstorage.Store{GetReturn: pDataBytes, ErrDelete: errors.New("delete error")}
+
+		rr := httptest.NewRecorder()
+
+		svc.deleteProfile(rr, newProfileRequest(http.MethodDelete, "profile1", nil))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to delete data : id=profile1 - delete error")
+	})
+}
+
+func TestMigrateLegacyProfile(t *testing.T) {
+	t.Run("configured profile", func(t *testing.T) {
+		provider := mem.NewProvider()
+
+		txnStore, err := provider.OpenStore(txnStoreName)
+		require.NoError(t, err)
+
+		require.NoError(t, txnStore.Put("profile1", marshal(t, testProfileData())))
+
+		svc := newTestOperation(t, withConfig(func(config *Config) {
+			config.StoreProvider = provider
+			config.AccountLinkProfile = "profile1"
+		}))
+
+		pDataBytes, err := svc.profileStore.Get("profile1")
+		require.NoError(t, err)
+		require.NotContains(t, string(pDataBytes), "rpsecret")
+		require.Equal(t, "rpsecret", profileSecretOf(t, svc, "profile1"))
+
+		_, err = txnStore.Get("profile1")
+		require.True(t, errors.Is(err, storage.ErrDataNotFound))
+	})
+
+	t.Run("profile read after the start", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		require.NoError(t, svc.store.Put("profile1", marshal(t, testProfileData())))
+
+		pData, err := svc.getProfileData("profile1")
+		require.NoError(t, err)
+		require.Equal(t, &testProfileData().profileData, pData)
+		require.Equal(t, "rpsecret", profileSecretOf(t, svc, "profile1"))
+	})
+
+	t.Run("not a profile", func(t *testing.T) {
+		svc := newTestOperation(t, withUserData(testUserData()))
+
+		for _, id := range []string{sampleUserName, "missing"} {
+			_, err := svc.getProfileData(id)
+			require.True(t, errors.Is(err, storage.ErrDataNotFound))
+		}
+
+		_, err := svc.store.Get(sampleUserName)
+		require.NoError(t, err)
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		require.NoError(t, svc.store.Put("profile1", marshal(t, testProfileData())))
+
+		svc.profileSecretStore = &mockstorage.Store{ErrPut: errors.New("put error")}
+
+		_, err := svc.getProfileData("profile1")
+		require.EqualError(t, err, "get profile data: migrate profile: save profile secret: put error")
+	})
+}
+
+func TestSetProfileCredentials(t *testing.T) {
+	svc := newTestOperation(t, withProfileData(&profileData{ID: "profile2", ClientID: "rp2"}))
+
+	// a profile without a secret
+	req := httptest.NewRequest(http.MethodPost, "https://profile.example.com", nil)
+	require.NoError(t, svc.setProfileCredentials(req, &profileData{ID: "profile2", ClientID: "rp2"}))
+
+	clientID, secret, ok := req.BasicAuth()
+	require.True(t, ok)
+	require.Equal(t, "rp2", clientID)
+	require.Empty(t, secret)
+
+	svc.profileSecretStore = &mockstorage.Store{ErrGet: errors.New("get error")}
+
+	err := svc.setProfileCredentials(req, &profileData{ID: "profile2"})
+	require.EqualError(t, err, "get profile secret: get error")
+
+	svc.profileSecretStore = &mockstorage.Store{GetReturn: []byte("invalid-json")}
+
+	err = svc.setProfileCredentials(req, &profileData{ID: "profile2"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unmarshal profile secret")
+}
+
+// profileSecretOf returns the client secret sent to the service of the profile.
+func profileSecretOf(t *testing.T, svc *Operation, id string) string {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "https://profile.example.com", nil)
+	require.NoError(t, svc.setProfileCredentials(req, &profileData{ID: id}))
+
+	_, secret, ok := req.BasicAuth()
+	require.True(t, ok)
+
+	return secret
+}
+
+func newProfileRequest(method, id string, body []byte) *http.Request {
+	return mux.SetURLVars(httptest.NewRequest(method, profile+"/"+id, bytes.NewReader(body)),
+		map[string]string{"id": id})
+}
+
+func marshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+
+	b, err := json.Marshal(v)
+	require.NoError(t, err)
+
+	return b
+}
@@ -0,0 +1,338 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dchest/uniuri"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/util"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+)
+
+const (
+	registrationStoreName = "registration"
+	registrationTagName   = "registration"
+
+	registration = register + "/{id}"
+
+	// registrationIDParam is the form param of the id of a registration to retry
+	registrationIDParam  = "registrationID"
+	registrationIDHeader = "X-Registration-ID"
+
+	// steps of the registration saga
+	registrationReserved       = "reserved"
+	registrationVaultCreated   = "vaultCreated"
+	registrationDocumentsSaved = "documentsSaved"
+	registrationCompleted      = "completed"
+	registrationRolledBack     = "rolledBack"
+	registrationRollbackFailed = "rollbackFailed"
+
+	// defaultRegistrationTimeout is the time after which an unfinished registration is rolled back.
+	defaultRegistrationTimeout = 5 * time.Minute
+)
+
+var (
+	errRegistrationInProgress = errors.New("registration in progress")
+	errInvalidRegistration    = errors.New("invalid registration")
+)
+
+// startRegistration reserves the username for a new registration, or for the retry of a registration which was
+// rolled back. A completed registration is returned as is.
+func (o *Operation) startRegistration(id, name string, cred *userCredential) (*userRegistration, error) {
+	reg := &userRegistration{ID: id, UserName: name, CreatedTime: util.NewTime(time.Now())}
+
+	if id == "" {
+		reg.ID = uuid.NewString()
+	} else {
+		existing, err := o.getRegistration(id)
+		if err != nil && !errors.Is(err, storage.ErrDataNotFound) {
+			return nil, err
+		}
+
+		if err == nil {
+			if existing.UserName != name {
+				return nil, fmt.Errorf("%w: registration %s is for another username", errInvalidRegistration, id)
+			}
+
+			switch {
+			case existing.Status == registrationCompleted:
+				return existing, nil
+			case existing.Status == registrationRolledBack:
+			case existing.Status == registrationRollbackFailed || existing.stale(o.registrationTimeout):
+				// the resources of the failed registration are released before the retry
+				err = o.compensateRegistration(existing)
+				if err != nil {
+					return nil, fmt.Errorf("rollback registration %s : %w", id, err)
+				}
+			default:
+				return nil, fmt.Errorf("%w: %s", errRegistrationInProgress, id)
+			}
+
+			reg.CreatedTime = existing.CreatedTime
+		}
+	}
+
+	err := o.reserveUsername(name, cred)
+	if err != nil {
+		return nil, err
+	}
+
+	err = o.updateRegistration(reg, registrationReserved)
+	if err != nil {
+		o.releaseUsername(name)
+
+		return nil, err
+	}
+
+	return reg, nil
+}
+
+// runRegistration creates the vault and saves the documents of the user before the user data is committed. The
+// registration is saved after each step, so that the resources can be released if the registration fails.
+func (o *Operation) runRegistration(ctx context.Context, reg *userRegistration, values map[string]string) error {
+	vaultData, err := o.vClient.CreateVault()
+	if err != nil {
+		return fmt.Errorf("failed to store documents in vault - err:create vault : %w", err)
+	}
+
+	reg.VaultID = vaultData.ID
+
+	err = o.updateRegistration(reg, registrationVaultCreated)
+	if err != nil {
+		return err
+	}
+
+	docs, err := o.storeDocuments(ctx, reg.VaultID, values)
+	if err != nil {
+		return fmt.Errorf("failed to store documents in vault - err:%w", err)
+	}
+
+	reg.Documents = docs
+	reg.UserID = strings.ToUpper(uniuri.NewLen(userIDLen))
+
+	err = o.updateRegistration(reg, registrationDocumentsSaved)
+	if err != nil {
+		return err
+	}
+
+	err = o.commitRegistration(reg)
+	if err != nil {
+		return err
+	}
+
+	return o.updateRegistration(reg, registrationCompleted)
+}
+
+// commitRegistration saves the user data and the id mapping of the user.
+func (o *Operation) commitRegistration(reg *userRegistration) error {
+	uDataBytes, err := json.Marshal(userData{
+		ID:              reg.UserID,
+		UserName:        reg.UserName,
+		VaultID:         reg.VaultID,
+		NationalIDDocID: reg.Documents[nationalID],
+		Documents:       reg.Documents,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal user data - err:%w", err)
+	}
+
+	err = o.store.Put(reg.UserName, uDataBytes)
+	if err != nil {
+		return fmt.Errorf("unable to save user data %s: %w", reg.UserName, err)
+	}
+
+	createdTime := time.Now()
+
+	uMapBytes, err := json.Marshal(userIDNameMap{
+		ID:          reg.UserID,
+		UserName:    reg.UserName,
+		CreatedTime: util.NewTime(createdTime),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal user data - err:%w", err)
+	}
+
+	err = o.userStore.Put(reg.UserID, uMapBytes, storage.Tag{Name: userTagName}, createdTag(createdTime))
+	if err != nil {
+		return fmt.Errorf("unable to save id-username mapping data: %w", err)
+	}
+
+	return nil
+}
+
+// rollbackRegistration releases the resources created by the failed registration.
+func (o *Operation) rollbackRegistration(reg *userRegistration, cause error) {
+	reg.Error = cause.Error()
+
+	err := o.compensateRegistration(reg)
+	if err != nil {
+		logger.Errorf("failed to rollback registration %s : %s", reg.ID, err.Error())
+	}
+}
+
+// compensateRegistration deletes the user data, the vault and the username reservation of the registration, in the
+// reverse order of their creation. The registration can be compensated again if a step fails.
+func (o *Operation) compensateRegistration(reg *userRegistration) error {
+	if reg.UserID != "" {
+		err := o.userStore.Delete(reg.UserID)
+		if err != nil && !errors.Is(err, storage.ErrDataNotFound) {
+			return o.failRollback(reg, fmt.Errorf("delete id-username mapping : %w", err))
+		}
+
+		err = o.store.Delete(reg.UserName)
+		if err != nil && !errors.Is(err, storage.ErrDataNotFound) {
+			return o.failRollback(reg, fmt.Errorf("delete user data : %w", err))
+		}
+	}
+
+	if reg.VaultID != "" {
+		err := o.vClient.DeleteVault(reg.VaultID)
+		if err != nil {
+			return o.failRollback(reg, fmt.Errorf("delete vault : %w", err))
+		}
+	}
+
+	o.releaseUsername(reg.UserName)
+
+	return o.updateRegistration(reg, registrationRolledBack)
+}
+
+func (o *Operation) failRollback(reg *userRegistration, err error) error {
+	updateErr := o.updateRegistration(reg, registrationRollbackFailed)
+	if updateErr != nil {
+		logger.Errorf("failed to save registration %s : %s", reg.ID, updateErr.Error())
+	}
+
+	return err
+}
+
+// recoverRegistrations rolls back the registrations which were interrupted by a restart. The unfinished registrations
+// which may still be running on another instance are rolled back once they become stale.
+func (o *Operation) recoverRegistrations() {
+	err := forEachValue(o.registrationStore, registrationTagName, func(value []byte) error {
+		reg := &userRegistration{}
+
+		err := json.Unmarshal(value, reg)
+		if err != nil {
+			logger.Warnf("invalid registration: %s", err.Error())
+
+			return nil
+		}
+
+		o.rollbackWhenStale(reg)
+
+		return nil
+	})
+	if err != nil {
+		logger.Warnf("failed to get the unfinished registrations: %s", err.Error())
+	}
+}
+
+// rollbackWhenStale rolls back the unfinished registration if it is stale, or checks it again when it would become
+// stale.
+func (o *Operation) rollbackWhenStale(reg *userRegistration) {
+	switch {
+	case reg.finished():
+	case reg.stale(o.registrationTimeout):
+		go o.rollbackRegistration(reg, errors.New("registration interrupted"))
+	default:
+		time.AfterFunc(time.Until(reg.UpdatedTime.Time.Add(o.registrationTimeout)), func() {
+			// the registration is checked again on the next start
+			if o.ctx.Err() != nil {
+				return
+			}
+
+			current, err := o.getRegistration(reg.ID)
+			if err != nil {
+				logger.Warnf("failed to get registration %s : %s", reg.ID, err.Error())
+
+				return
+			}
+
+			o.rollbackWhenStale(current)
+		})
+	}
+}
+
+func (o *Operation) getRegistrationStatus(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	reg, err := o.getRegistration(id)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		o.writeErrorResponse(w, http.StatusNotFound, fmt.Sprintf("registration %s not found", id))
+
+		return
+	}
+
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to get registration %s : %s", id, err.Error()))
+
+		return
+	}
+
+	o.writeResponse(w, http.StatusOK, &registrationResp{
+		ID:          reg.ID,
+		UserName:    reg.UserName,
+		Status:      reg.Status,
+		Error:       reg.Error,
+		CreatedTime: reg.CreatedTime,
+		UpdatedTime: reg.UpdatedTime,
+	})
+}
+
+func (o *Operation) getRegistration(id string) (*userRegistration, error) {
+	regBytes, err := o.registrationStore.Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("get registration : %w", err)
+	}
+
+	reg := &userRegistration{}
+
+	err = json.Unmarshal(regBytes, reg)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal registration : %w", err)
+	}
+
+	return reg, nil
+}
+
+func (o *Operation) updateRegistration(reg *userRegistration, status string) error {
+	reg.Status = status
+	reg.UpdatedTime = util.NewTime(time.Now())
+
+	regBytes, err := json.Marshal(reg)
+	if err != nil {
+		return fmt.Errorf("marshal registration : %w", err)
+	}
+
+	err = o.registrationStore.Put(reg.ID, regBytes, storage.Tag{Name: registrationTagName})
+	if err != nil {
+		return fmt.Errorf("save registration : %w", err)
+	}
+
+	return nil
+}
+
+// finished returns true if the registration completed or rolled back.
+func (r *userRegistration) finished() bool {
+	return r.Status == registrationCompleted || r.Status == registrationRolledBack
+}
+
+// stale returns true if the registration didn't complete or roll back within the timeout.
+func (r *userRegistration) stale(timeout time.Duration) bool {
+	return !r.finished() && (r.UpdatedTime == nil || time.Since(r.UpdatedTime.Time) >= timeout)
+}
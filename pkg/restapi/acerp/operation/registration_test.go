@@ -0,0 +1,305 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/util"
+	vdrapi "github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+	vdrmock "github.com/hyperledger/aries-framework-go/pkg/mock/vdr"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/sandbox/pkg/vdr"
+)
+
+func TestRegistration(t *testing.T) {
+	t.Run("rollback and retry", func(t *testing.T) {
+		vClient := &mockVaultClient{}
+
+		svc := newTestOperation(t, withUserCreation(t, vClient), withDashboardHTML(newTestHTMLFile(t)))
+		vClient.SaveDocErr = errors.New("save error")
+
+		rr := httptest.NewRecorder()
+
+		svc.register(rr, newRegisterRequest("", samplePassword))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(),
+			"failed to store documents in vault - err:save nationalID doc : failed to save doc : save error")
+
+		id := rr.Header().Get(registrationIDHeader)
+		require.NotEmpty(t, id)
+		require.Equal(t, []string{"did:key:123"}, vClient.deletedVaults)
+
+		reg, err := svc.getRegistration(id)
+		require.NoError(t, err)
+		require.Equal(t, registrationRolledBack, reg.Status)
+		require.Contains(t, reg.Error, "save error")
+
+		_, err = svc.getUserCredential(sampleUserName)
+		require.True(t, errors.Is(err, storage.ErrDataNotFound))
+
+		_, err = svc.getUserData(sampleUserName)
+		require.Error(t, err)
+
+		vClient.SaveDocErr = nil
+		rr = httptest.NewRecorder()
+
+		svc.register(rr, newRegisterRequest(id, samplePassword))
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Equal(t, id, rr.Header().Get(registrationIDHeader))
+
+		reg, err = svc.getRegistration(id)
+		require.NoError(t, err)
+		require.Equal(t, registrationCompleted, reg.Status)
+
+		u, err := svc.getUserData(sampleUserName)
+		require.NoError(t, err)
+		require.Equal(t, reg.UserID, u.ID)
+		require.Equal(t, reg.Documents[nationalID], u.NationalIDDocID)
+	})
+
+	t.Run("vault did not resolved", func(t *testing.T) {
+		vClient := &mockVaultClient{}
+
+		svc := newTestOperation(t, withUserCreation(t, vClient), withDashboardHTML(newTestHTMLFile(t)))
+		svc.vdri = &vdrmock.MockVDRegistry{ResolveErr: vdrapi.ErrNotFound}
+		svc.didBackoff = vdr.Backoff{Retries: 2, InitialDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+		rr := httptest.NewRecorder()
+
+		svc.register(rr, newRegisterRequest("", samplePassword))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "resolve did did:key:123 : did did:key:123 not found after 2 retries")
+		require.Equal(t, []string{"did:key:123"}, vClient.deletedVaults)
+
+		reg, err := svc.getRegistration(rr.Header().Get(registrationIDHeader))
+		require.NoError(t, err)
+		require.Equal(t, registrationRolledBack, reg.Status)
+	})
+
+	t.Run("retry of a completed registration", func(t *testing.T) {
+		vClient := &mockVaultClient{}
+
+		svc := newTestOperation(t, withUserCreation(t, vClient), withDashboardHTML(newTestHTMLFile(t)))
+
+		rr := httptest.NewRecorder()
+
+		svc.register(rr, newRegisterRequest("", samplePassword))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		id := rr.Header().Get(registrationIDHeader)
+
+		vClient.CreateVaultErr = errors.New("vault error")
+		rr = httptest.NewRecorder()
+
+		svc.register(rr, newRegisterRequest(id, samplePassword))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		rr = httptest.NewRecorder()
+
+		svc.register(rr, newRegisterRequest(id, "Wrong-Passw0rd!"))
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("registration in progress", func(t *testing.T) {
+		vClient := &mockVaultClient{}
+
+		svc := newTestOperation(t, withUserCreation(t, vClient), withDashboardHTML(newTestHTMLFile(t)))
+
+		require.NoError(t, svc.updateRegistration(&userRegistration{ID: "reg1", UserName: sampleUserName},
+			registrationVaultCreated))
+
+		rr := httptest.NewRecorder()
+
+		svc.register(rr, newRegisterRequest("reg1", samplePassword))
+		require.Equal(t, http.StatusConflict, rr.Code)
+		require.Contains(t, rr.Body.String(), "registration in progress")
+
+		// the stale registration is rolled back before the retry
+		reg := &userRegistration{ID: "reg1", UserName: sampleUserName, Status: registrationVaultCreated,
+			VaultID: "vault1", UpdatedTime: util.NewTime(time.Now().Add(-2 * defaultRegistrationTimeout))}
+		regBytes, err := json.Marshal(reg)
+		require.NoError(t, err)
+		require.NoError(t, svc.registrationStore.Put(reg.ID, regBytes, storage.Tag{Name: registrationTagName}))
+
+		rr = httptest.NewRecorder()
+
+		svc.register(rr, newRegisterRequest("reg1", samplePassword))
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Equal(t, []string{"vault1"}, vClient.deletedVaults)
+	})
+
+	t.Run("registration of another username", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		require.NoError(t, svc.updateRegistration(&userRegistration{ID: "reg1", UserName: "jane.doe@example.com"},
+			registrationRolledBack))
+
+		rr := httptest.NewRecorder()
+
+		svc.register(rr, newRegisterRequest("reg1", samplePassword))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "invalid registration")
+	})
+
+	t.Run("rollback failed", func(t *testing.T) {
+		vClient := &mockVaultClient{}
+
+		svc := newTestOperation(t, withUserCreation(t, vClient), withDashboardHTML(newTestHTMLFile(t)))
+		vClient.SaveDocErr = errors.New("save error")
+		vClient.DeleteVaultErr = errors.New("delete error")
+
+		rr := httptest.NewRecorder()
+
+		svc.register(rr, newRegisterRequest("", samplePassword))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+
+		id := rr.Header().Get(registrationIDHeader)
+
+		reg, err := svc.getRegistration(id)
+		require.NoError(t, err)
+		require.Equal(t, registrationRollbackFailed, reg.Status)
+
+		// the username is kept until the vault is deleted
+		_, err = svc.getUserCredential(sampleUserName)
+		require.NoError(t, err)
+
+		rr = httptest.NewRecorder()
+
+		svc.register(rr, newRegisterRequest(id, samplePassword))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "rollback registration")
+
+		vClient.SaveDocErr = nil
+		vClient.DeleteVaultErr = nil
+		rr = httptest.NewRecorder()
+
+		svc.register(rr, newRegisterRequest(id, samplePassword))
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Equal(t, []string{"did:key:123"}, vClient.deletedVaults)
+	})
+
+	t.Run("recover interrupted registrations", func(t *testing.T) {
+		vClient := &mockVaultClient{}
+
+		svc := newTestOperation(t, withUserCreation(t, vClient), withDashboardHTML(newTestHTMLFile(t)))
+
+		reg := &userRegistration{ID: "reg1", UserName: sampleUserName, VaultID: "vault1"}
+		require.NoError(t, svc.updateRegistration(reg, registrationVaultCreated))
+		require.NoError(t, svc.updateRegistration(&userRegistration{ID: "reg2", UserName: "jane.doe@example.com"},
+			registrationReserved))
+
+		// reg1 was interrupted before the timeout
+		reg.UpdatedTime = util.NewTime(time.Now().Add(-2 * defaultRegistrationTimeout))
+		regBytes, err := json.Marshal(reg)
+		require.NoError(t, err)
+		require.NoError(t, svc.registrationStore.Put(reg.ID, regBytes, storage.Tag{Name: registrationTagName}))
+
+		svc.recoverRegistrations()
+
+		require.Eventually(t, func() bool {
+			r, err := svc.getRegistration("reg1")
+
+			return err == nil && r.Status == registrationRolledBack
+		}, time.Second, 10*time.Millisecond)
+
+		require.Equal(t, []string{"vault1"}, vClient.deletedVaults)
+
+		r, err := svc.getRegistration("reg2")
+		require.NoError(t, err)
+		require.Equal(t, registrationReserved, r.Status)
+	})
+
+	t.Run("unfinished registrations are rolled back once stale", func(t *testing.T) {
+		vClient := &mockVaultClient{}
+
+		svc := newTestOperation(t, withVaultClient(vClient), withConfig(func(config *Config) {
+			config.RegistrationTimeout = 50 * time.Millisecond
+		}))
+
+		require.NoError(t, svc.updateRegistration(&userRegistration{ID: "reg1", UserName: sampleUserName,
+			VaultID: "vault1"}, registrationVaultCreated))
+		require.NoError(t, svc.updateRegistration(&userRegistration{ID: "reg2", UserName: "jane.doe@example.com",
+			VaultID: "vault2"}, registrationVaultCreated))
+
+		svc.recoverRegistrations()
+
+		// reg2 completes before it becomes stale
+		require.NoError(t, svc.updateRegistration(&userRegistration{ID: "reg2", UserName: "jane.doe@example.com",
+			VaultID: "vault2"}, registrationCompleted))
+
+		r, err := svc.getRegistration("reg1")
+		require.NoError(t, err)
+		require.Equal(t, registrationVaultCreated, r.Status)
+
+		require.Eventually(t, func() bool {
+			r, err := svc.getRegistration("reg1")
+
+			return err == nil && r.Status == registrationRolledBack
+		}, time.Second, 10*time.Millisecond)
+
+		require.Equal(t, []string{"vault1"}, vClient.deletedVaults)
+
+		r, err = svc.getRegistration("reg2")
+		require.NoError(t, err)
+		require.Equal(t, registrationCompleted, r.Status)
+	})
+}
+
+func TestGetRegistrationStatus(t *testing.T) {
+	svc := newTestOperation(t)
+
+	require.NoError(t, svc.updateRegistration(&userRegistration{
+		ID: "reg1", UserName: sampleUserName, VaultID: "vault1", Error: "save error",
+	}, registrationRolledBack))
+
+	t.Run("success", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+
+		svc.getRegistrationStatus(rr, mux.SetURLVars(httptest.NewRequest(http.MethodGet, registration, nil),
+			map[string]string{"id": "reg1"}))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var resp *registrationResp
+
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		require.Equal(t, "reg1", resp.ID)
+		require.Equal(t, registrationRolledBack, resp.Status)
+		require.Equal(t, "save error", resp.Error)
+		require.NotContains(t, rr.Body.String(), "vault1")
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+
+		svc.getRegistrationStatus(rr, mux.SetURLVars(httptest.NewRequest(http.MethodGet, registration, nil),
+			map[string]string{"id": "invalid"}))
+		require.Equal(t, http.StatusNotFound, rr.Code)
+		require.Contains(t, rr.Body.String(), "registration invalid not found")
+	})
+}
+
+func newRegisterRequest(id, pwd string) *http.Request {
+	req := &http.Request{Form: make(map[string][]string)}
+	req.Form.Add(username, sampleUserName)
+	req.Form.Add(password, pwd)
+	req.Form.Add(nationalID, sampleNationalID)
+
+	if id != "" {
+		req.Form.Add(registrationIDParam, id)
+	}
+
+	return req
+}
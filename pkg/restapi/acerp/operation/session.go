@@ -0,0 +1,332 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+)
+
+const (
+	sessionStoreName   = "session"
+	sessionUserTagName = "sessionUser"
+
+	sessionCookie = "ace_session"
+
+	defaultSessionIdleTimeout     = 30 * time.Minute
+	defaultSessionAbsoluteTimeout = 12 * time.Hour
+)
+
+var (
+	errNoSession      = errors.New("no session")
+	errSessionExpired = errors.New("session expired")
+)
+
+// userSession is the server-side session of a logged in user. The id of the session is only sent in the session
+// cookie, and the CSRF token in the forms of the pages of the session.
+type userSession struct {
+	ID           string    `json:"id"`
+	CSRFToken    string    `json:"csrfToken"`
+	UserName     string    `json:"userName"`
+	CreatedTime  time.Time `json:"createdTime"`
+	LastSeenTime time.Time `json:"lastSeenTime"`
+}
+
+// startSession issues a new session to the user who has just authenticated. The session of the request, if any, is
+// invalidated, so that a session id planted before the login can't be used.
+func (o *Operation) startSession(w http.ResponseWriter, r *http.Request, userName string) (*userSession, error) {
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		o.deleteSession(c.Value)
+	}
+
+	o.deleteExpiredSessions(userName)
+
+	now := time.Now()
+
+	return o.issueSession(w, &userSession{UserName: userName, CreatedTime: now, LastSeenTime: now})
+}
+
+// startSessionOrWriteError starts the session of the user, or writes the error.
+func (o *Operation) startSessionOrWriteError(w http.ResponseWriter, r *http.Request,
+	userName string) (*userSession, bool) {
+	s, err := o.startSession(w, r, userName)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to start session : %s", err.Error()))
+
+		return nil, false
+	}
+
+	return s, true
+}
+
+// requireSession returns the valid session of the request, and refreshes its idle timeout. A 401 is written if the
+// request has no valid session.
+func (o *Operation) requireSession(w http.ResponseWriter, r *http.Request) (*userSession, bool) {
+	s, err := o.getSession(r)
+
+	switch {
+	case errors.Is(err, errNoSession), errors.Is(err, errSessionExpired):
+		clearSessionCookie(w)
+		o.writeErrorResponse(w, http.StatusUnauthorized, err.Error())
+
+		return nil, false
+	case err != nil:
+		o.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to get session : %s", err.Error()))
+
+		return nil, false
+	}
+
+	s.LastSeenTime = time.Now()
+
+	err = o.saveSession(s)
+	if err != nil {
+		o.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to save session : %s", err.Error()))
+
+		return nil, false
+	}
+
+	return s, true
+}
+
+// requireCSRFToken checks that the form has the CSRF token of the session, as the session cookie is also sent with
+// the top level navigations from other sites.
+func (o *Operation) requireCSRFToken(w http.ResponseWriter, r *http.Request, s *userSession) bool {
+	if subtle.ConstantTimeCompare([]byte(r.FormValue(csrfTokenParam)), []byte(s.CSRFToken)) != 1 {
+		o.writeErrorResponse(w, http.StatusForbidden, "invalid csrf token")
+
+		return false
+	}
+
+	return true
+}
+
+// rotateSession replaces the session with a new id, after a change of the privileges of the user. The absolute
+// timeout still runs from the login.
+func (o *Operation) rotateSession(w http.ResponseWriter, s *userSession) (*userSession, error) {
+	o.deleteSession(s.ID)
+
+	return o.issueSession(w, &userSession{UserName: s.UserName, CreatedTime: s.CreatedTime, LastSeenTime: time.Now()})
+}
+
+// renewSessions invalidates the other sessions of the user after a change of the credentials, and rotates the
+// session of the request if it belongs to the user.
+func (o *Operation) renewSessions(w http.ResponseWriter, r *http.Request, userName string) error {
+	current, err := o.getSession(r)
+	if err != nil || current.UserName != userName {
+		current = nil
+	}
+
+	exceptID := ""
+	if current != nil {
+		exceptID = current.ID
+	}
+
+	err = o.invalidateUserSessions(userName, exceptID)
+	if err != nil {
+		return err
+	}
+
+	if current != nil {
+		_, err = o.rotateSession(w, current)
+	}
+
+	return err
+}
+
+// endSession invalidates the session of the request on the server and clears the session cookie.
+func (o *Operation) endSession(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		o.deleteSession(c.Value)
+	}
+
+	clearSessionCookie(w)
+}
+
+// invalidateUserSessions deletes the sessions of the user, except the given one.
+func (o *Operation) invalidateUserSessions(userName, exceptID string) error {
+	sessions, err := o.getUserSessions(userName)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range sessions {
+		if s.ID != exceptID {
+			o.deleteSession(s.ID)
+		}
+	}
+
+	return nil
+}
+
+func (o *Operation) issueSession(w http.ResponseWriter, s *userSession) (*userSession, error) {
+	s.ID = uuid.NewString()
+	s.CSRFToken = uuid.NewString()
+
+	err := o.saveSession(s)
+	if err != nil {
+		return nil, err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    s.ID,
+		Path:     "/",
+		Expires:  s.CreatedTime.Add(o.sessionAbsoluteTimeout),
+		HttpOnly: true,
+		Secure:   true,
+		// lax, so that the cookie is sent when the linked service redirects the user back
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return s, nil
+}
+
+func (o *Operation) getSession(r *http.Request) (*userSession, error) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil || c.Value == "" {
+		return nil, errNoSession
+	}
+
+	sessionBytes, err := o.sessionStore.Get(c.Value)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		return nil, errNoSession
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("get session : %w", err)
+	}
+
+	s := &userSession{}
+
+	err = json.Unmarshal(sessionBytes, s)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal session : %w", err)
+	}
+
+	if o.sessionExpired(s, time.Now()) {
+		o.deleteSession(s.ID)
+
+		return nil, errSessionExpired
+	}
+
+	return s, nil
+}
+
+func (o *Operation) sessionExpired(s *userSession, now time.Time) bool {
+	return now.Sub(s.LastSeenTime) >= o.sessionIdleTimeout || now.Sub(s.CreatedTime) >= o.sessionAbsoluteTimeout
+}
+
+func (o *Operation) saveSession(s *userSession) error {
+	sessionBytes, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("marshal session : %w", err)
+	}
+
+	err = o.sessionStore.Put(s.ID, sessionBytes, sessionUserTag(s.UserName))
+	if err != nil {
+		return fmt.Errorf("save session : %w", err)
+	}
+
+	return nil
+}
+
+func (o *Operation) deleteSession(id string) {
+	err := o.sessionStore.Delete(id)
+	if err != nil && !errors.Is(err, storage.ErrDataNotFound) {
+		logger.Warnf("failed to delete session : %s", err.Error())
+	}
+}
+
+// deleteExpiredSessions deletes the expired sessions of the user, which are otherwise only deleted when they are
+// used.
+func (o *Operation) deleteExpiredSessions(userName string) {
+	sessions, err := o.getUserSessions(userName)
+	if err != nil {
+		logger.Warnf("failed to get sessions of user %s : %s", userName, err.Error())
+
+		return
+	}
+
+	now := time.Now()
+
+	for _, s := range sessions {
+		if o.sessionExpired(s, now) {
+			o.deleteSession(s.ID)
+		}
+	}
+}
+
+func (o *Operation) getUserSessions(userName string) ([]*userSession, error) {
+	tag := sessionUserTag(userName)
+
+	iter, err := o.sessionStore.Query(fmt.Sprintf("%s:%s", tag.Name, tag.Value))
+	if err != nil {
+		return nil, fmt.Errorf("query sessions : %w", err)
+	}
+
+	defer func() {
+		err = iter.Close()
+		if err != nil {
+			logger.Warnf("failed to close session iterator: %s", err.Error())
+		}
+	}()
+
+	sessions := make([]*userSession, 0)
+
+	more, err := iter.Next()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get next session : %w", err)
+	}
+
+	for more {
+		value, err := iter.Value()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get session value : %w", err)
+		}
+
+		s := &userSession{}
+
+		err = json.Unmarshal(value, s)
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal session : %w", err)
+		}
+
+		sessions = append(sessions, s)
+
+		more, err = iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get next session : %w", err)
+		}
+	}
+
+	return sessions, nil
+}
+
+// sessionUserTag encodes the user name, which may contain ':'.
+func sessionUserTag(userName string) storage.Tag {
+	return storage.Tag{Name: sessionUserTagName, Value: base64.RawURLEncoding.EncodeToString([]byte(userName))}
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,282 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	mockstorage "github.com/hyperledger/aries-framework-go/component/storageutil/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartSession(t *testing.T) {
+	t.Run("cookie", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		rr := httptest.NewRecorder()
+
+		s, err := svc.startSession(rr, httptest.NewRequest(http.MethodPost, login, nil), sampleUserName)
+		require.NoError(t, err)
+
+		c := sessionCookieOf(t, rr)
+		require.Equal(t, s.ID, c.Value)
+		require.True(t, c.HttpOnly)
+		require.True(t, c.Secure)
+		require.Equal(t, http.SameSiteLaxMode, c.SameSite)
+		require.Equal(t, "/", c.Path)
+	})
+
+	t.Run("session of the request is invalidated", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		req := newSessionRequest(t, svc, http.MethodPost, login, "attacker")
+
+		s, err := svc.startSession(httptest.NewRecorder(), req, sampleUserName)
+		require.NoError(t, err)
+
+		_, err = svc.getSession(req)
+		require.True(t, errors.Is(err, errNoSession))
+
+		sessions, err := svc.getUserSessions(sampleUserName)
+		require.NoError(t, err)
+		require.Len(t, sessions, 1)
+		require.Equal(t, s.ID, sessions[0].ID)
+	})
+
+	t.Run("expired sessions of the user are deleted", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		expired := &userSession{
+			ID:           "expired",
+			UserName:     sampleUserName,
+			CreatedTime:  time.Now().Add(-time.Hour),
+			LastSeenTime: time.Now().Add(-time.Hour),
+		}
+		require.NoError(t, svc.saveSession(expired))
+
+		_, err := svc.startSession(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, login, nil),
+			sampleUserName)
+		require.NoError(t, err)
+
+		sessions, err := svc.getUserSessions(sampleUserName)
+		require.NoError(t, err)
+		require.Len(t, sessions, 1)
+		require.NotEqual(t, "expired", sessions[0].ID)
+	})
+}
+
+func TestRequireSession(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		req := newSessionRequest(t, svc, http.MethodGet, dashboard, sampleUserName)
+
+		before, err := svc.getSession(req)
+		require.NoError(t, err)
+
+		s, ok := svc.requireSession(httptest.NewRecorder(), req)
+		require.True(t, ok)
+		require.Equal(t, sampleUserName, s.UserName)
+		require.True(t, s.LastSeenTime.After(before.LastSeenTime))
+	})
+
+	t.Run("no cookie", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		rr := httptest.NewRecorder()
+
+		_, ok := svc.requireSession(rr, httptest.NewRequest(http.MethodGet, dashboard, nil))
+		require.False(t, ok)
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+		require.Contains(t, rr.Body.String(), "no session")
+	})
+
+	t.Run("unknown session", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		req := httptest.NewRequest(http.MethodGet, dashboard, nil)
+		req.AddCookie(&http.Cookie{Name: sessionCookie, Value: "unknown"})
+
+		rr := httptest.NewRecorder()
+
+		_, ok := svc.requireSession(rr, req)
+		require.False(t, ok)
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+		require.Equal(t, -1, sessionCookieOf(t, rr).MaxAge)
+	})
+
+	t.Run("idle timeout", func(t *testing.T) {
+		svc := newTestOperation(t)
+		svc.sessionIdleTimeout = time.Millisecond
+
+		req := newSessionRequest(t, svc, http.MethodGet, dashboard, sampleUserName)
+
+		time.Sleep(2 * time.Millisecond)
+
+		rr := httptest.NewRecorder()
+
+		_, ok := svc.requireSession(rr, req)
+		require.False(t, ok)
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+		require.Contains(t, rr.Body.String(), "session expired")
+
+		sessions, err := svc.getUserSessions(sampleUserName)
+		require.NoError(t, err)
+		require.Empty(t, sessions)
+	})
+
+	t.Run("absolute timeout", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		req := newSessionRequest(t, svc, http.MethodGet, dashboard, sampleUserName)
+
+		s, err := svc.getSession(req)
+		require.NoError(t, err)
+
+		// the session is used, but was created before the absolute timeout
+		s.CreatedTime = time.Now().Add(-svc.sessionAbsoluteTimeout)
+		require.NoError(t, svc.saveSession(s))
+
+		rr := httptest.NewRecorder()
+
+		_, ok := svc.requireSession(rr, req)
+		require.False(t, ok)
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+		require.Contains(t, rr.Body.String(), "session expired")
+	})
+
+	t.Run("store errors", func(t *testing.T) {
+		svc := newTestOperation(t)
+
+		req := newSessionRequest(t, svc, http.MethodGet, dashboard, sampleUserName)
+
+		svc.sessionStore = &mockstorage.Store{ErrGet: errors.New("get error")}
+
+		rr := httptest.NewRecorder()
+
+		_, ok := svc.requireSession(rr, req)
+		require.False(t, ok)
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to get session : get session : get error")
+
+		svc.sessionStore = &mockstorage.Store{GetReturn: []byte("invalid-json")}
+
+		rr = httptest.NewRecorder()
+
+		_, ok = svc.requireSession(rr, req)
+		require.False(t, ok)
+		require.Contains(t, rr.Body.String(), "unmarshal session")
+	})
+}
+
+func TestRenewSessions(t *testing.T) {
+	svc := newTestOperation(t)
+
+	req := newSessionRequest(t, svc, http.MethodPost, changePassword, sampleUserName)
+	other := newSessionRequest(t, svc, http.MethodGet, dashboard, sampleUserName)
+	otherUser := newSessionRequest(t, svc, http.MethodGet, dashboard, "other")
+
+	current, err := svc.getSession(req)
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	require.NoError(t, svc.renewSessions(rr, req, sampleUserName))
+
+	// the session of the request is rotated
+	_, err = svc.getSession(req)
+	require.True(t, errors.Is(err, errNoSession))
+
+	rotated := &http.Request{Header: http.Header{}}
+	rotated.AddCookie(sessionCookieOf(t, rr))
+
+	s, err := svc.getSession(rotated)
+	require.NoError(t, err)
+	require.NotEqual(t, current.ID, s.ID)
+	require.Equal(t, sampleUserName, s.UserName)
+	require.True(t, current.CreatedTime.Equal(s.CreatedTime))
+
+	// the other sessions of the user are invalidated
+	_, err = svc.getSession(other)
+	require.True(t, errors.Is(err, errNoSession))
+
+	_, err = svc.getSession(otherUser)
+	require.NoError(t, err)
+
+	svc.sessionStore = &mockstorage.Store{ErrQuery: errors.New("query error")}
+
+	err = svc.renewSessions(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, changePassword, nil),
+		sampleUserName)
+	require.EqualError(t, err, "query sessions : query error")
+}
+
+func TestEndSession(t *testing.T) {
+	svc := newTestOperation(t)
+
+	req := newSessionRequest(t, svc, http.MethodGet, logout, sampleUserName)
+
+	rr := httptest.NewRecorder()
+
+	svc.endSession(rr, req)
+	require.Equal(t, -1, sessionCookieOf(t, rr).MaxAge)
+
+	_, err := svc.getSession(req)
+	require.True(t, errors.Is(err, errNoSession))
+}
+
+// newSessionRequest returns a request with the cookie of a new session of the user.
+func newSessionRequest(t *testing.T, svc *Operation, method, target, userName string) *http.Request {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+
+	_, err := svc.startSession(rr, httptest.NewRequest(http.MethodPost, login, nil), userName)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(method, target, nil)
+	req.AddCookie(sessionCookieOf(t, rr))
+
+	return req
+}
+
+// newCSRFRequest returns a form post with the cookie and the CSRF token of a new session of the user.
+func newCSRFRequest(t *testing.T, svc *Operation, target, userName string) *http.Request {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+
+	s, err := svc.startSession(rr, httptest.NewRequest(http.MethodPost, login, nil), userName)
+	require.NoError(t, err)
+
+	form := url.Values{csrfTokenParam: {s.CSRFToken}}
+
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(sessionCookieOf(t, rr))
+
+	return req
+}
+
+func sessionCookieOf(t *testing.T, rr *httptest.ResponseRecorder) *http.Cookie {
+	t.Helper()
+
+	for _, c := range rr.Result().Cookies() { // nolint: bodyclose
+		if c.Name == sessionCookie {
+			return c
+		}
+	}
+
+	require.Fail(t, "no session cookie")
+
+	return nil
+}
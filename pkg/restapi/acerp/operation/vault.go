@@ -0,0 +1,78 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	vaultclient "github.com/trustbloc/edge-service/pkg/client/vault"
+)
+
+const (
+	deleteAuthorizationPath = "/vaults/%s/authorizations/%s"
+	deleteVaultPath         = "/vaults/%s"
+)
+
+// vaultServerClient adds the revocation of authorizations and the deletion of vaults, which aren't supported by the
+// edge-service vault client.
+type vaultServerClient struct {
+	*vaultclient.Client
+	baseURL    string
+	httpClient httpClient
+}
+
+func newVaultClient(baseURL string, client httpClient) *vaultServerClient {
+	return &vaultServerClient{
+		Client:     vaultclient.New(baseURL, vaultclient.WithHTTPClient(client)),
+		baseURL:    baseURL,
+		httpClient: client,
+	}
+}
+
+// DeleteAuthorization revokes the authorization of the vault.
+func (c *vaultServerClient) DeleteAuthorization(vaultID, authorizationID string) error {
+	return c.delete(c.baseURL+fmt.Sprintf(deleteAuthorizationPath, url.QueryEscape(vaultID),
+		url.QueryEscape(authorizationID)), "authorization")
+}
+
+// DeleteVault deletes the vault with its documents.
+func (c *vaultServerClient) DeleteVault(vaultID string) error {
+	return c.delete(c.baseURL+fmt.Sprintf(deleteVaultPath, url.QueryEscape(vaultID)), "vault")
+}
+
+func (c *vaultServerClient) delete(target, resource string) error {
+	req, err := http.NewRequest(http.MethodDelete, target, nil)
+	if err != nil {
+		return fmt.Errorf("create request : %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("delete %s : %w", resource, err)
+	}
+
+	defer func() {
+		err = resp.Body.Close()
+		if err != nil {
+			logger.Warnf("failed to close response body")
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		body, readErr := ioutil.ReadAll(resp.Body)
+		if readErr != nil {
+			logger.Warnf("failed to read response body for status: %d", resp.StatusCode)
+		}
+
+		return fmt.Errorf("delete %s : %s: %s", resource, resp.Status, string(body))
+	}
+
+	return nil
+}
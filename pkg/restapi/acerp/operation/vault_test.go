@@ -0,0 +1,84 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVaultServerClient_DeleteAuthorization(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		serv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, http.MethodDelete, r.Method)
+			require.Equal(t, "/vaults/did:example:123/authorizations/auth1", r.URL.Path)
+
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer serv.Close()
+
+		c := newVaultClient(serv.URL, http.DefaultClient)
+
+		require.NoError(t, c.DeleteAuthorization("did:example:123", "auth1"))
+	})
+
+	t.Run("error status", func(t *testing.T) {
+		serv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			_, err := w.Write([]byte("not found"))
+			require.NoError(t, err)
+		}))
+		defer serv.Close()
+
+		c := newVaultClient(serv.URL, http.DefaultClient)
+
+		err := c.DeleteAuthorization("did:example:123", "auth1")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "404 Not Found: not found")
+	})
+
+	t.Run("http error", func(t *testing.T) {
+		c := newVaultClient("http://vault.example.com", &mockHTTPClient{respErr: errors.New("http error")})
+
+		err := c.DeleteAuthorization("did:example:123", "auth1")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "delete authorization : http error")
+	})
+}
+
+func TestVaultServerClient_DeleteVault(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		serv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, http.MethodDelete, r.Method)
+			require.Equal(t, "/vaults/did:example:123", r.URL.Path)
+
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer serv.Close()
+
+		c := newVaultClient(serv.URL, http.DefaultClient)
+
+		require.NoError(t, c.DeleteVault("did:example:123"))
+	})
+
+	t.Run("error status", func(t *testing.T) {
+		serv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer serv.Close()
+
+		c := newVaultClient(serv.URL, http.DefaultClient)
+
+		err := c.DeleteVault("did:example:123")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "delete vault : 500 Internal Server Error")
+	})
+}
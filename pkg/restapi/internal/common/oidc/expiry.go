@@ -0,0 +1,113 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+)
+
+const (
+	expiryTagName = "expiresAt"
+	purgeInterval = time.Minute
+)
+
+// expiryTag tags a store entry with its expiry time, see purger.
+func expiryTag(expiresAt time.Time) storage.Tag {
+	return storage.Tag{Name: expiryTagName, Value: strconv.FormatInt(expiresAt.Unix(), 10)}
+}
+
+// purger deletes the expired entries of a store, so that abandoned logins and sessions don't accumulate.
+// The store is scanned at most once per purge interval.
+type purger struct {
+	mu        sync.Mutex
+	lastPurge time.Time
+}
+
+func (p *purger) purgeExpired(store storage.Store) {
+	p.mu.Lock()
+
+	now := time.Now()
+
+	if now.Sub(p.lastPurge) < purgeInterval {
+		p.mu.Unlock()
+
+		return
+	}
+
+	p.lastPurge = now
+
+	p.mu.Unlock()
+
+	iter, err := store.Query(expiryTagName)
+	if err != nil {
+		logger.Warnf("failed to query expired entries : %s", err)
+
+		return
+	}
+
+	defer func() {
+		if err := iter.Close(); err != nil {
+			logger.Warnf("failed to close expired entries iterator : %s", err)
+		}
+	}()
+
+	var expired []string
+
+	for {
+		ok, err := iter.Next()
+		if err != nil {
+			logger.Warnf("failed to iterate expired entries : %s", err)
+
+			break
+		}
+
+		if !ok {
+			break
+		}
+
+		if key, ok := expiredKey(iter, now); ok {
+			expired = append(expired, key)
+		}
+	}
+
+	for _, key := range expired {
+		if err := store.Delete(key); err != nil {
+			logger.Warnf("failed to delete expired entry %s : %s", key, err)
+		}
+	}
+}
+
+func expiredKey(iter storage.Iterator, now time.Time) (string, bool) {
+	tags, err := iter.Tags()
+	if err != nil {
+		return "", false
+	}
+
+	for _, tag := range tags {
+		if tag.Name != expiryTagName {
+			continue
+		}
+
+		expiresAt, err := strconv.ParseInt(tag.Value, 10, 64)
+		if err != nil || now.Before(time.Unix(expiresAt, 0)) {
+			return "", false
+		}
+
+		key, err := iter.Key()
+		if err != nil {
+			return "", false
+		}
+
+		return key, true
+	}
+
+	return "", false
+}
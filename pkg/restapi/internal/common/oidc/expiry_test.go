@@ -0,0 +1,61 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	mockstore "github.com/hyperledger/aries-framework-go/component/storageutil/mock"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPurger(t *testing.T) {
+	t.Run("deletes expired entries", func(t *testing.T) {
+		store, err := mem.NewProvider().OpenStore("test")
+		require.NoError(t, err)
+
+		require.NoError(t, store.Put("expired", []byte("v"), expiryTag(time.Now().Add(-time.Minute))))
+		require.NoError(t, store.Put("valid", []byte("v"), expiryTag(time.Now().Add(time.Minute))))
+		require.NoError(t, store.Put("untagged", []byte("v")))
+
+		p := &purger{}
+		p.purgeExpired(store)
+
+		_, err = store.Get("expired")
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+
+		_, err = store.Get("valid")
+		require.NoError(t, err)
+
+		_, err = store.Get("untagged")
+		require.NoError(t, err)
+	})
+
+	t.Run("purges at most once per interval", func(t *testing.T) {
+		store, err := mem.NewProvider().OpenStore("test")
+		require.NoError(t, err)
+
+		p := &purger{}
+		p.purgeExpired(store)
+
+		require.NoError(t, store.Put("expired", []byte("v"), expiryTag(time.Now().Add(-time.Minute))))
+
+		p.purgeExpired(store)
+
+		_, err = store.Get("expired")
+		require.NoError(t, err)
+	})
+
+	t.Run("query error", func(t *testing.T) {
+		p := &purger{}
+		p.purgeExpired(&mockstore.Store{ErrQuery: errors.New("query error")})
+	})
+}
@@ -8,19 +8,43 @@ package oidc
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/cenkalti/backoff/v4"
 	"github.com/coreos/go-oidc"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
 	"github.com/trustbloc/edge-core/pkg/log"
 	"golang.org/x/oauth2"
 )
 
 const (
 	oauth2CallbackPath = "/oauth2/callback"
+
+	storeName = "oidc-client"
+
+	codeChallengeParam       = "code_challenge"
+	codeChallengeMethodParam = "code_challenge_method"
+	codeChallengeMethodS256  = "S256"
+	codeVerifierParam        = "code_verifier"
+	nonceParam               = "nonce"
+
+	// RFC 7636 requires 43 to 128 characters, 32 random bytes are encoded to 43 characters
+	codeVerifierLength = 32
+	nonceLength        = 16
+
+	defaultRequestTTL = 10 * time.Minute
+
+	discoveryRetries = 3
 )
 
 var logger = log.New("oidc")
@@ -28,6 +52,7 @@ var logger = log.New("oidc")
 type oidcProvider interface {
 	Endpoint() oauth2.Endpoint
 	Verifier(*oidc.Config) verifier
+	UserInfo(context.Context, oauth2.TokenSource) (userInfo, error)
 }
 
 type oidcProviderImpl struct {
@@ -42,6 +67,14 @@ func (o *oidcProviderImpl) Verifier(config *oidc.Config) verifier {
 	return &verifierImpl{v: o.op.Verifier(config)}
 }
 
+func (o *oidcProviderImpl) UserInfo(ctx context.Context, ts oauth2.TokenSource) (userInfo, error) {
+	return o.op.UserInfo(ctx, ts)
+}
+
+type userInfo interface {
+	Claims(interface{}) error
+}
+
 type verifier interface {
 	Verify(context.Context, string) (idToken, error)
 }
@@ -61,6 +94,7 @@ type idToken interface {
 type oauth2Config interface {
 	AuthCodeURL(string, ...oauth2.AuthCodeOption) string
 	Exchange(context.Context, string, ...oauth2.AuthCodeOption) (oauth2Token, error)
+	TokenSource(context.Context, *oauth2.Token) oauth2.TokenSource
 }
 
 type oauth2ConfigImpl struct {
@@ -73,21 +107,75 @@ func (o *oauth2ConfigImpl) AuthCodeURL(state string, options ...oauth2.AuthCodeO
 
 func (o *oauth2ConfigImpl) Exchange(
 	ctx context.Context, code string, options ...oauth2.AuthCodeOption) (oauth2Token, error) {
-	return o.oc.Exchange(ctx, code, options...)
+	t, err := o.oc.Exchange(ctx, code, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &oauth2TokenImpl{t: t}, nil
+}
+
+func (o *oauth2ConfigImpl) TokenSource(ctx context.Context, t *oauth2.Token) oauth2.TokenSource {
+	return o.oc.TokenSource(ctx, t)
 }
 
 type oauth2Token interface {
 	Extra(string) interface{}
+	Raw() *oauth2.Token
+}
+
+type oauth2TokenImpl struct {
+	t *oauth2.Token
+}
+
+func (o *oauth2TokenImpl) Extra(key string) interface{} {
+	return o.t.Extra(key)
+}
+
+func (o *oauth2TokenImpl) Raw() *oauth2.Token {
+	return o.t
+}
+
+// CallbackResult is the result of a successful oidc callback.
+type CallbackResult struct {
+	// Claims of the verified ID token, merged with the UserInfo claims if enabled
+	Claims      map[string]interface{}
+	IDToken     string
+	AccessToken string
+	TokenType   string
+	Expiry      time.Time
+	// SessionID identifies the tokens kept by the client if sessions are enabled, see Client.Token
+	SessionID string
+	// Provider is the name of the registry provider that handled the callback
+	Provider string
+}
+
+// authRequest holds the PKCE code verifier and the nonce sent in the authorization request for a state.
+type authRequest struct {
+	CodeVerifier string    `json:"codeVerifier"`
+	Nonce        string    `json:"nonce"`
+	ExpiresAt    time.Time `json:"expiresAt"`
 }
 
 // Client for oidc
 type Client struct {
+	// oidcProvider is discovered on first use, see provider()
 	oidcProvider     oidcProvider
+	oidcProviderURL  string
+	discoveryBackOff func() backoff.BackOff
+	mu               sync.Mutex
 	oidcClientID     string
 	oidcClientSecret string
 	oidcCallbackURL  string
 	oauth2ConfigFunc func(...string) oauth2Config
 	tlsConfig        *tls.Config
+	store            storage.Store
+	requestTTL       time.Duration
+	sessionTTL       time.Duration
+	fetchUserInfo    bool
+	purger           purger
+	// refreshMu serializes the token refreshes, a refresh token can be used only once with most providers
+	refreshMu sync.Mutex
 }
 
 // Config defines configuration for oidc client
@@ -97,31 +185,47 @@ type Config struct {
 	OIDCClientID     string
 	OIDCClientSecret string
 	OIDCCallbackURL  string
+	// StoreProvider is used to save the PKCE verifiers and nonces per state
+	StoreProvider storage.Provider
+	// RequestTTL is how long an authorization request is valid, defaults to 10 minutes
+	RequestTTL time.Duration
+	// FetchUserInfo enables merging the claims from the provider's UserInfo endpoint into the ID token claims
+	FetchUserInfo bool
+	// SessionTTL is how long the tokens are kept after the callback for Client.Token and Client.UserInfo.
+	// The tokens are not kept if not set.
+	SessionTTL time.Duration
 }
 
 // New returns client instance
 func New(config *Config) (*Client, error) {
 	svc := &Client{
+		oidcProviderURL:  config.OIDCProviderURL,
 		oidcClientID:     config.OIDCClientID,
 		oidcClientSecret: config.OIDCClientSecret,
 		oidcCallbackURL:  config.OIDCCallbackURL,
 		tlsConfig:        config.TLSConfig,
+		requestTTL:       config.RequestTTL,
+		sessionTTL:       config.SessionTTL,
+		fetchUserInfo:    config.FetchUserInfo,
+		discoveryBackOff: func() backoff.BackOff {
+			return backoff.WithMaxRetries(backoff.NewExponentialBackOff(), discoveryRetries)
+		},
 	}
 
-	idp, err := oidc.NewProvider(
-		oidc.ClientContext(
-			context.Background(),
-			&http.Client{
-				Transport: &http.Transport{TLSClientConfig: config.TLSConfig},
-			},
-		),
-		config.OIDCProviderURL,
-	)
+	if svc.requestTTL <= 0 {
+		svc.requestTTL = defaultRequestTTL
+	}
+
+	if config.StoreProvider == nil {
+		return nil, errors.New("missing store provider")
+	}
+
+	store, err := config.StoreProvider.OpenStore(storeName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to init oidc provider with url [%s] : %w", config.OIDCProviderURL, err)
+		return nil, fmt.Errorf("failed to open oidc client store : %w", err)
 	}
 
-	svc.oidcProvider = &oidcProviderImpl{op: idp}
+	svc.store = store
 
 	svc.oauth2ConfigFunc = func(scopes ...string) oauth2Config {
 		config := &oauth2.Config{
@@ -142,34 +246,77 @@ func New(config *Config) (*Client, error) {
 	return svc, nil
 }
 
-// CreateOIDCRequest create oidc request
+// CreateOIDCRequest creates an oidc request with a PKCE code challenge and a nonce. The code verifier and the nonce
+// are saved for the state until the callback.
 func (c *Client) CreateOIDCRequest(state, scope string) (string, error) {
-	redirectURL := c.oauth2Config(strings.Split(scope, " ")...).AuthCodeURL(state, oauth2.AccessTypeOnline)
+	_, err := c.provider(context.Background())
+	if err != nil {
+		return "", err
+	}
+
+	req := &authRequest{ExpiresAt: time.Now().Add(c.requestTTL)}
+
+	req.CodeVerifier, err = randomString(codeVerifierLength)
+	if err != nil {
+		return "", fmt.Errorf("failed to create code verifier : %w", err)
+	}
+
+	req.Nonce, err = randomString(nonceLength)
+	if err != nil {
+		return "", fmt.Errorf("failed to create nonce : %w", err)
+	}
+
+	reqBytes, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal auth request : %w", err)
+	}
+
+	err = c.store.Put(state, reqBytes, expiryTag(req.ExpiresAt))
+	if err != nil {
+		return "", fmt.Errorf("failed to save auth request : %w", err)
+	}
+
+	c.purger.purgeExpired(c.store)
+
+	challenge := sha256.Sum256([]byte(req.CodeVerifier))
+
+	redirectURL := c.oauth2Config(strings.Split(scope, " ")...).AuthCodeURL(state,
+		oauth2.AccessTypeOnline,
+		oauth2.SetAuthURLParam(codeChallengeParam, base64.RawURLEncoding.EncodeToString(challenge[:])),
+		oauth2.SetAuthURLParam(codeChallengeMethodParam, codeChallengeMethodS256),
+		oauth2.SetAuthURLParam(nonceParam, req.Nonce),
+	)
 
 	logger.Debugf("redirectURL: %s", redirectURL)
 
 	return redirectURL, nil
 }
 
-// HandleOIDCCallback handle oidc callback
-func (c *Client) HandleOIDCCallback(reqContext context.Context, code string) ([]byte, error) {
-	oauthToken, err := c.oauth2Config().Exchange(
-		context.WithValue(
-			reqContext,
-			oauth2.HTTPClient,
-			&http.Client{Transport: &http.Transport{TLSClientConfig: c.tlsConfig}},
-		),
-		code)
+// HandleOIDCCallback exchanges the code with the code verifier saved for the state and verifies the ID token
+// and its nonce. The auth request of the state can be used only once.
+func (c *Client) HandleOIDCCallback(reqContext context.Context, state, code string) (*CallbackResult, error) {
+	req, err := c.authRequest(state)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := c.provider(reqContext)
+	if err != nil {
+		return nil, err
+	}
+
+	oauthToken, err := c.oauth2Config().Exchange(c.clientContext(reqContext),
+		code, oauth2.SetAuthURLParam(codeVerifierParam, req.CodeVerifier))
 	if err != nil {
 		return nil, fmt.Errorf("failed to exchange oauth2 code for token : %w", err)
 	}
 
 	rawIDToken, ok := oauthToken.Extra("id_token").(string)
 	if !ok {
-		return nil, fmt.Errorf("missing id_token : %w", err)
+		return nil, errors.New("missing id_token")
 	}
 
-	oidcToken, err := c.oidcProvider.Verifier(&oidc.Config{
+	oidcToken, err := provider.Verifier(&oidc.Config{
 		ClientID: c.oidcClientID,
 	}).Verify(reqContext, rawIDToken)
 	if err != nil {
@@ -183,14 +330,118 @@ func (c *Client) HandleOIDCCallback(reqContext context.Context, code string) ([]
 		return nil, fmt.Errorf("failed to extract user data from id_token : %w", err)
 	}
 
-	bits, err := json.Marshal(userData)
+	if nonce, _ := userData[nonceParam].(string); nonce != req.Nonce { // nolint: errcheck
+		return nil, errors.New("invalid id_token nonce")
+	}
+
+	t := oauthToken.Raw()
+
+	if c.fetchUserInfo {
+		err = c.mergeUserInfo(reqContext, oauth2.StaticTokenSource(t), userData)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := &CallbackResult{
+		Claims:      userData,
+		IDToken:     rawIDToken,
+		AccessToken: t.AccessToken,
+		TokenType:   t.TokenType,
+		Expiry:      t.Expiry,
+	}
+
+	if c.sessionTTL > 0 {
+		result.SessionID, err = c.saveToken(t)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// provider returns the oidc provider, discovering its metadata on first use. Failed discoveries are retried
+// with a backoff and again on the next call.
+func (c *Client) provider(ctx context.Context) (oidcProvider, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.oidcProvider != nil {
+		return c.oidcProvider, nil
+	}
+
+	var idp *oidc.Provider
+
+	err := backoff.RetryNotify(
+		func() error {
+			var err error
+
+			// the provider keeps the context to fetch the keys, so the request context isn't used here
+			idp, err = oidc.NewProvider(c.clientContext(context.Background()), c.oidcProviderURL)
+
+			return err
+		},
+		backoff.WithContext(c.discoveryBackOff(), ctx),
+		func(retryErr error, t time.Duration) {
+			logger.Warnf("failed to discover oidc provider %s, will retry in %s : %s", c.oidcProviderURL, t, retryErr)
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init oidc provider with url [%s] : %w", c.oidcProviderURL, err)
+	}
+
+	c.oidcProvider = &oidcProviderImpl{op: idp}
+
+	return c.oidcProvider, nil
+}
+
+func (c *Client) clientContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient,
+		&http.Client{Transport: &http.Transport{TLSClientConfig: c.tlsConfig}})
+}
+
+// authRequest returns and deletes the auth request saved for the state.
+func (c *Client) authRequest(state string) (*authRequest, error) {
+	reqBytes, err := c.store.Get(state)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		return nil, errors.New("invalid state parameter")
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get auth request : %w", err)
+	}
+
+	err = c.store.Delete(state)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal user data : %w", err)
+		return nil, fmt.Errorf("failed to delete auth request : %w", err)
 	}
 
-	return bits, nil
+	req := &authRequest{}
+
+	err = json.Unmarshal(reqBytes, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal auth request : %w", err)
+	}
+
+	if time.Now().After(req.ExpiresAt) {
+		return nil, errors.New("expired state parameter")
+	}
+
+	return req, nil
 }
 
 func (c *Client) oauth2Config(scopes ...string) oauth2Config {
 	return c.oauth2ConfigFunc(scopes...)
 }
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -8,6 +8,8 @@ package oidc
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -16,9 +18,13 @@ import (
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/cenkalti/backoff/v4"
 	"github.com/coreos/go-oidc"
 	"github.com/google/uuid"
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	mockstore "github.com/hyperledger/aries-framework-go/component/storageutil/mock"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/oauth2"
 )
@@ -42,44 +48,328 @@ func TestCreateOIDCRequest(t *testing.T) {
 		require.Equal(t, config.OIDCClientID, u.Query().Get("client_id"))
 		require.Equal(t,
 			fmt.Sprintf("%s%s", config.OIDCCallbackURL, oauth2CallbackPath), u.Query().Get("redirect_uri"))
+
+		req, err := svc.authRequest("1")
+		require.NoError(t, err)
+
+		challenge := sha256.Sum256([]byte(req.CodeVerifier))
+		require.Equal(t, base64.RawURLEncoding.EncodeToString(challenge[:]), u.Query().Get("code_challenge"))
+		require.Equal(t, "S256", u.Query().Get("code_challenge_method"))
+		require.Equal(t, req.Nonce, u.Query().Get("nonce"))
+	})
+
+	t.Run("error saving auth request", func(t *testing.T) {
+		config, cleanup := config()
+		defer cleanup()
+
+		svc, err := New(config)
+		require.NoError(t, err)
+
+		svc.store = &mockstore.Store{ErrPut: errors.New("put error")}
+
+		_, err = svc.CreateOIDCRequest("1", "scope")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to save auth request")
+	})
+}
+
+func TestNew(t *testing.T) {
+	t.Run("missing store provider", func(t *testing.T) {
+		config, cleanup := config()
+		defer cleanup()
+
+		config.StoreProvider = nil
+
+		_, err := New(config)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "missing store provider")
+	})
+
+	t.Run("error opening store", func(t *testing.T) {
+		config, cleanup := config()
+		defer cleanup()
+
+		config.StoreProvider = &mockstore.Provider{ErrOpenStore: errors.New("open error")}
+
+		_, err := New(config)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to open oidc client store")
+	})
+}
+
+func TestClient_provider(t *testing.T) {
+	t.Run("discovers and caches the provider", func(t *testing.T) {
+		config, cleanup := config()
+		defer cleanup()
+
+		svc, err := New(config)
+		require.NoError(t, err)
+		require.Nil(t, svc.oidcProvider)
+
+		p, err := svc.provider(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, config.OIDCProviderURL+"/oauth2/auth", p.Endpoint().AuthURL)
+
+		cached, err := svc.provider(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, p, cached)
+	})
+
+	t.Run("retries discovery on the next call", func(t *testing.T) {
+		h := &testOIDCProvider{}
+		available := false
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !available {
+				w.WriteHeader(http.StatusServiceUnavailable)
+
+				return
+			}
+
+			h.ServeHTTP(w, r)
+		}))
+		defer srv.Close()
+
+		h.baseURL = srv.URL
+
+		config, cleanup := config()
+		defer cleanup()
+
+		config.OIDCProviderURL = srv.URL
+
+		svc, err := New(config)
+		require.NoError(t, err)
+
+		attempts := 0
+		svc.discoveryBackOff = func() backoff.BackOff {
+			attempts++
+
+			return backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Millisecond), 1)
+		}
+
+		_, err = svc.CreateOIDCRequest("1", "scope")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to init oidc provider with url")
+
+		available = true
+
+		_, err = svc.CreateOIDCRequest("1", "scope")
+		require.NoError(t, err)
+		require.Equal(t, 2, attempts)
 	})
 }
 
 func TestHandleOIDCCallback(t *testing.T) {
 	t.Run("success", func(t *testing.T) {
 		code := uuid.New().String()
+		state := uuid.New().String()
+
+		config, configCleanup := config()
+		defer configCleanup()
+
+		o, err := New(config)
+		require.NoError(t, err)
+
+		nonce := saveAuthRequest(t, o, state)
+
+		oauth2Conf := &mockOAuth2Config{exchangeVal: &mockToken{
+			oauth2Claim: uuid.New().String(),
+			token:       &oauth2.Token{AccessToken: "access-token", TokenType: "Bearer", Expiry: time.Unix(10, 0)},
+		}}
+
+		o.oauth2ConfigFunc = func(...string) oauth2Config {
+			return oauth2Conf
+		}
+
+		o.oidcProvider = &mockOIDCProvider{
+			verifier: &mockVerifier{
+				verifyVal: &mockToken{oidcClaimsFunc: claimsFunc(map[string]interface{}{"k1": "v1", "nonce": nonce})},
+			},
+		}
+
+		result, err := o.HandleOIDCCallback(context.TODO(), state, code)
+		require.NoError(t, err)
+		require.Equal(t, 2, len(result.Claims))
+		require.Equal(t, "v1", result.Claims["k1"])
+		require.Equal(t, "access-token", result.AccessToken)
+		require.Equal(t, "Bearer", result.TokenType)
+		require.Equal(t, time.Unix(10, 0), result.Expiry)
+		require.Len(t, oauth2Conf.exchangeOpts, 1)
+		require.Empty(t, result.SessionID)
+
+		_, err = o.HandleOIDCCallback(context.TODO(), state, code)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid state parameter")
+	})
+
+	t.Run("success with user info", func(t *testing.T) {
+		state := uuid.New().String()
+
+		config, configCleanup := config()
+		defer configCleanup()
+
+		config.FetchUserInfo = true
+		config.SessionTTL = time.Hour
+
+		o, err := New(config)
+		require.NoError(t, err)
+
+		nonce := saveAuthRequest(t, o, state)
+
+		o.oauth2ConfigFunc = func(...string) oauth2Config {
+			return &mockOAuth2Config{exchangeVal: &mockToken{oauth2Claim: "id_token"}}
+		}
+
+		o.oidcProvider = &mockOIDCProvider{
+			verifier: &mockVerifier{
+				verifyVal: &mockToken{oidcClaimsFunc: claimsFunc(map[string]interface{}{
+					"sub": "user1", "nonce": nonce, "name": "John",
+				})},
+			},
+			userInfo: &mockToken{oidcClaimsFunc: claimsFunc(map[string]interface{}{
+				"sub": "user1", "nonce": "other", "name": "John Smith", "assurance": "high",
+			})},
+		}
+
+		result, err := o.HandleOIDCCallback(context.TODO(), state, "code")
+		require.NoError(t, err)
+		require.Equal(t, nonce, result.Claims["nonce"])
+		require.Equal(t, "John Smith", result.Claims["name"])
+		require.Equal(t, "high", result.Claims["assurance"])
+		require.NotEmpty(t, result.SessionID)
+	})
+
+	t.Run("error user info", func(t *testing.T) {
+		state := uuid.New().String()
+
+		config, configCleanup := config()
+		defer configCleanup()
+
+		config.FetchUserInfo = true
+		config.SessionTTL = time.Hour
+
+		o, err := New(config)
+		require.NoError(t, err)
+
+		nonce := saveAuthRequest(t, o, state)
+
+		o.oauth2ConfigFunc = func(...string) oauth2Config {
+			return &mockOAuth2Config{exchangeVal: &mockToken{oauth2Claim: "id_token"}}
+		}
+
+		o.oidcProvider = &mockOIDCProvider{
+			verifier: &mockVerifier{
+				verifyVal: &mockToken{oidcClaimsFunc: claimsFunc(map[string]interface{}{"nonce": nonce})},
+			},
+			userInfoErr: errors.New("user info error"),
+		}
+
+		_, err = o.HandleOIDCCallback(context.TODO(), state, "code")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to fetch user info")
+	})
+
+	t.Run("error saving session tokens", func(t *testing.T) {
+		state := uuid.New().String()
 
 		config, configCleanup := config()
 		defer configCleanup()
 
+		config.SessionTTL = time.Hour
+
 		o, err := New(config)
 		require.NoError(t, err)
 
+		nonce := saveAuthRequest(t, o, state)
+
+		reqBytes, err := o.store.Get(state)
+		require.NoError(t, err)
+
+		o.store = &mockstore.Store{GetReturn: reqBytes, ErrPut: errors.New("put error")}
+
 		o.oauth2ConfigFunc = func(...string) oauth2Config {
-			return &mockOAuth2Config{exchangeVal: &mockToken{
-				oauth2Claim: uuid.New().String(),
-			}}
+			return &mockOAuth2Config{exchangeVal: &mockToken{oauth2Claim: "id_token"}}
 		}
 
 		o.oidcProvider = &mockOIDCProvider{
 			verifier: &mockVerifier{
-				verifyVal: &mockToken{oidcClaimsFunc: func(v interface{}) error {
-					m, ok := v.(*map[string]interface{})
-					if !ok {
-						return fmt.Errorf("not map")
-					}
-					(*m)["k1"] = "v1"
-					return nil
-				}},
+				verifyVal: &mockToken{oidcClaimsFunc: claimsFunc(map[string]interface{}{"nonce": nonce})},
 			},
 		}
 
-		data, err := o.HandleOIDCCallback(context.TODO(), code)
+		_, err = o.HandleOIDCCallback(context.TODO(), state, "code")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to save session tokens")
+	})
+
+	t.Run("error invalid nonce", func(t *testing.T) {
+		state := uuid.New().String()
+
+		config, configCleanup := config()
+		defer configCleanup()
+
+		o, err := New(config)
 		require.NoError(t, err)
-		m := make(map[string]string)
-		require.NoError(t, json.Unmarshal(data, &m))
-		require.Equal(t, 1, len(m))
-		require.Equal(t, "v1", m["k1"])
+
+		saveAuthRequest(t, o, state)
+
+		o.oauth2ConfigFunc = func(...string) oauth2Config {
+			return &mockOAuth2Config{exchangeVal: &mockToken{oauth2Claim: "id_token"}}
+		}
+
+		o.oidcProvider = &mockOIDCProvider{
+			verifier: &mockVerifier{
+				verifyVal: &mockToken{oidcClaimsFunc: claimsFunc(map[string]interface{}{"nonce": "injected"})},
+			},
+		}
+
+		_, err = o.HandleOIDCCallback(context.TODO(), state, "code")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid id_token nonce")
+	})
+
+	t.Run("error expired state", func(t *testing.T) {
+		state := uuid.New().String()
+
+		config, configCleanup := config()
+		defer configCleanup()
+
+		o, err := New(config)
+		require.NoError(t, err)
+
+		o.requestTTL = -time.Minute
+
+		saveAuthRequest(t, o, state)
+
+		_, err = o.HandleOIDCCallback(context.TODO(), state, "code")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "expired state parameter")
+	})
+
+	t.Run("error store", func(t *testing.T) {
+		config, configCleanup := config()
+		defer configCleanup()
+
+		o, err := New(config)
+		require.NoError(t, err)
+
+		o.store = &mockstore.Store{ErrGet: errors.New("get error")}
+
+		_, err = o.HandleOIDCCallback(context.TODO(), "state", "code")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to get auth request")
+
+		o.store = &mockstore.Store{GetReturn: []byte("{}"), ErrDelete: errors.New("delete error")}
+
+		_, err = o.HandleOIDCCallback(context.TODO(), "state", "code")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to delete auth request")
+
+		o.store = &mockstore.Store{GetReturn: []byte("invalid")}
+
+		_, err = o.HandleOIDCCallback(context.TODO(), "state", "code")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to unmarshal auth request")
 	})
 
 	t.Run("error exchanging auth code", func(t *testing.T) {
@@ -93,7 +383,7 @@ func TestHandleOIDCCallback(t *testing.T) {
 				exchangeErr: errors.New("test"),
 			}
 		}
-		_, err = svc.HandleOIDCCallback(context.TODO(), "code")
+		_, err = svc.HandleOIDCCallback(context.TODO(), saveState(t, svc), "code")
 		require.Error(t, err)
 		require.Contains(t, err.Error(), "failed to exchange oauth2 code for token")
 	})
@@ -109,7 +399,7 @@ func TestHandleOIDCCallback(t *testing.T) {
 		svc.oidcProvider = &mockOIDCProvider{
 			verifier: &mockVerifier{verifyVal: &mockToken{}},
 		}
-		_, err = svc.HandleOIDCCallback(context.TODO(), "code")
+		_, err = svc.HandleOIDCCallback(context.TODO(), saveState(t, svc), "code")
 		require.Error(t, err)
 		require.Contains(t, err.Error(), "missing id_token")
 	})
@@ -126,7 +416,7 @@ func TestHandleOIDCCallback(t *testing.T) {
 		svc.oidcProvider = &mockOIDCProvider{
 			verifier: &mockVerifier{verifyErr: errors.New("test")},
 		}
-		_, err = svc.HandleOIDCCallback(context.TODO(), "code")
+		_, err = svc.HandleOIDCCallback(context.TODO(), saveState(t, svc), "code")
 		require.Error(t, err)
 		require.Contains(t, err.Error(), "failed to verify id_token")
 	})
@@ -142,12 +432,20 @@ func TestHandleOIDCCallback(t *testing.T) {
 		svc.oidcProvider = &mockOIDCProvider{
 			verifier: &mockVerifier{verifyVal: &mockToken{oidcClaimsErr: errors.New("test")}},
 		}
-		_, err = svc.HandleOIDCCallback(context.TODO(), "code")
+		_, err = svc.HandleOIDCCallback(context.TODO(), saveState(t, svc), "code")
 		require.Error(t, err)
 		require.Contains(t, err.Error(), "failed to extract user data from id_token")
 	})
 }
 
+func TestOauth2TokenImpl(t *testing.T) {
+	token := (&oauth2.Token{AccessToken: "t1"}).WithExtra(map[string]interface{}{"id_token": "t2"})
+
+	o := &oauth2TokenImpl{t: token}
+	require.Equal(t, "t2", o.Extra("id_token"))
+	require.Equal(t, token, o.Raw())
+}
+
 func TestOIDCProviderImpl(t *testing.T) {
 	o := oidcProviderImpl{&oidc.Provider{}}
 	require.Empty(t, o.Endpoint())
@@ -167,8 +465,10 @@ func TestOauth2ConfigImpl(t *testing.T) {
 }
 
 type mockOIDCProvider struct {
-	baseURL  string
-	verifier *mockVerifier
+	baseURL     string
+	verifier    *mockVerifier
+	userInfo    userInfo
+	userInfoErr error
 }
 
 func (m *mockOIDCProvider) Endpoint() oauth2.Endpoint {
@@ -182,6 +482,10 @@ func (m *mockOIDCProvider) Verifier(*oidc.Config) verifier {
 	return m.verifier
 }
 
+func (m *mockOIDCProvider) UserInfo(context.Context, oauth2.TokenSource) (userInfo, error) {
+	return m.userInfo, m.userInfoErr
+}
+
 type mockVerifier struct {
 	verifyVal idToken
 	verifyErr error
@@ -191,6 +495,49 @@ func (m *mockVerifier) Verify(ctx context.Context, token string) (idToken, error
 	return m.verifyVal, m.verifyErr
 }
 
+// saveAuthRequest saves an auth request for the state and returns the nonce.
+func saveAuthRequest(t *testing.T, c *Client, state string) string {
+	t.Helper()
+
+	req := &authRequest{
+		CodeVerifier: uuid.NewString(),
+		Nonce:        uuid.NewString(),
+		ExpiresAt:    time.Now().Add(c.requestTTL),
+	}
+
+	reqBytes, err := json.Marshal(req)
+	require.NoError(t, err)
+
+	require.NoError(t, c.store.Put(state, reqBytes))
+
+	return req.Nonce
+}
+
+func saveState(t *testing.T, c *Client) string {
+	t.Helper()
+
+	state := uuid.New().String()
+
+	saveAuthRequest(t, c, state)
+
+	return state
+}
+
+func claimsFunc(claims map[string]interface{}) func(v interface{}) error {
+	return func(v interface{}) error {
+		m, ok := v.(*map[string]interface{})
+		if !ok {
+			return fmt.Errorf("not map")
+		}
+
+		for k, val := range claims {
+			(*m)[k] = val
+		}
+
+		return nil
+	}
+}
+
 func config() (*Config, func()) {
 	path, oidcCleanup := newTestOIDCProvider()
 
@@ -199,6 +546,7 @@ func config() (*Config, func()) {
 			OIDCClientID:     uuid.New().String(),
 			OIDCClientSecret: uuid.New().String(),
 			OIDCCallbackURL:  "http://test.com",
+			StoreProvider:    mem.NewProvider(),
 		}, func() {
 			oidcCleanup()
 		}
@@ -248,6 +596,8 @@ type mockOAuth2Config struct {
 	authCodeFunc func(string, ...oauth2.AuthCodeOption) string
 	exchangeVal  oauth2Token
 	exchangeErr  error
+	exchangeOpts []oauth2.AuthCodeOption
+	tokenSource  oauth2.TokenSource
 }
 
 func (m *mockOAuth2Config) AuthCodeURL(state string, options ...oauth2.AuthCodeOption) string {
@@ -256,10 +606,17 @@ func (m *mockOAuth2Config) AuthCodeURL(state string, options ...oauth2.AuthCodeO
 
 func (m *mockOAuth2Config) Exchange(
 	ctx context.Context, code string, options ...oauth2.AuthCodeOption) (oauth2Token, error) {
+	m.exchangeOpts = options
+
 	return m.exchangeVal, m.exchangeErr
 }
 
+func (m *mockOAuth2Config) TokenSource(context.Context, *oauth2.Token) oauth2.TokenSource {
+	return m.tokenSource
+}
+
 type mockToken struct {
+	token          *oauth2.Token
 	oauth2Claim    interface{}
 	oidcClaimsFunc func(v interface{}) error
 	oidcClaimsErr  error
@@ -273,6 +630,14 @@ func (m *mockToken) Extra(_ string) interface{} {
 	return nil
 }
 
+func (m *mockToken) Raw() *oauth2.Token {
+	if m.token != nil {
+		return m.token
+	}
+
+	return &oauth2.Token{}
+}
+
 func (m *mockToken) Claims(v interface{}) error {
 	if m.oidcClaimsFunc != nil {
 		return m.oidcClaimsFunc(v)
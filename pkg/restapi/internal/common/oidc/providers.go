@@ -0,0 +1,61 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import "sort"
+
+const (
+	// ProviderQueryParam selects the provider of an oidc request.
+	ProviderQueryParam = "provider"
+
+	// DefaultProviderName is the name of the provider set with the single provider options (URL, client ID
+	// and secret) of the services.
+	DefaultProviderName = "default"
+)
+
+// ProviderSettings defines a named oidc provider in the configuration of the services.
+type ProviderSettings struct {
+	URL          string `json:"url"`
+	ClientID     string `json:"clientID"`
+	ClientSecret string `json:"clientSecret"`
+	UserInfo     bool   `json:"userInfo,omitempty"`
+}
+
+// ProviderConfigs returns the registry providers for the single provider, named DefaultProviderName and
+// skipped if its URL is not set, followed by the named providers sorted by name. The first provider
+// is the default provider of the registry.
+func ProviderConfigs(single *ProviderSettings, named map[string]*ProviderSettings) []*ProviderConfig {
+	var providers []*ProviderConfig
+
+	if single != nil && single.URL != "" {
+		providers = append(providers, providerConfig(DefaultProviderName, single))
+	}
+
+	names := make([]string, 0, len(named))
+
+	for name := range named {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		providers = append(providers, providerConfig(name, named[name]))
+	}
+
+	return providers
+}
+
+func providerConfig(name string, p *ProviderSettings) *ProviderConfig {
+	return &ProviderConfig{
+		Name:          name,
+		URL:           p.URL,
+		ClientID:      p.ClientID,
+		ClientSecret:  p.ClientSecret,
+		FetchUserInfo: p.UserInfo,
+	}
+}
@@ -0,0 +1,43 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProviderConfigs(t *testing.T) {
+	t.Run("single and named providers", func(t *testing.T) {
+		providers := ProviderConfigs(
+			&ProviderSettings{URL: "http://default.example.com", ClientID: "client", UserInfo: true},
+			map[string]*ProviderSettings{
+				"gov":  {URL: "http://gov.example.com"},
+				"bank": {URL: "http://bank.example.com", ClientSecret: "secret"},
+			})
+
+		require.Len(t, providers, 3)
+		require.Equal(t, &ProviderConfig{
+			Name: DefaultProviderName, URL: "http://default.example.com", ClientID: "client", FetchUserInfo: true,
+		}, providers[0])
+		require.Equal(t, "bank", providers[1].Name)
+		require.Equal(t, "secret", providers[1].ClientSecret)
+		require.Equal(t, "gov", providers[2].Name)
+	})
+
+	t.Run("named providers only", func(t *testing.T) {
+		providers := ProviderConfigs(&ProviderSettings{}, map[string]*ProviderSettings{
+			"bank": {URL: "http://bank.example.com"},
+		})
+
+		require.Len(t, providers, 1)
+		require.Equal(t, "bank", providers[0].Name)
+
+		require.Empty(t, ProviderConfigs(nil, nil))
+	})
+}
@@ -0,0 +1,219 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+)
+
+const registryStoreName = "oidc-registry"
+
+// ErrUnknownProvider is returned when a provider is not registered.
+var ErrUnknownProvider = errors.New("unknown oidc provider")
+
+// ProviderConfig defines a named oidc provider.
+type ProviderConfig struct {
+	Name          string
+	URL           string
+	ClientID      string
+	ClientSecret  string
+	FetchUserInfo bool
+}
+
+// RegistryConfig defines configuration for the oidc provider registry.
+type RegistryConfig struct {
+	TLSConfig   *tls.Config
+	CallbackURL string
+	// StoreProvider is used to save the provider of each state and the auth requests of the clients
+	StoreProvider storage.Provider
+	// RequestTTL is how long an authorization request is valid, defaults to 10 minutes
+	RequestTTL time.Duration
+	// SessionTTL is how long the tokens are kept after the callback, the tokens are not kept if not set
+	SessionTTL time.Duration
+	Providers  []*ProviderConfig
+	// DefaultProvider is used when a request doesn't name a provider, defaults to the first provider
+	DefaultProvider string
+}
+
+// Registry of named oidc providers. The providers share the callback, which is routed to the provider
+// that created the request for the state.
+type Registry struct {
+	clients         map[string]*Client
+	defaultProvider string
+	store           storage.Store
+	requestTTL      time.Duration
+	purger          purger
+}
+
+// NewRegistry returns a registry with a client for each provider. The provider metadata is discovered on first use.
+func NewRegistry(config *RegistryConfig) (*Registry, error) {
+	if len(config.Providers) == 0 {
+		return nil, errors.New("no oidc providers configured")
+	}
+
+	if config.StoreProvider == nil {
+		return nil, errors.New("missing store provider")
+	}
+
+	store, err := config.StoreProvider.OpenStore(registryStoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open oidc registry store : %w", err)
+	}
+
+	r := &Registry{
+		clients:         make(map[string]*Client),
+		defaultProvider: config.DefaultProvider,
+		store:           store,
+		requestTTL:      config.RequestTTL,
+	}
+
+	if r.requestTTL <= 0 {
+		r.requestTTL = defaultRequestTTL
+	}
+
+	for _, p := range config.Providers {
+		if p.Name == "" {
+			return nil, errors.New("missing oidc provider name")
+		}
+
+		if _, ok := r.clients[p.Name]; ok {
+			return nil, fmt.Errorf("duplicate oidc provider %s", p.Name)
+		}
+
+		r.clients[p.Name], err = New(&Config{
+			TLSConfig:        config.TLSConfig,
+			OIDCProviderURL:  p.URL,
+			OIDCClientID:     p.ClientID,
+			OIDCClientSecret: p.ClientSecret,
+			OIDCCallbackURL:  config.CallbackURL,
+			StoreProvider:    config.StoreProvider,
+			RequestTTL:       config.RequestTTL,
+			SessionTTL:       config.SessionTTL,
+			FetchUserInfo:    p.FetchUserInfo,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create oidc client for provider %s : %w", p.Name, err)
+		}
+	}
+
+	if r.defaultProvider == "" {
+		r.defaultProvider = config.Providers[0].Name
+	}
+
+	if _, ok := r.clients[r.defaultProvider]; !ok {
+		return nil, fmt.Errorf("default oidc provider %s is not configured", r.defaultProvider)
+	}
+
+	return r, nil
+}
+
+// Providers returns the sorted names of the registered providers.
+func (r *Registry) Providers() []string {
+	names := make([]string, 0, len(r.clients))
+
+	for name := range r.clients {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Client returns the client of the provider, or of the default provider if the name is empty.
+func (r *Registry) Client(provider string) (*Client, error) {
+	if provider == "" {
+		provider = r.defaultProvider
+	}
+
+	c, ok := r.clients[provider]
+	if !ok {
+		return nil, fmt.Errorf("%w : %s", ErrUnknownProvider, provider)
+	}
+
+	return c, nil
+}
+
+// CreateOIDCRequest creates an oidc request with the provider and saves the provider for the state. The state
+// expires with the request, the entries of the abandoned requests are purged.
+func (r *Registry) CreateOIDCRequest(provider, state, scope string) (string, error) {
+	c, err := r.Client(provider)
+	if err != nil {
+		return "", err
+	}
+
+	if provider == "" {
+		provider = r.defaultProvider
+	}
+
+	err = r.store.Put(state, []byte(provider), expiryTag(time.Now().Add(r.requestTTL)))
+	if err != nil {
+		return "", fmt.Errorf("failed to save oidc provider for state : %w", err)
+	}
+
+	r.purger.purgeExpired(r.store)
+
+	return c.CreateOIDCRequest(state, scope)
+}
+
+// HandleOIDCCallback handles the callback with the provider that created the request for the state.
+func (r *Registry) HandleOIDCCallback(reqContext context.Context, state, code string) (*CallbackResult, error) {
+	providerBytes, err := r.store.Get(state)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		return nil, errors.New("invalid state parameter")
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get oidc provider for state : %w", err)
+	}
+
+	err = r.store.Delete(state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete oidc provider for state : %w", err)
+	}
+
+	c, err := r.Client(string(providerBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := c.HandleOIDCCallback(reqContext, state, code)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Provider = string(providerBytes)
+
+	return result, nil
+}
+
+// UserInfo fetches the UserInfo claims of a session from the provider that handled its callback.
+func (r *Registry) UserInfo(ctx context.Context, provider, sessionID string) (map[string]interface{}, error) {
+	c, err := r.Client(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.UserInfo(ctx, sessionID)
+}
+
+// DeleteSession deletes the tokens of a session of the provider.
+func (r *Registry) DeleteSession(provider, sessionID string) error {
+	c, err := r.Client(provider)
+	if err != nil {
+		return err
+	}
+
+	return c.DeleteSession(sessionID)
+}
@@ -0,0 +1,184 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	mockstore "github.com/hyperledger/aries-framework-go/component/storageutil/mock"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRegistry(t *testing.T) {
+	t.Run("default provider", func(t *testing.T) {
+		r, err := NewRegistry(registryConfig())
+		require.NoError(t, err)
+		require.Equal(t, []string{"bank", "gov"}, r.Providers())
+
+		c, err := r.Client("")
+		require.NoError(t, err)
+		require.Equal(t, "http://bank.example.com", c.oidcProviderURL)
+
+		_, err = r.Client("unknown")
+		require.ErrorIs(t, err, ErrUnknownProvider)
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		config := registryConfig()
+		config.Providers = nil
+
+		_, err := NewRegistry(config)
+		require.EqualError(t, err, "no oidc providers configured")
+
+		config = registryConfig()
+		config.StoreProvider = nil
+
+		_, err = NewRegistry(config)
+		require.EqualError(t, err, "missing store provider")
+
+		config = registryConfig()
+		config.Providers[1].Name = ""
+
+		_, err = NewRegistry(config)
+		require.EqualError(t, err, "missing oidc provider name")
+
+		config = registryConfig()
+		config.Providers[1].Name = "bank"
+
+		_, err = NewRegistry(config)
+		require.EqualError(t, err, "duplicate oidc provider bank")
+
+		config = registryConfig()
+		config.DefaultProvider = "other"
+
+		_, err = NewRegistry(config)
+		require.EqualError(t, err, "default oidc provider other is not configured")
+	})
+
+	t.Run("open store error", func(t *testing.T) {
+		config := registryConfig()
+		config.StoreProvider = &mockstore.Provider{ErrOpenStore: errors.New("open error")}
+
+		_, err := NewRegistry(config)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to open oidc registry store")
+	})
+}
+
+func TestRegistry_HandleOIDCCallback(t *testing.T) {
+	t.Run("routes the callback to the provider of the state", func(t *testing.T) {
+		r, err := NewRegistry(registryConfig())
+		require.NoError(t, err)
+
+		bank, err := r.Client("bank")
+		require.NoError(t, err)
+
+		gov, err := r.Client("gov")
+		require.NoError(t, err)
+
+		bank.oidcProvider = &mockOIDCProvider{baseURL: "http://bank.example.com"}
+		gov.oidcProvider = &mockOIDCProvider{baseURL: "http://gov.example.com"}
+
+		state := uuid.NewString()
+
+		redirectURL, err := r.CreateOIDCRequest("gov", state, "scope")
+		require.NoError(t, err)
+
+		u, err := url.Parse(redirectURL)
+		require.NoError(t, err)
+		require.Equal(t, "gov.example.com", u.Host)
+
+		// replace the auth request to know the nonce
+		nonce := saveAuthRequest(t, gov, state)
+
+		gov.oauth2ConfigFunc = func(...string) oauth2Config {
+			return &mockOAuth2Config{exchangeVal: &mockToken{oauth2Claim: "id_token"}}
+		}
+
+		gov.oidcProvider = &mockOIDCProvider{verifier: &mockVerifier{
+			verifyVal: &mockToken{oidcClaimsFunc: claimsFunc(map[string]interface{}{"nonce": nonce})},
+		}}
+
+		result, err := r.HandleOIDCCallback(context.Background(), state, "code")
+		require.NoError(t, err)
+		require.Equal(t, "gov", result.Provider)
+
+		_, err = r.HandleOIDCCallback(context.Background(), state, "code")
+		require.EqualError(t, err, "invalid state parameter")
+	})
+
+	t.Run("abandoned requests are purged", func(t *testing.T) {
+		r, err := NewRegistry(registryConfig())
+		require.NoError(t, err)
+
+		bank, err := r.Client("bank")
+		require.NoError(t, err)
+
+		bank.oidcProvider = &mockOIDCProvider{baseURL: "http://bank.example.com"}
+		r.requestTTL = -time.Second
+
+		state := uuid.NewString()
+
+		_, err = r.CreateOIDCRequest("bank", state, "scope")
+		require.NoError(t, err)
+
+		_, err = r.store.Get(state)
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+
+		_, err = r.HandleOIDCCallback(context.Background(), state, "code")
+		require.EqualError(t, err, "invalid state parameter")
+	})
+
+	t.Run("unknown provider", func(t *testing.T) {
+		r, err := NewRegistry(registryConfig())
+		require.NoError(t, err)
+
+		_, err = r.CreateOIDCRequest("unknown", uuid.NewString(), "scope")
+		require.ErrorIs(t, err, ErrUnknownProvider)
+	})
+
+	t.Run("store errors", func(t *testing.T) {
+		r, err := NewRegistry(registryConfig())
+		require.NoError(t, err)
+
+		r.store = &mockstore.Store{ErrPut: errors.New("put error")}
+
+		_, err = r.CreateOIDCRequest("", uuid.NewString(), "scope")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to save oidc provider for state")
+
+		r.store = &mockstore.Store{ErrGet: errors.New("get error")}
+
+		_, err = r.HandleOIDCCallback(context.Background(), "state", "code")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to get oidc provider for state")
+
+		r.store = &mockstore.Store{GetReturn: []byte("bank"), ErrDelete: errors.New("delete error")}
+
+		_, err = r.HandleOIDCCallback(context.Background(), "state", "code")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to delete oidc provider for state")
+	})
+}
+
+func registryConfig() *RegistryConfig {
+	return &RegistryConfig{
+		CallbackURL:   "http://test.com",
+		StoreProvider: mem.NewProvider(),
+		Providers: []*ProviderConfig{
+			{Name: "bank", URL: "http://bank.example.com", ClientID: uuid.NewString()},
+			{Name: "gov", URL: "http://gov.example.com", ClientID: uuid.NewString()},
+		},
+	}
+}
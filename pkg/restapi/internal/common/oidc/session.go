@@ -0,0 +1,220 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"golang.org/x/oauth2"
+)
+
+const (
+	tokenKeyPrefix = "token_"
+	subClaim       = "sub"
+)
+
+// ErrSessionNotFound is returned when there are no tokens for the session.
+var ErrSessionNotFound = errors.New("oidc session not found")
+
+// idTokenClaims are not overwritten by the UserInfo claims.
+var idTokenClaims = map[string]struct{}{ // nolint: gochecknoglobals
+	"iss": {}, "sub": {}, "aud": {}, "exp": {}, "iat": {}, "auth_time": {}, "nonce": {}, "acr": {}, "amr": {},
+	"azp": {}, "at_hash": {}, "c_hash": {}, "sid": {},
+}
+
+type sessionToken struct {
+	AccessToken  string    `json:"accessToken"`
+	TokenType    string    `json:"tokenType,omitempty"`
+	RefreshToken string    `json:"refreshToken,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+	// ExpiresAt is when the session ends, regardless of the token refreshes
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Token returns a valid access token for the session. An expired access token is refreshed with the refresh token
+// and the new tokens are saved for the session.
+func (c *Client) Token(ctx context.Context, sessionID string) (*oauth2.Token, error) {
+	t, _, err := c.getToken(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Valid() {
+		return t, nil
+	}
+
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	// the token may have been refreshed by a concurrent call
+	t, expiresAt, err := c.getToken(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Valid() {
+		return t, nil
+	}
+
+	if t.RefreshToken == "" {
+		return nil, fmt.Errorf("access token expired for session %s", sessionID)
+	}
+
+	_, err = c.provider(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshed, err := c.oauth2Config().TokenSource(c.clientContext(ctx), t).Token()
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh token : %w", err)
+	}
+
+	err = c.putToken(sessionID, refreshed, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return refreshed, nil
+}
+
+// UserInfo fetches the UserInfo claims of the session from the provider.
+func (c *Client) UserInfo(ctx context.Context, sessionID string) (map[string]interface{}, error) {
+	t, err := c.Token(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := make(map[string]interface{})
+
+	err = c.mergeUserInfo(ctx, oauth2.StaticTokenSource(t), claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// DeleteSession deletes the tokens saved for the session.
+func (c *Client) DeleteSession(sessionID string) error {
+	err := c.store.Delete(tokenKeyPrefix + sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to delete session tokens : %w", err)
+	}
+
+	return nil
+}
+
+// mergeUserInfo adds the UserInfo claims to the claims. The ID token claims are kept, and the UserInfo subject
+// must be the same as the ID token subject.
+func (c *Client) mergeUserInfo(ctx context.Context, ts oauth2.TokenSource, claims map[string]interface{}) error {
+	provider, err := c.provider(ctx)
+	if err != nil {
+		return err
+	}
+
+	info, err := provider.UserInfo(c.clientContext(ctx), ts)
+	if err != nil {
+		return fmt.Errorf("failed to fetch user info : %w", err)
+	}
+
+	infoClaims := make(map[string]interface{})
+
+	err = info.Claims(&infoClaims)
+	if err != nil {
+		return fmt.Errorf("failed to extract user info claims : %w", err)
+	}
+
+	if sub, ok := claims[subClaim]; ok && sub != infoClaims[subClaim] {
+		return errors.New("user info subject does not match the id_token subject")
+	}
+
+	for k, v := range infoClaims {
+		if _, ok := idTokenClaims[k]; ok {
+			if _, exists := claims[k]; exists {
+				continue
+			}
+		}
+
+		claims[k] = v
+	}
+
+	return nil
+}
+
+func (c *Client) saveToken(t *oauth2.Token) (string, error) {
+	sessionID := uuid.NewString()
+
+	err := c.putToken(sessionID, t, time.Now().Add(c.sessionTTL))
+	if err != nil {
+		return "", err
+	}
+
+	c.purger.purgeExpired(c.store)
+
+	return sessionID, nil
+}
+
+func (c *Client) putToken(sessionID string, t *oauth2.Token, expiresAt time.Time) error {
+	tokenBytes, err := json.Marshal(&sessionToken{
+		AccessToken:  t.AccessToken,
+		TokenType:    t.TokenType,
+		RefreshToken: t.RefreshToken,
+		Expiry:       t.Expiry,
+		ExpiresAt:    expiresAt,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal session tokens : %w", err)
+	}
+
+	err = c.store.Put(tokenKeyPrefix+sessionID, tokenBytes, expiryTag(expiresAt))
+	if err != nil {
+		return fmt.Errorf("failed to save session tokens : %w", err)
+	}
+
+	return nil
+}
+
+// getToken returns the tokens of the session and when the session expires. Expired sessions are deleted.
+func (c *Client) getToken(sessionID string) (*oauth2.Token, time.Time, error) {
+	tokenBytes, err := c.store.Get(tokenKeyPrefix + sessionID)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		return nil, time.Time{}, ErrSessionNotFound
+	}
+
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to get session tokens : %w", err)
+	}
+
+	t := &sessionToken{}
+
+	err = json.Unmarshal(tokenBytes, t)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to unmarshal session tokens : %w", err)
+	}
+
+	if time.Now().After(t.ExpiresAt) {
+		if err := c.DeleteSession(sessionID); err != nil {
+			logger.Warnf("failed to delete expired oidc session %s : %s", sessionID, err)
+		}
+
+		return nil, time.Time{}, ErrSessionNotFound
+	}
+
+	return &oauth2.Token{
+		AccessToken:  t.AccessToken,
+		TokenType:    t.TokenType,
+		RefreshToken: t.RefreshToken,
+		Expiry:       t.Expiry,
+	}, t.ExpiresAt, nil
+}
@@ -0,0 +1,256 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mockstore "github.com/hyperledger/aries-framework-go/component/storageutil/mock"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestClient_Token(t *testing.T) {
+	t.Run("valid token", func(t *testing.T) {
+		c := newTestClient(t)
+
+		sessionID, err := c.saveToken(&oauth2.Token{AccessToken: "t1", Expiry: time.Now().Add(time.Hour)})
+		require.NoError(t, err)
+
+		token, err := c.Token(context.Background(), sessionID)
+		require.NoError(t, err)
+		require.Equal(t, "t1", token.AccessToken)
+	})
+
+	t.Run("refresh expired token", func(t *testing.T) {
+		c := newTestClient(t)
+
+		sessionID, err := c.saveToken(&oauth2.Token{
+			AccessToken: "t1", RefreshToken: "r1", Expiry: time.Now().Add(-time.Hour),
+		})
+		require.NoError(t, err)
+
+		c.oauth2ConfigFunc = func(...string) oauth2Config {
+			return &mockOAuth2Config{tokenSource: oauth2.StaticTokenSource(&oauth2.Token{
+				AccessToken: "t2", RefreshToken: "r2", Expiry: time.Now().Add(time.Hour),
+			})}
+		}
+
+		token, err := c.Token(context.Background(), sessionID)
+		require.NoError(t, err)
+		require.Equal(t, "t2", token.AccessToken)
+
+		saved, _, err := c.getToken(sessionID)
+		require.NoError(t, err)
+		require.Equal(t, "r2", saved.RefreshToken)
+	})
+
+	t.Run("concurrent refreshes use the refresh token once", func(t *testing.T) {
+		c := newTestClient(t)
+
+		sessionID, err := c.saveToken(&oauth2.Token{
+			AccessToken: "t1", RefreshToken: "r1", Expiry: time.Now().Add(-time.Hour),
+		})
+		require.NoError(t, err)
+
+		ts := &countingTokenSource{token: &oauth2.Token{
+			AccessToken: "t2", RefreshToken: "r2", Expiry: time.Now().Add(time.Hour),
+		}}
+
+		c.oauth2ConfigFunc = func(...string) oauth2Config {
+			return &mockOAuth2Config{tokenSource: ts}
+		}
+
+		var wg sync.WaitGroup
+
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				token, err := c.Token(context.Background(), sessionID)
+				require.NoError(t, err)
+				require.Equal(t, "t2", token.AccessToken)
+			}()
+		}
+
+		wg.Wait()
+
+		require.Equal(t, int32(1), atomic.LoadInt32(&ts.calls))
+	})
+
+	t.Run("expired session", func(t *testing.T) {
+		c := newTestClient(t)
+
+		c.sessionTTL = -time.Second
+
+		sessionID, err := c.saveToken(&oauth2.Token{AccessToken: "t1", Expiry: time.Now().Add(time.Hour)})
+		require.NoError(t, err)
+
+		_, err = c.Token(context.Background(), sessionID)
+		require.ErrorIs(t, err, ErrSessionNotFound)
+
+		_, err = c.store.Get(tokenKeyPrefix + sessionID)
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+	})
+
+	t.Run("expired token without refresh token", func(t *testing.T) {
+		c := newTestClient(t)
+
+		sessionID, err := c.saveToken(&oauth2.Token{AccessToken: "t1", Expiry: time.Now().Add(-time.Hour)})
+		require.NoError(t, err)
+
+		_, err = c.Token(context.Background(), sessionID)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "access token expired")
+	})
+
+	t.Run("refresh error", func(t *testing.T) {
+		c := newTestClient(t)
+
+		sessionID, err := c.saveToken(&oauth2.Token{
+			AccessToken: "t1", RefreshToken: "r1", Expiry: time.Now().Add(-time.Hour),
+		})
+		require.NoError(t, err)
+
+		c.oauth2ConfigFunc = func(...string) oauth2Config {
+			return &mockOAuth2Config{tokenSource: &mockTokenSource{err: errors.New("refresh error")}}
+		}
+
+		_, err = c.Token(context.Background(), sessionID)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to refresh token")
+	})
+
+	t.Run("session not found", func(t *testing.T) {
+		c := newTestClient(t)
+
+		_, err := c.Token(context.Background(), "invalid")
+		require.ErrorIs(t, err, ErrSessionNotFound)
+	})
+
+	t.Run("store errors", func(t *testing.T) {
+		c := newTestClient(t)
+
+		c.store = &mockstore.Store{ErrGet: errors.New("get error")}
+
+		_, err := c.Token(context.Background(), "session")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to get session tokens")
+
+		c.store = &mockstore.Store{GetReturn: []byte("invalid")}
+
+		_, err = c.Token(context.Background(), "session")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to unmarshal session tokens")
+	})
+}
+
+func TestClient_UserInfo(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		c := newTestClient(t)
+
+		sessionID, err := c.saveToken(&oauth2.Token{AccessToken: "t1"})
+		require.NoError(t, err)
+
+		c.oidcProvider = &mockOIDCProvider{
+			userInfo: &mockToken{oidcClaimsFunc: claimsFunc(map[string]interface{}{"sub": "user1", "k1": "v1"})},
+		}
+
+		claims, err := c.UserInfo(context.Background(), sessionID)
+		require.NoError(t, err)
+		require.Equal(t, "v1", claims["k1"])
+	})
+
+	t.Run("subject mismatch", func(t *testing.T) {
+		c := newTestClient(t)
+
+		c.oidcProvider = &mockOIDCProvider{
+			userInfo: &mockToken{oidcClaimsFunc: claimsFunc(map[string]interface{}{"sub": "user2"})},
+		}
+
+		err := c.mergeUserInfo(context.Background(), oauth2.StaticTokenSource(&oauth2.Token{}),
+			map[string]interface{}{"sub": "user1"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "user info subject does not match")
+	})
+
+	t.Run("invalid claims", func(t *testing.T) {
+		c := newTestClient(t)
+
+		sessionID, err := c.saveToken(&oauth2.Token{AccessToken: "t1"})
+		require.NoError(t, err)
+
+		c.oidcProvider = &mockOIDCProvider{userInfo: &mockToken{oidcClaimsErr: errors.New("claims error")}}
+
+		_, err = c.UserInfo(context.Background(), sessionID)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to extract user info claims")
+	})
+
+	t.Run("session not found", func(t *testing.T) {
+		c := newTestClient(t)
+
+		_, err := c.UserInfo(context.Background(), "invalid")
+		require.ErrorIs(t, err, ErrSessionNotFound)
+	})
+}
+
+func TestClient_DeleteSession(t *testing.T) {
+	c := newTestClient(t)
+
+	sessionID, err := c.saveToken(&oauth2.Token{AccessToken: "t1"})
+	require.NoError(t, err)
+
+	require.NoError(t, c.DeleteSession(sessionID))
+
+	_, err = c.Token(context.Background(), sessionID)
+	require.ErrorIs(t, err, ErrSessionNotFound)
+
+	c.store = &mockstore.Store{ErrDelete: errors.New("delete error")}
+	require.Error(t, c.DeleteSession(sessionID))
+}
+
+func newTestClient(t *testing.T) *Client {
+	t.Helper()
+
+	config, cleanup := config()
+	t.Cleanup(cleanup)
+
+	config.SessionTTL = time.Hour
+
+	c, err := New(config)
+	require.NoError(t, err)
+
+	return c
+}
+
+type mockTokenSource struct {
+	err error
+}
+
+func (m *mockTokenSource) Token() (*oauth2.Token, error) {
+	return nil, m.err
+}
+
+type countingTokenSource struct {
+	token *oauth2.Token
+	calls int32
+}
+
+func (m *countingTokenSource) Token() (*oauth2.Token, error) {
+	atomic.AddInt32(&m.calls, 1)
+
+	return m.token, nil
+}
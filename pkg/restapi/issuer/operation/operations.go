@@ -35,6 +35,7 @@ import (
 	"github.com/trustbloc/sandbox/pkg/internal/common/support"
 	oidcclient "github.com/trustbloc/sandbox/pkg/restapi/internal/common/oidc"
 	"github.com/trustbloc/sandbox/pkg/token"
+	tokenissuer "github.com/trustbloc/sandbox/pkg/token/issuer"
 )
 
 const (
@@ -42,6 +43,7 @@ const (
 	settings               = "/settings"
 	getCreditScore         = "/getCreditScore"
 	callback               = "/callback"
+	logout                 = "/logout"
 	generate               = "/generate"
 	revoke                 = "/revoke"
 	didcommInit            = "/didcomm/init"
@@ -69,7 +71,9 @@ const (
 	vcsProfileCookie     = "vcsProfile"
 	adapterProfileCookie = "adapterProfile"
 	assuranceScopeCookie = "assuranceScope"
-	callbackURLCookie    = "callbackURL"
+
+	// vcsProfileLoginKey is the login data key of the vcs profile
+	vcsProfileLoginKey = "vcsProfile"
 
 	issueCredentialURLFormat = "%s/%s" + "/credentials/issue"
 
@@ -94,10 +98,13 @@ type Handler interface {
 }
 
 type oidcClient interface {
-	CreateOIDCRequest(state, scope string) (string, error)
-	HandleOIDCCallback(reqContext context.Context, code string) ([]byte, error)
+	CreateOIDCRequest(provider, state, scope string) (string, error)
+	HandleOIDCCallback(reqContext context.Context, state, code string) (*oidcclient.CallbackResult, error)
 }
 
+// OIDCProviderConfig defines a named oidc provider, selected with the provider parameter of the oidc request.
+type OIDCProviderConfig = oidcclient.ProviderSettings
+
 // Operation defines handlers for authorization service
 type Operation struct {
 	handlers                []Handler
@@ -142,6 +149,8 @@ type Config struct {
 	OIDCClientID     string
 	OIDCClientSecret string
 	OIDCCallbackURL  string
+	OIDCUserInfo     bool
+	OIDCProviders    map[string]*OIDCProviderConfig
 	didcommScopes    map[string]struct{}
 	assuranceScopes  map[string]string
 }
@@ -153,15 +162,21 @@ type vc struct {
 }
 
 type tokenIssuer interface {
-	AuthCodeURL(w http.ResponseWriter) string
-	Exchange(r *http.Request) (*oauth2.Token, error)
+	AuthCodeURL(w http.ResponseWriter, req *tokenissuer.LoginRequest) (string, error)
+	Exchange(r *http.Request) (*tokenissuer.LoginContext, error)
 	Client(t *oauth2.Token) *http.Client
+	Revoke(t *oauth2.Token) error
 }
 
 type tokenResolver interface {
 	Resolve(token string) (*token.Introspection, error)
 }
 
+// tokenInvalidator is implemented by the token resolvers that cache tokens
+type tokenInvalidator interface {
+	Invalidate(token string)
+}
+
 type createOIDCRequestResponse struct {
 	Request string `json:"request"`
 }
@@ -207,11 +222,17 @@ func New(config *Config) (*Operation, error) {
 		svc.assuranceScopes = config.assuranceScopes
 	}
 
-	if config.OIDCProviderURL != "" {
-		svc.oidcClient, err = oidcclient.New(&oidcclient.Config{
-			OIDCClientID:     config.OIDCClientID,
-			OIDCClientSecret: config.OIDCClientSecret, OIDCCallbackURL: config.OIDCCallbackURL,
-			OIDCProviderURL: config.OIDCProviderURL, TLSConfig: config.TLSConfig,
+	if config.OIDCProviderURL != "" || len(config.OIDCProviders) > 0 {
+		svc.oidcClient, err = oidcclient.NewRegistry(&oidcclient.RegistryConfig{
+			TLSConfig:     config.TLSConfig,
+			CallbackURL:   config.OIDCCallbackURL,
+			StoreProvider: config.StoreProvider,
+			Providers: oidcclient.ProviderConfigs(&oidcclient.ProviderSettings{
+				URL:          config.OIDCProviderURL,
+				ClientID:     config.OIDCClientID,
+				ClientSecret: config.OIDCClientSecret,
+				UserInfo:     config.OIDCUserInfo,
+			}, config.OIDCProviders),
 		})
 		if err != nil {
 			return nil, fmt.Errorf("failed to create oidc client : %w", err)
@@ -231,6 +252,7 @@ func (c *Operation) registerHandler() {
 		support.NewHTTPHandler(settings, http.MethodGet, c.settings),
 		support.NewHTTPHandler(getCreditScore, http.MethodGet, c.getCreditScore),
 		support.NewHTTPHandler(callback, http.MethodGet, c.callback),
+		support.NewHTTPHandler(logout, http.MethodPost, c.logout),
 		support.NewHTTPHandler(oidcRedirectPath, http.MethodGet, c.oidcRedirect),
 
 		// issuer rest apis (html decoupled)
@@ -264,10 +286,6 @@ func (c *Operation) registerHandler() {
 
 // login using oauth2, will redirect to Auth Code URL
 func (c *Operation) login(w http.ResponseWriter, r *http.Request) {
-	u := c.tokenIssuer.AuthCodeURL(w)
-
-	expire := time.Now().AddDate(0, 0, 1)
-
 	if len(r.URL.Query()["vcsProfile"]) == 0 {
 		logger.Errorf("vcs profile is empty")
 		c.writeErrorResponse(w, http.StatusBadRequest, "vcs profile is empty")
@@ -275,15 +293,17 @@ func (c *Operation) login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	scope := r.URL.Query()["scope"]
-	if len(scope) > 0 {
-		u += "&scope=" + scope[0]
-	}
-
-	cookie := http.Cookie{Name: vcsProfileCookie, Value: r.URL.Query()["vcsProfile"][0], Expires: expire}
-	http.SetCookie(w, &cookie)
+	u, err := c.tokenIssuer.AuthCodeURL(w, &tokenissuer.LoginRequest{
+		Scope: strings.Fields(r.URL.Query().Get("scope")),
+		Data:  map[string]string{vcsProfileLoginKey: r.URL.Query()["vcsProfile"][0]},
+	})
+	if err != nil {
+		logger.Errorf("failed to create auth code url: %s", err.Error())
+		c.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to create auth code url: %s", err.Error()))
 
-	http.SetCookie(w, &http.Cookie{Name: callbackURLCookie, Value: "", Expires: expire})
+		return
+	}
 
 	http.Redirect(w, r, u, http.StatusTemporaryRedirect)
 }
@@ -312,15 +332,16 @@ func (c *Operation) auth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	u := c.tokenIssuer.AuthCodeURL(w)
-	u += "&scope=" + scope[0]
+	u, err := c.tokenIssuer.AuthCodeURL(w, &tokenissuer.LoginRequest{
+		Scope:       strings.Fields(scope[0]),
+		RedirectURL: callBackURL[0],
+	})
+	if err != nil {
+		c.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to create auth code url: %s", err.Error()))
 
-	cookie := http.Cookie{
-		Name:    callbackURLCookie,
-		Value:   callBackURL[0],
-		Expires: time.Now().AddDate(0, 0, 1),
+		return
 	}
-	http.SetCookie(w, &cookie)
 
 	http.Redirect(w, r, "/oidc/redirect/"+referrer[0]+"?url="+url.QueryEscape(u), http.StatusTemporaryRedirect)
 }
@@ -455,6 +476,13 @@ func (c *Operation) getTokenInfo(w http.ResponseWriter, r *http.Request) (*token
 	tk := oauth2.Token{AccessToken: accessToken}
 
 	info, err := c.tokenResolver.Resolve(accessToken)
+	if errors.Is(err, token.ErrInvalidToken) {
+		logger.Infof("rejected request with invalid token : %s", err)
+		c.writeErrorResponse(w, http.StatusUnauthorized, `Bearer error="invalid_token"`)
+
+		return nil, nil, fmt.Errorf("token is invalid : %w", err)
+	}
+
 	if err != nil {
 		logger.Errorf("failed to get token info: %s", err.Error())
 		c.writeErrorResponse(w, http.StatusBadRequest,
@@ -631,7 +659,7 @@ func (c *Operation) callback(w http.ResponseWriter, r *http.Request) { //nolint:
 		}
 	}
 
-	tk, err := c.tokenIssuer.Exchange(r)
+	login, err := c.tokenIssuer.Exchange(r)
 	if err != nil {
 		logger.Errorf("failed to exchange code for token: %s", err.Error())
 		c.writeErrorResponse(w, http.StatusBadRequest,
@@ -640,6 +668,8 @@ func (c *Operation) callback(w http.ResponseWriter, r *http.Request) { //nolint:
 		return
 	}
 
+	tk := login.Token
+
 	// user info from token will be used for to retrieve data from cms
 	info, err := c.tokenResolver.Resolve(tk.AccessToken)
 	if err != nil {
@@ -659,15 +689,7 @@ func (c *Operation) callback(w http.ResponseWriter, r *http.Request) { //nolint:
 		return
 	}
 
-	callbackURLCookie, err := r.Cookie(callbackURLCookie)
-	if err != nil && !errors.Is(err, http.ErrNoCookie) {
-		c.writeErrorResponse(w, http.StatusBadRequest,
-			fmt.Sprintf("failed to get authMode cookie: %s", err.Error()))
-
-		return
-	}
-
-	if callbackURLCookie != nil && callbackURLCookie.Value != "" {
+	if login.RedirectURL != "" {
 		txnID := uuid.NewString()
 		data := txnData{
 			UserID: userID,
@@ -690,21 +712,20 @@ func (c *Operation) callback(w http.ResponseWriter, r *http.Request) { //nolint:
 			return
 		}
 
-		http.Redirect(w, r, callbackURLCookie.Value+"?txnID="+txnID, http.StatusTemporaryRedirect)
+		http.Redirect(w, r, login.RedirectURL+"?txnID="+txnID, http.StatusTemporaryRedirect)
 
 		return
 	}
 
-	vcsProfileCookie, err := r.Cookie(vcsProfileCookie)
-	if err != nil {
-		logger.Errorf("failed to get cookie: %s", err.Error())
-		c.writeErrorResponse(w, http.StatusBadRequest,
-			fmt.Sprintf("failed to get cookie: %s", err.Error()))
+	vcsProfile := login.Data[vcsProfileLoginKey]
+	if vcsProfile == "" {
+		logger.Errorf("missing vcs profile of the login")
+		c.writeErrorResponse(w, http.StatusBadRequest, "missing vcs profile of the login")
 
 		return
 	}
 
-	cred, err := c.prepareCredential(subject, info.Scope, vcsProfileCookie.Value)
+	cred, err := c.prepareCredential(subject, info.Scope, vcsProfile)
 	if err != nil {
 		logger.Errorf("failed to create credential: %s", err.Error())
 		c.writeErrorResponse(w, http.StatusInternalServerError,
@@ -713,6 +734,9 @@ func (c *Operation) callback(w http.ResponseWriter, r *http.Request) { //nolint:
 		return
 	}
 
+	// the token is used only once to get the credential data in the chapi flow
+	c.revokeToken(tk)
+
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
 	t, err := template.ParseFiles(c.didAuthHTML)
@@ -725,13 +749,52 @@ func (c *Operation) callback(w http.ResponseWriter, r *http.Request) { //nolint:
 	}
 
 	if err := t.Execute(w, map[string]interface{}{
-		"Path": generate + "?" + "profile=" + vcsProfileCookie.Value,
+		"Path": generate + "?" + "profile=" + url.QueryEscape(vcsProfile),
 		"Cred": string(cred),
 	}); err != nil {
 		logger.Errorf(fmt.Sprintf("failed execute qr html template: %s", err.Error()))
 	}
 }
 
+// logout revokes the bearer token of the request
+func (c *Operation) logout(w http.ResponseWriter, r *http.Request) {
+	_, tk, err := c.getTokenInfo(w, r)
+	if err != nil {
+		return
+	}
+
+	err = c.tokenIssuer.Revoke(tk)
+	if err != nil {
+		logger.Errorf("failed to revoke token: %s", err.Error())
+		c.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to revoke token: %s", err.Error()))
+
+		return
+	}
+
+	c.invalidateToken(tk)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// revokeToken revokes the token, failures are only logged as the token expires anyway
+func (c *Operation) revokeToken(tk *oauth2.Token) {
+	err := c.tokenIssuer.Revoke(tk)
+	if err != nil {
+		logger.Warnf("failed to revoke token: %s", err.Error())
+
+		return
+	}
+
+	c.invalidateToken(tk)
+}
+
+func (c *Operation) invalidateToken(tk *oauth2.Token) {
+	if invalidator, ok := c.tokenResolver.(tokenInvalidator); ok {
+		invalidator.Invalidate(tk.AccessToken)
+	}
+}
+
 func (c *Operation) getCreditScore(w http.ResponseWriter, r *http.Request) {
 	userID, subject, err := c.getCMSData(nil, "name="+url.QueryEscape(r.URL.Query()["givenName"][0]+" "+
 		r.URL.Query()["familyName"][0]), r.URL.Query()["didCommScope"][0])
@@ -757,7 +820,13 @@ func (c *Operation) createOIDCRequest(w http.ResponseWriter, r *http.Request) {
 	// TODO validate scope
 	state := uuid.New().String()
 
-	redirectURL, err := c.oidcClient.CreateOIDCRequest(state, scope)
+	redirectURL, err := c.oidcClient.CreateOIDCRequest(r.URL.Query().Get(oidcclient.ProviderQueryParam), state, scope)
+	if errors.Is(err, oidcclient.ErrUnknownProvider) {
+		c.writeErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("failed to create oidc request : %s", err))
+
+		return
+	}
+
 	if err != nil {
 		c.writeErrorResponse(w,
 			http.StatusInternalServerError, fmt.Sprintf("failed to create oidc request : %s", err))
@@ -946,7 +1015,7 @@ func (c *Operation) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	data, err := c.oidcClient.HandleOIDCCallback(r.Context(), code)
+	result, err := c.oidcClient.HandleOIDCCallback(r.Context(), state, code)
 	if err != nil {
 		logger.Errorf("failed to handle oidc callback : %s", err)
 		c.didcommDemoResult(w, fmt.Sprintf("failed to handle oidc callback: %s", err))
@@ -954,6 +1023,14 @@ func (c *Operation) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	data, err := json.Marshal(result.Claims)
+	if err != nil {
+		logger.Errorf("failed to marshal user data : %s", err)
+		c.didcommDemoResult(w, fmt.Sprintf("failed to marshal user data: %s", err))
+
+		return
+	}
+
 	c.didcommDemoResult(w, string(data))
 }
 
@@ -973,9 +1050,24 @@ func (c *Operation) didcommDemoResult(w http.ResponseWriter, data string) {
 	}
 }
 
+// generateVCProfile returns the vcs profile of the login in the profile query parameter, or the profile
+// of the settings cookie.
+func generateVCProfile(r *http.Request) (string, error) {
+	if profile := r.FormValue("profile"); profile != "" {
+		return profile, nil
+	}
+
+	cookie, err := r.Cookie(vcsProfileCookie)
+	if err != nil {
+		return "", err
+	}
+
+	return cookie.Value, nil
+}
+
 // generateVC for creates VC
 func (c *Operation) generateVC(w http.ResponseWriter, r *http.Request) {
-	vcsProfileCookie, err := r.Cookie(vcsProfileCookie)
+	vcsProfile, err := generateVCProfile(r)
 	if err != nil {
 		logger.Errorf("failed to get vcsProfileCookie: %s", err.Error())
 		c.writeErrorResponse(w, http.StatusBadRequest,
@@ -1001,7 +1093,7 @@ func (c *Operation) generateVC(w http.ResponseWriter, r *http.Request) {
 	}
 
 	cred, err := c.createCredential(r.Form["cred"][0], r.Form["authresp"][0], r.Form["holder"][0],
-		r.Form["domain"][0], r.Form["challenge"][0], vcsProfileCookie.Value)
+		r.Form["domain"][0], r.Form["challenge"][0], vcsProfile)
 	if err != nil {
 		logger.Errorf("failed to create verifiable credential: %s", err.Error())
 		c.writeErrorResponse(w, http.StatusInternalServerError,
@@ -1010,7 +1102,7 @@ func (c *Operation) generateVC(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = c.storeCredential(cred, vcsProfileCookie.Value)
+	err = c.storeCredential(cred, vcsProfile)
 	if err != nil {
 		logger.Errorf("failed to store credential: %s", err.Error())
 		c.writeErrorResponse(w, http.StatusInternalServerError,
@@ -28,6 +28,7 @@ import (
 	"golang.org/x/oauth2"
 
 	"github.com/trustbloc/sandbox/pkg/token"
+	tokenissuer "github.com/trustbloc/sandbox/pkg/token/issuer"
 )
 
 const authHeader = "Bearer ABC"
@@ -212,7 +213,10 @@ func TestController_New(t *testing.T) {
 		require.Contains(t, err.Error(), "issuer store provider : store open error")
 		require.Nil(t, op)
 
-		op, err = New(&Config{StoreProvider: &mockstorage.Provider{}, OIDCProviderURL: "url"})
+		op, err = New(&Config{
+			StoreProvider: &mockstorage.Provider{},
+			OIDCProviders: map[string]*OIDCProviderConfig{"": {URL: "url"}},
+		})
 		require.Error(t, err)
 		require.Contains(t, err.Error(), "failed to create oidc client")
 		require.Nil(t, op)
@@ -220,8 +224,10 @@ func TestController_New(t *testing.T) {
 }
 
 func TestOperation_Login(t *testing.T) {
+	tokenIssuer := &mockTokenIssuer{}
+
 	cfg := &Config{
-		TokenIssuer: &mockTokenIssuer{}, TokenResolver: &mockTokenResolver{},
+		TokenIssuer: tokenIssuer, TokenResolver: &mockTokenResolver{},
 		StoreProvider: &mockstorage.Provider{},
 	}
 	handler := getHandlerWithConfig(t, login, cfg)
@@ -235,12 +241,24 @@ func TestOperation_Login(t *testing.T) {
 	require.NoError(t, err)
 	require.Contains(t, buff.String(), "Temporary Redirect")
 	require.Equal(t, http.StatusTemporaryRedirect, status)
+	require.Equal(t, []string{"test"}, tokenIssuer.requests[0].Scope)
+	require.Equal(t, "vc-issuer-1", tokenIssuer.requests[0].Data[vcsProfileLoginKey])
+
+	cfg.TokenIssuer = &mockTokenIssuer{authURLErr: errors.New("auth url error")}
+	handler = getHandlerWithConfig(t, login, cfg)
+
+	buff, status, err = handleRequest(handler, nil, login+"?vcsProfile=vc-issuer-1", true)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusInternalServerError, status)
+	require.Contains(t, buff.String(), "failed to create auth code url: auth url error")
 }
 
 func TestAuth(t *testing.T) {
 	t.Run("success", func(t *testing.T) {
+		tokenIssuer := &mockTokenIssuer{}
+
 		svc, err := New(&Config{
-			TokenIssuer: &mockTokenIssuer{}, TokenResolver: &mockTokenResolver{},
+			TokenIssuer: tokenIssuer, TokenResolver: &mockTokenResolver{},
 			StoreProvider: memstore.NewProvider(),
 		})
 		require.NoError(t, err)
@@ -252,6 +270,26 @@ func TestAuth(t *testing.T) {
 
 		svc.auth(rr, req)
 		require.Equal(t, http.StatusTemporaryRedirect, rr.Code)
+		require.Equal(t, "/abc", tokenIssuer.requests[0].RedirectURL)
+		require.Empty(t, rr.Result().Cookies())
+	})
+
+	t.Run("auth code url error", func(t *testing.T) {
+		svc, err := New(&Config{
+			TokenIssuer:   &mockTokenIssuer{authURLErr: errors.New("auth url error")},
+			TokenResolver: &mockTokenResolver{},
+			StoreProvider: memstore.NewProvider(),
+		})
+		require.NoError(t, err)
+
+		req, err := http.NewRequest(http.MethodGet, authPath+"?scope=test&callbackURL=/abc&referrer=prc", nil)
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+
+		svc.auth(rr, req)
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to create auth code url")
 	})
 
 	t.Run("missing scope", func(t *testing.T) {
@@ -575,6 +613,17 @@ func TestOperation_getTokenInfo(t *testing.T) {
 		require.Contains(t, err.Error(), "token is invalid")
 		require.Equal(t, http.StatusUnauthorized, rw.Code)
 	})
+
+	t.Run("failure: token is expired", func(t *testing.T) {
+		req.Header.Set("Authorization", "Bearer token")
+
+		op.tokenResolver = &mockTokenResolver{err: token.ErrExpiredToken}
+		rw := httptest.NewRecorder()
+
+		_, _, err := op.getTokenInfo(rw, req)
+		require.ErrorIs(t, err, token.ErrExpiredToken)
+		require.Equal(t, http.StatusUnauthorized, rw.Code)
+	})
 }
 
 func TestOperation_getIDHandler(t *testing.T) {
@@ -973,8 +1022,10 @@ func TestOperation_Callback(t *testing.T) {
 
 		defer func() { require.NoError(t, os.Remove(file.Name())) }()
 
+		tokenIssuer := &mockTokenIssuer{}
+
 		cfg := &Config{
-			TokenIssuer: &mockTokenIssuer{}, TokenResolver: &mockTokenResolver{},
+			TokenIssuer: tokenIssuer, TokenResolver: &mockTokenResolver{},
 			CMSURL: cms.URL, VCSURL: vcs.URL, ReceiveVCHTML: file.Name(),
 			DIDAuthHTML:   file.Name(),
 			StoreProvider: &mockstorage.Provider{},
@@ -984,14 +1035,15 @@ func TestOperation_Callback(t *testing.T) {
 		_, status, err := handleRequest(handler, headers, callback, true)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusOK, status)
+		require.Len(t, tokenIssuer.revoked, 1)
 
 		_, status, err = handleRequest(handler, headers, callback+"?error=access_denied", true)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusTemporaryRedirect, status)
 
-		// test ledger cookie not found
+		// test login without vcs profile
 		cfg = &Config{
-			TokenIssuer: &mockTokenIssuer{}, TokenResolver: &mockTokenResolver{},
+			TokenIssuer: &mockTokenIssuer{login: &tokenissuer.LoginContext{}}, TokenResolver: &mockTokenResolver{},
 			CMSURL: cms.URL, VCSURL: vcs.URL, ReceiveVCHTML: file.Name(), DIDAuthHTML: file.Name(),
 			StoreProvider: &mockstorage.Provider{},
 		}
@@ -1000,7 +1052,7 @@ func TestOperation_Callback(t *testing.T) {
 		body, status, err := handleRequest(handler, headers, callback, false)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusBadRequest, status)
-		require.Contains(t, body.String(), "failed to get cookie")
+		require.Contains(t, body.String(), "missing vcs profile of the login")
 
 		// test html not exist
 		cfg = &Config{
@@ -1059,7 +1111,7 @@ func TestOperation_Callback(t *testing.T) {
 		require.Contains(t, body.String(), "failed to get cms data")
 	})
 
-	t.Run("with callbackURL cookie", func(t *testing.T) {
+	t.Run("with login redirect url", func(t *testing.T) {
 		cms := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			fmt.Fprintf(w, "[%s]", foo)
 			fmt.Fprintln(w)
@@ -1067,7 +1119,8 @@ func TestOperation_Callback(t *testing.T) {
 		defer cms.Close()
 
 		svc, err := New(&Config{
-			TokenIssuer: &mockTokenIssuer{}, TokenResolver: &mockTokenResolver{},
+			TokenIssuer:   &mockTokenIssuer{login: &tokenissuer.LoginContext{RedirectURL: "/abc"}},
+			TokenResolver: &mockTokenResolver{},
 			StoreProvider: memstore.NewProvider(),
 			CMSURL:        cms.URL,
 		})
@@ -1076,15 +1129,14 @@ func TestOperation_Callback(t *testing.T) {
 		req, err := http.NewRequest(http.MethodGet, callback, nil)
 		require.NoError(t, err)
 
-		req.AddCookie(&http.Cookie{Name: callbackURLCookie, Value: "/abc"})
-
 		rr := httptest.NewRecorder()
 
 		svc.callback(rr, req)
 		require.Equal(t, http.StatusTemporaryRedirect, rr.Code)
+		require.True(t, strings.HasPrefix(rr.Header().Get("Location"), "/abc?txnID="))
 	})
 
-	t.Run("with callbackURL cookie - save txn data error", func(t *testing.T) {
+	t.Run("with login redirect url - save txn data error", func(t *testing.T) {
 		cms := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			fmt.Fprintf(w, "[%s]", foo)
 			fmt.Fprintln(w)
@@ -1092,7 +1144,8 @@ func TestOperation_Callback(t *testing.T) {
 		defer cms.Close()
 
 		svc, err := New(&Config{
-			TokenIssuer: &mockTokenIssuer{}, TokenResolver: &mockTokenResolver{},
+			TokenIssuer:   &mockTokenIssuer{login: &tokenissuer.LoginContext{RedirectURL: "/abc"}},
+			TokenResolver: &mockTokenResolver{},
 			StoreProvider: &mockstorage.Provider{OpenStoreReturn: &mockstorage.Store{
 				ErrPut: errors.New("save error"),
 			}},
@@ -1103,8 +1156,6 @@ func TestOperation_Callback(t *testing.T) {
 		req, err := http.NewRequest(http.MethodGet, callback, nil)
 		require.NoError(t, err)
 
-		req.AddCookie(&http.Cookie{Name: callbackURLCookie, Value: "/abc"})
-
 		rr := httptest.NewRecorder()
 
 		svc.callback(rr, req)
@@ -1175,6 +1226,15 @@ func TestOperation_GenerateVC(t *testing.T) {
 
 		svc.generateVC(rr, req)
 		require.Equal(t, http.StatusOK, rr.Code)
+
+		// the profile of the login is passed in the query
+		rr = httptest.NewRecorder()
+
+		req.Header = make(map[string][]string)
+		req.Form.Add("profile", "vc-issuer-1")
+
+		svc.generateVC(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
 	})
 
 	t.Run("generate VC - validations", func(t *testing.T) {
@@ -1432,6 +1492,60 @@ func createTestDocumentLoader(t *testing.T) *jsonld.DocumentLoader {
 	return loader
 }
 
+func TestOperation_Logout(t *testing.T) {
+	t.Run("revokes the bearer token", func(t *testing.T) {
+		tokenIssuer := &mockTokenIssuer{}
+		tokenResolver := &mockCachingTokenResolver{mockTokenResolver: mockTokenResolver{
+			info: token.Introspection{Active: true},
+		}}
+
+		op, err := New(&Config{
+			TokenIssuer: tokenIssuer, TokenResolver: tokenResolver, StoreProvider: &mockstorage.Provider{},
+		})
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, logout, nil)
+		req.Header.Set("Authorization", "Bearer token")
+
+		rw := httptest.NewRecorder()
+		op.logout(rw, req)
+		require.Equal(t, http.StatusOK, rw.Code)
+		require.Equal(t, "token", tokenIssuer.revoked[0].AccessToken)
+		require.Equal(t, []string{"token"}, tokenResolver.invalidated)
+	})
+
+	t.Run("missing bearer token", func(t *testing.T) {
+		op, err := New(&Config{
+			TokenIssuer: &mockTokenIssuer{}, TokenResolver: &mockTokenResolver{}, StoreProvider: &mockstorage.Provider{},
+		})
+		require.NoError(t, err)
+
+		rw := httptest.NewRecorder()
+		op.logout(rw, httptest.NewRequest(http.MethodPost, logout, nil))
+		require.Equal(t, http.StatusUnauthorized, rw.Code)
+	})
+
+	t.Run("revoke error", func(t *testing.T) {
+		op, err := New(&Config{
+			TokenIssuer:   &mockTokenIssuer{revokeErr: errors.New("revoke error")},
+			TokenResolver: &mockTokenResolver{info: token.Introspection{Active: true}},
+			StoreProvider: &mockstorage.Provider{},
+		})
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, logout, nil)
+		req.Header.Set("Authorization", "Bearer token")
+
+		rw := httptest.NewRecorder()
+		op.logout(rw, req)
+		require.Equal(t, http.StatusInternalServerError, rw.Code)
+		require.Contains(t, rw.Body.String(), "failed to revoke token: revoke error")
+
+		// the chapi flow continues if the token can't be revoked
+		op.revokeToken(&oauth2.Token{AccessToken: "token"})
+	})
+}
+
 func TestOperation_Callback_ExchangeCodeError(t *testing.T) {
 	svc, err := New(&Config{
 		TokenIssuer:   &mockTokenIssuer{err: errors.New("exchange code error")},
@@ -1800,6 +1914,20 @@ func TestCreateOIDCRequest(t *testing.T) {
 		require.Contains(t, w.Body.String(), "failed to create")
 	})
 
+	t.Run("bad request if provider is unknown", func(t *testing.T) {
+		svc, err := New(&Config{
+			StoreProvider: memstore.NewProvider(),
+			OIDCProviders: map[string]*OIDCProviderConfig{"bank": {URL: "http://bank.example.com"}},
+		})
+		require.NoError(t, err)
+		req := newCreateOIDCHTTPRequest("CreditCardStatement")
+		req.URL.RawQuery += "&provider=gov"
+		w := httptest.NewRecorder()
+		svc.createOIDCRequest(w, req)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "unknown oidc provider : gov")
+	})
+
 	t.Run("bad request if scope is missing", func(t *testing.T) {
 		svc, err := New(&Config{StoreProvider: memstore.NewProvider()})
 		require.NoError(t, err)
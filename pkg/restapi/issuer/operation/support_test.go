@@ -18,7 +18,9 @@ import (
 	"github.com/stretchr/testify/require"
 	"golang.org/x/oauth2"
 
+	oidcclient "github.com/trustbloc/sandbox/pkg/restapi/internal/common/oidc"
 	"github.com/trustbloc/sandbox/pkg/token"
+	tokenissuer "github.com/trustbloc/sandbox/pkg/token/issuer"
 )
 
 func handleRequest(handler Handler, headers map[string]string, path string, addCookie bool) (*bytes.Buffer, int, error) { //nolint:lll
@@ -120,25 +122,53 @@ func serveHTTP(t *testing.T, handler http.HandlerFunc, method, path string, req
 }
 
 type mockTokenIssuer struct {
-	err error
+	err        error
+	authURLErr error
+	revokeErr  error
+	revoked    []*oauth2.Token
+	login      *tokenissuer.LoginContext
+	requests   []*tokenissuer.LoginRequest
 }
 
-func (m *mockTokenIssuer) AuthCodeURL(w http.ResponseWriter) string {
-	return "url"
+func (m *mockTokenIssuer) AuthCodeURL(w http.ResponseWriter, req *tokenissuer.LoginRequest) (string, error) {
+	if m.authURLErr != nil {
+		return "", m.authURLErr
+	}
+
+	m.requests = append(m.requests, req)
+
+	return "url", nil
 }
 
-func (m *mockTokenIssuer) Exchange(r *http.Request) (*oauth2.Token, error) {
+func (m *mockTokenIssuer) Exchange(r *http.Request) (*tokenissuer.LoginContext, error) {
 	if m.err != nil {
 		return nil, m.err
 	}
 
-	return &oauth2.Token{}, nil
+	if m.login != nil {
+		return &tokenissuer.LoginContext{Token: &oauth2.Token{}, RedirectURL: m.login.RedirectURL, Data: m.login.Data}, nil
+	}
+
+	return &tokenissuer.LoginContext{
+		Token: &oauth2.Token{},
+		Data:  map[string]string{vcsProfileLoginKey: "vc-issuer-1"},
+	}, nil
 }
 
 func (m *mockTokenIssuer) Client(t *oauth2.Token) *http.Client {
 	return http.DefaultClient
 }
 
+func (m *mockTokenIssuer) Revoke(t *oauth2.Token) error {
+	if m.revokeErr != nil {
+		return m.revokeErr
+	}
+
+	m.revoked = append(m.revoked, t)
+
+	return nil
+}
+
 type mockTokenResolver struct {
 	info token.Introspection
 	err  error
@@ -152,18 +182,32 @@ func (r *mockTokenResolver) Resolve(string) (*token.Introspection, error) {
 	return &r.info, nil
 }
 
+type mockCachingTokenResolver struct {
+	mockTokenResolver
+	invalidated []string
+}
+
+func (r *mockCachingTokenResolver) Invalidate(tk string) {
+	r.invalidated = append(r.invalidated, tk)
+}
+
 type mockOIDCClient struct {
 	createOIDCRequest     string
 	createOIDCRequestErr  error
 	handleOIDCCallbackErr error
 }
 
-func (m *mockOIDCClient) CreateOIDCRequest(state, scope string) (string, error) {
+func (m *mockOIDCClient) CreateOIDCRequest(provider, state, scope string) (string, error) {
 	return m.createOIDCRequest, m.createOIDCRequestErr
 }
 
-func (m *mockOIDCClient) HandleOIDCCallback(reqContext context.Context, code string) ([]byte, error) {
-	return nil, m.handleOIDCCallbackErr
+func (m *mockOIDCClient) HandleOIDCCallback(
+	reqContext context.Context, state, code string) (*oidcclient.CallbackResult, error) {
+	if m.handleOIDCCallbackErr != nil {
+		return nil, m.handleOIDCCallbackErr
+	}
+
+	return &oidcclient.CallbackResult{Claims: map[string]interface{}{"sub": state}}, nil
 }
 
 func newCreateOIDCHTTPRequest(scope string) *http.Request {
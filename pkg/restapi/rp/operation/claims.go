@@ -0,0 +1,331 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	oidcclient "github.com/trustbloc/sandbox/pkg/restapi/internal/common/oidc"
+)
+
+const (
+	claimsPath         = "/claims"
+	getClaimsPath      = claimsPath + "/{id}"
+	getUserInfoPath    = getClaimsPath + "/userinfo"
+	claimsStoreName    = "rp-rest-claims"
+	claimsKeySeparator = "."
+
+	defaultClaimsSessionTTL = 15 * time.Minute
+)
+
+// ClaimsMapping maps a normalized claim name (for example 'credentialSubject.givenName' flattened
+// to 'givenName') to the field name expected by the application page of a flow.
+type ClaimsMapping map[string]string
+
+// claimsSession holds the verified claims saved for the business page which requested them.
+type claimsSession struct {
+	ID        string                 `json:"id"`
+	FlowType  string                 `json:"flowType,omitempty"`
+	Claims    map[string]interface{} `json:"claims"`
+	CreatedAt time.Time              `json:"createdAt"`
+	ExpiresAt time.Time              `json:"expiresAt"`
+	// OIDCLogin is the oidc session the claims came from, its tokens are kept to fetch the UserInfo claims
+	OIDCLogin *oidcLogin `json:"oidcLogin,omitempty"`
+}
+
+type oidcLogin struct {
+	Provider  string `json:"provider"`
+	SessionID string `json:"sessionID"`
+}
+
+type saveClaimsResponse struct {
+	SessionID string `json:"sessionID"`
+}
+
+// saveClaimsSession normalizes the claims, applies the mapping configured for the flow and saves
+// the result under a new session ID. The oidc login is nil for the claims of a presentation.
+func (c *Operation) saveClaimsSession(flowType string, claims map[string]interface{},
+	login *oidcLogin) (string, error) {
+	now := time.Now().UTC()
+
+	session := &claimsSession{
+		ID:        uuid.NewString(),
+		FlowType:  flowType,
+		Claims:    mapClaims(normalizeClaims(claims), c.flowClaims[flowType]),
+		CreatedAt: now,
+		ExpiresAt: now.Add(c.claimsSessionTTL),
+		OIDCLogin: login,
+	}
+
+	sessionBytes, err := json.Marshal(session)
+	if err != nil {
+		return "", fmt.Errorf("marshal claims session : %w", err)
+	}
+
+	err = c.claimsStore.Put(session.ID, sessionBytes)
+	if err != nil {
+		return "", fmt.Errorf("save claims session : %w", err)
+	}
+
+	return session.ID, nil
+}
+
+func (c *Operation) getClaimsSession(id string) (*claimsSession, error) {
+	sessionBytes, err := c.claimsStore.Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("get claims session : %w", err)
+	}
+
+	session := &claimsSession{}
+
+	err = json.Unmarshal(sessionBytes, session)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal claims session : %w", err)
+	}
+
+	if time.Now().UTC().After(session.ExpiresAt) {
+		if delErr := c.claimsStore.Delete(id); delErr != nil {
+			logger.Warnf("failed to delete expired claims session %s : %s", id, delErr)
+		}
+
+		if session.OIDCLogin != nil {
+			delErr := c.oidcClient.DeleteSession(session.OIDCLogin.Provider, session.OIDCLogin.SessionID)
+			if delErr != nil {
+				logger.Warnf("failed to delete oidc session of claims session %s : %s", id, delErr)
+			}
+		}
+
+		return nil, fmt.Errorf("get claims session : %w", storage.ErrDataNotFound)
+	}
+
+	return session, nil
+}
+
+func (c *Operation) getClaims(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if id == "" {
+		c.writeErrorResponse(w, http.StatusBadRequest, "missing claims session id")
+
+		return
+	}
+
+	session, err := c.getClaimsSession(id)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		c.writeErrorResponse(w, http.StatusNotFound, fmt.Sprintf("claims session not found or expired : %s", id))
+
+		return
+	}
+
+	if err != nil {
+		c.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to get claims : %s", err))
+
+		return
+	}
+
+	// the oidc session is only used by the server
+	session.OIDCLogin = nil
+
+	sessionBytes, err := json.Marshal(session)
+	if err != nil {
+		c.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to marshal claims : %s", err))
+
+		return
+	}
+
+	w.Header().Set("content-type", httpContentTypeJSON)
+	c.writeResponse(w, http.StatusOK, sessionBytes)
+}
+
+// getUserInfo fetches the current UserInfo claims of the oidc login behind the claims session. The claims
+// are mapped like the claims of the session.
+func (c *Operation) getUserInfo(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	session, err := c.getClaimsSession(id)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		c.writeErrorResponse(w, http.StatusNotFound, fmt.Sprintf("claims session not found or expired : %s", id))
+
+		return
+	}
+
+	if err != nil {
+		c.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to get claims : %s", err))
+
+		return
+	}
+
+	if session.OIDCLogin == nil {
+		c.writeErrorResponse(w, http.StatusNotFound, fmt.Sprintf("claims session has no oidc login : %s", id))
+
+		return
+	}
+
+	claims, err := c.oidcClient.UserInfo(r.Context(), session.OIDCLogin.Provider, session.OIDCLogin.SessionID)
+	if errors.Is(err, oidcclient.ErrSessionNotFound) {
+		c.writeErrorResponse(w, http.StatusNotFound, fmt.Sprintf("oidc session not found or expired : %s", id))
+
+		return
+	}
+
+	if err != nil {
+		c.writeErrorResponse(w, http.StatusBadGateway, fmt.Sprintf("failed to fetch user info : %s", err))
+
+		return
+	}
+
+	respBytes, err := json.Marshal(mapClaims(normalizeClaims(claims), c.flowClaims[session.FlowType]))
+	if err != nil {
+		c.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to marshal claims : %s", err))
+
+		return
+	}
+
+	w.Header().Set("content-type", httpContentTypeJSON)
+	c.writeResponse(w, http.StatusOK, respBytes)
+}
+
+// presentationClaims extracts the credential subjects of all the credentials in the presentation.
+func presentationClaims(vp []byte) (map[string]interface{}, error) {
+	presentation := struct {
+		VerifiableCredential json.RawMessage `json:"verifiableCredential"`
+	}{}
+
+	err := json.Unmarshal(vp, &presentation)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal presentation : %w", err)
+	}
+
+	creds, err := presentationCredentials(presentation.VerifiableCredential)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := make(map[string]interface{})
+
+	for _, cred := range creds {
+		for _, subject := range credentialSubjects(cred.Subject) {
+			for k, v := range subject {
+				claims[k] = v
+			}
+		}
+	}
+
+	return claims, nil
+}
+
+type presentedCredential struct {
+	Types   interface{}     `json:"type"`
+	Issuer  interface{}     `json:"issuer"`
+	Subject json.RawMessage `json:"credentialSubject"`
+}
+
+func presentationCredentials(raw json.RawMessage) ([]presentedCredential, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	var rawCreds []json.RawMessage
+
+	if err := json.Unmarshal(raw, &rawCreds); err != nil {
+		rawCreds = []json.RawMessage{raw}
+	}
+
+	creds := make([]presentedCredential, 0, len(rawCreds))
+
+	for _, rawCred := range rawCreds {
+		var jwt string
+
+		if json.Unmarshal(rawCred, &jwt) == nil {
+			cred, err := jwtCredential(jwt)
+			if err != nil {
+				return nil, err
+			}
+
+			creds = append(creds, *cred)
+
+			continue
+		}
+
+		var cred presentedCredential
+
+		if err := json.Unmarshal(rawCred, &cred); err != nil {
+			return nil, fmt.Errorf("unmarshal credential : %w", err)
+		}
+
+		creds = append(creds, cred)
+	}
+
+	return creds, nil
+}
+
+func credentialSubjects(raw json.RawMessage) []map[string]interface{} {
+	var subjects []map[string]interface{}
+
+	if err := json.Unmarshal(raw, &subjects); err == nil {
+		return subjects
+	}
+
+	var subject map[string]interface{}
+
+	if err := json.Unmarshal(raw, &subject); err == nil {
+		return []map[string]interface{}{subject}
+	}
+
+	return nil
+}
+
+// normalizeClaims flattens nested claims into a single level map keyed by the dot separated path.
+func normalizeClaims(claims map[string]interface{}) map[string]interface{} {
+	normalized := make(map[string]interface{})
+
+	flattenClaims("", claims, normalized)
+
+	return normalized
+}
+
+func flattenClaims(prefix string, claims, out map[string]interface{}) {
+	for k, v := range claims {
+		key := k
+		if prefix != "" {
+			key = prefix + claimsKeySeparator + k
+		}
+
+		if nested, ok := v.(map[string]interface{}); ok {
+			flattenClaims(key, nested, out)
+
+			continue
+		}
+
+		out[key] = v
+	}
+}
+
+// mapClaims renames the claims to application field names. Claims without a mapping are dropped
+// if the flow has a mapping configured; otherwise all the claims are returned as is.
+func mapClaims(claims map[string]interface{}, mapping ClaimsMapping) map[string]interface{} {
+	if len(mapping) == 0 {
+		return claims
+	}
+
+	mapped := make(map[string]interface{})
+
+	for claim, field := range mapping {
+		if v, ok := claims[claim]; ok {
+			mapped[field] = v
+		}
+	}
+
+	return mapped
+}
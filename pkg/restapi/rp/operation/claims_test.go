@@ -0,0 +1,289 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	mockstore "github.com/hyperledger/aries-framework-go/component/storageutil/mock"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+
+	oidcclient "github.com/trustbloc/sandbox/pkg/restapi/internal/common/oidc"
+)
+
+func TestGetClaims(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		config, cleanup := config(t)
+		defer cleanup()
+
+		config.FlowClaims = map[string]ClaimsMapping{
+			"flow1": {"name.given": "firstName"},
+		}
+
+		svc, err := New(config)
+		require.NoError(t, err)
+
+		id, err := svc.saveClaimsSession("flow1", map[string]interface{}{
+			"name": map[string]interface{}{"given": "John", "family": "Smith"},
+		}, nil)
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		svc.getClaims(rr, newGetClaimsRequest(id))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		session := &claimsSession{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), session))
+		require.Equal(t, id, session.ID)
+		require.Equal(t, "flow1", session.FlowType)
+		require.Equal(t, map[string]interface{}{"firstName": "John"}, session.Claims)
+		require.Nil(t, session.OIDCLogin)
+	})
+
+	t.Run("missing id", func(t *testing.T) {
+		config, cleanup := config(t)
+		defer cleanup()
+
+		svc, err := New(config)
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		svc.getClaims(rr, newGetClaimsRequest(""))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "missing claims session id")
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		config, cleanup := config(t)
+		defer cleanup()
+
+		svc, err := New(config)
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		svc.getClaims(rr, newGetClaimsRequest("invalid"))
+		require.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		config, cleanup := config(t)
+		defer cleanup()
+
+		svc, err := New(config)
+		require.NoError(t, err)
+
+		svc.claimsSessionTTL = -time.Minute
+
+		oidcClient := &mockOIDCClient{}
+		svc.oidcClient = oidcClient
+
+		id, err := svc.saveClaimsSession("", map[string]interface{}{"name": "John"},
+			&oidcLogin{Provider: "default", SessionID: "oidc-session"})
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		svc.getClaims(rr, newGetClaimsRequest(id))
+		require.Equal(t, http.StatusNotFound, rr.Code)
+
+		_, err = svc.claimsStore.Get(id)
+		require.True(t, errors.Is(err, storage.ErrDataNotFound))
+		require.Equal(t, []string{"oidc-session"}, oidcClient.deletedSessions)
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		config, cleanup := config(t)
+		defer cleanup()
+
+		svc, err := New(config)
+		require.NoError(t, err)
+
+		svc.claimsStore = &mockstore.Store{ErrGet: errors.New("get error")}
+
+		rr := httptest.NewRecorder()
+		svc.getClaims(rr, newGetClaimsRequest("id"))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "get error")
+	})
+
+	t.Run("invalid session data", func(t *testing.T) {
+		config, cleanup := config(t)
+		defer cleanup()
+
+		svc, err := New(config)
+		require.NoError(t, err)
+
+		svc.claimsStore = &mockstore.Store{GetReturn: []byte("invalid")}
+
+		rr := httptest.NewRecorder()
+		svc.getClaims(rr, newGetClaimsRequest("id"))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Contains(t, rr.Body.String(), "unmarshal claims session")
+	})
+}
+
+func TestGetUserInfo(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		config, cleanup := config(t)
+		defer cleanup()
+
+		config.FlowClaims = map[string]ClaimsMapping{"flow1": {"name.given": "firstName"}}
+
+		svc, err := New(config)
+		require.NoError(t, err)
+
+		svc.oidcClient = &mockOIDCClient{userInfoVal: map[string]interface{}{
+			"name": map[string]interface{}{"given": "Johnny"},
+		}}
+
+		id, err := svc.saveClaimsSession("flow1", map[string]interface{}{},
+			&oidcLogin{Provider: "default", SessionID: "oidc-session"})
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		svc.getUserInfo(rr, newGetClaimsRequest(id))
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.JSONEq(t, `{"firstName": "Johnny"}`, rr.Body.String())
+	})
+
+	t.Run("claims session without oidc login", func(t *testing.T) {
+		config, cleanup := config(t)
+		defer cleanup()
+
+		svc, err := New(config)
+		require.NoError(t, err)
+
+		id, err := svc.saveClaimsSession("", map[string]interface{}{}, nil)
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		svc.getUserInfo(rr, newGetClaimsRequest(id))
+		require.Equal(t, http.StatusNotFound, rr.Code)
+		require.Contains(t, rr.Body.String(), "claims session has no oidc login")
+
+		rr = httptest.NewRecorder()
+		svc.getUserInfo(rr, newGetClaimsRequest("invalid"))
+		require.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("oidc session errors", func(t *testing.T) {
+		config, cleanup := config(t)
+		defer cleanup()
+
+		svc, err := New(config)
+		require.NoError(t, err)
+
+		id, err := svc.saveClaimsSession("", map[string]interface{}{},
+			&oidcLogin{Provider: "default", SessionID: "oidc-session"})
+		require.NoError(t, err)
+
+		svc.oidcClient = &mockOIDCClient{userInfoErr: oidcclient.ErrSessionNotFound}
+
+		rr := httptest.NewRecorder()
+		svc.getUserInfo(rr, newGetClaimsRequest(id))
+		require.Equal(t, http.StatusNotFound, rr.Code)
+		require.Contains(t, rr.Body.String(), "oidc session not found or expired")
+
+		svc.oidcClient = &mockOIDCClient{userInfoErr: errors.New("userinfo error")}
+
+		rr = httptest.NewRecorder()
+		svc.getUserInfo(rr, newGetClaimsRequest(id))
+		require.Equal(t, http.StatusBadGateway, rr.Code)
+		require.Contains(t, rr.Body.String(), "userinfo error")
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		config, cleanup := config(t)
+		defer cleanup()
+
+		svc, err := New(config)
+		require.NoError(t, err)
+
+		svc.claimsStore = &mockstore.Store{ErrGet: errors.New("get error")}
+
+		rr := httptest.NewRecorder()
+		svc.getUserInfo(rr, newGetClaimsRequest("id"))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+	})
+}
+
+func TestSaveClaimsSession(t *testing.T) {
+	t.Run("store error", func(t *testing.T) {
+		config, cleanup := config(t)
+		defer cleanup()
+
+		svc, err := New(config)
+		require.NoError(t, err)
+
+		svc.claimsStore = &mockstore.Store{ErrPut: errors.New("put error")}
+
+		_, err = svc.saveClaimsSession("", map[string]interface{}{}, nil)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "put error")
+	})
+}
+
+func TestPresentationClaims(t *testing.T) {
+	t.Run("multiple credentials", func(t *testing.T) {
+		claims, err := presentationClaims([]byte(`{
+			"verifiableCredential": [
+				{"credentialSubject": {"givenName": "John", "address": {"city": "Toronto"}}},
+				{"credentialSubject": [{"familyName": "Smith"}]},
+				"eyJhbGciOiJub25lIn0.eyJpc3MiOiJkaWQ6ZXhhbXBsZTppc3N1ZXIiLCJ2YyI6eyJjcmVkZW50aWFsU3ViamVjdCI6eyJlbWFpbCI6ImpvaG5AZXhhbXBsZS5jb20ifX19."
+			]
+		}`))
+		require.NoError(t, err)
+		require.Equal(t, "John", claims["givenName"])
+		require.Equal(t, "Smith", claims["familyName"])
+		require.Equal(t, "john@example.com", claims["email"])
+
+		normalized := normalizeClaims(claims)
+		require.Equal(t, "Toronto", normalized["address.city"])
+	})
+
+	t.Run("single credential", func(t *testing.T) {
+		claims, err := presentationClaims([]byte(`{"verifiableCredential": {"credentialSubject": {"id": "did:ex:1"}}}`))
+		require.NoError(t, err)
+		require.Equal(t, "did:ex:1", claims["id"])
+	})
+
+	t.Run("no credentials", func(t *testing.T) {
+		claims, err := presentationClaims([]byte(`{}`))
+		require.NoError(t, err)
+		require.Empty(t, claims)
+	})
+
+	t.Run("invalid presentation", func(t *testing.T) {
+		_, err := presentationClaims([]byte("invalid"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unmarshal presentation")
+	})
+
+	t.Run("invalid credential", func(t *testing.T) {
+		_, err := presentationClaims([]byte(`{"verifiableCredential": [1]}`))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unmarshal credential")
+	})
+}
+
+func TestMapClaims(t *testing.T) {
+	claims := map[string]interface{}{"a": 1, "b.c": 2}
+
+	require.Equal(t, claims, mapClaims(claims, nil))
+	require.Equal(t, map[string]interface{}{"fieldC": 2},
+		mapClaims(claims, ClaimsMapping{"b.c": "fieldC", "d": "fieldD"}))
+}
+
+func newGetClaimsRequest(id string) *http.Request {
+	return mux.SetURLVars(httptest.NewRequest(http.MethodGet, claimsPath+"/"+id, nil), map[string]string{"id": id})
+}
@@ -15,4 +15,5 @@ type verifyPresentationRequest struct {
 	Domain    string          `json:"domain"`
 	Challenge string          `json:"challenge"`
 	VP        json.RawMessage `json:"vp"`
+	Flow      string          `json:"flow,omitempty"`
 }
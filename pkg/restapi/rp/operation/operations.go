@@ -22,11 +22,13 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/square/go-jose/v3"
 	"github.com/trustbloc/edge-core/pkg/log"
 	edgesvcops "github.com/trustbloc/edge-service/pkg/restapi/verifier/operation"
 
 	"github.com/trustbloc/sandbox/pkg/internal/common/support"
 	oidcclient "github.com/trustbloc/sandbox/pkg/restapi/internal/common/oidc"
+	"github.com/trustbloc/sandbox/pkg/trustregistry"
 )
 
 const (
@@ -67,10 +69,15 @@ type httpClient interface {
 }
 
 type oidcClient interface {
-	CreateOIDCRequest(state, scope string) (string, error)
-	HandleOIDCCallback(reqContext context.Context, code string) ([]byte, error)
+	CreateOIDCRequest(provider, state, scope string) (string, error)
+	HandleOIDCCallback(reqContext context.Context, state, code string) (*oidcclient.CallbackResult, error)
+	UserInfo(ctx context.Context, provider, sessionID string) (map[string]interface{}, error)
+	DeleteSession(provider, sessionID string) error
 }
 
+// OIDCProviderConfig defines a named oidc provider, selected with the provider parameter of the oidc request.
+type OIDCProviderConfig = oidcclient.ProviderSettings
+
 // Operation defines handlers
 type Operation struct {
 	handlers       []Handler
@@ -82,6 +89,13 @@ type Operation struct {
 	transientStore storage.Store
 	tlsConfig      *tls.Config
 	oidcClient     oidcClient
+	claimsStore    storage.Store
+	flowClaims     map[string]ClaimsMapping
+	// claimsSessionTTL is how long the verified claims are kept for the flow page
+	claimsSessionTTL time.Duration
+	trustRegistry    *trustregistry.Registry
+	// trustRegistryAdminToken is the bearer token required to update the trust registry
+	trustRegistryAdminToken string
 }
 
 // Config defines configuration for rp operations
@@ -95,14 +109,28 @@ type Config struct {
 	OIDCClientID           string
 	OIDCClientSecret       string
 	OIDCCallbackURL        string
+	OIDCUserInfo           bool
+	OIDCProviders          map[string]*OIDCProviderConfig
 	TransientStoreProvider storage.Provider
+	FlowClaims             map[string]ClaimsMapping
+	ClaimsSessionTTL       time.Duration
+	// TrustRegistryDocument is the JSON, YAML or JWS signed trust registry document loaded on start
+	TrustRegistryDocument []byte
+	// TrustRegistryKey if set, requires the trust registry documents to be signed with this key
+	TrustRegistryKey *jose.JSONWebKey
+	// TrustRegistryAdminToken if set, allows updating the trust registry with this bearer token. The registry
+	// can't be updated over REST without the token or the governance key.
+	TrustRegistryAdminToken string
+	// TrustRegistryAllowEmpty accepts trust registry documents without issuer policies (all the issuers trusted)
+	TrustRegistryAllowEmpty bool
 }
 
 // vc struct used to return vc data to html
 type vc struct {
-	Data     string `json:"data"`
-	Msg      string `json:"msg"`
-	FlowType string `json:"flowType"`
+	Data      string `json:"data"`
+	Msg       string `json:"msg"`
+	FlowType  string `json:"flowType"`
+	SessionID string `json:"sessionID"`
 }
 
 type createOIDCRequestResponse struct {
@@ -113,20 +141,40 @@ type createOIDCRequestResponse struct {
 // New returns rp operation instance
 func New(config *Config) (*Operation, error) {
 	svc := &Operation{
-		vpHTML:        config.VPHTML,
-		didCommVpHTML: config.DIDCOMMVPHTML,
-		vcsURL:        config.VCSURL,
-		client:        &http.Client{Transport: &http.Transport{TLSClientConfig: config.TLSConfig}},
-		requestTokens: config.RequestTokens,
-		tlsConfig:     config.TLSConfig,
+		vpHTML:           config.VPHTML,
+		didCommVpHTML:    config.DIDCOMMVPHTML,
+		vcsURL:           config.VCSURL,
+		client:           &http.Client{Transport: &http.Transport{TLSClientConfig: config.TLSConfig}},
+		requestTokens:    config.RequestTokens,
+		tlsConfig:        config.TLSConfig,
+		flowClaims:       config.FlowClaims,
+		claimsSessionTTL: config.ClaimsSessionTTL,
+
+		trustRegistryAdminToken: config.TrustRegistryAdminToken,
+	}
+
+	if svc.flowClaims == nil {
+		svc.flowClaims = map[string]ClaimsMapping{}
+	}
+
+	if svc.claimsSessionTTL <= 0 {
+		svc.claimsSessionTTL = defaultClaimsSessionTTL
 	}
 
 	var err error
 
-	svc.oidcClient, err = oidcclient.New(&oidcclient.Config{
-		OIDCClientID:     config.OIDCClientID,
-		OIDCClientSecret: config.OIDCClientSecret, OIDCCallbackURL: config.OIDCCallbackURL,
-		OIDCProviderURL: config.OIDCProviderURL, TLSConfig: config.TLSConfig,
+	// the tokens are kept as long as the claims sessions
+	svc.oidcClient, err = oidcclient.NewRegistry(&oidcclient.RegistryConfig{
+		TLSConfig:     config.TLSConfig,
+		CallbackURL:   config.OIDCCallbackURL,
+		StoreProvider: config.TransientStoreProvider,
+		SessionTTL:    svc.claimsSessionTTL,
+		Providers: oidcclient.ProviderConfigs(&oidcclient.ProviderSettings{
+			URL:          config.OIDCProviderURL,
+			ClientID:     config.OIDCClientID,
+			ClientSecret: config.OIDCClientSecret,
+			UserInfo:     config.OIDCUserInfo,
+		}, config.OIDCProviders),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create oidc client : %w", err)
@@ -137,6 +185,16 @@ func New(config *Config) (*Operation, error) {
 		return nil, fmt.Errorf("failed to create store : %w", err)
 	}
 
+	svc.claimsStore, err = config.TransientStoreProvider.OpenStore(claimsStoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create claims store : %w", err)
+	}
+
+	svc.trustRegistry, err = newTrustRegistry(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trust registry : %w", err)
+	}
+
 	svc.registerHandler()
 
 	return svc, nil
@@ -151,6 +209,14 @@ func (c *Operation) registerHandler() {
 		support.NewHTTPHandler(oauth2CallbackPath, http.MethodGet, c.handleOIDCCallback),
 
 		support.NewHTTPHandler(verifyPresentationPath, http.MethodPost, c.verifyPresentation),
+
+		// verified claims
+		support.NewHTTPHandler(getClaimsPath, http.MethodGet, c.getClaims),
+		support.NewHTTPHandler(getUserInfoPath, http.MethodGet, c.getUserInfo),
+
+		// trust registry
+		support.NewHTTPHandler(trustRegistryPath, http.MethodGet, c.getTrustRegistry),
+		support.NewHTTPHandler(trustRegistryPath, http.MethodPut, c.updateTrustRegistry),
 	}
 }
 
@@ -208,7 +274,37 @@ func (c *Operation) verifyPresentation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	c.writeResponse(w, http.StatusOK, []byte(""))
+	err = c.checkIssuers(req.Flow, req.VP)
+	if err != nil {
+		c.writeErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("failed to verify presentation: %s", err.Error()))
+
+		return
+	}
+
+	claims, err := presentationClaims(req.VP)
+	if err != nil {
+		c.writeErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("failed to extract claims: %s", err.Error()))
+
+		return
+	}
+
+	sessionID, err := c.saveClaimsSession(req.Flow, claims, nil)
+	if err != nil {
+		c.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to save claims session: %s", err.Error()))
+
+		return
+	}
+
+	respBytes, err := json.Marshal(&saveClaimsResponse{SessionID: sessionID})
+	if err != nil {
+		c.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to marshal response : %s", err))
+
+		return
+	}
+
+	w.Header().Set("content-type", httpContentTypeJSON)
+	c.writeResponse(w, http.StatusOK, respBytes)
 }
 
 // verifyVP
@@ -234,7 +330,7 @@ func (c *Operation) verifyVP(w http.ResponseWriter, r *http.Request) {
 		},
 	}
 
-	c.verify(req, inputData, c.vpHTML, w, r)
+	c.verify(req, inputData, r.Form.Get(flowQueryParam), c.vpHTML, w, r)
 }
 
 func (c *Operation) createOIDCRequest(w http.ResponseWriter, r *http.Request) {
@@ -255,7 +351,13 @@ func (c *Operation) createOIDCRequest(w http.ResponseWriter, r *http.Request) {
 	// TODO validate scope
 	state := uuid.New().String()
 
-	redirectURL, err := c.oidcClient.CreateOIDCRequest(state, scope)
+	redirectURL, err := c.oidcClient.CreateOIDCRequest(r.URL.Query().Get(oidcclient.ProviderQueryParam), state, scope)
+	if errors.Is(err, oidcclient.ErrUnknownProvider) {
+		c.writeErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("failed to create oidc request : %s", err))
+
+		return
+	}
+
 	if err != nil {
 		c.writeErrorResponse(w,
 			http.StatusInternalServerError, fmt.Sprintf("failed to create oidc request : %s", err))
@@ -297,7 +399,7 @@ func (c *Operation) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
 	state := r.URL.Query().Get("state")
 	if state == "" {
 		logger.Errorf("missing state")
-		c.didcommDemoResult(w, "missing state", "")
+		c.didcommDemoResult(w, "missing state", "", "")
 
 		return
 	}
@@ -305,7 +407,7 @@ func (c *Operation) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
 	code := r.URL.Query().Get("code")
 	if code == "" {
 		logger.Errorf("missing code")
-		c.didcommDemoResult(w, "missing code", "")
+		c.didcommDemoResult(w, "missing code", "", "")
 
 		return
 	}
@@ -322,30 +424,57 @@ func (c *Operation) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
 	_, err = c.transientStore.Get(state)
 	if errors.Is(err, storage.ErrDataNotFound) {
 		logger.Errorf("invalid state parameter")
-		c.didcommDemoResult(w, "invalid state parameter", "")
+		c.didcommDemoResult(w, "invalid state parameter", "", "")
 
 		return
 	}
 
 	if err != nil {
 		logger.Errorf("failed to query transient store for state : %s", err)
-		c.didcommDemoResult(w, fmt.Sprintf("failed to query transient store for state : %s", err), "")
+		c.didcommDemoResult(w, fmt.Sprintf("failed to query transient store for state : %s", err), "", "")
 
 		return
 	}
 
-	data, err := c.oidcClient.HandleOIDCCallback(r.Context(), code)
+	result, err := c.oidcClient.HandleOIDCCallback(r.Context(), state, code)
 	if err != nil {
 		logger.Errorf("failed to handle oidc callback : %s", err)
-		c.didcommDemoResult(w, fmt.Sprintf("failed to handle oidc callback: %s", err), "")
+		c.didcommDemoResult(w, fmt.Sprintf("failed to handle oidc callback: %s", err), "", "")
+
+		return
+	}
+
+	flowType := ""
+	if flowTypeCookie != nil {
+		flowType = flowTypeCookie.Value
+	}
+
+	data, err := json.Marshal(result.Claims)
+	if err != nil {
+		logger.Errorf("failed to marshal user data : %s", err)
+		c.didcommDemoResult(w, fmt.Sprintf("failed to marshal user data: %s", err), "", "")
+
+		return
+	}
+
+	var login *oidcLogin
+
+	if result.SessionID != "" {
+		login = &oidcLogin{Provider: result.Provider, SessionID: result.SessionID}
+	}
+
+	sessionID, err := c.saveClaimsSession(flowType, result.Claims, login)
+	if err != nil {
+		logger.Errorf("failed to save claims session : %s", err)
+		c.didcommDemoResult(w, fmt.Sprintf("failed to save claims session: %s", err), "", "")
 
 		return
 	}
 
-	c.didcommDemoResult(w, string(data), flowTypeCookie.Value)
+	c.didcommDemoResult(w, string(data), flowType, sessionID)
 }
 
-func (c *Operation) didcommDemoResult(w http.ResponseWriter, data, flowType string) {
+func (c *Operation) didcommDemoResult(w http.ResponseWriter, data, flowType, sessionID string) {
 	t, err := template.ParseFiles(c.didCommVpHTML)
 	if err != nil {
 		c.writeErrorResponse(w, http.StatusInternalServerError,
@@ -356,13 +485,13 @@ func (c *Operation) didcommDemoResult(w http.ResponseWriter, data, flowType stri
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
-	if err := t.Execute(w, vc{Data: data, FlowType: flowType}); err != nil {
+	if err := t.Execute(w, vc{Data: data, FlowType: flowType, SessionID: sessionID}); err != nil {
 		logger.Errorf(fmt.Sprintf("failed execute html template: %s", err.Error()))
 	}
 }
 
 // verify function verifies the input data and parse the response to provided template
-func (c *Operation) verify(verifyReq interface{}, inputData, htmlTemplate string,
+func (c *Operation) verify(verifyReq interface{}, inputData, flowType, htmlTemplate string,
 	w http.ResponseWriter, r *http.Request) {
 	t, err := template.ParseFiles(htmlTemplate)
 	if err != nil {
@@ -410,9 +539,39 @@ func (c *Operation) verify(verifyReq interface{}, inputData, htmlTemplate string
 		return
 	}
 
+	if err := c.checkIssuers(flowType, []byte(r.Form.Get(inputData))); err != nil {
+		logger.Warnf("failed to verify: %s", err)
+
+		if err := t.Execute(w, vc{Msg: "Oops verification is failed. " + err.Error()}); err != nil {
+			logger.Errorf(fmt.Sprintf("failed execute html template: %s", err.Error()))
+		}
+
+		return
+	}
+
+	// the business page reads the claims of the session, it can't go on without them
+	claims, err := presentationClaims([]byte(r.Form.Get(inputData)))
+	if err != nil {
+		logger.Warnf("failed to extract claims from the presentation : %s", err)
+
+		if err := t.Execute(w, vc{Msg: "Oops verification is failed. Failed to extract claims"}); err != nil {
+			logger.Errorf(fmt.Sprintf("failed execute html template: %s", err.Error()))
+		}
+
+		return
+	}
+
+	sessionID, err := c.saveClaimsSession(flowType, claims, nil)
+	if err != nil {
+		c.writeErrorResponse(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to save claims session: %s", err.Error()))
+
+		return
+	}
+
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
-	if err := t.Execute(w, vc{Msg: "Successfully verified", Data: r.Form.Get(inputData)}); err != nil {
+	if err := t.Execute(w, vc{Msg: "Successfully verified", Data: r.Form.Get(inputData), SessionID: sessionID}); err != nil {
 		logger.Errorf(fmt.Sprintf("failed execute html template: %s", err.Error()))
 	}
 }
@@ -485,6 +644,32 @@ func (c *Operation) writeErrorResponse(rw http.ResponseWriter, status int, msg s
 	}
 }
 
+func newTrustRegistry(config *Config) (*trustregistry.Registry, error) {
+	var opts []trustregistry.Option
+
+	if config.TrustRegistryKey != nil {
+		opts = append(opts, trustregistry.WithGovernanceKey(config.TrustRegistryKey))
+	}
+
+	if config.TrustRegistryAllowEmpty {
+		opts = append(opts, trustregistry.WithAllowEmpty())
+	}
+
+	registry, err := trustregistry.New(config.TransientStoreProvider, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(config.TrustRegistryDocument) > 0 {
+		err = registry.Load(config.TrustRegistryDocument)
+		if err != nil {
+			return nil, fmt.Errorf("load trust registry document : %w", err)
+		}
+	}
+
+	return registry, nil
+}
+
 func createStore(p storage.Provider) (storage.Store, error) {
 	return p.OpenStore(transientStoreName)
 }
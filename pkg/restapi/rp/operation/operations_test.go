@@ -24,6 +24,8 @@ import (
 	mockstore "github.com/hyperledger/aries-framework-go/component/storageutil/mock"
 	"github.com/stretchr/testify/require"
 	edgesvcops "github.com/trustbloc/edge-service/pkg/restapi/verifier/operation"
+
+	oidcclient "github.com/trustbloc/sandbox/pkg/restapi/internal/common/oidc"
 )
 
 const (
@@ -67,15 +69,16 @@ func TestNew(t *testing.T) {
 		svc, err := New(config)
 		require.NoError(t, err)
 		require.NotNil(t, svc)
-		require.Equal(t, 4, len(svc.GetRESTHandlers()))
+		require.Equal(t, 8, len(svc.GetRESTHandlers()))
 	})
 
-	t.Run("error if oidc provider is invalid", func(t *testing.T) {
+	t.Run("error if oidc providers are invalid", func(t *testing.T) {
 		config, cleanup := config(t)
 		defer cleanup()
-		config.OIDCProviderURL = "INVALID"
+		config.OIDCProviders = map[string]*OIDCProviderConfig{"": {URL: "http://bank.example.com"}}
 		_, err := New(config)
 		require.Error(t, err)
+		require.Contains(t, err.Error(), "missing oidc provider name")
 	})
 
 	t.Run("error if unable to open transient store", func(t *testing.T) {
@@ -207,6 +210,29 @@ func TestVerifyVP(t *testing.T) {
 		svc.verifyVP(rr, &http.Request{Form: m})
 		require.Equal(t, http.StatusOK, rr.Code)
 	})
+
+	t.Run("test claims session", func(t *testing.T) {
+		config, cleanup := config(t)
+		defer cleanup()
+		require.NoError(t, ioutil.WriteFile(config.VPHTML, []byte("{{.Msg}} {{.SessionID}}"), 0o600))
+		svc, err := New(config)
+		require.NoError(t, err)
+		svc.client = &mockHTTPClient{postValue: &http.Response{
+			StatusCode: http.StatusOK, Body: nil,
+		}}
+
+		rr := httptest.NewRecorder()
+		svc.verifyVP(rr, &http.Request{Form: map[string][]string{"vpDataInput": {validVP}}})
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		parts := strings.Fields(rr.Body.String())
+		require.Len(t, parts, 3)
+		require.Equal(t, []string{"Successfully", "verified"}, parts[:2])
+
+		session, err := svc.getClaimsSession(parts[2])
+		require.NoError(t, err)
+		require.Equal(t, "did:example:ebfeb1f712ebc6f1c276e12ec21", session.Claims["id"])
+	})
 }
 
 func TestCreateOIDCRequest(t *testing.T) {
@@ -228,6 +254,29 @@ func TestCreateOIDCRequest(t *testing.T) {
 		require.Equal(t, "request", result.Request)
 	})
 
+	t.Run("named provider", func(t *testing.T) {
+		config, cleanup := config(t)
+		defer cleanup()
+		config.OIDCProviders = map[string]*OIDCProviderConfig{"bank": {URL: config.OIDCProviderURL}}
+		svc, err := New(config)
+		require.NoError(t, err)
+
+		req := newCreateOIDCHTTPRequest("CreditCardStatement", "CreditCard")
+		req.URL.RawQuery += "&provider=bank"
+
+		w := httptest.NewRecorder()
+		svc.createOIDCRequest(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		req = newCreateOIDCHTTPRequest("CreditCardStatement", "CreditCard")
+		req.URL.RawQuery += "&provider=unknown"
+
+		w = httptest.NewRecorder()
+		svc.createOIDCRequest(w, req)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "unknown oidc provider")
+	})
+
 	t.Run("failed to create oidc request", func(t *testing.T) {
 		const scope = "CreditCardStatement"
 		const flowType = "CreditCard"
@@ -302,7 +351,11 @@ func TestHandleOIDCCallback(t *testing.T) {
 		o, err := New(config)
 		require.NoError(t, err)
 
-		o.oidcClient = &mockOIDCClient{}
+		o.oidcClient = &mockOIDCClient{handleOIDCCallbackVal: &oidcclient.CallbackResult{
+			Claims:    map[string]interface{}{"name": map[string]interface{}{"given": "John"}},
+			Provider:  "default",
+			SessionID: "oidc-session",
+		}}
 
 		result := httptest.NewRecorder()
 
@@ -372,15 +425,13 @@ func TestHandleOIDCCallback(t *testing.T) {
 		config, cleanup := config(t)
 		defer cleanup()
 
-		config.TransientStoreProvider = &mockstore.Provider{
-			OpenStoreReturn: &mockstore.Store{
-				GetReturn: []byte(state),
-				ErrGet:    errors.New("generic"),
-			},
-		}
-
 		svc, err := New(config)
 		require.NoError(t, err)
+
+		svc.transientStore = &mockstore.Store{
+			GetReturn: []byte(state),
+			ErrGet:    errors.New("generic"),
+		}
 		result := httptest.NewRecorder()
 		svc.handleOIDCCallback(result, newOIDCCallback(state, "code"))
 		require.Equal(t, http.StatusOK, result.Code)
@@ -407,7 +458,7 @@ func TestHandleOIDCCallback(t *testing.T) {
 		o, err := New(config)
 		require.NoError(t, err)
 
-		o.oidcClient = &mockOIDCClient{}
+		o.oidcClient = &mockOIDCClient{handleOIDCCallbackVal: &oidcclient.CallbackResult{}}
 
 		result := httptest.NewRecorder()
 		o.handleOIDCCallback(result, newOIDCCallback(state, code))
@@ -443,6 +494,14 @@ func TestVerifyDIDAuthHandler(t *testing.T) {
 
 		svc.verifyPresentation(rr, req)
 		require.Equal(t, http.StatusOK, rr.Code)
+
+		resp := &saveClaimsResponse{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+		require.NotEmpty(t, resp.SessionID)
+
+		session, err := svc.getClaimsSession(resp.SessionID)
+		require.NoError(t, err)
+		require.Equal(t, "did:example:ebfeb1f712ebc6f1c276e12ec21", session.Claims["id"])
 	})
 
 	t.Run("bad request", func(t *testing.T) {
@@ -539,15 +598,30 @@ func tmpFile(t *testing.T) (string, func()) {
 type mockOIDCClient struct {
 	createOIDCRequest     string
 	createOIDCRequestErr  error
+	handleOIDCCallbackVal *oidcclient.CallbackResult
 	handleOIDCCallbackErr error
+	userInfoVal           map[string]interface{}
+	userInfoErr           error
+	deletedSessions       []string
 }
 
-func (m *mockOIDCClient) CreateOIDCRequest(state, scope string) (string, error) {
+func (m *mockOIDCClient) CreateOIDCRequest(provider, state, scope string) (string, error) {
 	return m.createOIDCRequest, m.createOIDCRequestErr
 }
 
-func (m *mockOIDCClient) HandleOIDCCallback(reqContext context.Context, code string) ([]byte, error) {
-	return nil, m.handleOIDCCallbackErr
+func (m *mockOIDCClient) HandleOIDCCallback(
+	reqContext context.Context, state, code string) (*oidcclient.CallbackResult, error) {
+	return m.handleOIDCCallbackVal, m.handleOIDCCallbackErr
+}
+
+func (m *mockOIDCClient) UserInfo(ctx context.Context, provider, sessionID string) (map[string]interface{}, error) {
+	return m.userInfoVal, m.userInfoErr
+}
+
+func (m *mockOIDCClient) DeleteSession(provider, sessionID string) error {
+	m.deletedSessions = append(m.deletedSessions, sessionID)
+
+	return nil
 }
 
 func config(t *testing.T) (*Config, func()) {
@@ -0,0 +1,192 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/trustbloc/sandbox/pkg/trustregistry"
+)
+
+const (
+	trustRegistryPath = "/trustregistry"
+
+	bearerPrefix  = "Bearer "
+	jwtPartsCount = 3
+)
+
+type trustRegistryResponse struct {
+	Document *trustregistry.Document `json:"document"`
+	Signed   bool                    `json:"signed"`
+}
+
+func (c *Operation) getTrustRegistry(w http.ResponseWriter, _ *http.Request) {
+	doc, signed := c.trustRegistry.Document()
+
+	respBytes, err := json.Marshal(&trustRegistryResponse{Document: doc, Signed: signed})
+	if err != nil {
+		c.writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to marshal response : %s", err))
+
+		return
+	}
+
+	w.Header().Set("content-type", httpContentTypeJSON)
+	c.writeResponse(w, http.StatusOK, respBytes)
+}
+
+// updateTrustRegistry replaces the trust registry with the JSON, YAML or JWS signed document in the request body.
+// The caller must present the admin token unless the governance key is configured, in which case the signature
+// on the document authorizes the update.
+func (c *Operation) updateTrustRegistry(w http.ResponseWriter, r *http.Request) {
+	if !c.trustRegistryUpdateAllowed(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		c.writeErrorResponse(w, http.StatusUnauthorized, "trust registry updates require the admin token"+
+			" or a document signed with the governance key")
+
+		return
+	}
+
+	raw, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		c.writeErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("failed to read request : %s", err))
+
+		return
+	}
+
+	err = c.trustRegistry.Load(raw)
+	if err != nil {
+		c.writeErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("failed to update trust registry : %s", err))
+
+		return
+	}
+
+	c.getTrustRegistry(w, r)
+}
+
+func (c *Operation) trustRegistryUpdateAllowed(r *http.Request) bool {
+	if c.trustRegistryAdminToken != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), bearerPrefix)
+
+		if subtle.ConstantTimeCompare([]byte(token), []byte(c.trustRegistryAdminToken)) == 1 {
+			return true
+		}
+	}
+
+	return c.trustRegistry.GovernanceKey() != nil
+}
+
+// checkIssuers evaluates the issuers of all the credentials in the presentation against the trust registry.
+func (c *Operation) checkIssuers(flowType string, vp []byte) error {
+	presentation := struct {
+		VerifiableCredential json.RawMessage `json:"verifiableCredential"`
+	}{}
+
+	err := json.Unmarshal(vp, &presentation)
+	if err != nil {
+		return fmt.Errorf("unmarshal presentation : %w", err)
+	}
+
+	creds, err := presentationCredentials(presentation.VerifiableCredential)
+	if err != nil {
+		return err
+	}
+
+	if len(creds) == 0 {
+		return errors.New("presentation has no credentials")
+	}
+
+	if doc, _ := c.trustRegistry.Document(); len(doc.Issuers) == 0 {
+		return nil
+	}
+
+	for _, cred := range creds {
+		err = c.trustRegistry.CheckIssuer(flowType, &trustregistry.Credential{
+			Types:  stringOrArray(cred.Types),
+			Issuer: issuerID(cred.Issuer),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// jwtCredential decodes the vc claim of a credential in JWT form. The signature was verified with the presentation.
+func jwtCredential(jwt string) (*presentedCredential, error) {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != jwtPartsCount {
+		return nil, errors.New("invalid jwt credential")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("decode jwt credential : %w", err)
+	}
+
+	claims := struct {
+		Issuer     string              `json:"iss"`
+		Credential presentedCredential `json:"vc"`
+	}{}
+
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal jwt credential : %w", err)
+	}
+
+	cred := claims.Credential
+
+	// the registered claims take precedence over the ones in the vc claim (VC data model, JWT decoding)
+	if claims.Issuer != "" {
+		cred.Issuer = claims.Issuer
+	}
+
+	if issuerID(cred.Issuer) == "" {
+		return nil, errors.New("jwt credential has no issuer")
+	}
+
+	return &cred, nil
+}
+
+func issuerID(issuer interface{}) string {
+	switch i := issuer.(type) {
+	case string:
+		return i
+	case map[string]interface{}:
+		id, _ := i["id"].(string) // nolint: errcheck
+
+		return id
+	default:
+		return ""
+	}
+}
+
+func stringOrArray(v interface{}) []string {
+	switch s := v.(type) {
+	case string:
+		return []string{s}
+	case []interface{}:
+		values := make([]string, 0, len(s))
+
+		for _, value := range s {
+			if str, ok := value.(string); ok {
+				values = append(values, str)
+			}
+		}
+
+		return values
+	default:
+		return nil
+	}
+}
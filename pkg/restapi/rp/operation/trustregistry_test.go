@@ -0,0 +1,273 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package operation
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/square/go-jose/v3"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/sandbox/pkg/trustregistry"
+)
+
+const trustRegistryDoc = `{
+	"issuers": [{"credentialType": "VerifiableCredential", "dids": ["did:example:76e12ec712ebc6f1c221ebfeb1f"]}]
+}`
+
+func TestTrustRegistry(t *testing.T) {
+	t.Run("get and update", func(t *testing.T) {
+		config, cleanup := config(t)
+		defer cleanup()
+
+		config.TrustRegistryAdminToken = "admin-token"
+
+		svc, err := New(config)
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		svc.getTrustRegistry(rr, httptest.NewRequest(http.MethodGet, trustRegistryPath, nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		resp := &trustRegistryResponse{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+		require.Empty(t, resp.Document.Issuers)
+
+		rr = httptest.NewRecorder()
+		svc.updateTrustRegistry(rr, updateRequest(trustRegistryDoc, "admin-token"))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), resp))
+		require.Len(t, resp.Document.Issuers, 1)
+		require.False(t, resp.Signed)
+	})
+
+	t.Run("invalid document", func(t *testing.T) {
+		config, cleanup := config(t)
+		defer cleanup()
+
+		config.TrustRegistryAdminToken = "admin-token"
+
+		svc, err := New(config)
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		svc.updateTrustRegistry(rr, updateRequest(`{"issuers": [{}]}`, "admin-token"))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "failed to update trust registry")
+	})
+
+	t.Run("empty document", func(t *testing.T) {
+		config, cleanup := config(t)
+		defer cleanup()
+
+		config.TrustRegistryAdminToken = "admin-token"
+
+		svc, err := New(config)
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		svc.updateTrustRegistry(rr, updateRequest(`{"issuers": []}`, "admin-token"))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "no issuer policies")
+
+		config.TrustRegistryAllowEmpty = true
+
+		svc, err = New(config)
+		require.NoError(t, err)
+
+		rr = httptest.NewRecorder()
+		svc.updateTrustRegistry(rr, updateRequest(`{"issuers": []}`, "admin-token"))
+		require.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("unauthorized update", func(t *testing.T) {
+		config, cleanup := config(t)
+		defer cleanup()
+
+		svc, err := New(config)
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		svc.updateTrustRegistry(rr, updateRequest(trustRegistryDoc, ""))
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+
+		config.TrustRegistryAdminToken = "admin-token"
+
+		svc, err = New(config)
+		require.NoError(t, err)
+
+		rr = httptest.NewRecorder()
+		svc.updateTrustRegistry(rr, updateRequest(trustRegistryDoc, "other-token"))
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("governance key authorizes signed documents", func(t *testing.T) {
+		config, cleanup := config(t)
+		defer cleanup()
+
+		pub, _, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+
+		config.TrustRegistryKey = &jose.JSONWebKey{Key: pub}
+
+		svc, err := New(config)
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		svc.updateTrustRegistry(rr, updateRequest(trustRegistryDoc, ""))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "not signed")
+	})
+
+	t.Run("invalid document on start", func(t *testing.T) {
+		config, cleanup := config(t)
+		defer cleanup()
+
+		config.TrustRegistryDocument = []byte("{")
+
+		_, err := New(config)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to create trust registry")
+	})
+}
+
+func TestVerifyPresentationTrustedIssuer(t *testing.T) {
+	verify := func(t *testing.T, doc string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		config, cleanup := config(t)
+		defer cleanup()
+
+		config.TrustRegistryDocument = []byte(doc)
+
+		svc, err := New(config)
+		require.NoError(t, err)
+
+		svc.client = &mockHTTPClient{postValue: &http.Response{StatusCode: http.StatusOK}}
+
+		reqBytes, err := json.Marshal(&verifyPresentationRequest{
+			Domain:    uuid.NewString(),
+			Challenge: uuid.NewString(),
+			VP:        []byte(validVP),
+		})
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		svc.verifyPresentation(rr, httptest.NewRequest(http.MethodPost, verifyPresentationPath,
+			bytes.NewReader(reqBytes)))
+
+		return rr
+	}
+
+	t.Run("trusted issuer", func(t *testing.T) {
+		rr := verify(t, trustRegistryDoc)
+		require.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("untrusted issuer", func(t *testing.T) {
+		rr := verify(t, `{"issuers": [{"dids": ["did:example:other"]}]}`)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "untrusted issuer did:example:76e12ec712ebc6f1c221ebfeb1f")
+	})
+}
+
+func TestVerifyVPTrustedIssuer(t *testing.T) {
+	config, cleanup := config(t)
+	defer cleanup()
+
+	config.TrustRegistryDocument = []byte(`{"issuers": [{"flow": "flow1", "dids": ["did:example:other"]}]}`)
+
+	require.NoError(t, ioutil.WriteFile(config.VPHTML, []byte("{{.Msg}}"), 0o600))
+
+	svc, err := New(config)
+	require.NoError(t, err)
+
+	svc.client = &mockHTTPClient{postValue: &http.Response{StatusCode: http.StatusOK}}
+
+	form := url.Values{"vpDataInput": {validVP}, "flow": {"flow1"}}
+
+	req := httptest.NewRequest(http.MethodPost, verifyVPPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	svc.verifyVP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Body.String(), "untrusted issuer did:example:76e12ec712ebc6f1c221ebfeb1f")
+}
+
+func TestCheckIssuers(t *testing.T) {
+	config, cleanup := config(t)
+	defer cleanup()
+
+	config.TrustRegistryDocument = []byte(trustRegistryDoc)
+
+	svc, err := New(config)
+	require.NoError(t, err)
+
+	require.Error(t, svc.checkIssuers("", []byte("invalid")))
+	require.Error(t, svc.checkIssuers("", []byte(`{"verifiableCredential": [1]}`)))
+	require.Error(t, svc.checkIssuers("", []byte(`{"verifiableCredential": {"issuer": 1}}`)))
+	require.NoError(t, svc.checkIssuers("", []byte(`{"verifiableCredential": {"type": "VerifiableCredential",
+		"issuer": "did:example:76e12ec712ebc6f1c221ebfeb1f"}}`)))
+
+	t.Run("no credentials", func(t *testing.T) {
+		err := svc.checkIssuers("", []byte(`{"verifiableCredential": []}`))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "presentation has no credentials")
+
+		require.Error(t, svc.checkIssuers("", []byte(`{}`)))
+	})
+
+	t.Run("jwt credentials", func(t *testing.T) {
+		trusted := jwtVC(t, "did:example:76e12ec712ebc6f1c221ebfeb1f")
+		require.NoError(t, svc.checkIssuers("", []byte(`{"verifiableCredential": ["`+trusted+`"]}`)))
+
+		untrusted := jwtVC(t, "did:example:other")
+		err := svc.checkIssuers("", []byte(`{"verifiableCredential": ["`+trusted+`", "`+untrusted+`"]}`))
+		require.ErrorIs(t, err, trustregistry.ErrUntrustedIssuer)
+
+		require.Error(t, svc.checkIssuers("", []byte(`{"verifiableCredential": ["invalid"]}`)))
+		require.Error(t, svc.checkIssuers("", []byte(`{"verifiableCredential": ["a.!.c"]}`)))
+		require.Error(t, svc.checkIssuers("", []byte(`{"verifiableCredential": ["a.`+
+			base64.RawURLEncoding.EncodeToString([]byte("{"))+`.c"]}`)))
+		require.Error(t, svc.checkIssuers("", []byte(`{"verifiableCredential": ["`+jwtVC(t, "")+`"]}`)))
+	})
+}
+
+func updateRequest(doc, token string) *http.Request {
+	req := httptest.NewRequest(http.MethodPut, trustRegistryPath, strings.NewReader(doc))
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return req
+}
+
+func jwtVC(t *testing.T, issuer string) string {
+	t.Helper()
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"iss": issuer,
+		"vc":  map[string]interface{}{"type": []string{"VerifiableCredential"}, "credentialSubject": map[string]string{}},
+	})
+	require.NoError(t, err)
+
+	return "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString(payload) + "."
+}
@@ -0,0 +1,172 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/square/go-jose/v3"
+	"github.com/square/go-jose/v3/jwt"
+)
+
+// Client authentication methods of the authorization server endpoints, see RFC 6749 and OpenID Connect Core 1.0.
+const (
+	ClientSecretBasic = "client_secret_basic"
+	ClientSecretPost  = "client_secret_post"
+	PrivateKeyJWT     = "private_key_jwt"
+)
+
+const (
+	clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	clientAssertionTTL  = 5 * time.Minute
+)
+
+// ClientAuth authenticates the client in the requests to the authorization server endpoints.
+type ClientAuth struct {
+	Method       string
+	ClientID     string
+	ClientSecret string
+	// PrivateKey signs the client assertions of the private_key_jwt method
+	PrivateKey *jose.JSONWebKey
+}
+
+// Validate checks that the client authentication has the credentials of its method.
+func (a *ClientAuth) Validate() error {
+	if a.ClientID == "" {
+		return errors.New("missing client id")
+	}
+
+	switch a.Method {
+	case ClientSecretBasic, ClientSecretPost:
+		if a.ClientSecret == "" {
+			return fmt.Errorf("missing client secret for %s", a.Method)
+		}
+	case PrivateKeyJWT:
+		if a.PrivateKey == nil {
+			return fmt.Errorf("missing private key for %s", a.Method)
+		}
+	default:
+		return fmt.Errorf("unsupported client authentication method %s", a.Method)
+	}
+
+	return nil
+}
+
+// NewRequest creates a form POST request to the endpoint authenticated with the method of the client
+// authentication. The request is not authenticated if the client authentication is nil.
+func (a *ClientAuth) NewRequest(endpoint string, form url.Values) (*http.Request, error) {
+	values := url.Values{}
+
+	for k, v := range form {
+		values[k] = v
+	}
+
+	var basicAuth bool
+
+	if a != nil {
+		switch a.Method {
+		case ClientSecretBasic:
+			basicAuth = true
+		case ClientSecretPost:
+			values.Set("client_id", a.ClientID)
+			values.Set("client_secret", a.ClientSecret)
+		case PrivateKeyJWT:
+			assertion, err := a.clientAssertion(endpoint)
+			if err != nil {
+				return nil, err
+			}
+
+			values.Set("client_id", a.ClientID)
+			values.Set("client_assertion_type", clientAssertionType)
+			values.Set("client_assertion", assertion)
+		default:
+			return nil, fmt.Errorf("unsupported client authentication method %s", a.Method)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request : %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if basicAuth {
+		// the client id and secret are form encoded before they are used as basic auth credentials, see RFC 6749
+		req.SetBasicAuth(url.QueryEscape(a.ClientID), url.QueryEscape(a.ClientSecret))
+	}
+
+	return req, nil
+}
+
+// clientAssertion returns a signed JWT for the endpoint, see RFC 7523.
+func (a *ClientAuth) clientAssertion(endpoint string) (string, error) {
+	alg, err := signatureAlgorithm(a.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+
+	opts := &jose.SignerOptions{}
+	if a.PrivateKey.KeyID != "" {
+		opts = opts.WithHeader(jose.HeaderKey("kid"), a.PrivateKey.KeyID)
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: a.PrivateKey}, opts.WithType("JWT"))
+	if err != nil {
+		return "", fmt.Errorf("failed to create client assertion signer : %w", err)
+	}
+
+	now := time.Now()
+
+	assertion, err := jwt.Signed(signer).Claims(&jwt.Claims{
+		Issuer:   a.ClientID,
+		Subject:  a.ClientID,
+		Audience: jwt.Audience{endpoint},
+		ID:       uuid.NewString(),
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(now.Add(clientAssertionTTL)),
+	}).CompactSerialize()
+	if err != nil {
+		return "", fmt.Errorf("failed to sign client assertion : %w", err)
+	}
+
+	return assertion, nil
+}
+
+func signatureAlgorithm(key *jose.JSONWebKey) (jose.SignatureAlgorithm, error) {
+	if key.Algorithm != "" {
+		return jose.SignatureAlgorithm(key.Algorithm), nil
+	}
+
+	switch k := key.Key.(type) {
+	case *rsa.PrivateKey:
+		return jose.RS256, nil
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return jose.ES256, nil
+		case elliptic.P384():
+			return jose.ES384, nil
+		case elliptic.P521():
+			return jose.ES512, nil
+		}
+	case ed25519.PrivateKey:
+		return jose.EdDSA, nil
+	}
+
+	return "", errors.New("unsupported client assertion key")
+}
@@ -0,0 +1,137 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/url"
+	"testing"
+
+	"github.com/square/go-jose/v3"
+	"github.com/square/go-jose/v3/jwt"
+	"github.com/stretchr/testify/require"
+)
+
+const endpoint = "https://hydra.example.com/oauth2/introspect"
+
+func TestClientAuth_NewRequest(t *testing.T) {
+	form := url.Values{"token": {"t1"}}
+
+	t.Run("no client authentication", func(t *testing.T) {
+		var auth *ClientAuth
+
+		req, err := auth.NewRequest(endpoint, form)
+		require.NoError(t, err)
+		require.NoError(t, req.ParseForm())
+		require.Equal(t, "t1", req.PostForm.Get("token"))
+		require.Empty(t, req.Header.Get("Authorization"))
+	})
+
+	t.Run("client_secret_basic", func(t *testing.T) {
+		auth := &ClientAuth{Method: ClientSecretBasic, ClientID: "client:1", ClientSecret: "secret 1"}
+
+		req, err := auth.NewRequest(endpoint, form)
+		require.NoError(t, err)
+		require.Equal(t, "application/x-www-form-urlencoded", req.Header.Get("Content-Type"))
+
+		id, secret, ok := req.BasicAuth()
+		require.True(t, ok)
+		require.Equal(t, "client%3A1", id)
+		require.Equal(t, "secret+1", secret)
+	})
+
+	t.Run("client_secret_post", func(t *testing.T) {
+		auth := &ClientAuth{Method: ClientSecretPost, ClientID: "client", ClientSecret: "secret"}
+
+		req, err := auth.NewRequest(endpoint, form)
+		require.NoError(t, err)
+		require.NoError(t, req.ParseForm())
+		require.Equal(t, "client", req.PostForm.Get("client_id"))
+		require.Equal(t, "secret", req.PostForm.Get("client_secret"))
+		require.Equal(t, "t1", req.PostForm.Get("token"))
+		require.Empty(t, form.Get("client_id"))
+	})
+
+	t.Run("private_key_jwt", func(t *testing.T) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+
+		auth := &ClientAuth{Method: PrivateKeyJWT, ClientID: "client", PrivateKey: &jose.JSONWebKey{Key: key, KeyID: "k1"}}
+
+		req, err := auth.NewRequest(endpoint, form)
+		require.NoError(t, err)
+		require.NoError(t, req.ParseForm())
+		require.Equal(t, clientAssertionType, req.PostForm.Get("client_assertion_type"))
+
+		assertion, err := jwt.ParseSigned(req.PostForm.Get("client_assertion"))
+		require.NoError(t, err)
+		require.Equal(t, "k1", assertion.Headers[0].KeyID)
+
+		claims := &jwt.Claims{}
+		require.NoError(t, assertion.Claims(&key.PublicKey, claims))
+		require.NoError(t, claims.Validate(jwt.Expected{Issuer: "client", Subject: "client", Audience: jwt.Audience{endpoint}}))
+		require.NotEmpty(t, claims.ID)
+	})
+
+	t.Run("unsupported method", func(t *testing.T) {
+		_, err := (&ClientAuth{Method: "tls_client_auth"}).NewRequest(endpoint, form)
+		require.EqualError(t, err, "unsupported client authentication method tls_client_auth")
+
+		_, err = (&ClientAuth{Method: PrivateKeyJWT, PrivateKey: &jose.JSONWebKey{Key: []byte("key")}}).
+			NewRequest(endpoint, form)
+		require.EqualError(t, err, "unsupported client assertion key")
+	})
+}
+
+func TestClientAuth_Validate(t *testing.T) {
+	require.NoError(t, (&ClientAuth{Method: ClientSecretBasic, ClientID: "c", ClientSecret: "s"}).Validate())
+	require.NoError(t, (&ClientAuth{Method: PrivateKeyJWT, ClientID: "c", PrivateKey: &jose.JSONWebKey{}}).Validate())
+
+	require.EqualError(t, (&ClientAuth{Method: ClientSecretBasic}).Validate(), "missing client id")
+	require.EqualError(t, (&ClientAuth{Method: ClientSecretPost, ClientID: "c"}).Validate(),
+		"missing client secret for client_secret_post")
+	require.EqualError(t, (&ClientAuth{Method: PrivateKeyJWT, ClientID: "c"}).Validate(),
+		"missing private key for private_key_jwt")
+	require.EqualError(t, (&ClientAuth{Method: "none", ClientID: "c"}).Validate(),
+		"unsupported client authentication method none")
+}
+
+func TestSignatureAlgorithm(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		key *jose.JSONWebKey
+		alg jose.SignatureAlgorithm
+	}{
+		{key: &jose.JSONWebKey{Key: rsaKey}, alg: jose.RS256},
+		{key: &jose.JSONWebKey{Key: rsaKey, Algorithm: "PS256"}, alg: jose.PS256},
+		{key: &jose.JSONWebKey{Key: edKey}, alg: jose.EdDSA},
+		{key: &jose.JSONWebKey{Key: newECKey(t, elliptic.P384())}, alg: jose.ES384},
+		{key: &jose.JSONWebKey{Key: newECKey(t, elliptic.P521())}, alg: jose.ES512},
+	} {
+		alg, err := signatureAlgorithm(tc.key)
+		require.NoError(t, err)
+		require.Equal(t, tc.alg, alg)
+	}
+}
+
+func newECKey(t *testing.T, curve elliptic.Curve) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	require.NoError(t, err)
+
+	return key
+}
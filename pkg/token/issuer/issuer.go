@@ -9,14 +9,24 @@ package issuer
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"crypto/tls"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
 	"github.com/trustbloc/edge-core/pkg/log"
 	"golang.org/x/oauth2"
+
+	"github.com/trustbloc/sandbox/pkg/token"
+	"github.com/trustbloc/sandbox/pkg/token/revoker"
 )
 
 const (
@@ -24,8 +34,22 @@ const (
 	stateFormKey    = "state"
 	codeFormKey     = "code"
 
-	defaultCookieExpiry = 20
-	stateValueLength    = 16 // minutes
+	loginStoreName = "token-issuer-login"
+
+	codeChallengeParam       = "code_challenge"
+	codeChallengeMethodParam = "code_challenge_method"
+	codeChallengeMethodS256  = "S256"
+	codeVerifierParam        = "code_verifier"
+	nonceParam               = "nonce"
+	idTokenKey               = "id_token"
+
+	stateLength = 16
+	// RFC 7636 requires 43 to 128 characters, 32 random bytes are encoded to 43 characters
+	codeVerifierLength = 32
+	nonceLength        = 16
+	jwtPartsCount      = 3
+
+	defaultLoginTTL = 10 * time.Minute
 )
 
 var logger = log.New("sandbox-token-issuer")
@@ -40,51 +64,197 @@ func WithTLSConfig(tlsConfig *tls.Config) Option {
 	}
 }
 
+// WithStoreProvider option sets the provider of the store of the pending logins, an in-memory store is used
+// by default
+func WithStoreProvider(provider storage.Provider) Option {
+	return func(opts *Issuer) {
+		opts.storeProvider = provider
+	}
+}
+
+// WithLoginTTL option sets how long a login can be completed after the auth code URL is created,
+// defaults to 10 minutes
+func WithLoginTTL(ttl time.Duration) Option {
+	return func(opts *Issuer) {
+		opts.loginTTL = ttl
+	}
+}
+
+// WithRevocationURL option enables the revocation of the issued tokens with the RFC 7009 endpoint
+func WithRevocationURL(revocationURL string) Option {
+	return func(opts *Issuer) {
+		opts.revocationURL = revocationURL
+	}
+}
+
+// WithClientAuth option sets the client authentication of the revocation requests,
+// client_secret_basic with the oauth2 client credentials is used by default
+func WithClientAuth(auth *token.ClientAuth) Option {
+	return func(opts *Issuer) {
+		opts.clientAuth = auth
+	}
+}
+
 // Issuer implements token issuing
 type Issuer struct {
-	oauthConfig *oauth2.Config
-	tlsConfig   *tls.Config
+	oauthConfig   *oauth2.Config
+	tlsConfig     *tls.Config
+	revocationURL string
+	clientAuth    *token.ClientAuth
+	revoker       *revoker.Revoker
+	storeProvider storage.Provider
+	store         storage.Store
+	loginTTL      time.Duration
+}
+
+// LoginRequest is the context of a login that is kept by the issuer until the callback.
+type LoginRequest struct {
+	// Scope overrides the scopes of the oauth2 config if set
+	Scope []string
+	// RedirectURL is where the user is redirected after the login
+	RedirectURL string
+	// Data is application data of the login
+	Data map[string]string
+}
+
+// LoginContext is the context of a completed login.
+type LoginContext struct {
+	Token       *oauth2.Token
+	Scope       []string
+	RedirectURL string
+	Data        map[string]string
+}
+
+type pendingLogin struct {
+	Scope        []string          `json:"scope,omitempty"`
+	RedirectURL  string            `json:"redirectURL,omitempty"`
+	Data         map[string]string `json:"data,omitempty"`
+	CodeVerifier string            `json:"codeVerifier"`
+	Nonce        string            `json:"nonce"`
+	ExpiresAt    time.Time         `json:"expiresAt"`
 }
 
 // New creates new token issuer
-func New(oauthConfig *oauth2.Config, opts ...Option) *Issuer {
-	issuer := &Issuer{oauthConfig: oauthConfig}
+func New(oauthConfig *oauth2.Config, opts ...Option) (*Issuer, error) {
+	issuer := &Issuer{oauthConfig: oauthConfig, storeProvider: mem.NewProvider(), loginTTL: defaultLoginTTL}
 
 	for _, opt := range opts {
 		opt(issuer)
 	}
 
-	return issuer
+	store, err := issuer.storeProvider.OpenStore(loginStoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open token issuer store : %w", err)
+	}
+
+	issuer.store = store
+
+	if issuer.revocationURL != "" {
+		auth := issuer.clientAuth
+		if auth == nil {
+			auth = &token.ClientAuth{
+				Method:       token.ClientSecretBasic,
+				ClientID:     oauthConfig.ClientID,
+				ClientSecret: oauthConfig.ClientSecret,
+			}
+		}
+
+		issuer.revoker = revoker.New(issuer.revocationURL,
+			revoker.WithTLSConfig(issuer.tlsConfig), revoker.WithClientAuth(auth))
+	}
+
+	return issuer, nil
 }
 
-// AuthCodeURL returns a URL to OAuth 2.0 provider's consent page
-func (i *Issuer) AuthCodeURL(w http.ResponseWriter) string {
-	// AuthCodeURL receives state that is a token to protect the user from CSRF attacks
-	oauthState := generateStateOauthCookie(w)
+// AuthCodeURL returns a URL to OAuth 2.0 provider's consent page. The login request is saved with a PKCE
+// code verifier and a nonce for an opaque state that protects the user from CSRF attacks. The state is also
+// set in a cookie, so that the login can only be completed by the browser that started it.
+func (i *Issuer) AuthCodeURL(w http.ResponseWriter, req *LoginRequest) (string, error) {
+	state, err := randomString(stateLength)
+	if err != nil {
+		return "", fmt.Errorf("failed to create state : %w", err)
+	}
+
+	login := &pendingLogin{
+		Scope:       req.Scope,
+		RedirectURL: req.RedirectURL,
+		Data:        req.Data,
+		ExpiresAt:   time.Now().Add(i.loginTTL),
+	}
+
+	login.CodeVerifier, err = randomString(codeVerifierLength)
+	if err != nil {
+		return "", fmt.Errorf("failed to create code verifier : %w", err)
+	}
+
+	login.Nonce, err = randomString(nonceLength)
+	if err != nil {
+		return "", fmt.Errorf("failed to create nonce : %w", err)
+	}
+
+	loginBytes, err := json.Marshal(login)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal login : %w", err)
+	}
+
+	err = i.store.Put(state, loginBytes)
+	if err != nil {
+		return "", fmt.Errorf("failed to save login : %w", err)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthCookieName,
+		Value:    state,
+		Path:     "/",
+		Expires:  login.ExpiresAt,
+		HttpOnly: true,
+		// the cookie must be sent with the top level redirect from the provider
+		SameSite: http.SameSiteLaxMode,
+	})
 
-	return i.oauthConfig.AuthCodeURL(oauthState)
+	challenge := sha256.Sum256([]byte(login.CodeVerifier))
+
+	return i.config(req.Scope).AuthCodeURL(state,
+		oauth2.SetAuthURLParam(codeChallengeParam, base64.RawURLEncoding.EncodeToString(challenge[:])),
+		oauth2.SetAuthURLParam(codeChallengeMethodParam, codeChallengeMethodS256),
+		oauth2.SetAuthURLParam(nonceParam, login.Nonce),
+	), nil
 }
 
-// Exchange will exchange auth code for auth token
-func (i *Issuer) Exchange(r *http.Request) (*oauth2.Token, error) {
-	// read oauthState from cookie
-	oauthState, err := r.Cookie(oauthCookieName)
+// Exchange will exchange auth code for auth token with the code verifier of the login saved for the state.
+// The state must match the state cookie of the browser, and the nonce of the ID token, if any, must match
+// the nonce of the login. The login of the state can be completed only once.
+func (i *Issuer) Exchange(r *http.Request) (*LoginContext, error) {
+	state := r.FormValue(stateFormKey)
+
+	err := checkStateCookie(r, state)
 	if err != nil {
 		return nil, err
 	}
 
-	// validate that oauth cookie state matches the the state query parameter on your redirect callback
-	if r.FormValue(stateFormKey) != oauthState.Value {
-		return nil, errors.New("invalid oauth state")
+	login, err := i.pendingLogin(state)
+	if err != nil {
+		return nil, err
 	}
 
 	// exchange code for token
-	token, err := i.oauthConfig.Exchange(i.createContext(), r.FormValue(codeFormKey))
+	t, err := i.config(login.Scope).Exchange(i.createContext(), r.FormValue(codeFormKey),
+		oauth2.SetAuthURLParam(codeVerifierParam, login.CodeVerifier))
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkNonce(t, login.Nonce)
 	if err != nil {
 		return nil, err
 	}
 
-	return token, nil
+	return &LoginContext{
+		Token:       t,
+		Scope:       login.Scope,
+		RedirectURL: login.RedirectURL,
+		Data:        login.Data,
+	}, nil
 }
 
 // Client returns an HTTP client using the provided token.
@@ -93,23 +263,134 @@ func (i *Issuer) Client(t *oauth2.Token) *http.Client {
 	return oauth2.NewClient(ctx, i.oauthConfig.TokenSource(ctx, t))
 }
 
-func generateStateOauthCookie(w http.ResponseWriter) string {
-	// generate random bytes for state value
-	b := make([]byte, stateValueLength)
+// Revoke revokes the refresh token and the access token. Tokens are not revoked if the revocation URL
+// is not configured.
+func (i *Issuer) Revoke(t *oauth2.Token) error {
+	if i.revoker == nil {
+		logger.Debugf("token revocation url is not configured")
 
-	_, err := rand.Read(b)
+		return nil
+	}
+
+	// revoking the refresh token revokes the access tokens issued with it on most servers
+	if t.RefreshToken != "" {
+		err := i.revoker.Revoke(t.RefreshToken, revoker.RefreshTokenHint)
+		if err != nil {
+			return err
+		}
+	}
+
+	return i.revoker.Revoke(t.AccessToken, revoker.AccessTokenHint)
+}
+
+// checkStateCookie checks that the state is the one set in the cookie of the browser that started the login,
+// so that the callback URL of another login can't be completed in the browser (login CSRF).
+func checkStateCookie(r *http.Request, state string) error {
+	if state == "" {
+		return errors.New("missing oauth state")
+	}
+
+	cookie, err := r.Cookie(oauthCookieName)
 	if err != nil {
-		logger.Errorf(err.Error())
+		return errors.New("missing oauth state cookie")
 	}
 
-	state := base64.URLEncoding.EncodeToString(b)
-	cookie := http.Cookie{Name: oauthCookieName,
-		Value:   state,
-		Expires: time.Now().Add(defaultCookieExpiry * time.Minute)}
+	if subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		return errors.New("invalid oauth state")
+	}
+
+	return nil
+}
+
+// checkNonce checks the nonce of the ID token returned with an openid scope. The ID token is received directly
+// from the token endpoint, its signature isn't checked here (OpenID Connect Core 3.1.3.7).
+func checkNonce(t *oauth2.Token, nonce string) error {
+	idToken, ok := t.Extra(idTokenKey).(string)
+	if !ok || idToken == "" {
+		return nil
+	}
+
+	parts := strings.Split(idToken, ".")
+	if len(parts) != jwtPartsCount {
+		return errors.New("invalid id_token")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("failed to decode id_token : %w", err)
+	}
+
+	claims := struct {
+		Nonce string `json:"nonce"`
+	}{}
+
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal id_token : %w", err)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return errors.New("invalid id_token nonce")
+	}
+
+	return nil
+}
 
-	http.SetCookie(w, &cookie)
+// pendingLogin returns and deletes the login saved for the state.
+func (i *Issuer) pendingLogin(state string) (*pendingLogin, error) {
+	if state == "" {
+		return nil, errors.New("missing oauth state")
+	}
+
+	loginBytes, err := i.store.Get(state)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		return nil, errors.New("invalid oauth state")
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get login : %w", err)
+	}
+
+	err = i.store.Delete(state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete login : %w", err)
+	}
+
+	login := &pendingLogin{}
+
+	err = json.Unmarshal(loginBytes, login)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal login : %w", err)
+	}
+
+	if time.Now().After(login.ExpiresAt) {
+		return nil, errors.New("expired oauth state")
+	}
+
+	return login, nil
+}
+
+// config returns the oauth2 config with the scope of the login.
+func (i *Issuer) config(scope []string) *oauth2.Config {
+	if len(scope) == 0 {
+		return i.oauthConfig
+	}
+
+	config := *i.oauthConfig
+	config.Scopes = scope
+
+	return &config
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
 
-	return state
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
 
 func (i *Issuer) createContext() context.Context {
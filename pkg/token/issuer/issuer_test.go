@@ -7,99 +7,349 @@ SPDX-License-Identifier: Apache-2.0
 package issuer
 
 import (
-	"bytes"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/base64"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
 
+	mockstorage "github.com/hyperledger/aries-framework-go/component/storageutil/mock"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/oauth2"
+
+	"github.com/trustbloc/sandbox/pkg/token"
 )
 
 func TestOpts(t *testing.T) {
-	tokenIssuer := New(nil, WithTLSConfig(&tls.Config{ServerName: "name", MinVersion: tls.VersionTLS12}))
+	tokenIssuer, err := New(nil, WithTLSConfig(&tls.Config{ServerName: "name", MinVersion: tls.VersionTLS12}))
+	require.NoError(t, err)
 	require.NotNil(t, tokenIssuer.tlsConfig)
 }
 
+func TestNew(t *testing.T) {
+	_, err := New(&oauth2.Config{}, WithStoreProvider(&mockstorage.Provider{
+		ErrOpenStore: errors.New("open error"),
+	}))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed to open token issuer store : open error")
+}
+
 func TestIssuer_AuthCodeURL(t *testing.T) {
-	tokenIssuer := New(&oauth2.Config{})
+	t.Run("saves the login for the state", func(t *testing.T) {
+		tokenIssuer, err := New(&oauth2.Config{Scopes: []string{"default"}})
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+
+		u, err := tokenIssuer.AuthCodeURL(w, &LoginRequest{Scope: []string{"openid", "email"}, RedirectURL: "/callback"})
+		require.NoError(t, err)
+
+		authURL, err := url.Parse(u)
+		require.NoError(t, err)
+		require.Equal(t, "openid email", authURL.Query().Get("scope"))
+		require.Equal(t, "S256", authURL.Query().Get(codeChallengeMethodParam))
+		require.NotEmpty(t, authURL.Query().Get(codeChallengeParam))
+
+		cookies := w.Result().Cookies()
+		require.Len(t, cookies, 1)
+		require.Equal(t, oauthCookieName, cookies[0].Name)
+		require.Equal(t, authURL.Query().Get(stateFormKey), cookies[0].Value)
+		require.True(t, cookies[0].HttpOnly)
 
-	w := httptest.NewRecorder()
-	u := tokenIssuer.AuthCodeURL(w)
-	require.NotEmpty(t, u)
+		login, err := tokenIssuer.pendingLogin(authURL.Query().Get(stateFormKey))
+		require.NoError(t, err)
+		require.Equal(t, "/callback", login.RedirectURL)
+		require.NotEmpty(t, login.Nonce)
+		require.Equal(t, login.Nonce, authURL.Query().Get(nonceParam))
+
+		challenge := sha256.Sum256([]byte(login.CodeVerifier))
+		require.Equal(t, base64.RawURLEncoding.EncodeToString(challenge[:]), authURL.Query().Get(codeChallengeParam))
+	})
+
+	t.Run("default scope", func(t *testing.T) {
+		tokenIssuer, err := New(&oauth2.Config{Scopes: []string{"default"}})
+		require.NoError(t, err)
+
+		u, err := tokenIssuer.AuthCodeURL(httptest.NewRecorder(), &LoginRequest{})
+		require.NoError(t, err)
+		require.Contains(t, u, "scope=default")
+	})
+
+	t.Run("save error", func(t *testing.T) {
+		tokenIssuer, err := New(&oauth2.Config{}, WithStoreProvider(&mockstorage.Provider{
+			OpenStoreReturn: &mockstorage.Store{ErrPut: errors.New("put error")},
+		}))
+		require.NoError(t, err)
+
+		_, err = tokenIssuer.AuthCodeURL(httptest.NewRecorder(), &LoginRequest{})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to save login : put error")
+	})
 }
 
 func TestIssuer_Client(t *testing.T) {
-	tokenIssuer := New(&oauth2.Config{})
+	tokenIssuer, err := New(&oauth2.Config{})
+	require.NoError(t, err)
 
 	c := tokenIssuer.Client(&oauth2.Token{})
 	require.NotNil(t, c)
 }
 
-func TestIssuer_Exchange_NoStateCookie(t *testing.T) {
-	req, err := getRequest(nil, nil)
-	require.NoError(t, err)
+func TestIssuer_Exchange(t *testing.T) {
+	t.Run("returns the login context", func(t *testing.T) {
+		var codeVerifier string
 
-	tokenIssuer := New(&oauth2.Config{})
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "code", r.FormValue(codeFormKey))
+			codeVerifier = r.FormValue(codeVerifierParam)
 
-	token, err := tokenIssuer.Exchange(req)
-	require.Error(t, err)
-	require.Contains(t, err.Error(), "http: named cookie not present")
-	require.Nil(t, token)
-}
+			w.Header().Set("Content-Type", "application/json")
+			_, err := w.Write([]byte(`{"access_token":"token","token_type":"Bearer"}`))
+			require.NoError(t, err)
+		}))
+		defer srv.Close()
 
-func TestIssuer_Exchange_NoStateValue(t *testing.T) {
-	oauthCookie := &http.Cookie{
-		Name:  oauthCookieName,
-		Value: "value",
-	}
+		tokenIssuer, err := New(&oauth2.Config{Endpoint: oauth2.Endpoint{TokenURL: srv.URL}})
+		require.NoError(t, err)
 
-	req, err := getRequest([]*http.Cookie{oauthCookie}, nil)
-	require.NoError(t, err)
+		u, err := tokenIssuer.AuthCodeURL(httptest.NewRecorder(), &LoginRequest{
+			Scope: []string{"email"}, RedirectURL: "/callback", Data: map[string]string{"profile": "p1"},
+		})
+		require.NoError(t, err)
 
-	tokenIssuer := New(&oauth2.Config{})
+		authURL, err := url.Parse(u)
+		require.NoError(t, err)
 
-	token, err := tokenIssuer.Exchange(req)
-	require.Error(t, err)
-	require.Contains(t, err.Error(), "invalid oauth state")
-	require.Nil(t, token)
-}
+		state := authURL.Query().Get(stateFormKey)
 
-func TestIssuer_Exchange_GetTokenError(t *testing.T) {
-	const oauthCookieValue = "some value"
+		login, err := tokenIssuer.Exchange(getRequest(state, "code"))
+		require.NoError(t, err)
+		require.Equal(t, "token", login.Token.AccessToken)
+		require.Equal(t, []string{"email"}, login.Scope)
+		require.Equal(t, "/callback", login.RedirectURL)
+		require.Equal(t, "p1", login.Data["profile"])
 
-	cookie := &http.Cookie{
-		Name:  oauthCookieName,
-		Value: oauthCookieValue,
-	}
-	cookies := []*http.Cookie{cookie}
+		challenge := sha256.Sum256([]byte(codeVerifier))
+		require.Equal(t, base64.RawURLEncoding.EncodeToString(challenge[:]), authURL.Query().Get(codeChallengeParam))
 
-	formValues := make(map[string][]string)
-	formValues["state"] = []string{oauthCookieValue}
+		// the state can be used only once
+		_, err = tokenIssuer.Exchange(getRequest(state, "code"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid oauth state")
+	})
 
-	req, err := getRequest(cookies, formValues)
-	require.NoError(t, err)
+	t.Run("checks the id_token nonce", func(t *testing.T) {
+		var idToken string
 
-	tokenIssuer := New(&oauth2.Config{})
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, err := w.Write([]byte(`{"access_token":"token","token_type":"Bearer","id_token":"` + idToken + `"}`))
+			require.NoError(t, err)
+		}))
+		defer srv.Close()
 
-	token, err := tokenIssuer.Exchange(req)
-	require.Error(t, err)
-	require.Contains(t, err.Error(), "unsupported protocol scheme")
-	require.Nil(t, token)
+		tokenIssuer, err := New(&oauth2.Config{Endpoint: oauth2.Endpoint{TokenURL: srv.URL}})
+		require.NoError(t, err)
+
+		for _, tc := range []struct {
+			nonce string
+			err   string
+		}{
+			{err: "invalid id_token nonce"},
+			{nonce: "other", err: "invalid id_token nonce"},
+			{nonce: "login"},
+		} {
+			u, err := tokenIssuer.AuthCodeURL(httptest.NewRecorder(), &LoginRequest{Scope: []string{"openid"}})
+			require.NoError(t, err)
+
+			authURL, err := url.Parse(u)
+			require.NoError(t, err)
+
+			nonce := tc.nonce
+			if nonce == "login" {
+				nonce = authURL.Query().Get(nonceParam)
+			}
+
+			idToken = "e30." + base64.RawURLEncoding.EncodeToString([]byte(`{"nonce":"`+nonce+`"}`)) + ".sig"
+
+			login, err := tokenIssuer.Exchange(getRequest(authURL.Query().Get(stateFormKey), "code"))
+			if tc.err != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.err)
+				require.Nil(t, login)
+
+				continue
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, "token", login.Token.AccessToken)
+		}
+
+		idToken = "invalid"
+
+		u, err := tokenIssuer.AuthCodeURL(httptest.NewRecorder(), &LoginRequest{Scope: []string{"openid"}})
+		require.NoError(t, err)
+
+		authURL, err := url.Parse(u)
+		require.NoError(t, err)
+
+		_, err = tokenIssuer.Exchange(getRequest(authURL.Query().Get(stateFormKey), "code"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid id_token")
+	})
+
+	t.Run("state cookie of another browser", func(t *testing.T) {
+		tokenIssuer, err := New(&oauth2.Config{})
+		require.NoError(t, err)
+
+		u, err := tokenIssuer.AuthCodeURL(httptest.NewRecorder(), &LoginRequest{})
+		require.NoError(t, err)
+
+		authURL, err := url.Parse(u)
+		require.NoError(t, err)
+
+		state := authURL.Query().Get(stateFormKey)
+
+		req := &http.Request{Form: url.Values{stateFormKey: {state}, codeFormKey: {"code"}}, Header: http.Header{}}
+
+		_, err = tokenIssuer.Exchange(req)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "missing oauth state cookie")
+
+		req.AddCookie(&http.Cookie{Name: oauthCookieName, Value: "other"})
+
+		_, err = tokenIssuer.Exchange(req)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid oauth state")
+
+		// the login of the state isn't consumed by a request without the state cookie
+		_, err = tokenIssuer.pendingLogin(state)
+		require.NoError(t, err)
+	})
+
+	t.Run("missing state", func(t *testing.T) {
+		tokenIssuer, err := New(&oauth2.Config{})
+		require.NoError(t, err)
+
+		login, err := tokenIssuer.Exchange(getRequest("", "code"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "missing oauth state")
+		require.Nil(t, login)
+	})
+
+	t.Run("expired state", func(t *testing.T) {
+		tokenIssuer, err := New(&oauth2.Config{}, WithLoginTTL(-time.Minute))
+		require.NoError(t, err)
+
+		u, err := tokenIssuer.AuthCodeURL(httptest.NewRecorder(), &LoginRequest{})
+		require.NoError(t, err)
+
+		authURL, err := url.Parse(u)
+		require.NoError(t, err)
+
+		_, err = tokenIssuer.Exchange(getRequest(authURL.Query().Get(stateFormKey), "code"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "expired oauth state")
+	})
+
+	t.Run("store errors", func(t *testing.T) {
+		tokenIssuer, err := New(&oauth2.Config{}, WithStoreProvider(&mockstorage.Provider{
+			OpenStoreReturn: &mockstorage.Store{ErrGet: errors.New("get error")},
+		}))
+		require.NoError(t, err)
+
+		_, err = tokenIssuer.Exchange(getRequest("state", "code"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to get login : get error")
+
+		tokenIssuer, err = New(&oauth2.Config{}, WithStoreProvider(&mockstorage.Provider{
+			OpenStoreReturn: &mockstorage.Store{GetReturn: []byte("{")},
+		}))
+		require.NoError(t, err)
+
+		_, err = tokenIssuer.Exchange(getRequest("state", "code"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to unmarshal login")
+	})
+
+	t.Run("get token error", func(t *testing.T) {
+		tokenIssuer, err := New(&oauth2.Config{})
+		require.NoError(t, err)
+
+		u, err := tokenIssuer.AuthCodeURL(httptest.NewRecorder(), &LoginRequest{})
+		require.NoError(t, err)
+
+		authURL, err := url.Parse(u)
+		require.NoError(t, err)
+
+		login, err := tokenIssuer.Exchange(getRequest(authURL.Query().Get(stateFormKey), "code"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unsupported protocol scheme")
+		require.Nil(t, login)
+	})
 }
 
-func getRequest(cookies []*http.Cookie, formValues map[string][]string) (*http.Request, error) {
-	req, err := http.NewRequest("method", "path", bytes.NewBuffer([]byte("")))
-	if err != nil {
-		return nil, err
-	}
+func getRequest(state, code string) *http.Request {
+	r := &http.Request{Form: url.Values{stateFormKey: {state}, codeFormKey: {code}}, Header: http.Header{}}
+	r.AddCookie(&http.Cookie{Name: oauthCookieName, Value: state})
+
+	return r
+}
+
+func TestIssuer_Revoke(t *testing.T) {
+	t.Run("revokes the refresh and access tokens", func(t *testing.T) {
+		var revoked []string
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, _, ok := r.BasicAuth()
+			require.True(t, ok)
+			require.Equal(t, "client", id)
+
+			revoked = append(revoked, r.FormValue("token_type_hint")+":"+r.FormValue("token"))
+		}))
+		defer srv.Close()
+
+		tokenIssuer, err := New(&oauth2.Config{ClientID: "client", ClientSecret: "secret"}, WithRevocationURL(srv.URL))
+		require.NoError(t, err)
+
+		require.NoError(t, tokenIssuer.Revoke(&oauth2.Token{AccessToken: "a1", RefreshToken: "r1"}))
+		require.Equal(t, []string{"refresh_token:r1", "access_token:a1"}, revoked)
+	})
+
+	t.Run("client auth", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "client", r.FormValue("client_id"))
+		}))
+		defer srv.Close()
+
+		tokenIssuer, err := New(&oauth2.Config{}, WithRevocationURL(srv.URL), WithClientAuth(&token.ClientAuth{
+			Method: token.ClientSecretPost, ClientID: "client", ClientSecret: "secret",
+		}))
+		require.NoError(t, err)
+
+		require.NoError(t, tokenIssuer.Revoke(&oauth2.Token{AccessToken: "a1"}))
+	})
+
+	t.Run("revocation error", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer srv.Close()
+
+		tokenIssuer, err := New(&oauth2.Config{}, WithRevocationURL(srv.URL))
+		require.NoError(t, err)
 
-	for _, c := range cookies {
-		req.AddCookie(c)
-	}
+		require.Error(t, tokenIssuer.Revoke(&oauth2.Token{AccessToken: "a1", RefreshToken: "r1"}))
+	})
 
-	req.Form = formValues
+	t.Run("revocation not configured", func(t *testing.T) {
+		tokenIssuer, err := New(&oauth2.Config{})
+		require.NoError(t, err)
 
-	return req, nil
+		require.NoError(t, tokenIssuer.Revoke(&oauth2.Token{AccessToken: "a1"}))
+	})
 }
@@ -0,0 +1,276 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package jwks
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/square/go-jose/v3"
+	"github.com/square/go-jose/v3/jwt"
+	"github.com/trustbloc/edge-core/pkg/log"
+
+	"github.com/trustbloc/sandbox/pkg/token"
+)
+
+const (
+	defaultRefreshInterval    = time.Hour
+	defaultMinRefreshInterval = time.Minute
+
+	accessTokenType = "access_token"
+	// jwtAccessTokenType is the typ header of the JWT access tokens, see RFC 9068
+	jwtAccessTokenType = "at+jwt"
+	mediaTypePrefix    = "application/"
+)
+
+var logger = log.New("sandbox-token-jwks")
+
+// Option configures the resolver
+type Option func(opts *Resolver)
+
+// WithTLSConfig option is for definition of secured HTTP transport using a tls.Config instance
+func WithTLSConfig(tlsConfig *tls.Config) Option {
+	return func(opts *Resolver) {
+		opts.httpClient.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+}
+
+// WithRefreshInterval option sets how long the fetched keys are used before they are fetched again
+func WithRefreshInterval(interval time.Duration) Option {
+	return func(opts *Resolver) {
+		opts.refreshInterval = interval
+	}
+}
+
+// WithMinRefreshInterval option sets the minimum time between two fetches of the keys, which limits
+// the fetches for tokens signed with unknown keys
+func WithMinRefreshInterval(interval time.Duration) Option {
+	return func(opts *Resolver) {
+		opts.minRefreshInterval = interval
+	}
+}
+
+// Resolver validates JWT access tokens locally with the keys of the provider's JWKS endpoint.
+// The keys are fetched again after the refresh interval or when a token is signed with an unknown key,
+// so that rotated keys are picked up.
+type Resolver struct {
+	jwksURL            string
+	audience           string
+	httpClient         *http.Client
+	refreshInterval    time.Duration
+	minRefreshInterval time.Duration
+	now                func() time.Time
+
+	mu        sync.Mutex
+	keys      *jose.JSONWebKeySet
+	fetchedAt time.Time
+}
+
+// claims of the JWT access tokens, see RFC 9068
+type claims struct {
+	jwt.Claims
+	Scope    string                 `json:"scope,omitempty"`
+	Scopes   []string               `json:"scp,omitempty"`
+	ClientID string                 `json:"client_id,omitempty"`
+	Username string                 `json:"username,omitempty"`
+	Extra    map[string]interface{} `json:"ext,omitempty"`
+}
+
+// New creates new JWKS token resolver. Only the access tokens issued for the audience are accepted, so that
+// the ID tokens and the access tokens of the provider's other resource servers are rejected.
+func New(jwksURL, audience string, opts ...Option) (*Resolver, error) {
+	if audience == "" {
+		return nil, errors.New("missing token audience")
+	}
+
+	resolver := &Resolver{
+		jwksURL:            jwksURL,
+		audience:           audience,
+		httpClient:         &http.Client{},
+		refreshInterval:    defaultRefreshInterval,
+		minRefreshInterval: defaultMinRefreshInterval,
+		now:                time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(resolver)
+	}
+
+	return resolver, nil
+}
+
+// Resolve verifies the signature, the type and the audience of the JWT access token and returns its claims as
+// token information. Expired tokens and tokens used before their not before time are rejected with the errors
+// of the token package.
+func (r *Resolver) Resolve(tk string) (*token.Introspection, error) {
+	parsed, err := jwt.ParseSigned(tk)
+	if err != nil {
+		return nil, fmt.Errorf("%w : failed to parse jwt : %s", token.ErrInvalidToken, err)
+	}
+
+	if len(parsed.Headers) != 1 {
+		return nil, fmt.Errorf("%w : jwt must have one signature", token.ErrInvalidToken)
+	}
+
+	if !isAccessTokenType(parsed.Headers[0].ExtraHeaders[jose.HeaderType]) {
+		return nil, fmt.Errorf("%w : jwt is not an access token (typ %s)", token.ErrInvalidToken, jwtAccessTokenType)
+	}
+
+	key, err := r.key(parsed.Headers[0].KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &claims{}
+
+	err = parsed.Claims(key, c)
+	if err != nil {
+		return nil, fmt.Errorf("%w : failed to verify jwt : %s", token.ErrInvalidToken, err)
+	}
+
+	info := introspection(c)
+
+	err = info.Validate(r.now(), "", r.audience)
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// key returns the key with the key id, the keys are fetched again if the key is unknown.
+func (r *Resolver) key(kid string) (*jose.JSONWebKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+
+	if r.keys == nil || now.Sub(r.fetchedAt) >= r.refreshInterval {
+		err := r.fetchKeys(now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	key, ok := findKey(r.keys, kid)
+	if ok {
+		return key, nil
+	}
+
+	if now.Sub(r.fetchedAt) < r.minRefreshInterval {
+		return nil, fmt.Errorf("%w : unknown signing key %s", token.ErrInvalidToken, kid)
+	}
+
+	err := r.fetchKeys(now)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok = findKey(r.keys, kid)
+	if !ok {
+		return nil, fmt.Errorf("%w : unknown signing key %s", token.ErrInvalidToken, kid)
+	}
+
+	return key, nil
+}
+
+func (r *Resolver) fetchKeys(now time.Time) error {
+	resp, err := r.httpClient.Get(r.jwksURL)
+	if err != nil {
+		return fmt.Errorf("failed to fetch jwks : %w", err)
+	}
+
+	defer func() {
+		err := resp.Body.Close()
+		if err != nil {
+			logger.Warnf("failed to close response body")
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch jwks : http status code is %d", resp.StatusCode)
+	}
+
+	keys := &jose.JSONWebKeySet{}
+
+	err = json.NewDecoder(resp.Body).Decode(keys)
+	if err != nil {
+		return fmt.Errorf("failed to decode jwks : %w", err)
+	}
+
+	r.keys = keys
+	r.fetchedAt = now
+
+	logger.Debugf("fetched %d keys from %s", len(keys.Keys), r.jwksURL)
+
+	return nil
+}
+
+func isAccessTokenType(typ interface{}) bool {
+	t, ok := typ.(string)
+	if !ok {
+		return false
+	}
+
+	return strings.TrimPrefix(strings.ToLower(t), mediaTypePrefix) == jwtAccessTokenType
+}
+
+func findKey(keys *jose.JSONWebKeySet, kid string) (*jose.JSONWebKey, bool) {
+	// a token without a key id can be verified only if there is one key
+	if kid == "" {
+		if len(keys.Keys) == 1 {
+			return &keys.Keys[0], true
+		}
+
+		return nil, false
+	}
+
+	found := keys.Key(kid)
+	if len(found) == 0 {
+		return nil, false
+	}
+
+	return &found[0], true
+}
+
+func introspection(c *claims) *token.Introspection {
+	info := &token.Introspection{
+		Active:    true,
+		Scope:     c.Scope,
+		ClientID:  c.ClientID,
+		Username:  c.Username,
+		TokenType: accessTokenType,
+		Subject:   c.Subject,
+		Audience:  c.Audience,
+		Issuer:    c.Issuer,
+		Extra:     c.Extra,
+	}
+
+	if info.Scope == "" {
+		info.Scope = strings.Join(c.Scopes, " ")
+	}
+
+	if c.Expiry != nil {
+		info.ExpiresAt = int64(*c.Expiry)
+	}
+
+	if c.IssuedAt != nil {
+		info.IssuedAt = int64(*c.IssuedAt)
+	}
+
+	if c.NotBefore != nil {
+		info.NotBefore = int64(*c.NotBefore)
+	}
+
+	return info
+}
@@ -0,0 +1,318 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package jwks
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/square/go-jose/v3"
+	"github.com/square/go-jose/v3/jwt"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/sandbox/pkg/token"
+)
+
+const testAudience = "issuer"
+
+func TestNew(t *testing.T) {
+	_, err := New("http://localhost/jwks", "")
+	require.EqualError(t, err, "missing token audience")
+}
+
+func TestOpts(t *testing.T) {
+	r := newResolver(t, "", WithTLSConfig(&tls.Config{ServerName: "name", MinVersion: tls.VersionTLS12}),
+		WithRefreshInterval(time.Minute), WithMinRefreshInterval(time.Second))
+	require.NotNil(t, r.httpClient.Transport)
+	require.Equal(t, time.Minute, r.refreshInterval)
+	require.Equal(t, time.Second, r.minRefreshInterval)
+}
+
+func TestResolver_Resolve(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		srv := newJWKSServer(t)
+		defer srv.Close()
+
+		key := srv.addKey(t, "k1")
+
+		r := newResolver(t, srv.URL)
+
+		info, err := r.Resolve(sign(t, key, "k1", &claims{
+			Claims: jwt.Claims{
+				Subject:  "a@b.com",
+				Issuer:   "https://hydra.example.com/",
+				Audience: jwt.Audience{testAudience},
+				Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+				IssuedAt: jwt.NewNumericDate(time.Now()),
+			},
+			Scopes:   []string{"openid", "CreditCardStatement"},
+			ClientID: "client",
+		}))
+		require.NoError(t, err)
+		require.True(t, info.Active)
+		require.Equal(t, "a@b.com", info.Subject)
+		require.Equal(t, "openid CreditCardStatement", info.Scope)
+		require.Equal(t, "client", info.ClientID)
+		require.Equal(t, []string{testAudience}, info.Audience)
+		require.Equal(t, "https://hydra.example.com/", info.Issuer)
+		require.NotZero(t, info.ExpiresAt)
+
+		_, err = r.Resolve(sign(t, key, "k1", &claims{Scope: "openid"}))
+		require.NoError(t, err)
+		require.Equal(t, 1, srv.fetches)
+	})
+
+	t.Run("key rotation", func(t *testing.T) {
+		srv := newJWKSServer(t)
+		defer srv.Close()
+
+		srv.addKey(t, "k1")
+
+		r := newResolver(t, srv.URL, WithMinRefreshInterval(0))
+
+		_, err := r.Resolve(sign(t, srv.addKey(t, "k2"), "k2", &claims{}))
+		require.NoError(t, err)
+		require.Equal(t, 1, srv.fetches)
+
+		_, err = r.Resolve(sign(t, srv.addKey(t, "k3"), "k3", &claims{}))
+		require.NoError(t, err)
+		require.Equal(t, 2, srv.fetches)
+	})
+
+	t.Run("unknown key is not fetched again before the min refresh interval", func(t *testing.T) {
+		srv := newJWKSServer(t)
+		defer srv.Close()
+
+		srv.addKey(t, "k1")
+
+		r := newResolver(t, srv.URL)
+
+		_, err := r.Resolve(sign(t, newKey(t), "k2", &claims{}))
+		require.ErrorIs(t, err, token.ErrInvalidToken)
+		require.Contains(t, err.Error(), "unknown signing key k2")
+
+		_, err = r.Resolve(sign(t, newKey(t), "k2", &claims{}))
+		require.ErrorIs(t, err, token.ErrInvalidToken)
+		require.Equal(t, 1, srv.fetches)
+	})
+
+	t.Run("refresh interval", func(t *testing.T) {
+		srv := newJWKSServer(t)
+		defer srv.Close()
+
+		key := srv.addKey(t, "k1")
+
+		r := newResolver(t, srv.URL)
+
+		now := time.Now()
+		r.now = func() time.Time { return now }
+
+		_, err := r.Resolve(sign(t, key, "k1", &claims{}))
+		require.NoError(t, err)
+
+		r.now = func() time.Time { return now.Add(defaultRefreshInterval) }
+
+		_, err = r.Resolve(sign(t, key, "k1", &claims{}))
+		require.NoError(t, err)
+		require.Equal(t, 2, srv.fetches)
+	})
+
+	t.Run("invalid tokens", func(t *testing.T) {
+		srv := newJWKSServer(t)
+		defer srv.Close()
+
+		key := srv.addKey(t, "k1")
+
+		r := newResolver(t, srv.URL)
+
+		_, err := r.Resolve("invalid")
+		require.ErrorIs(t, err, token.ErrInvalidToken)
+
+		_, err = r.Resolve(sign(t, newKey(t), "k1", &claims{}))
+		require.ErrorIs(t, err, token.ErrInvalidToken)
+		require.Contains(t, err.Error(), "failed to verify jwt")
+
+		_, err = r.Resolve(sign(t, key, "k1", &claims{
+			Claims: jwt.Claims{Expiry: jwt.NewNumericDate(time.Now().Add(-time.Minute))},
+		}))
+		require.ErrorIs(t, err, token.ErrExpiredToken)
+
+		_, err = r.Resolve(sign(t, key, "k1", &claims{
+			Claims: jwt.Claims{NotBefore: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+		}))
+		require.ErrorIs(t, err, token.ErrTokenNotYetValid)
+
+		_, err = r.Resolve(sign(t, key, "k1", &claims{Claims: jwt.Claims{Audience: jwt.Audience{"other"}}}))
+		require.ErrorIs(t, err, token.ErrInvalidAudience)
+	})
+
+	t.Run("token type", func(t *testing.T) {
+		srv := newJWKSServer(t)
+		defer srv.Close()
+
+		key := srv.addKey(t, "k1")
+
+		r := newResolver(t, srv.URL)
+
+		_, err := r.Resolve(signWithType(t, key, "k1", "application/at+jwt", &claims{}))
+		require.NoError(t, err)
+
+		// an ID token of the same provider is not an access token
+		_, err = r.Resolve(signWithType(t, key, "k1", "JWT", &claims{}))
+		require.ErrorIs(t, err, token.ErrInvalidToken)
+		require.Contains(t, err.Error(), "jwt is not an access token")
+
+		_, err = r.Resolve(signWithType(t, key, "k1", "", &claims{}))
+		require.ErrorIs(t, err, token.ErrInvalidToken)
+	})
+
+	t.Run("token without key id", func(t *testing.T) {
+		srv := newJWKSServer(t)
+		defer srv.Close()
+
+		key := srv.addKey(t, "k1")
+
+		r := newResolver(t, srv.URL)
+
+		_, err := r.Resolve(sign(t, key, "", &claims{}))
+		require.NoError(t, err)
+
+		srv.addKey(t, "k2")
+
+		r = newResolver(t, srv.URL)
+
+		_, err = r.Resolve(sign(t, key, "", &claims{}))
+		require.ErrorIs(t, err, token.ErrInvalidToken)
+	})
+
+	t.Run("jwks errors", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+
+		r := newResolver(t, srv.URL)
+
+		_, err := r.Resolve(sign(t, newKey(t), "k1", &claims{}))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "http status code is 500")
+
+		srv.Close()
+
+		srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, err := w.Write([]byte("{"))
+			require.NoError(t, err)
+		}))
+		defer srv.Close()
+
+		r = newResolver(t, srv.URL)
+
+		_, err = r.Resolve(sign(t, newKey(t), "k1", &claims{}))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to decode jwks")
+
+		r = newResolver(t, "http://localhost:1/jwks")
+
+		_, err = r.Resolve(sign(t, newKey(t), "k1", &claims{}))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to fetch jwks")
+	})
+}
+
+type jwksServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    jose.JSONWebKeySet
+	fetches int
+}
+
+func newJWKSServer(t *testing.T) *jwksServer {
+	t.Helper()
+
+	s := &jwksServer{}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.fetches++
+
+		require.NoError(t, json.NewEncoder(w).Encode(&s.keys))
+	}))
+
+	return s
+}
+
+func (s *jwksServer) addKey(t *testing.T, kid string) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key := newKey(t)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys.Keys = append(s.keys.Keys, jose.JSONWebKey{Key: &key.PublicKey, KeyID: kid, Algorithm: "ES256"})
+
+	return key
+}
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	return key
+}
+
+func newResolver(t *testing.T, jwksURL string, opts ...Option) *Resolver {
+	t.Helper()
+
+	r, err := New(jwksURL, testAudience, opts...)
+	require.NoError(t, err)
+
+	return r
+}
+
+// sign signs the claims as an access token, for the test audience if the claims have no audience.
+func sign(t *testing.T, key *ecdsa.PrivateKey, kid string, c *claims) string {
+	t.Helper()
+
+	return signWithType(t, key, kid, jwtAccessTokenType, c)
+}
+
+func signWithType(t *testing.T, key *ecdsa.PrivateKey, kid, typ string, c *claims) string {
+	t.Helper()
+
+	if c.Audience == nil {
+		c.Audience = jwt.Audience{testAudience}
+	}
+
+	opts := &jose.SignerOptions{}
+	if kid != "" {
+		opts = opts.WithHeader(jose.HeaderKey("kid"), kid)
+	}
+
+	if typ != "" {
+		opts = opts.WithType(jose.ContentType(typ))
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, opts)
+	require.NoError(t, err)
+
+	tk, err := jwt.Signed(signer).Claims(c).CompactSerialize()
+	require.NoError(t, err)
+
+	return tk
+}
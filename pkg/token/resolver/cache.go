@@ -0,0 +1,192 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package resolver
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"github.com/trustbloc/sandbox/pkg/token"
+)
+
+const (
+	defaultCacheSize   = 1000
+	defaultCacheMaxTTL = 5 * time.Minute
+)
+
+type tokenResolver interface {
+	Resolve(string) (*token.Introspection, error)
+}
+
+// CacheOption configures the caching resolver
+type CacheOption func(opts *CachingResolver)
+
+// WithCacheSize option sets the maximum number of cached tokens, the least recently used token is evicted first
+func WithCacheSize(size int) CacheOption {
+	return func(opts *CachingResolver) {
+		opts.size = size
+	}
+}
+
+// WithCacheMaxTTL option sets the maximum time a token is cached, tokens are cached until they expire otherwise
+func WithCacheMaxTTL(ttl time.Duration) CacheOption {
+	return func(opts *CachingResolver) {
+		opts.maxTTL = ttl
+	}
+}
+
+// WithIssuer option rejects the tokens that are not issued by the issuer
+func WithIssuer(issuer string) CacheOption {
+	return func(opts *CachingResolver) {
+		opts.issuer = issuer
+	}
+}
+
+// WithAudience option rejects the tokens that are not issued for the audience
+func WithAudience(audience string) CacheOption {
+	return func(opts *CachingResolver) {
+		opts.audience = audience
+	}
+}
+
+// CachingResolver resolves tokens with another resolver and caches the valid tokens. Tokens that are
+// inactive, expired, not valid yet or issued by another issuer or for another audience are rejected
+// with the errors of the token package.
+type CachingResolver struct {
+	resolver tokenResolver
+	size     int
+	maxTTL   time.Duration
+	issuer   string
+	audience string
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]*list.Element
+	lru     *list.List
+}
+
+type cacheEntry struct {
+	key       [sha256.Size]byte
+	info      *token.Introspection
+	expiresAt time.Time
+}
+
+// NewCachingResolver creates new caching token resolver
+func NewCachingResolver(resolver tokenResolver, opts ...CacheOption) *CachingResolver {
+	r := &CachingResolver{
+		resolver: resolver,
+		size:     defaultCacheSize,
+		maxTTL:   defaultCacheMaxTTL,
+		now:      time.Now,
+		entries:  make(map[[sha256.Size]byte]*list.Element),
+		lru:      list.New(),
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// Resolve returns the cached token information, or resolves and caches it until the token expires.
+func (r *CachingResolver) Resolve(tk string) (*token.Introspection, error) {
+	// the cache doesn't keep the tokens
+	key := sha256.Sum256([]byte(tk))
+	now := r.now()
+
+	info, ok := r.get(key, now)
+	if !ok {
+		var err error
+
+		info, err = r.resolver.Resolve(tk)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := info.Validate(now, r.issuer, r.audience)
+	if err != nil {
+		r.remove(key)
+
+		return nil, err
+	}
+
+	if !ok {
+		r.add(key, info, now)
+	}
+
+	return info, nil
+}
+
+// Invalidate removes the token from the cache, for example after the token is revoked.
+func (r *CachingResolver) Invalidate(tk string) {
+	r.remove(sha256.Sum256([]byte(tk)))
+}
+
+func (r *CachingResolver) get(key [sha256.Size]byte, now time.Time) (*token.Introspection, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := e.Value.(*cacheEntry) // nolint: errcheck, forcetypeassert
+
+	if !now.Before(entry.expiresAt) {
+		r.lru.Remove(e)
+		delete(r.entries, key)
+
+		return nil, false
+	}
+
+	r.lru.MoveToFront(e)
+
+	return entry.info, true
+}
+
+func (r *CachingResolver) add(key [sha256.Size]byte, info *token.Introspection, now time.Time) {
+	expiresAt := now.Add(r.maxTTL)
+
+	if info.ExpiresAt != 0 && time.Unix(info.ExpiresAt, 0).Before(expiresAt) {
+		expiresAt = time.Unix(info.ExpiresAt, 0)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.size <= 0 {
+		return
+	}
+
+	if e, ok := r.entries[key]; ok {
+		r.lru.Remove(e)
+	}
+
+	r.entries[key] = r.lru.PushFront(&cacheEntry{key: key, info: info, expiresAt: expiresAt})
+
+	for r.lru.Len() > r.size {
+		oldest := r.lru.Back()
+
+		r.lru.Remove(oldest)
+		delete(r.entries, oldest.Value.(*cacheEntry).key) // nolint: errcheck, forcetypeassert
+	}
+}
+
+func (r *CachingResolver) remove(key [sha256.Size]byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e, ok := r.entries[key]; ok {
+		r.lru.Remove(e)
+		delete(r.entries, key)
+	}
+}
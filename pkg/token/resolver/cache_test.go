@@ -0,0 +1,152 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package resolver
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/sandbox/pkg/token"
+)
+
+func TestCachingResolver_Resolve(t *testing.T) {
+	now := time.Unix(1000, 0)
+
+	t.Run("caches until the token expires", func(t *testing.T) {
+		m := &mockResolver{infos: map[string]*token.Introspection{
+			"t1": {Active: true, Subject: "a@b.com", ExpiresAt: now.Unix() + 60},
+		}}
+
+		r := NewCachingResolver(m)
+		r.now = func() time.Time { return now }
+
+		info, err := r.Resolve("t1")
+		require.NoError(t, err)
+		require.Equal(t, "a@b.com", info.Subject)
+
+		_, err = r.Resolve("t1")
+		require.NoError(t, err)
+		require.Equal(t, 1, m.calls)
+
+		r.now = func() time.Time { return now.Add(time.Minute) }
+
+		_, err = r.Resolve("t1")
+		require.ErrorIs(t, err, token.ErrExpiredToken)
+		require.Equal(t, 2, m.calls)
+	})
+
+	t.Run("caches up to the max ttl", func(t *testing.T) {
+		m := &mockResolver{infos: map[string]*token.Introspection{"t1": {Active: true}}}
+
+		r := NewCachingResolver(m, WithCacheMaxTTL(time.Second))
+		r.now = func() time.Time { return now }
+
+		_, err := r.Resolve("t1")
+		require.NoError(t, err)
+
+		r.now = func() time.Time { return now.Add(time.Second) }
+
+		_, err = r.Resolve("t1")
+		require.NoError(t, err)
+		require.Equal(t, 2, m.calls)
+	})
+
+	t.Run("evicts the least recently used token", func(t *testing.T) {
+		m := &mockResolver{infos: map[string]*token.Introspection{
+			"t1": {Active: true}, "t2": {Active: true}, "t3": {Active: true},
+		}}
+
+		r := NewCachingResolver(m, WithCacheSize(2))
+
+		for _, tk := range []string{"t1", "t2", "t1", "t3", "t1"} {
+			_, err := r.Resolve(tk)
+			require.NoError(t, err)
+		}
+
+		require.Equal(t, 3, m.calls)
+		require.Equal(t, 2, r.lru.Len())
+
+		_, err := r.Resolve("t2")
+		require.NoError(t, err)
+		require.Equal(t, 4, m.calls)
+	})
+
+	t.Run("cache disabled", func(t *testing.T) {
+		m := &mockResolver{infos: map[string]*token.Introspection{"t1": {Active: true}}}
+
+		r := NewCachingResolver(m, WithCacheSize(0))
+
+		for i := 0; i < 2; i++ {
+			_, err := r.Resolve("t1")
+			require.NoError(t, err)
+		}
+
+		require.Equal(t, 2, m.calls)
+	})
+
+	t.Run("rejects invalid tokens", func(t *testing.T) {
+		m := &mockResolver{infos: map[string]*token.Introspection{
+			"inactive": {Active: false},
+			"other":    {Active: true, Issuer: "https://hydra.example.com/", Audience: []string{"rp"}},
+			"valid":    {Active: true, Issuer: "https://hydra.example.com/", Audience: []string{"issuer"}},
+		}}
+
+		r := NewCachingResolver(m, WithIssuer("https://hydra.example.com/"), WithAudience("issuer"))
+
+		_, err := r.Resolve("inactive")
+		require.ErrorIs(t, err, token.ErrInactiveToken)
+
+		_, err = r.Resolve("other")
+		require.ErrorIs(t, err, token.ErrInvalidAudience)
+
+		_, err = r.Resolve("valid")
+		require.NoError(t, err)
+
+		require.Equal(t, 1, r.lru.Len())
+	})
+
+	t.Run("invalidate", func(t *testing.T) {
+		m := &mockResolver{infos: map[string]*token.Introspection{"t1": {Active: true}}}
+
+		r := NewCachingResolver(m)
+
+		_, err := r.Resolve("t1")
+		require.NoError(t, err)
+
+		r.Invalidate("t1")
+		m.infos["t1"] = &token.Introspection{Active: false}
+
+		_, err = r.Resolve("t1")
+		require.ErrorIs(t, err, token.ErrInactiveToken)
+	})
+
+	t.Run("resolver error", func(t *testing.T) {
+		r := NewCachingResolver(&mockResolver{err: errors.New("introspection error")})
+
+		_, err := r.Resolve("t1")
+		require.EqualError(t, err, "introspection error")
+	})
+}
+
+type mockResolver struct {
+	infos map[string]*token.Introspection
+	err   error
+	calls int
+}
+
+func (m *mockResolver) Resolve(tk string) (*token.Introspection, error) {
+	m.calls++
+
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	return m.infos[tk], nil
+}
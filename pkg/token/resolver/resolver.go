@@ -33,10 +33,18 @@ func WithTLSConfig(tlsConfig *tls.Config) Option {
 	}
 }
 
+// WithClientAuth option authenticates the introspection requests with the client credentials
+func WithClientAuth(auth *token.ClientAuth) Option {
+	return func(opts *Resolver) {
+		opts.clientAuth = auth
+	}
+}
+
 // Resolver implements token resolution
 type Resolver struct {
 	tokenIntrospectionURL string
 	httpClient            *http.Client
+	clientAuth            *token.ClientAuth
 }
 
 // New creates new token resolver
@@ -52,8 +60,12 @@ func New(tokenIntrospectionURL string, opts ...Option) *Resolver {
 
 // Resolve returns token information based on token
 func (r *Resolver) Resolve(tk string) (*token.Introspection, error) {
-	resp, err := r.httpClient.PostForm(r.tokenIntrospectionURL,
-		url.Values{tokenFormKey: {tk}})
+	req, err := r.clientAuth.NewRequest(r.tokenIntrospectionURL, url.Values{tokenFormKey: {tk}})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
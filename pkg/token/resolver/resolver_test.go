@@ -15,6 +15,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/sandbox/pkg/token"
 )
 
 func TestOpts(t *testing.T) {
@@ -24,7 +26,7 @@ func TestOpts(t *testing.T) {
 
 func TestResolver_Resolve(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintln(w, jsonBody)
+		fmt.Fprint(w, jsonBody)
 	}))
 	defer ts.Close()
 
@@ -37,6 +39,33 @@ func TestResolver_Resolve(t *testing.T) {
 	require.Equal(t, "a@b.com", tk.Subject)
 }
 
+func TestResolver_Resolve_ClientAuth(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("client_id") != "client" || r.FormValue("client_secret") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		fmt.Fprint(w, jsonBody)
+	}))
+	defer ts.Close()
+
+	tokenIssuer := New(ts.URL, WithClientAuth(&token.ClientAuth{
+		Method: token.ClientSecretPost, ClientID: "client", ClientSecret: "secret",
+	}))
+
+	tk, err := tokenIssuer.Resolve("token")
+	require.NoError(t, err)
+	require.True(t, tk.Active)
+
+	_, err = New(ts.URL).Resolve("token")
+	require.EqualError(t, err, "http status code is not ok")
+
+	_, err = New(ts.URL, WithClientAuth(&token.ClientAuth{Method: "none"})).Resolve("token")
+	require.Error(t, err)
+}
+
 func TestResolver_Resolve_Error(t *testing.T) {
 	tokenIssuer := New("http://localhost/introspect")
 
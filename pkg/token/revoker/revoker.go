@@ -0,0 +1,116 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package revoker
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/trustbloc/edge-core/pkg/log"
+
+	"github.com/trustbloc/sandbox/pkg/token"
+)
+
+const (
+	tokenFormKey         = "token"
+	tokenTypeHintFormKey = "token_type_hint"
+
+	// AccessTokenHint hints that the revoked token is an access token
+	AccessTokenHint = "access_token"
+	// RefreshTokenHint hints that the revoked token is a refresh token
+	RefreshTokenHint = "refresh_token"
+)
+
+var logger = log.New("sandbox-token-revoker")
+
+// Option configures the revoker
+type Option func(opts *Revoker)
+
+// WithTLSConfig option is for definition of secured HTTP transport using a tls.Config instance
+func WithTLSConfig(tlsConfig *tls.Config) Option {
+	return func(opts *Revoker) {
+		opts.httpClient.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+}
+
+// WithClientAuth option authenticates the revocation requests with the client credentials
+func WithClientAuth(auth *token.ClientAuth) Option {
+	return func(opts *Revoker) {
+		opts.clientAuth = auth
+	}
+}
+
+// Revoker revokes tokens as specified by IETF RFC 7009
+// https://tools.ietf.org/html/rfc7009
+type Revoker struct {
+	revocationURL string
+	httpClient    *http.Client
+	clientAuth    *token.ClientAuth
+}
+
+type errorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// New creates new token revoker
+func New(revocationURL string, opts ...Option) *Revoker {
+	revoker := &Revoker{revocationURL: revocationURL, httpClient: &http.Client{}}
+
+	for _, opt := range opts {
+		opt(revoker)
+	}
+
+	return revoker
+}
+
+// Revoke revokes the token, the type hint is optional. Revoking an invalid or already revoked token succeeds.
+func (r *Revoker) Revoke(tk, tokenTypeHint string) error {
+	form := url.Values{tokenFormKey: {tk}}
+
+	if tokenTypeHint != "" {
+		form.Set(tokenTypeHintFormKey, tokenTypeHint)
+	}
+
+	req, err := r.clientAuth.NewRequest(r.revocationURL, form)
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to revoke token : %w", err)
+	}
+
+	defer func() {
+		err := resp.Body.Close()
+		if err != nil {
+			logger.Warnf("failed to close response body")
+		}
+	}()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to revoke token : http status code %d", resp.StatusCode)
+	}
+
+	errResp := &errorResponse{}
+
+	if json.Unmarshal(body, errResp) != nil || errResp.Error == "" {
+		return fmt.Errorf("failed to revoke token : http status code %d", resp.StatusCode)
+	}
+
+	return fmt.Errorf("failed to revoke token : %s %s", errResp.Error, errResp.ErrorDescription)
+}
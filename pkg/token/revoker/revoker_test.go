@@ -0,0 +1,86 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package revoker
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/sandbox/pkg/token"
+)
+
+func TestOpts(t *testing.T) {
+	r := New("", WithTLSConfig(&tls.Config{ServerName: "name", MinVersion: tls.VersionTLS12}),
+		WithClientAuth(&token.ClientAuth{Method: token.ClientSecretBasic}))
+	require.NotNil(t, r.httpClient.Transport)
+	require.NotNil(t, r.clientAuth)
+}
+
+func TestRevoker_Revoke(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		var form map[string][]string
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, secret, ok := r.BasicAuth()
+			if !ok || id != "client" || secret != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+
+				return
+			}
+
+			require.NoError(t, r.ParseForm())
+			form = r.PostForm
+		}))
+		defer srv.Close()
+
+		r := New(srv.URL, WithClientAuth(&token.ClientAuth{
+			Method: token.ClientSecretBasic, ClientID: "client", ClientSecret: "secret",
+		}))
+
+		require.NoError(t, r.Revoke("t1", AccessTokenHint))
+		require.Equal(t, []string{"t1"}, form["token"])
+		require.Equal(t, []string{"access_token"}, form["token_type_hint"])
+
+		require.NoError(t, r.Revoke("t2", ""))
+		require.Empty(t, form["token_type_hint"])
+	})
+
+	t.Run("error response", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, err := w.Write([]byte(`{"error": "invalid_client", "error_description": "client authentication failed"}`))
+			require.NoError(t, err)
+		}))
+		defer srv.Close()
+
+		err := New(srv.URL).Revoke("t1", "")
+		require.EqualError(t, err, "failed to revoke token : invalid_client client authentication failed")
+	})
+
+	t.Run("error status", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer srv.Close()
+
+		err := New(srv.URL).Revoke("t1", "")
+		require.EqualError(t, err, "failed to revoke token : http status code 503")
+	})
+
+	t.Run("request errors", func(t *testing.T) {
+		err := New("http://localhost:1/revoke").Revoke("t1", "")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to revoke token")
+
+		err = New("http://localhost:1/revoke", WithClientAuth(&token.ClientAuth{Method: "none"})).Revoke("t1", "")
+		require.EqualError(t, err, "unsupported client authentication method none")
+	})
+}
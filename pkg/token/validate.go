@@ -0,0 +1,65 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package token
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidToken is wrapped by the errors of the tokens that must not be accepted.
+var ErrInvalidToken = errors.New("invalid token")
+
+var (
+	// ErrInactiveToken is returned when the token is not active.
+	ErrInactiveToken = fmt.Errorf("%w : token is not active", ErrInvalidToken)
+	// ErrExpiredToken is returned when the token is expired.
+	ErrExpiredToken = fmt.Errorf("%w : token is expired", ErrInvalidToken)
+	// ErrTokenNotYetValid is returned when the token is used before its not before time.
+	ErrTokenNotYetValid = fmt.Errorf("%w : token is not valid yet", ErrInvalidToken)
+	// ErrInvalidAudience is returned when the token is not issued for the audience.
+	ErrInvalidAudience = fmt.Errorf("%w : token audience is not accepted", ErrInvalidToken)
+	// ErrInvalidIssuer is returned when the token is not issued by the issuer.
+	ErrInvalidIssuer = fmt.Errorf("%w : token issuer is not accepted", ErrInvalidToken)
+)
+
+// Validate checks that the token is active and valid at the time. The issuer and the audience
+// are checked if they are not empty.
+func (i *Introspection) Validate(now time.Time, issuer, audience string) error {
+	if !i.Active {
+		return ErrInactiveToken
+	}
+
+	if i.ExpiresAt != 0 && !now.Before(time.Unix(i.ExpiresAt, 0)) {
+		return ErrExpiredToken
+	}
+
+	if i.NotBefore != 0 && now.Before(time.Unix(i.NotBefore, 0)) {
+		return ErrTokenNotYetValid
+	}
+
+	if issuer != "" && i.Issuer != issuer {
+		return fmt.Errorf("%w : %s", ErrInvalidIssuer, i.Issuer)
+	}
+
+	if audience != "" && !contains(i.Audience, audience) {
+		return ErrInvalidAudience
+	}
+
+	return nil
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+
+	return false
+}
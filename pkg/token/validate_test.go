@@ -0,0 +1,54 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package token
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntrospection_Validate(t *testing.T) {
+	now := time.Unix(1000, 0)
+
+	valid := func() *Introspection {
+		return &Introspection{
+			Active:    true,
+			ExpiresAt: 2000,
+			NotBefore: 500,
+			Issuer:    "https://hydra.example.com/",
+			Audience:  []string{"issuer", "rp"},
+		}
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		require.NoError(t, valid().Validate(now, "https://hydra.example.com/", "rp"))
+		require.NoError(t, valid().Validate(now, "", ""))
+		require.NoError(t, (&Introspection{Active: true}).Validate(now, "", ""))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		info := valid()
+		info.Active = false
+		require.ErrorIs(t, info.Validate(now, "", ""), ErrInactiveToken)
+
+		info = valid()
+		info.ExpiresAt = now.Unix()
+		require.ErrorIs(t, info.Validate(now, "", ""), ErrExpiredToken)
+
+		info = valid()
+		info.NotBefore = now.Unix() + 1
+		require.ErrorIs(t, info.Validate(now, "", ""), ErrTokenNotYetValid)
+
+		err := valid().Validate(now, "https://other.example.com/", "")
+		require.ErrorIs(t, err, ErrInvalidIssuer)
+		require.ErrorIs(t, err, ErrInvalidToken)
+
+		require.ErrorIs(t, valid().Validate(now, "", "vault"), ErrInvalidAudience)
+	})
+}
@@ -0,0 +1,115 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package trustregistry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/square/go-jose/v3"
+	"gopkg.in/yaml.v2"
+)
+
+const didPrefix = "did:"
+
+// ErrUnsignedDocument is returned when a governance key is configured and the trust registry document is not signed.
+var ErrUnsignedDocument = errors.New("trust registry document is not signed")
+
+// Document is the trust registry governance document. It lists the issuers accepted by the relying party.
+type Document struct {
+	ID      string         `json:"id,omitempty" yaml:"id,omitempty"`
+	Name    string         `json:"name,omitempty" yaml:"name,omitempty"`
+	Version string         `json:"version,omitempty" yaml:"version,omitempty"`
+	Issuers []IssuerPolicy `json:"issuers" yaml:"issuers"`
+}
+
+// IssuerPolicy lists the issuer DIDs and domains accepted for a credential type in a flow. An empty credential type
+// or flow applies the policy to all the credential types or flows.
+type IssuerPolicy struct {
+	CredentialType string   `json:"credentialType,omitempty" yaml:"credentialType,omitempty"`
+	Flow           string   `json:"flow,omitempty" yaml:"flow,omitempty"`
+	DIDs           []string `json:"dids,omitempty" yaml:"dids,omitempty"`
+	// Domains are matched against did:web issuers and issuer URLs; '*.example.com' matches the subdomains.
+	Domains []string `json:"domains,omitempty" yaml:"domains,omitempty"`
+}
+
+// parseDocument parses a JSON, YAML or JWS signed trust registry document. The document must be signed
+// if the governance key is set.
+func parseDocument(raw []byte, governanceKey *jose.JSONWebKey) (*Document, bool, error) {
+	raw = bytes.TrimSpace(raw)
+
+	signed := isCompactJWS(raw)
+
+	if signed {
+		if governanceKey == nil {
+			return nil, false, errors.New("governance key is not configured to verify the signed document")
+		}
+
+		jws, err := jose.ParseSigned(string(raw))
+		if err != nil {
+			return nil, false, fmt.Errorf("parse signed document : %w", err)
+		}
+
+		raw, err = jws.Verify(governanceKey)
+		if err != nil {
+			return nil, false, fmt.Errorf("verify document signature : %w", err)
+		}
+	} else if governanceKey != nil {
+		return nil, false, ErrUnsignedDocument
+	}
+
+	doc := &Document{}
+
+	var err error
+
+	if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{")) {
+		err = json.Unmarshal(raw, doc)
+	} else {
+		err = yaml.UnmarshalStrict(raw, doc)
+	}
+
+	if err != nil {
+		return nil, false, fmt.Errorf("unmarshal document : %w", err)
+	}
+
+	err = doc.validate()
+	if err != nil {
+		return nil, false, err
+	}
+
+	return doc, signed, nil
+}
+
+func (d *Document) validate() error {
+	for i, p := range d.Issuers {
+		if len(p.DIDs) == 0 && len(p.Domains) == 0 {
+			return fmt.Errorf("issuer policy %d : at least one did or domain is required", i)
+		}
+
+		for _, did := range p.DIDs {
+			if !strings.HasPrefix(did, didPrefix) {
+				return fmt.Errorf("issuer policy %d : invalid did '%s'", i, did)
+			}
+		}
+
+		for _, domain := range p.Domains {
+			if domain == "" || strings.Contains(domain, "/") {
+				return fmt.Errorf("issuer policy %d : invalid domain '%s'", i, domain)
+			}
+		}
+	}
+
+	return nil
+}
+
+// isCompactJWS checks if the document is in JWS compact serialization (three base64url parts).
+func isCompactJWS(raw []byte) bool {
+	return bytes.Count(raw, []byte(".")) == 2 && bytes.IndexAny(raw, " \t\r\n{:") == -1
+}
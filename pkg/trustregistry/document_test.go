@@ -0,0 +1,143 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package trustregistry
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/square/go-jose/v3"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	jsonDocument = `{
+		"id": "demo-registry",
+		"version": "1",
+		"issuers": [
+			{"credentialType": "PermanentResidentCard", "flow": "flow1", "dids": ["did:example:issuer1"]},
+			{"credentialType": "UniversityDegreeCredential", "domains": ["example.com", "*.university.edu"]}
+		]
+	}`
+
+	yamlDocument = `
+id: demo-registry
+issuers:
+  - credentialType: PermanentResidentCard
+    dids:
+      - did:example:issuer1
+`
+)
+
+func TestParseDocument(t *testing.T) {
+	t.Run("json", func(t *testing.T) {
+		doc, signed, err := parseDocument([]byte(jsonDocument), nil)
+		require.NoError(t, err)
+		require.False(t, signed)
+		require.Equal(t, "demo-registry", doc.ID)
+		require.Len(t, doc.Issuers, 2)
+		require.Equal(t, []string{"example.com", "*.university.edu"}, doc.Issuers[1].Domains)
+	})
+
+	t.Run("yaml", func(t *testing.T) {
+		doc, signed, err := parseDocument([]byte(yamlDocument), nil)
+		require.NoError(t, err)
+		require.False(t, signed)
+		require.Equal(t, "demo-registry", doc.ID)
+		require.Equal(t, []string{"did:example:issuer1"}, doc.Issuers[0].DIDs)
+	})
+
+	t.Run("signed", func(t *testing.T) {
+		pub, priv := newKey(t)
+
+		doc, signed, err := parseDocument(sign(t, priv, jsonDocument), pub)
+		require.NoError(t, err)
+		require.True(t, signed)
+		require.Len(t, doc.Issuers, 2)
+	})
+
+	t.Run("signed with another key", func(t *testing.T) {
+		pub, _ := newKey(t)
+		_, priv := newKey(t)
+
+		_, _, err := parseDocument(sign(t, priv, jsonDocument), pub)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "verify document signature")
+	})
+
+	t.Run("signed without governance key", func(t *testing.T) {
+		_, priv := newKey(t)
+
+		_, _, err := parseDocument(sign(t, priv, jsonDocument), nil)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "governance key is not configured")
+	})
+
+	t.Run("invalid signed document", func(t *testing.T) {
+		pub, _ := newKey(t)
+
+		_, _, err := parseDocument([]byte("a.b.c"), pub)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "parse signed document")
+	})
+
+	t.Run("unsigned with governance key", func(t *testing.T) {
+		pub, _ := newKey(t)
+
+		_, _, err := parseDocument([]byte(jsonDocument), pub)
+		require.ErrorIs(t, err, ErrUnsignedDocument)
+	})
+
+	t.Run("invalid document", func(t *testing.T) {
+		_, _, err := parseDocument([]byte("{"), nil)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unmarshal document")
+
+		_, _, err = parseDocument([]byte("unknown: field"), nil)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unmarshal document")
+	})
+
+	t.Run("invalid policies", func(t *testing.T) {
+		_, _, err := parseDocument([]byte(`{"issuers": [{"credentialType": "type1"}]}`), nil)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "at least one did or domain is required")
+
+		_, _, err = parseDocument([]byte(`{"issuers": [{"dids": ["issuer1"]}]}`), nil)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid did")
+
+		_, _, err = parseDocument([]byte(`{"issuers": [{"domains": ["https://example.com/"]}]}`), nil)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid domain")
+	})
+}
+
+func newKey(t *testing.T) (*jose.JSONWebKey, ed25519.PrivateKey) {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	return &jose.JSONWebKey{Key: pub}, priv
+}
+
+func sign(t *testing.T, priv ed25519.PrivateKey, doc string) []byte {
+	t.Helper()
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.EdDSA, Key: priv}, nil)
+	require.NoError(t, err)
+
+	jws, err := signer.Sign([]byte(doc))
+	require.NoError(t, err)
+
+	compact, err := jws.CompactSerialize()
+	require.NoError(t, err)
+
+	return []byte(compact)
+}
@@ -0,0 +1,290 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package trustregistry
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/square/go-jose/v3"
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+const (
+	storeName   = "trustregistry"
+	documentKey = "governance-document"
+
+	didWebPrefix       = "did:web:"
+	baseCredentialType = "VerifiableCredential"
+)
+
+var logger = log.New("sandbox-trust-registry")
+
+// ErrUntrustedIssuer is returned when the issuer of a credential is not accepted by the trust registry.
+var ErrUntrustedIssuer = errors.New("untrusted issuer")
+
+// ErrEmptyDocument is returned when a trust registry document has no issuer policies and empty documents
+// aren't allowed. An empty registry accepts all the issuers.
+var ErrEmptyDocument = errors.New("trust registry document has no issuer policies")
+
+// UntrustedIssuerError describes why an issuer was rejected.
+type UntrustedIssuerError struct {
+	Issuer string
+	Reason string
+}
+
+func (e *UntrustedIssuerError) Error() string {
+	return fmt.Sprintf("%s %s : %s", ErrUntrustedIssuer, e.Issuer, e.Reason)
+}
+
+// Unwrap returns ErrUntrustedIssuer.
+func (e *UntrustedIssuerError) Unwrap() error {
+	return ErrUntrustedIssuer
+}
+
+// Credential is the information of a verified credential the registry needs to evaluate the issuer.
+type Credential struct {
+	Types  []string
+	Issuer string
+}
+
+// Option configures the trust registry
+type Option func(opts *Registry)
+
+// WithGovernanceKey option requires the trust registry documents to be signed (JWS) with the given key.
+func WithGovernanceKey(key *jose.JSONWebKey) Option {
+	return func(opts *Registry) {
+		opts.governanceKey = key
+	}
+}
+
+// WithAllowEmpty option accepts documents without issuer policies, which disables the issuer checks.
+func WithAllowEmpty() Option {
+	return func(opts *Registry) {
+		opts.allowEmpty = true
+	}
+}
+
+// Registry holds the issuers accepted by the relying party.
+type Registry struct {
+	store         storage.Store
+	governanceKey *jose.JSONWebKey
+	allowEmpty    bool
+
+	mu     sync.RWMutex
+	doc    *Document
+	signed bool
+}
+
+// New returns a trust registry with the document previously saved in the store, if any.
+func New(provider storage.Provider, opts ...Option) (*Registry, error) {
+	store, err := provider.OpenStore(storeName)
+	if err != nil {
+		return nil, fmt.Errorf("open trust registry store : %w", err)
+	}
+
+	registry := &Registry{store: store}
+
+	for _, opt := range opts {
+		opt(registry)
+	}
+
+	raw, err := store.Get(documentKey)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		return registry, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("get trust registry document : %w", err)
+	}
+
+	if len(raw) == 0 {
+		return registry, nil
+	}
+
+	registry.doc, registry.signed, err = registry.parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("load saved trust registry document : %w", err)
+	}
+
+	return registry, nil
+}
+
+// Load replaces the trust registry with the given JSON, YAML or JWS signed document.
+func (r *Registry) Load(raw []byte) error {
+	doc, signed, err := r.parse(raw)
+	if err != nil {
+		return err
+	}
+
+	err = r.store.Put(documentKey, raw)
+	if err != nil {
+		return fmt.Errorf("save trust registry document : %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.doc = doc
+	r.signed = signed
+
+	logger.Infof("trust registry loaded : id=%s version=%s policies=%d signed=%t",
+		doc.ID, doc.Version, len(doc.Issuers), signed)
+
+	return nil
+}
+
+// GovernanceKey returns the key the documents must be signed with, nil if unsigned documents are accepted.
+func (r *Registry) GovernanceKey() *jose.JSONWebKey {
+	return r.governanceKey
+}
+
+func (r *Registry) parse(raw []byte) (*Document, bool, error) {
+	doc, signed, err := parseDocument(raw, r.governanceKey)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(doc.Issuers) == 0 && !r.allowEmpty {
+		return nil, false, ErrEmptyDocument
+	}
+
+	return doc, signed, nil
+}
+
+// Document returns the current trust registry document and whether it was signed.
+func (r *Registry) Document() (*Document, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.doc == nil {
+		return &Document{Issuers: []IssuerPolicy{}}, false
+	}
+
+	return r.doc, r.signed
+}
+
+// CheckIssuer returns an UntrustedIssuerError if the issuer of the credential isn't accepted for the credential
+// types in the flow. All the issuers are accepted if the registry has no policies.
+func (r *Registry) CheckIssuer(flow string, cred *Credential) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.doc == nil || len(r.doc.Issuers) == 0 {
+		return nil
+	}
+
+	applicable := 0
+
+	for i := range r.doc.Issuers {
+		p := &r.doc.Issuers[i]
+
+		if !p.appliesTo(flow, cred.Types) {
+			continue
+		}
+
+		applicable++
+
+		if p.accepts(cred.Issuer) {
+			return nil
+		}
+	}
+
+	types := credentialTypes(cred.Types)
+
+	if applicable == 0 {
+		return &UntrustedIssuerError{
+			Issuer: cred.Issuer,
+			Reason: fmt.Sprintf("no accepted issuers are registered for credential type [%s] in flow '%s'", types, flow),
+		}
+	}
+
+	return &UntrustedIssuerError{
+		Issuer: cred.Issuer,
+		Reason: fmt.Sprintf("issuer is not accepted for credential type [%s] in flow '%s'", types, flow),
+	}
+}
+
+func (p *IssuerPolicy) appliesTo(flow string, types []string) bool {
+	if p.Flow != "" && p.Flow != flow {
+		return false
+	}
+
+	if p.CredentialType == "" {
+		return true
+	}
+
+	for _, t := range types {
+		if t == p.CredentialType {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *IssuerPolicy) accepts(issuer string) bool {
+	for _, did := range p.DIDs {
+		if did == issuer {
+			return true
+		}
+	}
+
+	domain := issuerDomain(issuer)
+	if domain == "" {
+		return false
+	}
+
+	for _, d := range p.Domains {
+		if strings.HasPrefix(d, "*.") && strings.HasSuffix(domain, d[1:]) {
+			return true
+		}
+
+		if strings.EqualFold(d, domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// issuerDomain returns the domain of a did:web or URL issuer.
+func issuerDomain(issuer string) string {
+	if strings.HasPrefix(issuer, didWebPrefix) {
+		host := strings.Split(strings.TrimPrefix(issuer, didWebPrefix), ":")[0]
+
+		host, err := url.PathUnescape(host)
+		if err != nil {
+			return ""
+		}
+
+		return strings.ToLower(strings.Split(host, ":")[0])
+	}
+
+	u, err := url.Parse(issuer)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+
+	return strings.ToLower(u.Hostname())
+}
+
+func credentialTypes(types []string) string {
+	filtered := make([]string, 0, len(types))
+
+	for _, t := range types {
+		if t != baseCredentialType {
+			filtered = append(filtered, t)
+		}
+	}
+
+	return strings.Join(filtered, ", ")
+}
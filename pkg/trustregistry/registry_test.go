@@ -0,0 +1,168 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package trustregistry
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	mockstore "github.com/hyperledger/aries-framework-go/component/storageutil/mock"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("loads saved document", func(t *testing.T) {
+		provider := mem.NewProvider()
+
+		registry, err := New(provider)
+		require.NoError(t, err)
+
+		doc, _ := registry.Document()
+		require.Empty(t, doc.Issuers)
+
+		require.NoError(t, registry.Load([]byte(jsonDocument)))
+
+		registry, err = New(provider)
+		require.NoError(t, err)
+
+		doc, signed := registry.Document()
+		require.False(t, signed)
+		require.Equal(t, "demo-registry", doc.ID)
+	})
+
+	t.Run("saved document is not signed", func(t *testing.T) {
+		provider := mem.NewProvider()
+
+		registry, err := New(provider)
+		require.NoError(t, err)
+		require.NoError(t, registry.Load([]byte(jsonDocument)))
+
+		pub, _ := newKey(t)
+
+		_, err = New(provider, WithGovernanceKey(pub))
+		require.ErrorIs(t, err, ErrUnsignedDocument)
+	})
+
+	t.Run("open store error", func(t *testing.T) {
+		_, err := New(&mockstore.Provider{ErrOpenStore: errors.New("open error")})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "open error")
+	})
+
+	t.Run("get document error", func(t *testing.T) {
+		_, err := New(&mockstore.Provider{OpenStoreReturn: &mockstore.Store{ErrGet: errors.New("get error")}})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "get error")
+	})
+}
+
+func TestRegistry_Load(t *testing.T) {
+	t.Run("signed document", func(t *testing.T) {
+		pub, priv := newKey(t)
+
+		registry, err := New(mem.NewProvider(), WithGovernanceKey(pub))
+		require.NoError(t, err)
+
+		require.NoError(t, registry.Load(sign(t, priv, jsonDocument)))
+
+		_, signed := registry.Document()
+		require.True(t, signed)
+	})
+
+	t.Run("invalid document", func(t *testing.T) {
+		registry, err := New(mem.NewProvider())
+		require.NoError(t, err)
+
+		require.Error(t, registry.Load([]byte("{")))
+	})
+
+	t.Run("empty document", func(t *testing.T) {
+		registry, err := New(mem.NewProvider())
+		require.NoError(t, err)
+
+		require.ErrorIs(t, registry.Load([]byte(`{"issuers": []}`)), ErrEmptyDocument)
+
+		registry, err = New(mem.NewProvider(), WithAllowEmpty())
+		require.NoError(t, err)
+
+		require.NoError(t, registry.Load([]byte(`{"issuers": []}`)))
+	})
+
+	t.Run("save error", func(t *testing.T) {
+		registry, err := New(&mockstore.Provider{OpenStoreReturn: &mockstore.Store{
+			ErrGet: storage.ErrDataNotFound, ErrPut: errors.New("put error"),
+		}})
+		require.NoError(t, err)
+
+		err = registry.Load([]byte(jsonDocument))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "put error")
+	})
+}
+
+func TestRegistry_CheckIssuer(t *testing.T) {
+	registry, err := New(mem.NewProvider())
+	require.NoError(t, err)
+
+	t.Run("empty registry accepts all issuers", func(t *testing.T) {
+		require.NoError(t, registry.CheckIssuer("flow1", &Credential{Issuer: "did:example:any"}))
+	})
+
+	require.NoError(t, registry.Load([]byte(jsonDocument)))
+
+	t.Run("trusted did", func(t *testing.T) {
+		require.NoError(t, registry.CheckIssuer("flow1", &Credential{
+			Types:  []string{"VerifiableCredential", "PermanentResidentCard"},
+			Issuer: "did:example:issuer1",
+		}))
+	})
+
+	t.Run("trusted domains", func(t *testing.T) {
+		types := []string{"VerifiableCredential", "UniversityDegreeCredential"}
+
+		require.NoError(t, registry.CheckIssuer("", &Credential{Types: types, Issuer: "did:web:example.com"}))
+		require.NoError(t, registry.CheckIssuer("", &Credential{Types: types, Issuer: "did:web:example.com%3A8080:user"}))
+		require.NoError(t, registry.CheckIssuer("", &Credential{Types: types, Issuer: "https://example.com/issuer"}))
+		require.NoError(t, registry.CheckIssuer("", &Credential{Types: types, Issuer: "did:web:cs.university.edu"}))
+	})
+
+	t.Run("untrusted issuer", func(t *testing.T) {
+		err := registry.CheckIssuer("flow1", &Credential{
+			Types:  []string{"VerifiableCredential", "PermanentResidentCard"},
+			Issuer: "did:example:issuer2",
+		})
+		require.ErrorIs(t, err, ErrUntrustedIssuer)
+
+		var untrustedErr *UntrustedIssuerError
+
+		require.True(t, errors.As(err, &untrustedErr))
+		require.Equal(t, "did:example:issuer2", untrustedErr.Issuer)
+		require.Equal(t, "issuer is not accepted for credential type [PermanentResidentCard] in flow 'flow1'",
+			untrustedErr.Reason)
+
+		err = registry.CheckIssuer("", &Credential{
+			Types:  []string{"UniversityDegreeCredential"},
+			Issuer: "did:web:university.edu.attacker.com",
+		})
+		require.ErrorIs(t, err, ErrUntrustedIssuer)
+
+		err = registry.CheckIssuer("", &Credential{Types: []string{"UniversityDegreeCredential"}, Issuer: "did:key:z6Mk"})
+		require.ErrorIs(t, err, ErrUntrustedIssuer)
+	})
+
+	t.Run("no policy for the flow", func(t *testing.T) {
+		err := registry.CheckIssuer("flow2", &Credential{
+			Types:  []string{"VerifiableCredential", "PermanentResidentCard"},
+			Issuer: "did:example:issuer1",
+		})
+		require.ErrorIs(t, err, ErrUntrustedIssuer)
+		require.Contains(t, err.Error(), "no accepted issuers are registered for credential type "+
+			"[PermanentResidentCard] in flow 'flow2'")
+	})
+}
@@ -0,0 +1,77 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package vdr
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	diddoc "github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	vdrapi "github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+const (
+	defaultRetries      = 8
+	defaultInitialDelay = 250 * time.Millisecond
+	defaultMaxDelay     = 5 * time.Second
+)
+
+var logger = log.New("sandbox-vdr")
+
+// Backoff configures the retries of the resolution of a DID which isn't published yet. The delay doubles after
+// each retry, up to MaxDelay.
+type Backoff struct {
+	Retries      int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+}
+
+// DefaultBackoff returns the backoff used when the retries aren't configured.
+func DefaultBackoff() Backoff {
+	return Backoff{Retries: defaultRetries, InitialDelay: defaultInitialDelay, MaxDelay: defaultMaxDelay}
+}
+
+// ResolveWithBackoff resolves the DID, and retries while the DID isn't found. Other errors are returned
+// immediately. The retries stop when the context is done.
+func ResolveWithBackoff(ctx context.Context, registry vdrapi.Registry, did string,
+	backoff Backoff) (*diddoc.DocResolution, error) {
+	delay := backoff.InitialDelay
+
+	for retry := 0; ; retry++ {
+		doc, err := registry.Resolve(did)
+		if err == nil {
+			return doc, nil
+		}
+
+		if !IsNotFound(err) {
+			return nil, err
+		}
+
+		if retry >= backoff.Retries {
+			return nil, fmt.Errorf("did %s not found after %d retries : %w", did, retry, err)
+		}
+
+		logger.Debugf("did %s not found, retry %d of %d in %s", did, retry+1, backoff.Retries, delay)
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return nil, fmt.Errorf("resolve did %s : %w", did, ctx.Err())
+		case <-timer.C:
+		}
+
+		delay *= 2
+		if delay > backoff.MaxDelay {
+			delay = backoff.MaxDelay
+		}
+	}
+}
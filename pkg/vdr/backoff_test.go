@@ -0,0 +1,74 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package vdr
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	diddoc "github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	vdrapi "github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveWithBackoff(t *testing.T) {
+	backoff := Backoff{Retries: 3, InitialDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+
+	t.Run("resolved after retries", func(t *testing.T) {
+		m := &notFoundRegistry{notFound: 2}
+
+		doc, err := ResolveWithBackoff(context.Background(), m, "did:example:1", backoff)
+		require.NoError(t, err)
+		require.Equal(t, "did:example:1", doc.DIDDocument.ID)
+		require.Equal(t, 3, m.calls)
+	})
+
+	t.Run("not found after the retries", func(t *testing.T) {
+		m := &notFoundRegistry{notFound: 10}
+
+		_, err := ResolveWithBackoff(context.Background(), m, "did:example:1", backoff)
+		require.True(t, IsNotFound(err))
+		require.Contains(t, err.Error(), "did did:example:1 not found after 3 retries")
+		require.Equal(t, 4, m.calls)
+	})
+
+	t.Run("other errors aren't retried", func(t *testing.T) {
+		m := &mockRegistry{err: errors.New("resolver error")}
+
+		_, err := ResolveWithBackoff(context.Background(), m, "did:example:1", backoff)
+		require.EqualError(t, err, "resolver error")
+		require.Equal(t, 1, m.calls)
+	})
+
+	t.Run("context done", func(t *testing.T) {
+		m := &notFoundRegistry{notFound: 10}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := ResolveWithBackoff(ctx, m, "did:example:1", DefaultBackoff())
+		require.True(t, errors.Is(err, context.Canceled))
+		require.Equal(t, 1, m.calls)
+	})
+}
+
+type notFoundRegistry struct {
+	mockRegistry
+	notFound int
+}
+
+func (m *notFoundRegistry) Resolve(did string, opts ...vdrapi.DIDMethodOption) (*diddoc.DocResolution, error) {
+	if m.calls < m.notFound {
+		m.calls++
+
+		return nil, vdrapi.ErrNotFound
+	}
+
+	return m.mockRegistry.Resolve(did, opts...)
+}
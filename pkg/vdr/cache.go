@@ -0,0 +1,170 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package vdr
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	diddoc "github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	vdrapi "github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+)
+
+const (
+	defaultCacheSize = 1000
+	defaultCacheTTL  = 5 * time.Minute
+)
+
+// CacheOption configures the caching registry
+type CacheOption func(opts *CachingRegistry)
+
+// WithCacheSize option sets the maximum number of cached DID documents, the least recently used document is
+// evicted first
+func WithCacheSize(size int) CacheOption {
+	return func(opts *CachingRegistry) {
+		opts.size = size
+	}
+}
+
+// WithCacheTTL option sets the time a resolved DID document is cached
+func WithCacheTTL(ttl time.Duration) CacheOption {
+	return func(opts *CachingRegistry) {
+		opts.ttl = ttl
+	}
+}
+
+// CachingRegistry resolves DIDs with another registry and caches the resolved documents. DIDs which are not found
+// aren't cached, so that a DID can be resolved as soon as it's published.
+type CachingRegistry struct {
+	vdrapi.Registry
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type cacheEntry struct {
+	did       string
+	doc       *diddoc.DocResolution
+	expiresAt time.Time
+}
+
+// NewCachingRegistry creates new caching registry
+func NewCachingRegistry(registry vdrapi.Registry, opts ...CacheOption) *CachingRegistry {
+	r := &CachingRegistry{
+		Registry: registry,
+		size:     defaultCacheSize,
+		ttl:      defaultCacheTTL,
+		now:      time.Now,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// Resolve returns the cached DID document, or resolves and caches it. The cache is bypassed if method options are
+// passed, as they can change the resolution.
+func (r *CachingRegistry) Resolve(did string, opts ...vdrapi.DIDMethodOption) (*diddoc.DocResolution, error) {
+	if len(opts) > 0 {
+		return r.Registry.Resolve(did, opts...)
+	}
+
+	now := r.now()
+
+	doc, ok := r.get(did, now)
+	if ok {
+		return doc, nil
+	}
+
+	doc, err := r.Registry.Resolve(did)
+	if err != nil {
+		return nil, err
+	}
+
+	r.add(did, doc, now)
+
+	return doc, nil
+}
+
+// Update updates the DID document and removes it from the cache.
+func (r *CachingRegistry) Update(doc *diddoc.Doc, opts ...vdrapi.DIDMethodOption) error {
+	defer r.Invalidate(doc.ID)
+
+	return r.Registry.Update(doc, opts...)
+}
+
+// Deactivate deactivates the DID and removes its document from the cache.
+func (r *CachingRegistry) Deactivate(did string, opts ...vdrapi.DIDMethodOption) error {
+	defer r.Invalidate(did)
+
+	return r.Registry.Deactivate(did, opts...)
+}
+
+// Invalidate removes the DID document from the cache.
+func (r *CachingRegistry) Invalidate(did string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e, ok := r.entries[did]; ok {
+		r.lru.Remove(e)
+		delete(r.entries, did)
+	}
+}
+
+func (r *CachingRegistry) get(did string, now time.Time) (*diddoc.DocResolution, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.entries[did]
+	if !ok {
+		return nil, false
+	}
+
+	entry := e.Value.(*cacheEntry) // nolint: errcheck, forcetypeassert
+
+	if !now.Before(entry.expiresAt) {
+		r.lru.Remove(e)
+		delete(r.entries, did)
+
+		return nil, false
+	}
+
+	r.lru.MoveToFront(e)
+
+	return entry.doc, true
+}
+
+func (r *CachingRegistry) add(did string, doc *diddoc.DocResolution, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.size <= 0 {
+		return
+	}
+
+	if e, ok := r.entries[did]; ok {
+		r.lru.Remove(e)
+	}
+
+	r.entries[did] = r.lru.PushFront(&cacheEntry{did: did, doc: doc, expiresAt: now.Add(r.ttl)})
+
+	for r.lru.Len() > r.size {
+		oldest := r.lru.Back()
+
+		r.lru.Remove(oldest)
+		delete(r.entries, oldest.Value.(*cacheEntry).did) // nolint: errcheck, forcetypeassert
+	}
+}
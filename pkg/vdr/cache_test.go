@@ -0,0 +1,142 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package vdr
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	diddoc "github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	vdrapi "github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+	vdrmock "github.com/hyperledger/aries-framework-go/pkg/mock/vdr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachingRegistry_Resolve(t *testing.T) {
+	now := time.Unix(1000, 0)
+
+	t.Run("caches until the ttl", func(t *testing.T) {
+		m := &mockRegistry{}
+
+		r := NewCachingRegistry(m, WithCacheTTL(time.Minute))
+		r.now = func() time.Time { return now }
+
+		doc, err := r.Resolve("did:example:1")
+		require.NoError(t, err)
+		require.Equal(t, "did:example:1", doc.DIDDocument.ID)
+
+		_, err = r.Resolve("did:example:1")
+		require.NoError(t, err)
+		require.Equal(t, 1, m.calls)
+
+		r.now = func() time.Time { return now.Add(time.Minute) }
+
+		_, err = r.Resolve("did:example:1")
+		require.NoError(t, err)
+		require.Equal(t, 2, m.calls)
+	})
+
+	t.Run("doesn't cache the dids which aren't found", func(t *testing.T) {
+		m := &mockRegistry{err: vdrapi.ErrNotFound}
+
+		r := NewCachingRegistry(m)
+
+		_, err := r.Resolve("did:example:1")
+		require.True(t, IsNotFound(err))
+
+		m.err = nil
+
+		_, err = r.Resolve("did:example:1")
+		require.NoError(t, err)
+		require.Equal(t, 2, m.calls)
+	})
+
+	t.Run("bypasses the cache with options", func(t *testing.T) {
+		m := &mockRegistry{}
+
+		r := NewCachingRegistry(m)
+
+		for i := 0; i < 2; i++ {
+			_, err := r.Resolve("did:example:1", vdrapi.WithOption("k", "v"))
+			require.NoError(t, err)
+		}
+
+		require.Equal(t, 2, m.calls)
+		require.Equal(t, 0, r.lru.Len())
+	})
+
+	t.Run("evicts the least recently used document", func(t *testing.T) {
+		m := &mockRegistry{}
+
+		r := NewCachingRegistry(m, WithCacheSize(2))
+
+		for _, did := range []string{"did:example:1", "did:example:2", "did:example:1", "did:example:3", "did:example:1"} {
+			_, err := r.Resolve(did)
+			require.NoError(t, err)
+		}
+
+		require.Equal(t, 3, m.calls)
+		require.Equal(t, 2, r.lru.Len())
+
+		_, err := r.Resolve("did:example:2")
+		require.NoError(t, err)
+		require.Equal(t, 4, m.calls)
+	})
+
+	t.Run("cache disabled", func(t *testing.T) {
+		m := &mockRegistry{}
+
+		r := NewCachingRegistry(m, WithCacheSize(0))
+
+		for i := 0; i < 2; i++ {
+			_, err := r.Resolve("did:example:1")
+			require.NoError(t, err)
+		}
+
+		require.Equal(t, 2, m.calls)
+	})
+}
+
+func TestCachingRegistry_Invalidate(t *testing.T) {
+	m := &mockRegistry{}
+	m.UpdateFunc = func(*diddoc.Doc, ...vdrapi.DIDMethodOption) error { return errors.New("update error") }
+	m.DeactivateFunc = func(string, ...vdrapi.DIDMethodOption) error { return nil }
+
+	r := NewCachingRegistry(m)
+
+	_, err := r.Resolve("did:example:1")
+	require.NoError(t, err)
+
+	require.EqualError(t, r.Update(&diddoc.Doc{ID: "did:example:1"}), "update error")
+
+	_, err = r.Resolve("did:example:1")
+	require.NoError(t, err)
+	require.Equal(t, 2, m.calls)
+
+	require.NoError(t, r.Deactivate("did:example:1"))
+
+	_, err = r.Resolve("did:example:1")
+	require.NoError(t, err)
+	require.Equal(t, 3, m.calls)
+}
+
+type mockRegistry struct {
+	vdrmock.MockVDRegistry
+	err   error
+	calls int
+}
+
+func (m *mockRegistry) Resolve(did string, _ ...vdrapi.DIDMethodOption) (*diddoc.DocResolution, error) {
+	m.calls++
+
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	return &diddoc.DocResolution{DIDDocument: &diddoc.Doc{ID: did}}, nil
+}
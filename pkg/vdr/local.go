@@ -0,0 +1,46 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package vdr
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	diddoc "github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	vdrapi "github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+	"github.com/hyperledger/aries-framework-go/pkg/vdr/key"
+	"github.com/hyperledger/aries-framework-go/pkg/vdr/web"
+)
+
+// webNotFound is the error of the did:web VDR when the web server responds with a 404.
+var webNotFound = fmt.Sprintf("status code [%d]", http.StatusNotFound) // nolint: gochecknoglobals
+
+// LocalVDRs returns the VDRs which resolve the DIDs without a universal resolver. did:key DIDs are resolved offline,
+// did:web DIDs are fetched from their web server with the http client.
+func LocalVDRs(httpClient *http.Client) []vdrapi.VDR {
+	return []vdrapi.VDR{key.New(), &webVDR{VDR: web.New(), httpClient: httpClient}}
+}
+
+type webVDR struct {
+	*web.VDR
+	httpClient *http.Client
+}
+
+// Read resolves the did:web DID.
+func (v *webVDR) Read(did string, opts ...vdrapi.DIDMethodOption) (*diddoc.DocResolution, error) {
+	if v.httpClient != nil {
+		opts = append([]vdrapi.DIDMethodOption{vdrapi.WithOption(web.HTTPClientOpt, v.httpClient)}, opts...)
+	}
+
+	doc, err := v.VDR.Read(did, opts...)
+	if err != nil && strings.Contains(err.Error(), webNotFound) {
+		return nil, fmt.Errorf("%w: %s", vdrapi.ErrNotFound, err.Error())
+	}
+
+	return doc, err
+}
@@ -0,0 +1,73 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package vdr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	vdrpkg "github.com/hyperledger/aries-framework-go/pkg/vdr"
+	"github.com/stretchr/testify/require"
+)
+
+const didKey = "did:key:z6MkpTHR8VNsBxYAAWHut2Geadd9jSwuBV8xRoAnwWsdvktH"
+
+func TestLocalVDRs(t *testing.T) {
+	t.Run("did:key", func(t *testing.T) {
+		registry := newLocalRegistry(nil)
+
+		doc, err := registry.Resolve(didKey)
+		require.NoError(t, err)
+		require.Equal(t, didKey, doc.DIDDocument.ID)
+	})
+
+	t.Run("did:web", func(t *testing.T) {
+		var didWeb string
+
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/.well-known/did.json" {
+				http.NotFound(w, r)
+
+				return
+			}
+
+			_, err := w.Write([]byte(`{"@context":["https://www.w3.org/ns/did/v1"],"id":"` + didWeb + `"}`))
+			require.NoError(t, err)
+		}))
+		defer srv.Close()
+
+		didWeb = "did:web:" + url.QueryEscape(strings.TrimPrefix(srv.URL, "https://"))
+
+		registry := newLocalRegistry(srv.Client())
+
+		doc, err := registry.Resolve(didWeb)
+		require.NoError(t, err)
+		require.Equal(t, didWeb, doc.DIDDocument.ID)
+
+		_, err = registry.Resolve(didWeb + ":user:alice")
+		require.True(t, IsNotFound(err))
+	})
+
+	t.Run("other methods", func(t *testing.T) {
+		_, err := newLocalRegistry(nil).Resolve("did:example:1")
+		require.Error(t, err)
+		require.False(t, IsNotFound(err))
+	})
+}
+
+func newLocalRegistry(httpClient *http.Client) *vdrpkg.Registry {
+	var opts []vdrpkg.Option
+
+	for _, v := range LocalVDRs(httpClient) {
+		opts = append(opts, vdrpkg.WithVDR(v))
+	}
+
+	return vdrpkg.New(opts...)
+}
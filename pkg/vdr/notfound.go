@@ -0,0 +1,44 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package vdr
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	diddoc "github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	vdrapi "github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+)
+
+// httpBindingNotFound is the error of the http binding VDR when the resolver responds with a 404.
+const httpBindingNotFound = "DID does not exist"
+
+// IsNotFound returns true if the DID of the resolution isn't found.
+func IsNotFound(err error) bool {
+	return errors.Is(err, vdrapi.ErrNotFound)
+}
+
+type notFoundVDR struct {
+	vdrapi.VDR
+}
+
+// WithTypedNotFound wraps the http binding VDR, to return the vdr ErrNotFound error when the resolver doesn't find
+// the DID.
+func WithTypedNotFound(v vdrapi.VDR) vdrapi.VDR {
+	return &notFoundVDR{VDR: v}
+}
+
+// Read resolves the DID.
+func (v *notFoundVDR) Read(did string, opts ...vdrapi.DIDMethodOption) (*diddoc.DocResolution, error) {
+	doc, err := v.VDR.Read(did, opts...)
+	if err != nil && !IsNotFound(err) && strings.Contains(err.Error(), httpBindingNotFound) {
+		return nil, fmt.Errorf("%w: %s", vdrapi.ErrNotFound, err.Error())
+	}
+
+	return doc, err
+}
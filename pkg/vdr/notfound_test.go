@@ -0,0 +1,59 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package vdr
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	diddoc "github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	vdrapi "github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+	vdrmock "github.com/hyperledger/aries-framework-go/pkg/mock/vdr"
+	"github.com/hyperledger/aries-framework-go/pkg/vdr/httpbinding"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithTypedNotFound(t *testing.T) {
+	t.Run("http binding not found", func(t *testing.T) {
+		srv := httptest.NewServer(http.NotFoundHandler())
+		defer srv.Close()
+
+		httpVDR, err := httpbinding.New(srv.URL)
+		require.NoError(t, err)
+
+		_, err = WithTypedNotFound(httpVDR).Read("did:example:1")
+		require.True(t, IsNotFound(err))
+		require.Contains(t, err.Error(), "DID does not exist")
+	})
+
+	t.Run("other errors", func(t *testing.T) {
+		v := WithTypedNotFound(&vdrmock.MockVDR{
+			ReadFunc: func(string, ...vdrapi.DIDMethodOption) (*diddoc.DocResolution, error) {
+				return nil, errors.New("read error")
+			},
+		})
+
+		_, err := v.Read("did:example:1")
+		require.EqualError(t, err, "read error")
+		require.False(t, IsNotFound(err))
+	})
+
+	t.Run("typed errors", func(t *testing.T) {
+		v := WithTypedNotFound(&vdrmock.MockVDR{
+			ReadFunc: func(string, ...vdrapi.DIDMethodOption) (*diddoc.DocResolution, error) {
+				return nil, fmt.Errorf("wrapped : %w", vdrapi.ErrNotFound)
+			},
+		})
+
+		_, err := v.Read("did:example:1")
+		require.EqualError(t, err, "wrapped : DID not found")
+		require.True(t, IsNotFound(err))
+	})
+}